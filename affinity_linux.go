@@ -0,0 +1,65 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"runtime"
+)
+
+// CPUSet is alias of unix.CPUSet
+type CPUSet = unix.CPUSet
+
+var processCPUSet = func() (set CPUSet) {
+	err := unix.SchedGetaffinity(0, &set)
+	if err != nil {
+		for i := range runtime.NumCPU() {
+			set.Set(i)
+		}
+	}
+	return
+}()
+
+// NewCPUSet creates and returns a CPUSet contains the given cpus
+func NewCPUSet(cpus ...int) (set CPUSet) {
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return
+}
+
+// LockOSThreadAffinity wires the calling goroutine to its current OS thread
+// and pins the thread to the given cpus. It is typically called at the start
+// of a polling or worker goroutine so that rings, workers and NIC IRQs can be
+// kept on the same cores
+func LockOSThreadAffinity(cpus ...int) error {
+	if len(cpus) < 1 {
+		return ErrInvalidParam
+	}
+	set := NewCPUSet(cpus...)
+	runtime.LockOSThread()
+	err := unix.SchedSetaffinity(0, &set)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// UnlockOSThreadAffinity restores the CPU affinity of the current OS thread
+// to the process default and unwires the calling goroutine from the thread
+func UnlockOSThreadAffinity() error {
+	defer runtime.UnlockOSThread()
+	set := processCPUSet
+	err := unix.SchedSetaffinity(0, &set)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"testing"
+)
+
+func TestLockOSThreadAffinity(t *testing.T) {
+	t.Run("pin and unpin", func(t *testing.T) {
+		origin := sox.CPUSet{}
+		err := unix.SchedGetaffinity(0, &origin)
+		if err != nil {
+			t.Errorf("sched getaffinity: %v", err)
+			return
+		}
+		err = sox.LockOSThreadAffinity(0)
+		if err != nil {
+			t.Errorf("lock os thread affinity: %v", err)
+			return
+		}
+		set := sox.CPUSet{}
+		err = unix.SchedGetaffinity(0, &set)
+		if err != nil {
+			t.Errorf("sched getaffinity: %v", err)
+			return
+		}
+		if set.Count() != 1 || !set.IsSet(0) {
+			t.Errorf("expected affinity cpu 0 only but got %d cpus", set.Count())
+			return
+		}
+		err = sox.UnlockOSThreadAffinity()
+		if err != nil {
+			t.Errorf("unlock os thread affinity: %v", err)
+			return
+		}
+		err = unix.SchedGetaffinity(0, &set)
+		if err != nil {
+			t.Errorf("sched getaffinity: %v", err)
+			return
+		}
+		if set != origin {
+			t.Errorf("expected affinity restored to %d cpus but got %d cpus", origin.Count(), set.Count())
+			return
+		}
+	})
+
+	t.Run("empty cpus", func(t *testing.T) {
+		err := sox.LockOSThreadAffinity()
+		if err != sox.ErrInvalidParam {
+			t.Errorf("lock os thread affinity expected invalid param but got %v", err)
+			return
+		}
+	})
+}
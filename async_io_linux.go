@@ -0,0 +1,447 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// AsyncIOOptions represents the options of AsyncIO
+type AsyncIOOptions struct {
+	// Entries is the number of the submission queue entries of the ring
+	Entries int
+}
+
+var defaultAsyncIOOptions = AsyncIOOptions{
+	Entries: 256,
+}
+
+const (
+	// asyncIOIgnored is the userData of the submissions whose
+	// completions are not delivered to any AsyncResult
+	asyncIOIgnored uint64 = 0
+	// asyncIOWakeup is the userData of the submission which wakes
+	// up the completion goroutine on Close
+	asyncIOWakeup uint64 = ^uint64(0)
+)
+
+// AsyncIO submits operations to an io_uring instance and delivers their
+// completions through AsyncResult futures. A goroutine owned by the AsyncIO
+// reaps the completions, so that the methods can be called from any goroutine
+// The buffers and the addresses passed to the methods must not be touched
+// until the operations have completed
+type AsyncIO struct {
+	ur       *ioUring
+	mu       sync.Mutex
+	next     uint64
+	pending  map[uint64]*AsyncResult
+	closed   bool
+	loopDone chan struct{}
+}
+
+// NewAsyncIO creates an AsyncIO with its own ring
+func NewAsyncIO(opts ...func(options *AsyncIOOptions)) (*AsyncIO, error) {
+	opt := defaultAsyncIOOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	ur, err := newIoUring(opt.Entries)
+	if err != nil {
+		return nil, err
+	}
+	a := &AsyncIO{
+		ur:       ur,
+		pending:  make(map[uint64]*AsyncResult),
+		loopDone: make(chan struct{}),
+	}
+	goLabeled(ProfileLabelCompletion, a.loop)
+
+	return a, nil
+}
+
+// ReadAsync submits a read into p from fd. For files the read starts from
+// the current file position. The result is the number of bytes read, and a
+// result of zero with a nil error means the end of the file or the stream
+func (a *AsyncIO) ReadAsync(ctx context.Context, fd int, p []byte) (*AsyncResult, error) {
+	if len(p) < 1 {
+		return nil, ErrInvalidParam
+	}
+	sqe := ioUringSqe{
+		opcode: IORING_OP_READ,
+		fd:     int32(fd),
+		off:    ioUringCurrentOffset,
+		addr:   uint64(uintptr(unsafe.Pointer(unsafe.SliceData(p)))),
+		len:    uint32(len(p)),
+	}
+	return a.submit(ctx, &sqe, p)
+}
+
+// WriteAsync submits a write of p to fd. For files the write starts from
+// the current file position. The result is the number of bytes written
+func (a *AsyncIO) WriteAsync(ctx context.Context, fd int, p []byte) (*AsyncResult, error) {
+	if len(p) < 1 {
+		return nil, ErrInvalidParam
+	}
+	sqe := ioUringSqe{
+		opcode: IORING_OP_WRITE,
+		fd:     int32(fd),
+		off:    ioUringCurrentOffset,
+		addr:   uint64(uintptr(unsafe.Pointer(unsafe.SliceData(p)))),
+		len:    uint32(len(p)),
+	}
+	return a.submit(ctx, &sqe, p)
+}
+
+// AcceptAsync submits an accepting of a connection on the listening fd
+// The result is the non-blocking fd of the accepted connection
+func (a *AsyncIO) AcceptAsync(ctx context.Context, fd int) (*AsyncResult, error) {
+	sqe := ioUringSqe{
+		opcode: IORING_OP_ACCEPT,
+		fd:     int32(fd),
+		uflags: unix.SOCK_NONBLOCK | unix.SOCK_CLOEXEC,
+	}
+	return a.submit(ctx, &sqe, nil)
+}
+
+// ConnectAsync submits a connecting of the socket fd to raddr
+// The result is zero after the connection has been established
+// If the ctx has a deadline, the connecting is cancelled by the kernel
+// at the deadline with a linked timeout, and the result has the error
+// context.DeadlineExceeded
+func (a *AsyncIO) ConnectAsync(ctx context.Context, fd int, raddr Addr) (*AsyncResult, error) {
+	if raddr == nil {
+		return nil, ErrInvalidParam
+	}
+	ptr, n, err := sockaddr(AddrToSockaddr(raddr))
+	if err != nil {
+		return nil, err
+	}
+	sqe := ioUringSqe{
+		opcode: IORING_OP_CONNECT,
+		fd:     int32(fd),
+		off:    uint64(n),
+		addr:   uint64(uintptr(ptr)),
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return a.submit(ctx, &sqe, ptr)
+	}
+	ts := &ioUringTimespec{}
+	ts.set(time.Until(deadline))
+	sqe.flags |= IOSQE_IO_LINK
+	sqes := [2]ioUringSqe{sqe, {
+		opcode: IORING_OP_LINK_TIMEOUT,
+		fd:     -1,
+		addr:   uint64(uintptr(unsafe.Pointer(ts))),
+		len:    1,
+	}}
+	return a.submitLinked(ctx, sqes[:], &AsyncResult{keep: [2]any{ptr, ts}, deadline: true})
+}
+
+// DialTCP connects to raddr on the network "tcp4" or "tcp6" with an
+// io_uring connect, instead of spinning until the connection has been
+// established as DialTCP4 and DialTCP6 do. A nil laddr means any address
+func (a *AsyncIO) DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPConn, error) {
+	if raddr == nil {
+		return nil, &OpError{Op: "dial", Net: network, Source: laddr, Addr: nil, Err: errors.New("missing address")}
+	}
+	var lsa unix.Sockaddr
+	switch network {
+	case "tcp4":
+		if laddr == nil {
+			laddr = &TCPAddr{IP: IPV4zero}
+		}
+		lsa = tcp4AddrToSockaddr(laddr)
+	case "tcp6":
+		if laddr == nil {
+			laddr = &TCPAddr{IP: IPV6unspecified}
+		}
+		lsa = tcp6AddrToSockaddr(laddr)
+	default:
+		return nil, UnknownNetworkError(network)
+	}
+	if err := zoneError(laddr, raddr); err != nil {
+		return nil, opError("dial", network, laddr, raddr, err)
+	}
+	so, err := newTCPSocket(lsa)
+	if err != nil {
+		return nil, opError("dial", network, laddr, raddr, err)
+	}
+	err = a.connect(ctx, so.fd, raddr)
+	if err != nil {
+		_ = so.Close()
+		return nil, &OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: err}
+	}
+
+	return &TCPConn{TCPSocket: so, laddr: laddr, raddr: raddr}, nil
+}
+
+// DialSCTP connects to raddr on the network "sctp4" or "sctp6" with an
+// io_uring connect, instead of spinning until the association has been
+// established as DialSCTP4 and DialSCTP6 do. A nil laddr means the loopback
+func (a *AsyncIO) DialSCTP(ctx context.Context, network string, laddr, raddr *SCTPAddr) (*SCTPConn, error) {
+	if raddr == nil {
+		return nil, &OpError{Op: "dial", Net: network, Source: laddr, Addr: nil, Err: errors.New("missing address")}
+	}
+	var lsa unix.Sockaddr
+	switch network {
+	case "sctp4":
+		if laddr == nil {
+			laddr = &SCTPAddr{IP: IPv4LoopBack}
+		}
+		lsa = sctp4AddrToSockaddr(laddr)
+	case "sctp6":
+		if laddr == nil {
+			laddr = &SCTPAddr{IP: IPv6LoopBack}
+		}
+		lsa = sctp6AddrToSockaddr(laddr)
+	default:
+		return nil, UnknownNetworkError(network)
+	}
+	if err := zoneError(laddr, raddr); err != nil {
+		return nil, opError("dial", network, laddr, raddr, err)
+	}
+	so, err := newSCTPSocket(lsa)
+	if err != nil {
+		return nil, opError("dial", network, laddr, raddr, err)
+	}
+	err = sctpBindx(so, lsa)
+	if err == nil {
+		err = a.connect(ctx, so.fd, raddr)
+	}
+	if err != nil {
+		_ = so.Close()
+		return nil, &OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: err}
+	}
+
+	return &SCTPConn{SCTPSocket: so, laddr: laddr, raddr: raddr}, nil
+}
+
+// connect connects fd to raddr and waits for the completion
+// The connecting is cancelled when the ctx is done
+func (a *AsyncIO) connect(ctx context.Context, fd int, raddr Addr) error {
+	r, err := a.ConnectAsync(ctx, fd, raddr)
+	if err != nil {
+		return err
+	}
+	<-r.Done()
+	_, err = r.Result()
+	return err
+}
+
+// Close cancels the in-flight operations, waits for their completions
+// and closes the ring. The results of the cancelled operations have the
+// error io.ErrClosedPipe
+func (a *AsyncIO) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	a.closed = true
+	cancel := ioUringSqe{
+		opcode: IORING_OP_ASYNC_CANCEL,
+		fd:     -1,
+		uflags: IORING_ASYNC_CANCEL_ALL | IORING_ASYNC_CANCEL_ANY,
+	}
+	wakeup := ioUringSqe{opcode: IORING_OP_NOP}
+	err := a.ur.submitSqeUserData(&cancel, asyncIOIgnored)
+	if err == nil {
+		err = a.ur.submitSqeUserData(&wakeup, asyncIOWakeup)
+	}
+	if err == nil {
+		err = a.ur.enter()
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	<-a.loopDone
+
+	return a.ur.Close()
+}
+
+// submit submits the sqe with a new AsyncResult. The keep is
+// referred to by the AsyncResult until the operation has completed
+func (a *AsyncIO) submit(ctx context.Context, sqe *ioUringSqe, keep any) (*AsyncResult, error) {
+	return a.submitLinked(ctx, unsafe.Slice(sqe, 1), &AsyncResult{keep: keep})
+}
+
+// submitLinked submits the sqes with the AsyncResult r, which is completed
+// by the first sqe. The completions of the rest of the sqes are ignored
+func (a *AsyncIO) submitLinked(ctx context.Context, sqes []ioUringSqe, r *AsyncResult) (*AsyncResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil, io.ErrClosedPipe
+	}
+	a.next++
+	if a.next == asyncIOWakeup {
+		a.next = asyncIOIgnored + 1
+	}
+	token := a.next
+	r.ctx, r.done = ctx, make(chan struct{})
+	userData := make([]uint64, len(sqes))
+	userData[0] = token
+	err := a.ur.submitSqesUserData(sqes, userData)
+	if err != nil {
+		return nil, err
+	}
+	err = a.ur.enter()
+	if err != nil {
+		return nil, err
+	}
+	a.pending[token] = r
+	r.stop = context.AfterFunc(ctx, func() {
+		a.cancel(token)
+	})
+
+	return r, nil
+}
+
+// cancel submits a cancellation of the operation identified by token
+func (a *AsyncIO) cancel(token uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.pending[token]; !ok || a.closed {
+		return
+	}
+	sqe := ioUringSqe{opcode: IORING_OP_ASYNC_CANCEL, addr: token}
+	if a.ur.submitSqeUserData(&sqe, asyncIOIgnored) == nil {
+		_ = a.ur.enter()
+	}
+}
+
+func (a *AsyncIO) loop() {
+	defer close(a.loopDone)
+	closing := false
+	for {
+		cqe, err := a.ur.wait()
+		if err == ErrTemporarilyUnavailable {
+			_, err = ioUringEnter(a.ur.ringFd, 0, 1, IORING_ENTER_GETEVENTS)
+			if err != nil && err != ErrInterruptedSyscall {
+				return
+			}
+			continue
+		}
+		userData, res := cqe.userData, cqe.res
+		if userData == asyncIOWakeup {
+			closing = true
+		} else if userData != asyncIOIgnored {
+			a.mu.Lock()
+			r, ok := a.pending[userData]
+			delete(a.pending, userData)
+			a.mu.Unlock()
+			if ok {
+				r.complete(res, closing)
+			}
+		}
+		if closing {
+			a.mu.Lock()
+			n := len(a.pending)
+			a.mu.Unlock()
+			if n == 0 {
+				return
+			}
+		}
+	}
+}
+
+// AsyncResult is the future of an operation submitted to AsyncIO
+type AsyncResult struct {
+	ctx      context.Context
+	keep     any
+	stop     func() bool
+	deadline bool
+	done     chan struct{}
+	mu       sync.Mutex
+	fns      []func(res int, err error)
+	res      int
+	err      error
+}
+
+// Done returns a channel which is closed when the operation has completed
+func (r *AsyncResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Result returns the result of the completed operation. If the operation
+// has not completed, Result returns ErrTemporarilyUnavailable
+func (r *AsyncResult) Result() (res int, err error) {
+	select {
+	case <-r.done:
+		return r.res, r.err
+	default:
+		return 0, ErrTemporarilyUnavailable
+	}
+}
+
+// Wait waits for the completion of the operation until the ctx is done
+// The operation is not cancelled when the ctx of Wait is done
+func (r *AsyncResult) Wait(ctx context.Context) (res int, err error) {
+	select {
+	case <-r.done:
+		return r.res, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Then registers fn to be invoked with the result when the operation has
+// completed. The fn is invoked on the completion goroutine of the AsyncIO,
+// or immediately if the operation has already completed
+func (r *AsyncResult) Then(fn func(res int, err error)) {
+	r.mu.Lock()
+	select {
+	case <-r.done:
+		r.mu.Unlock()
+		fn(r.res, r.err)
+		return
+	default:
+	}
+	r.fns = append(r.fns, fn)
+	r.mu.Unlock()
+}
+
+func (r *AsyncResult) complete(res int32, closing bool) {
+	if r.stop != nil {
+		r.stop()
+	}
+	if res < 0 {
+		r.err = errFromUnixErrno(unix.Errno(-res))
+		if unix.Errno(-res) == unix.ECANCELED {
+			if closing {
+				r.err = io.ErrClosedPipe
+			} else if err := r.ctx.Err(); err != nil {
+				r.err = err
+			} else if r.deadline {
+				// the linked timeout may expire slightly before the ctx
+				r.err = context.DeadlineExceeded
+			}
+		}
+	} else {
+		r.res = int(res)
+	}
+	r.keep = nil
+	r.mu.Lock()
+	close(r.done)
+	fns := r.fns
+	r.fns = nil
+	r.mu.Unlock()
+	for _, fn := range fns {
+		fn(r.res, r.err)
+	}
+}
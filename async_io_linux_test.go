@@ -0,0 +1,222 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAsyncIO_ReadWrite(t *testing.T) {
+	aio, err := sox.NewAsyncIO()
+	if err != nil {
+		t.Errorf("new async io: %v", err)
+		return
+	}
+	defer aio.Close()
+	fds := [2]int{}
+	err = unix.Pipe2(fds[:], unix.O_CLOEXEC)
+	if err != nil {
+		t.Errorf("pipe: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p := make([]byte, 16)
+	r, err := aio.ReadAsync(ctx, fds[0], p)
+	if err != nil {
+		t.Errorf("read async: %v", err)
+		return
+	}
+	called := make(chan int, 1)
+	r.Then(func(res int, err error) {
+		called <- res
+	})
+	w, err := aio.WriteAsync(ctx, fds[1], []byte("async"))
+	if err != nil {
+		t.Errorf("write async: %v", err)
+		return
+	}
+	n, err := w.Wait(ctx)
+	if err != nil || n != 5 {
+		t.Errorf("write expected 5 bytes but got %d: %v", n, err)
+		return
+	}
+	n, err = r.Wait(ctx)
+	if err != nil || string(p[:n]) != "async" {
+		t.Errorf("read expected %q but got %q: %v", "async", p[:n], err)
+		return
+	}
+	if res := <-called; res != 5 {
+		t.Errorf("callback expected 5 but got %d", res)
+		return
+	}
+	n, err = r.Result()
+	if err != nil || n != 5 {
+		t.Errorf("result expected 5 but got %d: %v", n, err)
+		return
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r, err := aio.ReadAsync(ctx, fds[0], p)
+		if err != nil {
+			t.Errorf("read async: %v", err)
+			return
+		}
+		if _, err = r.Result(); err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("expected pending read but got %v", err)
+			return
+		}
+		cancel()
+		select {
+		case <-r.Done():
+		case <-time.After(5 * time.Second):
+			t.Errorf("cancel timed out")
+			return
+		}
+		if _, err = r.Result(); err != context.Canceled {
+			t.Errorf("expected context.Canceled but got %v", err)
+			return
+		}
+	})
+}
+
+func TestAsyncIO_AcceptConnect(t *testing.T) {
+	aio, err := sox.NewAsyncIO()
+	if err != nil {
+		t.Errorf("new async io: %v", err)
+		return
+	}
+	l, err := sox.ListenTCP4(&sox.TCPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8196})
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ar, err := aio.AcceptAsync(ctx, l.Fd())
+	if err != nil {
+		t.Errorf("accept async: %v", err)
+		return
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Errorf("socket: %v", err)
+		return
+	}
+	defer unix.Close(fd)
+	cr, err := aio.ConnectAsync(ctx, fd, l.Addr())
+	if err != nil {
+		t.Errorf("connect async: %v", err)
+		return
+	}
+	_, err = cr.Wait(ctx)
+	if err != nil {
+		t.Errorf("connect: %v", err)
+		return
+	}
+	nfd, err := ar.Wait(ctx)
+	if err != nil || nfd < 0 {
+		t.Errorf("accept: %v", err)
+		return
+	}
+	defer unix.Close(nfd)
+
+	// the in-flight operations are cancelled by Close
+	r, err := aio.ReadAsync(context.Background(), nfd, make([]byte, 16))
+	if err != nil {
+		t.Errorf("read async: %v", err)
+		return
+	}
+	err = aio.Close()
+	if err != nil {
+		t.Errorf("close: %v", err)
+		return
+	}
+	if _, err = r.Result(); err != io.ErrClosedPipe {
+		t.Errorf("expected ErrClosedPipe but got %v", err)
+		return
+	}
+	if _, err = aio.ReadAsync(context.Background(), nfd, make([]byte, 16)); err != io.ErrClosedPipe {
+		t.Errorf("expected ErrClosedPipe but got %v", err)
+		return
+	}
+}
+
+func TestAsyncIO_DialTCP(t *testing.T) {
+	aio, err := sox.NewAsyncIO()
+	if err != nil {
+		t.Errorf("new async io: %v", err)
+		return
+	}
+	defer aio.Close()
+	// the backlog of zero admits one pending connection, and the
+	// handshakes of the later connections never complete
+	lfd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Errorf("socket: %v", err)
+		return
+	}
+	defer unix.Close(lfd)
+	err = unix.Bind(lfd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}})
+	if err == nil {
+		err = unix.Listen(lfd, 0)
+	}
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	sa, err := unix.Getsockname(lfd)
+	if err != nil {
+		t.Errorf("getsockname: %v", err)
+		return
+	}
+	raddr := &sox.TCPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: sa.(*unix.SockaddrInet4).Port}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := aio.DialTCP(ctx, "tcp4", nil, raddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	if conn.RemoteAddr() != raddr {
+		t.Errorf("expected remote address %v but got %v", raddr, conn.RemoteAddr())
+		return
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		for range 4 {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			conn, err := aio.DialTCP(ctx, "tcp4", nil, raddr)
+			cancel()
+			if err == nil {
+				defer conn.Close()
+				continue
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected context.DeadlineExceeded but got %v", err)
+			}
+			return
+		}
+		t.Errorf("expected dial timeout")
+	})
+}
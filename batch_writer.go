@@ -0,0 +1,139 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MessageFlusher is the interface that wraps the Flush method
+type MessageFlusher interface {
+	// Flush writes the frames buffered by the micro-batching writer mode
+	// It does nothing if the mode is disabled
+	Flush() error
+}
+
+// batchWriter is the underlying writer of the micro-batching writer mode
+// The frames written by the message writer are buffered until size bytes
+// have been buffered or the delay has elapsed since the first buffered frame
+// whichever comes first, so that a burst of small messages is written with a
+// single system call. A frame which does not fit in the buffer is written
+// together with the buffered frames by writev without being copied
+type batchWriter struct {
+	w     io.Writer
+	size  int
+	delay time.Duration
+
+	mu    sync.Mutex
+	buf   []byte
+	iovs  [][]byte
+	timer *time.Timer
+	armed bool
+	err   error
+}
+
+func newBatchWriter(w io.Writer, size int, delay time.Duration) *batchWriter {
+	b := &batchWriter{w: w, size: size, delay: delay, buf: make([]byte, 0, size), iovs: make([][]byte, 0, 2)}
+	if delay > 0 {
+		b.timer = time.AfterFunc(delay, b.flushTimer)
+		b.timer.Stop()
+	}
+	return b
+}
+
+func (b *batchWriter) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(b.buf)+len(p) < b.size {
+		b.buf = append(b.buf, p...)
+		if !b.armed && b.timer != nil {
+			b.timer.Reset(b.delay)
+			b.armed = true
+		}
+		return len(p), nil
+	}
+
+	buffered := len(b.buf)
+	n, err = b.writev(b.buf, p)
+	if n < buffered {
+		b.consume(n)
+		return 0, err
+	}
+	b.consume(buffered)
+	return n - buffered, err
+}
+
+// Flush writes the buffered frames. It returns ErrTemporarilyUnavailable
+// if the underlying writer would block before all of them have been written
+func (b *batchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushLocked()
+}
+
+func (b *batchWriter) flushLocked() error {
+	if b.err != nil {
+		return b.err
+	}
+	for len(b.buf) > 0 {
+		n, err := b.w.Write(b.buf)
+		b.consume(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushTimer flushes the buffered frames after the delay has elapsed
+// The flush is retried after another delay while the writer would block,
+// and the other errors are returned by the following Write or Flush
+func (b *batchWriter) flushTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.armed = false
+	err := b.flushLocked()
+	if err == ErrTemporarilyUnavailable {
+		b.timer.Reset(b.delay)
+		b.armed = true
+	} else if err != nil {
+		b.err = err
+	}
+}
+
+// writev writes the buffered frames followed by p with a single system call
+// if the underlying writer implements VectorWriter
+func (b *batchWriter) writev(buffered []byte, p []byte) (n int, err error) {
+	if len(buffered) == 0 {
+		return b.w.Write(p)
+	}
+	b.iovs = append(b.iovs[:0], buffered, p)
+	defer clear(b.iovs)
+	if w, ok := b.w.(VectorWriter); ok {
+		return w.Writev(b.iovs)
+	}
+	buffers := net.Buffers(b.iovs)
+	wn, err := buffers.WriteTo(b.w)
+
+	return int(wn), err
+}
+
+// consume drops the n written bytes from the head of the buffer
+func (b *batchWriter) consume(n int) {
+	if n <= 0 {
+		return
+	}
+	b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+	if len(b.buf) == 0 && b.armed {
+		b.timer.Stop()
+		b.armed = false
+	}
+}
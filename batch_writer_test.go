@@ -0,0 +1,173 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"fmt"
+	"hybscloud.com/sox"
+	"testing"
+	"time"
+)
+
+// notifyWriter sends a copy of each write to ch
+type notifyWriter struct {
+	ch chan []byte
+}
+
+func (w *notifyWriter) Write(p []byte) (n int, err error) {
+	w.ch <- bytes.Clone(p)
+	return len(p), nil
+}
+
+// syscallCounter counts the writes and discards the written bytes
+type syscallCounter struct {
+	n int
+}
+
+func (w *syscallCounter) Write(p []byte) (n int, err error) {
+	w.n++
+	return len(p), nil
+}
+
+func (w *syscallCounter) Writev(iovs [][]byte) (n int, err error) {
+	w.n++
+	for _, iov := range iovs {
+		n += len(iov)
+	}
+	return n, nil
+}
+
+func TestMessage_Batch(t *testing.T) {
+	t.Run("batch size", func(t *testing.T) {
+		w := &vectorWriter{}
+		mw := sox.NewMessageWriter(w, func(options *sox.MessageOptions) {
+			options.BatchSize = 16
+		})
+		for _, s := range []string{"abc", "de", "f"} {
+			_, err := mw.Write([]byte(s))
+			if err != nil {
+				t.Errorf("write message: %v", err)
+				return
+			}
+		}
+		if w.writes != 0 || w.writev != 0 {
+			t.Errorf("expected the frames to be buffered but got %d writes", w.writes+w.writev)
+			return
+		}
+		_, err := mw.Write([]byte("ghijkl"))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+		if w.writev != 1 || w.String() != "\x03abc\x02de\x01f\x06ghijkl" {
+			t.Errorf("expected a single writev but got %d writev %q", w.writev, w.String())
+			return
+		}
+		_, _ = mw.Write([]byte("mn"))
+		err = mw.(sox.MessageFlusher).Flush()
+		if err != nil || w.writes != 1 || w.String() != "\x03abc\x02de\x01f\x06ghijkl\x02mn" {
+			t.Errorf("flush expected a single write but got %d writes %q: %v", w.writes, w.String(), err)
+			return
+		}
+	})
+
+	t.Run("large message", func(t *testing.T) {
+		w := &vectorWriter{}
+		mw := sox.NewMessageWriter(w, func(options *sox.MessageOptions) {
+			options.BatchSize = 64
+		})
+		_, _ = mw.Write([]byte("small"))
+		_, err := mw.Write(make([]byte, 1024))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+		if w.writev != 1 || w.Len() != 6+3+1024 {
+			t.Errorf("expected a single writev of %d bytes but got %d writev of %d bytes", 6+3+1024, w.writev, w.Len())
+			return
+		}
+		r := sox.NewMessageReader(&w.Buffer)
+		p := make([]byte, 2048)
+		n, err := r.Read(p)
+		if err != nil || string(p[:n]) != "small" {
+			t.Errorf("read expected small but got %q: %v", p[:n], err)
+			return
+		}
+		n, err = r.Read(p)
+		if err != nil || n != 1024 {
+			t.Errorf("read expected %d bytes but got %d: %v", 1024, n, err)
+			return
+		}
+	})
+
+	t.Run("batch delay", func(t *testing.T) {
+		w := &notifyWriter{ch: make(chan []byte, 4)}
+		mw := sox.NewMessageWriter(w, func(options *sox.MessageOptions) {
+			options.BatchSize = 4096
+			options.BatchDelay = time.Millisecond
+		})
+		_, _ = mw.Write([]byte("abc"))
+		_, _ = mw.Write([]byte("de"))
+		select {
+		case p := <-w.ch:
+			if string(p) != "\x03abc\x02de" {
+				t.Errorf("expected the frames to be flushed together but got %q", p)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected the frames to be flushed after the delay")
+			return
+		}
+	})
+
+	t.Run("nonblock", func(t *testing.T) {
+		w := &vectorWriter{limit: 4}
+		mw := sox.NewMessageWriter(w, sox.MessageOptionsNonblock, func(options *sox.MessageOptions) {
+			options.BatchSize = 4
+		})
+		_, err := mw.Write([]byte("abcdef"))
+		if err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("write expected ErrTemporarilyUnavailable but got %v", err)
+			return
+		}
+		w.limit = 0
+		for err == sox.ErrTemporarilyUnavailable {
+			_, err = mw.Write([]byte("abcdef")[w.Len()-1:])
+		}
+		if err == nil {
+			err = mw.(sox.MessageFlusher).Flush()
+		}
+		if err != nil || w.String() != "\x06abcdef" {
+			t.Errorf("write expected the whole frame but got %q: %v", w.String(), err)
+			return
+		}
+	})
+}
+
+func BenchmarkMessage_Batch(b *testing.B) {
+	for _, size := range []int{16, 32, 64} {
+		for _, batch := range []int{0, 4096} {
+			b.Run(fmt.Sprintf("%d bytes message batch %d", size, batch), func(b *testing.B) {
+				w := &syscallCounter{}
+				mw := sox.NewMessageWriter(w, func(options *sox.MessageOptions) {
+					options.BatchSize = batch
+				})
+				p := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for range b.N {
+					_, err := mw.Write(p)
+					if err != nil {
+						b.Errorf("write message: %v", err)
+						return
+					}
+				}
+				_ = mw.(sox.MessageFlusher).Flush()
+				b.ReportMetric(float64(w.n)/float64(b.N), "syscalls/msg")
+			})
+		}
+	}
+}
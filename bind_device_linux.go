@@ -0,0 +1,146 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"bytes"
+	"golang.org/x/sys/unix"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+)
+
+const (
+	sysClassNet = "/sys/class/net"
+)
+
+// SetBindToDevice binds the socket fd to the network interface ifname with
+// SO_BINDTODEVICE, so that only the packets of the interface are received and
+// the packets are sent through it. Binding to a VRF device binds the socket to
+// the routing table of the VRF. An empty ifname unbinds the socket
+// The binding of a connecting socket takes effect at the next connect, which
+// DialTCPDevice does for TCP
+func SetBindToDevice(fd int, ifname string) error {
+	err := unix.BindToDevice(fd, ifname)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// BindToDevice returns the name of the network interface the socket fd is
+// bound to, or an empty string if it is not bound
+func BindToDevice(fd int) (ifname string, err error) {
+	ifname, err = unix.GetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+	if err != nil {
+		return "", errFromUnixErrno(err)
+	}
+	return ifname, nil
+}
+
+// DialTCPDevice connects to raddr like DialTCPAddrPort through the network
+// interface or the VRF ifname, which the socket is bound to before connecting
+func DialTCPDevice(ifname string, laddr, raddr netip.AddrPort) (*TCPConn, error) {
+	if ifname == "" {
+		return nil, InvalidAddrError("empty interface name")
+	}
+	return dialTCPAddrPort(laddr, raddr, ifname)
+}
+
+// NetworkInterface is a network interface with its addresses and its VRF
+type NetworkInterface struct {
+	net.Interface
+	// Addrs is the unicast addresses of the interface with their prefix lengths
+	Addrs []netip.Prefix
+	// VRF is the name of the VRF device the interface is enslaved to, or empty
+	VRF string
+	// IsVRF reports whether the interface is a VRF device itself
+	IsVRF bool
+}
+
+// NetworkInterfaces returns the network interfaces of the system
+func NetworkInterfaces() ([]NetworkInterface, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]NetworkInterface, 0, len(ifs))
+	for _, ifi := range ifs {
+		ni, err := networkInterface(ifi)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ni)
+	}
+	return ret, nil
+}
+
+// NetworkInterfaceByName returns the network interface named name
+func NetworkInterfaceByName(name string) (NetworkInterface, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return NetworkInterface{}, err
+	}
+	return networkInterface(*ifi)
+}
+
+// NetworkInterfacesOfVRF returns the network interfaces enslaved to the VRF device vrf
+func NetworkInterfacesOfVRF(vrf string) ([]NetworkInterface, error) {
+	ifs, err := NetworkInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	ret := ifs[:0]
+	for _, ni := range ifs {
+		if ni.VRF == vrf {
+			ret = append(ret, ni)
+		}
+	}
+	return ret, nil
+}
+
+func networkInterface(ifi net.Interface) (NetworkInterface, error) {
+	ni := NetworkInterface{Interface: ifi, IsVRF: isVRFDevice(ifi.Name)}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return NetworkInterface{}, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ones, _ := ipNet.Mask.Size()
+		ni.Addrs = append(ni.Addrs, netip.PrefixFrom(ip.Unmap(), ones))
+	}
+	// the master of an interface enslaved to a VRF is the VRF device, while
+	// the master of the other enslaved interfaces is a bridge or a bond
+	if master, err := os.Readlink(filepath.Join(sysClassNet, ifi.Name, "master")); err == nil {
+		if name := filepath.Base(master); isVRFDevice(name) {
+			ni.VRF = name
+		}
+	}
+	return ni, nil
+}
+
+func isVRFDevice(name string) bool {
+	uevent, err := os.ReadFile(filepath.Join(sysClassNet, name, "uevent"))
+	if err != nil {
+		return false
+	}
+	for _, line := range bytes.Split(uevent, []byte("\n")) {
+		if bytes.Equal(line, []byte("DEVTYPE=vrf")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"errors"
+	"hash/maphash"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrBrokerClosed will be returned when the Broker has been closed
+	ErrBrokerClosed = errors.New("broker closed")
+)
+
+// BrokerOptions holds the options of a Broker
+type BrokerOptions struct {
+	// Workers is the number of the fan-out goroutines, each of which consumes
+	// a ring queue of its own. The default Workers is GOMAXPROCS
+	Workers int
+	// Capacity is the capacity of the ring queue of each fan-out goroutine
+	// The default Capacity is 4K
+	Capacity int
+	// Nonblocking specifies whether Publish returns ErrTemporarilyUnavailable
+	// instead of waiting while the ring queue of the topic is full
+	Nonblocking bool
+	// BufferPool is the pool which the published messages are copied into
+	// A nil BufferPool indicates that DefaultBufferPool will be used
+	BufferPool *BufferPool
+}
+
+var defaultBrokerOptions = BrokerOptions{
+	Workers:     runtime.GOMAXPROCS(0),
+	Capacity:    defaultShardedRingQueueCapacity,
+	Nonblocking: false,
+	BufferPool:  nil,
+}
+
+// Broker fans the messages published on a topic out to all of the subscribers
+// of the topic. The topics are sharded over the ring queues of the fan-out
+// goroutines by hash, so that the messages of a topic are delivered in the
+// order they have been published, while the topics are fanned out in parallel
+//
+// A subscriber is typically the OutboundQueue of a connection, so that the
+// messages are framed and coalesced into a single writev by the event loop
+// A subscriber should not block on Write, e.g. an OutboundQueue with the
+// BackpressureBlock policy, or else it stalls all of the topics of its shard
+// The subscribers whose Write returns an error other than ErrMsgDropped or
+// ErrTemporarilyUnavailable, e.g. a closed OutboundQueue, are unsubscribed
+//
+// All of the methods of Broker are safe to be called from any goroutine,
+// e.g. message handlers running on the event loop
+type Broker struct {
+	seed      maphash.Seed
+	pool      *BufferPool
+	producers []ItemProducer[brokerMessage]
+
+	mu     sync.RWMutex
+	topics map[string][]*Subscription
+	closed atomic.Bool
+	wg     sync.WaitGroup
+}
+
+type brokerMessage struct {
+	topic string
+	lease *BufferLease
+}
+
+// Subscription is a subscriber of a topic, which is returned by Subscribe
+type Subscription struct {
+	broker *Broker
+	topic  string
+	w      io.Writer
+	done   atomic.Bool
+}
+
+// NewBroker creates a Broker and starts its fan-out goroutines
+// The goroutines exit after the Broker has been closed
+func NewBroker(opts ...func(options *BrokerOptions)) (*Broker, error) {
+	opt := defaultBrokerOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.Workers < 1 || opt.Workers > (1<<16) {
+		return nil, ErrInvalidParam
+	}
+	if opt.BufferPool == nil {
+		opt.BufferPool = DefaultBufferPool
+	}
+
+	b := &Broker{
+		seed:      maphash.MakeSeed(),
+		pool:      opt.BufferPool,
+		producers: make([]ItemProducer[brokerMessage], opt.Workers),
+		topics:    make(map[string][]*Subscription),
+	}
+	consumers := make([]ItemConsumer[brokerMessage], opt.Workers)
+	for i := range opt.Workers {
+		c, p, err := NewRingQueue[brokerMessage](func(options *RingQueueOptions) {
+			options.Capacity = opt.Capacity
+			options.ConcurrentProduce = true
+			options.ConcurrentConsume = false
+			options.Nonblocking = opt.Nonblocking
+			options.WaitStrategy = WaitStrategyPark
+		})
+		if err != nil {
+			return nil, err
+		}
+		consumers[i], b.producers[i] = c, p
+	}
+	b.wg.Add(opt.Workers)
+	for _, c := range consumers {
+		goLabeled(ProfileLabelWorker, func() { b.fanOut(c) })
+	}
+
+	return b, nil
+}
+
+// Subscribe subscribes w to the topic. Each message published on the topic
+// after Subscribe has returned is written to w with one Write
+func (b *Broker) Subscribe(topic string, w io.Writer) (*Subscription, error) {
+	if w == nil {
+		return nil, ErrInvalidParam
+	}
+	s := &Subscription{broker: b, topic: topic, w: w}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed.Load() {
+		return nil, ErrBrokerClosed
+	}
+	// the subscriber slices are copied on write, since the fan-out goroutines
+	// iterate over them without holding the lock
+	subs := b.topics[topic]
+	b.topics[topic] = append(subs[:len(subs):len(subs)], s)
+
+	return s, nil
+}
+
+// Publish copies msg and queues it to be written to all of the subscribers of
+// the topic. It returns without queueing if the topic has no subscriber
+func (b *Broker) Publish(topic string, msg []byte) error {
+	if b.closed.Load() {
+		return ErrBrokerClosed
+	}
+	if b.NumSubscribers(topic) < 1 {
+		return nil
+	}
+	lease := b.pool.Get(len(msg))
+	copy(lease.Bytes(), msg)
+	p := b.producers[maphash.String(b.seed, topic)%uint64(len(b.producers))]
+	err := p.Produce(brokerMessage{topic: topic, lease: lease})
+	if err != nil {
+		lease.Release()
+		if err == io.ErrClosedPipe {
+			return ErrBrokerClosed
+		}
+		return err
+	}
+
+	return nil
+}
+
+// NumSubscribers returns the number of the subscribers of the topic
+func (b *Broker) NumSubscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic])
+}
+
+// Close stops accepting messages, and waits until the queued messages have
+// been written to the subscribers and the fan-out goroutines have exited
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed.Swap(true) {
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+	for _, p := range b.producers {
+		_ = p.Close()
+	}
+	b.wg.Wait()
+
+	return nil
+}
+
+func (b *Broker) fanOut(c ItemConsumer[brokerMessage]) {
+	defer b.wg.Done()
+	for {
+		m, err := c.Consume()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		b.mu.RLock()
+		subs := b.topics[m.topic]
+		b.mu.RUnlock()
+		for _, s := range subs {
+			if s.done.Load() {
+				continue
+			}
+			_, err = s.w.Write(m.lease.Bytes())
+			if err != nil && err != ErrMsgDropped && err != ErrTemporarilyUnavailable {
+				s.Unsubscribe()
+			}
+		}
+		m.lease.Release()
+	}
+}
+
+// Topic returns the topic of the subscription
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Unsubscribe removes the subscriber from the topic. No message is written to
+// the subscriber after Unsubscribe has returned, unless it is being written
+// It is safe to be called more than once
+func (s *Subscription) Unsubscribe() {
+	if s.done.Swap(true) {
+		return
+	}
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.topics[s.topic]
+	i := slices.Index(subs, s)
+	if i < 0 {
+		return
+	}
+	if len(subs) == 1 {
+		delete(b.topics, s.topic)
+		return
+	}
+	b.topics[s.topic] = slices.Delete(slices.Clone(subs), i, i+1)
+}
@@ -0,0 +1,153 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"fmt"
+	"hybscloud.com/sox"
+	"io"
+	"sync"
+	"testing"
+)
+
+// brokerSubscriber records the messages written by a Broker
+type brokerSubscriber struct {
+	mu   sync.Mutex
+	msgs []string
+	err  error
+}
+
+func (s *brokerSubscriber) Write(p []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.msgs = append(s.msgs, string(p))
+	return len(p), nil
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("fan out", func(t *testing.T) {
+		b, err := sox.NewBroker(func(options *sox.BrokerOptions) {
+			options.Workers = 4
+		})
+		if err != nil {
+			t.Errorf("new broker: %v", err)
+			return
+		}
+		topics := []string{"a", "b", "c"}
+		subs := make(map[string][]*brokerSubscriber)
+		for _, topic := range topics {
+			for range 100 {
+				s := &brokerSubscriber{}
+				if _, err = b.Subscribe(topic, s); err != nil {
+					t.Errorf("subscribe: %v", err)
+					return
+				}
+				subs[topic] = append(subs[topic], s)
+			}
+		}
+		if n := b.NumSubscribers("a"); n != 100 {
+			t.Errorf("subscribers expected 100 but got %d", n)
+			return
+		}
+		wg := sync.WaitGroup{}
+		for _, topic := range topics {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 1000 {
+					if err := b.Publish(topic, []byte(fmt.Sprintf("%s%d", topic, i))); err != nil {
+						t.Errorf("publish: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		_ = b.Close()
+		for topic, ss := range subs {
+			for _, s := range ss {
+				if len(s.msgs) != 1000 {
+					t.Errorf("subscriber of %s expected 1000 messages but got %d", topic, len(s.msgs))
+					return
+				}
+				for i, msg := range s.msgs {
+					if msg != fmt.Sprintf("%s%d", topic, i) {
+						t.Errorf("subscriber of %s expected %s%d but got %s", topic, topic, i, msg)
+						return
+					}
+				}
+			}
+		}
+		if err = b.Publish("a", []byte("closed")); err != sox.ErrBrokerClosed {
+			t.Errorf("publish expected ErrBrokerClosed but got %v", err)
+			return
+		}
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		b, err := sox.NewBroker()
+		if err != nil {
+			t.Errorf("new broker: %v", err)
+			return
+		}
+		s0, s1, s2 := &brokerSubscriber{}, &brokerSubscriber{}, &brokerSubscriber{err: io.ErrClosedPipe}
+		sub0, _ := b.Subscribe("t", s0)
+		_, _ = b.Subscribe("t", s1)
+		_, _ = b.Subscribe("t", s2)
+		sub0.Unsubscribe()
+		sub0.Unsubscribe()
+		_ = b.Publish("t", []byte("m0"))
+		_ = b.Publish("t", []byte("m1"))
+		_ = b.Close()
+		if len(s0.msgs) != 0 || len(s1.msgs) != 2 {
+			t.Errorf("messages expected 0 and 2 but got %d and %d", len(s0.msgs), len(s1.msgs))
+			return
+		}
+		// the broken subscriber has been unsubscribed
+		if n := b.NumSubscribers("t"); n != 1 {
+			t.Errorf("subscribers expected 1 but got %d", n)
+			return
+		}
+		if _, err = b.Subscribe("t", s0); err != sox.ErrBrokerClosed {
+			t.Errorf("subscribe expected ErrBrokerClosed but got %v", err)
+			return
+		}
+	})
+
+	t.Run("outbound queue", func(t *testing.T) {
+		b, err := sox.NewBroker()
+		if err != nil {
+			t.Errorf("new broker: %v", err)
+			return
+		}
+		buf := &bytes.Buffer{}
+		q := sox.NewOutboundQueue(buf, func(options *sox.OutboundQueueOptions) {
+			options.HighWaterMark = 1 << 20
+			options.Policy = sox.BackpressureDropNewest
+		})
+		_, _ = b.Subscribe("t", q)
+		for i := range 16 {
+			_ = b.Publish("t", []byte(fmt.Sprintf("m%d", i)))
+		}
+		_ = b.Close()
+		if _, err = q.Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+			return
+		}
+		r := sox.NewMessageReader(buf)
+		p := make([]byte, 16)
+		for i := range 16 {
+			n, err := r.Read(p)
+			if err != nil || string(p[:n]) != fmt.Sprintf("m%d", i) {
+				t.Errorf("read expected m%d but got %q: %v", i, p[:n], err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,209 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	bufferTierPico = iota
+	bufferTierNano
+	bufferTierMicro
+	bufferTierSmall
+	bufferTierMedium
+	bufferTierLarge
+	bufferTierHuge
+	bufferTierNum
+	bufferTierOversize = -1
+)
+
+var bufferTierSizes = [bufferTierNum]int{
+	BufferSizePico,
+	BufferSizeNano,
+	BufferSizeMicro,
+	BufferSizeSmall,
+	BufferSizeMedium,
+	BufferSizeLarge,
+	BufferSizeHuge,
+}
+
+func bufferTierOf(n int) int {
+	for tier, size := range bufferTierSizes {
+		if n <= size {
+			return tier
+		}
+	}
+	return bufferTierOversize
+}
+
+func newBufferOfTier(tier int) []byte {
+	switch tier {
+	case bufferTierPico:
+		return new(PicoBuffer)[:]
+	case bufferTierNano:
+		return new(NanoBuffer)[:]
+	case bufferTierMicro:
+		return new(MicroBuffer)[:]
+	case bufferTierSmall:
+		return new(SmallBuffer)[:]
+	case bufferTierMedium:
+		return new(MediumBuffer)[:]
+	case bufferTierLarge:
+		return new(LargeBuffer)[:]
+	case bufferTierHuge:
+		return new(HugeBuffer)[:]
+	default:
+		panic("bad buffer tier")
+	}
+}
+
+// BufferPool is a pool of byte buffers classified by the sizes
+// from BufferSizePico to BufferSizeHuge. Buffers are handed out
+// as reference counted BufferLease objects
+type BufferPool struct {
+	tiers [bufferTierNum]sync.Pool
+
+	leased    atomic.Int64
+	released  atomic.Int64
+	allocated atomic.Int64
+	oversized atomic.Int64
+}
+
+// BufferPoolStats represents the statistics of a BufferPool
+type BufferPoolStats struct {
+	// Leased is the total number of leases handed out
+	Leased int64
+	// Released is the total number of leases given back
+	Released int64
+	// Allocated is the total number of buffers newly allocated
+	Allocated int64
+	// Oversized is the total number of leases larger than BufferSizeHuge
+	// which are allocated directly and never pooled
+	Oversized int64
+	// InUse is the number of leases not released yet
+	InUse int64
+}
+
+// DefaultBufferPool is the default BufferPool shared by messages and io-uring
+var DefaultBufferPool = NewBufferPool()
+
+// NewBufferPool creates and returns a new BufferPool
+func NewBufferPool() *BufferPool {
+	p := &BufferPool{}
+	for i := range bufferTierNum {
+		tier := i
+		p.tiers[tier].New = func() any {
+			p.allocated.Add(1)
+			return &BufferLease{pool: p, tier: tier, buf: newBufferOfTier(tier)}
+		}
+	}
+	return p
+}
+
+// Get leases a buffer which has at least n bytes from the pool.
+// The returned lease holds one reference
+func (p *BufferPool) Get(n int) *BufferLease {
+	if n < 0 {
+		panic("bad buffer size")
+	}
+	p.leased.Add(1)
+	tier := bufferTierOf(n)
+	if tier == bufferTierOversize {
+		p.allocated.Add(1)
+		p.oversized.Add(1)
+		l := &BufferLease{pool: p, tier: tier, buf: make([]byte, n)}
+		l.b = l.buf
+		l.refs.Store(1)
+		return l
+	}
+	l := p.tiers[tier].Get().(*BufferLease)
+	l.b = l.buf[:n]
+	l.refs.Store(1)
+	return l
+}
+
+// Stats returns the statistics of the pool
+func (p *BufferPool) Stats() BufferPoolStats {
+	leased, released := p.leased.Load(), p.released.Load()
+	return BufferPoolStats{
+		Leased:    leased,
+		Released:  released,
+		Allocated: p.allocated.Load(),
+		Oversized: p.oversized.Load(),
+		InUse:     leased - released,
+	}
+}
+
+func (p *BufferPool) put(l *BufferLease) {
+	p.released.Add(1)
+	if l.tier == bufferTierOversize {
+		return
+	}
+	l.b = nil
+	p.tiers[l.tier].Put(l)
+}
+
+// BufferLease is a buffer leased from a BufferPool. A lease is reference counted
+// so that zero-copy sends can hold the buffer until the kernel completes
+type BufferLease struct {
+	pool *BufferPool
+	tier int
+	refs atomic.Int32
+	buf  []byte
+	b    []byte
+
+	limit   *MemoryLimit
+	charged int64
+}
+
+// Bytes returns the leased bytes
+func (l *BufferLease) Bytes() []byte {
+	return l.b
+}
+
+// Len returns the length of the leased bytes
+func (l *BufferLease) Len() int {
+	return len(l.b)
+}
+
+// Cap returns the capacity of the underlying buffer
+func (l *BufferLease) Cap() int {
+	return len(l.buf)
+}
+
+// Truncate shrinks or grows the leased bytes to n within the capacity
+func (l *BufferLease) Truncate(n int) {
+	if n < 0 || n > len(l.buf) {
+		panic("bad buffer lease length")
+	}
+	l.b = l.buf[:n]
+}
+
+// Retain adds a reference to the lease
+func (l *BufferLease) Retain() *BufferLease {
+	if l.refs.Add(1) <= 1 {
+		panic("retain released buffer lease")
+	}
+	return l
+}
+
+// Release drops a reference of the lease. The buffer will be given
+// back to the pool when the last reference has been released
+func (l *BufferLease) Release() {
+	refs := l.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("release released buffer lease")
+	}
+	if l.limit != nil {
+		l.limit.uncharge(l.charged)
+		l.limit, l.charged = nil, 0
+	}
+	l.pool.put(l)
+}
@@ -0,0 +1,91 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	t.Run("tiers", func(t *testing.T) {
+		pool := sox.NewBufferPool()
+		cases := []struct{ n, cap int }{
+			{0, sox.BufferSizePico},
+			{sox.BufferSizePico, sox.BufferSizePico},
+			{sox.BufferSizePico + 1, sox.BufferSizeNano},
+			{1000, sox.BufferSizeSmall},
+			{sox.BufferSizeHuge, sox.BufferSizeHuge},
+			{sox.BufferSizeHuge + 1, sox.BufferSizeHuge + 1},
+		}
+		for _, c := range cases {
+			l := pool.Get(c.n)
+			if l.Len() != c.n || l.Cap() != c.cap {
+				t.Errorf("lease %d bytes expected len=%d cap=%d but got len=%d cap=%d", c.n, c.n, c.cap, l.Len(), l.Cap())
+				return
+			}
+			l.Release()
+		}
+		stats := pool.Stats()
+		if stats.Leased != int64(len(cases)) || stats.Released != int64(len(cases)) || stats.InUse != 0 {
+			t.Errorf("unexpected pool stats: %+v", stats)
+			return
+		}
+		if stats.Oversized != 1 {
+			t.Errorf("expected 1 oversized lease but got %d", stats.Oversized)
+			return
+		}
+	})
+
+	t.Run("reference counting", func(t *testing.T) {
+		pool := sox.NewBufferPool()
+		l := pool.Get(100)
+		l.Retain()
+		l.Release()
+		if stats := pool.Stats(); stats.InUse != 1 {
+			t.Errorf("expected 1 lease in use but got %d", stats.InUse)
+			return
+		}
+		l.Release()
+		if stats := pool.Stats(); stats.InUse != 0 {
+			t.Errorf("expected 0 lease in use but got %d", stats.InUse)
+			return
+		}
+	})
+
+	t.Run("message read lease", func(t *testing.T) {
+		pool := sox.NewBufferPool()
+		r, w := sox.NewMessagePipe(func(options *sox.MessageOptions) {
+			options.BufferPool = pool
+		})
+		s := bytes.Repeat([]byte{0x41, 0x42, 0x43, 0x44}, 5000)
+		go func() {
+			for _, n := range []int{len(s), 0, 100} {
+				_, err := w.Write(s[:n])
+				if err != nil {
+					t.Errorf("write message: %v", err)
+					return
+				}
+			}
+		}()
+		for _, n := range []int{len(s), 0, 100} {
+			l, err := r.(sox.MessageLeaseReader).ReadLease()
+			if err != nil {
+				t.Errorf("read lease: %v", err)
+				return
+			}
+			if !bytes.Equal(l.Bytes(), s[:n]) {
+				t.Errorf("read lease expected %d bytes but got %d bytes", n, l.Len())
+				return
+			}
+			l.Release()
+		}
+		if stats := pool.Stats(); stats.Leased != 3 || stats.InUse != 0 {
+			t.Errorf("unexpected pool stats: %+v", stats)
+			return
+		}
+	})
+}
@@ -0,0 +1,259 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"bufio"
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+const (
+	skMemInfoVars = 9
+
+	defaultBufferTunerMinBuffer = 1 << 18
+	defaultBufferTunerMaxBuffer = 1 << 26
+)
+
+// SocketMemInfo is the snapshot of the SO_MEMINFO of a socket in bytes
+type SocketMemInfo struct {
+	RecvAlloc    int
+	RecvBuffer   int
+	SendAlloc    int
+	SendBuffer   int
+	ForwardAlloc int
+	SendQueued   int
+	OptMem       int
+	Backlog      int
+	// Drops is the number of the packets dropped by the socket, e.g. when
+	// the receive buffer is full. It wraps around at 1<<32
+	Drops uint32
+}
+
+// MemInfo returns the snapshot of the SO_MEMINFO of the socket fd
+func MemInfo(fd int) (info SocketMemInfo, err error) {
+	v := [skMemInfoVars]uint32{}
+	n := uint32(unsafe.Sizeof(v))
+	_, _, errno := unix.Syscall6(
+		unix.SYS_GETSOCKOPT,
+		uintptr(fd),
+		unix.SOL_SOCKET,
+		unix.SO_MEMINFO,
+		uintptr(unsafe.Pointer(&v)),
+		uintptr(unsafe.Pointer(&n)),
+		0)
+	if errno != 0 {
+		return SocketMemInfo{}, errFromUnixErrno(errno)
+	}
+	info = SocketMemInfo{
+		RecvAlloc:    int(v[0]),
+		RecvBuffer:   int(v[1]),
+		SendAlloc:    int(v[2]),
+		SendBuffer:   int(v[3]),
+		ForwardAlloc: int(v[4]),
+		SendQueued:   int(v[5]),
+		OptMem:       int(v[6]),
+		Backlog:      int(v[7]),
+		Drops:        v[8],
+	}
+	return info, nil
+}
+
+// UDPRcvbufErrors returns the number of the UDP datagrams of the system
+// which are dropped for the full receive buffers, the RcvbufErrors of /proc/net/snmp
+func UDPRcvbufErrors() (uint64, error) {
+	f, err := os.Open("/proc/net/snmp")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var names []string
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] != "Udp:" {
+			continue
+		}
+		// the first Udp line holds the names and the second holds the values
+		if names == nil {
+			names = fields
+			continue
+		}
+		for i := 1; i < len(fields) && i < len(names); i++ {
+			if names[i] == "RcvbufErrors" {
+				return strconv.ParseUint(fields[i], 10, 64)
+			}
+		}
+		break
+	}
+	return 0, ErrNotSupported
+}
+
+// BufferTunerOptions holds optional parameters for BufferTuner
+// The sizes are in the unit of SO_RCVBUF and SO_SNDBUF, of which the kernel
+// reserves the double for the bookkeeping overhead
+type BufferTunerOptions struct {
+	MinRecvBuffer int
+	MaxRecvBuffer int
+	MinSendBuffer int
+	MaxSendBuffer int
+	// IdleRounds is the number of the consecutive Tune calls without
+	// pressure, after which the buffers are halved toward the minimums
+	// The default 0 never shrinks the buffers
+	IdleRounds int
+	// Force specifies whether SO_RCVBUFFORCE and SO_SNDBUFFORCE are tried
+	// first to exceed net.core.rmem_max and net.core.wmem_max, which needs
+	// CAP_NET_ADMIN. Without it, the kernel caps the buffers at those limits
+	Force bool
+}
+
+// BufferTuner adjusts the receive and the send buffers of the sockets
+// within the caps by watching their pressure. The receive buffer of a socket
+// is doubled when the socket dropped packets since the last Tune, and the
+// send buffer is doubled when it is filled over 3/4
+// Tune is expected to be called periodically, e.g. on the expirations of a
+// Timer. The methods of BufferTuner are safe for concurrent use
+type BufferTuner struct {
+	*BufferTunerOptions
+	mu      sync.Mutex
+	sockets map[int]*bufferTunerSocket
+}
+
+type bufferTunerSocket struct {
+	drops uint32
+	idle  int
+}
+
+// NewBufferTuner creates and returns a BufferTuner
+func NewBufferTuner(opts ...func(options *BufferTunerOptions)) (*BufferTuner, error) {
+	o := &BufferTunerOptions{
+		MinRecvBuffer: defaultBufferTunerMinBuffer,
+		MaxRecvBuffer: defaultBufferTunerMaxBuffer,
+		MinSendBuffer: defaultBufferTunerMinBuffer,
+		MaxSendBuffer: defaultBufferTunerMaxBuffer,
+		IdleRounds:    0,
+		Force:         false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	if o.MinRecvBuffer < 1 || o.MinRecvBuffer > o.MaxRecvBuffer ||
+		o.MinSendBuffer < 1 || o.MinSendBuffer > o.MaxSendBuffer || o.IdleRounds < 0 {
+		return nil, ErrInvalidParam
+	}
+
+	return &BufferTuner{BufferTunerOptions: o, sockets: make(map[int]*bufferTunerSocket)}, nil
+}
+
+// Add starts tuning the socket fd, whose buffers are raised to the minimums
+func (t *BufferTuner) Add(fd int) error {
+	info, err := MemInfo(fd)
+	if err != nil {
+		return err
+	}
+	if info.RecvBuffer/2 < t.MinRecvBuffer {
+		err = t.setBuffer(fd, unix.SO_RCVBUF, unix.SO_RCVBUFFORCE, t.MinRecvBuffer)
+	}
+	if err == nil && info.SendBuffer/2 < t.MinSendBuffer {
+		err = t.setBuffer(fd, unix.SO_SNDBUF, unix.SO_SNDBUFFORCE, t.MinSendBuffer)
+	}
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sockets[fd] = &bufferTunerSocket{drops: info.Drops}
+
+	return nil
+}
+
+// Remove stops tuning the socket fd. It should be called before the socket is closed
+func (t *BufferTuner) Remove(fd int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sockets, fd)
+}
+
+// Tune adjusts the buffers of the sockets once. The sockets which have
+// been closed are removed, and the other errors are returned joined
+func (t *BufferTuner) Tune() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs []error
+	for fd, so := range t.sockets {
+		err := t.tune(fd, so)
+		if err == ErrBadFd || err == ErrNotSocket {
+			delete(t.sockets, fd)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t *BufferTuner) tune(fd int, so *bufferTunerSocket) error {
+	info, err := MemInfo(fd)
+	if err != nil {
+		return err
+	}
+	// the kernel reports the doubled sizes which were set
+	rcvbuf, sndbuf := info.RecvBuffer/2, info.SendBuffer/2
+	drops := info.Drops - so.drops
+	so.drops = info.Drops
+	recvPressure := drops > 0 && rcvbuf < t.MaxRecvBuffer
+	sendPressure := info.SendAlloc*4 >= info.SendBuffer*3 && sndbuf < t.MaxSendBuffer
+	if recvPressure {
+		err = t.setBuffer(fd, unix.SO_RCVBUF, unix.SO_RCVBUFFORCE, min(rcvbuf*2, t.MaxRecvBuffer))
+	}
+	if err == nil && sendPressure {
+		err = t.setBuffer(fd, unix.SO_SNDBUF, unix.SO_SNDBUFFORCE, min(sndbuf*2, t.MaxSendBuffer))
+	}
+	if err != nil {
+		return err
+	}
+	if recvPressure || sendPressure || drops > 0 || t.IdleRounds == 0 {
+		so.idle = 0
+		return nil
+	}
+	if so.idle++; so.idle < t.IdleRounds {
+		return nil
+	}
+	so.idle = 0
+	// the buffers are shrunk only while they are mostly empty
+	if rcvbuf > t.MinRecvBuffer && info.RecvAlloc*4 < info.RecvBuffer {
+		err = t.setBuffer(fd, unix.SO_RCVBUF, unix.SO_RCVBUFFORCE, max(rcvbuf/2, t.MinRecvBuffer))
+	}
+	if err == nil && sndbuf > t.MinSendBuffer && info.SendAlloc*4 < info.SendBuffer {
+		err = t.setBuffer(fd, unix.SO_SNDBUF, unix.SO_SNDBUFFORCE, max(sndbuf/2, t.MinSendBuffer))
+	}
+
+	return err
+}
+
+func (t *BufferTuner) setBuffer(fd int, opt int, forceOpt int, size int) error {
+	if t.Force {
+		err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, forceOpt, size)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EPERM {
+			return errFromUnixErrno(err)
+		}
+	}
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, opt, size)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
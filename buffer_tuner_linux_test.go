@@ -0,0 +1,116 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+func TestBufferTuner(t *testing.T) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Errorf("socket: %v", err)
+		return
+	}
+	defer unix.Close(fd)
+	err = unix.Bind(fd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}})
+	if err != nil {
+		t.Errorf("bind: %v", err)
+		return
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		t.Errorf("getsockname: %v", err)
+		return
+	}
+
+	tuner, err := NewBufferTuner(func(options *BufferTunerOptions) {
+		options.MinRecvBuffer, options.MaxRecvBuffer = 4096, 16384
+		options.MinSendBuffer, options.MaxSendBuffer = 4096, 16384
+		options.IdleRounds = 2
+	})
+	if err != nil {
+		t.Errorf("new buffer tuner: %v", err)
+		return
+	}
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 1024)
+	if err != nil {
+		t.Errorf("set rcvbuf: %v", err)
+		return
+	}
+	err = tuner.Add(fd)
+	if err != nil {
+		t.Errorf("add: %v", err)
+		return
+	}
+	info, err := MemInfo(fd)
+	if err != nil || info.RecvBuffer != 2*4096 {
+		t.Errorf("add expected rcvbuf %d but got %+v: %v", 2*4096, info, err)
+		return
+	}
+
+	sender, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Errorf("socket: %v", err)
+		return
+	}
+	defer unix.Close(sender)
+	flood := func() {
+		b := make([]byte, 1024)
+		for range 64 {
+			_ = unix.Sendto(sender, b, 0, sa)
+		}
+	}
+	for _, expected := range []int{8192, 16384, 16384} {
+		flood()
+		err = tuner.Tune()
+		if err != nil {
+			t.Errorf("tune: %v", err)
+			return
+		}
+		info, err = MemInfo(fd)
+		if err != nil || info.RecvBuffer != 2*expected {
+			t.Errorf("tune expected rcvbuf %d but got %+v: %v", 2*expected, info, err)
+			return
+		}
+	}
+
+	// the drained buffer is halved after the idle rounds
+	b := make([]byte, 2048)
+	for {
+		if _, _, err := unix.Recvfrom(fd, b, 0); err != nil {
+			break
+		}
+	}
+	for range 2 {
+		err = tuner.Tune()
+		if err != nil {
+			t.Errorf("tune: %v", err)
+			return
+		}
+	}
+	info, err = MemInfo(fd)
+	if err != nil || info.RecvBuffer != 2*8192 {
+		t.Errorf("tune expected rcvbuf %d but got %+v: %v", 2*8192, info, err)
+		return
+	}
+
+	tuner.Remove(fd)
+	if len(tuner.sockets) != 0 {
+		t.Errorf("remove expected no sockets but got %d", len(tuner.sockets))
+		return
+	}
+	if _, err = NewBufferTuner(func(options *BufferTunerOptions) { options.MinRecvBuffer = 1 << 30 }); err != ErrInvalidParam {
+		t.Errorf("new buffer tuner expected ErrInvalidParam but got %v", err)
+		return
+	}
+	if _, err = UDPRcvbufErrors(); err != nil {
+		t.Errorf("udp rcvbuf errors: %v", err)
+		return
+	}
+}
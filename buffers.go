@@ -13,27 +13,32 @@ import (
 // Buffers is alias of net.Buffers
 type Buffers = net.Buffers
 
-// AlignedMemBlocks returns n bytes slices which
-// has length with memory page size and address
-// starts from multiple of memory page size
-func AlignedMemBlocks(n int) (blocks [][]byte) {
+// AlignedMemBlocks returns n bytes slices which has length with size
+// and address starts from multiple of size. The size must be a power of two
+// The blocks smaller than memory page size share pages with each other,
+// and the first block always starts from multiple of memory page size
+func AlignedMemBlocks(n int, size int) (blocks [][]byte) {
 	if n < 1 {
 		panic("bad block num")
 	}
-	blocks = make([][]byte, n)
-	size := os.Getpagesize()
-	p := make([]byte, size*(n+1))
+	if size < 1 || size&(size-1) != 0 {
+		panic("bad block size")
+	}
+	align := max(size, os.Getpagesize())
+	p := make([]byte, size*n+align-1)
 	ptr := uintptr(unsafe.Pointer(&p[0]))
-	off := ptr - (ptr & ^(uintptr(size) - 1))
+	off := int((uintptr(align) - ptr&(uintptr(align)-1)) & (uintptr(align) - 1))
+	blocks = make([][]byte, n)
 	for i := range n {
-		blocks[i] = unsafe.Slice(&p[i*size-int(off)], size)
+		blocks[i] = p[off+i*size : off+(i+1)*size : off+(i+1)*size]
 	}
 	return
 }
 
 // AlignedMemBlock returns one aligned block
+// with length of memory page size
 func AlignedMemBlock() []byte {
-	return AlignedMemBlocks(1)[0]
+	return AlignedMemBlocks(1, os.Getpagesize())[0]
 }
 
 // NewBuffers creates and initializes a new Buffers with given n and size
@@ -0,0 +1,139 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	hugePageSize = 2 << 20
+
+	_MPOL_BIND     = 2
+	_MAP_HUGE_2MB  = 21 << unix.MAP_HUGE_SHIFT
+	_MPOL_MF_MOVE  = 1 << 1
+	numaNodeMaxNum = 1024
+)
+
+// MemBlocksOptions holds the options of AllocAlignedMemBlocks
+type MemBlocksOptions struct {
+	// HugePages maps the memory with 2MB huge pages
+	// The huge pages must have been reserved by the system
+	HugePages bool
+	// NUMANode binds the memory to the given NUMA node
+	// A negative NUMANode means no binding
+	NUMANode int
+	// Lock locks the memory in RAM to prevent it from being paged to the swap area
+	Lock bool
+}
+
+var defaultMemBlocksOptions = MemBlocksOptions{
+	HugePages: false,
+	NUMANode:  -1,
+	Lock:      false,
+}
+
+// MemBlocks is a set of aligned memory blocks allocated outside the Go heap
+// The memory is not managed by GC and must be released with Release
+type MemBlocks struct {
+	mem      []byte
+	blocks   [][]byte
+	locked   bool
+	released atomic.Bool
+}
+
+// AllocAlignedMemBlocks maps and returns n memory blocks which has length with
+// memory page size and address starts from multiple of memory page size
+// The blocks are contiguous, so that they can also be registered to io_uring
+// as fixed buffers with less pinned pages
+func AllocAlignedMemBlocks(n int, opts ...func(options *MemBlocksOptions)) (*MemBlocks, error) {
+	if n < 1 {
+		return nil, ErrInvalidParam
+	}
+	options := defaultMemBlocksOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.NUMANode >= numaNodeMaxNum {
+		return nil, ErrInvalidParam
+	}
+
+	size := os.Getpagesize()
+	length, flags := size*n, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE
+	if options.HugePages {
+		length = (length + hugePageSize - 1) &^ (hugePageSize - 1)
+		flags |= unix.MAP_HUGETLB | _MAP_HUGE_2MB
+	}
+	if options.NUMANode >= 0 {
+		// populate the pages after binding them to the node
+		flags &^= unix.MAP_POPULATE
+	}
+	mem, err := unix.Mmap(-1, 0, length, unix.PROT_READ|unix.PROT_WRITE, flags)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+	mb := &MemBlocks{mem: mem}
+	if options.NUMANode >= 0 {
+		err = mbind(mem, options.NUMANode)
+		if err != nil {
+			_ = unix.Munmap(mem)
+			return nil, err
+		}
+	}
+	if options.Lock {
+		err = unix.Mlock(mem)
+		if err != nil {
+			_ = unix.Munmap(mem)
+			return nil, errFromUnixErrno(err)
+		}
+		mb.locked = true
+	}
+
+	mb.blocks = make([][]byte, n)
+	for i := range n {
+		mb.blocks[i] = mem[i*size : (i+1)*size : (i+1)*size]
+	}
+
+	return mb, nil
+}
+
+// Blocks returns the memory blocks
+func (mb *MemBlocks) Blocks() [][]byte {
+	return mb.blocks
+}
+
+// Release unmaps the memory. The blocks must not be used after Release
+func (mb *MemBlocks) Release() error {
+	if !mb.released.CompareAndSwap(false, true) {
+		return nil
+	}
+	if mb.locked {
+		_ = unix.Munlock(mb.mem)
+	}
+	mb.blocks = nil
+	err := unix.Munmap(mb.mem)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+func mbind(b []byte, node int) error {
+	mask := [numaNodeMaxNum / 64]uint64{}
+	mask[node/64] |= 1 << (node % 64)
+	addr, n := uintptr(unsafe.Pointer(unsafe.SliceData(b))), uintptr(len(b))
+	_, _, errno := unix.Syscall6(unix.SYS_MBIND, addr, n, _MPOL_BIND, uintptr(unsafe.Pointer(&mask)), numaNodeMaxNum, _MPOL_MF_MOVE)
+	if errno != 0 {
+		return errFromUnixErrno(errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"hybscloud.com/sox"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestAllocAlignedMemBlocks(t *testing.T) {
+	check := func(t *testing.T, mb *sox.MemBlocks, n int) {
+		blocks := mb.Blocks()
+		if len(blocks) != n {
+			t.Errorf("expected %d blocks but got %d", n, len(blocks))
+			return
+		}
+		for _, b := range blocks {
+			if len(b) != os.Getpagesize() || cap(b) != os.Getpagesize() {
+				t.Errorf("expected slice len and cap %d but got %d %d", os.Getpagesize(), len(b), cap(b))
+				return
+			}
+			ptr := uintptr(unsafe.Pointer(&b[0]))
+			if ptr%uintptr(os.Getpagesize()) != 0 {
+				t.Errorf("memory not aligned")
+				return
+			}
+			b[0], b[len(b)-1] = 1, 1
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		const n = 64
+		mb, err := sox.AllocAlignedMemBlocks(n)
+		if err != nil {
+			t.Errorf("alloc aligned mem blocks: %v", err)
+			return
+		}
+		check(t, mb, n)
+		if err = mb.Release(); err != nil {
+			t.Errorf("release mem blocks: %v", err)
+			return
+		}
+		if mb.Blocks() != nil {
+			t.Errorf("expected no blocks after release")
+			return
+		}
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		mb, err := sox.AllocAlignedMemBlocks(4, func(options *sox.MemBlocksOptions) {
+			options.Lock = true
+		})
+		if err != nil {
+			t.Skipf("alloc locked mem blocks: %v", err)
+		}
+		defer mb.Release()
+		check(t, mb, 4)
+	})
+
+	t.Run("numa node", func(t *testing.T) {
+		mb, err := sox.AllocAlignedMemBlocks(4, func(options *sox.MemBlocksOptions) {
+			options.NUMANode = 0
+		})
+		if err != nil {
+			t.Skipf("alloc mem blocks on numa node: %v", err)
+		}
+		defer mb.Release()
+		check(t, mb, 4)
+	})
+
+	t.Run("huge pages", func(t *testing.T) {
+		mb, err := sox.AllocAlignedMemBlocks(4, func(options *sox.MemBlocksOptions) {
+			options.HugePages = true
+		})
+		if err != nil {
+			t.Skipf("alloc mem blocks with huge pages: %v", err)
+		}
+		defer mb.Release()
+		check(t, mb, 4)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := sox.AllocAlignedMemBlocks(0)
+		if err != sox.ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam but got %v", err)
+			return
+		}
+	})
+}
@@ -13,7 +13,7 @@ import (
 
 func TestAlignedMemBlocks(t *testing.T) {
 	t.Run("single", func(t *testing.T) {
-		b := sox.AlignedMemBlocks(1)[0]
+		b := sox.AlignedMemBlocks(1, os.Getpagesize())[0]
 		if len(b) != os.Getpagesize() {
 			t.Errorf("expected slice len %d but got %d", os.Getpagesize(), len(b))
 			return
@@ -31,7 +31,7 @@ func TestAlignedMemBlocks(t *testing.T) {
 
 	t.Run("multiple", func(t *testing.T) {
 		const n = 1024
-		blocks := sox.AlignedMemBlocks(n)
+		blocks := sox.AlignedMemBlocks(n, os.Getpagesize())
 		for i := range n {
 			b := blocks[i]
 			if len(b) != os.Getpagesize() {
@@ -49,4 +49,46 @@ func TestAlignedMemBlocks(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("block sizes", func(t *testing.T) {
+		const n = 16
+		for _, size := range []int{64, 512, os.Getpagesize(), 1 << 16, 1 << 21} {
+			blocks := sox.AlignedMemBlocks(n, size)
+			if len(blocks) != n {
+				t.Errorf("expected %d blocks but got %d", n, len(blocks))
+				return
+			}
+			for i, b := range blocks {
+				if len(b) != size || cap(b) != size {
+					t.Errorf("expected slice len and cap %d but got %d %d", size, len(b), cap(b))
+					return
+				}
+				ptr := uintptr(unsafe.Pointer(&b[0]))
+				if ptr%uintptr(size) != 0 {
+					t.Errorf("block %d of size %d not aligned: %#x", i, size, ptr)
+					return
+				}
+				if i == 0 && ptr%uintptr(os.Getpagesize()) != 0 {
+					t.Errorf("first block of size %d not page aligned: %#x", size, ptr)
+					return
+				}
+				b[0], b[size-1] = byte(i), byte(i)
+			}
+			for i, b := range blocks {
+				if b[0] != byte(i) || b[size-1] != byte(i) {
+					t.Errorf("blocks of size %d overlap", size)
+					return
+				}
+			}
+		}
+	})
+
+	t.Run("bad block size", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic on bad block size")
+			}
+		}()
+		sox.AlignedMemBlocks(1, 3000)
+	})
 }
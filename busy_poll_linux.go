@@ -0,0 +1,121 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"math"
+	"time"
+	"unsafe"
+)
+
+const (
+	_EPIOCSPARAMS = 0x40088a01
+	_EPIOCGPARAMS = 0x80088a02
+)
+
+// epollParams is the struct epoll_params of the EPIOCSPARAMS ioctl
+type epollParams struct {
+	busyPollUsecs  uint32
+	busyPollBudget uint16
+	preferBusyPoll uint8
+	pad            uint8
+}
+
+// SetBusyPoll sets the busy polling parameters of the socket fd with
+// SO_BUSY_POLL, SO_BUSY_POLL_BUDGET and SO_PREFER_BUSY_POLL
+// Increasing the timeout or the budget over the system default requires CAP_NET_ADMIN
+func SetBusyPoll(fd int, params BusyPollParams) error {
+	usecs, err := busyPollUsecs(params.Timeout)
+	if err != nil {
+		return err
+	}
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL, int(usecs))
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	if params.Budget > 0 {
+		if params.Budget > math.MaxUint16 {
+			return ErrInvalidParam
+		}
+		err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL_BUDGET, params.Budget)
+		if err != nil {
+			return errFromUnixErrno(err)
+		}
+	}
+	prefer := 0
+	if params.Prefer {
+		prefer = 1
+	}
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PREFER_BUSY_POLL, prefer)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// BusyPoll returns the busy polling parameters of the socket fd
+// The Budget is always zero because the kernel does not report it
+func BusyPoll(fd int) (params BusyPollParams, err error) {
+	usecs, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL)
+	if err != nil {
+		return params, errFromUnixErrno(err)
+	}
+	prefer, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PREFER_BUSY_POLL)
+	if err != nil {
+		return params, errFromUnixErrno(err)
+	}
+
+	return BusyPollParams{Timeout: time.Duration(usecs) * time.Microsecond, Prefer: prefer != 0}, nil
+}
+
+// setBusyPoll sets the busy polling parameters of the epoll with EPIOCSPARAMS
+// which requires Linux 6.9 or later. A budget over 64 requires CAP_NET_ADMIN
+func (ep *epoll) setBusyPoll(params BusyPollParams) error {
+	usecs, err := busyPollUsecs(params.Timeout)
+	if err != nil {
+		return err
+	}
+	if params.Budget < 0 || params.Budget > math.MaxUint16 {
+		return ErrInvalidParam
+	}
+	p := epollParams{busyPollUsecs: usecs, busyPollBudget: uint16(params.Budget)}
+	if params.Prefer {
+		p.preferBusyPoll = 1
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(ep.fd), _EPIOCSPARAMS, uintptr(unsafe.Pointer(&p)))
+	if errno != 0 {
+		return errFromUnixErrno(errno)
+	}
+
+	return nil
+}
+
+// busyPoll returns the busy polling parameters of the epoll with EPIOCGPARAMS
+func (ep *epoll) busyPoll() (params BusyPollParams, err error) {
+	p := epollParams{}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(ep.fd), _EPIOCGPARAMS, uintptr(unsafe.Pointer(&p)))
+	if errno != 0 {
+		return params, errFromUnixErrno(errno)
+	}
+
+	return BusyPollParams{
+		Timeout: time.Duration(p.busyPollUsecs) * time.Microsecond,
+		Budget:  int(p.busyPollBudget),
+		Prefer:  p.preferBusyPoll != 0,
+	}, nil
+}
+
+func busyPollUsecs(d time.Duration) (uint32, error) {
+	usecs := d.Microseconds()
+	if usecs < 0 || usecs > math.MaxInt32 {
+		return 0, ErrInvalidParam
+	}
+
+	return uint32(usecs), nil
+}
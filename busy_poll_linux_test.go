@@ -0,0 +1,72 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"testing"
+	"time"
+)
+
+func TestBusyPoll(t *testing.T) {
+	t.Run("socket", func(t *testing.T) {
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			t.Errorf("socket: %v", err)
+			return
+		}
+		defer unix.Close(fd)
+		err = SetBusyPoll(fd, BusyPollParams{Timeout: 0, Prefer: true})
+		if err != nil {
+			t.Errorf("set busy poll: %v", err)
+			return
+		}
+		params, err := BusyPoll(fd)
+		if err != nil {
+			t.Errorf("busy poll: %v", err)
+			return
+		}
+		if params.Timeout != 0 || !params.Prefer {
+			t.Errorf("busy poll expected timeout=0 prefer=true but got %+v", params)
+			return
+		}
+		err = SetBusyPoll(fd, BusyPollParams{Timeout: -time.Microsecond})
+		if err != ErrInvalidParam {
+			t.Errorf("set busy poll expected %v but got %v", ErrInvalidParam, err)
+			return
+		}
+	})
+
+	t.Run("epoll", func(t *testing.T) {
+		ep, err := newPoller(16)
+		if err != nil {
+			t.Errorf("new epoll: %v", err)
+			return
+		}
+		defer ep.Close()
+		expected := BusyPollParams{Timeout: 50 * time.Microsecond, Budget: 8, Prefer: true}
+		err = ep.setBusyPoll(expected)
+		if errors.Is(err, unix.ENOTTY) {
+			t.Skipf("epoll busy poll params are not supported: %v", err)
+			return
+		}
+		if err != nil {
+			t.Errorf("epoll set busy poll: %v", err)
+			return
+		}
+		params, err := ep.busyPoll()
+		if err != nil {
+			t.Errorf("epoll busy poll: %v", err)
+			return
+		}
+		if params != expected {
+			t.Errorf("epoll busy poll expected %+v but got %+v", expected, params)
+			return
+		}
+	})
+}
@@ -0,0 +1,114 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrByteOrderMismatch will be returned when the peers require different byte orders
+	ErrByteOrderMismatch = errors.New("byte order mismatch")
+)
+
+//
+// The byte order preamble is exchanged once at connection setup before any message:
+//
+// | 'S' | 'O' | 'X' | order (1) |
+//
+// The order is 'b' or 'l' for a preferred big or little endian, which may be
+// negotiated, or 'B' or 'L' for a required one. The peers agree on the little
+// endian only if neither of them requires the big endian and at least one of
+// them requires the little endian or both of them prefer it. The peers which
+// require different byte orders fail with ErrByteOrderMismatch
+//
+
+const (
+	byteOrderPreambleLength = 4
+)
+
+// NegotiateByteOrder exchanges the byte order preamble over rw, and returns the
+// byte order which both of the peers use for ReadByteOrder and WriteByteOrder
+// A nil order means the network byte order. If required is true, it validates
+// that the peer can use the order, or fails with ErrByteOrderMismatch
+// Both of the peers write the preamble before reading, so that rw must be
+// buffered like the sockets, and unlike the synchronous pipes
+func NegotiateByteOrder(rw io.ReadWriter, order binary.ByteOrder, required bool) (binary.ByteOrder, error) {
+	code := byte('b')
+	if order == binary.LittleEndian {
+		code = 'l'
+	}
+	if required {
+		code -= 'a' - 'A'
+	}
+	p := [byteOrderPreambleLength]byte{'S', 'O', 'X', code}
+	err := writeFull(rw, p[:])
+	if err != nil {
+		return nil, err
+	}
+	err = readFull(rw, p[:])
+	if err != nil {
+		return nil, err
+	}
+	peer := p[3]
+	if p[0] != 'S' || p[1] != 'O' || p[2] != 'X' || (peer != 'b' && peer != 'l' && peer != 'B' && peer != 'L') {
+		return nil, &MessageProtocolError{Header: p[:], Reason: "bad byte order preamble"}
+	}
+
+	switch {
+	case code == 'B' && peer == 'L', code == 'L' && peer == 'B':
+		return nil, ErrByteOrderMismatch
+	case code == 'B' || peer == 'B':
+		return binary.BigEndian, nil
+	case code == 'L' || peer == 'L', code == 'l' && peer == 'l':
+		return binary.LittleEndian, nil
+	}
+	return binary.BigEndian, nil
+}
+
+// MessageOptionsByteOrder returns the option which sets both of the byte orders to order
+// e.g. the byte order returned by NegotiateByteOrder
+func MessageOptionsByteOrder(order binary.ByteOrder) func(options *MessageOptions) {
+	return func(options *MessageOptions) {
+		options.ReadByteOrder, options.WriteByteOrder = order, order
+	}
+}
+
+// writeFull writes p to w, and waits while w is temporarily unavailable
+func writeFull(w io.Writer, p []byte) error {
+	for sw := (SpinWait{}); len(p) > 0; {
+		n, err := w.Write(p)
+		p = p[n:]
+		if err == ErrTemporarilyUnavailable {
+			sw.Once()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFull reads exactly len(p) bytes from r, and waits while r is temporarily unavailable
+func readFull(r io.Reader, p []byte) error {
+	for sw, read := (SpinWait{}), 0; read < len(p); {
+		n, err := r.Read(p[read:])
+		read += n
+		if err == ErrTemporarilyUnavailable {
+			sw.Once()
+			continue
+		}
+		if err == io.EOF && read > 0 && read < len(p) {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil && read < len(p) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"hybscloud.com/sox"
+	"net"
+	"testing"
+)
+
+func TestNegotiateByteOrder(t *testing.T) {
+	type side struct {
+		order    binary.ByteOrder
+		required bool
+	}
+	cases := []struct {
+		name     string
+		a, b     side
+		expected binary.ByteOrder
+		err      error
+	}{
+		{"default", side{nil, false}, side{nil, false}, binary.BigEndian, nil},
+		{"both prefer little", side{binary.LittleEndian, false}, side{binary.LittleEndian, false}, binary.LittleEndian, nil},
+		{"one prefers little", side{binary.LittleEndian, false}, side{binary.BigEndian, false}, binary.BigEndian, nil},
+		{"require little", side{binary.LittleEndian, true}, side{binary.BigEndian, false}, binary.LittleEndian, nil},
+		{"require big", side{binary.BigEndian, true}, side{binary.LittleEndian, false}, binary.BigEndian, nil},
+		{"mismatch", side{binary.BigEndian, true}, side{binary.LittleEndian, true}, nil, sox.ErrByteOrderMismatch},
+	}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	for _, c := range cases {
+		cc, err := net.Dial("tcp4", l.Addr().String())
+		if err != nil {
+			t.Errorf("dial: %v", err)
+			return
+		}
+		sc, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		type result struct {
+			order binary.ByteOrder
+			err   error
+		}
+		peer := make(chan result, 1)
+		go func() {
+			order, err := sox.NegotiateByteOrder(sc, c.b.order, c.b.required)
+			peer <- result{order, err}
+		}()
+		order, err := sox.NegotiateByteOrder(cc, c.a.order, c.a.required)
+		r := <-peer
+		_, _ = cc.Close(), sc.Close()
+		if err != c.err || r.err != c.err || order != c.expected || r.order != c.expected {
+			t.Errorf("%s expected %v but got %v and %v: %v, %v", c.name, c.expected, order, r.order, err, r.err)
+			return
+		}
+	}
+
+	t.Run("bad preamble", func(t *testing.T) {
+		cc, err := net.Dial("tcp4", l.Addr().String())
+		if err != nil {
+			t.Errorf("dial: %v", err)
+			return
+		}
+		defer cc.Close()
+		sc, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer sc.Close()
+		_, _ = sc.Write([]byte{0xfe, 0x00, 0x03, 'a'})
+		_, err = sox.NegotiateByteOrder(cc, nil, false)
+		protoErr := &sox.MessageProtocolError{}
+		if !errors.As(err, &protoErr) {
+			t.Errorf("negotiate expected MessageProtocolError but got %v", err)
+			return
+		}
+	})
+}
@@ -0,0 +1,125 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrCodecUnsupportedType will be returned when a codec cannot marshal or unmarshal the value
+	ErrCodecUnsupportedType = errors.New("codec unsupported type")
+)
+
+// Encoder is the interface that wraps the Encode method
+type Encoder interface {
+	// Encode appends the encoding of v to dst and returns the extended buffer
+	// dst is a buffer leased from the BufferPool, which is reallocated by
+	// append as usual if the encoding does not fit
+	Encode(dst []byte, v any) ([]byte, error)
+}
+
+// Decoder is the interface that wraps the Decode method
+type Decoder interface {
+	// Decode decodes data into v, which is typically a pointer
+	// data is only valid until Decode returns and must not be retained
+	Decode(data []byte, v any) error
+}
+
+// Codec is the interface that groups the Encode and Decode methods
+// Codecs of the serialization formats, e.g. Protocol Buffers or MessagePack,
+// are plugged into the message layer by MessageOptions.Codec
+type Codec interface {
+	Encoder
+	Decoder
+}
+
+// JSONCodec is the Codec of JSON with encoding/json
+var JSONCodec Codec = jsonCodec{}
+
+// BinaryCodec is the Codec of the values which implement
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+var BinaryCodec Codec = binaryCodec{}
+
+// MessageValueReader is the interface that groups the basic Read method and ReadInto method
+type MessageValueReader interface {
+	io.Reader
+	// ReadInto reads a whole message into a buffer leased from the BufferPool
+	// and decodes it into v with the Codec
+	ReadInto(v any) error
+}
+
+// MessageValueWriter is the interface that groups the basic Write method and WriteValue method
+type MessageValueWriter interface {
+	io.Writer
+	// WriteValue encodes v with the Codec into a buffer leased from the
+	// BufferPool and writes it as a message. A nonblocking WriteValue which
+	// returns ErrTemporarilyUnavailable must be retried with the same v
+	WriteValue(v any) error
+}
+
+func (msg *message) readInto(v any) error {
+	lease, err := msg.readLease()
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+
+	return msg.codec.Decode(lease.Bytes(), v)
+}
+
+func (msg *message) writeValue(v any) error {
+	lease := msg.pool.Get(BufferSizeSmall)
+	defer lease.Release()
+	b, err := msg.codec.Encode(lease.Bytes()[:0], v)
+	if err != nil {
+		return err
+	}
+	_, err = msg.write(b)
+
+	return err
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(dst []byte, v any) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	err := json.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return dst, err
+	}
+	// drop the newline appended by json.Encoder
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+func (jsonCodec) Decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Encode(dst []byte, v any) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return dst, ErrCodecUnsupportedType
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
+
+func (binaryCodec) Decode(data []byte, v any) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return ErrCodecUnsupportedType
+	}
+	return u.UnmarshalBinary(data)
+}
@@ -0,0 +1,106 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+type testCodecMessage struct {
+	ID   int      `json:"id"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// upperCodec is a Codec of strings plugged in to the message layer
+type upperCodec struct{}
+
+func (upperCodec) Encode(dst []byte, v any) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return dst, sox.ErrCodecUnsupportedType
+	}
+	return append(dst, strings.ToUpper(s)...), nil
+}
+
+func (upperCodec) Decode(data []byte, v any) error {
+	p, ok := v.(*string)
+	if !ok {
+		return sox.ErrCodecUnsupportedType
+	}
+	*p = string(data)
+	return nil
+}
+
+func TestMessageCodec(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w := sox.NewMessageWriter(buf).(sox.MessageValueWriter)
+		r := sox.NewMessageReader(buf).(sox.MessageValueReader)
+		msgs := []testCodecMessage{
+			{ID: 1, Name: "sox", Tags: []string{"a", "b"}},
+			// longer than the leased buffer of the encoding
+			{ID: 2, Name: strings.Repeat("x", 1<<16)},
+		}
+		for _, msg := range msgs {
+			if err := w.WriteValue(&msg); err != nil {
+				t.Errorf("write value: %v", err)
+				return
+			}
+		}
+		for _, expected := range msgs {
+			msg := testCodecMessage{}
+			if err := r.ReadInto(&msg); err != nil {
+				t.Errorf("read into: %v", err)
+				return
+			}
+			if msg.ID != expected.ID || msg.Name != expected.Name || len(msg.Tags) != len(expected.Tags) {
+				t.Errorf("read into expected %d but got %d", expected.ID, msg.ID)
+				return
+			}
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		opt := func(options *sox.MessageOptions) { options.Codec = sox.BinaryCodec }
+		w := sox.NewMessageWriter(buf, opt).(sox.MessageValueWriter)
+		r := sox.NewMessageReader(buf, opt).(sox.MessageValueReader)
+		addr := netip.MustParseAddr("fe80::1")
+		if err := w.WriteValue(addr); err != nil {
+			t.Errorf("write value: %v", err)
+			return
+		}
+		if err := w.WriteValue(1); err != sox.ErrCodecUnsupportedType {
+			t.Errorf("write value expected ErrCodecUnsupportedType but got %v", err)
+			return
+		}
+		got := netip.Addr{}
+		if err := r.ReadInto(&got); err != nil || got != addr {
+			t.Errorf("read into expected %v but got %v: %v", addr, got, err)
+			return
+		}
+	})
+
+	t.Run("plug-in", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		opt := func(options *sox.MessageOptions) { options.Codec = upperCodec{} }
+		w := sox.NewMessageWriter(buf, opt).(sox.MessageValueWriter)
+		r := sox.NewMessageReader(buf, opt).(sox.MessageValueReader)
+		if err := w.WriteValue("hello"); err != nil {
+			t.Errorf("write value: %v", err)
+			return
+		}
+		s := ""
+		if err := r.ReadInto(&s); err != nil || s != "HELLO" {
+			t.Errorf("read into expected HELLO but got %q: %v", s, err)
+			return
+		}
+	})
+}
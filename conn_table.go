@@ -0,0 +1,168 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ConnID identifies a connection in a ConnTable. The zero ConnID is never assigned
+type ConnID uint64
+
+type connEntry struct {
+	conn     Conn
+	userdata any
+}
+
+// ConnTable is a registry of connections which assigns each connection a ConnID
+// and stores the user data of the connection. The event loop keeps the accepted
+// connections in a ConnTable. All methods are safe to be called from any goroutine
+type ConnTable struct {
+	mu    sync.RWMutex
+	next  ConnID
+	conns map[ConnID]*connEntry
+}
+
+// NewConnTable creates and returns a new empty ConnTable
+func NewConnTable() *ConnTable {
+	return &ConnTable{conns: make(map[ConnID]*connEntry)}
+}
+
+// Add registers the conn with the userdata and returns the assigned ConnID
+func (t *ConnTable) Add(conn Conn, userdata any) ConnID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.conns[t.next] = &connEntry{conn: conn, userdata: userdata}
+
+	return t.next
+}
+
+// Remove unregisters the connection with the id and returns it
+func (t *ConnTable) Remove(id ConnID) (conn Conn, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.conns[id]
+	if !ok {
+		return nil, false
+	}
+	delete(t.conns, id)
+
+	return e.conn, true
+}
+
+// LookupByID returns the connection with the id
+func (t *ConnTable) LookupByID(id ConnID) (conn Conn, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	e, ok := t.conns[id]
+	if !ok {
+		return nil, false
+	}
+
+	return e.conn, true
+}
+
+// Userdata returns the user data of the connection with the id
+func (t *ConnTable) Userdata(id ConnID) (userdata any, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	e, ok := t.conns[id]
+	if !ok {
+		return nil, false
+	}
+
+	return e.userdata, true
+}
+
+// SetUserdata replaces the user data of the connection with the id
+// It returns false if there is no such connection
+func (t *ConnTable) SetUserdata(id ConnID, userdata any) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.conns[id]
+	if !ok {
+		return false
+	}
+	e.userdata = userdata
+
+	return true
+}
+
+// Len returns the number of the registered connections
+func (t *ConnTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.conns)
+}
+
+// Range calls fn for each registered connection until fn returns false
+// The table must not be modified by fn
+func (t *ConnTable) Range(fn func(id ConnID, conn Conn) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for id, e := range t.conns {
+		if !fn(id, e.conn) {
+			return
+		}
+	}
+}
+
+// Context returns a context derived from parent which carries the ConnID
+// and the user data of the connection. The user data can be taken by
+// ContextUserdata with its type, and the ConnID by ContextConnID
+func (t *ConnTable) Context(parent context.Context, id ConnID) context.Context {
+	ctx := ContextWithUserdata[ConnID](parent, id)
+	if userdata, ok := t.Userdata(id); ok && userdata != nil {
+		ctx = ContextWithUserdata[any](ctx, userdata)
+	}
+
+	return ctx
+}
+
+// ContextConnID returns the ConnID carried by ctx, or zero if there is none
+func ContextConnID(ctx context.Context) ConnID {
+	return ContextUserdata[ConnID](ctx)
+}
+
+// Broadcast frames the msg once with the message options and writes the frame
+// to each registered connection which filter returns true for. A nil filter
+// selects all of the connections. It returns the number of the connections
+// which the whole frame has been written to, and the joined write errors
+func (t *ConnTable) Broadcast(msg []byte, filter func(id ConnID, conn Conn) bool, opts ...func(options *MessageOptions)) (n int, err error) {
+	frame := bytes.Buffer{}
+	_, err = NewMessageWriter(&frame, append(opts, func(options *MessageOptions) {
+		options.Nonblock = false
+		options.Hooks = nil
+	})...).Write(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	targets := make([]Conn, 0, t.Len())
+	t.Range(func(id ConnID, conn Conn) bool {
+		if filter == nil || filter(id, conn) {
+			targets = append(targets, conn)
+		}
+		return true
+	})
+	errs := make([]error, 0)
+	for _, conn := range targets {
+		wn, werr := conn.Write(frame.Bytes())
+		if werr == nil && wn < frame.Len() {
+			werr = ErrMsgInvalidWrite
+		}
+		if werr != nil {
+			errs = append(errs, werr)
+			continue
+		}
+		n++
+	}
+
+	return n, errors.Join(errs...)
+}
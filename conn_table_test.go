@@ -0,0 +1,116 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestConnTable(t *testing.T) {
+	t.Run("add lookup remove", func(t *testing.T) {
+		table := sox.NewConnTable()
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		id1, id2 := table.Add(c1, "first"), table.Add(c2, nil)
+		if id1 == 0 || id1 == id2 || table.Len() != 2 {
+			t.Errorf("unexpected ids %d %d or len %d", id1, id2, table.Len())
+			return
+		}
+		conn, ok := table.LookupByID(id1)
+		if !ok || conn != c1 {
+			t.Errorf("lookup expected the first conn")
+			return
+		}
+		if !table.SetUserdata(id2, 42) {
+			t.Errorf("set userdata expected to succeed")
+			return
+		}
+		if data, _ := table.Userdata(id2); data != 42 {
+			t.Errorf("userdata expected 42 but got %v", data)
+			return
+		}
+		count := 0
+		table.Range(func(id sox.ConnID, conn sox.Conn) bool {
+			count++
+			return true
+		})
+		if count != 2 {
+			t.Errorf("range expected 2 conns but got %d", count)
+			return
+		}
+		conn, ok = table.Remove(id1)
+		if !ok || conn != c1 || table.Len() != 1 {
+			t.Errorf("remove expected the first conn")
+			return
+		}
+		if _, ok = table.LookupByID(id1); ok {
+			t.Errorf("lookup expected no conn after remove")
+			return
+		}
+		if table.SetUserdata(id1, 0) {
+			t.Errorf("set userdata expected to fail after remove")
+			return
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		table := sox.NewConnTable()
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		id := table.Add(c1, "userdata")
+		ctx := table.Context(context.Background(), id)
+		if sox.ContextConnID(ctx) != id {
+			t.Errorf("context expected conn id %d but got %d", id, sox.ContextConnID(ctx))
+			return
+		}
+		if data := sox.ContextUserdata[any](ctx); data != "userdata" {
+			t.Errorf("context expected userdata but got %v", data)
+			return
+		}
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		table := sox.NewConnTable()
+		ids, peers := make([]sox.ConnID, 3), make([]net.Conn, 3)
+		for i := range 3 {
+			c, peer := net.Pipe()
+			defer c.Close()
+			defer peer.Close()
+			ids[i], peers[i] = table.Add(c, i), peer
+		}
+		frames := make(chan []byte, 3)
+		for _, peer := range peers[:2] {
+			go func(peer net.Conn) {
+				p := make([]byte, 16)
+				n, _ := io.ReadAtLeast(peer, p, 4)
+				frames <- p[:n]
+			}(peer)
+		}
+		n, err := table.Broadcast([]byte("abc"), func(id sox.ConnID, conn sox.Conn) bool {
+			return id != ids[2]
+		})
+		if err != nil {
+			t.Errorf("broadcast: %v", err)
+			return
+		}
+		if n != 2 {
+			t.Errorf("broadcast expected 2 conns but got %d", n)
+			return
+		}
+		for range 2 {
+			frame := <-frames
+			if string(frame) != "\x03abc" {
+				t.Errorf("broadcast expected framed message but got %q", frame)
+				return
+			}
+		}
+	})
+}
@@ -11,7 +11,7 @@ type userdataGetter[T any] interface {
 }
 
 type userdataSetter[T any] interface {
-	setUserdata(data T)
+	setUserdata(data T) (ok bool)
 }
 
 type innerCtxGetter interface {
@@ -20,30 +20,58 @@ type innerCtxGetter interface {
 
 type userdataCtx[T any] struct {
 	context.Context
-	userdata T
+	userdata  T
+	immutable bool
 }
 
 func (ctx *userdataCtx[T]) getUserdata() T {
 	return ctx.userdata
 }
 
-func (ctx *userdataCtx[T]) setUserdata(data T) {
+func (ctx *userdataCtx[T]) setUserdata(data T) (ok bool) {
+	if ctx.immutable {
+		return false
+	}
 	ctx.userdata = data
+	return true
 }
 
 func (ctx *userdataCtx[T]) innerCtx() context.Context {
 	return ctx.Context
 }
 
+// ContextWithUserdata returns a context which carries the userdata of the type T
+// Each type T has one userdata, which ContextUserdata returns
+//
+// ContextWithUserdata mutates the parent instead of deriving a new context when
+// the parent is itself a context returned by ContextWithUserdata with the same
+// type T, so that the event loop replaces the userdata without allocations
+// The mutation is visible to all of the holders of the parent. Use
+// ContextWithImmutableUserdata when the parent may be shared
 func ContextWithUserdata[T any](parent context.Context, userdata T) context.Context {
-	if uc, ok := parent.(userdataSetter[T]); ok {
-		uc.setUserdata(userdata)
+	if uc, ok := parent.(userdataSetter[T]); ok && uc.setUserdata(userdata) {
 		return parent
 	}
 
 	return &userdataCtx[T]{Context: parent, userdata: userdata}
 }
 
+// ContextWithImmutableUserdata is like ContextWithUserdata but always derives a
+// new context, whose userdata is never mutated by the later ContextWithUserdata
+func ContextWithImmutableUserdata[T any](parent context.Context, userdata T) context.Context {
+	return &userdataCtx[T]{Context: parent, userdata: userdata, immutable: true}
+}
+
+// ContextDeleteUserdata returns a context derived from parent in which the
+// userdata of the type T is deleted, so that ContextUserdata returns the zero
+// value. The parent is never mutated
+func ContextDeleteUserdata[T any](parent context.Context) context.Context {
+	var zero T
+	return &userdataCtx[T]{Context: parent, userdata: zero, immutable: true}
+}
+
+// ContextUserdata returns the userdata of the type T carried by ctx, or the
+// zero value if there is none
 func ContextUserdata[T any](ctx context.Context) (ret T) {
 	for ctx != nil {
 		if uc, ok := ctx.(userdataGetter[T]); ok {
@@ -59,6 +87,53 @@ func ContextUserdata[T any](ctx context.Context) (ret T) {
 	return
 }
 
+// keyedUserdataKey is the context value key of the userdata of the key and the type T
+type keyedUserdataKey[K comparable, T any] struct {
+	key K
+}
+
+type keyedUserdataCtx[K comparable, T any] struct {
+	context.Context
+	key      K
+	userdata T
+	deleted  bool
+}
+
+func (ctx *keyedUserdataCtx[K, T]) Value(key any) any {
+	if k, ok := key.(keyedUserdataKey[K, T]); ok && k.key == ctx.key {
+		if ctx.deleted {
+			return nil
+		}
+		return ctx.userdata
+	}
+	return ctx.Context.Value(key)
+}
+
+func (ctx *keyedUserdataCtx[K, T]) innerCtx() context.Context {
+	return ctx.Context
+}
+
+// ContextWithUserdataKey returns a context derived from parent which carries
+// the userdata of the key and the type T, so that multiple userdata of the
+// same type can be stored with different keys. The parent is never mutated
+// The userdata is found through the contexts derived by the context package
+func ContextWithUserdataKey[K comparable, T any](parent context.Context, key K, userdata T) context.Context {
+	return &keyedUserdataCtx[K, T]{Context: parent, key: key, userdata: userdata}
+}
+
+// ContextDeleteUserdataKey returns a context derived from parent in which the
+// userdata of the key and the type T is deleted. The parent is never mutated
+func ContextDeleteUserdataKey[K comparable, T any](parent context.Context, key K) context.Context {
+	return &keyedUserdataCtx[K, T]{Context: parent, key: key, deleted: true}
+}
+
+// ContextUserdataKey returns the userdata of the key and the type T carried
+// by ctx, or the zero value if there is none
+func ContextUserdataKey[K comparable, T any](ctx context.Context, key K) (ret T) {
+	ret, _ = ctx.Value(keyedUserdataKey[K, T]{key: key}).(T)
+	return
+}
+
 type fdGetter interface {
 	getFD() (fd int)
 }
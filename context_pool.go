@@ -0,0 +1,74 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"sync"
+)
+
+// The event loop derives a context for each inbound message, which carries
+// the fd and the userdata of the connection. The contexts are recycled with
+// the pools below, so that the dispatch of the handlers is allocation-free
+//
+// The ownership rule is strict: the event loop acquires the contexts before
+// the dispatch and releases them after the handler has returned. Handlers
+// must not retain the context or use it from other goroutines after they
+// have returned, and must derive a context with the context package or
+// ContextWithImmutableUserdata to hand it over to other goroutines. The
+// released contexts are reset, so that they do not pin their parents and
+// userdata, and a released context panics on use
+
+var fdCtxPool = sync.Pool{
+	New: func() any {
+		return &fdCtx{}
+	},
+}
+
+// acquireFDCtx returns a pooled fdCtx which carries fd over parent
+func acquireFDCtx(parent context.Context, fd int) *fdCtx {
+	ctx := fdCtxPool.Get().(*fdCtx)
+	ctx.Context, ctx.fd = parent, fd
+	return ctx
+}
+
+// releaseFDCtx resets ctx and puts it back into the pool
+func releaseFDCtx(ctx *fdCtx) {
+	ctx.reset()
+	fdCtxPool.Put(ctx)
+}
+
+func (ctx *fdCtx) reset() {
+	ctx.Context, ctx.fd = nil, -1
+}
+
+// userdataCtxPool is a pool of the userdataCtx of the type T
+// The zero value is ready to use
+type userdataCtxPool[T any] struct {
+	pool sync.Pool
+}
+
+// acquire returns a pooled userdataCtx which carries userdata over parent
+// The returned context is mutable, so that the handlers can replace the
+// userdata with ContextWithUserdata without allocations
+func (p *userdataCtxPool[T]) acquire(parent context.Context, userdata T) *userdataCtx[T] {
+	ctx, ok := p.pool.Get().(*userdataCtx[T])
+	if !ok {
+		ctx = &userdataCtx[T]{}
+	}
+	ctx.Context, ctx.userdata = parent, userdata
+	return ctx
+}
+
+// release resets ctx and puts it back into the pool
+func (p *userdataCtxPool[T]) release(ctx *userdataCtx[T]) {
+	ctx.reset()
+	p.pool.Put(ctx)
+}
+
+func (ctx *userdataCtx[T]) reset() {
+	var zero T
+	ctx.Context, ctx.userdata, ctx.immutable = nil, zero, false
+}
@@ -0,0 +1,60 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextPool(t *testing.T) {
+	type connState struct {
+		id int
+	}
+	base := context.Background()
+	state := &connState{id: 5}
+	pool := userdataCtxPool[*connState]{}
+
+	t.Run("carry", func(t *testing.T) {
+		fc := acquireFDCtx(base, 7)
+		uc := pool.acquire(fc, state)
+		var ctx context.Context = uc
+		if fd := contextFD(fc); fd != 7 {
+			t.Errorf("expected fd=%d but got %d", 7, fd)
+			return
+		}
+		if s := ContextUserdata[*connState](ctx); s != state {
+			t.Errorf("expected userdata=%v but got %v", state, s)
+			return
+		}
+		ctx = ContextWithUserdata[*connState](ctx, &connState{id: 6})
+		if ctx != uc || ContextUserdata[*connState](ctx).id != 6 {
+			t.Errorf("expected the pooled context to be mutable")
+			return
+		}
+		pool.release(uc)
+		releaseFDCtx(fc)
+		if uc.Context != nil || uc.userdata != nil || fc.Context != nil || fc.fd != -1 {
+			t.Errorf("expected the released contexts to be reset")
+			return
+		}
+	})
+
+	t.Run("allocation free", func(t *testing.T) {
+		n := testing.AllocsPerRun(1000, func() {
+			fc := acquireFDCtx(base, 7)
+			uc := pool.acquire(fc, state)
+			if ContextUserdata[*connState](uc) != state {
+				panic("unexpected userdata")
+			}
+			pool.release(uc)
+			releaseFDCtx(fc)
+		})
+		if n > 0 {
+			t.Errorf("expected no allocation but got %v", n)
+			return
+		}
+	})
+}
@@ -182,3 +182,91 @@ func TestContextWithUserdata(t *testing.T) {
 		}
 	})
 }
+
+func TestContextWithImmutableUserdata(t *testing.T) {
+	t.Run("mutation", func(t *testing.T) {
+		parent := sox.ContextWithUserdata[int](context.Background(), 5)
+		ctx := sox.ContextWithUserdata[int](parent, 10)
+		if ctx != parent || sox.ContextUserdata[int](parent) != 10 {
+			t.Errorf("expected the parent to be mutated but got %v", sox.ContextUserdata[int](parent))
+			return
+		}
+	})
+
+	t.Run("immutable", func(t *testing.T) {
+		parent := sox.ContextWithImmutableUserdata[int](context.Background(), 5)
+		ctx := sox.ContextWithUserdata[int](parent, 10)
+		if val := sox.ContextUserdata[int](parent); val != 5 {
+			t.Errorf("expected val=%v but got %v", 5, val)
+			return
+		}
+		if val := sox.ContextUserdata[int](ctx); val != 10 {
+			t.Errorf("expected val=%v but got %v", 10, val)
+			return
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		parent := sox.ContextWithUserdata[string](context.Background(), "sox")
+		parent = sox.ContextWithUserdata[int](parent, 5)
+		ctx := sox.ContextDeleteUserdata[string](parent)
+		if val := sox.ContextUserdata[string](ctx); val != "" {
+			t.Errorf("expected val=\"%v\" but got \"%v\"", "", val)
+			return
+		}
+		if val := sox.ContextUserdata[int](ctx); val != 5 {
+			t.Errorf("expected val=%v but got %v", 5, val)
+			return
+		}
+		if val := sox.ContextUserdata[string](parent); val != "sox" {
+			t.Errorf("expected val=\"%v\" but got \"%v\"", "sox", val)
+			return
+		}
+		ctx = sox.ContextWithUserdata[string](ctx, "io-library")
+		if val := sox.ContextUserdata[string](ctx); val != "io-library" {
+			t.Errorf("expected val=\"%v\" but got \"%v\"", "io-library", val)
+			return
+		}
+	})
+}
+
+func TestContextWithUserdataKey(t *testing.T) {
+	type traceKey struct{}
+	ctx := sox.ContextWithUserdataKey(context.Background(), "tenant", "hayabusa")
+	ctx = sox.ContextWithUserdataKey(ctx, "trace", "4bf92f35")
+	ctx = sox.ContextWithUserdataKey(ctx, traceKey{}, 7)
+	ctx = sox.ContextWithUserdata[string](ctx, "sox")
+	// the userdata is found through the contexts of the context package
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if val := sox.ContextUserdataKey[string, string](ctx, "tenant"); val != "hayabusa" {
+		t.Errorf("expected val=\"%v\" but got \"%v\"", "hayabusa", val)
+		return
+	}
+	if val := sox.ContextUserdataKey[string, string](ctx, "trace"); val != "4bf92f35" {
+		t.Errorf("expected val=\"%v\" but got \"%v\"", "4bf92f35", val)
+		return
+	}
+	if val := sox.ContextUserdataKey[traceKey, int](ctx, traceKey{}); val != 7 {
+		t.Errorf("expected val=%v but got %v", 7, val)
+		return
+	}
+	if val := sox.ContextUserdataKey[string, int](ctx, "tenant"); val != 0 {
+		t.Errorf("expected val=%v but got %v", 0, val)
+		return
+	}
+
+	deleted := sox.ContextDeleteUserdataKey[string, string](ctx, "tenant")
+	if val := sox.ContextUserdataKey[string, string](deleted, "tenant"); val != "" {
+		t.Errorf("expected val=\"%v\" but got \"%v\"", "", val)
+		return
+	}
+	if val := sox.ContextUserdataKey[string, string](deleted, "trace"); val != "4bf92f35" {
+		t.Errorf("expected val=\"%v\" but got \"%v\"", "4bf92f35", val)
+		return
+	}
+	if val := sox.ContextUserdataKey[string, string](ctx, "tenant"); val != "hayabusa" {
+		t.Errorf("expected val=\"%v\" but got \"%v\"", "hayabusa", val)
+		return
+	}
+}
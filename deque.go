@@ -0,0 +1,140 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	defaultDequeCapacity = 1 << 8
+)
+
+// NewDeque creates and returns an unbounded work-stealing Deque with the given options
+func NewDeque[ItemType any](opts ...func(options *DequeOptions)) (*Deque[ItemType], error) {
+	o := &DequeOptions{
+		Capacity: defaultDequeCapacity,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	if o.Capacity < 1 || o.Capacity >= (1<<30) {
+		return nil, errors.New("invalid deque capacity")
+	}
+	o.Capacity |= o.Capacity >> 1
+	o.Capacity |= o.Capacity >> 2
+	o.Capacity |= o.Capacity >> 4
+	o.Capacity |= o.Capacity >> 8
+	o.Capacity |= o.Capacity >> 16
+	d := &Deque[ItemType]{DequeOptions: o}
+	d.array.Store(newDequeArray[ItemType](o.Capacity + 1))
+
+	return d, nil
+}
+
+// DequeOptions holds optional parameters for Deque
+type DequeOptions struct {
+	// Capacity specifies the initial capacity of the Deque, which grows
+	// as many times as needed. The default Capacity is 256
+	Capacity int
+}
+
+// Deque is a Chase-Lev work-stealing deque of the pointers to items
+// The owner goroutine, e.g. a worker goroutine of the event loop, pushes
+// and pops the items at the bottom in LIFO order, and any other goroutine
+// steals the items from the top in FIFO order, so that an idle worker
+// takes over the oldest pending work of a busy one
+//
+// Push and Pop must only be called by the owner goroutine. Steal and Len
+// are safe to be called from any goroutine. The stolen slots keep their
+// pointers until the owner overwrites them
+type Deque[T any] struct {
+	*DequeOptions
+	top    atomic.Int64
+	bottom atomic.Int64
+	array  atomic.Pointer[dequeArray[T]]
+}
+
+type dequeArray[T any] struct {
+	mask  int64
+	slots []atomic.Pointer[T]
+}
+
+func newDequeArray[T any](size int) *dequeArray[T] {
+	return &dequeArray[T]{mask: int64(size - 1), slots: make([]atomic.Pointer[T], size)}
+}
+
+// Push inserts the item at the bottom of the Deque. A nil item is ignored
+// Push must only be called by the owner goroutine
+func (d *Deque[T]) Push(item *T) {
+	if item == nil {
+		return
+	}
+	b, t, a := d.bottom.Load(), d.top.Load(), d.array.Load()
+	if b-t > a.mask {
+		// the thieves may still read the old array, which
+		// is left as it is and collected after them
+		na := newDequeArray[T](len(a.slots) << 1)
+		for i := t; i < b; i++ {
+			na.slots[i&na.mask].Store(a.slots[i&a.mask].Load())
+		}
+		d.array.Store(na)
+		a = na
+	}
+	a.slots[b&a.mask].Store(item)
+	d.bottom.Store(b + 1)
+}
+
+// Pop removes and returns the item at the bottom of the Deque
+// It returns nil if the Deque is empty
+// Pop must only be called by the owner goroutine
+func (d *Deque[T]) Pop() *T {
+	b := d.bottom.Load() - 1
+	a := d.array.Load()
+	d.bottom.Store(b)
+	t := d.top.Load()
+	if t > b {
+		d.bottom.Store(b + 1)
+		return nil
+	}
+	item := a.slots[b&a.mask].Load()
+	if t < b {
+		a.slots[b&a.mask].Store(nil)
+		return item
+	}
+	// the last item races with the thieves
+	if !d.top.CompareAndSwap(t, t+1) {
+		item = nil
+	}
+	d.bottom.Store(b + 1)
+
+	return item
+}
+
+// Steal removes and returns the item at the top of the Deque
+// It returns nil if the Deque is empty
+func (d *Deque[T]) Steal() *T {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		t := d.top.Load()
+		b := d.bottom.Load()
+		if t >= b {
+			return nil
+		}
+		a := d.array.Load()
+		item := a.slots[t&a.mask].Load()
+		if d.top.CompareAndSwap(t, t+1) {
+			return item
+		}
+		sw.Once()
+	}
+}
+
+// Len returns the number of the items in the Deque
+// The result may be stale if any other goroutine is using the Deque
+func (d *Deque[T]) Len() int {
+	return int(max(d.bottom.Load()-d.top.Load(), 0))
+}
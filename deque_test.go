@@ -0,0 +1,130 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"fmt"
+	"hybscloud.com/sox"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDeque_Series(t *testing.T) {
+	d, err := sox.NewDeque[int](func(options *sox.DequeOptions) {
+		options.Capacity = 0x3
+	})
+	if err != nil {
+		t.Errorf("deque new: %v", err)
+		return
+	}
+	if item := d.Pop(); item != nil {
+		t.Errorf("deque pop expected nil but got %d", *item)
+		return
+	}
+	if item := d.Steal(); item != nil {
+		t.Errorf("deque steal expected nil but got %d", *item)
+		return
+	}
+	// more than the initial capacity to grow
+	items := make([]int, 0x10)
+	for i := range items {
+		items[i] = i
+		d.Push(&items[i])
+	}
+	if d.Len() != len(items) {
+		t.Errorf("deque len expected %d but got %d", len(items), d.Len())
+		return
+	}
+	for i := range 0x8 {
+		if item := d.Steal(); item == nil || *item != i {
+			t.Errorf("deque steal expected %d but got %v", i, item)
+			return
+		}
+	}
+	for i := 0xf; i >= 0x8; i-- {
+		if item := d.Pop(); item == nil || *item != i {
+			t.Errorf("deque pop expected %d but got %v", i, item)
+			return
+		}
+	}
+	if item := d.Pop(); item != nil {
+		t.Errorf("deque pop expected nil but got %d", *item)
+		return
+	}
+	if d.Len() != 0 {
+		t.Errorf("deque len expected 0 but got %d", d.Len())
+		return
+	}
+}
+
+func TestDeque_Concurrent(t *testing.T) {
+	for _, thieves := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("%d thieves", thieves), func(t *testing.T) {
+			d, err := sox.NewDeque[int](func(options *sox.DequeOptions) {
+				options.Capacity = 0x10
+			})
+			if err != nil {
+				t.Errorf("deque new: %v", err)
+				return
+			}
+			const n = 1 << 16
+			items, taken := make([]int, n), make([]atomic.Int32, n)
+			take := func(item *int) {
+				if taken[*item].Add(1) != 1 {
+					t.Errorf("deque item %d taken twice", *item)
+				}
+			}
+			done := atomic.Bool{}
+			wg := sync.WaitGroup{}
+			for range thieves {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for !done.Load() || d.Len() > 0 {
+						if item := d.Steal(); item != nil {
+							take(item)
+						}
+					}
+				}()
+			}
+			// the owner pops one item every four pushes
+			for i := range items {
+				items[i] = i
+				d.Push(&items[i])
+				if i&3 == 3 {
+					if item := d.Pop(); item != nil {
+						take(item)
+					}
+				}
+			}
+			for item := d.Pop(); item != nil; item = d.Pop() {
+				take(item)
+			}
+			done.Store(true)
+			wg.Wait()
+			for i := range taken {
+				if taken[i].Load() != 1 {
+					t.Errorf("deque item %d taken %d times", i, taken[i].Load())
+					return
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeque(b *testing.B) {
+	d, err := sox.NewDeque[int]()
+	if err != nil {
+		b.Errorf("deque new: %v", err)
+		return
+	}
+	item := 1
+	b.ResetTimer()
+	for range b.N {
+		d.Push(&item)
+		d.Pop()
+	}
+}
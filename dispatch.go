@@ -0,0 +1,90 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Workers describes the worker goroutines of the event loop to the WorkerDispatchers
+type Workers interface {
+	// Len returns the number of the worker goroutines
+	Len() int
+	// Pending returns the number of the messages queued on the i-th worker goroutine
+	Pending(i int) int
+}
+
+// WorkerDispatcher is a DispatchHandler which also chooses the worker goroutine
+// When Parallel >= 2, the event loop hands the incoming message over to the
+// worker goroutine returned by DispatchWorker, which must be in [0, workers.Len())
+type WorkerDispatcher interface {
+	DispatchHandler
+	DispatchWorker(ctx context.Context, reader PollReader, workers Workers) int
+}
+
+// FdHash is a WorkerDispatcher which hands the messages of a connection
+// over to the same worker goroutine, chosen by the fd of the connection
+// The zero value dispatches to Handler
+type FdHash struct {
+	Handler MessageHandler
+}
+
+// ServeDispatch returns the Handler
+func (d *FdHash) ServeDispatch(ctx context.Context, reader PollReader) MessageHandler {
+	return d.Handler
+}
+
+// DispatchWorker returns the worker goroutine of the fd of the reader
+func (d *FdHash) DispatchWorker(ctx context.Context, reader PollReader, workers Workers) int {
+	return int(uint(reader.Fd()) % uint(workers.Len()))
+}
+
+// RoundRobin is a WorkerDispatcher which hands the messages over to
+// the worker goroutines in turn regardless of the connections
+// The zero value dispatches to Handler
+type RoundRobin struct {
+	Handler MessageHandler
+	next    atomic.Uint32
+}
+
+// ServeDispatch returns the Handler
+func (d *RoundRobin) ServeDispatch(ctx context.Context, reader PollReader) MessageHandler {
+	return d.Handler
+}
+
+// DispatchWorker returns the next worker goroutine
+func (d *RoundRobin) DispatchWorker(ctx context.Context, reader PollReader, workers Workers) int {
+	return int((d.next.Add(1) - 1) % uint32(workers.Len()))
+}
+
+// LeastPending is a WorkerDispatcher which hands the messages over to the
+// worker goroutine with the fewest queued messages. The ties are broken in
+// turn, so that the idle worker goroutines are loaded evenly
+// The zero value dispatches to Handler
+type LeastPending struct {
+	Handler MessageHandler
+	next    atomic.Uint32
+}
+
+// ServeDispatch returns the Handler
+func (d *LeastPending) ServeDispatch(ctx context.Context, reader PollReader) MessageHandler {
+	return d.Handler
+}
+
+// DispatchWorker returns the worker goroutine with the fewest queued messages
+func (d *LeastPending) DispatchWorker(ctx context.Context, reader PollReader, workers Workers) int {
+	n := workers.Len()
+	start := int((d.next.Add(1) - 1) % uint32(n))
+	least, pending := start, workers.Pending(start)
+	for j := 1; j < n && pending > 0; j++ {
+		i := (start + j) % n
+		if p := workers.Pending(i); p < pending {
+			least, pending = i, p
+		}
+	}
+
+	return least
+}
@@ -0,0 +1,78 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"hybscloud.com/sox"
+	"testing"
+)
+
+type testWorkers []int
+
+func (w testWorkers) Len() int          { return len(w) }
+func (w testWorkers) Pending(i int) int { return w[i] }
+
+type testFdReader int
+
+func (r testFdReader) Fd() int                    { return int(r) }
+func (r testFdReader) Read(p []byte) (int, error) { return 0, nil }
+
+func TestWorkerDispatcher(t *testing.T) {
+	ctx := context.Background()
+	t.Run("fd hash", func(t *testing.T) {
+		d, workers := &sox.FdHash{}, testWorkers{0, 0, 0, 0}
+		for fd := range 16 {
+			i, j := d.DispatchWorker(ctx, testFdReader(fd), workers), d.DispatchWorker(ctx, testFdReader(fd), workers)
+			if i != j || i != fd%4 {
+				t.Errorf("fd hash of fd %d expected worker %d but got %d and %d", fd, fd%4, i, j)
+				return
+			}
+		}
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		d, workers := &sox.RoundRobin{}, testWorkers{0, 0, 0}
+		for k := range 9 {
+			if i := d.DispatchWorker(ctx, testFdReader(5), workers); i != k%3 {
+				t.Errorf("round robin expected worker %d but got %d", k%3, i)
+				return
+			}
+		}
+	})
+
+	t.Run("least pending", func(t *testing.T) {
+		d, workers := &sox.LeastPending{}, testWorkers{3, 1, 2, 1}
+		for range 8 {
+			if i := d.DispatchWorker(ctx, testFdReader(5), workers); i != 1 && i != 3 {
+				t.Errorf("least pending expected worker 1 or 3 but got %d", i)
+				return
+			}
+		}
+		workers = testWorkers{0, 0, 0}
+		seen := [3]bool{}
+		for range 3 {
+			seen[d.DispatchWorker(ctx, testFdReader(5), workers)] = true
+		}
+		if seen != [3]bool{true, true, true} {
+			t.Errorf("least pending expected the idle workers in turn but got %v", seen)
+			return
+		}
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		h := &testMessageHandler{}
+		var d sox.WorkerDispatcher = &sox.FdHash{Handler: h}
+		if d.ServeDispatch(ctx, testFdReader(5)) != h {
+			t.Errorf("fd hash expected the handler")
+			return
+		}
+	})
+}
+
+type testMessageHandler struct{}
+
+func (h *testMessageHandler) ServeMessage(ctx context.Context, reply sox.PollWriter, request sox.PollReader) {
+}
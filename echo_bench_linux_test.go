@@ -0,0 +1,315 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"io"
+	"testing"
+)
+
+// The echo benchmarks measure the round trips of the messages between a client
+// and an echo server over each transport, with the readiness of the poller and
+// with the completions of io-uring. Run them with
+//
+//	go test -run NONE -bench BenchmarkEcho -benchmem -count 10
+//
+// and compare the results with benchstat to tell performance regressions
+
+type echoConn interface {
+	sox.Conn
+	Fd() int
+}
+
+// pollConn waits for the readiness of the non-blocking conn with its own poller
+type pollConn struct {
+	echoConn
+	p      *sox.Poller
+	events []sox.PollEvent
+}
+
+func newPollConn(conn echoConn) (*pollConn, error) {
+	p, err := sox.NewPoller()
+	if err != nil {
+		return nil, err
+	}
+	err = p.Add(conn.Fd(), sox.PollIn|sox.PollOut|sox.PollEdgeTriggered)
+	if err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	return &pollConn{echoConn: conn, p: p, events: make([]sox.PollEvent, 1)}, nil
+}
+
+func (c *pollConn) Read(p []byte) (n int, err error) {
+	for {
+		n, err = c.echoConn.Read(p)
+		if err != sox.ErrTemporarilyUnavailable {
+			return
+		}
+		_, err = c.p.Wait(c.events, -1)
+		if err != nil && err != sox.ErrInterruptedSyscall {
+			return 0, err
+		}
+	}
+}
+
+func (c *pollConn) Write(p []byte) (n int, err error) {
+	for {
+		wn, err := c.echoConn.Write(p[n:])
+		n += wn
+		if err != sox.ErrTemporarilyUnavailable {
+			return n, err
+		}
+		_, err = c.p.Wait(c.events, -1)
+		if err != nil && err != sox.ErrInterruptedSyscall {
+			return n, err
+		}
+	}
+}
+
+// Close closes the poller but leaves the conn open
+func (c *pollConn) Close() error {
+	return c.p.Close()
+}
+
+// uringConn reads and writes the conn with the operations of io-uring
+type uringConn struct {
+	fd  int
+	aio *sox.AsyncIO
+}
+
+func (c *uringConn) Read(p []byte) (n int, err error) {
+	r, err := c.aio.ReadAsync(context.Background(), c.fd, p)
+	if err != nil {
+		return 0, err
+	}
+	n, err = r.Wait(context.Background())
+	if err == nil && n == 0 {
+		return 0, io.EOF
+	}
+	return
+}
+
+func (c *uringConn) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		r, err := c.aio.WriteAsync(context.Background(), c.fd, p[n:])
+		if err != nil {
+			return n, err
+		}
+		wn, err := r.Wait(context.Background())
+		n += wn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+type echoTransport struct {
+	name string
+	dial func() (client, server echoConn, err error)
+	opts func(options *sox.MessageOptions)
+}
+
+var echoTransports = []echoTransport{
+	{"tcp", dialEchoTCP, sox.MessageOptionsTCPSocket},
+	{"unix", dialEchoUnix, func(options *sox.MessageOptions) {
+		options.ReadProto = sox.UnderlyingProtocolSeqPacket
+		options.WriteProto = sox.UnderlyingProtocolSeqPacket
+	}},
+	{"sctp", dialEchoSCTP, sox.MessageOptionsSCTPSocket},
+}
+
+func BenchmarkEcho(b *testing.B) {
+	for _, transport := range echoTransports {
+		b.Run(transport.name, func(b *testing.B) {
+			client, server, err := transport.dial()
+			if err != nil {
+				b.Skipf("dial %s: %v", transport.name, err)
+				return
+			}
+			defer client.Close()
+			defer server.Close()
+			for _, size := range []int{64, 1024} {
+				b.Run(fmt.Sprintf("poller %d bytes", size), func(b *testing.B) {
+					benchmarkEchoPoller(b, client, server, transport.opts, size)
+				})
+				b.Run(fmt.Sprintf("io-uring %d bytes", size), func(b *testing.B) {
+					benchmarkEchoUring(b, client, server, transport.opts, size)
+				})
+			}
+		})
+	}
+}
+
+func benchmarkEchoPoller(b *testing.B, client, server echoConn, opts func(options *sox.MessageOptions), size int) {
+	cc, err := newPollConn(client)
+	if err != nil {
+		b.Errorf("new poll conn: %v", err)
+		return
+	}
+	defer cc.Close()
+	sc, err := newPollConn(server)
+	if err != nil {
+		b.Errorf("new poll conn: %v", err)
+		return
+	}
+	defer sc.Close()
+	benchmarkEcho(b, cc, sc, opts, size)
+}
+
+func benchmarkEchoUring(b *testing.B, client, server echoConn, opts func(options *sox.MessageOptions), size int) {
+	aio, err := sox.NewAsyncIO()
+	if err != nil {
+		b.Skipf("new async io: %v", err)
+		return
+	}
+	defer aio.Close()
+	benchmarkEcho(b, &uringConn{fd: client.Fd(), aio: aio}, &uringConn{fd: server.Fd(), aio: aio}, opts, size)
+}
+
+// benchmarkEcho performs b.N round trips of the messages of size bytes
+func benchmarkEcho(b *testing.B, client, server io.ReadWriter, opts func(options *sox.MessageOptions), size int) {
+	done := make(chan error, 1)
+	go func() {
+		rw := sox.NewMessageReadWriter(server, server, opts)
+		buf := make([]byte, size)
+		for range b.N {
+			n, err := rw.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			_, err = rw.Write(buf[:n])
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	rw := sox.NewMessageReadWriter(client, client, opts)
+	p, buf := make([]byte, size), make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for range b.N {
+		_, err := rw.Write(p)
+		if err != nil {
+			b.Errorf("write message: %v", err)
+			return
+		}
+		n, err := rw.Read(buf)
+		if err != nil || n != size {
+			b.Errorf("read message expected %d bytes but got %d: %v", size, n, err)
+			return
+		}
+	}
+	b.StopTimer()
+	if err := <-done; err != nil {
+		b.Errorf("echo server: %v", err)
+	}
+}
+
+func dialEchoTCP() (client, server echoConn, err error) {
+	laddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8208")
+	if err != nil {
+		return nil, nil, err
+	}
+	lis, err := sox.ListenTCP4(laddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lis.Close()
+	raddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8209")
+	if err != nil {
+		return nil, nil, err
+	}
+	client, server, err = dialEcho(lis, func() (echoConn, error) { return sox.DialTCP4(raddr, laddr) })
+	if err != nil {
+		return nil, nil, err
+	}
+	// the header and the payload of a message are written separately
+	// which would be delayed by the Nagle's algorithm
+	for _, fd := range []int{client.Fd(), server.Fd()} {
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1)
+	}
+	return client, server, nil
+}
+
+func dialEchoUnix() (client, server echoConn, err error) {
+	laddr, err := sox.ResolveUnixAddr("unixpacket", "@sox-bench-echo")
+	if err != nil {
+		return nil, nil, err
+	}
+	lis, err := sox.ListenUnix(laddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lis.Close()
+	raddr, err := sox.ResolveUnixAddr("unixpacket", "@")
+	if err != nil {
+		return nil, nil, err
+	}
+	return dialEcho(lis, func() (echoConn, error) { return sox.DialUnix(raddr, laddr) })
+}
+
+func dialEchoSCTP() (client, server echoConn, err error) {
+	laddr, err := sox.ResolveSCTPAddr("sctp4", "127.0.0.1:8208")
+	if err != nil {
+		return nil, nil, err
+	}
+	lis, err := sox.ListenSCTP4(laddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lis.Close()
+	raddr, err := sox.ResolveSCTPAddr("sctp4", "127.0.0.1:8209")
+	if err != nil {
+		return nil, nil, err
+	}
+	client, server, err = dialEcho(lis, func() (echoConn, error) { return sox.DialSCTP4(raddr, laddr) })
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, fd := range []int{client.Fd(), server.Fd()} {
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_SCTP, sox.SCTP_NODELAY, 1)
+	}
+	return client, server, nil
+}
+
+func dialEcho(lis sox.Listener, dial func() (echoConn, error)) (client, server echoConn, err error) {
+	accepted := make(chan sox.Conn, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+	}()
+	client, err = dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn := <-accepted
+	if conn == nil {
+		_ = client.Close()
+		return nil, nil, io.ErrClosedPipe
+	}
+	server, ok := conn.(echoConn)
+	if !ok {
+		_ = conn.Close()
+		_ = client.Close()
+		return nil, nil, sox.ErrInvalidParam
+	}
+	return client, server, nil
+}
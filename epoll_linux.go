@@ -13,34 +13,50 @@ import (
 )
 
 type epoll struct {
-	fd   int
-	evts []unix.EpollEvent
+	fd        int
+	maxEvents int
 }
 
+// newPoller creates an epoll which reports at most n events per wait
 func newPoller(n int) (*epoll, error) {
 	if n < 1 {
 		return nil, ErrInvalidParam
 	}
-	evts := make([]unix.EpollEvent, n)
 
 	fd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
 	if err != nil {
 		return nil, errFromUnixErrno(err)
 	}
 
-	return &epoll{fd: fd, evts: evts}, nil
+	return &epoll{fd: fd, maxEvents: n}, nil
 }
 
 func (ep *epoll) FD() int {
 	return ep.fd
 }
 
+// add registers fd with the events. The events are level-triggered unless
+// pollerEventEdgeTriggered is set. Peer's shutdown of writing is always
+// reported as pollerEventRdHup together with pollerEventIn
 func (ep *epoll) add(fd int, events uint32) error {
+	return ep.ctl(unix.EPOLL_CTL_ADD, fd, events)
+}
+
+// mod changes the events of the registered fd. It also re-arms
+// the fd which has been disabled by pollerEventOneShot
+func (ep *epoll) mod(fd int, events uint32) error {
+	return ep.ctl(unix.EPOLL_CTL_MOD, fd, events)
+}
+
+func (ep *epoll) ctl(op int, fd int, events uint32) error {
+	if events&pollerEventIn != 0 {
+		events |= pollerEventRdHup
+	}
 	evt := &unix.EpollEvent{
-		Events: events | unix.EPOLLET,
+		Events: events,
 		Fd:     int32(fd),
 	}
-	err := unix.EpollCtl(ep.fd, unix.EPOLL_CTL_ADD, fd, evt)
+	err := unix.EpollCtl(ep.fd, op, fd, evt)
 	if err != nil {
 		return errFromUnixErrno(err)
 	}
@@ -57,15 +73,23 @@ func (ep *epoll) del(fd int) error {
 	return nil
 }
 
-func (ep *epoll) wait(d time.Duration) (events []pollerEvent, err error) {
-	n, err := unix.EpollWait(ep.fd, ep.evts, int(d.Milliseconds()))
+// wait waits for the events into the caller owned events, so that the same
+// events can be reused by the polling loop without allocation
+func (ep *epoll) wait(events []PollEvent, d time.Duration) (n int, err error) {
+	if len(events) < 1 {
+		return 0, ErrInvalidParam
+	}
+	evts := unsafe.Slice((*unix.EpollEvent)(unsafe.Pointer(unsafe.SliceData(events))), min(len(events), ep.maxEvents))
+	msec := -1
+	if d >= 0 {
+		msec = int((d + time.Millisecond - 1) / time.Millisecond)
+	}
+	n, err = unix.EpollWait(ep.fd, evts, msec)
 	if err != nil {
-		return events, errFromUnixErrno(err)
+		return 0, errFromUnixErrno(err)
 	}
-	ptr := (*pollerEvent)(unsafe.Pointer(&ep.evts[0]))
-	events = unsafe.Slice(ptr, n)
 
-	return
+	return n, nil
 }
 
 func (ep *epoll) Close() error {
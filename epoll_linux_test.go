@@ -7,6 +7,7 @@
 package sox
 
 import (
+	"golang.org/x/sys/unix"
 	"testing"
 	"time"
 )
@@ -42,8 +43,9 @@ func TestEpoll(t *testing.T) {
 		return
 	}
 
-	d := time.Millisecond * 200
-	events, err := ep.wait(d)
+	d, buf := time.Millisecond*200, make([]PollEvent, 16)
+	n, err := ep.wait(buf, d)
+	events := buf[:n]
 	if err != nil {
 		t.Errorf("epoll wait: %v", err)
 		return
@@ -52,12 +54,13 @@ func TestEpoll(t *testing.T) {
 		t.Errorf("epoll wait expected event num=%d but got %v", 0, events)
 		return
 	}
-	err = ep.add(efd1.Fd(), pollerEventIn)
+	err = ep.add(efd1.Fd(), pollerEventIn|pollerEventEdgeTriggered)
 	if err != nil {
 		t.Errorf("epoll add fd=%d: %v", efd1.Fd(), err)
 		return
 	}
-	events, err = ep.wait(d)
+	n, err = ep.wait(buf, d)
+	events = buf[:n]
 	if err != nil {
 		t.Errorf("epoll wait: %v", err)
 		return
@@ -71,12 +74,13 @@ func TestEpoll(t *testing.T) {
 		return
 	}
 
-	err = ep.add(efd2.Fd(), pollerEventIn)
+	err = ep.add(efd2.Fd(), pollerEventIn|pollerEventEdgeTriggered)
 	if err != nil {
 		t.Errorf("epoll add fd=%d: %v", efd2.Fd(), err)
 		return
 	}
-	events, err = ep.wait(d)
+	n, err = ep.wait(buf, d)
+	events = buf[:n]
 	if err != nil {
 		t.Errorf("epoll wait: %v", err)
 		return
@@ -100,7 +104,8 @@ func TestEpoll(t *testing.T) {
 		t.Errorf("event fd write: %v", err)
 		return
 	}
-	events, err = ep.wait(d)
+	n, err = ep.wait(buf, d)
+	events = buf[:n]
 	if err != nil {
 		t.Errorf("epoll wait: %v", err)
 		return
@@ -131,7 +136,8 @@ func TestEpoll(t *testing.T) {
 		return
 	}
 	for {
-		events, err = ep.wait(d)
+		n, err = ep.wait(buf, d)
+		events = buf[:n]
 		if err == ErrInterruptedSyscall {
 			continue
 		}
@@ -146,3 +152,191 @@ func TestEpoll(t *testing.T) {
 		return
 	}
 }
+
+func TestEpoll_Mod(t *testing.T) {
+	ep, err := newPoller(16)
+	if err != nil {
+		t.Errorf("new epoll: %v", err)
+		return
+	}
+	defer ep.Close()
+	efd, err := NewEventfd()
+	if err != nil {
+		t.Errorf("new event fd: %v", err)
+		return
+	}
+	defer efd.Close()
+	err = efd.WriteUint(1)
+	if err != nil {
+		t.Errorf("event fd write: %v", err)
+		return
+	}
+	buf := make([]PollEvent, 16)
+	wait := func(expected int) bool {
+		for {
+			n, err := ep.wait(buf, time.Millisecond*100)
+			events := buf[:n]
+			if err == ErrInterruptedSyscall {
+				continue
+			}
+			if err != nil {
+				t.Errorf("epoll wait: %v", err)
+				return false
+			}
+			if len(events) != expected {
+				t.Errorf("epoll wait expected event num=%d but got %v", expected, events)
+				return false
+			}
+			return true
+		}
+	}
+
+	t.Run("level triggered", func(t *testing.T) {
+		err := ep.add(efd.Fd(), pollerEventIn)
+		if err != nil {
+			t.Errorf("epoll add fd=%d: %v", efd.Fd(), err)
+			return
+		}
+		if !wait(1) || !wait(1) {
+			return
+		}
+	})
+	t.Run("oneshot", func(t *testing.T) {
+		err := ep.mod(efd.Fd(), pollerEventIn|pollerEventOneShot)
+		if err != nil {
+			t.Errorf("epoll mod fd=%d: %v", efd.Fd(), err)
+			return
+		}
+		if !wait(1) || !wait(0) {
+			return
+		}
+		err = ep.mod(efd.Fd(), pollerEventIn|pollerEventOneShot)
+		if err != nil {
+			t.Errorf("epoll mod fd=%d: %v", efd.Fd(), err)
+			return
+		}
+		if !wait(1) || !wait(0) {
+			return
+		}
+	})
+	t.Run("edge triggered", func(t *testing.T) {
+		err := ep.mod(efd.Fd(), pollerEventIn|pollerEventEdgeTriggered)
+		if err != nil {
+			t.Errorf("epoll mod fd=%d: %v", efd.Fd(), err)
+			return
+		}
+		if !wait(1) || !wait(0) {
+			return
+		}
+	})
+	t.Run("mod unregistered", func(t *testing.T) {
+		err := ep.mod(-1, pollerEventIn)
+		if err == nil {
+			t.Errorf("epoll mod expected error but got nil")
+			return
+		}
+	})
+}
+
+func TestEpoll_RdHup(t *testing.T) {
+	ep, err := newPoller(16)
+	if err != nil {
+		t.Errorf("new epoll: %v", err)
+		return
+	}
+	defer ep.Close()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Errorf("socketpair: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+	err = ep.add(fds[0], pollerEventIn|pollerEventEdgeTriggered)
+	if err != nil {
+		t.Errorf("epoll add fd=%d: %v", fds[0], err)
+		return
+	}
+	err = unix.Shutdown(fds[1], unix.SHUT_WR)
+	if err != nil {
+		t.Errorf("shutdown: %v", err)
+		return
+	}
+	buf := make([]PollEvent, 16)
+	n, err := ep.wait(buf, time.Millisecond*200)
+	events := buf[:n]
+	if err != nil {
+		t.Errorf("epoll wait: %v", err)
+		return
+	}
+	if len(events) != 1 || events[0].Events&pollerEventRdHup == 0 {
+		t.Errorf("epoll wait expected rdhup event but got %v", events)
+		return
+	}
+}
+
+func TestEpoll_Wait(t *testing.T) {
+	ep, err := newPoller(2)
+	if err != nil {
+		t.Errorf("new epoll: %v", err)
+		return
+	}
+	defer ep.Close()
+	for range 3 {
+		efd, err := NewEventfd()
+		if err != nil {
+			t.Errorf("new event fd: %v", err)
+			return
+		}
+		defer efd.Close()
+		err = efd.WriteUint(1)
+		if err != nil {
+			t.Errorf("event fd write: %v", err)
+			return
+		}
+		err = ep.add(efd.Fd(), pollerEventIn)
+		if err != nil {
+			t.Errorf("epoll add fd=%d: %v", efd.Fd(), err)
+			return
+		}
+	}
+
+	t.Run("max events", func(t *testing.T) {
+		buf := make([]PollEvent, 16)
+		n, err := ep.wait(buf, 0)
+		if err != nil {
+			t.Errorf("epoll wait: %v", err)
+			return
+		}
+		if n != 2 {
+			t.Errorf("epoll wait expected event num=%d but got %d", 2, n)
+			return
+		}
+		n, err = ep.wait(buf[:1], 0)
+		if err != nil {
+			t.Errorf("epoll wait: %v", err)
+			return
+		}
+		if n != 1 {
+			t.Errorf("epoll wait expected event num=%d but got %d", 1, n)
+			return
+		}
+	})
+	t.Run("empty events", func(t *testing.T) {
+		_, err := ep.wait(nil, 0)
+		if err != ErrInvalidParam {
+			t.Errorf("epoll wait expected %v but got %v", ErrInvalidParam, err)
+			return
+		}
+	})
+	t.Run("no allocation", func(t *testing.T) {
+		buf := make([]PollEvent, 16)
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = ep.wait(buf, 0)
+		})
+		if allocs != 0 {
+			t.Errorf("epoll wait expected no allocation but got %v", allocs)
+			return
+		}
+	})
+}
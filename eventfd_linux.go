@@ -13,9 +13,30 @@ import (
 
 type eventfd int
 
+// EventfdOptions holds optional parameters for eventfd
+type EventfdOptions struct {
+	// InitValue specifies the initial value of the counter
+	InitValue uint
+	// Semaphore specifies whether the eventfd works in semaphore mode or not
+	// In semaphore mode, every read decrements the counter by 1 and returns 1,
+	// so that every notified count wakes up exactly one reader
+	Semaphore bool
+}
+
 // NewEventfd creates and returns a new nonblocking eventfd as a PollUintReadWriteCloser
-func NewEventfd() (PollUintReadWriteCloser, error) {
-	fd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+func NewEventfd(opts ...func(options *EventfdOptions)) (PollUintReadWriteCloser, error) {
+	o := &EventfdOptions{
+		InitValue: 0,
+		Semaphore: false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	flags := unix.EFD_NONBLOCK | unix.EFD_CLOEXEC
+	if o.Semaphore {
+		flags |= unix.EFD_SEMAPHORE
+	}
+	fd, err := unix.Eventfd(o.InitValue, flags)
 	if err != nil {
 		return nil, errFromUnixErrno(err)
 	}
@@ -8,7 +8,11 @@ package sox_test
 
 import (
 	"hybscloud.com/sox"
+	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestEventfd_ReadWrite(t *testing.T) {
@@ -111,3 +115,129 @@ func TestEventfd_ReadWrite(t *testing.T) {
 		}
 	})
 }
+
+func TestEventfd_Semaphore(t *testing.T) {
+	evt, err := sox.NewEventfd(func(options *sox.EventfdOptions) {
+		options.InitValue = 1
+		options.Semaphore = true
+	})
+	if err != nil {
+		t.Errorf("new eventfd: %v", err)
+		return
+	}
+	defer evt.Close()
+	err = evt.WriteUint(2)
+	if err != nil {
+		t.Errorf("write eventfd: %v", err)
+		return
+	}
+	for range 3 {
+		val, err := evt.ReadUint()
+		if err != nil || val != 1 {
+			t.Errorf("read semaphore eventfd expected 1 but got %d %v", val, err)
+			return
+		}
+	}
+	val, err := evt.ReadUint()
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("read semaphore eventfd expected EAGAIN but got: %d", val)
+		return
+	}
+}
+
+func TestNotifier_NotifyWait(t *testing.T) {
+	nf, err := sox.NewNotifier()
+	if err != nil {
+		t.Errorf("new notifier: %v", err)
+		return
+	}
+	err = nf.TryWait()
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("notifier try wait expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	err = nf.Wait(10 * time.Millisecond)
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("notifier wait expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+
+	const workers, n = 4, 1000
+	woken := atomic.Int64{}
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := nf.Wait(-1)
+				if err == io.ErrClosedPipe {
+					return
+				}
+				if err != nil {
+					t.Errorf("notifier wait: %v", err)
+					return
+				}
+				woken.Add(1)
+			}
+		}()
+	}
+	for range n {
+		err = nf.Notify(1)
+		if err != nil {
+			t.Errorf("notifier notify: %v", err)
+			return
+		}
+	}
+	for woken.Load() < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if woken.Load() != n {
+		t.Errorf("notifier expected %d wakeups but got %d", n, woken.Load())
+		return
+	}
+	err = nf.Close()
+	if err != nil {
+		t.Errorf("notifier close: %v", err)
+		return
+	}
+	wg.Wait()
+	err = nf.Notify(1)
+	if err != io.ErrClosedPipe {
+		t.Errorf("notifier notify expected %v but got %v", io.ErrClosedPipe, err)
+		return
+	}
+}
+
+func TestNotifier_NotifyClose(t *testing.T) {
+	nf, err := sox.NewNotifier()
+	if err != nil {
+		t.Errorf("new notifier: %v", err)
+		return
+	}
+	wg := sync.WaitGroup{}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := nf.Notify(1)
+				if err == io.ErrClosedPipe {
+					return
+				}
+				if err != nil {
+					t.Errorf("notifier notify: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	err = nf.Close()
+	if err != nil {
+		t.Errorf("notifier close: %v", err)
+		return
+	}
+	wg.Wait()
+}
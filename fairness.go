@@ -0,0 +1,126 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+// DefaultReadBudget is the ReadBudget used when the ReadBudget of Options is zero
+var DefaultReadBudget = ReadBudget{MaxFrames: 64, MaxBytes: 0}
+
+// ReadBudget limits the reads of a connection per wakeup of the event loop, so
+// that a single chatty connection can not monopolize a poll iteration
+// MaxFrames <= 0 means no limit of the frames, and MaxBytes <= 0 means no limit
+// of the bytes. A connection which has used up its budget before its socket
+// is drained is saturated, and is requeued at the back of a ReadyList
+type ReadBudget struct {
+	// MaxFrames is the maximum number of the frames, i.e. the successful reads
+	MaxFrames int
+	// MaxBytes is the maximum number of the bytes. The read which crosses
+	// it completes, so a wakeup may read up to MaxBytes plus one frame
+	MaxBytes int
+}
+
+// Exhausted reports whether the budget is used up by the frames and the bytes
+func (b ReadBudget) Exhausted(frames int, bytes int) bool {
+	return (b.MaxFrames > 0 && frames >= b.MaxFrames) || (b.MaxBytes > 0 && bytes >= b.MaxBytes)
+}
+
+// Drain calls read until the socket is drained, read fails or the budget is
+// used up. read returns the number of the bytes of a frame, or
+// ErrTemporarilyUnavailable when there is nothing left to read
+// saturated reports that the budget was used up before the socket was drained
+func (b ReadBudget) Drain(read func() (n int, err error)) (frames int, bytes int, saturated bool, err error) {
+	for !b.Exhausted(frames, bytes) {
+		n, err := read()
+		if err == ErrTemporarilyUnavailable {
+			return frames, bytes, false, nil
+		}
+		if err != nil {
+			return frames, bytes, false, err
+		}
+		frames, bytes = frames+1, bytes+n
+	}
+
+	return frames, bytes, true, nil
+}
+
+// ReadyList is the FIFO list of the ready fds the event loop serves in turn
+// An fd is queued at most once, so the fds reported by the poller while they
+// are still queued keep their places, and a saturated fd pushed back after it
+// is served waits behind all the other ready fds. Since the edge-triggered
+// poller does not report a saturated fd again, the fd must be pushed back
+// ReadyList is not safe for concurrent use, it is owned by the polling goroutine
+type ReadyList struct {
+	fds    []int
+	head   int
+	n      int
+	queued map[int]struct{}
+}
+
+// NewReadyList creates and returns a new empty ReadyList
+func NewReadyList() *ReadyList {
+	return &ReadyList{fds: make([]int, 64), queued: make(map[int]struct{})}
+}
+
+// Len returns the number of the queued fds
+func (l *ReadyList) Len() int {
+	return l.n
+}
+
+// Push queues fd at the back unless it is already queued, and reports whether it was queued
+func (l *ReadyList) Push(fd int) bool {
+	if _, ok := l.queued[fd]; ok {
+		return false
+	}
+	if l.n == len(l.fds) {
+		fds := make([]int, 2*len(l.fds))
+		k := copy(fds, l.fds[l.head:])
+		copy(fds[k:], l.fds[:l.head])
+		l.fds, l.head = fds, 0
+	}
+	l.fds[(l.head+l.n)%len(l.fds)] = fd
+	l.n++
+	l.queued[fd] = struct{}{}
+
+	return true
+}
+
+// PushEvents queues the fds of the events which are not queued yet in order
+func (l *ReadyList) PushEvents(events []PollEvent) {
+	for i := range events {
+		l.Push(int(events[i].Fd))
+	}
+}
+
+// Pop dequeues the fd at the front
+func (l *ReadyList) Pop() (fd int, ok bool) {
+	if l.n == 0 {
+		return -1, false
+	}
+	fd = l.fds[l.head]
+	l.head = (l.head + 1) % len(l.fds)
+	l.n--
+	delete(l.queued, fd)
+
+	return fd, true
+}
+
+// Remove dequeues fd wherever it is, e.g. when its connection is closed,
+// and reports whether it was queued
+func (l *ReadyList) Remove(fd int) bool {
+	if _, ok := l.queued[fd]; !ok {
+		return false
+	}
+	delete(l.queued, fd)
+	k := 0
+	for i := 0; i < l.n; i++ {
+		v := l.fds[(l.head+i)%len(l.fds)]
+		if v != fd {
+			l.fds[(l.head+k)%len(l.fds)] = v
+			k++
+		}
+	}
+	l.n = k
+
+	return true
+}
@@ -0,0 +1,117 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"errors"
+	"hybscloud.com/sox"
+	"slices"
+	"testing"
+)
+
+func TestReadBudget_Drain(t *testing.T) {
+	reader := func(frames ...int) func() (int, error) {
+		return func() (int, error) {
+			if len(frames) == 0 {
+				return 0, sox.ErrTemporarilyUnavailable
+			}
+			n := frames[0]
+			frames = frames[1:]
+			return n, nil
+		}
+	}
+	t.Run("frames", func(t *testing.T) {
+		frames, bytes, saturated, err := sox.ReadBudget{MaxFrames: 2}.Drain(reader(10, 20, 30))
+		if frames != 2 || bytes != 30 || !saturated || err != nil {
+			t.Errorf("drain expected 2 frames 30 bytes saturated but got %d %d %v %v", frames, bytes, saturated, err)
+			return
+		}
+	})
+	t.Run("bytes", func(t *testing.T) {
+		frames, bytes, saturated, err := sox.ReadBudget{MaxBytes: 25}.Drain(reader(10, 20, 30))
+		if frames != 2 || bytes != 30 || !saturated || err != nil {
+			t.Errorf("drain expected 2 frames 30 bytes saturated but got %d %d %v %v", frames, bytes, saturated, err)
+			return
+		}
+	})
+	t.Run("drained", func(t *testing.T) {
+		frames, bytes, saturated, err := sox.DefaultReadBudget.Drain(reader(10, 20, 30))
+		if frames != 3 || bytes != 60 || saturated || err != nil {
+			t.Errorf("drain expected 3 frames 60 bytes drained but got %d %d %v %v", frames, bytes, saturated, err)
+			return
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		expected := errors.New("read error")
+		_, _, saturated, err := sox.DefaultReadBudget.Drain(func() (int, error) { return 0, expected })
+		if saturated || err != expected {
+			t.Errorf("drain expected %v but got %v %v", expected, saturated, err)
+			return
+		}
+	})
+}
+
+func TestReadyList(t *testing.T) {
+	t.Run("fifo", func(t *testing.T) {
+		l := sox.NewReadyList()
+		l.PushEvents([]sox.PollEvent{{Fd: 3}, {Fd: 4}, {Fd: 3}})
+		for i := 5; i < 200; i++ {
+			l.Push(i)
+		}
+		if l.Push(4) || l.Len() != 197 {
+			t.Errorf("push expected 197 fds without duplicates but got %d", l.Len())
+			return
+		}
+		if !l.Remove(100) || l.Remove(100) {
+			t.Errorf("remove expected to dequeue fd 100 once")
+			return
+		}
+		for i := 3; i < 200; i++ {
+			if i == 100 {
+				continue
+			}
+			fd, ok := l.Pop()
+			if !ok || fd != i {
+				t.Errorf("pop expected fd %d but got %d %v", i, fd, ok)
+				return
+			}
+		}
+		if _, ok := l.Pop(); ok {
+			t.Errorf("pop expected empty list")
+			return
+		}
+	})
+
+	t.Run("starvation", func(t *testing.T) {
+		// fd 1 has 1000 pending frames and fd 2 has 1, which is served
+		// in the first iteration while fd 1 is requeued behind it
+		pending := map[int]int{1: 1000, 2: 1}
+		var served []int
+		l := sox.NewReadyList()
+		l.PushEvents([]sox.PollEvent{{Fd: 1}, {Fd: 2}})
+		budget := sox.ReadBudget{MaxFrames: 16}
+		for fd, ok := l.Pop(); ok; fd, ok = l.Pop() {
+			served = append(served, fd)
+			_, _, saturated, err := budget.Drain(func() (int, error) {
+				if pending[fd] == 0 {
+					return 0, sox.ErrTemporarilyUnavailable
+				}
+				pending[fd]--
+				return 1, nil
+			})
+			if err != nil {
+				t.Errorf("drain: %v", err)
+				return
+			}
+			if saturated {
+				l.Push(fd)
+			}
+		}
+		if !slices.Equal(served[:3], []int{1, 2, 1}) || pending[1] != 0 || len(served) != 1000/16+2 {
+			t.Errorf("expected fd 2 served between the budgets of fd 1 but got %v", served)
+			return
+		}
+	})
+}
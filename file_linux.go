@@ -0,0 +1,410 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// DirectIOAlignment is the alignment of the offsets and the lengths of the
+// reads and writes on the files opened with O_DIRECT
+const DirectIOAlignment = 512
+
+// FileOptions represents the options of files
+type FileOptions struct {
+	// Entries is the number of the submission queue entries of the ring
+	Entries int
+	// Resolve is the unix.RESOLVE_* flags which restrict the path resolution
+	// of OpenFile, e.g. unix.RESOLVE_BENEATH. A non-zero Resolve opens the
+	// file with openat2
+	Resolve uint64
+}
+
+var defaultFileOptions = FileOptions{
+	Entries: 8,
+}
+
+// File is a file whose open, read, write, fsync and close are submitted
+// through io_uring. Each method submits the operation and waits for its
+// completion, and the methods of a File are serialized
+//
+// Files opened with O_DIRECT bypass the page cache. The offsets and the
+// lengths must be multiples of DirectIOAlignment, and the buffers which are
+// not aligned, e.g. not allocated with AlignedMemBlocks, are bounced through
+// aligned ones
+type File struct {
+	name   string
+	fd     int
+	direct bool
+	ur     *ioUring
+	mu     sync.Mutex
+}
+
+// OpenFile opens the named file with the flag, e.g. unix.O_RDWR|unix.O_CREAT,
+// and the permission bits perm if the file is created
+func OpenFile(name string, flag int, perm uint32, opts ...func(options *FileOptions)) (*File, error) {
+	opt := defaultFileOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	path, err := unix.BytePtrFromString(name)
+	if err != nil {
+		return nil, ErrInvalidParam
+	}
+	ur, err := newIoUring(opt.Entries)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{name: name, fd: -1, direct: flag&unix.O_DIRECT != 0, ur: ur}
+	how := unix.OpenHow{Flags: uint64(flag | unix.O_CLOEXEC), Mode: uint64(perm), Resolve: opt.Resolve}
+	fd, err := f.do(func() error {
+		if opt.Resolve != 0 {
+			return ur.openat2(context.Background(), unix.AT_FDCWD, path, &how)
+		}
+		return ur.openat(context.Background(), unix.AT_FDCWD, path, flag|unix.O_CLOEXEC, perm)
+	})
+	runtime.KeepAlive(path)
+	runtime.KeepAlive(&how)
+	if err != nil {
+		_ = ur.Close()
+		return nil, err
+	}
+	f.fd = fd
+
+	return f, nil
+}
+
+// Name returns the name of the file as presented to OpenFile
+func (f *File) Name() string {
+	return f.name
+}
+
+// Fd returns the file descriptor of the file
+func (f *File) Fd() int {
+	return f.fd
+}
+
+// Read reads up to len(p) bytes from the current file position
+// Read returns io.EOF at the end of the file
+func (f *File) Read(p []byte) (n int, err error) {
+	if len(p) < 1 {
+		return 0, nil
+	}
+	n, err = f.readAt(p, ioUringCurrentOffset)
+	if err == nil && n == 0 {
+		return 0, io.EOF
+	}
+	return
+}
+
+// ReadAt reads len(p) bytes from the offset off. ReadAt returns
+// io.EOF if the file ends before p has been filled
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, ErrInvalidParam
+	}
+	for n < len(p) {
+		m, err := f.readAt(p[n:], uint64(off)+uint64(n))
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.EOF
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// Write writes all of p to the current file position
+func (f *File) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		m, err := f.writeAt(p[n:], ioUringCurrentOffset)
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// WriteAt writes all of p to the offset off
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, ErrInvalidParam
+	}
+	for n < len(p) {
+		m, err := f.writeAt(p[n:], uint64(off)+uint64(n))
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// Seek sets the file position for the next Read or Write
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fd < 0 {
+		return 0, io.ErrClosedPipe
+	}
+	ret, err := unix.Seek(f.fd, offset, whence)
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+	return ret, nil
+}
+
+// Sync commits the written data and metadata of the file to the storage
+func (f *File) Sync() error {
+	_, err := f.do(func() error {
+		return f.ur.fsync(context.Background(), f.fd)
+	})
+	return err
+}
+
+// Stat returns the FileInfo of the file queried with statx
+// The Sys method of the FileInfo returns the *unix.Statx_t
+func (f *File) Stat() (fs.FileInfo, error) {
+	st := &fileStat{name: filepath.Base(f.name)}
+	path := &[1]byte{}
+	_, err := f.do(func() error {
+		return f.ur.statx(context.Background(), f.fd, &path[0], unix.AT_EMPTY_PATH, unix.STATX_BASIC_STATS, &st.sys)
+	})
+	runtime.KeepAlive(path)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Allocate manipulates the allocated disk space of the file in the range from
+// off with the length, as fallocate(2) does with the mode, e.g. zero mode
+// preallocates the space and extends the file size if needed
+func (f *File) Allocate(mode uint32, off int64, length int64) error {
+	_, err := f.do(func() error {
+		return f.ur.fallocate(context.Background(), f.fd, mode, off, length)
+	})
+	return err
+}
+
+// Close closes the file and its ring
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fd < 0 {
+		return io.ErrClosedPipe
+	}
+	fd := f.fd
+	f.fd = -1
+	_, err := f.complete(func() error {
+		return f.ur.close(context.Background(), fd)
+	})
+	if e := f.ur.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (f *File) readAt(p []byte, off uint64) (n int, err error) {
+	b, bounced, err := f.buffer(p, off)
+	if err != nil {
+		return 0, err
+	}
+	n, err = f.do(func() error {
+		return f.ur.readAt(context.Background(), f.fd, b, off)
+	})
+	runtime.KeepAlive(b)
+	if bounced {
+		copy(p, b[:n])
+	}
+	return
+}
+
+func (f *File) writeAt(p []byte, off uint64) (n int, err error) {
+	b, bounced, err := f.buffer(p, off)
+	if err != nil {
+		return 0, err
+	}
+	if bounced {
+		copy(b, p)
+	}
+	n, err = f.do(func() error {
+		return f.ur.writeAt(context.Background(), f.fd, b, off)
+	})
+	runtime.KeepAlive(b)
+	return
+}
+
+// buffer returns the buffer which the kernel reads into or writes from
+// for p. The buffer is a bounce buffer if p is not aligned for O_DIRECT
+func (f *File) buffer(p []byte, off uint64) (b []byte, bounced bool, err error) {
+	if !f.direct {
+		return p, false, nil
+	}
+	if len(p)%DirectIOAlignment != 0 || (off != ioUringCurrentOffset && off%DirectIOAlignment != 0) {
+		return nil, false, ErrInvalidParam
+	}
+	if uintptr(unsafe.Pointer(unsafe.SliceData(p)))%DirectIOAlignment == 0 {
+		return p, false, nil
+	}
+	b = make([]byte, len(p)+DirectIOAlignment-1)
+	o := int(-uintptr(unsafe.Pointer(unsafe.SliceData(b))) & (DirectIOAlignment - 1))
+	return b[o : o+len(p) : o+len(p)], true, nil
+}
+
+// do submits the operation with submit and waits for its completion
+func (f *File) do(submit func() error) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ur.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return f.complete(submit)
+}
+
+func (f *File) complete(submit func() error) (int, error) {
+	return ioUringComplete(f.ur, submit)
+}
+
+// ioUringComplete submits the operation with submit on ur, which must have no
+// other operations in flight, and waits for its completion
+func ioUringComplete(ur *ioUring, submit func() error) (int, error) {
+	err := submit()
+	if err != nil {
+		return 0, err
+	}
+	err = ur.enter()
+	if err != nil {
+		return 0, err
+	}
+	res, err := ur.waitOne()
+	if err != nil {
+		return 0, err
+	}
+	return int(res), nil
+}
+
+// RemoveFile removes the named file or empty directory through io_uring
+func RemoveFile(name string) error {
+	path, err := unix.BytePtrFromString(name)
+	if err != nil {
+		return ErrInvalidParam
+	}
+	err = fileOnce(func(ur *ioUring) error {
+		return ur.unlinkat(context.Background(), unix.AT_FDCWD, path, 0)
+	})
+	if err == unix.EISDIR {
+		err = fileOnce(func(ur *ioUring) error {
+			return ur.unlinkat(context.Background(), unix.AT_FDCWD, path, unix.AT_REMOVEDIR)
+		})
+	}
+	runtime.KeepAlive(path)
+	return err
+}
+
+// RenameFile renames the file oldname to newname through io_uring
+// An existing newname is replaced as rename(2) does
+func RenameFile(oldname, newname string) error {
+	oldpath, err := unix.BytePtrFromString(oldname)
+	if err != nil {
+		return ErrInvalidParam
+	}
+	newpath, err := unix.BytePtrFromString(newname)
+	if err != nil {
+		return ErrInvalidParam
+	}
+	err = fileOnce(func(ur *ioUring) error {
+		return ur.renameat(context.Background(), unix.AT_FDCWD, oldpath, unix.AT_FDCWD, newpath, 0)
+	})
+	runtime.KeepAlive(oldpath)
+	runtime.KeepAlive(newpath)
+	return err
+}
+
+// fileOnce submits one operation on a transient ring and waits for its completion
+func fileOnce(submit func(ur *ioUring) error) error {
+	ur, err := newIoUring(defaultFileOptions.Entries)
+	if err != nil {
+		return err
+	}
+	defer ur.Close()
+	_, err = ioUringComplete(ur, func() error {
+		return submit(ur)
+	})
+	return err
+}
+
+// fileStat is the FileInfo decoded from the result of statx
+type fileStat struct {
+	name string
+	sys  unix.Statx_t
+}
+
+func (st *fileStat) Name() string {
+	return st.name
+}
+
+func (st *fileStat) Size() int64 {
+	return int64(st.sys.Size)
+}
+
+func (st *fileStat) Mode() fs.FileMode {
+	mode := fs.FileMode(st.sys.Mode & 0777)
+	switch uint32(st.sys.Mode) & unix.S_IFMT {
+	case unix.S_IFBLK:
+		mode |= fs.ModeDevice
+	case unix.S_IFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case unix.S_IFDIR:
+		mode |= fs.ModeDir
+	case unix.S_IFIFO:
+		mode |= fs.ModeNamedPipe
+	case unix.S_IFLNK:
+		mode |= fs.ModeSymlink
+	case unix.S_IFSOCK:
+		mode |= fs.ModeSocket
+	}
+	if st.sys.Mode&unix.S_ISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if st.sys.Mode&unix.S_ISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if st.sys.Mode&unix.S_ISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+func (st *fileStat) ModTime() time.Time {
+	return time.Unix(st.sys.Mtime.Sec, int64(st.sys.Mtime.Nsec))
+}
+
+func (st *fileStat) IsDir() bool {
+	return st.Mode().IsDir()
+}
+
+func (st *fileStat) Sys() any {
+	return &st.sys
+}
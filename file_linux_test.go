@@ -0,0 +1,192 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"bytes"
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile_ReadWrite(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "snapshot")
+	f, err := sox.OpenFile(name, unix.O_RDWR|unix.O_CREAT|unix.O_TRUNC, 0600)
+	if err != nil {
+		t.Errorf("open file: %v", err)
+		return
+	}
+	n, err := f.Write([]byte("hello "))
+	if err != nil || n != 6 {
+		t.Errorf("write %d bytes: %v", n, err)
+		return
+	}
+	n, err = f.Write([]byte("world"))
+	if err != nil || n != 5 {
+		t.Errorf("write %d bytes: %v", n, err)
+		return
+	}
+	_, err = f.WriteAt([]byte("W"), 6)
+	if err != nil {
+		t.Errorf("write at: %v", err)
+		return
+	}
+	err = f.Sync()
+	if err != nil {
+		t.Errorf("sync: %v", err)
+		return
+	}
+
+	p := make([]byte, 5)
+	n, err = f.ReadAt(p, 6)
+	if err != nil || string(p[:n]) != "World" {
+		t.Errorf("read at expected %q but got %q: %v", "World", p[:n], err)
+		return
+	}
+	n, err = f.ReadAt(p, 8)
+	if err != io.EOF || string(p[:n]) != "rld" {
+		t.Errorf("read at expected %q and EOF but got %q: %v", "rld", p[:n], err)
+		return
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Errorf("seek: %v", err)
+		return
+	}
+	b, err := io.ReadAll(f)
+	if err != nil || string(b) != "hello World" {
+		t.Errorf("read all expected %q but got %q: %v", "hello World", b, err)
+		return
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Errorf("close: %v", err)
+		return
+	}
+	_, err = f.Read(p)
+	if err != io.ErrClosedPipe {
+		t.Errorf("expected ErrClosedPipe but got %v", err)
+		return
+	}
+	b, err = os.ReadFile(name)
+	if err != nil || string(b) != "hello World" {
+		t.Errorf("read file expected %q but got %q: %v", "hello World", b, err)
+		return
+	}
+}
+
+func TestFile_Direct(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "direct")
+	f, err := sox.OpenFile(name, unix.O_RDWR|unix.O_CREAT|unix.O_DIRECT, 0600)
+	if err != nil {
+		t.Skipf("open file with O_DIRECT: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte("unaligned length"))
+	if err != sox.ErrInvalidParam {
+		t.Errorf("expected ErrInvalidParam but got %v", err)
+		return
+	}
+	// the unaligned buffer is bounced through an aligned one
+	s := make([]byte, sox.DirectIOAlignment+1)[1:]
+	copy(s, "test0123456789")
+	_, err = f.WriteAt(s, 0)
+	if err != nil {
+		t.Skipf("direct write: %v", err)
+	}
+	p := sox.AlignedMemBlock()[:sox.DirectIOAlignment]
+	n, err := f.ReadAt(p, 0)
+	if err != nil || !bytes.Equal(p[:n], s) {
+		t.Errorf("read at expected %q but got %q: %v", s[:14], p[:14], err)
+		return
+	}
+}
+
+func TestFile_Stat(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "stat")
+	f, err := sox.OpenFile(name, unix.O_RDWR|unix.O_CREAT, 0640)
+	if err != nil {
+		t.Errorf("open file: %v", err)
+		return
+	}
+	defer f.Close()
+	err = f.Allocate(0, 0, 4096)
+	if err != nil {
+		t.Errorf("allocate: %v", err)
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Errorf("stat: %v", err)
+		return
+	}
+	if fi.Name() != "stat" || fi.Size() != 4096 || fi.Mode() != 0640 || fi.IsDir() {
+		t.Errorf("unexpected file info name %q size %d mode %v", fi.Name(), fi.Size(), fi.Mode())
+		return
+	}
+	if st, ok := fi.Sys().(*unix.Statx_t); !ok || st.Size != 4096 {
+		t.Errorf("unexpected sys %v", fi.Sys())
+		return
+	}
+}
+
+func TestOpenFile_Resolve(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "resolve")
+	// absolute paths are not beneath the working directory
+	_, err := sox.OpenFile(name, unix.O_RDWR|unix.O_CREAT, 0600, func(options *sox.FileOptions) {
+		options.Resolve = unix.RESOLVE_BENEATH
+	})
+	if err != unix.EXDEV {
+		t.Errorf("expected EXDEV but got %v", err)
+		return
+	}
+}
+
+func TestRenameRemoveFile(t *testing.T) {
+	dir := t.TempDir()
+	oldname, newname := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	err := os.WriteFile(oldname, []byte("data"), 0600)
+	if err != nil {
+		t.Errorf("write file: %v", err)
+		return
+	}
+	err = sox.RenameFile(oldname, newname)
+	if err != nil {
+		t.Errorf("rename file: %v", err)
+		return
+	}
+	b, err := os.ReadFile(newname)
+	if err != nil || string(b) != "data" {
+		t.Errorf("read renamed file %q: %v", b, err)
+		return
+	}
+	err = sox.RemoveFile(newname)
+	if err != nil {
+		t.Errorf("remove file: %v", err)
+		return
+	}
+	_, err = os.Stat(newname)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected removed file but got %v", err)
+		return
+	}
+	err = sox.RemoveFile(dir)
+	if err != nil {
+		t.Errorf("remove dir: %v", err)
+		return
+	}
+	err = sox.RemoveFile(dir)
+	if err != unix.ENOENT {
+		t.Errorf("expected ENOENT but got %v", err)
+		return
+	}
+}
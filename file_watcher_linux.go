@@ -0,0 +1,205 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"encoding/binary"
+	"golang.org/x/sys/unix"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	fileWatcherBufferSize = BufferSizeMedium
+	fileWatcherMask       = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+		unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+)
+
+// FileOp describes the operations of a FileEvent
+type FileOp uint32
+
+const (
+	// FileCreate means a file was created or moved into the watched directory
+	FileCreate FileOp = 1 << iota
+	// FileWrite means a file was modified
+	FileWrite
+	// FileCloseWrite means a file opened for writing was closed
+	FileCloseWrite
+	// FileRemove means a file or the watched path itself was deleted
+	FileRemove
+	// FileRename means a file or the watched path itself was moved away
+	FileRename
+	// FileChmod means the metadata of a file was changed
+	FileChmod
+	// FileOverflow means the event queue overflowed and some events were lost
+	FileOverflow
+)
+
+var fileOpNames = []string{"CREATE", "WRITE", "CLOSE_WRITE", "REMOVE", "RENAME", "CHMOD", "OVERFLOW"}
+
+func (op FileOp) String() string {
+	names := make([]string, 0, 2)
+	for i, name := range fileOpNames {
+		if op&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Has reports whether op contains any of the operations in x
+func (op FileOp) Has(x FileOp) bool {
+	return op&x != 0
+}
+
+// FileEvent represents a filesystem event
+type FileEvent struct {
+	// Name is the path of the file which the event happened on
+	Name string
+	// Op is the operations of the event
+	Op FileOp
+	// Cookie associates the FileRename and FileCreate events of a rename
+	Cookie uint32
+}
+
+// FileWatcher watches filesystem events with inotify. The Fd becomes readable
+// when there are any events, then ReadEvents should be called to take them
+// A typical usage is hot-reloading configs and certificates of a server
+type FileWatcher struct {
+	fd      int
+	buf     []byte
+	mu      sync.Mutex
+	watches map[int]string
+	paths   map[string]int
+}
+
+// NewFileWatcher creates and returns a new nonblocking FileWatcher
+func NewFileWatcher() (*FileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+
+	return &FileWatcher{
+		fd:      fd,
+		buf:     make([]byte, fileWatcherBufferSize),
+		watches: make(map[int]string),
+		paths:   make(map[string]int),
+	}, nil
+}
+
+func (fw *FileWatcher) Fd() int {
+	return fw.fd
+}
+
+// Add starts watching the file or directory of the path
+// Events on the entries of a directory are reported with their paths
+func (fw *FileWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	wd, err := unix.InotifyAddWatch(fw.fd, path, fileWatcherMask)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	fw.mu.Lock()
+	fw.watches[wd], fw.paths[path] = path, wd
+	fw.mu.Unlock()
+
+	return nil
+}
+
+// Remove stops watching the path
+func (fw *FileWatcher) Remove(path string) error {
+	path = filepath.Clean(path)
+	fw.mu.Lock()
+	wd, ok := fw.paths[path]
+	if ok {
+		delete(fw.paths, path)
+		delete(fw.watches, wd)
+	}
+	fw.mu.Unlock()
+	if !ok {
+		return ErrInvalidParam
+	}
+	_, err := unix.InotifyRmWatch(fw.fd, uint32(wd))
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// ReadEvents reads and returns the events which have come
+// It returns ErrTemporarilyUnavailable if there are no events
+func (fw *FileWatcher) ReadEvents() (events []FileEvent, err error) {
+	n, err := unix.Read(fw.fd, fw.buf)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	for off := 0; off+unix.SizeofInotifyEvent <= n; {
+		wd := int(int32(binary.NativeEndian.Uint32(fw.buf[off:])))
+		mask := binary.NativeEndian.Uint32(fw.buf[off+4:])
+		cookie := binary.NativeEndian.Uint32(fw.buf[off+8:])
+		nameLen := int(binary.NativeEndian.Uint32(fw.buf[off+12:]))
+		name := strings.TrimRight(string(fw.buf[off+unix.SizeofInotifyEvent:off+unix.SizeofInotifyEvent+nameLen]), "\x00")
+		off += unix.SizeofInotifyEvent + nameLen
+
+		path, ok := fw.watches[wd]
+		if mask&unix.IN_IGNORED != 0 {
+			if ok {
+				delete(fw.watches, wd)
+				delete(fw.paths, path)
+			}
+			continue
+		}
+		op := fileOpOf(mask)
+		if op == 0 {
+			continue
+		}
+		if name != "" {
+			path = filepath.Join(path, name)
+		}
+		events = append(events, FileEvent{Name: path, Op: op, Cookie: cookie})
+	}
+
+	return events, nil
+}
+
+func fileOpOf(mask uint32) (op FileOp) {
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		op |= FileCreate
+	}
+	if mask&unix.IN_MODIFY != 0 {
+		op |= FileWrite
+	}
+	if mask&unix.IN_CLOSE_WRITE != 0 {
+		op |= FileCloseWrite
+	}
+	if mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0 {
+		op |= FileRemove
+	}
+	if mask&(unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0 {
+		op |= FileRename
+	}
+	if mask&unix.IN_ATTRIB != 0 {
+		op |= FileChmod
+	}
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		op |= FileOverflow
+	}
+	return
+}
+
+func (fw *FileWatcher) Close() error {
+	err := unix.Close(fw.fd)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileWatcher_Events(t *testing.T) {
+	dir := t.TempDir()
+	fw, err := sox.NewFileWatcher()
+	if err != nil {
+		t.Errorf("new file watcher: %v", err)
+		return
+	}
+	defer fw.Close()
+	err = fw.Add(dir)
+	if err != nil {
+		t.Errorf("file watcher add: %v", err)
+		return
+	}
+	_, err = fw.ReadEvents()
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("file watcher read events expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+
+	name := filepath.Join(dir, "cert.pem")
+	err = os.WriteFile(name, []byte("cert"), 0600)
+	if err != nil {
+		t.Errorf("write file: %v", err)
+		return
+	}
+	err = os.Rename(name, name+".old")
+	if err != nil {
+		t.Errorf("rename file: %v", err)
+		return
+	}
+
+	ops := map[string]sox.FileOp{}
+	for ops[name]&sox.FileRename == 0 {
+		fds := []unix.PollFd{{Fd: int32(fw.Fd()), Events: unix.POLLIN}}
+		_, err = unix.Poll(fds, 1000)
+		if err != nil {
+			t.Errorf("poll file watcher: %v", err)
+			return
+		}
+		if fds[0].Revents&unix.POLLIN == 0 {
+			t.Errorf("file watcher expected events but got %v", ops)
+			return
+		}
+		events, err := fw.ReadEvents()
+		if err != nil {
+			t.Errorf("file watcher read events: %v", err)
+			return
+		}
+		for _, event := range events {
+			ops[event.Name] |= event.Op
+		}
+	}
+	expected := sox.FileCreate | sox.FileWrite | sox.FileCloseWrite | sox.FileRename
+	if ops[name] != expected {
+		t.Errorf("file watcher expected %v but got %v", expected, ops[name])
+		return
+	}
+	if !ops[name+".old"].Has(sox.FileCreate) {
+		t.Errorf("file watcher expected %v but got %v", sox.FileCreate, ops[name+".old"])
+		return
+	}
+
+	err = fw.Remove(dir)
+	if err != nil {
+		t.Errorf("file watcher remove: %v", err)
+		return
+	}
+	err = fw.Remove(dir)
+	if err != sox.ErrInvalidParam {
+		t.Errorf("file watcher remove expected ErrInvalidParam but got %v", err)
+		return
+	}
+}
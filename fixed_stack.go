@@ -26,6 +26,20 @@ type Stack[ItemType any] interface {
 	//   It returns the zero-value and ErrTemporarilyUnavailable if the Stack is set as Nonblocking.
 	//   If the stack is not set as Nonblocking, it blocks until any element has been Pushed.
 	Pop() (item ItemType, err error)
+	// PushMany inserts the items at the top of the Stack in order, so that
+	// the last item becomes the top. It reserves the room for as many items
+	// as possible at once, and returns the number of the pushed items
+	// It returns io.ErrClosedPipe if the Stack is closed, and
+	// ErrTemporarilyUnavailable if the Stack is full and set as Nonblocking.
+	// Otherwise, it blocks until all the items have been pushed
+	PushMany(items []ItemType) (n int, err error)
+	// PopN removes up to len(items) elements from the top of the Stack at once
+	// into items, the top first, and returns the number of the popped elements
+	// When the Stack is empty, it returns or blocks as Pop does
+	PopN(items []ItemType) (n int, err error)
+	// TryPop removes and returns the element at the top of the Stack
+	// It returns false instead of blocking if the Stack is empty
+	TryPop() (item ItemType, ok bool)
 	// Close closes the Stack. The return value is always nil.
 	Close() error
 }
@@ -35,6 +49,7 @@ const (
 )
 
 // NewFixedStack creates and returns a fixed capacity Stack with the given options
+// The returned Stack also implements ItemPeeker
 func NewFixedStack[ItemType any](opts ...func(options *FixedStackOptions)) (Stack[ItemType], error) {
 	o := &FixedStackOptions{
 		Capacity:    defaultFixedStackCapacity,
@@ -70,30 +85,95 @@ type FixedStackOptions struct {
 	// Concurrent specifies whether the Stack works in Concurrent mode or not
 	// Concurrent should be set as true,
 	//   if there are multiple goroutines doing Push or multiple goroutines doing Pop
+	// A non-concurrent Stack reserves the slots before writing or reading them
+	// as well, since Push and Pop contend at the top, and panics if it catches
+	// two goroutines doing Push or two goroutines doing Pop at the same time
 	Concurrent bool
 	// Nonblocking specifies whether the Push or Pop operations will NOT block
 	// even if it is temporarily unavailable or not
 	Nonblocking bool
+	// WaitStrategy specifies how the blocking Push or Pop operations wait
+	// while the Stack is full or empty. The default WaitStrategy is WaitStrategySpin
+	WaitStrategy WaitStrategy
 }
 
+const (
+	fixedStackStatusWriting = 1 << 31
+	fixedStackStatusClosed  = 1 << 30
+	fixedStackStatusMask    = fixedStackStatusWriting | fixedStackStatusClosed
+	fixedStackTopValueMask  = (1 << 30) - 1
+)
+
+// fixedStack is a fixedStackConcurrent owned by one goroutine doing Push and
+// one goroutine doing Pop, which asserts the ownership
 type fixedStack[T any] struct {
+	*fixedStackConcurrent[T]
+	pushing atomic.Int32
+	popping atomic.Int32
+}
+
+func newFixedStack[T any](stack []T, opt *FixedStackOptions) *fixedStack[T] {
+	return &fixedStack[T]{fixedStackConcurrent: newFixedStackConcurrent[T](stack, opt)}
+}
+
+func (s *fixedStack[T]) Push(item T) error {
+	defer s.own(&s.pushing, "push")()
+	return s.fixedStackConcurrent.Push(item)
+}
+
+func (s *fixedStack[T]) Pop() (item T, err error) {
+	defer s.own(&s.popping, "pop")()
+	return s.fixedStackConcurrent.Pop()
+}
+
+func (s *fixedStack[T]) TryPop() (item T, ok bool) {
+	defer s.own(&s.popping, "pop")()
+	return s.fixedStackConcurrent.TryPop()
+}
+
+func (s *fixedStack[T]) PushMany(items []T) (n int, err error) {
+	defer s.own(&s.pushing, "push")()
+	return s.fixedStackConcurrent.PushMany(items)
+}
+
+func (s *fixedStack[T]) PopN(items []T) (n int, err error) {
+	defer s.own(&s.popping, "pop")()
+	return s.fixedStackConcurrent.PopN(items)
+}
+
+func (s *fixedStack[T]) own(owner *atomic.Int32, op string) (release func()) {
+	if !owner.CompareAndSwap(0, 1) {
+		panic("non-concurrent fixed stack " + op + " by multiple goroutines")
+	}
+	return func() { owner.Store(0) }
+}
+
+type fixedStackConcurrent[T any] struct {
 	*FixedStackOptions
 	stack []T
 	top   atomic.Uint32
+	waits *ringQueueWaits
 }
 
-func newFixedStack[T any](stack []T, opt *FixedStackOptions) *fixedStack[T] {
-	return &fixedStack[T]{
+func newFixedStackConcurrent[T any](stack []T, opt *FixedStackOptions) *fixedStackConcurrent[T] {
+	return &fixedStackConcurrent[T]{
 		FixedStackOptions: opt,
 		stack:             stack,
 		top:               atomic.Uint32{},
+		waits:             &ringQueueWaits{strategy: opt.WaitStrategy, notEmpty: newParker(), notFull: newParker()},
 	}
 }
 
-func (s *fixedStack[T]) Push(item T) error {
-	sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce)
+func (s *fixedStackConcurrent[T]) Push(item T) error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := s.waits.notFullWait(sw)
+	defer w.done()
 	for {
 		top := s.top.Load()
+		if top&fixedStackStatusWriting == fixedStackStatusWriting {
+			sw.Once()
+			continue
+		}
 		if top&fixedStackStatusClosed == fixedStackStatusClosed {
 			return io.ErrClosedPipe
 		}
@@ -101,144 +181,172 @@ func (s *fixedStack[T]) Push(item T) error {
 			if s.Nonblocking {
 				return ErrTemporarilyUnavailable
 			}
-			sw.Once()
+			w.once()
 			continue
 		}
-		s.stack[top] = item
-		if !s.top.CompareAndSwap(top, top+1) {
+		newTop := fixedStackStatusWriting | (top&fixedStackTopValueMask + 1)
+		if !s.top.CompareAndSwap(top, newTop) {
 			sw.Once()
 			continue
 		}
+		s.stack[top&fixedStackTopValueMask] = item
+		s.top.Store(newTop&(fixedStackStatusMask^fixedStackStatusWriting) | newTop&fixedStackTopValueMask)
 		break
 	}
 
+	s.waits.produced()
 	return nil
 }
 
-func (s *fixedStack[T]) Pop() (item T, err error) {
-	sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume)
+func (s *fixedStackConcurrent[T]) Pop() (item T, err error) {
+	return s.pop(s.Nonblocking)
+}
+
+func (s *fixedStackConcurrent[T]) TryPop() (item T, ok bool) {
+	item, err := s.pop(true)
+	return item, err == nil
+}
+
+func (s *fixedStackConcurrent[T]) pop(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := s.waits.notEmptyWait(sw)
+	defer w.done()
 	for {
 		top := s.top.Load()
 		if top&fixedStackTopValueMask <= 0 {
 			if top&fixedStackStatusClosed == fixedStackStatusClosed {
 				return item, io.EOF
 			}
-			if s.Nonblocking {
+			if nonblocking {
 				return item, ErrTemporarilyUnavailable
 			}
-			sw.Once()
+			w.once()
 			continue
 		}
-		item = s.stack[s.top.Add(math.MaxUint32)&fixedStackTopValueMask]
-		break
-	}
-
-	return item, nil
-}
-
-func (s *fixedStack[T]) Close() error {
-	sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce)
-	for {
-		top := s.top.Load()
-		if top&fixedStackStatusClosed == fixedStackStatusClosed {
-			return nil
+		if top&fixedStackStatusWriting == fixedStackStatusWriting {
+			sw.Once()
+			continue
 		}
-		if swapped := s.top.CompareAndSwap(top, top|fixedStackStatusClosed); !swapped {
+		newTop := top&fixedStackStatusClosed | fixedStackStatusWriting | (top&fixedStackTopValueMask - 1)
+		if !s.top.CompareAndSwap(top, newTop) {
 			sw.Once()
 			continue
 		}
-
+		item = s.stack[newTop&fixedStackTopValueMask]
+		s.top.Store(newTop&(fixedStackStatusMask^fixedStackStatusWriting) | newTop&fixedStackTopValueMask)
 		break
 	}
 
-	return nil
-}
-
-const (
-	fixedStackStatusWriting = 1 << 31
-	fixedStackStatusClosed  = 1 << 30
-	fixedStackStatusMask    = fixedStackStatusWriting | fixedStackStatusClosed
-	fixedStackTopValueMask  = (1 << 30) - 1
-)
-
-type fixedStackConcurrent[T any] struct {
-	*FixedStackOptions
-	stack []T
-	top   atomic.Uint32
-}
-
-func newFixedStackConcurrent[T any](stack []T, opt *FixedStackOptions) *fixedStackConcurrent[T] {
-	return &fixedStackConcurrent[T]{
-		FixedStackOptions: opt,
-		stack:             stack,
-		top:               atomic.Uint32{},
-	}
+	s.waits.consumed()
+	return item, nil
 }
 
-func (s *fixedStackConcurrent[T]) Push(item T) error {
-	sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce)
-	for {
+func (s *fixedStackConcurrent[T]) PushMany(items []T) (n int, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := s.waits.notFullWait(sw)
+	defer w.done()
+	for n < len(items) {
 		top := s.top.Load()
 		if top&fixedStackStatusWriting == fixedStackStatusWriting {
 			sw.Once()
 			continue
 		}
 		if top&fixedStackStatusClosed == fixedStackStatusClosed {
-			return io.ErrClosedPipe
+			return n, io.ErrClosedPipe
 		}
 		if top&fixedStackTopValueMask >= s.Capacity {
 			if s.Nonblocking {
-				return ErrTemporarilyUnavailable
+				return n, ErrTemporarilyUnavailable
 			}
-			sw.Once()
+			w.once()
 			continue
 		}
-		newTop := fixedStackStatusWriting | (top&fixedStackTopValueMask + 1)
+		k := min(uint32(len(items)-n), s.Capacity-top)
+		newTop := fixedStackStatusWriting | (top + k)
 		if !s.top.CompareAndSwap(top, newTop) {
 			sw.Once()
 			continue
 		}
-		s.stack[top&fixedStackTopValueMask] = item
-		s.top.Store(newTop&(fixedStackStatusMask^fixedStackStatusWriting) | newTop&fixedStackTopValueMask)
-		break
+		copy(s.stack[top:top+k], items[n:])
+		s.top.Store(top + k)
+		n += int(k)
+		s.waits.produced()
 	}
 
-	return nil
+	return n, nil
 }
 
-func (s *fixedStackConcurrent[T]) Pop() (item T, err error) {
-	sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume)
+func (s *fixedStackConcurrent[T]) PopN(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := s.waits.notEmptyWait(sw)
+	defer w.done()
 	for {
 		top := s.top.Load()
 		if top&fixedStackTopValueMask <= 0 {
 			if top&fixedStackStatusClosed == fixedStackStatusClosed {
-				return item, io.EOF
+				return 0, io.EOF
 			}
 			if s.Nonblocking {
-				return item, ErrTemporarilyUnavailable
+				return 0, ErrTemporarilyUnavailable
 			}
-			sw.Once()
+			w.once()
 			continue
 		}
 		if top&fixedStackStatusWriting == fixedStackStatusWriting {
 			sw.Once()
 			continue
 		}
-		newTop := fixedStackStatusWriting | (top&fixedStackTopValueMask - 1)
+		k := min(uint32(len(items)), top&fixedStackTopValueMask)
+		newTop := top&fixedStackStatusClosed | fixedStackStatusWriting | (top&fixedStackTopValueMask - k)
 		if !s.top.CompareAndSwap(top, newTop) {
 			sw.Once()
 			continue
 		}
-		item = s.stack[newTop&fixedStackTopValueMask]
-		s.top.Store(newTop&(fixedStackStatusMask^fixedStackStatusWriting) | newTop&fixedStackTopValueMask)
-		break
+		for i := range k {
+			items[i] = s.stack[top&fixedStackTopValueMask-1-i]
+		}
+		s.top.Store(newTop &^ fixedStackStatusWriting)
+		s.waits.consumed()
+		return int(k), nil
 	}
+}
 
-	return item, nil
+func (s *fixedStackConcurrent[T]) Peek() (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	for {
+		top := s.top.Load()
+		if top&fixedStackTopValueMask <= 0 {
+			if top&fixedStackStatusClosed == fixedStackStatusClosed {
+				return item, io.EOF
+			}
+			return item, ErrTemporarilyUnavailable
+		}
+		if top&fixedStackStatusWriting == fixedStackStatusWriting {
+			sw.Once()
+			continue
+		}
+		item = s.stack[top&fixedStackTopValueMask-1]
+		if s.top.Load() != top {
+			sw.Once()
+			continue
+		}
+		return item, nil
+	}
+}
+
+func (s *fixedStackConcurrent[T]) Len() int {
+	return int(s.top.Load() & fixedStackTopValueMask)
+}
+
+func (s *fixedStackConcurrent[T]) Cap() int {
+	return int(s.Capacity)
 }
 
 func (s *fixedStackConcurrent[T]) Close() error {
-	sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce)
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
 	for {
 		top := s.top.Load()
 		if top&fixedStackStatusClosed == fixedStackStatusClosed {
@@ -256,5 +364,6 @@ func (s *fixedStackConcurrent[T]) Close() error {
 		break
 	}
 
+	s.waits.closed()
 	return nil
 }
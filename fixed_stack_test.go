@@ -5,11 +5,14 @@
 package sox_test
 
 import (
+	"fmt"
 	"hybscloud.com/sox"
 	"io"
 	"math"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestFixedStack_Series(t *testing.T) {
@@ -78,6 +81,35 @@ func TestFixedStack_Series(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("pop by multiple goroutines", func(t *testing.T) {
+		s, err := sox.NewFixedStack[int](func(options *sox.FixedStackOptions) {
+			options.Concurrent = false
+			options.Nonblocking = false
+		})
+		if err != nil {
+			t.Errorf("fixed stack new: %v", err)
+			return
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = s.Pop()
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("fixed stack pop expected panic")
+				}
+			}()
+			for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+				_, _ = s.TryPop()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		_ = s.Push(1)
+		<-done
+	})
 }
 
 func BenchmarkFixedStack_Parallel(b *testing.B) {
@@ -165,6 +197,164 @@ func TestFixedStack_Concurrent(t *testing.T) {
 
 }
 
+func TestFixedStack_Batch(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		t.Run(fmt.Sprintf("concurrent %v", concurrent), func(t *testing.T) {
+			s, err := sox.NewFixedStack[int](func(options *sox.FixedStackOptions) {
+				options.Capacity = 0x7
+				options.Concurrent = concurrent
+				options.Nonblocking = true
+			})
+			if err != nil {
+				t.Errorf("fixed stack new: %v", err)
+				return
+			}
+			testFixedStackBatch(t, s)
+		})
+	}
+
+	t.Run("64 push goroutines 64 pop goroutines", func(t *testing.T) {
+		s, err := sox.NewFixedStack[int64](func(options *sox.FixedStackOptions) {
+			options.Capacity = 0xff
+			options.Concurrent = true
+		})
+		if err != nil {
+			t.Errorf("fixed stack new: %v", err)
+			return
+		}
+		const m, n = 0x40, 0x400
+		popped := make([]atomic.Int32, m)
+		wg := sync.WaitGroup{}
+		for i := range m {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				items := make([]int64, 8)
+				for total := 0; total < n; {
+					k, err := s.PopN(items[:min(len(items), n-total)])
+					if err != nil {
+						t.Errorf("fixed stack pop n: %v", err)
+						return
+					}
+					for _, item := range items[:k] {
+						popped[item>>32].Add(1)
+					}
+					total += k
+				}
+			}()
+			go func() {
+				items := make([]int64, n)
+				for j := range items {
+					items[j] = int64(i<<32) | int64(j)
+				}
+				for j := 0; j < n; j += 8 {
+					_, err := s.PushMany(items[j : j+8])
+					if err != nil {
+						t.Errorf("fixed stack push many: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for i := range m {
+			if popped[i].Load() != n {
+				t.Errorf("fixed stack expected %d items of pusher %d but got %d", n, i, popped[i].Load())
+				return
+			}
+		}
+	})
+}
+
+func TestFixedStack_WaitStrategy(t *testing.T) {
+	for _, strategy := range []sox.WaitStrategy{sox.WaitStrategyPark, sox.WaitStrategySpinThenYield, sox.WaitStrategySpinThenSleep} {
+		t.Run(fmt.Sprintf("strategy %d", strategy), func(t *testing.T) {
+			s, err := sox.NewFixedStack[int64](func(options *sox.FixedStackOptions) {
+				options.Capacity = 0xf
+				options.Concurrent = true
+				options.WaitStrategy = strategy
+			})
+			if err != nil {
+				t.Errorf("fixed stack new: %v", err)
+				return
+			}
+			testFixedStackConcurrent(t, s, 0x04, 0x400)
+		})
+	}
+
+	t.Run("park close wakes pop", func(t *testing.T) {
+		s, err := sox.NewFixedStack[int64](func(options *sox.FixedStackOptions) {
+			options.WaitStrategy = sox.WaitStrategyPark
+		})
+		if err != nil {
+			t.Errorf("fixed stack new: %v", err)
+			return
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = s.Close()
+		}()
+		item, err := s.Pop()
+		if err != io.EOF {
+			t.Errorf("fixed stack pop expected %v but got %v %v", io.EOF, item, err)
+			return
+		}
+	})
+}
+
+func TestFixedStack_Peek(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		t.Run(fmt.Sprintf("concurrent %v", concurrent), func(t *testing.T) {
+			s, err := sox.NewFixedStack[int](func(options *sox.FixedStackOptions) {
+				options.Capacity = 0x7
+				options.Concurrent = concurrent
+				options.Nonblocking = true
+			})
+			if err != nil {
+				t.Errorf("fixed stack new: %v", err)
+				return
+			}
+			pk := s.(sox.ItemPeeker[int])
+			if pk.Cap() != 7 || pk.Len() != 0 {
+				t.Errorf("fixed stack expected len=0 cap=7 but got len=%d cap=%d", pk.Len(), pk.Cap())
+				return
+			}
+			item, err := pk.Peek()
+			if err != sox.ErrTemporarilyUnavailable {
+				t.Errorf("fixed stack peek expected ErrTemporarilyUnavailable but got %v %v", item, err)
+				return
+			}
+			for i := range 3 {
+				err = s.Push(i + 1)
+				if err != nil {
+					t.Errorf("fixed stack push: %v", err)
+					return
+				}
+			}
+			item, err = pk.Peek()
+			if err != nil || item != 3 || pk.Len() != 3 {
+				t.Errorf("fixed stack peek expected 3 with len=3 but got %v len=%d %v", item, pk.Len(), err)
+				return
+			}
+			_, _ = s.Pop()
+			item, err = pk.Peek()
+			if err != nil || item != 2 || pk.Len() != 2 {
+				t.Errorf("fixed stack peek expected 2 with len=2 but got %v len=%d %v", item, pk.Len(), err)
+				return
+			}
+			_ = s.Close()
+			for range 2 {
+				_, _ = s.Pop()
+			}
+			item, err = pk.Peek()
+			if err != io.EOF {
+				t.Errorf("fixed stack peek expected %v but got %v %v", io.EOF, item, err)
+				return
+			}
+		})
+	}
+}
+
 func BenchmarkFixedStackConcurrent(b *testing.B) {
 	b.Run("1 push goroutine 1 pop goroutine", func(b *testing.B) {
 		s, err := sox.NewFixedStack[int](func(options *sox.FixedStackOptions) {
@@ -219,6 +409,48 @@ func BenchmarkFixedStackConcurrent(b *testing.B) {
 	})
 }
 
+func BenchmarkFixedStackConcurrent_Batch(b *testing.B) {
+	s, err := sox.NewFixedStack[int](func(options *sox.FixedStackOptions) {
+		options.Concurrent = true
+		options.Nonblocking = false
+	})
+	if err != nil {
+		b.Errorf("fixed stack new: %v", err)
+		return
+	}
+	const m, batch = 64, 16
+	b.ResetTimer()
+	for range m {
+		go func() {
+			items := make([]int, batch)
+			for range b.N/m/batch + 1 {
+				_, err := s.PushMany(items)
+				if err != nil {
+					b.Errorf("fixed stack push many: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg := sync.WaitGroup{}
+	for range m {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			items := make([]int, batch)
+			for total := 0; total < b.N/m/batch*batch; {
+				n, err := s.PopN(items)
+				if err != nil {
+					b.Errorf("fixed stack pop n: %v", err)
+					return
+				}
+				total += n
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func testFixedStackNonblocking(t *testing.T, s sox.Stack[uintptr]) {
 	item, err := s.Pop()
 	if err != sox.ErrTemporarilyUnavailable {
@@ -336,6 +568,58 @@ func testFixedStackNonblocking(t *testing.T, s sox.Stack[uintptr]) {
 	}
 }
 
+func testFixedStackBatch(t *testing.T, s sox.Stack[int]) {
+	items := make([]int, 4)
+	n, err := s.PopN(items)
+	if n != 0 || err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("fixed stack pop n expected ErrTemporarilyUnavailable but got %d %v", n, err)
+		return
+	}
+	if item, ok := s.TryPop(); ok {
+		t.Errorf("fixed stack try pop expected not ok but got %d", item)
+		return
+	}
+	n, err = s.PushMany([]int{1, 2, 3, 4, 5})
+	if n != 5 || err != nil {
+		t.Errorf("fixed stack push many 5 items: %d %v", n, err)
+		return
+	}
+	n, err = s.PushMany([]int{6, 7, 8})
+	if n != 2 || err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("fixed stack push many expected 2 items and ErrTemporarilyUnavailable but got %d %v", n, err)
+		return
+	}
+	n, err = s.PopN(items)
+	if n != 4 || err != nil || items[0] != 7 || items[1] != 6 || items[2] != 5 || items[3] != 4 {
+		t.Errorf("fixed stack pop n expected [7 6 5 4] but got %v: %v", items[:n], err)
+		return
+	}
+	if item, ok := s.TryPop(); !ok || item != 3 {
+		t.Errorf("fixed stack try pop expected 3 but got %d %v", item, ok)
+		return
+	}
+	n, err = s.PopN(items)
+	if n != 2 || err != nil || items[0] != 2 || items[1] != 1 {
+		t.Errorf("fixed stack pop n expected [2 1] but got %v: %v", items[:n], err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Errorf("fixed stack close: %v", err)
+		return
+	}
+	n, err = s.PopN(items)
+	if n != 0 || err != io.EOF {
+		t.Errorf("fixed stack pop n expected %v but got %d %v", io.EOF, n, err)
+		return
+	}
+	n, err = s.PushMany([]int{1})
+	if n != 0 || err != io.ErrClosedPipe {
+		t.Errorf("fixed stack push many expected %v but got %d %v", io.ErrClosedPipe, n, err)
+		return
+	}
+}
+
 func testFixedStackConcurrent(t *testing.T, s sox.Stack[int64], m int, n int) {
 	stack, index, next := make([][]int64, m), make([][]int, m), make([]int64, m)
 	locks := make([]sync.Mutex, m)
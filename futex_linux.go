@@ -0,0 +1,92 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	_FUTEX_WAIT = 0
+	_FUTEX_WAKE = 1
+
+	_FUTEX2_SIZE_U32        = 0x02
+	_FUTEX_BITSET_MATCH_ANY = 0xffffffff
+)
+
+// Futex is a 32-bit word which goroutines, threads and processes can wait on
+// and wake up each other. A Futex placed in memory shared between processes,
+// e.g. memory mapped with MAP_SHARED, lets the processes block efficiently.
+// The zero value for Futex is ready to use
+type Futex uint32
+
+// FutexAt returns the Futex at the start of b. The b must be 4 bytes aligned
+// and have at least 4 bytes
+func FutexAt(b []byte) (*Futex, error) {
+	if len(b) < 4 || uintptr(unsafe.Pointer(&b[0]))&3 != 0 {
+		return nil, ErrInvalidParam
+	}
+
+	return (*Futex)(unsafe.Pointer(&b[0])), nil
+}
+
+// Load atomically loads the value of the Futex
+func (f *Futex) Load() uint32 {
+	return atomic.LoadUint32((*uint32)(f))
+}
+
+// Store atomically stores val into the Futex
+func (f *Futex) Store(val uint32) {
+	atomic.StoreUint32((*uint32)(f), val)
+}
+
+// Add atomically adds delta to the Futex and returns the new value
+func (f *Futex) Add(delta uint32) uint32 {
+	return atomic.AddUint32((*uint32)(f), delta)
+}
+
+// CompareAndSwap executes the compare-and-swap operation for the Futex
+func (f *Futex) CompareAndSwap(old, new uint32) bool {
+	return atomic.CompareAndSwapUint32((*uint32)(f), old, new)
+}
+
+// Wait blocks while the value of the Futex equals val, until it is woken up
+// A negative d means Wait blocks without timeout
+// It returns ErrTemporarilyUnavailable if the value does not equal val,
+// os.ErrDeadlineExceeded on timeout, and ErrInterruptedSyscall if it is
+// interrupted by a signal. Wakeups may be spurious, so the caller should
+// check the value again after Wait returns
+func (f *Futex) Wait(val uint32, d time.Duration) error {
+	var ts *unix.Timespec
+	if d >= 0 {
+		t := unix.NsecToTimespec(d.Nanoseconds())
+		ts = &t
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(f)), _FUTEX_WAIT, uintptr(val), uintptr(unsafe.Pointer(ts)), 0, 0)
+	if errno == unix.ETIMEDOUT {
+		return os.ErrDeadlineExceeded
+	}
+	if errno != 0 {
+		return errFromUnixErrno(errno)
+	}
+
+	return nil
+}
+
+// Wake wakes up at most n waiters of the Futex and returns the number of woken waiters
+func (f *Futex) Wake(n int) (woken int, err error) {
+	r, _, errno := unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(f)), _FUTEX_WAKE, uintptr(n), 0, 0, 0)
+	if errno != 0 {
+		return 0, errFromUnixErrno(errno)
+	}
+
+	return int(r), nil
+}
@@ -0,0 +1,90 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFutex_WaitWake(t *testing.T) {
+	t.Run("value mismatch", func(t *testing.T) {
+		f := sox.Futex(1)
+		err := f.Wait(0, -1)
+		if err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("futex wait expected ErrTemporarilyUnavailable but got %v", err)
+			return
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		f := sox.Futex(0)
+		err := f.Wait(0, time.Millisecond)
+		if err != os.ErrDeadlineExceeded {
+			t.Errorf("futex wait expected os.ErrDeadlineExceeded but got %v", err)
+			return
+		}
+	})
+
+	t.Run("wake", func(t *testing.T) {
+		f := new(sox.Futex)
+		done := make(chan error, 1)
+		go func() {
+			for f.Load() == 0 {
+				err := f.Wait(0, -1)
+				if err != nil && err != sox.ErrTemporarilyUnavailable && err != sox.ErrInterruptedSyscall {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+		time.Sleep(10 * time.Millisecond)
+		f.Store(1)
+		_, err := f.Wake(1)
+		if err != nil {
+			t.Errorf("futex wake: %v", err)
+			return
+		}
+		select {
+		case err = <-done:
+			if err != nil {
+				t.Errorf("futex wait: %v", err)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Errorf("futex wait was not woken up")
+			return
+		}
+	})
+
+	t.Run("shared memory", func(t *testing.T) {
+		b, err := unix.Mmap(-1, 0, os.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_ANONYMOUS)
+		if err != nil {
+			t.Errorf("mmap: %v", err)
+			return
+		}
+		defer unix.Munmap(b)
+		f, err := sox.FutexAt(b)
+		if err != nil {
+			t.Errorf("futex at: %v", err)
+			return
+		}
+		if f.Add(3) != 3 || b[0] != 3 {
+			t.Errorf("futex at expected to share the memory")
+			return
+		}
+		_, err = sox.FutexAt(b[1:])
+		if err != sox.ErrInvalidParam {
+			t.Errorf("futex at unaligned expected ErrInvalidParam but got %v", err)
+			return
+		}
+	})
+}
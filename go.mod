@@ -1,5 +1,5 @@
 module hybscloud.com/sox
 
-go 1.22
+go 1.23
 
 require golang.org/x/sys v0.17.0
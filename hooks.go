@@ -0,0 +1,92 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Hooks holds the callbacks which the event loop and the message codec invoke
+// along the I/O path. Any of the callbacks can be nil. The callbacks are called
+// synchronously in the I/O path, so that they should return quickly
+// A typical usage is to attach log/slog records or OpenTelemetry spans
+// to the context without forking the package
+type Hooks struct {
+	// OnAccept is called after the listener accepted a new connection
+	OnAccept func(ctx context.Context, conn Conn)
+	// OnRead is called after a whole message or n bytes have been read
+	OnRead func(ctx context.Context, n int)
+	// OnWrite is called after a whole message or n bytes have been written
+	OnWrite func(ctx context.Context, n int)
+	// OnClose is called after a connection has been closed
+	// The err is the reason of closing, nil means closed normally
+	OnClose func(ctx context.Context, err error)
+	// OnPoll is called after the polling goroutine woke up with n events
+	// and d is the duration it has waited
+	OnPoll func(ctx context.Context, n int, d time.Duration)
+	// OnError is called when an I/O operation failed with err
+	// Temporary errors such as ErrTemporarilyUnavailable are not reported
+	OnError func(ctx context.Context, err error)
+}
+
+func (h *Hooks) accept(ctx context.Context, conn Conn) {
+	if h == nil || h.OnAccept == nil {
+		return
+	}
+	h.OnAccept(ctx, conn)
+}
+
+func (h *Hooks) read(ctx context.Context, n int, err error) {
+	if h == nil {
+		return
+	}
+	if err == nil {
+		if h.OnRead != nil {
+			h.OnRead(ctx, n)
+		}
+		return
+	}
+	h.error(ctx, err)
+}
+
+func (h *Hooks) write(ctx context.Context, n int, err error) {
+	if h == nil {
+		return
+	}
+	if err == nil {
+		if h.OnWrite != nil {
+			h.OnWrite(ctx, n)
+		}
+		return
+	}
+	h.error(ctx, err)
+}
+
+func (h *Hooks) close(ctx context.Context, err error) {
+	if h == nil || h.OnClose == nil {
+		return
+	}
+	h.OnClose(ctx, err)
+}
+
+func (h *Hooks) poll(ctx context.Context, n int, d time.Duration) {
+	if h == nil || h.OnPoll == nil {
+		return
+	}
+	h.OnPoll(ctx, n, d)
+}
+
+func (h *Hooks) error(ctx context.Context, err error) {
+	if h == nil || h.OnError == nil {
+		return
+	}
+	switch err {
+	case ErrTemporarilyUnavailable, ErrInterruptedSyscall, io.ErrShortBuffer, io.EOF:
+		return
+	}
+	h.OnError(ctx, err)
+}
@@ -9,7 +9,6 @@ import (
 	"net"
 	"net/netip"
 	"strconv"
-	"strings"
 )
 
 var (
@@ -53,6 +52,12 @@ var (
 	UDPAddrFromAddrPort = net.UDPAddrFromAddrPort
 )
 
+// unmapAddrPort returns the IPv4 address port of an IPv4-mapped IPv6 one
+// and the others as they are
+func unmapAddrPort(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
+
 func SCTPAddrFromAddrPort(addr netip.AddrPort) *SCTPAddr {
 	return &SCTPAddr{
 		IP:   addr.Addr().AsSlice(),
@@ -67,7 +72,15 @@ var (
 	ResolveUDPAddr = net.ResolveUDPAddr
 )
 
+// ResolveSCTPAddr returns an address of SCTP end point with DefaultResolver
+// The network must be a SCTP network name: "sctp", "sctp4" or "sctp6"
 func ResolveSCTPAddr(network, address string) (*SCTPAddr, error) {
+	return ResolveSCTPAddrContext(context.Background(), DefaultResolver, network, address)
+}
+
+// ResolveSCTPAddrContext returns an address of SCTP end point with the resolver
+// The "sctp" network prefers IPv6 addresses and falls back to IPv4 ones
+func ResolveSCTPAddrContext(ctx context.Context, resolver Resolver, network, address string) (*SCTPAddr, error) {
 	switch network {
 	case "sctp", "sctp4", "sctp6":
 	case "": // a hint wildcard for Go 1.0 undocumented behavior
@@ -75,41 +88,42 @@ func ResolveSCTPAddr(network, address string) (*SCTPAddr, error) {
 	default:
 		return nil, UnknownNetworkError(network)
 	}
-	var want6 bool
-	if network == "sctp" || network == "sctp6" {
-		want6 = true
-	}
-	if network == "sctp" && strings.ContainsAny(address, ":[") {
-		want6 = true
-	}
 	if addrPort, err := netip.ParseAddrPort(address); err == nil {
-		if addrPort.Addr().Is6() && want6 {
-			return SCTPAddrFromAddrPort(addrPort), nil
-		}
-		if addrPort.Addr().Is4() && network != "sctp6" {
+		if addrPort.Addr().Is4() && network != "sctp6" || addrPort.Addr().Is6() && network != "sctp4" {
 			return SCTPAddrFromAddrPort(addrPort), nil
 		}
+		return nil, &AddrError{Err: "no suitable address", Addr: address}
 	}
-	addrList, err := net.DefaultResolver.LookupAddr(context.Background(), address)
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := lookupPort(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		return &SCTPAddr{Port: port}, nil
+	}
+	ipAddrs, err := resolver.LookupIPAddr(ctx, host)
 	if err != nil {
 		return nil, err
 	}
 	var addr4 *SCTPAddr = nil
-	for _, addr := range addrList {
-		addrPort, err := netip.ParseAddrPort(addr)
-		if err != nil {
-			continue
-		}
-		if want6 && addrPort.Addr().Is6() {
-			return SCTPAddrFromAddrPort(addrPort), nil
+	for _, ipAddr := range ipAddrs {
+		if ipAddr.IP.To4() == nil && network != "sctp4" {
+			return &SCTPAddr{IP: ipAddr.IP, Port: port, Zone: ipAddr.Zone}, nil
 		}
-		if addr4 == nil && addrPort.Addr().Is4() {
-			addr4 = SCTPAddrFromAddrPort(addrPort)
-			if !want6 {
+		if ipAddr.IP.To4() != nil && network != "sctp6" && addr4 == nil {
+			addr4 = &SCTPAddr{IP: ipAddr.IP, Port: port, Zone: ipAddr.Zone}
+			if network == "sctp4" {
 				return addr4, nil
 			}
 		}
 	}
+	if addr4 == nil {
+		return nil, &AddrError{Err: "no suitable address", Addr: host}
+	}
 
 	return addr4, nil
 }
@@ -143,13 +157,59 @@ func IP6AddressToBytes(ip net.IP) [16]byte {
 	}
 }
 
-func ip6ZoneID(zone string) int {
+// ip6ZoneID returns the index of the IPv6 zone, which is the name or the decimal
+// index of a network interface. An unknown zone is reported as an AddrError
+func ip6ZoneID(zone string) (int, error) {
 	if zone == "" {
-		return 0
+		return 0, nil
 	}
-	i, err := net.InterfaceByName(zone)
+	if i, err := strconv.Atoi(zone); err == nil && i >= 0 {
+		return i, nil
+	}
+	ifi, err := net.InterfaceByName(zone)
 	if err != nil {
-		panic(err)
+		return 0, &AddrError{Err: "unknown zone", Addr: zone}
+	}
+	return ifi.Index, nil
+}
+
+// ip6ZoneName returns the name of the network interface with the index id,
+// or the decimal id if there is no such interface
+func ip6ZoneName(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(id)); err == nil {
+		return ifi.Name
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// zoneError returns the error of the IPv6 zones of the addrs if any
+func zoneError(addrs ...Addr) error {
+	for _, addr := range addrs {
+		zone := ""
+		switch a := addr.(type) {
+		case *IPAddr:
+			if a != nil {
+				zone = a.Zone
+			}
+		case *TCPAddr:
+			if a != nil {
+				zone = a.Zone
+			}
+		case *UDPAddr:
+			if a != nil {
+				zone = a.Zone
+			}
+		case *SCTPAddr:
+			if a != nil {
+				zone = a.Zone
+			}
+		}
+		if _, err := ip6ZoneID(zone); err != nil {
+			return err
+		}
 	}
-	return i.Index
+	return nil
 }
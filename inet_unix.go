@@ -18,12 +18,24 @@ const (
 	defaultBacklog = 511
 )
 
+// listen reserves the placeholder fd for acceptWait and listens on the socket fd
+func listen(fd int) error {
+	reserveFd()
+	return unix.Listen(fd, defaultBacklog)
+}
+
+// acceptWait accepts a connection on the listener fd. When the process or the system
+// runs out of fds, it sheds the pending connection with the reserved fd and returns
+// ErrProcessFileLimit or ErrSystemFileLimit, so that the caller does not hot-spin
 func acceptWait(fd int) (nfd int, sa unix.Sockaddr, err error) {
-	for sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); sw.Once() {
+	for sw := NewSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); sw.Once() {
 		nfd, sa, err = unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
 		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
 			continue
 		}
+		if err == unix.EMFILE || err == unix.ENFILE {
+			shedConnection(fd)
+		}
 		if err != nil {
 			return 0, nil, errFromUnixErrno(err)
 		}
@@ -32,13 +44,47 @@ func acceptWait(fd int) (nfd int, sa unix.Sockaddr, err error) {
 	return
 }
 
+// acceptOnce accepts a pending connection of the non-blocking listener fd
+// without waiting. It returns ErrTemporarilyUnavailable if there is none
+func acceptOnce(fd int) (nfd int, sa unix.Sockaddr, err error) {
+	nfd, sa, err = unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+	if err == unix.EMFILE || err == unix.ENFILE {
+		shedConnection(fd)
+	}
+	if err != nil {
+		return 0, nil, errFromUnixErrno(err)
+	}
+	return
+}
+
+// sockname returns the address which the socket fd is bound to with getsockname
+// e.g. the port assigned by the kernel to the sockets bound to port 0, or sa
+// if getsockname fails
+func sockname(fd int, sa unix.Sockaddr) unix.Sockaddr {
+	lsa, err := unix.Getsockname(fd)
+	if err != nil || lsa == nil {
+		return sa
+	}
+	return lsa
+}
+
+// peername returns the address of the peer of the socket fd with getpeername
+// or sa if getpeername fails
+func peername(fd int, sa unix.Sockaddr) unix.Sockaddr {
+	rsa, err := unix.Getpeername(fd)
+	if err != nil || rsa == nil {
+		return sa
+	}
+	return rsa
+}
+
 func connectWait(fd int, sa unix.Sockaddr) error {
 	if err := unix.Connect(fd, sa); err == nil {
 		return nil
 	} else if err != unix.EINPROGRESS {
 		return errFromUnixErrno(err)
 	}
-	for sw := NewParamSpinWait(); !sw.Closed(); sw.Once() {
+	for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
 		val, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
 		if err != nil {
 			return errFromUnixErrno(err)
@@ -58,13 +104,21 @@ func inetAddrFromAddrPort(addrPort netip.AddrPort) unix.Sockaddr {
 		}
 	} else if addrPort.Addr().Is6() {
 		return &unix.SockaddrInet6{
-			Port: int(addrPort.Port()),
-			Addr: addrPort.Addr().As16(),
+			Port:   int(addrPort.Port()),
+			ZoneId: ip6ZoneIndex(addrPort.Addr().Zone()),
+			Addr:   addrPort.Addr().As16(),
 		}
 	}
 	return &unix.SockaddrInet6{}
 }
 
+// ip6ZoneIndex returns the index of the IPv6 zone, or 0 if the zone is unknown
+// The unknown zones are reported by zoneError before the addresses are used
+func ip6ZoneIndex(zone string) uint32 {
+	id, _ := ip6ZoneID(zone)
+	return uint32(id)
+}
+
 func unixAddrFromSockaddr(sa unix.Sockaddr, proto UnderlyingProtocol) *net.UnixAddr {
 	switch proto {
 	case UnderlyingProtocolStream:
@@ -87,7 +141,7 @@ func addrPortFromSockaddr(addr unix.Sockaddr) netip.AddrPort {
 		return netip.AddrPortFrom(netip.AddrFrom4(a.Addr), uint16(a.Port))
 	case *unix.SockaddrInet6:
 		a := addr.(*unix.SockaddrInet6)
-		return netip.AddrPortFrom(netip.AddrFrom16(a.Addr), uint16(a.Port))
+		return netip.AddrPortFrom(netip.AddrFrom16(a.Addr).WithZone(ip6ZoneName(a.ZoneId)), uint16(a.Port))
 	}
 	return netip.AddrPort{}
 }
@@ -137,7 +191,7 @@ func ip4AddrPortToSockaddr(addr *IPAddr, port int) unix.Sockaddr {
 
 func ip6AddrToSockaddr(addr *IPAddr) unix.Sockaddr {
 	return &unix.SockaddrInet6{
-		ZoneId: uint32(ip6ZoneID(addr.Zone)),
+		ZoneId: ip6ZoneIndex(addr.Zone),
 		Addr:   IP6AddressToBytes(addr.IP),
 	}
 }
@@ -145,7 +199,7 @@ func ip6AddrToSockaddr(addr *IPAddr) unix.Sockaddr {
 func ip6AddrPortToSockaddr(addr *IPAddr, port int) unix.Sockaddr {
 	return &unix.SockaddrInet6{
 		Port:   port,
-		ZoneId: uint32(ip6ZoneID(addr.Zone)),
+		ZoneId: ip6ZoneIndex(addr.Zone),
 		Addr:   IP6AddressToBytes(addr.IP),
 	}
 }
@@ -165,6 +219,9 @@ func ipAddrPortToSockaddr(addr *IPAddr, port int) unix.Sockaddr {
 }
 
 func tcp4AddrToSockaddr(addr *TCPAddr) unix.Sockaddr {
+	if addr == nil {
+		return &unix.SockaddrInet4{}
+	}
 	return &unix.SockaddrInet4{
 		Port: addr.Port,
 		Addr: IP4AddressToBytes(addr.IP),
@@ -172,9 +229,12 @@ func tcp4AddrToSockaddr(addr *TCPAddr) unix.Sockaddr {
 }
 
 func tcp6AddrToSockaddr(addr *TCPAddr) unix.Sockaddr {
+	if addr == nil {
+		return &unix.SockaddrInet6{}
+	}
 	return &unix.SockaddrInet6{
 		Port:   addr.Port,
-		ZoneId: uint32(ip6ZoneID(addr.Zone)),
+		ZoneId: ip6ZoneIndex(addr.Zone),
 		Addr:   IP6AddressToBytes(addr.IP),
 	}
 }
@@ -184,6 +244,9 @@ func tcpAddrToSockaddr(addr *TCPAddr) unix.Sockaddr {
 }
 
 func udp4AddrToSockaddr(addr *UDPAddr) unix.Sockaddr {
+	if addr == nil {
+		return &unix.SockaddrInet4{}
+	}
 	return &unix.SockaddrInet4{
 		Port: addr.Port,
 		Addr: IP4AddressToBytes(addr.IP),
@@ -191,9 +254,12 @@ func udp4AddrToSockaddr(addr *UDPAddr) unix.Sockaddr {
 }
 
 func udp6AddrToSockaddr(addr *UDPAddr) unix.Sockaddr {
+	if addr == nil {
+		return &unix.SockaddrInet6{}
+	}
 	return &unix.SockaddrInet6{
 		Port:   addr.Port,
-		ZoneId: uint32(ip6ZoneID(addr.Zone)),
+		ZoneId: ip6ZoneIndex(addr.Zone),
 		Addr:   IP6AddressToBytes(addr.IP),
 	}
 }
@@ -203,6 +269,9 @@ func udpAddrToSockaddr(addr *UDPAddr) unix.Sockaddr {
 }
 
 func sctp4AddrToSockaddr(addr *SCTPAddr) unix.Sockaddr {
+	if addr == nil {
+		return &unix.SockaddrInet4{}
+	}
 	return &unix.SockaddrInet4{
 		Port: addr.Port,
 		Addr: IP4AddressToBytes(addr.IP),
@@ -210,9 +279,12 @@ func sctp4AddrToSockaddr(addr *SCTPAddr) unix.Sockaddr {
 }
 
 func sctp6AddrToSockaddr(addr *SCTPAddr) unix.Sockaddr {
+	if addr == nil {
+		return &unix.SockaddrInet6{}
+	}
 	return &unix.SockaddrInet6{
 		Port:   addr.Port,
-		ZoneId: uint32(ip6ZoneID(addr.Zone)),
+		ZoneId: ip6ZoneIndex(addr.Zone),
 		Addr:   IP6AddressToBytes(addr.IP),
 	}
 }
@@ -244,7 +316,7 @@ func sockaddr(sa unix.Sockaddr) (ptr unsafe.Pointer, n int, err error) {
 		return inet6Sockaddr(sa.(*unix.SockaddrInet6))
 	case *unix.SockaddrUnix:
 		if len(sa.(*unix.SockaddrUnix).Name) >= unix.SizeofSockaddrUnix-unix.SizeofShort {
-			return unsafe.Pointer(uintptr(0)), 0, ErrInvalidParam
+			return nil, 0, ErrInvalidParam
 		}
 		return unixSockaddr(sa.(*unix.SockaddrUnix))
 	default:
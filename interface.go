@@ -6,6 +6,8 @@ package sox
 
 import (
 	"context"
+	"errors"
+	"syscall"
 	"time"
 )
 
@@ -17,8 +19,25 @@ type Interface interface {
 	AddIO(dispatch DispatchHandler, message MessageHandler, written WrittenHandler, closed ClosedHandler)
 	// AddTimer adds timer event with the given event handler
 	AddTimer(ticked TickedHandler)
-	// Serve starts serving
+	// AddSignal adds signal event on the given signals with the given event handler
+	AddSignal(handler SignalHandler, signals ...syscall.Signal)
+	// Execute posts fn to be run in the polling goroutine. It is safe to be called
+	// from any goroutine and wakes the polling goroutine up if it is waiting for events
+	Execute(fn func()) error
+	// Conns returns the registry of the connections of the event loop
+	Conns() *ConnTable
+	// Stats returns a snapshot of the statistics of the event loop
+	Stats() Stats
+	// Serve starts serving. It blocks until the event loop is shut down,
+	// and then returns ErrEventLoopClosed
 	Serve() error
+	// Shutdown stops polling and closes the listeners. It then waits for the
+	// in-flight handler invocations to return until ctx is done.
+	// The rings, the pollers and the connections are released last, and then
+	// Serve returns ErrEventLoopClosed. If ctx is done first, Shutdown releases
+	// them without waiting and returns the error of ctx.
+	// Shutdown is safe to call from any goroutine. Calls after the first are no-ops.
+	Shutdown(ctx context.Context) error
 	// Poll waits for events. The d parameter specifies the duration that Poll will block
 	// d == 0 means Poll method will return immediately even if there is no events came
 	// d < 0 means Poll method will block forever or until there are any events
@@ -34,13 +53,58 @@ type Options struct {
 	// Parallel <= 0 means the events will be handled at the same goroutine as the polling goroutine
 	// Parallel == 1 means the events will be handled in series at one another worker goroutine
 	// Parallel >= 2 means the events will be handled in parallel at Parallel worker goroutines
+	// while the messages of one connection are still handled in order, one at a time,
+	// by an OrderedExecutor keyed by the fd of the connection
 	// It is possible to specify which worker will be used to handle the event
 	// by implement your customized DispatchHandler
 	Parallel int
+	// PollerMaxEvents sets the maximum number of events handled per poll
+	// Larger values reduce the number of system calls under heavy load
+	// PollerMaxEvents <= 0 means the default value 1024 will be used
+	PollerMaxEvents int
+	// BusyPoll sets the kernel busy polling of the poller and the connections
+	// A nil BusyPoll means busy polling will be left as the system default
+	BusyPoll *BusyPollParams
+	// Metrics collects the statistics of the event loop. A nil Metrics means
+	// the event loop collects its own statistics which are only exposed by Stats
+	// Setting a shared Metrics lets multiple event loops be aggregated
+	Metrics *Metrics
+	// Hooks is invoked along the I/O path of the event loop and the messages
+	// of the connections. A nil Hooks means no hooks will be invoked
+	Hooks *Hooks
+	// ErrorHandler handles the panics of the MessageHandlers and the AcceptedHandlers,
+	// which are recovered so that one buggy handler can not take down the polling
+	// goroutine, and the errors the handlers surface with ReportError
+	// A nil ErrorHandler means the panics are recovered and dropped
+	ErrorHandler ErrorHandler
+	// ReadBudget limits the frames and the bytes read from one connection per
+	// wakeup. The saturated connections are requeued at the back of the ready
+	// list, so that one chatty connection can not starve the others
+	// The zero ReadBudget means DefaultReadBudget will be used
+	ReadBudget ReadBudget
+	// Logger logs the internal errors which have no handler to be returned to,
+	// e.g. the poller failures, the events of unregistered fds and the dropped
+	// io-uring submissions and completions. A *slog.Logger can be set directly
+	// and wrapped with NewRateLimitLogger to bound the rate of the records
+	// A nil Logger means the internal errors are dropped
+	Logger Logger
+	// ProfileLabels are the additional pprof label pairs of key and value which the
+	// polling goroutine and the worker goroutines are annotated with, besides the
+	// label of ProfileLabelKey, e.g. to tell the goroutines of multiple event loops
+	ProfileLabels []string
+	// ReadMemoryLimit bounds the bytes of the buffers which the messages of all
+	// the connections of the event loop are read into, see MemoryLimit. The reads
+	// park while the limit is exceeded, which applies backpressure to the peers
+	// The readers are charged with MessageOptionsReadMemoryLimit
+	// ReadMemoryLimit <= 0 means the buffers are not limited
+	ReadMemoryLimit int64
 }
 
 var defaultOptions = Options{}
 
+// ErrEventLoopClosed is returned by Serve and Poll after the event loop has been shut down
+var ErrEventLoopClosed = errors.New("event loop closed")
+
 // New creates and returns a new event loop with given options
 func New(options ...func(option *Options)) (evLoop Interface, err error) {
 	panic("todo")
@@ -87,6 +151,12 @@ type MessageHandler interface {
 	ServeMessage(ctx context.Context, reply PollWriter, request PollReader)
 }
 
+// ErrorHandler handles the errors of the other handlers. A recovered panic
+// is passed as a *HandlerPanicError with the stack of the panic
+type ErrorHandler interface {
+	ServeError(ctx context.Context, err error)
+}
+
 // WrittenHandler handles send message completed events
 type WrittenHandler interface {
 	ServeWritten(ctx context.Context, writer PollWriter)
@@ -0,0 +1,180 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	listenerAdapterStatusClosed = 1 << 30
+)
+
+// ListenerAdapterOptions holds optional parameters for ListenerAdapter
+type ListenerAdapterOptions struct {
+	// NetConn specifies whether the accepted connections are handed over to
+	// the network poller of the runtime as the connections of package net
+	// It should be set as true for net/http.Server and the other users of
+	// the standard library, since the connections of this package are
+	// non-blocking and do not support the deadlines
+	NetConn bool
+}
+
+// acceptor is implemented by the listeners of this package
+type acceptor interface {
+	Listener
+	Fd() int
+	// acceptOnce returns ErrTemporarilyUnavailable if there is no pending connection
+	acceptOnce() (Conn, error)
+}
+
+// ListenerAdapter adapts a TCPListener, a UnixListener or an SCTPListener
+// to the full net.Listener semantics which the standard library relies on
+// Accept blocks until a connection is accepted, the deadline is exceeded or
+// the ListenerAdapter is closed. Close unblocks the pending Accept calls,
+// which then return net.ErrClosed. Accept is safe to be called from any
+// number of goroutines, and each connection is accepted by one of them
+type ListenerAdapter struct {
+	*ListenerAdapterOptions
+	l        acceptor
+	efd      PollUintReadWriteCloser
+	deadline atomic.Int64
+	// status holds the closed bit and the number of in-flight Accept calls
+	status atomic.Int32
+}
+
+// NewListenerAdapter creates and returns a ListenerAdapter of l
+// The ListenerAdapter owns l and closes it on Close
+func NewListenerAdapter(l Listener, opts ...func(options *ListenerAdapterOptions)) (*ListenerAdapter, error) {
+	o := &ListenerAdapterOptions{
+		NetConn: false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	a, ok := l.(acceptor)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	efd, err := NewEventfd()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListenerAdapter{ListenerAdapterOptions: o, l: a, efd: efd}, nil
+}
+
+// Accept waits for and returns the next connection to the listener
+func (la *ListenerAdapter) Accept() (Conn, error) {
+	if la.status.Add(1)&listenerAdapterStatusClosed != 0 {
+		la.status.Add(-1)
+		return nil, la.opError(net.ErrClosed)
+	}
+	defer la.status.Add(-1)
+	fds := [2]unix.PollFd{
+		{Fd: int32(la.l.Fd()), Events: unix.POLLIN},
+		{Fd: int32(la.efd.Fd()), Events: unix.POLLIN},
+	}
+	for {
+		if la.status.Load()&listenerAdapterStatusClosed != 0 {
+			return nil, la.opError(net.ErrClosed)
+		}
+		// the deadline may be changed by SetDeadline while waiting
+		d := pollWaitInterval
+		if deadline := la.deadline.Load(); deadline != 0 {
+			d = min(d, time.Until(time.Unix(0, deadline)))
+			if d <= 0 {
+				return nil, la.opError(os.ErrDeadlineExceeded)
+			}
+		}
+		conn, err := la.l.acceptOnce()
+		if err == nil {
+			if la.NetConn {
+				return netConn(conn)
+			}
+			return conn, nil
+		}
+		if err != ErrTemporarilyUnavailable && err != ErrInterruptedSyscall {
+			return nil, err
+		}
+		_, err = unix.Poll(fds[:], int((d+time.Millisecond-1)/time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			return nil, la.opError(errFromUnixErrno(err))
+		}
+	}
+}
+
+// Close closes the ListenerAdapter and the listener. The pending
+// Accept calls are unblocked and return net.ErrClosed
+func (la *ListenerAdapter) Close() error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		status := la.status.Load()
+		if status&listenerAdapterStatusClosed != 0 {
+			return nil
+		}
+		if la.status.CompareAndSwap(status, status|listenerAdapterStatusClosed) {
+			break
+		}
+		sw.Once()
+	}
+	// the eventfd is left readable to wake up all of the pending Accept calls,
+	// and the listener is closed after them, so that its fd is never reused
+	// under them
+	err := la.efd.WriteUint(1)
+	sw = NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	for la.status.Load() != listenerAdapterStatusClosed {
+		sw.Once()
+	}
+
+	return errors.Join(err, la.l.Close(), la.efd.Close())
+}
+
+// Addr returns the address of the listener
+func (la *ListenerAdapter) Addr() Addr {
+	return la.l.Addr()
+}
+
+// SetDeadline sets the deadline of the Accept calls. The pending ones observe
+// the new deadline within a few milliseconds. A zero t means no deadline
+func (la *ListenerAdapter) SetDeadline(t time.Time) error {
+	if la.status.Load()&listenerAdapterStatusClosed != 0 {
+		return la.opError(net.ErrClosed)
+	}
+	if t.IsZero() {
+		la.deadline.Store(0)
+	} else {
+		la.deadline.Store(max(t.UnixNano(), 1))
+	}
+
+	return nil
+}
+
+func (la *ListenerAdapter) opError(err error) error {
+	return &OpError{Op: "accept", Net: la.l.Addr().Network(), Source: nil, Addr: la.l.Addr(), Err: err}
+}
+
+// netConn hands the connection over to the network poller of the runtime
+func netConn(conn Conn) (Conn, error) {
+	so, ok := conn.(interface{ Fd() int })
+	if !ok {
+		return conn, nil
+	}
+	f := os.NewFile(uintptr(so.Fd()), "")
+	nc, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return nc, nil
+}
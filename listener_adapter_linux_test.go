@@ -0,0 +1,153 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"context"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListenerAdapter_HTTP(t *testing.T) {
+	l, err := sox.ListenTCP4(&sox.TCPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8199})
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	la, err := sox.NewListenerAdapter(l, func(options *sox.ListenerAdapterOptions) {
+		options.NetConn = true
+	})
+	if err != nil {
+		_ = l.Close()
+		t.Errorf("new listener adapter: %v", err)
+		return
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "sox")
+	})}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(la)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{}, Timeout: 5 * time.Second}
+	for range 4 {
+		resp, err := client.Get("http://" + la.Addr().String() + "/")
+		if err != nil {
+			t.Errorf("http get: %v", err)
+			return
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil || string(body) != "sox" {
+			t.Errorf("http get expected %q but got %q: %v", "sox", body, err)
+			return
+		}
+	}
+	client.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("http shutdown: %v", err)
+		return
+	}
+	if err = <-served; err != http.ErrServerClosed {
+		t.Errorf("http serve expected http.ErrServerClosed but got %v", err)
+		return
+	}
+}
+
+func TestListenerAdapter_Accept(t *testing.T) {
+	l, err := sox.ListenTCP4(&sox.TCPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8199})
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	la, err := sox.NewListenerAdapter(l)
+	if err != nil {
+		_ = l.Close()
+		t.Errorf("new listener adapter: %v", err)
+		return
+	}
+	defer la.Close()
+
+	t.Run("deadline", func(t *testing.T) {
+		_ = la.SetDeadline(time.Now().Add(50 * time.Millisecond))
+		defer la.SetDeadline(time.Time{})
+		_, err := la.Accept()
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("accept expected timeout but got %v", err)
+			return
+		}
+	})
+
+	t.Run("concurrent accept", func(t *testing.T) {
+		const n = 4
+		accepted := make(chan sox.Conn, n)
+		wg := sync.WaitGroup{}
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := la.Accept()
+				if err != nil {
+					t.Errorf("accept: %v", err)
+					return
+				}
+				accepted <- conn
+			}()
+		}
+		for range n {
+			conn, err := net.DialTimeout("tcp4", la.Addr().String(), 5*time.Second)
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer conn.Close()
+		}
+		wg.Wait()
+		close(accepted)
+		for conn := range accepted {
+			_ = conn.Close()
+		}
+	})
+
+	t.Run("close unblocks accept", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := la.Accept()
+			done <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		err := la.Close()
+		if err != nil {
+			t.Errorf("close: %v", err)
+			return
+		}
+		select {
+		case err = <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("close did not unblock accept")
+			return
+		}
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("accept expected net.ErrClosed but got %v", err)
+			return
+		}
+		if _, err = la.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("accept expected net.ErrClosed but got %v", err)
+			return
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Logger is the interface of the loggers which the internal errors are logged
+// to, e.g. the poller failures, the events of unregistered fds and the dropped
+// completions, which have no handler to be returned to. *slog.Logger is a Logger
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// RateLimitLoggerOptions holds the options of NewRateLimitLogger
+type RateLimitLoggerOptions struct {
+	// Level is the minimum level of the records to pass. The default Level is slog.LevelInfo
+	Level slog.Level
+	// Burst is the number of the records of each message passed per Interval
+	// The default Burst is 10
+	Burst int
+	// Interval is the period which Burst applies to. The default Interval is 1 second
+	Interval time.Duration
+}
+
+var defaultRateLimitLoggerOptions = RateLimitLoggerOptions{
+	Level:    slog.LevelInfo,
+	Burst:    10,
+	Interval: time.Second,
+}
+
+// rateLimitLogger passes at most Burst records of each message per Interval
+type rateLimitLogger struct {
+	l   Logger
+	opt RateLimitLoggerOptions
+
+	mu     sync.Mutex
+	limits map[string]*logLimit
+}
+
+type logLimit struct {
+	start      time.Time
+	n          int
+	suppressed int
+}
+
+// NewRateLimitLogger returns a Logger which passes the records of the Level
+// or above to l, at most Burst records of each message per Interval, so that
+// an error repeated on the hot path does not flood the logs. The number of the
+// suppressed records of the message is appended to the next passed record as
+// the attribute "suppressed"
+func NewRateLimitLogger(l Logger, opts ...func(options *RateLimitLoggerOptions)) Logger {
+	opt := defaultRateLimitLoggerOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	opt.Burst = max(1, opt.Burst)
+	if opt.Interval <= 0 {
+		opt.Interval = defaultRateLimitLoggerOptions.Interval
+	}
+
+	return &rateLimitLogger{l: l, opt: opt, limits: make(map[string]*logLimit)}
+}
+
+func (r *rateLimitLogger) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if level < r.opt.Level {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	lim, ok := r.limits[msg]
+	if !ok {
+		lim = &logLimit{start: now}
+		r.limits[msg] = lim
+	}
+	if now.Sub(lim.start) >= r.opt.Interval {
+		lim.start, lim.n = now, 0
+	}
+	if lim.n >= r.opt.Burst {
+		lim.suppressed++
+		r.mu.Unlock()
+		return
+	}
+	lim.n++
+	suppressed := lim.suppressed
+	lim.suppressed = 0
+	r.mu.Unlock()
+	if suppressed > 0 {
+		args = append(args[:len(args):len(args)], slog.Int("suppressed", suppressed))
+	}
+	r.l.Log(ctx, level, msg, args...)
+}
+
+// logRecord logs the record to l unless l is nil
+func logRecord(ctx context.Context, l Logger, level slog.Level, msg string, args ...any) {
+	if l == nil {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	l.Log(ctx, level, msg, args...)
+}
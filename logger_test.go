@@ -0,0 +1,44 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"context"
+	"hybscloud.com/sox"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var l sox.Logger = slog.New(slog.NewTextHandler(buf, nil))
+	l = sox.NewRateLimitLogger(l, func(options *sox.RateLimitLoggerOptions) {
+		options.Level = slog.LevelWarn
+		options.Burst = 2
+		options.Interval = 50 * time.Millisecond
+	})
+	ctx := context.Background()
+	for range 5 {
+		l.Log(ctx, slog.LevelError, "poller failed", "fd", 3)
+	}
+	l.Log(ctx, slog.LevelWarn, "unregistered fd", "fd", 4)
+	l.Log(ctx, slog.LevelInfo, "below level")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], "unregistered fd") {
+		t.Errorf("logger expected 3 records but got %q", lines)
+		return
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	buf.Reset()
+	l.Log(ctx, slog.LevelError, "poller failed", "fd", 3)
+	if !strings.Contains(buf.String(), "suppressed=3") {
+		t.Errorf("logger expected the suppressed records but got %q", buf.String())
+		return
+	}
+}
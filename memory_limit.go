@@ -0,0 +1,100 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"sync/atomic"
+)
+
+// MemoryLimit bounds the bytes of the buffers leased by the message readers
+// which share it, e.g. all the connections of an event loop, so that
+// thousands of clients sending messages of the maximum size at once can not
+// run the process out of memory. The buffers are charged before being leased
+// and uncharged when the leases are released. The blocking readers park while
+// the limit is exceeded, and the non-blocking readers fail with
+// ErrTemporarilyUnavailable, which must be retried after leases are released
+// A message longer than the limit is skipped with ErrMsgTooLong. The readers of
+// packets lease buffers of 64KiB, which the limit must be at least
+type MemoryLimit struct {
+	limit int64
+	inUse atomic.Int64
+	pk    *parker
+}
+
+// NewMemoryLimit creates and returns a new MemoryLimit of limit bytes
+func NewMemoryLimit(limit int64) *MemoryLimit {
+	if limit < 1 {
+		panic("bad memory limit")
+	}
+	return &MemoryLimit{limit: limit, pk: newParker()}
+}
+
+// Limit returns the limit in bytes
+func (m *MemoryLimit) Limit() int64 {
+	return m.limit
+}
+
+// InUse returns the bytes charged and not uncharged yet
+func (m *MemoryLimit) InUse() int64 {
+	return m.inUse.Load()
+}
+
+// tryCharge charges n bytes unless it would exceed the limit
+func (m *MemoryLimit) tryCharge(n int64) bool {
+	for {
+		inUse := m.inUse.Load()
+		if inUse+n > m.limit {
+			return false
+		}
+		if m.inUse.CompareAndSwap(inUse, inUse+n) {
+			return true
+		}
+	}
+}
+
+// charge charges n bytes, and parks until the charge fits in the limit
+// unless nonblock is true, which fails with ErrTemporarilyUnavailable
+// A charge larger than the limit fails with ErrMsgTooLong
+func (m *MemoryLimit) charge(n int64, nonblock bool) error {
+	if n > m.limit {
+		return ErrMsgTooLong
+	}
+	for {
+		if m.tryCharge(n) {
+			return nil
+		}
+		if nonblock {
+			return ErrTemporarilyUnavailable
+		}
+		gen := m.pk.prepare()
+		if m.tryCharge(n) {
+			m.pk.cancel()
+			return nil
+		}
+		m.pk.park(gen)
+	}
+}
+
+// uncharge gives n bytes back and wakes up the parked readers
+func (m *MemoryLimit) uncharge(n int64) {
+	if m.inUse.Add(-n) < 0 {
+		panic("uncharge more than charged")
+	}
+	m.pk.unpark()
+}
+
+// MessageOptionsReadMemoryLimit returns the MessageOptions function of the
+// connections of an event loop with opt, which charges the readers it is applied
+// to one MemoryLimit of opt.ReadMemoryLimit. The MemoryLimit of the readers is
+// left as it is if opt.ReadMemoryLimit <= 0
+func MessageOptionsReadMemoryLimit(opt *Options) func(options *MessageOptions) {
+	if opt.ReadMemoryLimit <= 0 {
+		return func(options *MessageOptions) {}
+	}
+	limit := NewMemoryLimit(opt.ReadMemoryLimit)
+	return func(options *MessageOptions) {
+		options.MemoryLimit = limit
+	}
+}
@@ -0,0 +1,171 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"io"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	newReader := func(limit *sox.MemoryLimit, nonblock bool, payloads ...int) sox.MessageLeaseReader {
+		buf := &bytes.Buffer{}
+		w := sox.NewMessageWriter(buf)
+		for _, n := range payloads {
+			_, _ = w.Write(make([]byte, n))
+		}
+		return sox.NewMessageReader(buf, func(options *sox.MessageOptions) {
+			options.MemoryLimit = limit
+			options.Nonblock = nonblock
+		}).(sox.MessageLeaseReader)
+	}
+
+	t.Run("nonblock", func(t *testing.T) {
+		limit := sox.NewMemoryLimit(512)
+		r0, r1 := newReader(limit, true, 300), newReader(limit, true, 300)
+		l0, err := r0.ReadLease()
+		if err != nil || limit.InUse() != 300 {
+			t.Errorf("read lease expected 300 bytes charged but got %d: %v", limit.InUse(), err)
+			return
+		}
+		_, err = r1.ReadLease()
+		if err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("read lease expected ErrTemporarilyUnavailable but got %v", err)
+			return
+		}
+		l0.Release()
+		l1, err := r1.ReadLease()
+		if err != nil || l1.Len() != 300 || limit.InUse() != 300 {
+			t.Errorf("read lease expected 300 bytes but got %d with %d bytes charged: %v", l1.Len(), limit.InUse(), err)
+			return
+		}
+		l1.Release()
+		if limit.InUse() != 0 {
+			t.Errorf("expected nothing charged but got %d bytes", limit.InUse())
+			return
+		}
+	})
+
+	t.Run("park", func(t *testing.T) {
+		limit := sox.NewMemoryLimit(512)
+		r0, r1 := newReader(limit, false, 300), newReader(limit, false, 300)
+		l0, err := r0.ReadLease()
+		if err != nil {
+			t.Errorf("read lease: %v", err)
+			return
+		}
+		read := make(chan error, 1)
+		go func() {
+			l1, err := r1.ReadLease()
+			if err == nil {
+				l1.Release()
+			}
+			read <- err
+		}()
+		select {
+		case err = <-read:
+			t.Errorf("expected the read to park but got %v", err)
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+		l0.Release()
+		select {
+		case err = <-read:
+			if err != nil {
+				t.Errorf("read lease: %v", err)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected the read to be woken up after the release")
+			return
+		}
+	})
+
+	t.Run("many parked readers", func(t *testing.T) {
+		const n = 2000
+		limit := sox.NewMemoryLimit(512)
+		l0, err := newReader(limit, false, 300).ReadLease()
+		if err != nil {
+			t.Errorf("read lease: %v", err)
+			return
+		}
+		threads := pprof.Lookup("threadcreate").Count()
+		wg := sync.WaitGroup{}
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l, err := newReader(limit, false, 300).ReadLease()
+				if err != nil {
+					t.Errorf("read lease: %v", err)
+					return
+				}
+				l.Release()
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		// the parked readers hold no OS threads
+		if created := pprof.Lookup("threadcreate").Count() - threads; created > n/10 {
+			t.Errorf("expected the parked readers to hold no threads but %d threads were created", created)
+		}
+		l0.Release()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("expected all of the readers to be woken up")
+			return
+		}
+	})
+
+	t.Run("larger than limit", func(t *testing.T) {
+		limit := sox.NewMemoryLimit(64)
+		r := newReader(limit, true, 1024, 16)
+		_, err := r.ReadLease()
+		if err != sox.ErrMsgTooLong || limit.InUse() != 0 {
+			t.Errorf("read lease expected ErrMsgTooLong but got %v with %d bytes charged", err, limit.InUse())
+			return
+		}
+		l, err := r.ReadLease()
+		if err != nil || l.Len() != 16 {
+			t.Errorf("read lease expected the next message but got %v", err)
+			return
+		}
+		l.Release()
+		if _, err = r.ReadLease(); err != io.EOF {
+			t.Errorf("read lease expected io.EOF but got %v", err)
+			return
+		}
+	})
+
+	t.Run("event loop options", func(t *testing.T) {
+		opt := sox.MessageOptionsReadMemoryLimit(&sox.Options{ReadMemoryLimit: 512})
+		r := [2]sox.MessageLeaseReader{}
+		for i := range r {
+			buf := &bytes.Buffer{}
+			_, _ = sox.NewMessageWriter(buf).Write(make([]byte, 300))
+			r[i] = sox.NewMessageReader(buf, opt, sox.MessageOptionsNonblock).(sox.MessageLeaseReader)
+		}
+		l, err := r[0].ReadLease()
+		if err != nil {
+			t.Errorf("read lease: %v", err)
+			return
+		}
+		defer l.Release()
+		if _, err = r[1].ReadLease(); err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("read lease expected the shared limit to be exceeded but got %v", err)
+			return
+		}
+	})
+}
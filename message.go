@@ -6,27 +6,82 @@ package sox
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"iter"
 	"sync/atomic"
+	"time"
 )
 
 // MessageOptions represents message feature options
 type MessageOptions struct {
 	// ReadByteOrder sets which byte order will be used when reading data
+	// Both of the peers must use the same byte order, since the extended lengths
+	// are laid out differently, see NegotiateByteOrder
 	ReadByteOrder binary.ByteOrder
 	// WriteByteOrder sets which byte order will be used when writing data
 	WriteByteOrder binary.ByteOrder
+	// NetworkOrderOnly sets the reader and the writer to use the network byte
+	// order regardless of ReadByteOrder and WriteByteOrder
+	NetworkOrderOnly bool
 	// ReadProto sets which protocol type will be used when reading data
 	ReadProto UnderlyingProtocol
 	// WriteProto sets which protocol type will be used when writing data
 	WriteProto UnderlyingProtocol
 	// ReadLimit is the maximum message payload data size
 	// A ReadLimit of zero indicates that there is no limit
+	// Reading a longer message skips the message and returns ErrMsgTooLong
 	ReadLimit int
+	// WriteLimit is the maximum message payload data size to write
+	// A WriteLimit of zero indicates that there is no limit
+	WriteLimit int
 	// Nonblock if the nonblock flag is true, Message will not block on I/O
 	Nonblock bool
+	// BufferPool is the pool which ReadLease acquires buffers from
+	// A nil BufferPool indicates that DefaultBufferPool will be used
+	BufferPool *BufferPool
+	// Hooks is invoked after each message has been read or written
+	// A nil Hooks indicates that no hooks will be invoked
+	Hooks *Hooks
+	// Context is the context which Hooks are invoked with
+	// A nil Context indicates that context.Background will be used
+	Context context.Context
+	// FrameFormat sets which framing format will be used over stream protocols
+	FrameFormat FrameFormat
+	// WebSocket holds the options of FrameFormatWebSocket
+	WebSocket WebSocketOptions
+	// WaitStrategy specifies how the blocking reads and writes wait while the
+	// underlying reader or writer is temporarily unavailable
+	// The default WaitStrategy is WaitStrategySpin
+	WaitStrategy WaitStrategy
+	// Codec is the Codec which ReadInto and WriteValue marshal the values with
+	// A nil Codec indicates that JSONCodec will be used
+	Codec Codec
+	// Strict sets the reader to validate the length prefixes of the untrusted
+	// peers. The non-canonical lengths, e.g. an extended length of FrameFormatSox
+	// which fits in a shorter encoding, are rejected with a *MessageProtocolError
+	// after which the reader keeps failing, since the stream can not be resynced
+	// A zero ReadLimit of a strict reader means DefaultStrictReadLimit, so that
+	// a forged length can not make the reader allocate an arbitrary buffer
+	Strict bool
+	// BatchSize enables the micro-batching writer mode over stream protocols
+	// The written frames are buffered until BatchSize bytes have been buffered
+	// or BatchDelay has elapsed, whichever comes first, and then flushed with
+	// a single writev, which trades latency for fewer system calls with small
+	// messages. A BatchSize of zero indicates that the mode is disabled
+	// The frames must be flushed explicitly with MessageFlusher before closing
+	BatchSize int
+	// BatchDelay is the maximum time which a frame is buffered for
+	// A BatchDelay of zero indicates that the frames are buffered until
+	// BatchSize is reached or Flush is called
+	BatchDelay time.Duration
+	// MemoryLimit is the MemoryLimit which the buffers leased by ReadLease and
+	// Messages are charged to, which is usually shared by the readers of many
+	// connections. A nil MemoryLimit indicates that the buffers are not limited
+	MemoryLimit *MemoryLimit
 }
 
 var defaultMessageOptions = MessageOptions{
@@ -35,6 +90,7 @@ var defaultMessageOptions = MessageOptions{
 	ReadProto:      UnderlyingProtocolStream,
 	WriteProto:     UnderlyingProtocolStream,
 	Nonblock:       false,
+	FrameFormat:    FrameFormatSox,
 }
 
 // MessageOptionsTCPSocket sets feature options for TCP sockets
@@ -59,27 +115,84 @@ var MessageOptionsNetworkOrder = func(options *MessageOptions) {
 	options.WriteByteOrder = binary.BigEndian
 }
 
+// MessageOptionsNetworkOrderOnly sets byte order to big endian
+// which can not be overridden by the other options
+var MessageOptionsNetworkOrderOnly = func(options *MessageOptions) {
+	options.NetworkOrderOnly = true
+}
+
 // MessageOptionsNonblock sets message nonblock
 var MessageOptionsNonblock = func(options *MessageOptions) {
 	options.Nonblock = true
 }
 
+// MessageOptionsWebSocket sets feature options for the server side of WebSocket
+// connections, which read masked frames and write unmasked frames
+var MessageOptionsWebSocket = func(options *MessageOptions) {
+	options.ReadByteOrder = binary.BigEndian
+	options.WriteByteOrder = binary.BigEndian
+	options.ReadProto = UnderlyingProtocolStream
+	options.WriteProto = UnderlyingProtocolStream
+	options.FrameFormat = FrameFormatWebSocket
+	options.WebSocket.Client = false
+}
+
+// MessageOptionsWebSocketClient sets feature options for the client side of
+// WebSocket connections, which read unmasked frames and write masked frames
+var MessageOptionsWebSocketClient = func(options *MessageOptions) {
+	MessageOptionsWebSocket(options)
+	options.WebSocket.Client = true
+}
+
+// MessageLeaseReader is the interface that groups the basic Read method and ReadLease method
+type MessageLeaseReader interface {
+	io.Reader
+	// ReadLease reads a whole message into a buffer leased from the BufferPool
+	// The caller must Release the returned lease after the message has been handled
+	ReadLease() (lease *BufferLease, err error)
+}
+
+// MessageDiscarder is the interface that wraps the Discard method
+type MessageDiscarder interface {
+	// Discard skips the rest of the current message, e.g. after a read has
+	// returned io.ErrShortBuffer, or the next message if no message is being
+	// read, and returns the number of skipped payload bytes. The payload is
+	// not buffered, so that the stream stays usable after unwanted messages
+	Discard() (n int64, err error)
+}
+
+// MessageIterator is the interface that wraps the Messages method
+type MessageIterator interface {
+	// Messages returns an iterator over the messages read until io.EOF
+	// The message is in a buffer leased from the BufferPool, which is only
+	// valid until the next iteration. Errors are yielded with a nil message
+	// and stop the iteration, except ErrMsgTooLong of skipped messages
+	Messages() iter.Seq2[[]byte, error]
+}
+
 // NewMessageReader creates and returns a new io.Reader to read messages
+// The returned io.Reader also implements MessageLeaseReader, MessageValueReader,
+// MessageContextReader, MessageDiscarder and MessageIterator
 func NewMessageReader(reader io.Reader, opts ...func(options *MessageOptions)) io.Reader {
 	return &messageReader{message: newMessage(reader, nil, opts...)}
 }
 
 // NewMessageWriter creates and returns a new io.Writer to write messages
+// The returned io.Writer also implements MessageValueWriter, MessageContextWriter and MessageFlusher
 func NewMessageWriter(writer io.Writer, opts ...func(options *MessageOptions)) io.Writer {
 	return &messageWriter{message: newMessage(nil, writer, opts...)}
 }
 
 // NewMessageReadWriter creates and returns a new io.ReadWriter to read and write messages
+// With FrameFormatWebSocket, the reader replies to ping and close frames with the writer
 func NewMessageReadWriter(reader io.Reader, writer io.Writer, opts ...func(options *MessageOptions)) io.ReadWriter {
-	return &messageReadWriter{
+	rw := &messageReadWriter{
 		messageReader: &messageReader{newMessage(reader, nil, opts...)},
 		messageWriter: &messageWriter{newMessage(nil, writer, opts...)},
 	}
+	rw.messageReader.peer = rw.messageWriter.message
+
+	return rw
 }
 
 // NewMessagePipe creates and returns a synchronous in-memory message pipe
@@ -102,6 +215,23 @@ const (
 	UnderlyingProtocolSeqPacket UnderlyingProtocol = 5
 )
 
+// FrameFormat represents the framing format of messages over stream protocols
+type FrameFormat int
+
+const (
+	// FrameFormatSox is the original message protocol format of sox
+	FrameFormatSox FrameFormat = iota
+	// FrameFormatWebSocket is the framing format of RFC 6455
+	FrameFormatWebSocket
+	// FrameFormatFixed16 prefixes each message with a 2-byte payload length
+	FrameFormatFixed16
+	// FrameFormatFixed32 prefixes each message with a 4-byte payload length
+	FrameFormatFixed32
+	// FrameFormatVarint prefixes each message with the payload length
+	// encoded as a protobuf varint of at most 8 bytes
+	FrameFormatVarint
+)
+
 // PreserveBoundary returns true if the underlying protocol preserves message boundaries
 func (t UnderlyingProtocol) PreserveBoundary() bool {
 	switch t {
@@ -149,14 +279,45 @@ var (
 	ErrMsgTooLong = errors.New("message too long")
 	// ErrMsgClosed will be returned when try to read or write on a closed reader or writer
 	ErrMsgClosed = errors.New("message closed")
+	// ErrMsgDropped will be returned when a message is dropped by the backpressure policy
+	ErrMsgDropped = errors.New("message dropped")
+	// ErrMsgProtocol will be returned when the peer violates the framing format
+	ErrMsgProtocol = errors.New("message protocol error")
 )
 
+// DefaultStrictReadLimit is the ReadLimit of the strict readers without a ReadLimit
+const DefaultStrictReadLimit = 64 << 20
+
+// DefaultLeaseReadLimit is the maximum message which ReadLease leases a buffer
+// for without a ReadLimit, so that a forged length prefix can not run the
+// process out of memory. The longer messages are skipped with ErrMsgTooLong
+const DefaultLeaseReadLimit = 64 << 20
+
+// MessageProtocolError is the error of a malformed length prefix which a
+// strict reader has rejected. It wraps ErrMsgProtocol
+type MessageProtocolError struct {
+	// Header is the length prefix which has been read
+	Header []byte
+	// Reason describes why the length prefix has been rejected
+	Reason string
+}
+
+func (e *MessageProtocolError) Error() string {
+	return fmt.Sprintf("message protocol error: %s: % x", e.Reason, e.Header)
+}
+
+func (e *MessageProtocolError) Unwrap() error {
+	return ErrMsgProtocol
+}
+
 const (
 	messageHeaderLength           = 1
 	messagePayloadMaxLength8Bits  = 1<<8 - 3
 	messagePayloadMaxLength16Bits = 1<<16 - 1
 	messagePayloadMaxLength56Bits = 1<<56 - 1
 
+	messageReadPacketSize = 1 << 16
+
 	messageStatusRead   uint32 = 4
 	messageStatusWrite  uint32 = 2
 	messageStatusClosed uint32 = 0x2000
@@ -176,8 +337,25 @@ type message struct {
 	offset int64
 	count  atomic.Int32
 
-	readLimit int64
-	nonblock  bool
+	readLimit  int64
+	writeLimit int64
+	discard    int64
+	discarded  int64
+	discarding bool
+	strict     bool
+	rerr       error
+	nonblock   bool
+	strategy   WaitStrategy
+	pool       *BufferPool
+	memLimit   *MemoryLimit
+	codec      Codec
+	hooks      *Hooks
+	ctx        context.Context
+	format     FrameFormat
+	ws         *webSocket
+	peer       *message
+	batch      *batchWriter
+	stats      messageStats
 
 	done bool
 }
@@ -186,7 +364,8 @@ func (msg *message) close() error {
 	if msg.done {
 		return nil
 	}
-	for sw := NewParamSpinWait(); !sw.Closed(); {
+	sw := NewSpinWait().SetContext(msg.ctx)
+	for !sw.Closed() {
 		status := msg.status.Load()
 		if (status & (messageStatusRead | messageStatusWrite)) == (messageStatusRead | messageStatusWrite) {
 			if msg.nonblock {
@@ -200,10 +379,10 @@ func (msg *message) close() error {
 			msg.done = true
 			return nil
 		}
-		sw.OnceWithLevel(spinWaitLevelAtomic)
+		sw.OnceWithLevel(SpinWaitLevelAtomic)
 	}
 
-	return nil
+	return sw.Err()
 }
 
 func (msg *message) setReadWriter(rw io.ReadWriter, order binary.ByteOrder, typ UnderlyingProtocol) {
@@ -222,16 +401,38 @@ func (msg *message) setWriter(w io.Writer, order binary.ByteOrder, typ Underlyin
 }
 
 func (msg *message) read(p []byte) (n int, err error) {
+	n, err = msg.readFrame(p)
+	msg.hooks.read(msg.ctx, n, err)
+	msg.stats.read(err)
+	return
+}
+
+// readFrame reads a message like read without invoking hooks
+func (msg *message) readFrame(p []byte) (n int, err error) {
 	if msg.done {
 		return 0, io.EOF
 	}
+	if msg.rerr != nil {
+		return 0, msg.rerr
+	}
 	if _, ok := msg.enterRead(); !ok {
 		return 0, ErrTemporarilyUnavailable
 	}
-	if msg.rpr.PreserveBoundary() {
-		return msg.readPacket(p)
+	if msg.discarding {
+		err = msg.discardMessage()
+		if err != ErrTemporarilyUnavailable {
+			msg.exitRead()
+		}
+	} else if msg.rpr.PreserveBoundary() {
+		n, err = msg.readPacket(p)
+	} else if msg.format == FrameFormatWebSocket {
+		n, err = msg.readWebSocket(p)
+	} else if msg.format != FrameFormatSox {
+		n, err = msg.readPrefixed(p)
+	} else {
+		n, err = msg.readStream(p)
 	}
-	return msg.readStream(p)
+	return
 }
 
 func (msg *message) readStream(p []byte) (n int, err error) {
@@ -249,16 +450,13 @@ func (msg *message) readStream(p []byte) (n int, err error) {
 		}
 		if err == io.EOF {
 			if msg.offset < messageHeaderLength {
-				return 0, io.ErrUnexpectedEOF
+				return 0, io.EOF
 			}
 			break
 		}
 	}
 	exLengthBytes := int64(0)
 	if msg.offset >= messageHeaderLength {
-		if msg.readLimit > 0 && msg.length > msg.readLimit {
-			return 0, ErrMsgTooLong
-		}
 		if msg.header[0] == messagePayloadMaxLength8Bits+1 {
 			exLengthBytes = 2
 		} else if msg.header[0] == messagePayloadMaxLength8Bits+2 {
@@ -270,7 +468,7 @@ func (msg *message) readStream(p []byte) (n int, err error) {
 	}
 	if msg.offset < messageHeaderLength+exLengthBytes {
 		for rn := 0; msg.offset < messageHeaderLength+exLengthBytes; {
-			rn, err = msg.readOnce(msg.header[messageHeaderLength : messageHeaderLength+exLengthBytes])
+			rn, err = msg.readOnce(msg.header[msg.offset : messageHeaderLength+exLengthBytes])
 			msg.offset += int64(rn)
 			if err != nil && err != io.EOF && (err != ErrTemporarilyUnavailable || msg.nonblock) {
 				return
@@ -296,21 +494,20 @@ func (msg *message) readStream(p []byte) (n int, err error) {
 		} else {
 			msg.length = int64(msg.header[0])
 		}
+		if msg.strict {
+			err = msg.validateLength(messageHeaderLength+exLengthBytes, messageExLengthBytes(msg.length) != exLengthBytes)
+			if err != nil {
+				return 0, err
+			}
+		}
 	}
-	if msg.readLimit > 0 && msg.length > msg.readLimit {
-		return 0, ErrMsgTooLong
+	if msg.readLimit > 0 && msg.length > msg.readLimit && msg.offset == messageHeaderLength+exLengthBytes {
+		msg.discard, msg.discarding = msg.length, true
+		return 0, msg.discardMessage()
 	}
 	// we assume that generally a 4K buffer p []byte will be given
+	// the read can be retried with a larger buffer, see readLease
 	if msg.length > int64(len(p)) {
-		if msg.length < (1 << 16) {
-			// TODO: acquire a 64k buffer from pool
-		} else if msg.length < (1 << 20) {
-			// TODO: acquire a 1m buffer from pool
-		} else if msg.length < (1 << 24) {
-			// TODO: acquire a 16m buffer from pool
-		} else {
-			// TODO: non-buffered work mode
-		}
 		return 0, io.ErrShortBuffer
 	}
 	for rn := 0; msg.offset < messageHeaderLength+exLengthBytes+msg.length; {
@@ -332,6 +529,118 @@ func (msg *message) readStream(p []byte) (n int, err error) {
 	msg.reset()
 	return
 }
+
+// readPrefixed reads a message of the length-prefix frame formats
+func (msg *message) readPrefixed(p []byte) (n int, err error) {
+	defer func() {
+		if err != ErrTemporarilyUnavailable {
+			msg.exitRead()
+		}
+	}()
+
+	headerLength, length, err := msg.prefixHeader()
+	for rn := 0; headerLength == 0 && err == nil; {
+		want := msg.offset + 1
+		if msg.format == FrameFormatFixed16 {
+			want = 2
+		} else if msg.format == FrameFormatFixed32 {
+			want = 4
+		}
+		rn, err = msg.readOnce(msg.header[msg.offset:want])
+		msg.offset += int64(rn)
+		if err == io.EOF && msg.offset < want {
+			if msg.offset == 0 {
+				return 0, io.EOF
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		headerLength, length, err = msg.prefixHeader()
+	}
+	if err != nil {
+		return 0, err
+	}
+	msg.length = length
+	if msg.strict && msg.offset == headerLength && msg.format == FrameFormatVarint {
+		// a varint is overlong if its last byte is zero
+		err = msg.validateLength(headerLength, headerLength > 1 && msg.header[headerLength-1] == 0)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if msg.readLimit > 0 && msg.length > msg.readLimit && msg.offset == headerLength {
+		msg.discard, msg.discarding = msg.length, true
+		return 0, msg.discardMessage()
+	}
+	// the read can be retried with a larger buffer, see readLease
+	if msg.length > int64(len(p)) {
+		return 0, io.ErrShortBuffer
+	}
+	for rn := 0; msg.offset < headerLength+msg.length; {
+		rn, err = msg.readOnce(p[msg.offset-headerLength : msg.length])
+		msg.offset += int64(rn)
+		n += rn
+		if err != nil && err != io.EOF {
+			return
+		}
+		if err == io.EOF {
+			if msg.offset < headerLength+msg.length {
+				return n, io.ErrUnexpectedEOF
+			}
+			break
+		}
+	}
+
+	msg.count.Add(-1)
+	msg.reset()
+	return
+}
+
+// validateLength fails the reader with a *MessageProtocolError if the length
+// prefix of headerLength bytes is non-canonical
+func (msg *message) validateLength(headerLength int64, nonCanonical bool) error {
+	if !nonCanonical {
+		return nil
+	}
+	msg.rerr = &MessageProtocolError{
+		Header: append([]byte(nil), msg.header[:headerLength]...),
+		Reason: "non-canonical length",
+	}
+	msg.reset()
+	return msg.rerr
+}
+
+// prefixHeader decodes the length prefix which has been read into the header
+// A headerLength of zero indicates that more bytes of the prefix are needed
+func (msg *message) prefixHeader() (headerLength int64, length int64, err error) {
+	switch msg.format {
+	case FrameFormatFixed16:
+		if msg.offset >= 2 {
+			return 2, int64(msg.rbo.Uint16(msg.header[:2])), nil
+		}
+	case FrameFormatFixed32:
+		if msg.offset >= 4 {
+			return 4, int64(msg.rbo.Uint32(msg.header[:4])), nil
+		}
+	case FrameFormatVarint:
+		if msg.offset == 0 {
+			return 0, 0, nil
+		}
+		u64, vn := binary.Uvarint(msg.header[:min(msg.offset, int64(len(msg.header)))])
+		if vn > 0 {
+			return int64(vn), int64(u64), nil
+		}
+		if msg.offset >= int64(len(msg.header)) {
+			return 0, 0, ErrMsgProtocol
+		}
+	default:
+		return 0, 0, ErrMsgInvalidArguments
+	}
+	return 0, 0, nil
+}
+
 func (msg *message) readPacket(p []byte) (n int, err error) {
 	defer msg.exitRead()
 	for {
@@ -347,27 +656,125 @@ func (msg *message) readPacket(p []byte) (n int, err error) {
 		}
 		if n > messagePayloadMaxLength56Bits {
 			return n, ErrMsgTooLong
-		} else if n == len(p) {
-			break
 		}
+		// each packet is a whole message
+		break
 	}
 
 	msg.count.Add(-1)
 	msg.reset()
 	return
 }
+
+// discardMessage skips the rest of the oversized message and returns ErrMsgTooLong,
+// so that the next read starts with the next message
+func (msg *message) discardMessage() error {
+	err := msg.skip()
+	if err != nil {
+		return err
+	}
+	msg.discarding = false
+	msg.reset()
+	return ErrMsgTooLong
+}
+
+// skip reads and drops the next msg.discard bytes
+func (msg *message) skip() error {
+	if msg.discard <= 0 {
+		return nil
+	}
+	n, err := io.CopyN(io.Discard, messageOnceReader{msg}, msg.discard)
+	msg.discard -= n
+	msg.discarded += n
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// discardRead skips the rest of the current message, or the next message
+// if no message is being read, and returns the number of skipped payload bytes
+func (msg *message) discardRead() (n int64, err error) {
+	start := msg.discarded
+	if msg.rpr.PreserveBoundary() {
+		lease := msg.pool.Get(messageReadPacketSize)
+		defer lease.Release()
+		rn, err := msg.readFrame(lease.Bytes())
+		return int64(rn), err
+	}
+	if msg.format == FrameFormatWebSocket {
+		ws := msg.ws
+		if ws.ready {
+			n = int64(len(ws.frag))
+			ws.resetMessage()
+			return n, nil
+		}
+		if ws.headerLength > 0 && ws.headerOffset == ws.headerLength && ws.opcode&webSocketOpClose == 0 && !ws.discarding {
+			msg.discard = ws.length - ws.payloadOffset
+		}
+		ws.discarding = true
+	} else if !msg.discarding {
+		_, err = msg.readFrame(nil)
+		if err == nil || err == ErrMsgTooLong {
+			return msg.discarded - start, nil
+		}
+		if err != io.ErrShortBuffer {
+			return 0, err
+		}
+		msg.discard = msg.length - (msg.offset - msg.streamHeaderLength())
+		msg.discarding = true
+	}
+	_, err = msg.readFrame(nil)
+	if err == ErrMsgTooLong {
+		err = nil
+	}
+
+	return msg.discarded - start, err
+}
+
+// streamHeaderLength returns the header length of the current stream message
+func (msg *message) streamHeaderLength() int64 {
+	if msg.format == FrameFormatSox {
+		switch msg.header[0] {
+		case messagePayloadMaxLength8Bits + 1:
+			return messageHeaderLength + 2
+		case messagePayloadMaxLength8Bits + 2:
+			return messageHeaderLength + 7
+		}
+		return messageHeaderLength
+	}
+	headerLength, _, _ := msg.prefixHeader()
+	return headerLength
+}
+
+// messageOnceReader reads the underlying reader of the message with readOnce
+type messageOnceReader struct {
+	*message
+}
+
+func (r messageOnceReader) Read(p []byte) (n int, err error) {
+	return r.readOnce(p)
+}
+
 func (msg *message) readOnce(p []byte) (n int, err error) {
 	if msg.rd == nil {
 		return 0, ErrMsgInvalidArguments
 	}
+	w := strategyWait{strategy: msg.strategy}
 	for {
 		n, err = msg.rd.Read(p)
+		msg.stats.readOnce(p, n, err)
 		if err != ErrTemporarilyUnavailable {
 			break
 		}
 		if msg.nonblock {
 			break
 		}
+		if w.sw == nil {
+			w.sw = &SpinWait{}
+		}
+		w.once()
 	}
 	return
 }
@@ -407,16 +814,33 @@ func (msg *message) exitRead() (oldStatus uint32) {
 }
 
 func (msg *message) write(p []byte) (n int, err error) {
+	n, err = msg.writeFrame(p)
+	msg.hooks.write(msg.ctx, n, err)
+	msg.stats.write(err)
+	return
+}
+
+// writeFrame writes a message like write without invoking hooks
+func (msg *message) writeFrame(p []byte) (n int, err error) {
 	if msg.done {
 		return 0, ErrMsgClosed
 	}
+	if msg.writeLimit > 0 && int64(len(p)) > msg.writeLimit {
+		return 0, ErrMsgTooLong
+	}
 	if _, ok := msg.enterWrite(); !ok {
 		return 0, ErrTemporarilyUnavailable
 	}
 	if msg.wpr.PreserveBoundary() {
-		return msg.writePacket(p)
+		n, err = msg.writePacket(p)
+	} else if msg.format == FrameFormatWebSocket {
+		n, err = msg.writeWebSocket(p)
+	} else if msg.format != FrameFormatSox {
+		n, err = msg.writePrefixed(p)
+	} else {
+		n, err = msg.writeStream(p)
 	}
-	return msg.writeStream(p)
+	return
 }
 
 func (msg *message) writeStream(p []byte) (n int, err error) {
@@ -433,28 +857,9 @@ func (msg *message) writeStream(p []byte) (n int, err error) {
 	if msg.offset == 0 {
 		msg.length = int64(len(p))
 	}
-	exLengthBytes := int64(0)
-	if msg.length <= messagePayloadMaxLength8Bits {
-		exLengthBytes = 0
-	} else if msg.length <= messagePayloadMaxLength16Bits {
-		exLengthBytes = 2
-	} else {
-		exLengthBytes = 7
-	}
+	exLengthBytes := messageExLengthBytes(msg.length)
 	if msg.offset == 0 {
-		if msg.length <= messagePayloadMaxLength8Bits {
-			msg.header[0] = byte(msg.length)
-		} else if msg.length <= messagePayloadMaxLength16Bits {
-			msg.header[0] = messagePayloadMaxLength8Bits + 1
-			msg.wbo.PutUint16(msg.header[messageHeaderLength:messageHeaderLength+exLengthBytes], uint16(msg.length))
-		} else {
-			if msg.wbo == binary.LittleEndian {
-				msg.wbo.PutUint64(msg.header[:], uint64(msg.length)<<8)
-			} else {
-				msg.wbo.PutUint64(msg.header[:], uint64(msg.length&messagePayloadMaxLength56Bits))
-			}
-			msg.header[0] = messagePayloadMaxLength8Bits + 2
-		}
+		putMessageHeader(msg.header[:], msg.length, msg.wbo)
 	}
 	for wn := 0; msg.offset < messageHeaderLength+exLengthBytes; {
 		wn, err = msg.writeOnce(msg.header[msg.offset : messageHeaderLength+exLengthBytes])
@@ -471,7 +876,7 @@ func (msg *message) writeStream(p []byte) (n int, err error) {
 		msg.offset += int64(wn)
 		n += wn
 		if err != nil && (err != ErrTemporarilyUnavailable || msg.nonblock) {
-			break
+			return
 		}
 	}
 
@@ -483,6 +888,96 @@ func (msg *message) writeStream(p []byte) (n int, err error) {
 	msg.reset()
 	return
 }
+
+// messageExLengthBytes returns the number of the extended payload length bytes
+// of a stream message with the given payload length
+func messageExLengthBytes(length int64) int64 {
+	if length <= messagePayloadMaxLength8Bits {
+		return 0
+	} else if length <= messagePayloadMaxLength16Bits {
+		return 2
+	}
+	return 7
+}
+
+// putMessageHeader encodes the header of a stream message with the given payload
+// length into h, which must have 8 bytes, and returns the header length
+func putMessageHeader(h []byte, length int64, order binary.ByteOrder) int {
+	exLengthBytes := messageExLengthBytes(length)
+	if length <= messagePayloadMaxLength8Bits {
+		h[0] = byte(length)
+	} else if length <= messagePayloadMaxLength16Bits {
+		h[0] = messagePayloadMaxLength8Bits + 1
+		order.PutUint16(h[messageHeaderLength:messageHeaderLength+exLengthBytes], uint16(length))
+	} else {
+		if order == binary.LittleEndian {
+			order.PutUint64(h, uint64(length)<<8)
+		} else {
+			order.PutUint64(h, uint64(length&messagePayloadMaxLength56Bits))
+		}
+		h[0] = messagePayloadMaxLength8Bits + 2
+	}
+
+	return int(messageHeaderLength + exLengthBytes)
+}
+
+// writePrefixed writes a message of the length-prefix frame formats
+func (msg *message) writePrefixed(p []byte) (n int, err error) {
+	defer func() {
+		if err != ErrTemporarilyUnavailable {
+			msg.exitWrite()
+		}
+	}()
+
+	if msg.offset == 0 {
+		msg.length = int64(len(p))
+	}
+	headerLength := int64(0)
+	switch msg.format {
+	case FrameFormatFixed16:
+		if msg.length > 1<<16-1 {
+			return 0, ErrMsgTooLong
+		}
+		headerLength = 2
+		msg.wbo.PutUint16(msg.header[:], uint16(msg.length))
+	case FrameFormatFixed32:
+		if msg.length > 1<<32-1 {
+			return 0, ErrMsgTooLong
+		}
+		headerLength = 4
+		msg.wbo.PutUint32(msg.header[:], uint32(msg.length))
+	case FrameFormatVarint:
+		if msg.length > messagePayloadMaxLength56Bits {
+			return 0, ErrMsgTooLong
+		}
+		headerLength = int64(binary.PutUvarint(msg.header[:], uint64(msg.length)))
+	default:
+		return 0, ErrMsgInvalidArguments
+	}
+	for wn := 0; msg.offset < headerLength; {
+		wn, err = msg.writeOnce(msg.header[msg.offset:headerLength])
+		msg.offset += int64(wn)
+		if err != nil && (err != ErrTemporarilyUnavailable || msg.nonblock) {
+			return
+		}
+	}
+	if msg.length != msg.offset-headerLength+int64(len(p)) {
+		return 0, io.ErrShortWrite
+	}
+	for wn := 0; msg.offset < headerLength+msg.length; {
+		wn, err = msg.writeOnce(p[:msg.length-(msg.offset-headerLength)])
+		msg.offset += int64(wn)
+		n += wn
+		if err != nil && (err != ErrTemporarilyUnavailable || msg.nonblock) {
+			return
+		}
+	}
+
+	msg.count.Add(1)
+	msg.reset()
+	return
+}
+
 func (msg *message) writePacket(p []byte) (n int, err error) {
 	defer msg.exitWrite()
 	if len(p) > messagePayloadMaxLength56Bits {
@@ -514,18 +1009,42 @@ func (msg *message) writeOnce(p []byte) (n int, err error) {
 	if msg.wr == nil {
 		return 0, ErrMsgInvalidArguments
 	}
+	w := strategyWait{strategy: msg.strategy}
 	for {
 		n, err = msg.wr.Write(p)
+		msg.stats.writeOnce(n, err)
 		if err != ErrTemporarilyUnavailable {
 			break
 		}
 		if msg.nonblock {
 			break
 		}
+		if w.sw == nil {
+			w.sw = &SpinWait{}
+		}
+		w.once()
 	}
 	return
 }
 
+// flush writes the frames buffered by the micro-batching writer mode
+func (msg *message) flush() error {
+	if msg.batch == nil {
+		return nil
+	}
+	w := strategyWait{strategy: msg.strategy}
+	for {
+		err := msg.batch.Flush()
+		if err != ErrTemporarilyUnavailable || msg.nonblock {
+			return err
+		}
+		if w.sw == nil {
+			w.sw = &SpinWait{}
+		}
+		w.once()
+	}
+}
+
 func (msg *message) enterWrite() (oldStatus uint32, ok bool) {
 	if msg.rd == nil {
 		return 0, true
@@ -581,6 +1100,88 @@ func (msg *message) writeTo(writer io.Writer) (n int64, err error) {
 	return io.Copy(writer, msg.rd)
 }
 
+func (msg *message) readLease() (lease *BufferLease, err error) {
+	if !msg.rpr.PreserveBoundary() {
+		_, err = msg.read(nil)
+		if err == nil {
+			return msg.pool.Get(0), nil
+		}
+		if err != io.ErrShortBuffer {
+			return nil, err
+		}
+		if msg.length > msg.leaseReadLimit() {
+			// the length is declared by the peer, and the message is skipped
+			// instead of being leased a buffer of
+			_, err = msg.discardRead()
+			if err != nil {
+				return nil, err
+			}
+			return nil, ErrMsgTooLong
+		}
+		lease, err = msg.leaseRead(int(msg.length))
+	} else {
+		lease, err = msg.leaseRead(messageReadPacketSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := msg.read(lease.Bytes())
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	lease.Truncate(n)
+
+	return lease, nil
+}
+
+// leaseReadLimit returns the maximum message which readLease leases a buffer for
+func (msg *message) leaseReadLimit() int64 {
+	limit := int64(DefaultLeaseReadLimit)
+	if msg.readLimit > 0 {
+		limit = msg.readLimit
+	}
+	if msg.memLimit != nil {
+		limit = min(limit, msg.memLimit.Limit())
+	}
+	return limit
+}
+
+// leaseRead leases a buffer of n bytes to read a message into, which
+// is charged to the MemoryLimit until the lease has been released
+func (msg *message) leaseRead(n int) (*BufferLease, error) {
+	if msg.memLimit == nil {
+		return msg.pool.Get(n), nil
+	}
+	err := msg.memLimit.charge(int64(n), msg.nonblock)
+	if err != nil {
+		return nil, err
+	}
+	lease := msg.pool.Get(n)
+	lease.limit, lease.charged = msg.memLimit, int64(n)
+	return lease, nil
+}
+
+func (msg *message) messages(yield func([]byte, error) bool) {
+	for {
+		lease, err := msg.readLease()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if !yield(nil, err) || err != ErrMsgTooLong {
+				return
+			}
+			continue
+		}
+		ok := yield(lease.Bytes(), nil)
+		lease.Release()
+		if !ok {
+			return
+		}
+	}
+}
+
 func (msg *message) reset() {
 	msg.offset = 0
 }
@@ -592,18 +1193,52 @@ func newMessage(reader io.Reader, writer io.Writer, opts ...func(options *Messag
 	}
 
 	m := &message{
-		status:    atomic.Uint32{},
-		header:    [8]byte{},
-		length:    0,
-		offset:    0,
-		count:     atomic.Int32{},
-		readLimit: int64(opt.ReadLimit),
-		nonblock:  opt.Nonblock,
-		done:      false,
+		status:     atomic.Uint32{},
+		header:     [8]byte{},
+		length:     0,
+		offset:     0,
+		count:      atomic.Int32{},
+		readLimit:  int64(opt.ReadLimit),
+		writeLimit: int64(opt.WriteLimit),
+		strict:     opt.Strict,
+		nonblock:   opt.Nonblock,
+		strategy:   opt.WaitStrategy,
+		pool:       opt.BufferPool,
+		memLimit:   opt.MemoryLimit,
+		codec:      opt.Codec,
+		hooks:      opt.Hooks,
+		ctx:        opt.Context,
+		format:     opt.FrameFormat,
+		done:       false,
+	}
+	if m.format == FrameFormatWebSocket {
+		m.ws = newWebSocket(opt.WebSocket)
+		if m.readLimit < 1 {
+			m.readLimit = DefaultWebSocketReadLimit
+		}
+	}
+	if m.pool == nil {
+		m.pool = DefaultBufferPool
+	}
+	if m.ctx == nil {
+		m.ctx = context.Background()
+	}
+	if m.codec == nil {
+		m.codec = JSONCodec
+	}
+	if m.strict && m.readLimit < 1 {
+		m.readLimit = DefaultStrictReadLimit
+	}
+	if opt.NetworkOrderOnly {
+		opt.ReadByteOrder, opt.WriteByteOrder = binary.BigEndian, binary.BigEndian
 	}
 	if reader != nil {
 		m.setReader(reader, opt.ReadByteOrder, opt.ReadProto)
 	}
+	if writer != nil && opt.BatchSize > 0 && !opt.WriteProto.PreserveBoundary() {
+		m.batch = newBatchWriter(writer, opt.BatchSize, opt.BatchDelay)
+		writer = m.batch
+	}
 	if writer != nil {
 		m.setWriter(writer, opt.WriteByteOrder, opt.WriteProto)
 	}
@@ -618,10 +1253,34 @@ func (msg *messageReader) Read(b []byte) (n int, err error) {
 	return msg.read(b)
 }
 
+func (msg *messageReader) ReadLease() (lease *BufferLease, err error) {
+	return msg.readLease()
+}
+
+func (msg *messageReader) ReadInto(v any) error {
+	return msg.readInto(v)
+}
+
+func (msg *messageReader) ReadContext(ctx context.Context, b []byte) (ret context.Context, n int, err error) {
+	return msg.readContext(ctx, b)
+}
+
 func (msg *messageReader) WriteTo(writer io.Writer) (n int64, err error) {
 	return msg.writeTo(writer)
 }
 
+func (msg *messageReader) Stats() MessageStats {
+	return msg.stats.snapshot()
+}
+
+func (msg *messageReader) Discard() (n int64, err error) {
+	return msg.discardRead()
+}
+
+func (msg *messageReader) Messages() iter.Seq2[[]byte, error] {
+	return msg.messages
+}
+
 type messageWriter struct {
 	*message
 }
@@ -630,11 +1289,43 @@ func (msg *messageWriter) Write(b []byte) (n int, err error) {
 	return msg.write(b)
 }
 
+func (msg *messageWriter) WriteValue(v any) error {
+	return msg.writeValue(v)
+}
+
+func (msg *messageWriter) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	return msg.writeContext(ctx, b)
+}
+
 func (msg *messageWriter) ReadFrom(reader io.Reader) (n int64, err error) {
 	return msg.readFrom(reader)
 }
 
+func (msg *messageWriter) Flush() error {
+	return msg.flush()
+}
+
+func (msg *messageWriter) Stats() MessageStats {
+	return msg.stats.snapshot()
+}
+
+func (msg *messageWriter) WritePing(p []byte) error {
+	return msg.writeWebSocketControl(webSocketOpPing, p)
+}
+
+func (msg *messageWriter) WritePong(p []byte) error {
+	return msg.writeWebSocketControl(webSocketOpPong, p)
+}
+
+func (msg *messageWriter) WriteClose(code int, reason string) error {
+	return msg.writeWebSocketClose(code, reason)
+}
+
 type messageReadWriter struct {
 	*messageReader
 	*messageWriter
 }
+
+func (msg *messageReadWriter) Stats() MessageStats {
+	return msg.messageReader.Stats().add(msg.messageWriter.Stats())
+}
@@ -0,0 +1,146 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// MessageChannelOptions represents the options of NewMessageChannel
+type MessageChannelOptions struct {
+	// Capacity is the buffer capacity of the receive and the send channels
+	Capacity int
+	// MessageOptions are applied to the message reader and writer of the connection
+	// The Nonblock option is ignored, since the channels wait for the readiness
+	MessageOptions []func(options *MessageOptions)
+	// OnError is invoked with the error which has stopped reading or writing
+	// A nil OnError indicates that the errors will be ignored
+	OnError func(err error)
+}
+
+var defaultMessageChannelOptions = MessageChannelOptions{
+	Capacity: 64,
+}
+
+// NewMessageChannel starts reading and writing messages on the non-blocking conn
+// and returns the channels of them, for the goroutine-per-connection style
+// The receive channel is closed when the conn has been read to the end or
+// failed. Closing the send channel closes the conn after the messages sent
+// before have been written, and then the receive channel is closed
+func NewMessageChannel(conn PollReadWriteCloser, opts ...func(options *MessageChannelOptions)) (<-chan []byte, chan<- []byte, error) {
+	opt := defaultMessageChannelOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.Capacity < 0 {
+		return nil, nil, ErrInvalidParam
+	}
+	rp, err := newFdPoller(conn.Fd())
+	if err != nil {
+		return nil, nil, err
+	}
+	wp, err := newFdPoller(conn.Fd())
+	if err != nil {
+		_ = rp.Close()
+		return nil, nil, err
+	}
+	msgOpts := append(opt.MessageOptions[:len(opt.MessageOptions):len(opt.MessageOptions)], func(options *MessageOptions) {
+		options.Nonblock = false
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recv, send := make(chan []byte, opt.Capacity), make(chan []byte, opt.Capacity)
+	readDone := make(chan struct{})
+	goLabeled(ProfileLabelReader, func() {
+		defer close(readDone)
+		defer close(recv)
+		defer rp.Close()
+		r := NewMessageReader(&pollConnReader{ctx: ctx, so: conn, p: rp}, msgOpts...)
+		for msg, err := range r.(MessageIterator).Messages() {
+			if err != nil {
+				if opt.OnError != nil && ctx.Err() == nil {
+					opt.OnError(err)
+				}
+				continue
+			}
+			select {
+			case recv <- bytes.Clone(msg):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	goLabeled(ProfileLabelWriter, func() {
+		defer func() {
+			cancel()
+			<-readDone
+			_ = conn.Close()
+		}()
+		defer wp.Close()
+		w := NewMessageWriter(&pollConnWriter{ctx: ctx, so: conn, p: wp}, msgOpts...)
+		failed := false
+		for msg := range send {
+			// the messages are drained after failures, so that senders never block
+			if failed {
+				continue
+			}
+			_, err := w.Write(msg)
+			if err != nil {
+				failed = true
+				if opt.OnError != nil {
+					opt.OnError(err)
+				}
+			}
+		}
+	})
+
+	return recv, send, nil
+}
+
+// pollConnReader reads from the non-blocking so, and waits
+// for the readiness with the poller p instead of returning
+// ErrTemporarilyUnavailable
+type pollConnReader struct {
+	ctx context.Context
+	so  PollReader
+	p   *Poller
+}
+
+func (r *pollConnReader) Read(b []byte) (n int, err error) {
+	for {
+		n, err = r.so.Read(b)
+		if err == ErrTemporarilyUnavailable || err == ErrInterruptedSyscall {
+			err = pollWait(r.ctx, r.p, r.so.Fd(), PollIn, time.Time{})
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err == nil && n == 0 && len(b) > 0 {
+			return 0, io.EOF
+		}
+		return
+	}
+}
+
+// pollConnWriter writes all of b to the non-blocking so
+type pollConnWriter struct {
+	ctx context.Context
+	so  PollWriter
+	p   *Poller
+}
+
+func (w *pollConnWriter) Write(b []byte) (n int, err error) {
+	err = pollWriteAll(w.ctx, w.so, w.p, b, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,93 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"hybscloud.com/sox"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewMessageChannel(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	// the peer echoes messages until the end of the stream
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw := sox.NewMessageReadWriter(conn, conn)
+		p := make([]byte, 64)
+		for {
+			n, err := rw.Read(p)
+			if err != nil {
+				return
+			}
+			_, err = rw.Write(p[:n])
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	raddr := l.Addr().(*net.TCPAddr)
+	conn, err := sox.DialTCP4(&sox.TCPAddr{IP: sox.IPV4zero}, &sox.TCPAddr{IP: raddr.IP, Port: raddr.Port})
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	var errs []error
+	recv, send, err := sox.NewMessageChannel(conn, func(options *sox.MessageChannelOptions) {
+		options.Capacity = 4
+		options.OnError = func(err error) {
+			errs = append(errs, err)
+		}
+	})
+	if err != nil {
+		t.Errorf("new message channel: %v", err)
+		return
+	}
+	messages := []string{"hello", "", "message channel"}
+	for _, s := range messages {
+		send <- []byte(s)
+	}
+	for _, s := range messages {
+		select {
+		case msg, ok := <-recv:
+			if !ok || string(msg) != s {
+				t.Errorf("expected %q but got %q", s, msg)
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("receive timed out")
+			return
+		}
+	}
+
+	close(send)
+	select {
+	case _, ok := <-recv:
+		if ok {
+			t.Errorf("expected closed receive channel")
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("close timed out")
+		return
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+		return
+	}
+}
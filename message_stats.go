@@ -0,0 +1,129 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// MessageStats is a snapshot of the counters of a message reader or writer
+type MessageStats struct {
+	// MessagesRead is the number of whole messages read
+	MessagesRead uint64 `json:"messages_read"`
+	// MessagesWritten is the number of whole messages written
+	MessagesWritten uint64 `json:"messages_written"`
+	// BytesRead is the number of bytes read from the underlying reader, including headers
+	BytesRead uint64 `json:"bytes_read"`
+	// BytesWritten is the number of bytes written to the underlying writer, including headers
+	BytesWritten uint64 `json:"bytes_written"`
+	// ShortReads is the number of underlying reads which returned fewer bytes than requested
+	ShortReads uint64 `json:"short_reads"`
+	// ShortBuffers is the number of reads which returned io.ErrShortBuffer
+	ShortBuffers uint64 `json:"short_buffers"`
+	// Retries is the number of underlying reads and writes
+	// which returned ErrTemporarilyUnavailable
+	Retries uint64 `json:"retries"`
+	// TooLong is the number of messages rejected with ErrMsgTooLong
+	TooLong uint64 `json:"too_long"`
+	// ProtocolErrors is the number of malformed frames rejected with ErrMsgProtocol
+	ProtocolErrors uint64 `json:"protocol_errors"`
+}
+
+// MessageStatsReader is the interface that wraps the Stats method
+// The message readers and writers created by this package implement MessageStatsReader
+type MessageStatsReader interface {
+	// Stats returns a snapshot of the counters
+	Stats() MessageStats
+}
+
+type messageStats struct {
+	messagesRead    atomic.Uint64
+	messagesWritten atomic.Uint64
+	bytesRead       atomic.Uint64
+	bytesWritten    atomic.Uint64
+	shortReads      atomic.Uint64
+	shortBuffers    atomic.Uint64
+	retries         atomic.Uint64
+	tooLong         atomic.Uint64
+	protocolErrors  atomic.Uint64
+}
+
+func (s *messageStats) snapshot() MessageStats {
+	return MessageStats{
+		MessagesRead:    s.messagesRead.Load(),
+		MessagesWritten: s.messagesWritten.Load(),
+		BytesRead:       s.bytesRead.Load(),
+		BytesWritten:    s.bytesWritten.Load(),
+		ShortReads:      s.shortReads.Load(),
+		ShortBuffers:    s.shortBuffers.Load(),
+		Retries:         s.retries.Load(),
+		TooLong:         s.tooLong.Load(),
+		ProtocolErrors:  s.protocolErrors.Load(),
+	}
+}
+
+// read counts the result of a message read
+func (s *messageStats) read(err error) {
+	switch err {
+	case nil:
+		s.messagesRead.Add(1)
+	case io.ErrShortBuffer:
+		s.shortBuffers.Add(1)
+	default:
+		s.failed(err)
+	}
+}
+
+// write counts the result of a message write
+func (s *messageStats) write(err error) {
+	if err == nil {
+		s.messagesWritten.Add(1)
+		return
+	}
+	s.failed(err)
+}
+
+func (s *messageStats) failed(err error) {
+	switch err {
+	case ErrMsgTooLong:
+		s.tooLong.Add(1)
+	case ErrMsgProtocol:
+		s.protocolErrors.Add(1)
+	}
+}
+
+// readOnce counts the result of an underlying read of len(p) bytes
+func (s *messageStats) readOnce(p []byte, n int, err error) {
+	s.bytesRead.Add(uint64(n))
+	if err == ErrTemporarilyUnavailable {
+		s.retries.Add(1)
+	} else if n < len(p) {
+		s.shortReads.Add(1)
+	}
+}
+
+// writeOnce counts the result of an underlying write
+func (s *messageStats) writeOnce(n int, err error) {
+	s.bytesWritten.Add(uint64(n))
+	if err == ErrTemporarilyUnavailable {
+		s.retries.Add(1)
+	}
+}
+
+// add returns the sum of the snapshots a and b
+func (a MessageStats) add(b MessageStats) MessageStats {
+	return MessageStats{
+		MessagesRead:    a.MessagesRead + b.MessagesRead,
+		MessagesWritten: a.MessagesWritten + b.MessagesWritten,
+		BytesRead:       a.BytesRead + b.BytesRead,
+		BytesWritten:    a.BytesWritten + b.BytesWritten,
+		ShortReads:      a.ShortReads + b.ShortReads,
+		ShortBuffers:    a.ShortBuffers + b.ShortBuffers,
+		Retries:         a.Retries + b.Retries,
+		TooLong:         a.TooLong + b.TooLong,
+		ProtocolErrors:  a.ProtocolErrors + b.ProtocolErrors,
+	}
+}
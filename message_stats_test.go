@@ -0,0 +1,77 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMessageStats(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := sox.NewMessageWriter(&buf)
+	for _, s := range []string{"abc", "too long message", "defgh"} {
+		_, err := w.Write([]byte(s))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+	}
+	ws := w.(sox.MessageStatsReader).Stats()
+	if ws.MessagesWritten != 3 || ws.BytesWritten != 1+3+1+16+1+5 || ws.MessagesRead != 0 {
+		t.Errorf("bad writer stats %+v", ws)
+		return
+	}
+
+	r := sox.NewMessageReader(iotest.HalfReader(&buf), func(options *sox.MessageOptions) {
+		options.ReadLimit = 8
+	})
+	p := make([]byte, 4)
+	_, err := r.Read(p)
+	if err != nil {
+		t.Errorf("read message: %v", err)
+		return
+	}
+	_, err = r.Read(p)
+	if err != sox.ErrMsgTooLong {
+		t.Errorf("expected ErrMsgTooLong but got %v", err)
+		return
+	}
+	_, err = r.Read(p)
+	if err != io.ErrShortBuffer {
+		t.Errorf("expected short buffer but got %v", err)
+		return
+	}
+	_, err = r.Read(make([]byte, 8))
+	if err != nil {
+		t.Errorf("read message: %v", err)
+		return
+	}
+	rs := r.(sox.MessageStatsReader).Stats()
+	if rs.MessagesRead != 2 || rs.BytesRead != ws.BytesWritten || rs.TooLong != 1 || rs.ShortBuffers != 1 || rs.ShortReads == 0 {
+		t.Errorf("bad reader stats %+v", rs)
+		return
+	}
+
+	rw := sox.NewMessageReadWriter(&buf, &buf)
+	_, err = rw.Write([]byte("abc"))
+	if err != nil {
+		t.Errorf("write message: %v", err)
+		return
+	}
+	_, err = rw.Read(p)
+	if err != nil {
+		t.Errorf("read message: %v", err)
+		return
+	}
+	s := rw.(sox.MessageStatsReader).Stats()
+	if s.MessagesRead != 1 || s.MessagesWritten != 1 || s.BytesRead != 4 || s.BytesWritten != 4 {
+		t.Errorf("bad read writer stats %+v", s)
+		return
+	}
+}
@@ -6,7 +6,9 @@ package sox_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"hybscloud.com/sox"
 	"io"
 	"testing"
@@ -762,6 +764,56 @@ func TestMessage_PipeStream(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("read limit resync", func(t *testing.T) {
+		for _, format := range []sox.FrameFormat{sox.FrameFormatSox, sox.FrameFormatFixed32, sox.FrameFormatWebSocket} {
+			buf := bytes.Buffer{}
+			opt := func(options *sox.MessageOptions) {
+				options.FrameFormat = format
+				options.WebSocket.Client = true
+				options.ReadLimit = 16
+			}
+			w := sox.NewMessageWriter(&buf, opt, func(options *sox.MessageOptions) {
+				options.WebSocket.Client = false
+			})
+			for _, s := range []string{"short message", "much too long message", "next message"} {
+				_, err := w.Write([]byte(s))
+				if err != nil {
+					t.Errorf("write message: %v", err)
+					return
+				}
+			}
+			r := sox.NewMessageReader(&buf, opt)
+			p := make([]byte, 64)
+			for _, expected := range []string{"short message", "", "next message"} {
+				n, err := r.Read(p)
+				if expected == "" && err != sox.ErrMsgTooLong {
+					t.Errorf("format %d read expected ErrMsgTooLong but got %v", format, err)
+					return
+				}
+				if expected != "" && (err != nil || string(p[:n]) != expected) {
+					t.Errorf("format %d read expected %q but got %q: %v", format, expected, p[:n], err)
+					return
+				}
+			}
+		}
+	})
+
+	t.Run("write limit", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf, func(options *sox.MessageOptions) {
+			options.WriteLimit = 4
+		})
+		_, err := w.Write([]byte("too long"))
+		if err != sox.ErrMsgTooLong {
+			t.Errorf("write expected ErrMsgTooLong but got %v", err)
+			return
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected nothing written but got %x", buf.Bytes())
+			return
+		}
+	})
 }
 
 func BenchmarkMessage_Stream(b *testing.B) {
@@ -934,3 +986,516 @@ func benchmarkMessageStream(b *testing.B, r io.Reader, w io.Writer, l int) {
 		}
 	}
 }
+
+func TestMessage_Hooks(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "conn")
+	reads, writes, errs := []int{}, []int{}, []error{}
+	hooks := &sox.Hooks{
+		OnRead: func(c context.Context, n int) {
+			if c.Value(ctxKey{}) != "conn" {
+				t.Errorf("hook invoked with unexpected context")
+			}
+			reads = append(reads, n)
+		},
+		OnWrite: func(c context.Context, n int) {
+			writes = append(writes, n)
+		},
+		OnError: func(c context.Context, err error) {
+			errs = append(errs, err)
+		},
+	}
+	buf := bytes.Buffer{}
+	rw := sox.NewMessageReadWriter(&buf, &buf, func(options *sox.MessageOptions) {
+		options.Hooks = hooks
+		options.Context = ctx
+		options.ReadLimit = 8
+	})
+	for _, s := range []string{"abc", "defgh", "too long message"} {
+		_, err := rw.Write([]byte(s))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+	}
+	if len(writes) != 3 || writes[0] != 3 || writes[1] != 5 {
+		t.Errorf("expected OnWrite with 3 and 5 bytes but got %v", writes)
+		return
+	}
+	p := make([]byte, 8)
+	for range 2 {
+		_, err := rw.Read(p)
+		if err != nil {
+			t.Errorf("read message: %v", err)
+			return
+		}
+	}
+	_, err := rw.Read(p)
+	if err != sox.ErrMsgTooLong {
+		t.Errorf("read expected ErrMsgTooLong but got %v", err)
+		return
+	}
+	if len(reads) != 2 || reads[0] != 3 || reads[1] != 5 {
+		t.Errorf("expected OnRead with 3 and 5 bytes but got %v", reads)
+		return
+	}
+	if len(errs) != 1 || errs[0] != sox.ErrMsgTooLong {
+		t.Errorf("expected OnError with ErrMsgTooLong but got %v", errs)
+		return
+	}
+}
+
+func TestMessage_FrameFormat(t *testing.T) {
+	cases := []struct {
+		name   string
+		format sox.FrameFormat
+		size   int
+		header []byte
+	}{
+		{"fixed16", sox.FrameFormatFixed16, 5, []byte{0x00, 0x05}},
+		{"fixed16 long", sox.FrameFormatFixed16, 300, []byte{0x01, 0x2c}},
+		{"fixed32", sox.FrameFormatFixed32, 300, []byte{0x00, 0x00, 0x01, 0x2c}},
+		{"varint", sox.FrameFormatVarint, 5, []byte{0x05}},
+		{"varint long", sox.FrameFormatVarint, 300, []byte{0xac, 0x02}},
+		{"varint empty", sox.FrameFormatVarint, 0, []byte{0x00}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			opt := func(options *sox.MessageOptions) {
+				options.FrameFormat = c.format
+			}
+			w := sox.NewMessageWriter(&buf, opt)
+			s := bytes.Repeat([]byte{0x41}, c.size)
+			for range 2 {
+				n, err := w.Write(s)
+				if err != nil || n != c.size {
+					t.Errorf("write %d byte(s): %v", n, err)
+					return
+				}
+			}
+			if !bytes.HasPrefix(buf.Bytes(), append(c.header, s...)) || buf.Len() != 2*(len(c.header)+c.size) {
+				t.Errorf("bad frame %x", buf.Bytes())
+				return
+			}
+			r := sox.NewMessageReader(&buf, opt)
+			for range 2 {
+				lease, err := r.(sox.MessageLeaseReader).ReadLease()
+				if err != nil {
+					t.Errorf("read lease: %v", err)
+					return
+				}
+				if !bytes.Equal(lease.Bytes(), s) {
+					t.Errorf("expected %x but %x", s, lease.Bytes())
+					return
+				}
+				lease.Release()
+			}
+			_, err := r.Read(make([]byte, 16))
+			if err != io.EOF {
+				t.Errorf("expected EOF but got %v", err)
+				return
+			}
+		})
+	}
+
+	t.Run("little endian", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf, func(options *sox.MessageOptions) {
+			options.FrameFormat = sox.FrameFormatFixed32
+			options.WriteByteOrder = binary.LittleEndian
+		})
+		_, err := w.Write([]byte{0x41})
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		if !bytes.Equal(buf.Bytes(), []byte{0x01, 0x00, 0x00, 0x00, 0x41}) {
+			t.Errorf("bad frame %x", buf.Bytes())
+			return
+		}
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		w := sox.NewMessageWriter(io.Discard, func(options *sox.MessageOptions) {
+			options.FrameFormat = sox.FrameFormatFixed16
+		})
+		_, err := w.Write(make([]byte, 1<<16))
+		if err != sox.ErrMsgTooLong {
+			t.Errorf("expected message too long but got %v", err)
+			return
+		}
+	})
+
+	t.Run("bad varint", func(t *testing.T) {
+		r := sox.NewMessageReader(bytes.NewReader(bytes.Repeat([]byte{0xff}, 9)), func(options *sox.MessageOptions) {
+			options.FrameFormat = sox.FrameFormatVarint
+		})
+		_, err := r.Read(make([]byte, 16))
+		if err != sox.ErrMsgProtocol {
+			t.Errorf("expected message protocol error but got %v", err)
+			return
+		}
+	})
+}
+
+func TestMessage_Discard(t *testing.T) {
+	for _, format := range []sox.FrameFormat{sox.FrameFormatSox, sox.FrameFormatFixed16, sox.FrameFormatVarint, sox.FrameFormatWebSocket} {
+		buf := bytes.Buffer{}
+		opt := func(options *sox.MessageOptions) {
+			options.FrameFormat = format
+			options.WebSocket.Client = true
+		}
+		w := sox.NewMessageWriter(&buf, opt, func(options *sox.MessageOptions) {
+			options.WebSocket.Client = false
+			options.WebSocket.MaxFrameSize = 100
+		})
+		long := bytes.Repeat([]byte{0x41}, 300)
+		for _, s := range [][]byte{long, []byte("unwanted"), []byte("next message")} {
+			_, err := w.Write(s)
+			if err != nil {
+				t.Errorf("write message: %v", err)
+				return
+			}
+		}
+		r := sox.NewMessageReader(&buf, opt)
+		p := make([]byte, 16)
+		_, err := r.Read(p)
+		if err != io.ErrShortBuffer {
+			t.Errorf("format %d read expected short buffer but got %v", format, err)
+			return
+		}
+		n, err := r.(sox.MessageDiscarder).Discard()
+		if err != nil || n != int64(len(long)) {
+			t.Errorf("format %d discard %d byte(s): %v", format, n, err)
+			return
+		}
+		n, err = r.(sox.MessageDiscarder).Discard()
+		if err != nil || n != int64(len("unwanted")) {
+			t.Errorf("format %d discard %d byte(s): %v", format, n, err)
+			return
+		}
+		rn, err := r.Read(p)
+		if err != nil || string(p[:rn]) != "next message" {
+			t.Errorf("format %d read expected %q but got %q: %v", format, "next message", p[:rn], err)
+			return
+		}
+	}
+}
+
+// zeroReader reads endless zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (n int, err error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestMessage_LeaseReadLimit(t *testing.T) {
+	order := sox.MessageOptionsByteOrder(binary.BigEndian)
+	header := func(length uint64) []byte {
+		return binary.BigEndian.AppendUint64(nil, 0xff<<56|length)
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		long := uint64(sox.DefaultLeaseReadLimit + 1)
+		rd := io.MultiReader(
+			bytes.NewReader(header(long)),
+			io.LimitReader(zeroReader{}, int64(long)),
+			bytes.NewReader([]byte("\x04next")),
+		)
+		r := sox.NewMessageReader(rd, order).(sox.MessageLeaseReader)
+		_, err := r.ReadLease()
+		if err != sox.ErrMsgTooLong {
+			t.Errorf("read lease expected ErrMsgTooLong but got %v", err)
+			return
+		}
+		l, err := r.ReadLease()
+		if err != nil || string(l.Bytes()) != "next" {
+			t.Errorf("read lease expected next but got %v", err)
+			return
+		}
+		l.Release()
+	})
+
+	t.Run("forged length", func(t *testing.T) {
+		// the buffer of the length is never leased
+		r := sox.NewMessageReader(bytes.NewReader(header(1<<36)), order).(sox.MessageLeaseReader)
+		_, err := r.ReadLease()
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("read lease expected ErrUnexpectedEOF but got %v", err)
+			return
+		}
+	})
+}
+
+func TestMessage_Messages(t *testing.T) {
+	buf := bytes.Buffer{}
+	opt := func(options *sox.MessageOptions) {
+		options.ReadLimit = 8
+	}
+	w := sox.NewMessageWriter(&buf, opt)
+	for _, s := range []string{"abc", "too long message", "defgh", ""} {
+		_, err := w.Write([]byte(s))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+	}
+	r := sox.NewMessageReader(&buf, opt)
+	var msgs []string
+	var errs []error
+	for msg, err := range r.(sox.MessageIterator).Messages() {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		msgs = append(msgs, string(msg))
+	}
+	if len(msgs) != 3 || msgs[0] != "abc" || msgs[1] != "defgh" || msgs[2] != "" {
+		t.Errorf("expected messages %q but got %q", []string{"abc", "defgh", ""}, msgs)
+		return
+	}
+	if len(errs) != 1 || errs[0] != sox.ErrMsgTooLong {
+		t.Errorf("expected ErrMsgTooLong but got %v", errs)
+		return
+	}
+}
+
+// unavailableReader returns ErrTemporarilyUnavailable for the first n reads
+type unavailableReader struct {
+	io.Reader
+	n int
+}
+
+func (r *unavailableReader) Read(p []byte) (int, error) {
+	if r.n > 0 {
+		r.n--
+		return 0, sox.ErrTemporarilyUnavailable
+	}
+	return r.Reader.Read(p)
+}
+
+func TestMessage_WaitStrategy(t *testing.T) {
+	for _, strategy := range []sox.WaitStrategy{sox.WaitStrategySpin, sox.WaitStrategyYield, sox.WaitStrategyPark, sox.WaitStrategySpinThenYield, sox.WaitStrategySpinThenSleep} {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf)
+		_, err := w.Write([]byte("wait strategy"))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+		r := sox.NewMessageReader(&unavailableReader{Reader: &buf, n: 8}, func(options *sox.MessageOptions) {
+			options.WaitStrategy = strategy
+		})
+		p := make([]byte, 16)
+		n, err := r.Read(p)
+		if err != nil || string(p[:n]) != "wait strategy" {
+			t.Errorf("strategy %d read expected %q but got %q: %v", strategy, "wait strategy", p[:n], err)
+			return
+		}
+	}
+}
+
+// tricklingReader reads one byte at a time, and returns ErrTemporarilyUnavailable between the bytes
+type tricklingReader struct {
+	io.Reader
+	ready bool
+}
+
+func (r *tricklingReader) Read(p []byte) (int, error) {
+	if r.ready = !r.ready; !r.ready || len(p) < 1 {
+		return 0, sox.ErrTemporarilyUnavailable
+	}
+	return r.Reader.Read(p[:1])
+}
+
+func TestMessage_Strict(t *testing.T) {
+	strict := func(options *sox.MessageOptions) { options.Strict = true }
+
+	t.Run("canonical", func(t *testing.T) {
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			buf := bytes.Buffer{}
+			byteOrder := func(options *sox.MessageOptions) {
+				options.ReadByteOrder, options.WriteByteOrder = order, order
+			}
+			w := sox.NewMessageWriter(&buf, byteOrder)
+			r := sox.NewMessageReader(&buf, byteOrder, strict)
+			for _, n := range []int{0, 253, 254, 1 << 16} {
+				_, _ = w.Write(make([]byte, n))
+				p := make([]byte, n)
+				rn, err := r.Read(p)
+				if err != nil || rn != n {
+					t.Errorf("%v read expected %d bytes but got %d: %v", order, n, rn, err)
+					return
+				}
+			}
+		}
+	})
+
+	t.Run("non-canonical", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			data   []byte
+			format sox.FrameFormat
+		}{
+			{"16-bit length", []byte{0xfe, 0x00, 0x03, 'a', 'b', 'c'}, sox.FrameFormatSox},
+			{"56-bit length", []byte{0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}, sox.FrameFormatSox},
+			{"overlong varint", []byte{0x83, 0x00, 'a', 'b', 'c'}, sox.FrameFormatVarint},
+		}
+		for _, c := range cases {
+			r := sox.NewMessageReader(bytes.NewReader(c.data), strict, func(options *sox.MessageOptions) {
+				options.FrameFormat = c.format
+			})
+			_, err := r.Read(make([]byte, 1<<10))
+			protoErr := &sox.MessageProtocolError{}
+			if !errors.As(err, &protoErr) || !errors.Is(err, sox.ErrMsgProtocol) {
+				t.Errorf("%s expected MessageProtocolError but got %v", c.name, err)
+				return
+			}
+			// the stream can not be resynced
+			if _, err = r.Read(make([]byte, 1<<10)); err != protoErr {
+				t.Errorf("%s expected the same error but got %v", c.name, err)
+				return
+			}
+			// the non-strict reader accepts the length
+			r = sox.NewMessageReader(bytes.NewReader(c.data), func(options *sox.MessageOptions) {
+				options.FrameFormat = c.format
+			})
+			if _, err = r.Read(make([]byte, 1<<10)); errors.Is(err, sox.ErrMsgProtocol) {
+				t.Errorf("%s expected no protocol error of the non-strict reader but got %v", c.name, err)
+				return
+			}
+		}
+	})
+
+	t.Run("forged length", func(t *testing.T) {
+		data := []byte{0xff, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		r := sox.NewMessageReader(bytes.NewReader(data), strict).(sox.MessageLeaseReader)
+		// the message beyond DefaultStrictReadLimit is skipped instead of being allocated
+		if _, err := r.ReadLease(); err != io.ErrUnexpectedEOF {
+			t.Errorf("read lease expected ErrUnexpectedEOF but got %v", err)
+			return
+		}
+	})
+
+	t.Run("resumed header", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		_, _ = sox.NewMessageWriter(&buf).Write(bytes.Repeat([]byte{'x'}, 300))
+		r := sox.NewMessageReader(&tricklingReader{Reader: &buf}, strict, sox.MessageOptionsNonblock)
+		p := make([]byte, 512)
+		total, err := 0, error(sox.ErrTemporarilyUnavailable)
+		// the nonblocking reads return the bytes read by each of them
+		for i := 0; err == sox.ErrTemporarilyUnavailable && i < 1<<12; i++ {
+			n := 0
+			n, err = r.Read(p)
+			total += n
+		}
+		if err != nil || total != 300 || !bytes.Equal(p[:300], bytes.Repeat([]byte{'x'}, 300)) {
+			t.Errorf("read expected 300 bytes but got %d: %v", total, err)
+			return
+		}
+	})
+}
+
+func FuzzMessageReader(f *testing.F) {
+	for _, n := range []int{0, 1, 253, 254, 300} {
+		for _, format := range []sox.FrameFormat{sox.FrameFormatSox, sox.FrameFormatFixed16, sox.FrameFormatFixed32, sox.FrameFormatVarint} {
+			buf := bytes.Buffer{}
+			_, _ = sox.NewMessageWriter(&buf, func(options *sox.MessageOptions) {
+				options.FrameFormat = format
+			}).Write(bytes.Repeat([]byte{'x'}, n))
+			f.Add(buf.Bytes(), uint8(format), true)
+		}
+	}
+	f.Add([]byte{0xfe, 0x00}, uint8(sox.FrameFormatSox), true)
+	f.Add([]byte{0xfe, 0x00, 0x03, 'a'}, uint8(sox.FrameFormatSox), false)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint8(sox.FrameFormatSox), true)
+	f.Add([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, uint8(sox.FrameFormatVarint), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, format uint8, strict bool) {
+		formats := []sox.FrameFormat{sox.FrameFormatSox, sox.FrameFormatFixed16, sox.FrameFormatFixed32, sox.FrameFormatVarint}
+		r := sox.NewMessageReader(bytes.NewReader(data), func(options *sox.MessageOptions) {
+			options.FrameFormat = formats[int(format)%len(formats)]
+			options.Strict = strict
+			if !strict {
+				options.ReadLimit = 1 << 16
+			}
+		}).(sox.MessageLeaseReader)
+		total := 0
+		for range len(data) + 1 {
+			lease, err := r.ReadLease()
+			if err == sox.ErrMsgTooLong {
+				continue
+			}
+			if err != nil {
+				if strict && errors.Is(err, sox.ErrMsgProtocol) {
+					if _, again := r.ReadLease(); again != err {
+						t.Fatalf("strict reader expected the same error but got %v", again)
+					}
+				}
+				return
+			}
+			// a reader never reads more payload than the input
+			if total += lease.Len(); total > len(data) {
+				t.Fatalf("read %d payload bytes from %d bytes", total, len(data))
+			}
+			lease.Release()
+		}
+	})
+}
+
+func TestMessage_WireFormat(t *testing.T) {
+	// the canonical headers of FrameFormatSox
+	vectors := []struct {
+		length int
+		order  binary.ByteOrder
+		header []byte
+	}{
+		{0, binary.BigEndian, []byte{0x00}},
+		{253, binary.BigEndian, []byte{0xfd}},
+		{253, binary.LittleEndian, []byte{0xfd}},
+		{254, binary.BigEndian, []byte{0xfe, 0x00, 0xfe}},
+		{254, binary.LittleEndian, []byte{0xfe, 0xfe, 0x00}},
+		{65535, binary.BigEndian, []byte{0xfe, 0xff, 0xff}},
+		{65536, binary.BigEndian, []byte{0xff, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}},
+		{65536, binary.LittleEndian, []byte{0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{1<<24 + 2, binary.BigEndian, []byte{0xff, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x02}},
+		{1<<24 + 2, binary.LittleEndian, []byte{0xff, 0x02, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
+	}
+	for _, v := range vectors {
+		buf := bytes.Buffer{}
+		order := sox.MessageOptionsByteOrder(v.order)
+		_, err := sox.NewMessageWriter(&buf, order).Write(make([]byte, v.length))
+		if err != nil {
+			t.Errorf("write message: %v", err)
+			return
+		}
+		if !bytes.Equal(buf.Bytes()[:len(v.header)], v.header) || buf.Len() != len(v.header)+v.length {
+			t.Errorf("%v length %d expected header % x but got % x", v.order, v.length, v.header, buf.Bytes()[:len(v.header)])
+			return
+		}
+		n, err := sox.NewMessageReader(&buf, order, func(options *sox.MessageOptions) {
+			options.Strict = true
+		}).Read(make([]byte, v.length))
+		if err != nil || n != v.length {
+			t.Errorf("%v length %d read expected %d bytes but got %d: %v", v.order, v.length, v.length, n, err)
+			return
+		}
+	}
+
+	t.Run("network order only", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		little := sox.MessageOptionsByteOrder(binary.LittleEndian)
+		_, _ = sox.NewMessageWriter(&buf, sox.MessageOptionsNetworkOrderOnly, little).Write(make([]byte, 254))
+		if !bytes.Equal(buf.Bytes()[:3], []byte{0xfe, 0x00, 0xfe}) {
+			t.Errorf("expected the network order header but got % x", buf.Bytes()[:3])
+			return
+		}
+		n, err := sox.NewMessageReader(&buf, little, sox.MessageOptionsNetworkOrderOnly).Read(make([]byte, 512))
+		if err != nil || n != 254 {
+			t.Errorf("read expected 254 bytes but got %d: %v", n, err)
+			return
+		}
+	})
+}
@@ -0,0 +1,203 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"maps"
+	"slices"
+)
+
+//
+// The metadata header extension precedes the payload of a message:
+//
+// | count (1) | key length (1) | key ... | value length (2) | value ... | ... | payload ... |
+//
+// The entries are sorted by the keys, so that the same metadata is always
+// encoded into the same bytes. A zero count means no metadata
+//
+
+const (
+	metadataMaxEntries     = 1<<8 - 1
+	metadataMaxKeyLength   = 1<<8 - 1
+	metadataMaxValueLength = 1<<16 - 1
+)
+
+// Metadata is a small map of strings which is propagated over the wire along
+// with the messages, e.g. trace ids and tenant ids. It has at most 255 entries
+// with keys of at most 255 bytes and values of at most 64K bytes
+type Metadata map[string]string
+
+// ContextWithMetadata returns a context which carries the metadata md
+// The metadata is stored with ContextWithUserdata
+func ContextWithMetadata(parent context.Context, md Metadata) context.Context {
+	return ContextWithUserdata[Metadata](parent, md)
+}
+
+// ContextMetadata returns the metadata carried by ctx, or nil if there is none
+func ContextMetadata(ctx context.Context) Metadata {
+	return ContextUserdata[Metadata](ctx)
+}
+
+// AppendMetadata appends the metadata header extension of md to dst and
+// returns the extended buffer. It returns ErrMsgTooLong if md exceeds the limits
+func AppendMetadata(dst []byte, md Metadata) ([]byte, error) {
+	if len(md) > metadataMaxEntries {
+		return dst, ErrMsgTooLong
+	}
+	b := append(dst, byte(len(md)))
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		v := md[k]
+		if len(k) > metadataMaxKeyLength || len(v) > metadataMaxValueLength {
+			return dst, ErrMsgTooLong
+		}
+		b = append(b, byte(len(k)))
+		b = append(b, k...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		b = append(b, v...)
+	}
+
+	return b, nil
+}
+
+// ParseMetadata parses the metadata header extension at the beginning of msg
+// and returns the metadata and the rest of msg, which is the payload
+// The returned metadata is nil if the extension has no entry
+func ParseMetadata(msg []byte) (md Metadata, payload []byte, err error) {
+	if len(msg) < 1 {
+		return nil, nil, ErrMsgProtocol
+	}
+	n, b := int(msg[0]), msg[1:]
+	if n > 0 {
+		md = make(Metadata, n)
+	}
+	for range n {
+		if len(b) < 1 {
+			return nil, nil, ErrMsgProtocol
+		}
+		kl := int(b[0])
+		if len(b) < 1+kl+2 {
+			return nil, nil, ErrMsgProtocol
+		}
+		k := string(b[1 : 1+kl])
+		b = b[1+kl:]
+		vl := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+vl {
+			return nil, nil, ErrMsgProtocol
+		}
+		md[k], b = string(b[2:2+vl]), b[2+vl:]
+	}
+
+	return md, b, nil
+}
+
+// MessageContextWriter is the interface that groups the basic Write method and WriteContext method
+type MessageContextWriter interface {
+	io.Writer
+	// WriteContext writes p as a message with the metadata header extension
+	// of the metadata carried by ctx. A nonblocking WriteContext which returns
+	// ErrTemporarilyUnavailable must be retried with the same ctx and p
+	WriteContext(ctx context.Context, p []byte) (n int, err error)
+}
+
+// MessageContextReader is the interface that groups the basic Read method and ReadContext method
+type MessageContextReader interface {
+	io.Reader
+	// ReadContext reads a whole message written by WriteContext, copies the
+	// payload into p, and returns ctx with the metadata of the message
+	// If p is too small, the payload is truncated and io.ErrShortBuffer is returned
+	ReadContext(ctx context.Context, p []byte) (ret context.Context, n int, err error)
+}
+
+func (msg *message) writeContext(ctx context.Context, p []byte) (n int, err error) {
+	lease := msg.pool.Get(BufferSizeSmall + len(p))
+	defer lease.Release()
+	b, err := AppendMetadata(lease.Bytes()[:0], ContextMetadata(ctx))
+	if err != nil {
+		return 0, err
+	}
+	_, err = msg.write(append(b, p...))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (msg *message) readContext(ctx context.Context, p []byte) (ret context.Context, n int, err error) {
+	lease, err := msg.readLease()
+	if err != nil {
+		return ctx, 0, err
+	}
+	defer lease.Release()
+	md, payload, err := ParseMetadata(lease.Bytes())
+	if err != nil {
+		return ctx, 0, err
+	}
+	if md != nil {
+		ctx = ContextWithMetadata(ctx, md)
+	}
+	n = copy(p, payload)
+	if n < len(payload) {
+		return ctx, n, io.ErrShortBuffer
+	}
+
+	return ctx, n, nil
+}
+
+// MetadataHandler is a MessageHandler which restores the metadata of the
+// request messages written by WriteContext into the request context before
+// it dispatches the payload to Handler. The request is read with ReadLease if
+// it implements MessageLeaseReader, or else with one Read of at most
+// BufferSizeLarge bytes. The requests without valid extensions are dropped
+type MetadataHandler struct {
+	Handler MessageHandler
+}
+
+// ServeMessage serves the payload of the request with the metadata in the ctx
+func (h *MetadataHandler) ServeMessage(ctx context.Context, reply PollWriter, request PollReader) {
+	lease, err := readRequest(request)
+	if err != nil {
+		return
+	}
+	defer lease.Release()
+	md, payload, err := ParseMetadata(lease.Bytes())
+	if err != nil {
+		return
+	}
+	if md != nil {
+		ctx = ContextWithMetadata(ctx, md)
+	}
+	h.Handler.ServeMessage(ctx, reply, &requestReader{Reader: bytes.NewReader(payload), fd: request.Fd()})
+}
+
+// requestReader is the PollReader of a request message which has been read
+type requestReader struct {
+	*bytes.Reader
+	fd int
+}
+
+func (r *requestReader) Fd() int {
+	return r.fd
+}
+
+// readRequest reads a whole request message from the request of a MessageHandler
+func readRequest(request PollReader) (*BufferLease, error) {
+	if lr, ok := request.(MessageLeaseReader); ok {
+		return lr.ReadLease()
+	}
+	lease := DefaultBufferPool.Get(BufferSizeLarge)
+	n, err := request.Read(lease.Bytes())
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	lease.Truncate(n)
+
+	return lease, nil
+}
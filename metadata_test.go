@@ -0,0 +1,120 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"context"
+	"hybscloud.com/sox"
+	"io"
+	"maps"
+	"strings"
+	"testing"
+)
+
+type testMetadataHandler struct {
+	md      sox.Metadata
+	payload []byte
+}
+
+func (h *testMetadataHandler) ServeMessage(ctx context.Context, reply sox.PollWriter, request sox.PollReader) {
+	h.md = sox.ContextMetadata(ctx)
+	h.payload, _ = io.ReadAll(request)
+}
+
+// testPollBuffer is a PollReader of the messages in a buffer
+type testPollBuffer struct {
+	io.Reader
+}
+
+func (b *testPollBuffer) Fd() int {
+	return -1
+}
+
+func TestMetadata(t *testing.T) {
+	md := sox.Metadata{"trace-id": "4bf92f3577b34da6", "tenant": "hayabusa"}
+
+	t.Run("wire", func(t *testing.T) {
+		b, err := sox.AppendMetadata([]byte("prefix"), md)
+		if err != nil {
+			t.Errorf("append metadata: %v", err)
+			return
+		}
+		b = append(b, "payload"...)
+		got, payload, err := sox.ParseMetadata(b[len("prefix"):])
+		if err != nil || !maps.Equal(got, md) || string(payload) != "payload" {
+			t.Errorf("parse metadata expected %v but got %v %q: %v", md, got, payload, err)
+			return
+		}
+		got, payload, err = sox.ParseMetadata([]byte{0})
+		if err != nil || got != nil || len(payload) != 0 {
+			t.Errorf("parse metadata expected nothing but got %v %q: %v", got, payload, err)
+			return
+		}
+		if _, _, err = sox.ParseMetadata(b[len("prefix") : len("prefix")+8]); err != sox.ErrMsgProtocol {
+			t.Errorf("parse metadata expected ErrMsgProtocol but got %v", err)
+			return
+		}
+		long := sox.Metadata{strings.Repeat("k", 255): "v"}
+		b, err = sox.AppendMetadata(nil, long)
+		if err != nil {
+			t.Errorf("append metadata: %v", err)
+			return
+		}
+		got, payload, err = sox.ParseMetadata(b)
+		if err != nil || !maps.Equal(got, long) || len(payload) != 0 {
+			t.Errorf("parse metadata expected the key of 255 bytes but got %v: %v", got, err)
+			return
+		}
+		if _, _, err = sox.ParseMetadata(b[:len(b)-3]); err != sox.ErrMsgProtocol {
+			t.Errorf("parse metadata expected ErrMsgProtocol but got %v", err)
+			return
+		}
+		if _, err = sox.AppendMetadata(nil, sox.Metadata{"k": strings.Repeat("v", 1<<16)}); err != sox.ErrMsgTooLong {
+			t.Errorf("append metadata expected ErrMsgTooLong but got %v", err)
+			return
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w := sox.NewMessageWriter(buf).(sox.MessageContextWriter)
+		r := sox.NewMessageReader(buf).(sox.MessageContextReader)
+		_, err := w.WriteContext(sox.ContextWithMetadata(context.Background(), md), []byte("hello"))
+		if err != nil {
+			t.Errorf("write context: %v", err)
+			return
+		}
+		_, err = w.WriteContext(context.Background(), []byte("no metadata"))
+		if err != nil {
+			t.Errorf("write context: %v", err)
+			return
+		}
+		p := make([]byte, 16)
+		ctx, n, err := r.ReadContext(context.Background(), p)
+		if err != nil || string(p[:n]) != "hello" || !maps.Equal(sox.ContextMetadata(ctx), md) {
+			t.Errorf("read context expected hello with %v but got %q with %v: %v", md, p[:n], sox.ContextMetadata(ctx), err)
+			return
+		}
+		ctx, n, err = r.ReadContext(context.Background(), p[:2])
+		if err != io.ErrShortBuffer || string(p[:n]) != "no" || sox.ContextMetadata(ctx) != nil {
+			t.Errorf("read context expected short buffer but got %q: %v", p[:n], err)
+			return
+		}
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w := sox.NewMessageWriter(buf).(sox.MessageContextWriter)
+		_, _ = w.WriteContext(sox.ContextWithMetadata(context.Background(), md), []byte("request"))
+		h := &testMetadataHandler{}
+		mh := &sox.MetadataHandler{Handler: h}
+		mh.ServeMessage(context.Background(), nil, &testPollBuffer{Reader: sox.NewMessageReader(buf)})
+		if !maps.Equal(h.md, md) || string(h.payload) != "request" {
+			t.Errorf("handler expected request with %v but got %q with %v", md, h.payload, h.md)
+			return
+		}
+	})
+}
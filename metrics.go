@@ -0,0 +1,154 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// spinWaitYields counts the spin waits which yielded the processor or slept
+var spinWaitYields atomic.Uint64
+
+// Metrics collects the statistics of an event loop
+// All methods are safe to be called from any goroutine
+// Metrics implements expvar.Var, so that it can be published with expvar.Publish
+type Metrics struct {
+	accepted    atomic.Uint64
+	closed      atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	pollWakeups atomic.Uint64
+
+	sqDepth        atomic.Int64
+	cqDepth        atomic.Int64
+	queueOccupancy atomic.Int64
+}
+
+// Stats is a snapshot of Metrics
+type Stats struct {
+	// Accepted is the number of accepted connections
+	Accepted uint64 `json:"accepted"`
+	// Closed is the number of closed connections
+	Closed uint64 `json:"closed"`
+	// BytesIn is the number of bytes read from connections
+	BytesIn uint64 `json:"bytes_in"`
+	// BytesOut is the number of bytes written to connections
+	BytesOut uint64 `json:"bytes_out"`
+	// PollWakeups is the number of times the polling goroutine woke up with events
+	PollWakeups uint64 `json:"poll_wakeups"`
+	// SpinWaitYields is the number of spin waits in the process
+	// which yielded the processor or slept
+	SpinWaitYields uint64 `json:"spin_wait_yields"`
+	// SQDepth is the number of the pending submission queue entries
+	SQDepth int64 `json:"sq_depth"`
+	// CQDepth is the number of the unconsumed completion queue entries
+	CQDepth int64 `json:"cq_depth"`
+	// QueueOccupancy is the number of the items in the task and message queues
+	QueueOccupancy int64 `json:"queue_occupancy"`
+}
+
+// AddAccepted adds n to the number of accepted connections
+func (m *Metrics) AddAccepted(n uint64) {
+	m.accepted.Add(n)
+}
+
+// AddClosed adds n to the number of closed connections
+func (m *Metrics) AddClosed(n uint64) {
+	m.closed.Add(n)
+}
+
+// AddBytesIn adds n to the number of bytes read from connections
+func (m *Metrics) AddBytesIn(n uint64) {
+	m.bytesIn.Add(n)
+}
+
+// AddBytesOut adds n to the number of bytes written to connections
+func (m *Metrics) AddBytesOut(n uint64) {
+	m.bytesOut.Add(n)
+}
+
+// AddPollWakeups adds n to the number of poll wakeups
+func (m *Metrics) AddPollWakeups(n uint64) {
+	m.pollWakeups.Add(n)
+}
+
+// SetSQDepth sets the number of the pending submission queue entries
+func (m *Metrics) SetSQDepth(n int64) {
+	m.sqDepth.Store(n)
+}
+
+// SetCQDepth sets the number of the unconsumed completion queue entries
+func (m *Metrics) SetCQDepth(n int64) {
+	m.cqDepth.Store(n)
+}
+
+// SetQueueOccupancy sets the number of the items in the task and message queues
+func (m *Metrics) SetQueueOccupancy(n int64) {
+	m.queueOccupancy.Store(n)
+}
+
+// Stats returns a snapshot of the Metrics
+func (m *Metrics) Stats() Stats {
+	return Stats{
+		Accepted:       m.accepted.Load(),
+		Closed:         m.closed.Load(),
+		BytesIn:        m.bytesIn.Load(),
+		BytesOut:       m.bytesOut.Load(),
+		PollWakeups:    m.pollWakeups.Load(),
+		SpinWaitYields: spinWaitYields.Load(),
+		SQDepth:        m.sqDepth.Load(),
+		CQDepth:        m.cqDepth.Load(),
+		QueueOccupancy: m.queueOccupancy.Load(),
+	}
+}
+
+// String returns the snapshot of the Metrics in JSON as an expvar.Var
+func (m *Metrics) String() string {
+	b, _ := json.Marshal(m.Stats())
+	return string(b)
+}
+
+// Publish publishes the Metrics with the given name in expvar
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, m)
+}
+
+// WritePrometheus writes the snapshot of the Metrics to w in the Prometheus
+// text exposition format. Each metric name is prefixed with the namespace
+func (m *Metrics) WritePrometheus(w io.Writer, namespace string) error {
+	s := m.Stats()
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value any
+	}{
+		{"accepted_total", "counter", "The number of accepted connections", s.Accepted},
+		{"closed_total", "counter", "The number of closed connections", s.Closed},
+		{"bytes_in_total", "counter", "The number of bytes read from connections", s.BytesIn},
+		{"bytes_out_total", "counter", "The number of bytes written to connections", s.BytesOut},
+		{"poll_wakeups_total", "counter", "The number of poll wakeups with events", s.PollWakeups},
+		{"spin_wait_yields_total", "counter", "The number of spin waits which yielded or slept", s.SpinWaitYields},
+		{"sq_depth", "gauge", "The number of pending submission queue entries", s.SQDepth},
+		{"cq_depth", "gauge", "The number of unconsumed completion queue entries", s.CQDepth},
+		{"queue_occupancy", "gauge", "The number of items in the task and message queues", s.QueueOccupancy},
+	}
+	if namespace != "" {
+		namespace += "_"
+	}
+	for _, metric := range metrics {
+		name := namespace + metric.name
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, metric.help, name, metric.kind, name, metric.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"hybscloud.com/sox"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := &sox.Metrics{}
+	m.AddAccepted(3)
+	m.AddClosed(1)
+	m.AddBytesIn(100)
+	m.AddBytesOut(200)
+	m.AddPollWakeups(5)
+	m.SetSQDepth(7)
+	m.SetCQDepth(2)
+	m.SetQueueOccupancy(9)
+
+	t.Run("stats", func(t *testing.T) {
+		s := m.Stats()
+		if s.Accepted != 3 || s.Closed != 1 || s.BytesIn != 100 || s.BytesOut != 200 || s.PollWakeups != 5 {
+			t.Errorf("unexpected counters: %+v", s)
+			return
+		}
+		if s.SQDepth != 7 || s.CQDepth != 2 || s.QueueOccupancy != 9 {
+			t.Errorf("unexpected gauges: %+v", s)
+			return
+		}
+	})
+
+	t.Run("spin wait yields", func(t *testing.T) {
+		before := m.Stats().SpinWaitYields
+		sw := sox.SpinWait{}
+		for !sw.WillYield() {
+			sw.Once()
+		}
+		sw.Once()
+		if m.Stats().SpinWaitYields <= before {
+			t.Errorf("expected spin wait yields to be counted")
+			return
+		}
+	})
+
+	t.Run("expvar", func(t *testing.T) {
+		m.Publish("sox_test_metrics")
+		v := expvar.Get("sox_test_metrics")
+		if v == nil {
+			t.Errorf("expected metrics to be published")
+			return
+		}
+		s := sox.Stats{}
+		err := json.Unmarshal([]byte(v.String()), &s)
+		if err != nil {
+			t.Errorf("unmarshal metrics: %v", err)
+			return
+		}
+		if s.Accepted != 3 || s.QueueOccupancy != 9 {
+			t.Errorf("unexpected published stats: %+v", s)
+			return
+		}
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		err := m.WritePrometheus(&buf, "sox")
+		if err != nil {
+			t.Errorf("write prometheus: %v", err)
+			return
+		}
+		for _, line := range []string{"# TYPE sox_accepted_total counter", "sox_accepted_total 3", "sox_bytes_out_total 200", "# TYPE sox_sq_depth gauge", "sox_sq_depth 7"} {
+			if !strings.Contains(buf.String(), line+"\n") {
+				t.Errorf("expected line %q in:\n%s", line, buf.String())
+				return
+			}
+		}
+	})
+}
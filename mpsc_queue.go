@@ -0,0 +1,74 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"sync/atomic"
+)
+
+type mpscNode[T any] struct {
+	next  atomic.Pointer[mpscNode[T]]
+	value T
+}
+
+// mpscQueue is an unbounded intrusive multi-producer single-consumer
+// linked queue, see Dmitry Vyukov's non-intrusive MPSC node-based queue
+// push is wait-free and can be called from any goroutine,
+// pop must only be called by the single consumer goroutine
+type mpscQueue[T any] struct {
+	head atomic.Pointer[mpscNode[T]]
+	tail *mpscNode[T]
+	stub mpscNode[T]
+}
+
+func newMPSCQueue[T any]() *mpscQueue[T] {
+	q := &mpscQueue[T]{}
+	q.head.Store(&q.stub)
+	q.tail = &q.stub
+	return q
+}
+
+func (q *mpscQueue[T]) push(n *mpscNode[T]) {
+	n.next.Store(nil)
+	prev := q.head.Swap(n)
+	prev.next.Store(n)
+}
+
+// pop removes and returns the node at the front of the queue
+// It returns nil if the queue is empty. A producer which has swapped
+// the head but has not linked its node yet is waited for
+func (q *mpscQueue[T]) pop() *mpscNode[T] {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		tail := q.tail
+		next := tail.next.Load()
+		if tail == &q.stub {
+			if next == nil {
+				if q.head.Load() == tail {
+					return nil
+				}
+				sw.Once()
+				continue
+			}
+			q.tail = next
+			tail, next = next, next.next.Load()
+		}
+		if next != nil {
+			q.tail = next
+			return tail
+		}
+		if q.head.Load() != tail {
+			sw.Once()
+			continue
+		}
+		q.push(&q.stub)
+		next = tail.next.Load()
+		if next != nil {
+			q.tail = next
+			return tail
+		}
+		sw.Once()
+	}
+}
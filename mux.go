@@ -0,0 +1,626 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMuxClosed will be returned when the Mux has been closed
+	ErrMuxClosed = errors.New("mux closed")
+	// ErrMuxStreamReset will be returned when the stream has been reset,
+	// e.g. when the peer has no room to accept it or violated its window
+	ErrMuxStreamReset = errors.New("mux stream reset")
+)
+
+//
+// Each frame of muxes is a message, which begins with the frame type
+// and the stream id:
+//
+// open:   | type (1) | stream id (4) |
+// data:   | type (1) | stream id (4) | payload ... |
+// window: | type (1) | stream id (4) | credit (4) |
+// close:  | type (1) | stream id (4) |
+// reset:  | type (1) | stream id (4) |
+//
+// The client side opens the streams with odd ids and the server side with
+// even ids. Each side of a stream starts with MuxInitialWindow bytes of
+// credit to write, and the window frames add the credit as the reader
+// consumes the data. The close frame half-closes the stream, after which
+// the side writes no more data, and the reset frame aborts the stream
+//
+
+const (
+	muxFrameOpen   = 1
+	muxFrameData   = 2
+	muxFrameWindow = 3
+	muxFrameClose  = 4
+	muxFrameReset  = 5
+
+	muxHeaderLength = 1 + 4
+	muxMaxFrameSize = 16 << 10
+)
+
+// MuxInitialWindow is the initial window of each stream in bytes
+const MuxInitialWindow = 256 << 10
+
+// MuxOptions represents the options of muxes
+type MuxOptions struct {
+	// Client specifies whether the Mux is the client side of the connection
+	// The two sides of a connection must be on the different sides
+	Client bool
+	// Window is the receive window of each stream in bytes, which is at least
+	// MuxInitialWindow. A larger window lets a stream write faster over the
+	// connections with high bandwidth-delay products
+	Window int
+	// AcceptBacklog is the maximum number of the streams opened by the peer
+	// which are waiting for Accept. The streams beyond it are reset
+	AcceptBacklog int
+	// MessageOptions are applied to the message reader and writer of the connection
+	// The Nonblock option is ignored, since the frames are exchanged synchronously
+	MessageOptions []func(options *MessageOptions)
+}
+
+var defaultMuxOptions = MuxOptions{
+	Client:        false,
+	Window:        MuxInitialWindow,
+	AcceptBacklog: 64,
+}
+
+// Mux multiplexes independent logical streams over one connection, e.g. a
+// TCP or a Unix stream connection. Each stream is a Conn, which is opened by
+// Open on one side and returned by Accept on the other side, and has its own
+// flow control window, so that a slow stream does not stall the others
+// Mux implements Listener, and all of its methods are safe to be called
+// from any goroutine. The frames of the connection are read by a goroutine
+// which NewMux starts until the connection is broken or the Mux is closed
+type Mux struct {
+	opt  MuxOptions
+	conn io.ReadWriter
+	rd   io.Reader
+
+	wmu sync.Mutex
+	wr  io.Writer
+
+	mu      sync.Mutex
+	streams map[uint32]*MuxStream
+	next    uint32
+	err     error
+	accept  chan *MuxStream
+	done    chan struct{}
+	// closeOnce closes the connection on the first Close, even if the
+	// Mux has been shut down by the broken connection before
+	closeOnce sync.Once
+}
+
+// NewMux creates and returns a new Mux over the connection conn
+func NewMux(conn io.ReadWriter, opts ...func(options *MuxOptions)) *Mux {
+	opt := defaultMuxOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	opt.Window = max(MuxInitialWindow, opt.Window)
+	opt.AcceptBacklog = max(1, opt.AcceptBacklog)
+	opt.MessageOptions = append(opt.MessageOptions[:len(opt.MessageOptions):len(opt.MessageOptions)], func(options *MessageOptions) {
+		options.Nonblock = false
+	})
+	m := &Mux{
+		opt:     opt,
+		conn:    conn,
+		rd:      NewMessageReader(conn, opt.MessageOptions...),
+		wr:      NewMessageWriter(conn, opt.MessageOptions...),
+		streams: make(map[uint32]*MuxStream),
+		next:    2,
+		accept:  make(chan *MuxStream, opt.AcceptBacklog),
+		done:    make(chan struct{}),
+	}
+	if opt.Client {
+		m.next = 1
+	}
+	goLabeled(ProfileLabelReader, m.readLoop)
+
+	return m
+}
+
+// Open opens a new stream to the peer
+func (m *Mux) Open() (*MuxStream, error) {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return nil, m.err
+	}
+	s := m.newStream(m.next)
+	m.next += 2
+	m.streams[s.id] = s
+	m.mu.Unlock()
+
+	err := m.writeFrame(muxFrameOpen, s.id, nil)
+	if err == nil {
+		err = s.openWindow()
+	}
+	if err != nil {
+		m.removeStream(s.id)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Accept waits for and returns the next stream opened by the peer
+func (m *Mux) Accept() (Conn, error) {
+	s, err := m.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer
+func (m *Mux) AcceptStream() (*MuxStream, error) {
+	select {
+	case s := <-m.accept:
+		if err := s.openWindow(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case <-m.done:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return nil, m.err
+	}
+}
+
+// Addr returns the local address of the connection, if the connection is a Conn
+func (m *Mux) Addr() Addr {
+	if conn, ok := m.conn.(Conn); ok {
+		return conn.LocalAddr()
+	}
+	return muxAddr{}
+}
+
+// NumStreams returns the number of the streams which are not fully closed
+func (m *Mux) NumStreams() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.streams)
+}
+
+// Close closes the Mux, all of its streams and the connection if it is an io.Closer
+func (m *Mux) Close() (err error) {
+	m.shutdown(ErrMuxClosed)
+	m.closeOnce.Do(func() {
+		if c, ok := m.conn.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return err
+}
+
+// shutdown fails the Mux and its streams with err, and reports whether it was the first
+func (m *Mux) shutdown(err error) bool {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return false
+	}
+	m.err = err
+	streams := m.streams
+	m.streams = make(map[uint32]*MuxStream)
+	close(m.done)
+	m.mu.Unlock()
+	for _, s := range streams {
+		s.fail(err)
+	}
+
+	return true
+}
+
+func (m *Mux) readLoop() {
+	b := make([]byte, muxHeaderLength+muxMaxFrameSize)
+	for {
+		n, err := m.rd.Read(b)
+		if err == nil && n < muxHeaderLength {
+			err = ErrMsgProtocol
+		}
+		if err == nil {
+			err = m.handleFrame(b[0], binary.BigEndian.Uint32(b[1:]), b[muxHeaderLength:n])
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = ErrMuxClosed
+			}
+			m.shutdown(err)
+			return
+		}
+	}
+}
+
+func (m *Mux) handleFrame(typ byte, id uint32, p []byte) error {
+	if typ == muxFrameOpen {
+		// the peer opens the streams with the ids of its own side
+		if id == 0 || (id%2 == 1) == m.opt.Client {
+			return ErrMsgProtocol
+		}
+		m.mu.Lock()
+		_, ok := m.streams[id]
+		var s *MuxStream
+		if !ok && m.err == nil {
+			s = m.newStream(id)
+			m.streams[id] = s
+		}
+		m.mu.Unlock()
+		if s == nil {
+			return ErrMsgProtocol
+		}
+		select {
+		case m.accept <- s:
+			return nil
+		default:
+			m.removeStream(id)
+			return m.writeFrame(muxFrameReset, id, nil)
+		}
+	}
+
+	m.mu.Lock()
+	s := m.streams[id]
+	m.mu.Unlock()
+	if s == nil {
+		// the frames of the streams which have been closed or reset are dropped,
+		// and the data is answered with a reset so that the peer stops writing
+		if typ == muxFrameData {
+			return m.writeFrame(muxFrameReset, id, nil)
+		}
+		return nil
+	}
+	switch typ {
+	case muxFrameData:
+		if !s.receive(p) {
+			s.fail(ErrMuxStreamReset)
+			m.removeStream(id)
+			return m.writeFrame(muxFrameReset, id, nil)
+		}
+	case muxFrameWindow:
+		if len(p) != 4 {
+			return ErrMsgProtocol
+		}
+		s.addCredit(int(binary.BigEndian.Uint32(p)))
+	case muxFrameClose:
+		s.closeRead()
+	case muxFrameReset:
+		s.fail(ErrMuxStreamReset)
+		m.removeStream(id)
+	default:
+		return ErrMsgProtocol
+	}
+
+	return nil
+}
+
+func (m *Mux) writeFrame(typ byte, id uint32, p []byte) error {
+	b := make([]byte, muxHeaderLength+len(p))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], id)
+	copy(b[muxHeaderLength:], p)
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	select {
+	case <-m.done:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.err
+	default:
+	}
+	_, err := m.wr.Write(b)
+	return err
+}
+
+func (m *Mux) writeWindow(id uint32, credit int) error {
+	b := [4]byte{}
+	binary.BigEndian.PutUint32(b[:], uint32(credit))
+	return m.writeFrame(muxFrameWindow, id, b[:])
+}
+
+func (m *Mux) removeStream(id uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+}
+
+func (m *Mux) newStream(id uint32) *MuxStream {
+	return &MuxStream{
+		mux:     m,
+		id:      id,
+		credit:  MuxInitialWindow,
+		window:  MuxInitialWindow,
+		rnotify: make(chan struct{}, 1),
+		wnotify: make(chan struct{}, 1),
+	}
+}
+
+// MuxStream is a logical stream of a Mux, which implements Conn
+// Read and Write may be called concurrently with each other
+type MuxStream struct {
+	mux *Mux
+	id  uint32
+
+	mu sync.Mutex
+	// buf holds the received data which has not been read
+	buf []byte
+	// window is the receive window, consumed is the number of the bytes
+	// read since the last window frame, and credit is the number of the
+	// bytes which may be written to the peer
+	window   int
+	consumed int
+	credit   int
+	// readClosed is set by the close frame of the peer, writeClosed by
+	// CloseWrite and Close, and closed by Close
+	readClosed  bool
+	writeClosed bool
+	closed      bool
+	err         error
+	rdeadline   time.Time
+	wdeadline   time.Time
+	// rnotify and wnotify wake up the reader and the writer when any state above changes
+	rnotify chan struct{}
+	wnotify chan struct{}
+}
+
+// ID returns the stream id
+func (s *MuxStream) ID() uint32 {
+	return s.id
+}
+
+// Read reads the data of the stream into p, and returns io.EOF after
+// the peer has closed the stream and all of the data has been read
+func (s *MuxStream) Read(p []byte) (n int, err error) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(s.buf) > 0 {
+			n = copy(p, s.buf)
+			s.buf = s.buf[n:]
+			s.consumed += n
+			credit := 0
+			// the window is reopened once half of it has been consumed
+			if s.consumed >= s.window/2 && !s.readClosed {
+				credit, s.consumed = s.consumed, 0
+			}
+			s.mu.Unlock()
+			if credit > 0 {
+				err = s.mux.writeWindow(s.id, credit)
+			}
+			return n, err
+		}
+		if s.err != nil {
+			err = s.err
+		} else if s.readClosed {
+			err = io.EOF
+		}
+		deadline := s.rdeadline
+		s.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if err = s.wait(s.rnotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes p to the stream, and blocks while the window of the peer is exhausted
+func (s *MuxStream) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		s.mu.Lock()
+		if s.err != nil {
+			err = s.err
+		} else if s.writeClosed {
+			err = net.ErrClosed
+		}
+		k := min(len(p)-n, s.credit, muxMaxFrameSize)
+		s.credit -= k
+		deadline := s.wdeadline
+		s.mu.Unlock()
+		if err != nil {
+			return n, err
+		}
+		if k == 0 {
+			if err = s.wait(s.wnotify, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		err = s.mux.writeFrame(muxFrameData, s.id, p[n:n+k])
+		if err != nil {
+			return n, err
+		}
+		n += k
+	}
+
+	return n, nil
+}
+
+// Close closes the stream. The peer reads io.EOF after the written data,
+// and the stream is reset if the peer writes more data after it
+// The stream is removed from the Mux at once, since it is read no more
+func (s *MuxStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed, s.buf = true, nil
+	s.signal()
+	s.mu.Unlock()
+	err := s.CloseWrite()
+	s.mux.removeStream(s.id)
+
+	return err
+}
+
+// CloseWrite half-closes the stream. The peer reads io.EOF after the written
+// data, while the stream can still be read. The stream is removed from the
+// Mux once both sides have closed it for writing
+func (s *MuxStream) CloseWrite() error {
+	s.mu.Lock()
+	if s.writeClosed || s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	done := s.readClosed
+	s.signal()
+	s.mu.Unlock()
+	if done {
+		s.mux.removeStream(s.id)
+	}
+
+	return s.mux.writeFrame(muxFrameClose, s.id, nil)
+}
+
+// LocalAddr returns the local address of the connection of the Mux
+func (s *MuxStream) LocalAddr() Addr {
+	return s.mux.Addr()
+}
+
+// RemoteAddr returns the remote address of the connection of the Mux
+func (s *MuxStream) RemoteAddr() Addr {
+	if conn, ok := s.mux.conn.(Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return muxAddr{}
+}
+
+// SetDeadline sets the read and the write deadlines of the stream
+func (s *MuxStream) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rdeadline, s.wdeadline = t, t
+	s.signal()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline of the stream
+func (s *MuxStream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rdeadline = t
+	s.signal()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the stream
+func (s *MuxStream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wdeadline = t
+	s.signal()
+	return nil
+}
+
+// openWindow advertises the receive window beyond MuxInitialWindow to the peer
+func (s *MuxStream) openWindow() error {
+	s.mu.Lock()
+	credit := s.mux.opt.Window - s.window
+	s.window = s.mux.opt.Window
+	s.mu.Unlock()
+	if credit == 0 {
+		return nil
+	}
+	return s.mux.writeWindow(s.id, credit)
+}
+
+// receive appends the data of the peer, and reports whether it is within the window
+func (s *MuxStream) receive(p []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readClosed || s.closed {
+		return false
+	}
+	if len(s.buf)+s.consumed+len(p) > s.window {
+		return false
+	}
+	s.buf = append(s.buf, p...)
+	s.signal()
+	return true
+}
+
+func (s *MuxStream) addCredit(credit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credit += credit
+	s.signal()
+}
+
+func (s *MuxStream) closeRead() {
+	s.mu.Lock()
+	s.readClosed = true
+	done := s.writeClosed
+	s.signal()
+	s.mu.Unlock()
+	if done {
+		s.mux.removeStream(s.id)
+	}
+}
+
+func (s *MuxStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.signal()
+}
+
+// signal wakes up the reader and the writer. The caller must hold mu
+func (s *MuxStream) signal() {
+	select {
+	case s.rnotify <- struct{}{}:
+	default:
+	}
+	select {
+	case s.wnotify <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits for a change of the stream state on notify until the deadline
+func (s *MuxStream) wait(notify chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-notify
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-notify:
+		return nil
+	case <-t.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+type muxAddr struct{}
+
+func (muxAddr) Network() string {
+	return "mux"
+}
+
+func (muxAddr) String() string {
+	return "mux"
+}
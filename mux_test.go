@@ -0,0 +1,251 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// muxPair returns the client and the server Mux over a loopback connection
+func muxPair(t *testing.T, opts ...func(options *sox.MuxOptions)) (client *sox.Mux, server *sox.Mux) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	ch := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+		}
+		ch <- conn
+	}()
+	cc, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	sc := <-ch
+	if sc == nil {
+		t.FailNow()
+	}
+	client = sox.NewMux(cc, append(opts, func(options *sox.MuxOptions) { options.Client = true })...)
+	server = sox.NewMux(sc, opts...)
+	return client, server
+}
+
+func TestMux_Streams(t *testing.T) {
+	client, server := muxPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	// the server echoes each stream back
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	const streams, size = 8, 1 << 20
+	wg := sync.WaitGroup{}
+	errs := make(chan error, streams)
+	for i := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := client.Open()
+			if err != nil {
+				errs <- err
+				return
+			}
+			// each stream writes more than its window while the echo is read
+			data := bytes.Repeat([]byte{byte(i)}, size)
+			go func() {
+				_, _ = s.Write(data)
+				_ = s.CloseWrite()
+			}()
+			echo, err := io.ReadAll(s)
+			_ = s.Close()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(echo, data) {
+				errs <- errors.New("unexpected echo")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("stream: %v", err)
+		return
+	}
+	for sw := sox.NewSpinWait().SetLimit(1 << 16); client.NumStreams()+server.NumStreams() > 0 && !sw.Closed(); sw.Once() {
+	}
+	if n := client.NumStreams() + server.NumStreams(); n != 0 {
+		t.Errorf("expected all streams removed but got %d", n)
+		return
+	}
+}
+
+func TestMux_Close(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		client, server := muxPair(t)
+		defer client.Close()
+		defer server.Close()
+		s, err := client.Open()
+		if err != nil {
+			t.Errorf("open: %v", err)
+			return
+		}
+		_ = s.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, err = s.Read(make([]byte, 1))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("read expected ErrDeadlineExceeded but got %v", err)
+			return
+		}
+	})
+
+	t.Run("mux", func(t *testing.T) {
+		client, server := muxPair(t)
+		defer server.Close()
+		s, err := client.Open()
+		if err != nil {
+			t.Errorf("open: %v", err)
+			return
+		}
+		accepted := make(chan error, 1)
+		go func() {
+			_, err := server.Accept()
+			if err == nil {
+				_, err = server.Accept()
+			}
+			accepted <- err
+		}()
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.Read(make([]byte, 1))
+			done <- err
+		}()
+		_ = client.Close()
+		if err := <-done; err != sox.ErrMuxClosed {
+			t.Errorf("read expected ErrMuxClosed but got %v", err)
+			return
+		}
+		// the server observes the broken connection
+		if err := <-accepted; err == nil {
+			t.Errorf("accept expected error")
+			return
+		}
+		if _, err = client.Open(); err != sox.ErrMuxClosed {
+			t.Errorf("open expected ErrMuxClosed but got %v", err)
+			return
+		}
+	})
+
+	t.Run("broken connection", func(t *testing.T) {
+		c0, c1 := net.Pipe()
+		conn := &closeCountingConn{Conn: c0}
+		m := sox.NewMux(conn)
+		_ = c1.Close()
+		if _, err := m.Accept(); err == nil {
+			t.Errorf("accept expected error")
+			return
+		}
+		_ = m.Close()
+		_ = m.Close()
+		if conn.closed.Load() != 1 {
+			t.Errorf("expected the connection to be closed once but got %d", conn.closed.Load())
+			return
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		client, server := muxPair(t)
+		defer client.Close()
+		defer server.Close()
+		s, err := client.Open()
+		if err != nil {
+			t.Errorf("open: %v", err)
+			return
+		}
+		ss, err := server.AcceptStream()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		// the stream closed by one side only is not kept
+		_ = s.Close()
+		if client.NumStreams() != 0 {
+			t.Errorf("expected no streams but got %d", client.NumStreams())
+			return
+		}
+		_ = ss.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			_, err = ss.Write([]byte("a"))
+			if err == nil {
+				_, err = ss.Read(make([]byte, 1))
+			}
+			if err == io.EOF {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			break
+		}
+		if err != sox.ErrMuxStreamReset || server.NumStreams() != 0 {
+			t.Errorf("write expected ErrMuxStreamReset but got %v with %d streams", err, server.NumStreams())
+			return
+		}
+	})
+
+	t.Run("backlog", func(t *testing.T) {
+		client, server := muxPair(t, func(options *sox.MuxOptions) { options.AcceptBacklog = 1 })
+		defer client.Close()
+		defer server.Close()
+		_, err := client.Open()
+		if err != nil {
+			t.Errorf("open: %v", err)
+			return
+		}
+		s, err := client.Open()
+		if err != nil {
+			t.Errorf("open: %v", err)
+			return
+		}
+		_, err = s.Read(make([]byte, 1))
+		if err != sox.ErrMuxStreamReset {
+			t.Errorf("read expected ErrMuxStreamReset but got %v", err)
+			return
+		}
+	})
+}
+
+// closeCountingConn counts the calls of Close
+type closeCountingConn struct {
+	net.Conn
+	closed atomic.Int32
+}
+
+func (c *closeCountingConn) Close() error {
+	c.closed.Add(1)
+	return c.Conn.Close()
+}
@@ -8,21 +8,96 @@ import (
 	"errors"
 	"io"
 	"net"
+	"syscall"
 )
 
 var (
-	ErrInterruptedSyscall     = errors.New("interrupted system call")
-	ErrTemporarilyUnavailable = errors.New("resource temporarily unavailable")
-	ErrInProgress             = errors.New("in progress")
-	ErrFaultParams            = errors.New("fault parameters")
-	ErrInvalidParam           = errors.New("invalid param")
-	ErrProcessFileLimit       = errors.New("process open fd limit")
-	ErrSystemFileLimit        = errors.New("system open fd limit")
-	ErrNoDevice               = errors.New("no device")
-	ErrNoAvailableMemory      = errors.New("no available kernel memory")
-	ErrNoPermission           = errors.New("operation not permitted")
+	ErrInterruptedSyscall     = &ErrnoError{msg: "interrupted system call", errno: syscall.EINTR}
+	ErrTemporarilyUnavailable = &ErrnoError{msg: "resource temporarily unavailable", errno: syscall.EAGAIN}
+	ErrInProgress             = &ErrnoError{msg: "in progress", errno: syscall.EINPROGRESS}
+	ErrFaultParams            = &ErrnoError{msg: "fault parameters", errno: syscall.EFAULT}
+	ErrInvalidParam           = &ErrnoError{msg: "invalid param", errno: syscall.EINVAL}
+	ErrProcessFileLimit       = &ErrnoError{msg: "process open fd limit", errno: syscall.EMFILE}
+	ErrSystemFileLimit        = &ErrnoError{msg: "system open fd limit", errno: syscall.ENFILE}
+	ErrNoDevice               = &ErrnoError{msg: "no device", errno: syscall.ENODEV}
+	ErrNoAvailableMemory      = &ErrnoError{msg: "no available kernel memory", errno: syscall.ENOMEM}
+	ErrNoPermission           = &ErrnoError{msg: "operation not permitted", errno: syscall.EPERM}
 )
 
+var (
+	ErrPermissionDenied       = &ErrnoError{msg: "permission denied", errno: syscall.EACCES}
+	ErrBadFd                  = &ErrnoError{msg: "bad file descriptor", errno: syscall.EBADF}
+	ErrNotSocket              = &ErrnoError{msg: "not a socket", errno: syscall.ENOTSOCK}
+	ErrNoBufferSpace          = &ErrnoError{msg: "no buffer space available", errno: syscall.ENOBUFS}
+	ErrMsgSize                = &ErrnoError{msg: "message too long for the socket", errno: syscall.EMSGSIZE}
+	ErrNotSupported           = &ErrnoError{msg: "operation not supported", errno: syscall.EOPNOTSUPP}
+	ErrProtocolNotSupported   = &ErrnoError{msg: "protocol not supported", errno: syscall.EPROTONOSUPPORT}
+	ErrAddrFamilyNotSupported = &ErrnoError{msg: "address family not supported", errno: syscall.EAFNOSUPPORT}
+	ErrAddrInUse              = &ErrnoError{msg: "address already in use", errno: syscall.EADDRINUSE}
+	ErrAddrNotAvailable       = &ErrnoError{msg: "cannot assign requested address", errno: syscall.EADDRNOTAVAIL}
+	ErrNetworkDown            = &ErrnoError{msg: "network is down", errno: syscall.ENETDOWN}
+	ErrNetworkUnreachable     = &ErrnoError{msg: "network is unreachable", errno: syscall.ENETUNREACH}
+	ErrHostUnreachable        = &ErrnoError{msg: "no route to host", errno: syscall.EHOSTUNREACH}
+	ErrConnectionAborted      = &ErrnoError{msg: "connection aborted", errno: syscall.ECONNABORTED}
+	ErrConnectionReset        = &ErrnoError{msg: "connection reset by peer", errno: syscall.ECONNRESET}
+	ErrConnectionRefused      = &ErrnoError{msg: "connection refused", errno: syscall.ECONNREFUSED}
+	ErrAlreadyConnected       = &ErrnoError{msg: "socket is already connected", errno: syscall.EISCONN}
+	ErrNotConnected           = &ErrnoError{msg: "socket is not connected", errno: syscall.ENOTCONN}
+	ErrAlreadyInProgress      = &ErrnoError{msg: "operation already in progress", errno: syscall.EALREADY}
+	ErrTimedOut               = &ErrnoError{msg: "connection timed out", errno: syscall.ETIMEDOUT}
+	ErrBrokenPipe             = &ErrnoError{msg: "broken pipe", errno: syscall.EPIPE}
+)
+
+// ErrnoError is the exported error which an errno of system calls is mapped to
+// It can be compared with == as a sentinel, and unwraps to the errno, so that
+// errors.Is(err, syscall.ECONNRESET) and errors.As with syscall.Errno work
+type ErrnoError struct {
+	msg   string
+	errno syscall.Errno
+}
+
+func (e *ErrnoError) Error() string {
+	return e.msg
+}
+
+// Errno returns the errno which the error is mapped from
+func (e *ErrnoError) Errno() syscall.Errno {
+	return e.errno
+}
+
+// Unwrap returns the errno which the error is mapped from
+func (e *ErrnoError) Unwrap() error {
+	return e.errno
+}
+
+// Timeout reports whether the error is a timeout, e.g. ErrTemporarilyUnavailable
+// and ErrTimedOut, as net.Error does
+func (e *ErrnoError) Timeout() bool {
+	return e.errno.Timeout()
+}
+
+// Temporary reports whether the operation may succeed when it is retried, e.g.
+// ErrTemporarilyUnavailable, ErrInterruptedSyscall and ErrProcessFileLimit
+func (e *ErrnoError) Temporary() bool {
+	return e.errno.Temporary()
+}
+
+var _ net.Error = ErrTemporarilyUnavailable
+
+// IsTemporary reports whether err or the error it wraps is temporary, so that
+// the operation may be retried, as the retry loops written against net.Error do
+func IsTemporary(err error) bool {
+	var e interface{ Temporary() bool }
+	return errors.As(err, &e) && e.Temporary()
+}
+
+// IsTimeout reports whether err or the error it wraps is a timeout, e.g.
+// ErrTemporarilyUnavailable, os.ErrDeadlineExceeded and context.DeadlineExceeded
+func IsTimeout(err error) bool {
+	var e interface{ Timeout() bool }
+	return errors.As(err, &e) && e.Timeout()
+}
+
 type NetworkType int
 
 const (
@@ -39,6 +114,27 @@ type Socket interface {
 	io.Closer
 }
 
+// VectorReader is the interface that wraps the scatter read method Readv
+// Readv reads into the buffers of iovs in order with a single system call
+type VectorReader interface {
+	Readv(iovs [][]byte) (n int, err error)
+}
+
+// VectorWriter is the interface that wraps the gather write method Writev
+// Writev writes the buffers of iovs in order with a single system call
+type VectorWriter interface {
+	Writev(iovs [][]byte) (n int, err error)
+}
+
+// Peeker is the interface that wraps the Peek method
+// Peek returns the next n bytes without consuming them, so that a protocol
+// can be sniffed, e.g. TLS or plaintext, before the connection is handed over
+// to the message reader. Fewer than n bytes are returned with
+// ErrTemporarilyUnavailable if the rest of them have not been received yet
+type Peeker interface {
+	Peek(n int) ([]byte, error)
+}
+
 type Listener = net.Listener
 type Conn = net.Conn
 type Addr = net.Addr
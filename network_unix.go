@@ -13,6 +13,41 @@ import (
 
 type Sockaddr = unix.Sockaddr
 
+// MsgReader is the interface that wraps the Recvmsg method, which reads
+// into the buffers together with the ancillary data of the socket
+type MsgReader interface {
+	Recvmsg(buffers [][]byte, oob []byte) (n, oobn int, recvflags int, from Sockaddr, err error)
+}
+
+// MsgWriter is the interface that wraps the Sendmsg method, which writes
+// the buffers together with the ancillary data to the socket
+type MsgWriter interface {
+	Sendmsg(buffers [][]byte, oob []byte, to Addr) (n int, err error)
+}
+
+// MsgConn is the interface that wraps the ReadMsg and WriteMsg methods, which
+// read and write the data together with the control messages in oob like the
+// methods of net.UDPConn and net.UnixConn. ReadMsg returns the flags of the
+// received message and the address of the sender, which is nil if the kernel
+// does not report it over the connected sockets
+type MsgConn interface {
+	ReadMsg(b, oob []byte) (n, oobn, flags int, addr Addr, err error)
+	WriteMsg(b, oob []byte, addr Addr) (n, oobn int, err error)
+}
+
+// SocketConn is the interface of the connections of sox, which exposes the
+// socket and its scatter/gather I/O. TCPConn, UDPConn, UnixConn and SCTPConn
+// implement it, so that a Conn of sox can be asserted to SocketConn
+type SocketConn interface {
+	Conn
+	Socket
+	VectorReader
+	VectorWriter
+	MsgReader
+	MsgWriter
+	MsgConn
+}
+
 func AddrToSockaddr(addr Addr) Sockaddr {
 	switch addr := addr.(type) {
 	case *IPAddr:
@@ -0,0 +1,136 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	notifierStatusClosed = 1 << 30
+)
+
+// Notifier is a counter which wakes up exactly one waiter per notified count
+// It is built on a semaphore mode eventfd, so that the Fd can also be registered
+// with the poller of the event loop. A typical usage is that the event loop
+// Notify once per queued task and each worker goroutine Wait before taking a task
+type Notifier struct {
+	efd PollUintReadWriteCloser
+	// status holds the closed bit and the number of in-flight calls
+	status atomic.Int32
+}
+
+// NewNotifier creates and returns a new Notifier
+func NewNotifier() (*Notifier, error) {
+	efd, err := NewEventfd(func(options *EventfdOptions) {
+		options.Semaphore = true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{efd: efd}, nil
+}
+
+// Fd returns the file descriptor of the eventfd which
+// is readable while the counter is greater than 0
+func (nf *Notifier) Fd() int {
+	return nf.efd.Fd()
+}
+
+// Notify adds n to the counter and wakes up at most n waiters
+// It returns io.ErrClosedPipe if the Notifier has been closed
+func (nf *Notifier) Notify(n uint) error {
+	if nf.status.Add(1)&notifierStatusClosed != 0 {
+		nf.status.Add(-1)
+		return io.ErrClosedPipe
+	}
+	defer nf.status.Add(-1)
+	if n < 1 {
+		return nil
+	}
+
+	return nf.efd.WriteUint(n)
+}
+
+// TryWait decrements the counter by 1 without blocking. It returns
+// ErrTemporarilyUnavailable if the counter is 0
+func (nf *Notifier) TryWait() error {
+	if nf.status.Add(1)&notifierStatusClosed != 0 {
+		nf.status.Add(-1)
+		return io.ErrClosedPipe
+	}
+	defer nf.status.Add(-1)
+	_, err := nf.efd.ReadUint()
+
+	return err
+}
+
+// Wait blocks until the counter is greater than 0 and then decrements it by 1
+// A negative d means Wait blocks without timeout. It returns ErrTemporarilyUnavailable
+// on timeout, and io.ErrClosedPipe if the Notifier has been closed
+func (nf *Notifier) Wait(d time.Duration) error {
+	if nf.status.Add(1)&notifierStatusClosed != 0 {
+		nf.status.Add(-1)
+		return io.ErrClosedPipe
+	}
+	defer nf.status.Add(-1)
+	var deadline time.Time
+	if d >= 0 {
+		deadline = time.Now().Add(d)
+	}
+	for {
+		if nf.status.Load()&notifierStatusClosed != 0 {
+			return io.ErrClosedPipe
+		}
+		_, err := nf.efd.ReadUint()
+		if err != ErrTemporarilyUnavailable {
+			return err
+		}
+		timeout := -1
+		if d >= 0 {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return ErrTemporarilyUnavailable
+			}
+			timeout = int((left + time.Millisecond - 1) / time.Millisecond)
+		}
+		fds := []unix.PollFd{{Fd: int32(nf.efd.Fd()), Events: unix.POLLIN}}
+		_, err = unix.Poll(fds, timeout)
+		if err != nil && err != unix.EINTR {
+			return errFromUnixErrno(err)
+		}
+	}
+}
+
+// Close closes the Notifier. The blocked waiters are woken up
+// and return io.ErrClosedPipe
+func (nf *Notifier) Close() error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		status := nf.status.Load()
+		if status&notifierStatusClosed != 0 {
+			return nil
+		}
+		if nf.status.CompareAndSwap(status, status|notifierStatusClosed) {
+			break
+		}
+		sw.Once()
+	}
+	// wake up all of the waiters blocked in poll
+	_ = nf.efd.WriteUint(math.MaxUint32)
+	sw = NewSpinWait().SetLevel(SpinWaitLevelBlockingIO)
+	for nf.status.Load() != notifierStatusClosed {
+		sw.Once()
+	}
+
+	return nf.efd.Close()
+}
@@ -0,0 +1,109 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"sync"
+)
+
+const (
+	orderedExecutorShards = 64
+)
+
+// OrderedExecutor runs the tasks of each key in order, one at a time, while
+// the tasks of the different keys run in parallel. The event loop keys the
+// MessageHandler invocations by the fd of the connection when Parallel >= 2,
+// since most application protocols require the messages of a connection
+// to be handled in order
+//
+// The tasks of a key are queued in a run queue of the key, which is submitted
+// as a whole to run on a worker goroutine, and is dropped once drained
+type OrderedExecutor struct {
+	submit func(run func())
+	shards [orderedExecutorShards]orderedExecutorShard
+}
+
+type orderedExecutorShard struct {
+	mu     sync.Mutex
+	queues map[int]*orderedRunQueue
+}
+
+type orderedRunQueue struct {
+	key   int
+	tasks []func()
+	head  int
+}
+
+// NewOrderedExecutor creates and returns an OrderedExecutor which submits the
+// run queues to submit, e.g. the enqueue of a worker goroutine. A nil submit
+// runs each run queue on a new goroutine
+func NewOrderedExecutor(submit func(run func())) *OrderedExecutor {
+	if submit == nil {
+		submit = func(run func()) { go run() }
+	}
+	e := &OrderedExecutor{submit: submit}
+	for i := range e.shards {
+		e.shards[i].queues = make(map[int]*orderedRunQueue)
+	}
+
+	return e
+}
+
+// Execute queues fn to run after all of the tasks which have been executed with the key
+// It is safe to be called from any goroutine
+func (e *OrderedExecutor) Execute(key int, fn func()) error {
+	if fn == nil {
+		return ErrInvalidParam
+	}
+	shard := &e.shards[uint(key)%orderedExecutorShards]
+	shard.mu.Lock()
+	q, running := shard.queues[key]
+	if !running {
+		q = &orderedRunQueue{key: key}
+		shard.queues[key] = q
+	}
+	q.tasks = append(q.tasks, fn)
+	shard.mu.Unlock()
+	if !running {
+		e.submit(func() { e.run(shard, q) })
+	}
+
+	return nil
+}
+
+// Pending returns the number of the tasks with the key which have not finished yet
+func (e *OrderedExecutor) Pending(key int) int {
+	shard := &e.shards[uint(key)%orderedExecutorShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if q, ok := shard.queues[key]; ok {
+		return len(q.tasks) - q.head
+	}
+
+	return 0
+}
+
+func (e *OrderedExecutor) run(shard *orderedExecutorShard, q *orderedRunQueue) {
+	for {
+		shard.mu.Lock()
+		fn := q.tasks[q.head]
+		shard.mu.Unlock()
+		fn()
+		shard.mu.Lock()
+		q.tasks[q.head] = nil
+		q.head++
+		if q.head == len(q.tasks) {
+			delete(shard.queues, q.key)
+			shard.mu.Unlock()
+			return
+		}
+		if q.head >= 64 && q.head*2 >= len(q.tasks) {
+			n := copy(q.tasks, q.tasks[q.head:])
+			clear(q.tasks[n:])
+			q.tasks, q.head = q.tasks[:n], 0
+		}
+		shard.mu.Unlock()
+	}
+}
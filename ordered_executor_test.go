@@ -0,0 +1,89 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"hybscloud.com/sox"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedExecutor(t *testing.T) {
+	t.Run("in order per key", func(t *testing.T) {
+		e := sox.NewOrderedExecutor(nil)
+		const keys, n = 16, 1 << 10
+		next, running := make([]int, keys), make([]atomic.Int32, keys)
+		wg := sync.WaitGroup{}
+		wg.Add(keys * n)
+		for i := range n {
+			for key := range keys {
+				err := e.Execute(key, func() {
+					defer wg.Done()
+					if running[key].Add(1) != 1 {
+						t.Errorf("ordered executor ran key %d in parallel", key)
+					}
+					if next[key] != i {
+						t.Errorf("ordered executor expected task %d of key %d but got %d", next[key], key, i)
+					}
+					next[key] = i + 1
+					running[key].Add(-1)
+				})
+				if err != nil {
+					t.Errorf("ordered executor execute: %v", err)
+					return
+				}
+			}
+		}
+		wg.Wait()
+		for key := range keys {
+			if pending := e.Pending(key); pending != 0 {
+				t.Errorf("ordered executor expected no pending tasks of key %d but got %d", key, pending)
+				return
+			}
+		}
+	})
+
+	t.Run("keys in parallel", func(t *testing.T) {
+		e := sox.NewOrderedExecutor(nil)
+		blocked, done := make(chan struct{}), make(chan struct{})
+		_ = e.Execute(1, func() { <-blocked })
+		_ = e.Execute(1, func() {})
+		_ = e.Execute(2, func() { close(done) })
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("ordered executor key 2 blocked by key 1")
+			return
+		}
+		if pending := e.Pending(1); pending != 2 {
+			t.Errorf("ordered executor expected 2 pending tasks of key 1 but got %d", pending)
+			return
+		}
+		close(blocked)
+	})
+
+	t.Run("submit", func(t *testing.T) {
+		runs := make(chan func(), 4)
+		e := sox.NewOrderedExecutor(func(run func()) { runs <- run })
+		ran := 0
+		_ = e.Execute(3, func() { ran++ })
+		_ = e.Execute(3, func() { ran++ })
+		if len(runs) != 1 {
+			t.Errorf("ordered executor expected 1 submitted run queue but got %d", len(runs))
+			return
+		}
+		(<-runs)()
+		if ran != 2 || e.Pending(3) != 0 {
+			t.Errorf("ordered executor expected 2 tasks ran but got %d", ran)
+			return
+		}
+		if err := e.Execute(3, nil); err != sox.ErrInvalidParam {
+			t.Errorf("ordered executor expected ErrInvalidParam but got %v", err)
+			return
+		}
+	})
+}
@@ -0,0 +1,346 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	outboundQueueMaxBatchFrames = 1024
+)
+
+// BackpressurePolicy specifies what an OutboundQueue does when
+// a message is written while its high-water mark is exceeded
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock blocks the writer until the queue is flushed below the high-water mark
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropOldest drops the oldest unwritten messages to make room for the new one
+	BackpressureDropOldest
+	// BackpressureDropNewest drops the new message and returns ErrMsgDropped
+	BackpressureDropNewest
+	// BackpressureClose closes the queue and the underlying writer if it is an io.Closer
+	BackpressureClose
+)
+
+// OutboundQueueOptions holds the options of an OutboundQueue
+type OutboundQueueOptions struct {
+	// ByteOrder sets which byte order will be used when framing messages
+	ByteOrder binary.ByteOrder
+	// Proto sets which protocol type will be used when framing messages
+	// Frames of a protocol which preserves message boundaries are never coalesced
+	Proto UnderlyingProtocol
+	// MaxBatchSize is the maximum number of bytes coalesced into one writev
+	MaxBatchSize int
+	// BufferPool is the pool which the frames are leased from
+	// A nil BufferPool indicates that DefaultBufferPool will be used
+	BufferPool *BufferPool
+	// Notify is called when the queue turns from empty to non-empty. The event
+	// loop uses it to watch the writability of the connection and flush the queue
+	Notify func()
+	// HighWaterMark is the number of queued bytes above which Policy applies
+	// A HighWaterMark of zero indicates that the queue is unbounded
+	HighWaterMark int
+	// LowWaterMark is the number of queued bytes at or below which the queue
+	// becomes writable again. Zero indicates half of HighWaterMark
+	LowWaterMark int
+	// Policy is the BackpressurePolicy applied above HighWaterMark
+	Policy BackpressurePolicy
+	// WritableNotify is called with false when the queued bytes exceed HighWaterMark
+	// and with true when they fall to LowWaterMark, so that applications can throttle
+	WritableNotify func(writable bool)
+}
+
+var defaultOutboundQueueOptions = OutboundQueueOptions{
+	ByteOrder:    binary.BigEndian,
+	Proto:        UnderlyingProtocolStream,
+	MaxBatchSize: BufferSizeLarge,
+	BufferPool:   nil,
+	Notify:       nil,
+
+	HighWaterMark:  0,
+	LowWaterMark:   0,
+	Policy:         BackpressureBlock,
+	WritableNotify: nil,
+}
+
+// OutboundQueue is the outbound message queue of a connection
+// Write frames the message and enqueues it, so that it is safe to be called
+// from any goroutine, e.g. message handlers running on worker goroutines
+// The event loop calls Flush when the connection becomes writable, which
+// coalesces the small frames into a single writev
+type OutboundQueue struct {
+	mu      sync.Mutex
+	w       io.Writer
+	frames  []*BufferLease
+	offset  int
+	pending int
+	closed  bool
+	batch   [][]byte
+
+	order     binary.ByteOrder
+	proto     UnderlyingProtocol
+	batchSize int
+	pool      *BufferPool
+	notify    func()
+
+	highWater      int
+	lowWater       int
+	policy         BackpressurePolicy
+	writableNotify func(writable bool)
+	unwritable     bool
+	flushed        *sync.Cond
+}
+
+// NewOutboundQueue creates and returns a new OutboundQueue which flushes into w
+// If w implements Writev, the coalesced frames are written by Writev
+func NewOutboundQueue(w io.Writer, opts ...func(options *OutboundQueueOptions)) *OutboundQueue {
+	options := defaultOutboundQueueOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.BufferPool == nil {
+		options.BufferPool = DefaultBufferPool
+	}
+	if options.MaxBatchSize < 1 {
+		options.MaxBatchSize = defaultOutboundQueueOptions.MaxBatchSize
+	}
+	if options.HighWaterMark < 0 {
+		options.HighWaterMark = 0
+	}
+	if options.LowWaterMark < 1 || options.LowWaterMark > options.HighWaterMark {
+		options.LowWaterMark = options.HighWaterMark / 2
+	}
+
+	q := &OutboundQueue{
+		w:              w,
+		frames:         make([]*BufferLease, 0, 8),
+		order:          options.ByteOrder,
+		proto:          options.Proto,
+		batchSize:      options.MaxBatchSize,
+		pool:           options.BufferPool,
+		notify:         options.Notify,
+		highWater:      options.HighWaterMark,
+		lowWater:       options.LowWaterMark,
+		policy:         options.Policy,
+		writableNotify: options.WritableNotify,
+	}
+	q.flushed = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// Write frames p as a message and enqueues the frame. It returns io.ErrClosedPipe
+// if the queue has been closed. The frame is written by the following Flush
+func (q *OutboundQueue) Write(p []byte) (n int, err error) {
+	if int64(len(p)) > messagePayloadMaxLength56Bits {
+		return 0, ErrMsgTooLong
+	}
+	var lease *BufferLease
+	if q.proto.PreserveBoundary() {
+		lease = q.pool.Get(len(p))
+		copy(lease.Bytes(), p)
+	} else {
+		lease = q.pool.Get(int(messageHeaderLength+messageExLengthBytes(int64(len(p)))) + len(p))
+		header := [8]byte{}
+		hn := putMessageHeader(header[:], int64(len(p)), q.order)
+		copy(lease.Bytes(), header[:hn])
+		copy(lease.Bytes()[hn:], p)
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		lease.Release()
+		return 0, io.ErrClosedPipe
+	}
+	if q.highWater > 0 && q.pending+lease.Len() > q.highWater && q.pending > 0 {
+		err = q.applyPolicy(lease.Len())
+		if err != nil {
+			q.mu.Unlock()
+			lease.Release()
+			return 0, err
+		}
+	}
+	wasEmpty := len(q.frames) == 0
+	q.frames = append(q.frames, lease)
+	q.pending += lease.Len()
+	changed := q.updateWritable()
+	q.mu.Unlock()
+	if changed {
+		q.writableNotify(false)
+	}
+	if wasEmpty && q.notify != nil {
+		q.notify()
+	}
+
+	return len(p), nil
+}
+
+// applyPolicy makes room for a frame of n bytes with the BackpressurePolicy
+// It must be called with the lock held
+func (q *OutboundQueue) applyPolicy(n int) error {
+	switch q.policy {
+	case BackpressureDropOldest:
+		// the head frame must be kept if it has been partially written
+		head := 0
+		if q.offset > 0 {
+			head = 1
+		}
+		for len(q.frames) > head && q.pending+n > q.highWater {
+			q.pending -= q.frames[head].Len()
+			q.frames[head].Release()
+			q.frames = append(q.frames[:head], q.frames[head+1:]...)
+		}
+		return nil
+	case BackpressureDropNewest:
+		return ErrMsgDropped
+	case BackpressureClose:
+		q.closeLocked()
+		if c, ok := q.w.(io.Closer); ok {
+			_ = c.Close()
+		}
+		return io.ErrClosedPipe
+	default:
+		for !q.closed && q.pending > 0 && q.pending+n > q.highWater {
+			q.flushed.Wait()
+		}
+		if q.closed {
+			return io.ErrClosedPipe
+		}
+		return nil
+	}
+}
+
+// updateWritable updates the writable state with the queued bytes and reports
+// whether the state has changed. It must be called with the lock held
+func (q *OutboundQueue) updateWritable() (changed bool) {
+	if q.highWater < 1 || q.writableNotify == nil {
+		return false
+	}
+	if !q.unwritable && q.pending > q.highWater {
+		q.unwritable = true
+		return true
+	}
+	if q.unwritable && q.pending <= q.lowWater {
+		q.unwritable = false
+		return true
+	}
+	return false
+}
+
+// Writable reports whether the queued bytes are under the high-water mark
+func (q *OutboundQueue) Writable() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.highWater < 1 || q.pending <= q.highWater
+}
+
+// Flush writes the queued frames until the queue is empty or the writer would block
+// It returns ErrTemporarilyUnavailable if there are remaining frames which should be
+// flushed again when the connection becomes writable
+func (q *OutboundQueue) Flush() (n int, err error) {
+	q.mu.Lock()
+	defer func() {
+		changed := q.updateWritable()
+		if n > 0 {
+			q.flushed.Broadcast()
+		}
+		q.mu.Unlock()
+		if changed {
+			q.writableNotify(true)
+		}
+	}()
+	for len(q.frames) > 0 {
+		q.batch = q.batch[:0]
+		size := 0
+		for i, frame := range q.frames {
+			b := frame.Bytes()
+			if i == 0 {
+				b = b[q.offset:]
+			} else if size+len(b) > q.batchSize || len(q.batch) >= outboundQueueMaxBatchFrames {
+				break
+			}
+			q.batch = append(q.batch, b)
+			size += len(b)
+			if q.proto.PreserveBoundary() {
+				break
+			}
+		}
+		wn, werr := q.writeBatch()
+		n += wn
+		q.consume(wn)
+		if werr != nil {
+			return n, werr
+		}
+		if wn < size {
+			return n, ErrTemporarilyUnavailable
+		}
+	}
+
+	return n, nil
+}
+
+// Len returns the number of the bytes waiting to be flushed
+func (q *OutboundQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending
+}
+
+// Close discards the queued frames and closes the queue
+func (q *OutboundQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked()
+
+	return nil
+}
+
+func (q *OutboundQueue) closeLocked() {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, frame := range q.frames {
+		frame.Release()
+	}
+	q.frames, q.offset, q.pending = nil, 0, 0
+	q.flushed.Broadcast()
+}
+
+func (q *OutboundQueue) writeBatch() (n int, err error) {
+	if len(q.batch) == 1 {
+		return q.w.Write(q.batch[0])
+	}
+	if w, ok := q.w.(VectorWriter); ok {
+		return w.Writev(q.batch)
+	}
+	buffers := net.Buffers(q.batch)
+	wn, err := buffers.WriteTo(q.w)
+
+	return int(wn), err
+}
+
+// consume drops the n written bytes from the head of the queue
+func (q *OutboundQueue) consume(n int) {
+	q.pending -= n
+	for n > 0 && len(q.frames) > 0 {
+		left := q.frames[0].Len() - q.offset
+		if n < left {
+			q.offset += n
+			return
+		}
+		n -= left
+		q.frames[0].Release()
+		q.frames[0] = nil
+		q.frames, q.offset = q.frames[1:], 0
+	}
+}
@@ -0,0 +1,255 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// vectorWriter records the writes and accepts at most limit bytes per write
+type vectorWriter struct {
+	bytes.Buffer
+	limit  int
+	writes int
+	writev int
+}
+
+func (w *vectorWriter) Write(p []byte) (n int, err error) {
+	w.writes++
+	if w.limit > 0 && len(p) > w.limit {
+		n, _ = w.Buffer.Write(p[:w.limit])
+		return n, sox.ErrTemporarilyUnavailable
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *vectorWriter) Writev(iovs [][]byte) (n int, err error) {
+	w.writev++
+	for _, iov := range iovs {
+		if w.limit > 0 && n+len(iov) > w.limit {
+			wn, _ := w.Buffer.Write(iov[:w.limit-n])
+			return n + wn, sox.ErrTemporarilyUnavailable
+		}
+		wn, _ := w.Buffer.Write(iov)
+		n += wn
+	}
+	return n, nil
+}
+
+func TestOutboundQueue(t *testing.T) {
+	t.Run("coalesce", func(t *testing.T) {
+		w := &vectorWriter{}
+		notified := 0
+		q := sox.NewOutboundQueue(w, func(options *sox.OutboundQueueOptions) {
+			options.Notify = func() { notified++ }
+		})
+		defer q.Close()
+		for _, s := range []string{"abc", "de", "f"} {
+			_, err := q.Write([]byte(s))
+			if err != nil {
+				t.Errorf("queue write: %v", err)
+				return
+			}
+		}
+		if notified != 1 || q.Len() != 9 {
+			t.Errorf("expected 1 notification and 9 bytes but got %d %d", notified, q.Len())
+			return
+		}
+		n, err := q.Flush()
+		if err != nil || n != 9 {
+			t.Errorf("flush expected 9 bytes but got %d: %v", n, err)
+			return
+		}
+		if w.writev != 1 || w.writes != 0 {
+			t.Errorf("expected a single writev but got %d writev and %d write", w.writev, w.writes)
+			return
+		}
+		if w.String() != "\x03abc\x02de\x01f" || q.Len() != 0 {
+			t.Errorf("unexpected flushed frames %q", w.String())
+			return
+		}
+	})
+
+	t.Run("partial flush", func(t *testing.T) {
+		w := &vectorWriter{limit: 5}
+		q := sox.NewOutboundQueue(w)
+		defer q.Close()
+		_, _ = q.Write([]byte("abc"))
+		_, _ = q.Write([]byte("defgh"))
+		n, err := q.Flush()
+		if err != sox.ErrTemporarilyUnavailable || n != 5 || q.Len() != 5 {
+			t.Errorf("flush expected 5 bytes and ErrTemporarilyUnavailable but got %d %d: %v", n, q.Len(), err)
+			return
+		}
+		w.limit = 0
+		n, err = q.Flush()
+		if err != nil || n != 5 {
+			t.Errorf("flush expected 5 bytes but got %d: %v", n, err)
+			return
+		}
+		if w.String() != "\x03abc\x05defgh" {
+			t.Errorf("unexpected flushed frames %q", w.String())
+			return
+		}
+	})
+
+	t.Run("packet", func(t *testing.T) {
+		w := &vectorWriter{}
+		q := sox.NewOutboundQueue(w, func(options *sox.OutboundQueueOptions) {
+			options.Proto = sox.UnderlyingProtocolDgram
+		})
+		defer q.Close()
+		_, _ = q.Write([]byte("abc"))
+		_, _ = q.Write([]byte("de"))
+		_, err := q.Flush()
+		if err != nil || w.writes != 2 || w.String() != "abcde" {
+			t.Errorf("expected 2 separate packet writes but got %d %q: %v", w.writes, w.String(), err)
+			return
+		}
+	})
+
+	t.Run("concurrent writes", func(t *testing.T) {
+		w := &vectorWriter{}
+		q := sox.NewOutboundQueue(w)
+		wg := sync.WaitGroup{}
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					_, _ = q.Write([]byte("x"))
+				}
+			}()
+		}
+		wg.Wait()
+		n, err := q.Flush()
+		if err != nil || n != 800 {
+			t.Errorf("flush expected 800 bytes but got %d: %v", n, err)
+			return
+		}
+		_ = q.Close()
+		_, err = q.Write([]byte("x"))
+		if err != io.ErrClosedPipe {
+			t.Errorf("write after close expected io.ErrClosedPipe but got %v", err)
+			return
+		}
+	})
+}
+
+func TestOutboundQueue_Backpressure(t *testing.T) {
+	highWater := func(policy sox.BackpressurePolicy, notify func(bool)) func(options *sox.OutboundQueueOptions) {
+		return func(options *sox.OutboundQueueOptions) {
+			options.HighWaterMark = 8
+			options.Policy = policy
+			options.WritableNotify = notify
+		}
+	}
+
+	t.Run("drop newest", func(t *testing.T) {
+		w := &vectorWriter{}
+		states := []bool{}
+		q := sox.NewOutboundQueue(w, highWater(sox.BackpressureDropNewest, func(writable bool) {
+			states = append(states, writable)
+		}))
+		defer q.Close()
+		_, _ = q.Write([]byte("abc"))
+		_, _ = q.Write([]byte("def"))
+		_, err := q.Write([]byte("ghi"))
+		if err != sox.ErrMsgDropped {
+			t.Errorf("write expected ErrMsgDropped but got %v", err)
+			return
+		}
+		_, _ = q.Flush()
+		if w.String() != "\x03abc\x03def" {
+			t.Errorf("unexpected flushed frames %q", w.String())
+			return
+		}
+		if len(states) != 0 || !q.Writable() {
+			t.Errorf("expected no writable notification but got %v", states)
+			return
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		w := &vectorWriter{}
+		q := sox.NewOutboundQueue(w, highWater(sox.BackpressureDropOldest, nil))
+		defer q.Close()
+		_, _ = q.Write([]byte("abc"))
+		_, _ = q.Write([]byte("def"))
+		_, err := q.Write([]byte("ghi"))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		_, _ = q.Flush()
+		if w.String() != "\x03def\x03ghi" {
+			t.Errorf("unexpected flushed frames %q", w.String())
+			return
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		q := sox.NewOutboundQueue(&vectorWriter{}, highWater(sox.BackpressureClose, nil))
+		_, _ = q.Write([]byte("abcdef"))
+		_, err := q.Write([]byte("ghi"))
+		if err != io.ErrClosedPipe {
+			t.Errorf("write expected io.ErrClosedPipe but got %v", err)
+			return
+		}
+		if q.Len() != 0 {
+			t.Errorf("expected the closed queue to be empty")
+			return
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		w := &vectorWriter{}
+		mu := sync.Mutex{}
+		states := []bool{}
+		q := sox.NewOutboundQueue(w, highWater(sox.BackpressureBlock, func(writable bool) {
+			mu.Lock()
+			states = append(states, writable)
+			mu.Unlock()
+		}))
+		defer q.Close()
+		_, _ = q.Write([]byte("abcdefgh"))
+		if q.Writable() {
+			t.Errorf("expected the queue above the high-water mark not to be writable")
+			return
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := q.Write([]byte("ijk"))
+			done <- err
+		}()
+		select {
+		case <-done:
+			t.Errorf("write expected to block above the high-water mark")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		_, _ = q.Flush()
+		if err := <-done; err != nil {
+			t.Errorf("blocked write: %v", err)
+			return
+		}
+		_, _ = q.Flush()
+		if w.String() != "\x08abcdefgh\x03ijk" {
+			t.Errorf("unexpected flushed frames %q", w.String())
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(states) != 2 || states[0] || !states[1] {
+			t.Errorf("expected writable notifications false and true but got %v", states)
+			return
+		}
+	})
+}
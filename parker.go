@@ -0,0 +1,60 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// parker parks goroutines until the generation changes.
+// A waiter must call prepare before checking its condition
+// so that a concurrent unpark can never be lost
+//
+// The parked goroutines wait on the channel of the generation, which unpark
+// closes, so that they are parked by the Go scheduler and hold no OS thread
+type parker struct {
+	mu      sync.Mutex
+	gen     uint32
+	ch      chan struct{}
+	waiters atomic.Int32
+}
+
+func newParker() *parker {
+	return &parker{ch: make(chan struct{})}
+}
+
+func (p *parker) prepare() (gen uint32) {
+	p.waiters.Add(1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gen
+}
+
+func (p *parker) cancel() {
+	p.waiters.Add(-1)
+}
+
+func (p *parker) park(gen uint32) {
+	p.mu.Lock()
+	ch := p.ch
+	changed := p.gen != gen
+	p.mu.Unlock()
+	if !changed {
+		<-ch
+	}
+	p.waiters.Add(-1)
+}
+
+func (p *parker) unpark() {
+	if p.waiters.Load() < 1 {
+		return
+	}
+	p.mu.Lock()
+	p.gen++
+	close(p.ch)
+	p.ch = make(chan struct{})
+	p.mu.Unlock()
+}
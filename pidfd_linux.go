@@ -0,0 +1,111 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"unsafe"
+)
+
+const (
+	_P_PIDFD = 3
+)
+
+// The codes of how a child process exited
+const (
+	CLD_EXITED = 1
+	CLD_KILLED = 2
+	CLD_DUMPED = 3
+)
+
+// PollPidfd is the interface that groups the methods in interface PollReadCloser
+// and the methods to supervise a process through a pidfd
+type PollPidfd interface {
+	PollReadCloser
+	// Pid returns the process ID
+	Pid() int
+	// SendSignal sends the signal sig to the process
+	SendSignal(sig unix.Signal) error
+	// Wait reaps the exited process and returns how it exited. The code is one of
+	// CLD_EXITED, CLD_KILLED and CLD_DUMPED, and the status is the exit status or
+	// the signal number. It returns ErrTemporarilyUnavailable if the process is running
+	Wait() (code int, status int, err error)
+}
+
+type pidfd struct {
+	fd  int
+	pid int
+}
+
+// NewPidfd creates and returns a new nonblocking pidfd referring to the process pid
+// The Fd becomes readable when the process exits. Read returns io.EOF
+// after the process has exited, and ErrTemporarilyUnavailable otherwise
+func NewPidfd(pid int) (PollPidfd, error) {
+	fd, err := unix.PidfdOpen(pid, unix.PIDFD_NONBLOCK)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+
+	return &pidfd{fd: fd, pid: pid}, nil
+}
+
+func (fd *pidfd) Fd() int {
+	return fd.fd
+}
+
+func (fd *pidfd) Pid() int {
+	return fd.pid
+}
+
+func (fd *pidfd) Read(p []byte) (n int, err error) {
+	fds := []unix.PollFd{{Fd: int32(fd.fd), Events: unix.POLLIN}}
+	_, err = unix.Poll(fds, 0)
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+	if fds[0].Revents&unix.POLLIN == 0 {
+		return 0, ErrTemporarilyUnavailable
+	}
+
+	return 0, io.EOF
+}
+
+func (fd *pidfd) SendSignal(sig unix.Signal) error {
+	err := unix.PidfdSendSignal(fd.fd, sig, nil, 0)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+func (fd *pidfd) Wait() (code int, status int, err error) {
+	info := unix.Siginfo{}
+	err = unix.Waitid(_P_PIDFD, fd.fd, &info, unix.WEXITED|unix.WNOHANG, nil)
+	if err != nil {
+		return 0, 0, errFromUnixErrno(err)
+	}
+	if info.Signo == 0 {
+		return 0, 0, ErrTemporarilyUnavailable
+	}
+	// si_status follows si_pid and si_uid in the _sigchld member of siginfo_t
+	// the union of siginfo_t follows three int fields and is aligned to the pointer size
+	ptrSize := unsafe.Sizeof(uintptr(0))
+	off := (3*4+ptrSize-1)&^(ptrSize-1) + 8
+	status = int(*(*int32)(unsafe.Add(unsafe.Pointer(&info), off)))
+
+	return int(info.Code), status, nil
+}
+
+func (fd *pidfd) Close() error {
+	err := unix.Close(fd.fd)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
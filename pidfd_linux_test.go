@@ -0,0 +1,98 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"io"
+	"os/exec"
+	"testing"
+)
+
+func TestPidfd_Supervise(t *testing.T) {
+	wait := func(t *testing.T, fd sox.PollPidfd) (code int, status int) {
+		fds := []unix.PollFd{{Fd: int32(fd.Fd()), Events: unix.POLLIN}}
+		_, err := unix.Poll(fds, 5000)
+		if err != nil {
+			t.Errorf("poll pidfd: %v", err)
+			return
+		}
+		_, err = fd.Read(nil)
+		if err != io.EOF {
+			t.Errorf("pidfd read expected %v but got %v", io.EOF, err)
+			return
+		}
+		code, status, err = fd.Wait()
+		if err != nil {
+			t.Errorf("pidfd wait: %v", err)
+			return
+		}
+		return
+	}
+
+	t.Run("exit", func(t *testing.T) {
+		cmd := exec.Command("sh", "-c", "exit 3")
+		err := cmd.Start()
+		if err != nil {
+			t.Skipf("start child process: %v", err)
+			return
+		}
+		defer cmd.Process.Release()
+		fd, err := sox.NewPidfd(cmd.Process.Pid)
+		if err != nil {
+			t.Errorf("new pidfd: %v", err)
+			return
+		}
+		defer fd.Close()
+		code, status := wait(t, fd)
+		if code != sox.CLD_EXITED || status != 3 {
+			t.Errorf("pidfd wait expected exited with 3 but got %d %d", code, status)
+			return
+		}
+	})
+
+	t.Run("send signal", func(t *testing.T) {
+		cmd := exec.Command("sleep", "10")
+		err := cmd.Start()
+		if err != nil {
+			t.Skipf("start child process: %v", err)
+			return
+		}
+		defer cmd.Process.Release()
+		fd, err := sox.NewPidfd(cmd.Process.Pid)
+		if err != nil {
+			t.Errorf("new pidfd: %v", err)
+			return
+		}
+		defer fd.Close()
+		if fd.Pid() != cmd.Process.Pid {
+			t.Errorf("pidfd expected pid %d but got %d", cmd.Process.Pid, fd.Pid())
+			return
+		}
+		_, err = fd.Read(nil)
+		if err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("pidfd read expected ErrTemporarilyUnavailable but got %v", err)
+			return
+		}
+		_, _, err = fd.Wait()
+		if err != sox.ErrTemporarilyUnavailable {
+			t.Errorf("pidfd wait expected ErrTemporarilyUnavailable but got %v", err)
+			return
+		}
+		err = fd.SendSignal(unix.SIGTERM)
+		if err != nil {
+			t.Errorf("pidfd send signal: %v", err)
+			return
+		}
+		code, status := wait(t, fd)
+		if code != sox.CLD_KILLED || status != int(unix.SIGTERM) {
+			t.Errorf("pidfd wait expected killed by SIGTERM but got %d %d", code, status)
+			return
+		}
+	})
+}
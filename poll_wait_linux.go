@@ -0,0 +1,112 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	pollWaitInterval = 10 * time.Millisecond
+)
+
+// newFdPoller creates a Poller which fd is registered with, so that the
+// synchronous exchanges, e.g. handshakes, on the non-blocking fd can
+// wait for its readiness with pollWait
+func newFdPoller(fd int) (*Poller, error) {
+	p, err := NewPoller(func(options *PollerOptions) {
+		options.MaxEvents = 1
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = p.Add(fd, 0)
+	if err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// pollWait waits for the events of fd until the deadline or the ctx is done
+// A zero deadline means no deadline
+func pollWait(ctx context.Context, p *Poller, fd int, events uint32, deadline time.Time) error {
+	err := p.Modify(fd, events)
+	if err != nil {
+		return err
+	}
+	evts := [1]PollEvent{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		d := pollWaitInterval
+		if !deadline.IsZero() {
+			d = min(d, time.Until(deadline))
+			if d <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+		}
+		n, err := p.Wait(evts[:], d)
+		if err == ErrInterruptedSyscall {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+}
+
+// pollReadFull reads exactly len(b) bytes from the non-blocking so
+func pollReadFull(ctx context.Context, so PollReader, p *Poller, b []byte, deadline time.Time) error {
+	for len(b) > 0 {
+		n, err := so.Read(b)
+		if err == ErrTemporarilyUnavailable || err == ErrInterruptedSyscall {
+			err = pollWait(ctx, p, so.Fd(), PollIn, deadline)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+// pollWriteAll writes all of b to the non-blocking so
+func pollWriteAll(ctx context.Context, so PollWriter, p *Poller, b []byte, deadline time.Time) error {
+	for len(b) > 0 {
+		n, err := so.Write(b)
+		if err == ErrTemporarilyUnavailable || err == ErrInterruptedSyscall {
+			err = pollWait(ctx, p, so.Fd(), PollOut, deadline)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
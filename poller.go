@@ -15,21 +15,59 @@ const (
 )
 
 const (
-	pollerEventIn  = 0x1
-	pollerEventOut = 0x4
-	pollerEventHup = 0x10
+	pollerEventIn    = 0x1
+	pollerEventOut   = 0x4
+	pollerEventErr   = 0x8
+	pollerEventHup   = 0x10
+	pollerEventRdHup = 0x2000
+
+	// pollerEventOneShot disables the fd after an event is reported,
+	// the fd must be re-armed by mod before it is reported again
+	pollerEventOneShot = 1 << 30
+	// pollerEventEdgeTriggered reports an event only when the state
+	// of the fd changes instead of while the fd is ready
+	pollerEventEdgeTriggered = 1 << 31
 )
 
-type pollerEvent struct {
+// BusyPollParams holds the parameters of kernel busy polling, which
+// trades CPU for latency by polling the device queue instead of
+// sleeping until the interrupt comes
+type BusyPollParams struct {
+	// Timeout is the duration of busy polling. Zero disables busy polling
+	Timeout time.Duration
+	// Budget is the maximum number of packets handled per busy poll
+	// Zero indicates the default budget of the kernel
+	Budget int
+	// Prefer lets busy polling take precedence over the softirq processing
+	Prefer bool
+}
+
+// Poll events which can be waited and reported by Poller
+const (
+	PollIn            = pollerEventIn
+	PollOut           = pollerEventOut
+	PollErr           = pollerEventErr
+	PollHup           = pollerEventHup
+	PollRdHup         = pollerEventRdHup
+	PollOneShot       = pollerEventOneShot
+	PollEdgeTriggered = pollerEventEdgeTriggered
+)
+
+// PollEvent is a readiness event reported by Poller
+type PollEvent struct {
+	// Events is the bit set of the ready events
 	Events uint32
-	Fd     int32
-	pad    [4]byte
+	// Fd is the file descriptor which is ready
+	Fd  int32
+	pad [4]byte
 }
 
 type poller interface {
 	add(fd int, events uint32) error
+	mod(fd int, events uint32) error
 	del(fd int) error
-	wait(d time.Duration) (events []pollerEvent, err error)
+	// wait fills events with at most maxEvents ready events and returns the number of them
+	wait(events []PollEvent, d time.Duration) (n int, err error)
 	Close() error
 }
 
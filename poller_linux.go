@@ -0,0 +1,88 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"time"
+)
+
+// PollerOptions holds the options of Poller
+type PollerOptions struct {
+	// MaxEvents is the maximum number of events reported per Wait
+	MaxEvents int
+	// BusyPoll sets the kernel busy polling of the poller
+	// A nil BusyPoll means busy polling will be left as the system default
+	BusyPoll *BusyPollParams
+}
+
+var defaultPollerOptions = PollerOptions{
+	MaxEvents: pollerDefaultEventsNum,
+	BusyPoll:  nil,
+}
+
+// Poller is the readiness notification facility of the operating system,
+// which is epoll on Linux. It lets applications drive their own event loops
+// for the file descriptors created outside this package
+type Poller struct {
+	ep *epoll
+}
+
+// NewPoller creates and returns a new Poller with the given options
+func NewPoller(opts ...func(options *PollerOptions)) (*Poller, error) {
+	options := defaultPollerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	ep, err := newPoller(options.MaxEvents)
+	if err != nil {
+		return nil, err
+	}
+	if options.BusyPoll != nil {
+		err = ep.setBusyPoll(*options.BusyPoll)
+		if err != nil {
+			_ = ep.Close()
+			return nil, err
+		}
+	}
+
+	return &Poller{ep: ep}, nil
+}
+
+// Fd returns the file descriptor of the Poller, which becomes readable
+// when there are any events, so that Pollers can be nested
+func (p *Poller) Fd() int {
+	return p.ep.fd
+}
+
+// Add registers fd with the events. The events are level-triggered unless
+// PollEdgeTriggered is set. PollErr and PollHup are always reported
+func (p *Poller) Add(fd int, events uint32) error {
+	return p.ep.add(fd, events)
+}
+
+// Modify changes the events of the registered fd. It also re-arms
+// the fd which has been disabled by PollOneShot
+func (p *Poller) Modify(fd int, events uint32) error {
+	return p.ep.mod(fd, events)
+}
+
+// Delete unregisters fd from the Poller
+func (p *Poller) Delete(fd int) error {
+	return p.ep.del(fd)
+}
+
+// Wait waits for the events and fills them into events, and returns the number
+// of the ready events. The d parameter works like the one of Interface.Poll
+// The events can be reused across calls, so that Wait does not allocate
+func (p *Poller) Wait(events []PollEvent, d time.Duration) (n int, err error) {
+	return p.ep.wait(events, d)
+}
+
+// Close closes the Poller
+func (p *Poller) Close() error {
+	return p.ep.Close()
+}
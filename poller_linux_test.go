@@ -0,0 +1,83 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"testing"
+	"time"
+)
+
+func TestPoller(t *testing.T) {
+	p, err := sox.NewPoller(func(options *sox.PollerOptions) {
+		options.MaxEvents = 8
+	})
+	if err != nil {
+		t.Errorf("new poller: %v", err)
+		return
+	}
+	defer p.Close()
+	fds := make([]int, 2)
+	err = unix.Pipe2(fds, unix.O_NONBLOCK|unix.O_CLOEXEC)
+	if err != nil {
+		t.Errorf("pipe: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	events := make([]sox.PollEvent, 8)
+	err = p.Add(fds[0], sox.PollIn|sox.PollOneShot)
+	if err != nil {
+		t.Errorf("poller add: %v", err)
+		return
+	}
+	n, err := p.Wait(events, 0)
+	if err != nil || n != 0 {
+		t.Errorf("poller wait expected no events but got n=%d err=%v", n, err)
+		return
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_, _ = unix.Write(fds[1], []byte("x"))
+	}()
+	n, err = p.Wait(events, -1)
+	if err != nil || n != 1 {
+		t.Errorf("poller wait expected 1 event but got n=%d err=%v", n, err)
+		return
+	}
+	if int(events[0].Fd) != fds[0] || events[0].Events&sox.PollIn == 0 {
+		t.Errorf("poller wait expected fd=%d readable but got %+v", fds[0], events[0])
+		return
+	}
+	n, err = p.Wait(events, time.Millisecond)
+	if err != nil || n != 0 {
+		t.Errorf("poller wait expected oneshot disabled but got n=%d err=%v", n, err)
+		return
+	}
+	err = p.Modify(fds[0], sox.PollIn)
+	if err != nil {
+		t.Errorf("poller modify: %v", err)
+		return
+	}
+	n, err = p.Wait(events, 0)
+	if err != nil || n != 1 {
+		t.Errorf("poller wait expected 1 event but got n=%d err=%v", n, err)
+		return
+	}
+	err = p.Delete(fds[0])
+	if err != nil {
+		t.Errorf("poller delete: %v", err)
+		return
+	}
+	n, err = p.Wait(events, 0)
+	if err != nil || n != 0 {
+		t.Errorf("poller wait expected no events but got n=%d err=%v", n, err)
+		return
+	}
+}
@@ -10,9 +10,11 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// PollSignalfd is the interface that groups Fd and ReadSiginfo method
+// PollSignalfd is the interface that groups Fd, ReadSiginfo and ReadSignalEvent method
 type PollSignalfd interface {
 	pollFd
 	// ReadSiginfo reads and returns the came signal info
 	ReadSiginfo() (sig unix.Signal, code int, err error)
+	// ReadSignalEvent reads and returns the came signal info as a SignalEvent
+	ReadSignalEvent() (event SignalEvent, err error)
 }
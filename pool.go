@@ -0,0 +1,226 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrPoolLeak means there are objects which have not been put back when the Pool is closed
+	ErrPoolLeak = errors.New("pool objects leaked")
+)
+
+const (
+	poolLeakStackDepth = 16
+)
+
+// PoolOptions holds optional parameters for Pool
+type PoolOptions[ItemType any] struct {
+	// New creates a new object when the pool is empty. New is required
+	New func() ItemType
+	// Reset resets the object before it is put back into the pool. Reset is optional
+	Reset func(item ItemType)
+	// Capacity specifies the maximum number of idle objects kept by the pool
+	// Objects put back into a full pool are dropped. The Capacity is rounded up
+	// to a power of 2 minus 1. The default Capacity is 0 which means the idle
+	// objects are kept in a sync.Pool and may be freed by the garbage collector
+	Capacity uint32
+	// Debug specifies whether the pool tracks outstanding objects or not
+	// In Debug mode, Close reports the objects which have not been put back
+	// with the stacks where they were gotten, and putting an object which is
+	// not outstanding panics. Objects are tracked individually only if
+	// they are comparable, e.g. not the slices held by a Pool[any], otherwise
+	// only the number of them is tracked
+	// Equal objects, e.g. the values of a non-pointer ItemType, are tracked
+	// by the number of them, each with its own stack
+	Debug bool
+}
+
+// Pool is a generic object pool
+type Pool[ItemType any] struct {
+	*PoolOptions[ItemType]
+	idle        Stack[ItemType]
+	pool        sync.Pool
+	outstanding atomic.Int64
+	closed      atomic.Bool
+
+	mu         sync.Mutex
+	comparable bool
+	tracked    map[any][][]uintptr
+}
+
+// NewPool creates and returns a new Pool with the given options
+func NewPool[ItemType any](opts ...func(options *PoolOptions[ItemType])) (*Pool[ItemType], error) {
+	o := &PoolOptions[ItemType]{
+		New:      nil,
+		Reset:    nil,
+		Capacity: 0,
+		Debug:    false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	if o.New == nil {
+		return nil, errors.New("pool new function required")
+	}
+	p := &Pool[ItemType]{PoolOptions: o}
+	if o.Capacity > 0 {
+		idle, err := NewFixedStack[ItemType](func(options *FixedStackOptions) {
+			options.Capacity = o.Capacity
+			options.Concurrent = true
+			options.Nonblocking = true
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.idle = idle
+	} else {
+		p.pool.New = func() any { return o.New() }
+	}
+	if o.Debug {
+		p.comparable = reflect.TypeOf((*ItemType)(nil)).Elem().Comparable()
+		p.tracked = make(map[any][][]uintptr)
+	}
+
+	return p, nil
+}
+
+// Get gets an object from the pool, or creates a new one if the pool is empty
+func (p *Pool[ItemType]) Get() (item ItemType) {
+	if p.idle != nil {
+		var err error
+		item, err = p.idle.Pop()
+		if err != nil {
+			item = p.New()
+		}
+	} else {
+		item = p.pool.Get().(ItemType)
+	}
+	p.outstanding.Add(1)
+	if p.Debug && p.trackable(item) {
+		pcs := make([]uintptr, poolLeakStackDepth)
+		pcs = pcs[:runtime.Callers(2, pcs)]
+		p.mu.Lock()
+		p.tracked[any(item)] = append(p.tracked[any(item)], pcs)
+		p.mu.Unlock()
+	}
+
+	return item
+}
+
+// Put puts the object back into the pool
+func (p *Pool[ItemType]) Put(item ItemType) {
+	if p.Debug && p.trackable(item) {
+		p.mu.Lock()
+		stacks := p.tracked[any(item)]
+		if len(stacks) > 1 {
+			p.tracked[any(item)] = stacks[:len(stacks)-1]
+		} else {
+			delete(p.tracked, any(item))
+		}
+		p.mu.Unlock()
+		if len(stacks) < 1 {
+			panic("put object which is not outstanding")
+		}
+	}
+	p.outstanding.Add(-1)
+	if p.Reset != nil {
+		p.Reset(item)
+	}
+	if p.closed.Load() {
+		return
+	}
+	if p.idle != nil {
+		_ = p.idle.Push(item)
+		return
+	}
+	p.pool.Put(item)
+}
+
+// trackable reports whether the item can be a key of the tracked objects
+// The dynamic values are checked, since an interface ItemType is comparable
+// while the values it holds may not be
+func (p *Pool[ItemType]) trackable(item ItemType) bool {
+	if !p.comparable {
+		return false
+	}
+	v := reflect.ValueOf(any(item))
+	return !v.IsValid() || v.Comparable()
+}
+
+// Outstanding returns the number of objects which have been gotten and not put back yet
+func (p *Pool[ItemType]) Outstanding() int {
+	return int(p.outstanding.Load())
+}
+
+// Close closes the pool and drops the idle objects. Objects put back after
+// Close are dropped. In Debug mode it returns a *PoolLeakError if there are
+// any outstanding objects
+func (p *Pool[ItemType]) Close() error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if p.idle != nil {
+		_ = p.idle.Close()
+	}
+	if !p.Debug {
+		return nil
+	}
+	n := p.outstanding.Load()
+	if n < 1 {
+		return nil
+	}
+	e := &PoolLeakError{Outstanding: int(n)}
+	p.mu.Lock()
+	for _, stacks := range p.tracked {
+		for _, pcs := range stacks {
+			e.Stacks = append(e.Stacks, poolLeakStack(pcs))
+		}
+	}
+	p.mu.Unlock()
+
+	return e
+}
+
+// PoolLeakError records the objects which have not been put back when the Pool is closed
+type PoolLeakError struct {
+	// Outstanding is the number of objects which have not been put back
+	Outstanding int
+	// Stacks are the stacks where the leaked objects were gotten
+	// Stacks has no stacks of the objects which are not comparable
+	Stacks []string
+}
+
+func (e *PoolLeakError) Error() string {
+	s := fmt.Sprintf("%v: %d outstanding", ErrPoolLeak, e.Outstanding)
+	for _, stack := range e.Stacks {
+		s += "\n" + stack
+	}
+	return s
+}
+
+func (e *PoolLeakError) Unwrap() error {
+	return ErrPoolLeak
+}
+
+func poolLeakStack(pcs []uintptr) string {
+	b := strings.Builder{}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		_, _ = fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,198 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"errors"
+	"hybscloud.com/sox"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type poolTestObject struct {
+	n int
+}
+
+func TestPool_GetPut(t *testing.T) {
+	t.Run("bounded", func(t *testing.T) {
+		created := 0
+		p, err := sox.NewPool[*poolTestObject](func(options *sox.PoolOptions[*poolTestObject]) {
+			options.New = func() *poolTestObject { created++; return &poolTestObject{} }
+			options.Reset = func(item *poolTestObject) { item.n = 0 }
+			options.Capacity = 1
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		o1, o2 := p.Get(), p.Get()
+		if created != 2 || p.Outstanding() != 2 {
+			t.Errorf("pool expected 2 created and outstanding but got %d %d", created, p.Outstanding())
+			return
+		}
+		o1.n, o2.n = 1, 2
+		p.Put(o1)
+		p.Put(o2)
+		o3 := p.Get()
+		if o3 != o1 || o3.n != 0 {
+			t.Errorf("pool expected the reset idle object but got %v", o3)
+			return
+		}
+		_ = p.Get()
+		if created != 3 {
+			t.Errorf("pool expected the object put into full pool dropped but got %d created", created)
+			return
+		}
+		err = p.Close()
+		if err != nil {
+			t.Errorf("pool close: %v", err)
+			return
+		}
+	})
+
+	t.Run("unbounded concurrent", func(t *testing.T) {
+		p, err := sox.NewPool[*poolTestObject](func(options *sox.PoolOptions[*poolTestObject]) {
+			options.New = func() *poolTestObject { return &poolTestObject{} }
+			options.Debug = true
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		wg := sync.WaitGroup{}
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 1000 {
+					p.Put(p.Get())
+				}
+			}()
+		}
+		wg.Wait()
+		if p.Outstanding() != 0 {
+			t.Errorf("pool expected no outstanding objects but got %d", p.Outstanding())
+			return
+		}
+		err = p.Close()
+		if err != nil {
+			t.Errorf("pool close: %v", err)
+			return
+		}
+	})
+
+	t.Run("new required", func(t *testing.T) {
+		_, err := sox.NewPool[int]()
+		if err == nil {
+			t.Errorf("new pool expected error without new function")
+			return
+		}
+	})
+}
+
+func TestPool_Leak(t *testing.T) {
+	t.Run("comparable", func(t *testing.T) {
+		p, err := sox.NewPool[*poolTestObject](func(options *sox.PoolOptions[*poolTestObject]) {
+			options.New = func() *poolTestObject { return &poolTestObject{} }
+			options.Capacity = 8
+			options.Debug = true
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		p.Put(p.Get())
+		_ = p.Get()
+		err = p.Close()
+		if !errors.Is(err, sox.ErrPoolLeak) {
+			t.Errorf("pool close expected %v but got %v", sox.ErrPoolLeak, err)
+			return
+		}
+		leak := &sox.PoolLeakError{}
+		if !errors.As(err, &leak) || leak.Outstanding != 1 || len(leak.Stacks) != 1 {
+			t.Errorf("pool close expected 1 leak with stack but got %v", err)
+			return
+		}
+		if !strings.Contains(leak.Stacks[0], "TestPool_Leak") {
+			t.Errorf("pool leak stack expected the caller but got %s", leak.Stacks[0])
+			return
+		}
+	})
+
+	t.Run("not comparable", func(t *testing.T) {
+		p, err := sox.NewPool[[]byte](func(options *sox.PoolOptions[[]byte]) {
+			options.New = func() []byte { return make([]byte, 8) }
+			options.Debug = true
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		_ = p.Get()
+		_ = p.Get()
+		leak := &sox.PoolLeakError{}
+		err = p.Close()
+		if !errors.As(err, &leak) || leak.Outstanding != 2 || len(leak.Stacks) != 0 {
+			t.Errorf("pool close expected 2 leaks without stack but got %v", err)
+			return
+		}
+	})
+
+	t.Run("equal objects", func(t *testing.T) {
+		p, err := sox.NewPool[int](func(options *sox.PoolOptions[int]) {
+			options.New = func() int { return 0 }
+			options.Debug = true
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		_, _, _ = p.Get(), p.Get(), p.Get()
+		p.Put(0)
+		leak := &sox.PoolLeakError{}
+		err = p.Close()
+		if !errors.As(err, &leak) || leak.Outstanding != 2 || len(leak.Stacks) != 2 {
+			t.Errorf("pool close expected 2 leaks with stacks but got %v", err)
+			return
+		}
+	})
+
+	t.Run("not comparable values", func(t *testing.T) {
+		p, err := sox.NewPool[any](func(options *sox.PoolOptions[any]) {
+			options.New = func() any { return make([]byte, 8) }
+			options.Debug = true
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		p.Put(p.Get())
+		_ = p.Get()
+		leak := &sox.PoolLeakError{}
+		err = p.Close()
+		if !errors.As(err, &leak) || leak.Outstanding != 1 || len(leak.Stacks) != 0 {
+			t.Errorf("pool close expected 1 leak without stack but got %v", err)
+			return
+		}
+	})
+
+	t.Run("put not outstanding", func(t *testing.T) {
+		p, err := sox.NewPool[*poolTestObject](func(options *sox.PoolOptions[*poolTestObject]) {
+			options.New = func() *poolTestObject { return &poolTestObject{} }
+			options.Debug = true
+		})
+		if err != nil {
+			t.Errorf("new pool: %v", err)
+			return
+		}
+		defer func() {
+			if recover() == nil {
+				t.Errorf("pool put expected panic")
+			}
+		}()
+		p.Put(&poolTestObject{})
+	})
+}
@@ -0,0 +1,239 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+const (
+	defaultPriorityQueueCapacity = math.MaxInt16
+)
+
+// NewPriorityQueue creates a bounded priority queue with given options
+// and returns the consumer and the producer of it. Items are consumed
+// in the order specified by the Less option, the least item first
+// The returned consumer and producer also implement ItemContextConsumer,
+// ItemContextProducer and ItemPeeker
+func NewPriorityQueue[ItemType any](
+	opts ...func(options *PriorityQueueOptions[ItemType])) (
+	consumer ItemConsumer[ItemType],
+	producer ItemProducer[ItemType],
+	err error) {
+	o := &PriorityQueueOptions[ItemType]{
+		Capacity:    defaultPriorityQueueCapacity,
+		Less:        nil,
+		Concurrent:  true,
+		Nonblocking: false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	if o.Capacity < 1 || o.Capacity >= (1<<30) {
+		return nil, nil, errors.New("invalid priority queue capacity")
+	}
+	if o.Less == nil {
+		return nil, nil, errors.New("priority queue less function required")
+	}
+	pq := newPriorityQueue[ItemType](o)
+
+	return pq, pq, nil
+}
+
+// PriorityQueueOptions holds optional parameters for PriorityQueue implementations
+type PriorityQueueOptions[ItemType any] struct {
+	// Capacity specifies the capacity of queue. The default Capacity is 32K
+	Capacity int
+	// Less reports whether the item a should be consumed before the item b
+	// Less is required and must describe a strict weak ordering
+	Less func(a, b ItemType) bool
+	// Concurrent specifies whether the queue works in Concurrent mode or not
+	// It should be set as false only if all of the Produce and Consume operations
+	// are done by one goroutine, e.g. by the event loop itself
+	Concurrent bool
+	// Nonblocking specifies whether the Produce or Consume operations will NOT block
+	// even if it is temporarily unavailable or not
+	Nonblocking bool
+	// WaitStrategy specifies how the blocking Produce or Consume operations wait
+	// while the queue is full or empty. The default WaitStrategy is WaitStrategySpin
+	WaitStrategy WaitStrategy
+}
+
+type priorityQueue[T any] struct {
+	*PriorityQueueOptions[T]
+	heap   []T
+	locked atomic.Bool
+	closed bool
+	waits  *ringQueueWaits
+}
+
+func newPriorityQueue[T any](opt *PriorityQueueOptions[T]) *priorityQueue[T] {
+	return &priorityQueue[T]{
+		PriorityQueueOptions: opt,
+		heap:                 make([]T, 0, min(opt.Capacity, 64)),
+		locked:               atomic.Bool{},
+		closed:               false,
+		waits:                &ringQueueWaits{strategy: opt.WaitStrategy, notEmpty: newParker(), notFull: newParker()},
+	}
+}
+
+func (pq *priorityQueue[T]) lock() {
+	if !pq.Concurrent {
+		return
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for !pq.locked.CompareAndSwap(false, true) {
+		sw.Once()
+	}
+}
+
+func (pq *priorityQueue[T]) unlock() {
+	if !pq.Concurrent {
+		return
+	}
+	pq.locked.Store(false)
+}
+
+func (pq *priorityQueue[T]) Produce(item T) error {
+	return pq.produce(item, pq.Nonblocking)
+}
+
+func (pq *priorityQueue[T]) produce(item T, nonblocking bool) error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := pq.waits.notFullWait(sw)
+	defer w.done()
+	for ; ; w.once() {
+		pq.lock()
+		if pq.closed {
+			pq.unlock()
+			return io.ErrClosedPipe
+		}
+		if len(pq.heap) >= pq.Capacity {
+			pq.unlock()
+			if nonblocking {
+				return ErrTemporarilyUnavailable
+			}
+			continue
+		}
+		pq.heap = append(pq.heap, item)
+		pq.up(len(pq.heap) - 1)
+		pq.unlock()
+		break
+	}
+	pq.waits.produced()
+
+	return nil
+}
+
+func (pq *priorityQueue[T]) Consume() (item T, err error) {
+	return pq.consume(pq.Nonblocking)
+}
+
+func (pq *priorityQueue[T]) consume(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := pq.waits.notEmptyWait(sw)
+	defer w.done()
+	for ; ; w.once() {
+		pq.lock()
+		n := len(pq.heap)
+		if n < 1 {
+			closed := pq.closed
+			pq.unlock()
+			if closed {
+				return item, io.EOF
+			}
+			if nonblocking {
+				return item, ErrTemporarilyUnavailable
+			}
+			continue
+		}
+		item = pq.heap[0]
+		pq.heap[0] = pq.heap[n-1]
+		pq.heap[n-1] = *new(T)
+		pq.heap = pq.heap[:n-1]
+		pq.down(0)
+		pq.unlock()
+		break
+	}
+	pq.waits.consumed()
+
+	return item, nil
+}
+
+func (pq *priorityQueue[T]) ProduceContext(ctx context.Context, item T) error {
+	return pq.waits.waitContext(ctx, pq.waits.notFull, func() error { return pq.produce(item, true) })
+}
+
+func (pq *priorityQueue[T]) ConsumeContext(ctx context.Context) (item T, err error) {
+	err = pq.waits.waitContext(ctx, pq.waits.notEmpty, func() (err error) {
+		item, err = pq.consume(true)
+		return
+	})
+	return
+}
+
+func (pq *priorityQueue[T]) Peek() (item T, err error) {
+	pq.lock()
+	defer pq.unlock()
+	if len(pq.heap) < 1 {
+		if pq.closed {
+			return item, io.EOF
+		}
+		return item, ErrTemporarilyUnavailable
+	}
+	return pq.heap[0], nil
+}
+
+func (pq *priorityQueue[T]) Len() int {
+	pq.lock()
+	defer pq.unlock()
+	return len(pq.heap)
+}
+
+func (pq *priorityQueue[T]) Cap() int {
+	return pq.Capacity
+}
+
+func (pq *priorityQueue[T]) Close() error {
+	pq.lock()
+	pq.closed = true
+	pq.unlock()
+	pq.waits.closed()
+
+	return nil
+}
+
+func (pq *priorityQueue[T]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !pq.Less(pq.heap[i], pq.heap[parent]) {
+			break
+		}
+		pq.heap[i], pq.heap[parent] = pq.heap[parent], pq.heap[i]
+		i = parent
+	}
+}
+
+func (pq *priorityQueue[T]) down(i int) {
+	n := len(pq.heap)
+	for {
+		least, left, right := i, 2*i+1, 2*i+2
+		if left < n && pq.Less(pq.heap[left], pq.heap[least]) {
+			least = left
+		}
+		if right < n && pq.Less(pq.heap[right], pq.heap[least]) {
+			least = right
+		}
+		if least == i {
+			break
+		}
+		pq.heap[i], pq.heap[least] = pq.heap[least], pq.heap[i]
+		i = least
+	}
+}
@@ -0,0 +1,140 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"fmt"
+	"hybscloud.com/sox"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestPriorityQueue_Series(t *testing.T) {
+	c, p, err := sox.NewPriorityQueue[int](func(options *sox.PriorityQueueOptions[int]) {
+		options.Capacity = 8
+		options.Less = func(a, b int) bool { return a < b }
+		options.Concurrent = false
+		options.Nonblocking = true
+	})
+	if err != nil {
+		t.Errorf("priority queue new: %v", err)
+		return
+	}
+	for _, item := range []int{5, 3, 7, 1, 8, 2, 6, 4} {
+		err = p.Produce(item)
+		if err != nil {
+			t.Errorf("priority queue produce: %v", err)
+			return
+		}
+	}
+	err = p.Produce(9)
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("priority queue produce expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	pk := c.(sox.ItemPeeker[int])
+	item, err := pk.Peek()
+	if err != nil || item != 1 || pk.Len() != 8 {
+		t.Errorf("priority queue peek expected 1 with len=8 but got %v len=%d %v", item, pk.Len(), err)
+		return
+	}
+	for i := range 8 {
+		item, err = c.Consume()
+		if err != nil {
+			t.Errorf("priority queue consume: %v", err)
+			return
+		}
+		if item != i+1 {
+			t.Errorf("priority queue consume expected %v but got %v", i+1, item)
+			return
+		}
+	}
+	_, err = c.Consume()
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("priority queue consume expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	_ = p.Close()
+	_, err = c.Consume()
+	if err != io.EOF {
+		t.Errorf("priority queue consume expected %v but got %v", io.EOF, err)
+		return
+	}
+	err = p.Produce(1)
+	if err != io.ErrClosedPipe {
+		t.Errorf("priority queue produce expected %v but got %v", io.ErrClosedPipe, err)
+		return
+	}
+}
+
+func TestPriorityQueue_Concurrent(t *testing.T) {
+	for _, strategy := range []sox.WaitStrategy{sox.WaitStrategySpin, sox.WaitStrategyPark} {
+		t.Run(fmt.Sprintf("wait strategy %v", strategy), func(t *testing.T) {
+			c, p, err := sox.NewPriorityQueue[int](func(options *sox.PriorityQueueOptions[int]) {
+				options.Capacity = 16
+				options.Less = func(a, b int) bool { return a > b }
+				options.WaitStrategy = strategy
+			})
+			if err != nil {
+				t.Errorf("priority queue new: %v", err)
+				return
+			}
+			const producers, n = 4, 1000
+			wg := sync.WaitGroup{}
+			for i := range producers {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := range n {
+						_ = p.Produce(i*n + j)
+					}
+				}(i)
+			}
+			go func() {
+				wg.Wait()
+				_ = p.Close()
+			}()
+			seen := make([]bool, producers*n)
+			for {
+				item, err := c.Consume()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Errorf("priority queue consume: %v", err)
+					return
+				}
+				if seen[item] {
+					t.Errorf("priority queue consume duplicated item %v", item)
+					return
+				}
+				seen[item] = true
+			}
+			for i, ok := range seen {
+				if !ok {
+					t.Errorf("priority queue item %v lost", i)
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestPriorityQueue_Options(t *testing.T) {
+	_, _, err := sox.NewPriorityQueue[int]()
+	if err == nil {
+		t.Errorf("priority queue new expected error without less function")
+		return
+	}
+	_, _, err = sox.NewPriorityQueue[int](func(options *sox.PriorityQueueOptions[int]) {
+		options.Capacity = 0
+		options.Less = func(a, b int) bool { return a < b }
+	})
+	if err == nil {
+		t.Errorf("priority queue new expected error with zero capacity")
+		return
+	}
+}
@@ -0,0 +1,38 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// The goroutines started by sox are annotated with the pprof label of
+// ProfileLabelKey, so that the CPU profiles can be attributed to the
+// subsystems, e.g. with go tool pprof -tagfocus=sox=poller
+const (
+	// ProfileLabelKey is the key of the pprof label of the goroutines started by sox
+	ProfileLabelKey = "sox"
+
+	// ProfileLabelPoller is the label of the polling goroutines of the event loop
+	ProfileLabelPoller = "poller"
+	// ProfileLabelWorker is the label of the worker goroutines which run the handlers
+	ProfileLabelWorker = "worker"
+	// ProfileLabelCompletion is the label of the goroutines which reap the io-uring completions
+	ProfileLabelCompletion = "completion"
+	// ProfileLabelReader is the label of the goroutines which read the messages of a connection
+	ProfileLabelReader = "reader"
+	// ProfileLabelWriter is the label of the goroutines which write the messages of a connection
+	ProfileLabelWriter = "writer"
+)
+
+// goLabeled runs fn on a new goroutine annotated with the subsystem label and
+// the additional label pairs of key and value, e.g. Options.ProfileLabels
+func goLabeled(subsystem string, fn func(), labels ...string) {
+	set := pprof.Labels(append([]string{ProfileLabelKey, subsystem}, labels...)...)
+	go pprof.Do(context.Background(), set, func(context.Context) {
+		fn()
+	})
+}
@@ -0,0 +1,43 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileLabels(t *testing.T) {
+	b, err := sox.NewBroker(func(options *sox.BrokerOptions) {
+		options.Workers = 2
+	})
+	if err != nil {
+		t.Errorf("new broker: %v", err)
+		return
+	}
+	defer b.Close()
+	label := `"` + sox.ProfileLabelKey + `":"` + sox.ProfileLabelWorker + `"`
+	// the labels are set when the goroutines have started
+	for i := 0; ; i++ {
+		buf := bytes.Buffer{}
+		err = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+		if err != nil {
+			t.Errorf("goroutine profile: %v", err)
+			return
+		}
+		if strings.Contains(buf.String(), label) {
+			break
+		}
+		if i >= 100 {
+			t.Errorf("expected the worker goroutines to be labeled with %s", label)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,269 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	socks5Version          = 5
+	socks5AuthVersion      = 1
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5CmdConnect       = 1
+	socks5AddrIPv4         = 1
+	socks5AddrDomain       = 3
+	socks5AddrIPv6         = 4
+
+	httpConnectMaxHeaderLength = 4096
+)
+
+var socks5Replies = [...]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// DialThroughProxy dials the target address, e.g. "example.com:443", through the
+// proxy of proxyURL and returns the tunneled connection. The schemes "socks5",
+// "socks5h" and "http" are supported, and the credentials of proxyURL are used
+// for the authentication. The host names of the target are resolved locally with
+// DefaultResolver for "socks5", and by the proxy for "socks5h" and "http"
+// The returned connection is the non-blocking TCPConn to
+// the proxy, so that it can be registered with pollers like other connections
+func DialThroughProxy(proxyURL *url.URL, target string) (*TCPConn, error) {
+	return DialThroughProxyContext(context.Background(), proxyURL, target)
+}
+
+// DialThroughProxyContext works like DialThroughProxy and
+// gives up the handshake when the ctx is done
+func DialThroughProxyContext(ctx context.Context, proxyURL *url.URL, target string) (*TCPConn, error) {
+	defaultPort := ""
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		defaultPort = "1080"
+	case "http":
+		defaultPort = "80"
+	default:
+		return nil, UnknownNetworkError(proxyURL.Scheme)
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := lookupPort(ctx, port)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "socks5" {
+		ip, err := lookupIP(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+	proxyPort := proxyURL.Port()
+	if proxyPort == "" {
+		proxyPort = defaultPort
+	}
+	proxyPortNum, err := lookupPort(ctx, proxyPort)
+	if err != nil {
+		return nil, err
+	}
+	proxyIP, err := lookupIP(ctx, proxyURL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	proxyAddr := &TCPAddr{IP: proxyIP, Port: proxyPortNum}
+	var conn *TCPConn
+	if proxyAddr.IP.To4() != nil {
+		conn, err = DialTCP4(&TCPAddr{IP: IPV4zero}, proxyAddr)
+	} else {
+		conn, err = DialTCP6(&TCPAddr{IP: IPV6unspecified}, proxyAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := newFdPoller(conn.Fd())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	defer p.Close()
+	deadline, _ := ctx.Deadline()
+	err = pollWait(ctx, p, conn.Fd(), PollOut, deadline)
+	if err == nil {
+		if proxyURL.Scheme == "http" {
+			err = httpConnect(ctx, conn, p, proxyURL.User, host, portNum, deadline)
+		} else {
+			err = socks5Connect(ctx, conn, p, proxyURL.User, host, portNum, deadline)
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, &OpError{Op: "proxyconnect", Net: "tcp", Addr: proxyAddr, Err: err}
+	}
+
+	return conn, nil
+}
+
+// socks5Connect performs the handshake of RFC 1928 and RFC 1929. The host
+// names which are not IP addresses are resolved by the proxy
+func socks5Connect(ctx context.Context, conn *TCPConn, p *Poller, user *url.Userinfo, host string, port int, deadline time.Time) error {
+	b := []byte{socks5Version, 1, socks5AuthNone}
+	if user != nil {
+		b = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	err := pollWriteAll(ctx, conn, p, b, deadline)
+	if err != nil {
+		return err
+	}
+	reply := [2]byte{}
+	err = pollReadFull(ctx, conn, p, reply[:], deadline)
+	if err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errors.New("unexpected SOCKS version " + strconv.Itoa(int(reply[0])))
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("SOCKS authentication required")
+		}
+		username := user.Username()
+		password, _ := user.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return ErrInvalidParam
+		}
+		b = append([]byte{socks5AuthVersion, byte(len(username))}, username...)
+		b = append(append(b, byte(len(password))), password...)
+		err = pollWriteAll(ctx, conn, p, b, deadline)
+		if err != nil {
+			return err
+		}
+		err = pollReadFull(ctx, conn, p, reply[:], deadline)
+		if err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS authentication failed")
+		}
+	case socks5AuthNoAcceptable:
+		return errors.New("no acceptable SOCKS authentication methods")
+	default:
+		return errors.New("unexpected SOCKS authentication method " + strconv.Itoa(int(reply[1])))
+	}
+
+	b = []byte{socks5Version, socks5CmdConnect, 0}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.Unmap().Is4() {
+		b = append(b, socks5AddrIPv4)
+		b = append(b, ip.Unmap().AsSlice()...)
+	} else if err == nil {
+		b = append(b, socks5AddrIPv6)
+		b = append(b, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return ErrInvalidParam
+		}
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	err = pollWriteAll(ctx, conn, p, b, deadline)
+	if err != nil {
+		return err
+	}
+	header := [4]byte{}
+	err = pollReadFull(ctx, conn, p, header[:], deadline)
+	if err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if int(header[1]) < len(socks5Replies) && socks5Replies[header[1]] != "" {
+			return errors.New(socks5Replies[header[1]])
+		}
+		return errors.New("unknown SOCKS error " + strconv.Itoa(int(header[1])))
+	}
+	// the bound address is read and discarded
+	n := 0
+	switch header[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		l := [1]byte{}
+		err = pollReadFull(ctx, conn, p, l[:], deadline)
+		if err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return errors.New("unknown SOCKS address type " + strconv.Itoa(int(header[3])))
+	}
+
+	return pollReadFull(ctx, conn, p, make([]byte, n+2), deadline)
+}
+
+// httpConnect establishes the tunnel with the CONNECT method of HTTP/1.1
+func httpConnect(ctx context.Context, conn *TCPConn, p *Poller, user *url.Userinfo, host string, port int, deadline time.Time) error {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	b := []byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n")
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		b = append(b, "Proxy-Authorization: Basic "+credentials+"\r\n"...)
+	}
+	b = append(b, "\r\n"...)
+	err := pollWriteAll(ctx, conn, p, b, deadline)
+	if err != nil {
+		return err
+	}
+
+	// the response is read byte by byte, so that the data
+	// following the response is left in the connection
+	resp := make([]byte, 0, 128)
+	for !bytes.HasSuffix(resp, []byte("\r\n\r\n")) {
+		if len(resp) >= httpConnectMaxHeaderLength {
+			return errors.New("HTTP CONNECT response too long")
+		}
+		c := [1]byte{}
+		err = pollReadFull(ctx, conn, p, c[:], deadline)
+		if err != nil {
+			return err
+		}
+		resp = append(resp, c[0])
+	}
+	status, _, _ := bytes.Cut(resp, []byte("\r\n"))
+	fields := bytes.Fields(status)
+	if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte("HTTP/1.")) {
+		return errors.New("malformed HTTP CONNECT response")
+	}
+	if len(fields[1]) != 3 || fields[1][0] != '2' {
+		return errors.New("HTTP CONNECT failed: " + string(bytes.Join(fields[1:], []byte(" "))))
+	}
+
+	return nil
+}
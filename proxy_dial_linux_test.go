@@ -0,0 +1,249 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// serveTestProxy accepts one connection, runs handshake, and then echoes the data
+func serveTestProxy(t *testing.T, handshake func(conn net.Conn) bool) *url.URL {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if handshake(conn) {
+			_, _ = io.Copy(conn, conn)
+		}
+	}()
+
+	return &url.URL{Host: l.Addr().String()}
+}
+
+// ctxResolver records the hosts it is asked for and resolves them
+// to the loopback address, or fails with the error of a done ctx
+type ctxResolver struct {
+	hosts []string
+}
+
+func (r *ctxResolver) LookupIPAddr(ctx context.Context, host string) ([]sox.IPAddr, error) {
+	r.hosts = append(r.hosts, host)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []sox.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+}
+
+func useResolver(t *testing.T, r sox.Resolver) {
+	saved := sox.DefaultResolver
+	sox.DefaultResolver = r
+	t.Cleanup(func() { sox.DefaultResolver = saved })
+}
+
+func echoThroughProxy(t *testing.T, conn *sox.TCPConn) {
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	buf := make([]byte, 4)
+	deadline := time.Now().Add(time.Second)
+	for n := 0; n < len(buf); {
+		rn, err := conn.Read(buf[n:])
+		if err == sox.ErrTemporarilyUnavailable && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		n += rn
+	}
+	if string(buf) != "ping" {
+		t.Errorf("read expected ping but got %q", buf)
+		return
+	}
+}
+
+func TestDialThroughProxy(t *testing.T) {
+	t.Run("socks5h", func(t *testing.T) {
+		proxyURL := serveTestProxy(t, func(conn net.Conn) bool {
+			b := make([]byte, 4)
+			if _, err := io.ReadFull(conn, b); err != nil || !bytes.Equal(b, []byte{5, 2, 0, 2}) {
+				t.Errorf("socks5 greeting: %v %v", b, err)
+				return false
+			}
+			_, _ = conn.Write([]byte{5, 2})
+			b = make([]byte, 1+1+4+1+6)
+			if _, err := io.ReadFull(conn, b); err != nil || string(b[2:6]) != "user" || string(b[7:]) != "secret" {
+				t.Errorf("socks5 auth: %q %v", b, err)
+				return false
+			}
+			_, _ = conn.Write([]byte{1, 0})
+			b = make([]byte, 4+1+len("example.com")+2)
+			if _, err := io.ReadFull(conn, b); err != nil || b[3] != 3 || string(b[5:5+b[4]]) != "example.com" || b[len(b)-1] != 80 {
+				t.Errorf("socks5 connect: %q %v", b, err)
+				return false
+			}
+			_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 80})
+			return true
+		})
+		proxyURL.Scheme, proxyURL.User = "socks5h", url.UserPassword("user", "secret")
+		conn, err := sox.DialThroughProxy(proxyURL, "example.com:80")
+		if err != nil {
+			t.Errorf("dial through proxy: %v", err)
+			return
+		}
+		defer conn.Close()
+		echoThroughProxy(t, conn)
+	})
+
+	t.Run("socks5", func(t *testing.T) {
+		proxyURL := serveTestProxy(t, func(conn net.Conn) bool {
+			b := make([]byte, 3)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Errorf("socks5 greeting: %v", err)
+				return false
+			}
+			_, _ = conn.Write([]byte{5, 0})
+			b = make([]byte, 4)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Errorf("socks5 connect: %v", err)
+				return false
+			}
+			// the host name has been resolved locally
+			var ip net.IP
+			switch b[3] {
+			case 1:
+				ip = make(net.IP, net.IPv4len)
+			case 4:
+				ip = make(net.IP, net.IPv6len)
+			default:
+				t.Errorf("socks5 connect expected an IP address but got type %d", b[3])
+				return false
+			}
+			if _, err := io.ReadFull(conn, ip); err != nil || !ip.IsLoopback() {
+				t.Errorf("socks5 connect expected a loopback address but got %v: %v", ip, err)
+				return false
+			}
+			_, _ = io.ReadFull(conn, b[:2])
+			_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 80})
+			return true
+		})
+		proxyURL.Scheme = "socks5"
+		conn, err := sox.DialThroughProxy(proxyURL, "localhost:80")
+		if err != nil {
+			t.Errorf("dial through proxy: %v", err)
+			return
+		}
+		defer conn.Close()
+		echoThroughProxy(t, conn)
+	})
+
+	t.Run("socks5 refused", func(t *testing.T) {
+		proxyURL := serveTestProxy(t, func(conn net.Conn) bool {
+			b := make([]byte, 3)
+			_, _ = io.ReadFull(conn, b)
+			_, _ = conn.Write([]byte{5, 0})
+			b = make([]byte, 4+4+2)
+			_, _ = io.ReadFull(conn, b)
+			_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return false
+		})
+		proxyURL.Scheme = "socks5"
+		_, err := sox.DialThroughProxy(proxyURL, "192.0.2.1:80")
+		if err == nil {
+			t.Errorf("dial through proxy expected error but got nil")
+			return
+		}
+	})
+
+	t.Run("http connect", func(t *testing.T) {
+		proxyURL := serveTestProxy(t, func(conn net.Conn) bool {
+			req, err := http.ReadRequest(bufio.NewReaderSize(conn, 16))
+			if err != nil || req.Method != http.MethodConnect || req.Host != "example.com:443" {
+				t.Errorf("http connect request: %v %v", req, err)
+				return false
+			}
+			_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+			return true
+		})
+		proxyURL.Scheme = "http"
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		conn, err := sox.DialThroughProxyContext(ctx, proxyURL, "example.com:443")
+		if err != nil {
+			t.Errorf("dial through proxy: %v", err)
+			return
+		}
+		defer conn.Close()
+		echoThroughProxy(t, conn)
+	})
+
+	t.Run("http forbidden", func(t *testing.T) {
+		proxyURL := serveTestProxy(t, func(conn net.Conn) bool {
+			_, _ = http.ReadRequest(bufio.NewReader(conn))
+			_, _ = conn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\n"))
+			return false
+		})
+		proxyURL.Scheme = "http"
+		_, err := sox.DialThroughProxy(proxyURL, "example.com:443")
+		if err == nil {
+			t.Errorf("dial through proxy expected error but got nil")
+			return
+		}
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		r := &ctxResolver{}
+		useResolver(t, r)
+		_, err := sox.DialThroughProxy(&url.URL{Scheme: "ftp", Host: "proxy.example:21"}, "example.com:443")
+		if _, ok := err.(sox.UnknownNetworkError); !ok {
+			t.Errorf("dial through proxy expected unknown network error but got %v", err)
+			return
+		}
+		if len(r.hosts) > 0 {
+			t.Errorf("dial through proxy expected no lookup but looked up %v", r.hosts)
+			return
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		r := &ctxResolver{}
+		useResolver(t, r)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := sox.DialThroughProxyContext(ctx, &url.URL{Scheme: "socks5h", Host: "proxy.example:1080"}, "example.com:443")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("dial through proxy expected context canceled but got %v", err)
+			return
+		}
+		if len(r.hosts) != 1 || r.hosts[0] != "proxy.example" {
+			t.Errorf("dial through proxy expected lookup of proxy.example but looked up %v", r.hosts)
+			return
+		}
+	})
+}
@@ -0,0 +1,437 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	proxyV1MaxLength      = 107
+	proxyV2HeaderLength   = 16
+	proxyV2AddrLength6    = 36
+	proxyV2AddrLengthUnix = 216
+	proxyV2UnixPathLength = 108
+
+	proxyV2CmdLocal = 0x20
+	proxyV2CmdProxy = 0x21
+
+	proxyV2FamUnspec     = 0x00
+	proxyV2FamTCP4       = 0x11
+	proxyV2FamUDP4       = 0x12
+	proxyV2FamTCP6       = 0x21
+	proxyV2FamUDP6       = 0x22
+	proxyV2FamUnixStream = 0x31
+	proxyV2FamUnixDgram  = 0x32
+)
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ErrInvalidProxyHeader is returned when a PROXY protocol header is malformed
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+// ProxyHeader is the header of the HAProxy PROXY protocol, which conveys
+// the addresses of the original connection through L4 load balancers
+type ProxyHeader struct {
+	// Version is the version of the protocol, 1 for the text format
+	// and 2 for the binary format
+	Version int
+	// Local indicates the connection was made by the proxy itself,
+	// e.g. for health checks, so that the addresses are not available
+	Local bool
+	// SourceAddr is the address of the original client
+	SourceAddr Addr
+	// DestinationAddr is the address the original client connected to
+	DestinationAddr Addr
+}
+
+// WriteTo writes the header to w in the format of Version
+// A header without addresses is written as UNKNOWN or LOCAL
+func (h *ProxyHeader) WriteTo(w io.Writer) (n int64, err error) {
+	var b []byte
+	switch h.Version {
+	case 1:
+		b, err = h.appendV1(nil)
+	case 2:
+		b, err = h.appendV2(nil)
+	default:
+		return 0, ErrInvalidParam
+	}
+	if err != nil {
+		return 0, err
+	}
+	wn, err := w.Write(b)
+
+	return int64(wn), err
+}
+
+func (h *ProxyHeader) appendV1(b []byte) ([]byte, error) {
+	if h.Local || h.SourceAddr == nil || h.DestinationAddr == nil {
+		return append(b, "PROXY UNKNOWN\r\n"...), nil
+	}
+	src, ok0 := h.SourceAddr.(*TCPAddr)
+	dst, ok1 := h.DestinationAddr.(*TCPAddr)
+	if !ok0 || !ok1 {
+		return nil, ErrInvalidParam
+	}
+	fam := "TCP4"
+	if src.IP.To4() == nil || dst.IP.To4() == nil {
+		fam = "TCP6"
+	}
+	b = append(b, proxyV1Signature...)
+	b = append(b, fam...)
+	b = append(b, ' ')
+	b = append(b, src.IP.String()...)
+	b = append(b, ' ')
+	b = append(b, dst.IP.String()...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(src.Port), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(dst.Port), 10)
+
+	return append(b, "\r\n"...), nil
+}
+
+func (h *ProxyHeader) appendV2(b []byte) ([]byte, error) {
+	b = append(b, proxyV2Signature...)
+	if h.Local || h.SourceAddr == nil || h.DestinationAddr == nil {
+		return append(b, proxyV2CmdLocal, proxyV2FamUnspec, 0, 0), nil
+	}
+	var fam byte
+	var addrs []byte
+	switch src := h.SourceAddr.(type) {
+	case *TCPAddr:
+		dst, ok := h.DestinationAddr.(*TCPAddr)
+		if !ok {
+			return nil, ErrInvalidParam
+		}
+		fam, addrs = proxyV2FamTCP4, proxyV2AppendInet(nil, src.AddrPort(), dst.AddrPort())
+	case *UDPAddr:
+		dst, ok := h.DestinationAddr.(*UDPAddr)
+		if !ok {
+			return nil, ErrInvalidParam
+		}
+		fam, addrs = proxyV2FamUDP4, proxyV2AppendInet(nil, src.AddrPort(), dst.AddrPort())
+	case *net.UnixAddr:
+		dst, ok := h.DestinationAddr.(*net.UnixAddr)
+		if !ok || len(src.Name) > proxyV2UnixPathLength || len(dst.Name) > proxyV2UnixPathLength {
+			return nil, ErrInvalidParam
+		}
+		fam = proxyV2FamUnixStream
+		if src.Net == "unixgram" {
+			fam = proxyV2FamUnixDgram
+		}
+		addrs = make([]byte, proxyV2AddrLengthUnix)
+		copy(addrs, src.Name)
+		copy(addrs[proxyV2UnixPathLength:], dst.Name)
+	default:
+		return nil, ErrInvalidParam
+	}
+	if len(addrs) == proxyV2AddrLength6 {
+		fam += 0x10
+	}
+	b = append(b, proxyV2CmdProxy, fam)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+
+	return append(b, addrs...), nil
+}
+
+func proxyV2AppendInet(b []byte, src, dst netip.AddrPort) []byte {
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if !srcIP.Is4() || !dstIP.Is4() {
+		srcIP, dstIP = netip.AddrFrom16(src.Addr().As16()), netip.AddrFrom16(dst.Addr().As16())
+	}
+	b = append(b, srcIP.AsSlice()...)
+	b = append(b, dstIP.AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+
+	return binary.BigEndian.AppendUint16(b, dst.Port())
+}
+
+// ParseProxyHeader parses the PROXY protocol header at the start of b
+// It returns the length of the header, or zero if b is too short to
+// determine the header. ErrInvalidProxyHeader is returned if b does
+// not start with a valid header
+func ParseProxyHeader(b []byte) (h *ProxyHeader, n int, err error) {
+	if len(b) < len(proxyV1Signature) {
+		if bytes.HasPrefix(proxyV1Signature, b) || bytes.HasPrefix(proxyV2Signature, b) {
+			return nil, 0, nil
+		}
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	if bytes.HasPrefix(b, proxyV1Signature) {
+		return parseProxyHeaderV1(b)
+	}
+	if len(b) < len(proxyV2Signature) && bytes.HasPrefix(proxyV2Signature, b) {
+		return nil, 0, nil
+	}
+	if bytes.HasPrefix(b, proxyV2Signature) {
+		return parseProxyHeaderV2(b)
+	}
+
+	return nil, 0, ErrInvalidProxyHeader
+}
+
+func parseProxyHeaderV1(b []byte) (h *ProxyHeader, n int, err error) {
+	end := bytes.Index(b[:min(len(b), proxyV1MaxLength)], []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= proxyV1MaxLength {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		return nil, 0, nil
+	}
+	fields := strings.Split(string(b[len(proxyV1Signature):end]), " ")
+	if fields[0] == "UNKNOWN" {
+		return &ProxyHeader{Version: 1, Local: true}, end + 2, nil
+	}
+	if len(fields) != 5 || fields[0] != "TCP4" && fields[0] != "TCP6" {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	src, err0 := netip.ParseAddr(fields[1])
+	dst, err1 := netip.ParseAddr(fields[2])
+	srcPort, err2 := strconv.ParseUint(fields[3], 10, 16)
+	dstPort, err3 := strconv.ParseUint(fields[4], 10, 16)
+	if err0 != nil || err1 != nil || err2 != nil || err3 != nil || src.Is4() != (fields[0] == "TCP4") || dst.Is4() != src.Is4() {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	return &ProxyHeader{
+		Version:         1,
+		SourceAddr:      TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(srcPort))),
+		DestinationAddr: TCPAddrFromAddrPort(netip.AddrPortFrom(dst, uint16(dstPort))),
+	}, end + 2, nil
+}
+
+func parseProxyHeaderV2(b []byte) (h *ProxyHeader, n int, err error) {
+	if len(b) < proxyV2HeaderLength {
+		return nil, 0, nil
+	}
+	cmd, fam := b[12], b[13]
+	n = proxyV2HeaderLength + int(binary.BigEndian.Uint16(b[14:]))
+	// the high 4 bits of cmd are the version which must be 2
+	if cmd != proxyV2CmdLocal && cmd != proxyV2CmdProxy {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	if len(b) < n {
+		return nil, 0, nil
+	}
+	h = &ProxyHeader{Version: 2, Local: cmd == proxyV2CmdLocal}
+	if h.Local {
+		return h, n, nil
+	}
+	// the type-length-values following the addresses are skipped
+	addrs := b[proxyV2HeaderLength:n]
+	switch fam {
+	case proxyV2FamTCP4, proxyV2FamUDP4, proxyV2FamTCP6, proxyV2FamUDP6:
+		ipLen := net.IPv4len
+		if fam == proxyV2FamTCP6 || fam == proxyV2FamUDP6 {
+			ipLen = net.IPv6len
+		}
+		if len(addrs) < 2*ipLen+4 {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		srcIP, _ := netip.AddrFromSlice(addrs[:ipLen])
+		dstIP, _ := netip.AddrFromSlice(addrs[ipLen : 2*ipLen])
+		src := netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(addrs[2*ipLen:]))
+		dst := netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(addrs[2*ipLen+2:]))
+		if fam == proxyV2FamTCP4 || fam == proxyV2FamTCP6 {
+			h.SourceAddr, h.DestinationAddr = TCPAddrFromAddrPort(src), TCPAddrFromAddrPort(dst)
+		} else {
+			h.SourceAddr, h.DestinationAddr = UDPAddrFromAddrPort(src), UDPAddrFromAddrPort(dst)
+		}
+	case proxyV2FamUnixStream, proxyV2FamUnixDgram:
+		if len(addrs) < proxyV2AddrLengthUnix {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		network := "unix"
+		if fam == proxyV2FamUnixDgram {
+			network = "unixgram"
+		}
+		src, _, _ := bytes.Cut(addrs[:proxyV2UnixPathLength], []byte{0})
+		dst, _, _ := bytes.Cut(addrs[proxyV2UnixPathLength:proxyV2AddrLengthUnix], []byte{0})
+		h.SourceAddr = &net.UnixAddr{Name: string(src), Net: network}
+		h.DestinationAddr = &net.UnixAddr{Name: string(dst), Net: network}
+	case proxyV2FamUnspec:
+		h.Local = true
+	default:
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	return h, n, nil
+}
+
+// ProxyListenerOptions holds the options of NewProxyListener
+type ProxyListenerOptions struct {
+	// Optional lets the connections without PROXY protocol header be accepted
+	// as they are. It must only be set if the clients are trusted, since the
+	// clients can forge their addresses otherwise
+	Optional bool
+}
+
+var defaultProxyListenerOptions = ProxyListenerOptions{
+	Optional: false,
+}
+
+type proxyListener struct {
+	Listener
+	optional bool
+}
+
+// NewProxyListener returns a Listener whose accepted connections parse the
+// PROXY protocol header v1 or v2 before the data. The RemoteAddr and the
+// LocalAddr of the connections report the addresses of the original connection
+func NewProxyListener(l Listener, opts ...func(options *ProxyListenerOptions)) Listener {
+	options := defaultProxyListenerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &proxyListener{Listener: l, optional: options.Optional}
+}
+
+func (l *proxyListener) Accept() (Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProxyConn{Conn: conn, optional: l.optional}, nil
+}
+
+// ProxyConn is a connection accepted by the Listener of NewProxyListener
+// The header is parsed by the first reads, which return ErrTemporarilyUnavailable
+// like the underlying non-blocking connection until the header is complete
+type ProxyConn struct {
+	Conn
+	mu       sync.Mutex
+	optional bool
+	parsed   bool
+	buf      []byte
+	header   *ProxyHeader
+	rest     []byte
+	err      error
+}
+
+// Fd returns the file descriptor of the underlying connection
+func (c *ProxyConn) Fd() int {
+	return GetFd(c.Conn)
+}
+
+// Header reads the PROXY protocol header if it has not been read yet and returns it
+// A nil header with a nil error means the connection has no header
+func (c *ProxyConn) Header() (*ProxyHeader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.readHeader()
+
+	return c.header, err
+}
+
+func (c *ProxyConn) Read(b []byte) (n int, err error) {
+	c.mu.Lock()
+	err = c.readHeader()
+	if err != nil {
+		c.mu.Unlock()
+		return 0, err
+	}
+	if len(c.rest) > 0 {
+		n = copy(b, c.rest)
+		c.rest = c.rest[n:]
+		c.mu.Unlock()
+		return n, nil
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the source address of the header, or the address of the peer
+// if the header has not been read yet or does not convey the addresses
+func (c *ProxyConn) RemoteAddr() Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.header != nil && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the header, or the local address
+// if the header has not been read yet or does not convey the addresses
+func (c *ProxyConn) LocalAddr() Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.header != nil && c.header.DestinationAddr != nil {
+		return c.header.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads and parses the header. It must be called with the lock held
+func (c *ProxyConn) readHeader() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.parsed {
+		return nil
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, 0, proxyV2HeaderLength+proxyV2AddrLengthUnix)
+	}
+	for {
+		h, n, err := ParseProxyHeader(c.buf)
+		if err == ErrInvalidProxyHeader && c.optional {
+			c.parsed, c.rest, c.buf = true, c.buf, nil
+			return nil
+		}
+		if err != nil {
+			c.err = err
+			return err
+		}
+		if h != nil {
+			c.parsed, c.header, c.rest, c.buf = true, h, c.buf[n:], nil
+			return nil
+		}
+		if len(c.buf) == cap(c.buf) {
+			c.buf = append(c.buf, 0)[:len(c.buf)]
+		}
+		rn, err := c.Conn.Read(c.buf[len(c.buf):cap(c.buf)])
+		c.buf = c.buf[:len(c.buf)+rn]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != ErrTemporarilyUnavailable && err != ErrInterruptedSyscall {
+				c.err = err
+			}
+			return err
+		}
+		if rn == 0 {
+			c.err = io.ErrUnexpectedEOF
+			return c.err
+		}
+	}
+}
+
+// WriteProxyHeader writes the PROXY protocol header of the given version to conn,
+// which conveys the local and remote addresses of src. It lets a dialed connection
+// to the upstream server carry the addresses of the accepted connection src
+func WriteProxyHeader(conn Conn, version int, src Conn) error {
+	h := &ProxyHeader{Version: version, SourceAddr: src.RemoteAddr(), DestinationAddr: src.LocalAddr()}
+	_, err := h.WriteTo(conn)
+
+	return err
+}
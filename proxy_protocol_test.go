@@ -0,0 +1,176 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+type pipeListener struct {
+	conns chan net.Conn
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, io.EOF
+	}
+	return conn, nil
+}
+func (l *pipeListener) Close() error   { return nil }
+func (l *pipeListener) Addr() net.Addr { return nil }
+
+func TestProxyHeader(t *testing.T) {
+	src := sox.TCPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:51234"))
+	dst := sox.TCPAddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:443"))
+	src6 := sox.UDPAddrFromAddrPort(netip.MustParseAddrPort("[2001:db8::1]:51234"))
+	dst6 := sox.UDPAddrFromAddrPort(netip.MustParseAddrPort("[2001:db8::2]:443"))
+	unixSrc, unixDst := &net.UnixAddr{Name: "/tmp/src.sock", Net: "unix"}, &net.UnixAddr{Name: "/tmp/dst.sock", Net: "unix"}
+	cases := []sox.ProxyHeader{
+		{Version: 1, SourceAddr: src, DestinationAddr: dst},
+		{Version: 1, Local: true},
+		{Version: 2, SourceAddr: src, DestinationAddr: dst},
+		{Version: 2, SourceAddr: src6, DestinationAddr: dst6},
+		{Version: 2, SourceAddr: unixSrc, DestinationAddr: unixDst},
+		{Version: 2, Local: true},
+	}
+	for _, c := range cases {
+		buf := bytes.Buffer{}
+		_, err := c.WriteTo(&buf)
+		if err != nil {
+			t.Errorf("write header %+v: %v", c, err)
+			return
+		}
+		buf.WriteString("payload")
+		b := buf.Bytes()
+		for i := 0; i < buf.Len()-len("payload"); i++ {
+			h, n, err := sox.ParseProxyHeader(b[:i])
+			if h != nil || n != 0 || err != nil {
+				t.Errorf("parse partial header expected incomplete but got %+v %d %v", h, n, err)
+				return
+			}
+		}
+		h, n, err := sox.ParseProxyHeader(b)
+		if err != nil {
+			t.Errorf("parse header %+v: %v", c, err)
+			return
+		}
+		if string(b[n:]) != "payload" || h.Version != c.Version || h.Local != c.Local {
+			t.Errorf("parse header expected %+v but got %+v", c, h)
+			return
+		}
+		if !c.Local && (h.SourceAddr.String() != c.SourceAddr.String() || h.DestinationAddr.String() != c.DestinationAddr.String()) {
+			t.Errorf("parse header expected %v %v but got %v %v", c.SourceAddr, c.DestinationAddr, h.SourceAddr, h.DestinationAddr)
+			return
+		}
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, b := range []string{"GET / HTTP/1.1\r\n", "PROXY TCP4 1.2.3.4\r\n", "PROXY TCP4 ::1 ::2 1 2\r\n", "\r\n\r\n\x00\r\nQUIT\n\x31\x11\x00\x00"} {
+			_, _, err := sox.ParseProxyHeader([]byte(b))
+			if err != sox.ErrInvalidProxyHeader {
+				t.Errorf("parse %q expected %v but got %v", b, sox.ErrInvalidProxyHeader, err)
+				return
+			}
+		}
+	})
+}
+
+func TestProxyListener(t *testing.T) {
+	src := sox.TCPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:51234"))
+	dst := sox.TCPAddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:443"))
+
+	t.Run("required", func(t *testing.T) {
+		pl := &pipeListener{conns: make(chan net.Conn, 1)}
+		client, server := net.Pipe()
+		defer client.Close()
+		pl.conns <- server
+		l := sox.NewProxyListener(pl)
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		go func() {
+			h := &sox.ProxyHeader{Version: 2, SourceAddr: src, DestinationAddr: dst}
+			_, _ = h.WriteTo(client)
+			_, _ = client.Write([]byte("hello"))
+		}()
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		if string(buf) != "hello" {
+			t.Errorf("read expected hello but got %q", buf)
+			return
+		}
+		if conn.RemoteAddr().String() != src.String() || conn.LocalAddr().String() != dst.String() {
+			t.Errorf("addresses expected %v %v but got %v %v", src, dst, conn.RemoteAddr(), conn.LocalAddr())
+			return
+		}
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		pl := &pipeListener{conns: make(chan net.Conn, 1)}
+		client, server := net.Pipe()
+		defer client.Close()
+		pl.conns <- server
+		conn, err := sox.NewProxyListener(pl).Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		go func() {
+			_, _ = client.Write([]byte("GET / HTTP/1.1\r\n"))
+		}()
+		_, err = conn.Read(make([]byte, 16))
+		if err != sox.ErrInvalidProxyHeader {
+			t.Errorf("read expected %v but got %v", sox.ErrInvalidProxyHeader, err)
+			return
+		}
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		pl := &pipeListener{conns: make(chan net.Conn, 1)}
+		client, server := net.Pipe()
+		defer client.Close()
+		pl.conns <- server
+		conn, err := sox.NewProxyListener(pl, func(options *sox.ProxyListenerOptions) {
+			options.Optional = true
+		}).Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		go func() {
+			_, _ = client.Write([]byte("GET / HTTP/1.1\r\n"))
+		}()
+		buf := make([]byte, 16)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		if string(buf) != "GET / HTTP/1.1\r\n" {
+			t.Errorf("read expected the data as it is but got %q", buf)
+			return
+		}
+		h, err := conn.(*sox.ProxyConn).Header()
+		if h != nil || err != nil {
+			t.Errorf("header expected none but got %+v %v", h, err)
+			return
+		}
+	})
+}
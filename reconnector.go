@@ -0,0 +1,170 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DialFunc dials and returns a new connection
+type DialFunc func(ctx context.Context) (Conn, error)
+
+// RetryOptions holds the options of the exponential backoff of DialWithRetry
+type RetryOptions struct {
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of the delay between retries
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the delay grows after each retry
+	Multiplier float64
+	// Jitter randomizes each delay by up to the fraction of it, so that
+	// the clients disconnected at the same time do not retry in lockstep
+	Jitter float64
+	// MaxAttempts is the maximum number of dials. Zero means no limit
+	MaxAttempts int
+}
+
+var defaultRetryOptions = RetryOptions{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	MaxAttempts:    0,
+}
+
+// DialWithRetry calls dial until it succeeds, the ctx is done, or MaxAttempts
+// is reached, waiting with exponential backoff and jitter between attempts
+// It returns the error of ctx if the ctx is done, otherwise the last dial error
+func DialWithRetry(ctx context.Context, dial DialFunc, opts ...func(options *RetryOptions)) (Conn, error) {
+	options := defaultRetryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Multiplier < 1 {
+		options.Multiplier = 1
+	}
+	options.Jitter = min(max(options.Jitter, 0), 1)
+
+	var timer *time.Timer
+	backoff := options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := dial(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		if options.MaxAttempts > 0 && attempt >= options.MaxAttempts {
+			return nil, err
+		}
+		d := backoff
+		if options.Jitter > 0 {
+			d = time.Duration(float64(d) * (1 + options.Jitter*(2*rand.Float64()-1)))
+		}
+		if timer == nil {
+			timer = time.NewTimer(d)
+			defer timer.Stop()
+		} else {
+			timer.Reset(d)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(time.Duration(float64(backoff)*options.Multiplier), options.MaxBackoff)
+	}
+}
+
+// Reconnector keeps a connection to a remote peer established. It dials with
+// DialWithRetry and calls the ConnectedHandler on every (re)establishment,
+// which is where the connection is registered with the event loop
+type Reconnector struct {
+	mu      sync.Mutex
+	dial    DialFunc
+	handler ConnectedHandler
+	opts    []func(options *RetryOptions)
+	conn    Conn
+	closed  bool
+}
+
+// NewReconnector creates and returns a new Reconnector which dials with dial
+// A nil handler means no callback will be called on establishment
+func NewReconnector(dial DialFunc, handler ConnectedHandler, opts ...func(options *RetryOptions)) *Reconnector {
+	return &Reconnector{dial: dial, handler: handler, opts: opts}
+}
+
+// Conn returns the current connection, or nil if there is none
+func (r *Reconnector) Conn() Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn
+}
+
+// Connect returns the current connection, or establishes a new one if there is none
+func (r *Reconnector) Connect(ctx context.Context) (Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, io.ErrClosedPipe
+	}
+	if r.conn != nil {
+		return r.conn, nil
+	}
+
+	return r.connectLocked(ctx)
+}
+
+// Reconnect closes the broken connection and establishes a new one
+// The broken connection which has already been replaced is ignored, so
+// that concurrent callers detecting the same failure only reconnect once
+func (r *Reconnector) Reconnect(ctx context.Context, broken Conn) (Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, io.ErrClosedPipe
+	}
+	if r.conn != nil && r.conn != broken {
+		return r.conn, nil
+	}
+	if r.conn != nil {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+
+	return r.connectLocked(ctx)
+}
+
+// Close closes the current connection and stops reconnecting
+func (r *Reconnector) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+
+	return err
+}
+
+func (r *Reconnector) connectLocked(ctx context.Context) (Conn, error) {
+	conn, err := DialWithRetry(ctx, r.dial, r.opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.conn = conn
+	if r.handler != nil {
+		r.handler.ServeConnected(conn)
+	}
+
+	return conn, nil
+}
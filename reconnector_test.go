@@ -0,0 +1,137 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type connectedCounter struct {
+	conns []sox.Conn
+}
+
+func (c *connectedCounter) ServeConnected(conn sox.Conn) {
+	c.conns = append(c.conns, conn)
+}
+
+func TestDialWithRetry(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	fastRetry := func(options *sox.RetryOptions) {
+		options.InitialBackoff = time.Millisecond
+		options.MaxBackoff = 4 * time.Millisecond
+	}
+
+	t.Run("retry until success", func(t *testing.T) {
+		attempts := 0
+		conn, err := sox.DialWithRetry(context.Background(), func(ctx context.Context) (sox.Conn, error) {
+			attempts++
+			if attempts < 4 {
+				return nil, errRefused
+			}
+			c, _ := net.Pipe()
+			return c, nil
+		}, fastRetry)
+		if err != nil {
+			t.Errorf("dial with retry: %v", err)
+			return
+		}
+		defer conn.Close()
+		if attempts != 4 {
+			t.Errorf("dial with retry expected 4 attempts but got %d", attempts)
+			return
+		}
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		attempts := 0
+		_, err := sox.DialWithRetry(context.Background(), func(ctx context.Context) (sox.Conn, error) {
+			attempts++
+			return nil, errRefused
+		}, fastRetry, func(options *sox.RetryOptions) {
+			options.MaxAttempts = 3
+		})
+		if err != errRefused || attempts != 3 {
+			t.Errorf("dial with retry expected %v after 3 attempts but got %v after %d", errRefused, err, attempts)
+			return
+		}
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := sox.DialWithRetry(ctx, func(ctx context.Context) (sox.Conn, error) {
+			return nil, errRefused
+		}, fastRetry)
+		if err != context.DeadlineExceeded {
+			t.Errorf("dial with retry expected %v but got %v", context.DeadlineExceeded, err)
+			return
+		}
+	})
+}
+
+func TestReconnector(t *testing.T) {
+	attempts := 0
+	dial := func(ctx context.Context) (sox.Conn, error) {
+		attempts++
+		if attempts%2 == 1 {
+			return nil, errors.New("network unreachable")
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	handler := &connectedCounter{}
+	r := sox.NewReconnector(dial, handler, func(options *sox.RetryOptions) {
+		options.InitialBackoff = time.Millisecond
+	})
+
+	conn0, err := r.Connect(context.Background())
+	if err != nil {
+		t.Errorf("connect: %v", err)
+		return
+	}
+	same, err := r.Connect(context.Background())
+	if err != nil || same != conn0 {
+		t.Errorf("connect expected the current connection but got %v %v", same, err)
+		return
+	}
+	conn1, err := r.Reconnect(context.Background(), conn0)
+	if err != nil {
+		t.Errorf("reconnect: %v", err)
+		return
+	}
+	if conn1 == conn0 || r.Conn() != conn1 {
+		t.Errorf("reconnect expected a new connection")
+		return
+	}
+	if _, err := conn0.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("broken connection expected closed but got %v", err)
+		return
+	}
+	stale, err := r.Reconnect(context.Background(), conn0)
+	if err != nil || stale != conn1 {
+		t.Errorf("reconnect with replaced connection expected the current one but got %v %v", stale, err)
+		return
+	}
+	if len(handler.conns) != 2 || handler.conns[0] != conn0 || handler.conns[1] != conn1 {
+		t.Errorf("connected handler expected 2 calls but got %d", len(handler.conns))
+		return
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Errorf("close: %v", err)
+		return
+	}
+	if _, err := r.Connect(context.Background()); err != io.ErrClosedPipe {
+		t.Errorf("connect after close expected %v but got %v", io.ErrClosedPipe, err)
+		return
+	}
+}
@@ -0,0 +1,97 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var (
+	// ErrHandlerPanic is wrapped by the HandlerPanicError of a recovered panic
+	ErrHandlerPanic = errors.New("handler panicked")
+)
+
+// HandlerPanicError records a panic of a user handler which has been recovered
+type HandlerPanicError struct {
+	// Value is the value which the handler panicked with
+	Value any
+	// Stack is the stack of the goroutine where the handler panicked
+	Stack []byte
+}
+
+func (e *HandlerPanicError) Error() string {
+	return fmt.Sprintf("%v: %v\n%s", ErrHandlerPanic, e.Value, e.Stack)
+}
+
+// Unwrap returns ErrHandlerPanic, and the panic value if it is an error
+func (e *HandlerPanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{ErrHandlerPanic, err}
+	}
+	return []error{ErrHandlerPanic}
+}
+
+// RecoverMessageHandler returns a MessageHandler which calls handler and
+// passes the panic of it to errs instead of crashing the goroutine
+// The ctx passed to handler carries errs, so that handler can also
+// surface its errors with ReportError. The event loop wraps the
+// handlers with Options.ErrorHandler in this way
+func RecoverMessageHandler(handler MessageHandler, errs ErrorHandler) MessageHandler {
+	return &recoverMessageHandler{handler: handler, errs: errs}
+}
+
+// RecoverAcceptedHandler returns an AcceptedHandler which calls handler
+// and passes the panic of it to errs instead of crashing the goroutine
+func RecoverAcceptedHandler(handler AcceptedHandler, errs ErrorHandler) AcceptedHandler {
+	return &recoverAcceptedHandler{handler: handler, errs: errs}
+}
+
+// ReportError passes err to the ErrorHandler carried by ctx
+// It returns false if err is nil or there is no ErrorHandler
+func ReportError(ctx context.Context, err error) bool {
+	errs := ContextUserdata[ErrorHandler](ctx)
+	if err == nil || errs == nil {
+		return false
+	}
+	errs.ServeError(ctx, err)
+
+	return true
+}
+
+type recoverMessageHandler struct {
+	handler MessageHandler
+	errs    ErrorHandler
+}
+
+func (h *recoverMessageHandler) ServeMessage(ctx context.Context, reply PollWriter, request PollReader) {
+	if h.errs != nil {
+		ctx = ContextWithUserdata[ErrorHandler](ctx, h.errs)
+	}
+	defer recoverHandler(ctx, h.errs)
+	h.handler.ServeMessage(ctx, reply, request)
+}
+
+type recoverAcceptedHandler struct {
+	handler AcceptedHandler
+	errs    ErrorHandler
+}
+
+func (h *recoverAcceptedHandler) ServeAccepted(conn Conn, listener Listener) {
+	defer recoverHandler(context.Background(), h.errs)
+	h.handler.ServeAccepted(conn, listener)
+}
+
+func recoverHandler(ctx context.Context, errs ErrorHandler) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if errs != nil {
+		errs.ServeError(ctx, &HandlerPanicError{Value: v, Stack: debug.Stack()})
+	}
+}
@@ -0,0 +1,75 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testErrorHandler struct {
+	errs []error
+}
+
+func (h *testErrorHandler) ServeError(ctx context.Context, err error) {
+	h.errs = append(h.errs, err)
+}
+
+type testPanicHandler struct {
+	value any
+}
+
+func (h *testPanicHandler) ServeMessage(ctx context.Context, reply sox.PollWriter, request sox.PollReader) {
+	if h.value == nil {
+		sox.ReportError(ctx, io.ErrUnexpectedEOF)
+		return
+	}
+	panic(h.value)
+}
+
+func (h *testPanicHandler) ServeAccepted(conn sox.Conn, listener sox.Listener) {
+	panic(h.value)
+}
+
+func TestRecoverMessageHandler(t *testing.T) {
+	errs := &testErrorHandler{}
+	sox.RecoverMessageHandler(&testPanicHandler{value: "boom"}, errs).ServeMessage(context.Background(), nil, nil)
+	sox.RecoverMessageHandler(&testPanicHandler{value: io.ErrShortWrite}, errs).ServeMessage(context.Background(), nil, nil)
+	sox.RecoverMessageHandler(&testPanicHandler{}, errs).ServeMessage(context.Background(), nil, nil)
+	sox.RecoverAcceptedHandler(&testPanicHandler{value: 1}, errs).ServeAccepted(nil, nil)
+	if len(errs.errs) != 4 {
+		t.Errorf("error handler expected 4 errors but got %v", errs.errs)
+		return
+	}
+	e := &sox.HandlerPanicError{}
+	if !errors.As(errs.errs[0], &e) || e.Value != "boom" || !strings.Contains(string(e.Stack), "testPanicHandler") {
+		t.Errorf("error handler expected the panic with its stack but got %v", errs.errs[0])
+		return
+	}
+	if !errors.Is(errs.errs[1], sox.ErrHandlerPanic) || !errors.Is(errs.errs[1], io.ErrShortWrite) {
+		t.Errorf("error handler expected the panic of io.ErrShortWrite but got %v", errs.errs[1])
+		return
+	}
+	if errs.errs[2] != io.ErrUnexpectedEOF {
+		t.Errorf("error handler expected the reported io.ErrUnexpectedEOF but got %v", errs.errs[2])
+		return
+	}
+	if !errors.Is(errs.errs[3], sox.ErrHandlerPanic) {
+		t.Errorf("error handler expected the panic of the accepted handler but got %v", errs.errs[3])
+		return
+	}
+
+	t.Run("nil error handler", func(t *testing.T) {
+		sox.RecoverMessageHandler(&testPanicHandler{value: "boom"}, nil).ServeMessage(context.Background(), nil, nil)
+		if sox.ReportError(context.Background(), io.EOF) {
+			t.Errorf("report error expected false without an error handler")
+			return
+		}
+	})
+}
@@ -0,0 +1,575 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrRetransmitLimit will be returned when a packet has not been
+// acknowledged after the maximum number of retransmissions
+var ErrRetransmitLimit = errors.New("retransmission limit exceeded")
+
+//
+// Each packet of reliable connections is a datagram as follows:
+//
+// data: | type (1) | seq (8) | payload ... |
+// fin:  | type (1) | seq (8) |
+// ack:  | type (1) | next expected seq (8) | selective ack bitmap (8) |
+// nack: | type (1) | missing seq (8) |
+//
+// Bit i of the selective ack bitmap means that the packet of the
+// sequence number next expected seq + 1 + i has been received
+//
+
+const (
+	reliablePacketData = 1
+	reliablePacketFin  = 2
+	reliablePacketAck  = 3
+	reliablePacketNack = 4
+
+	reliableHeaderLength = 1 + 8
+	reliableAckLength    = 1 + 8 + 8
+	reliableBitmapBits   = 64
+	reliableRTOGranular  = time.Millisecond
+)
+
+// ReliableConnOptions represents the options of reliable connections
+type ReliableConnOptions struct {
+	// Window is the maximum number of unacknowledged packets in flight
+	Window int
+	// MaxPayload is the maximum payload size of each message
+	MaxPayload int
+	// Unordered delivers messages as soon as they arrive instead of in order
+	Unordered bool
+	// InitialRTO is the retransmission timeout before the first round-trip sample
+	InitialRTO time.Duration
+	// MinRTO is the lower bound of the retransmission timeout
+	MinRTO time.Duration
+	// MaxRTO is the upper bound of the retransmission timeout
+	MaxRTO time.Duration
+	// MaxRetransmits is the number of retransmissions of a packet
+	// after which the connection fails with ErrRetransmitLimit
+	MaxRetransmits int
+	// Linger is how long Close waits for the packets in flight to be acknowledged
+	Linger time.Duration
+}
+
+var defaultReliableConnOptions = ReliableConnOptions{
+	Window:         256,
+	MaxPayload:     1200,
+	Unordered:      false,
+	InitialRTO:     time.Second,
+	MinRTO:         200 * time.Millisecond,
+	MaxRTO:         60 * time.Second,
+	MaxRetransmits: 10,
+	Linger:         time.Second,
+}
+
+type reliablePacket struct {
+	b           []byte
+	sentAt      time.Time
+	deadline    time.Time
+	retransmits int
+	// nackedAt is when the packet was retransmitted on a nack last time, which
+	// is not counted in retransmits since the nacks prove the peer is alive
+	nackedAt time.Time
+}
+
+// ReliableConn is a reliability layer over a connected datagram Conn, e.g. a
+// UDPConn created with DialUDP4. Messages are numbered, acknowledged and
+// retransmitted with the timeout estimated from the round-trip time as RFC
+// 6298 does. Each Write sends a message and each Read returns a message, so
+// that message readers and writers with UnderlyingProtocolDgram work over
+// lossy links. A background goroutine receives packets and retransmits them
+type ReliableConn struct {
+	conn Conn
+	fd   int
+	p    *Poller
+	opt  ReliableConnOptions
+	drop func(b []byte) bool
+
+	mu         sync.Mutex
+	nextSeq    uint64
+	unacked    map[uint64]*reliablePacket
+	srtt       time.Duration
+	rttvar     time.Duration
+	rto        time.Duration
+	expected   uint64
+	received   map[uint64][]byte
+	nacked     uint64
+	ready      [][]byte
+	finSeq     uint64
+	recvClosed bool
+	err        error
+
+	window   chan struct{}
+	recv     chan []byte
+	pending  []byte
+	done     chan struct{}
+	failed   chan struct{}
+	loopDone chan struct{}
+	once     sync.Once
+
+	readDeadline  pipeDeadline
+	writeDeadline pipeDeadline
+}
+
+// NewReliableConn starts the reliability layer over the connected datagram conn
+// The conn is owned by the returned ReliableConn and closed by its Close
+func NewReliableConn(conn Conn, opts ...func(options *ReliableConnOptions)) (*ReliableConn, error) {
+	opt := defaultReliableConnOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.Window < 1 || opt.MaxPayload < 1 || opt.MinRTO <= 0 || opt.MaxRTO < opt.MinRTO {
+		return nil, ErrInvalidParam
+	}
+	fd := GetFd(conn)
+	if fd < 0 {
+		return nil, ErrInvalidParam
+	}
+	p, err := newFdPoller(fd)
+	if err != nil {
+		return nil, err
+	}
+	err = p.Modify(fd, PollIn)
+	if err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	c := &ReliableConn{
+		conn:          conn,
+		fd:            fd,
+		p:             p,
+		opt:           opt,
+		nextSeq:       1,
+		unacked:       make(map[uint64]*reliablePacket),
+		rto:           min(max(opt.InitialRTO, opt.MinRTO), opt.MaxRTO),
+		expected:      1,
+		received:      make(map[uint64][]byte),
+		window:        make(chan struct{}, opt.Window),
+		recv:          make(chan []byte, opt.Window),
+		done:          make(chan struct{}),
+		failed:        make(chan struct{}),
+		loopDone:      make(chan struct{}),
+		readDeadline:  makePipeDeadline(),
+		writeDeadline: makePipeDeadline(),
+	}
+	goLabeled(ProfileLabelReader, c.loop)
+
+	return c, nil
+}
+
+// Read reads the next message into p. If p is too short, Read returns
+// io.ErrShortBuffer and the message can be read with a larger buffer
+// Read returns io.EOF after the peer has closed and all messages have been read
+func (c *ReliableConn) Read(p []byte) (n int, err error) {
+	if c.pending == nil {
+		select {
+		case b, ok := <-c.recv:
+			if !ok {
+				return 0, io.EOF
+			}
+			c.pending = b
+		case <-c.failed:
+			return 0, c.error()
+		case <-c.done:
+			return 0, io.ErrClosedPipe
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	if len(c.pending) > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	n = copy(p, c.pending)
+	c.pending = nil
+	return n, nil
+}
+
+// Write sends p as a message. Write blocks while the window is full
+func (c *ReliableConn) Write(p []byte) (n int, err error) {
+	if len(p) > c.opt.MaxPayload {
+		return 0, ErrMsgTooLong
+	}
+	err = c.send(reliablePacketData, p, c.writeDeadline.wait())
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *ReliableConn) send(typ byte, p []byte, deadline <-chan struct{}) error {
+	select {
+	case c.window <- struct{}{}:
+	case <-c.failed:
+		return c.error()
+	case <-c.done:
+		return io.ErrClosedPipe
+	case <-deadline:
+		return os.ErrDeadlineExceeded
+	}
+	b := make([]byte, reliableHeaderLength+len(p))
+	b[0] = typ
+	copy(b[reliableHeaderLength:], p)
+	now := time.Now()
+	c.mu.Lock()
+	binary.BigEndian.PutUint64(b[1:], c.nextSeq)
+	c.unacked[c.nextSeq] = &reliablePacket{b: b, sentAt: now, deadline: now.Add(c.rto)}
+	c.nextSeq++
+	c.mu.Unlock()
+	// lost packets are retransmitted by the loop
+	c.writePacket(b)
+	return nil
+}
+
+func (c *ReliableConn) writePacket(b []byte) {
+	if c.drop != nil && c.drop(b) {
+		return
+	}
+	_, _ = c.conn.Write(b)
+}
+
+// Close sends the end of the stream to the peer, waits for the packets in
+// flight to be acknowledged up to Linger, and closes the underlying conn
+func (c *ReliableConn) Close() error {
+	err := io.ErrClosedPipe
+	c.once.Do(func() {
+		linger := time.After(c.opt.Linger)
+		expired := make(chan struct{})
+		go func() {
+			<-linger
+			close(expired)
+		}()
+		if c.send(reliablePacketFin, nil, expired) == nil {
+			for {
+				c.mu.Lock()
+				n := len(c.unacked)
+				c.mu.Unlock()
+				if n == 0 {
+					break
+				}
+				select {
+				case <-expired:
+				case <-c.failed:
+				case <-time.After(pollWaitInterval):
+					continue
+				}
+				break
+			}
+		}
+		close(c.done)
+		<-c.loopDone
+		_ = c.p.Close()
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *ReliableConn) error() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *ReliableConn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+		close(c.failed)
+	}
+}
+
+// LocalAddr returns the local address of the underlying conn
+func (c *ReliableConn) LocalAddr() Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying conn
+func (c *ReliableConn) RemoteAddr() Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *ReliableConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline of Read
+func (c *ReliableConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Write, which blocks while the window is full
+func (c *ReliableConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// Fd returns the file descriptor of the underlying conn
+func (c *ReliableConn) Fd() int {
+	return c.fd
+}
+
+// Protocol returns UnderlyingProtocolDgram since the message boundaries are preserved
+func (c *ReliableConn) Protocol() UnderlyingProtocol {
+	return UnderlyingProtocolDgram
+}
+
+func (c *ReliableConn) loop() {
+	defer close(c.loopDone)
+	buf := make([]byte, reliableHeaderLength+c.opt.MaxPayload+1)
+	evts := [1]PollEvent{}
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		for {
+			n, err := c.conn.Read(buf)
+			if err == ErrTemporarilyUnavailable {
+				break
+			}
+			if err != nil {
+				// e.g. ECONNREFUSED, the packets will be retransmitted
+				if err == ErrInterruptedSyscall {
+					continue
+				}
+				break
+			}
+			c.handle(buf[:n])
+		}
+
+		c.mu.Lock()
+		d := c.retransmit(time.Now())
+		c.deliver()
+		c.mu.Unlock()
+		_, _ = c.p.Wait(evts[:], min(d, pollWaitInterval))
+	}
+}
+
+// retransmit resends the expired packets and returns the duration until
+// the next expiry. The caller must hold mu
+func (c *ReliableConn) retransmit(now time.Time) time.Duration {
+	d := c.opt.MaxRTO
+	backoff := false
+	for _, pkt := range c.unacked {
+		if now.Before(pkt.deadline) {
+			d = min(d, pkt.deadline.Sub(now))
+			continue
+		}
+		if pkt.retransmits >= c.opt.MaxRetransmits {
+			c.fail(ErrRetransmitLimit)
+			return d
+		}
+		if !backoff {
+			backoff = true
+			c.rto = min(c.rto*2, c.opt.MaxRTO)
+		}
+		pkt.retransmits++
+		pkt.deadline = now.Add(c.rto)
+		d = min(d, c.rto)
+		c.writePacket(pkt.b)
+	}
+	return d
+}
+
+// deliver moves the ready messages to the receive channel, and closes the
+// receive channel after the end of the stream. The caller must hold mu
+func (c *ReliableConn) deliver() {
+	for len(c.ready) > 0 {
+		select {
+		case c.recv <- c.ready[0]:
+			c.ready[0] = nil
+			c.ready = c.ready[1:]
+			continue
+		default:
+		}
+		break
+	}
+	if len(c.ready) == 0 && c.finSeq > 0 && c.expected > c.finSeq && !c.recvClosed {
+		c.recvClosed = true
+		close(c.recv)
+	}
+}
+
+func (c *ReliableConn) handle(b []byte) {
+	if len(b) < reliableHeaderLength {
+		return
+	}
+	seq := binary.BigEndian.Uint64(b[1:])
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch b[0] {
+	case reliablePacketData, reliablePacketFin:
+		c.receive(b[0], seq, b[reliableHeaderLength:])
+	case reliablePacketAck:
+		if len(b) < reliableAckLength {
+			return
+		}
+		c.acknowledge(seq, binary.BigEndian.Uint64(b[reliableHeaderLength:]))
+	case reliablePacketNack:
+		now := time.Now()
+		if pkt, ok := c.unacked[seq]; ok && (pkt.nackedAt.IsZero() || now.Sub(pkt.nackedAt) >= c.rto) {
+			// fast retransmission without waiting for the timeout, at most
+			// once per timeout so that the repeated nacks do not flood the peer
+			pkt.nackedAt = now
+			pkt.deadline = now.Add(c.rto)
+			c.writePacket(pkt.b)
+		}
+	}
+}
+
+// receive handles a data or fin packet. The caller must hold mu
+func (c *ReliableConn) receive(typ byte, seq uint64, payload []byte) {
+	if seq >= c.expected+uint64(c.opt.Window) {
+		return
+	}
+	if _, ok := c.received[seq]; seq >= c.expected && !ok {
+		var b []byte
+		if typ == reliablePacketFin {
+			c.finSeq = seq
+		} else {
+			b = append([]byte{}, payload...)
+		}
+		if c.opt.Unordered && typ == reliablePacketData {
+			c.ready = append(c.ready, b)
+			b = nil
+		}
+		c.received[seq] = b
+		for {
+			b, ok := c.received[c.expected]
+			if !ok {
+				break
+			}
+			delete(c.received, c.expected)
+			if b != nil {
+				c.ready = append(c.ready, b)
+			}
+			c.expected++
+		}
+		if seq > c.expected && c.nacked < c.expected {
+			// a gap has been found
+			c.nacked = c.expected
+			nack := [reliableHeaderLength]byte{reliablePacketNack}
+			binary.BigEndian.PutUint64(nack[1:], c.expected)
+			c.writePacket(nack[:])
+		}
+	}
+
+	// duplicates are acknowledged again, since the previous ack may be lost
+	ack := [reliableAckLength]byte{reliablePacketAck}
+	binary.BigEndian.PutUint64(ack[1:], c.expected)
+	bitmap := uint64(0)
+	for i := range reliableBitmapBits {
+		if _, ok := c.received[c.expected+1+uint64(i)]; ok {
+			bitmap |= 1 << i
+		}
+	}
+	binary.BigEndian.PutUint64(ack[reliableHeaderLength:], bitmap)
+	c.writePacket(ack[:])
+}
+
+// acknowledge drops the acknowledged packets and samples the round-trip
+// time from the packets which have not been retransmitted
+// The caller must hold mu
+func (c *ReliableConn) acknowledge(expected uint64, bitmap uint64) {
+	now := time.Now()
+	for seq, pkt := range c.unacked {
+		if seq >= expected && (seq == expected || seq-expected-1 >= reliableBitmapBits || bitmap&(1<<(seq-expected-1)) == 0) {
+			continue
+		}
+		delete(c.unacked, seq)
+		<-c.window
+		if pkt.retransmits == 0 && pkt.nackedAt.IsZero() {
+			c.sample(now.Sub(pkt.sentAt))
+		}
+	}
+}
+
+// sample updates the retransmission timeout with the round-trip time r
+func (c *ReliableConn) sample(r time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = r, r/2
+	} else {
+		delta := c.srtt - r
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + r) / 8
+	}
+	c.rto = min(max(c.srtt+max(reliableRTOGranular, 4*c.rttvar), c.opt.MinRTO), c.opt.MaxRTO)
+}
+
+// pipeDeadline is a deadline which can be waited on with a channel
+// and changed while being waited on
+type pipeDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makePipeDeadline() pipeDeadline {
+	return pipeDeadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline. A zero t means no deadline
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer has closed the channel
+		d.cancel = make(chan struct{})
+	}
+	d.timer = nil
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel which is closed when the deadline passes
+func (d *pipeDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,229 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reliableConnPair creates a pair of reliable connections over the UDP
+// sockets bound to and connected with each other on the ports
+func reliableConnPair(t *testing.T, port0, port1 int, opts ...func(options *ReliableConnOptions)) (c0, c1 *ReliableConn) {
+	dial := func(lport, rport int) *ReliableConn {
+		laddr := &UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: lport}
+		so, err := newUDPSocket(udp4AddrToSockaddr(laddr))
+		if err != nil {
+			t.Fatalf("new udp socket: %v", err)
+		}
+		err = unix.Bind(so.fd, udp4AddrToSockaddr(laddr))
+		if err != nil {
+			t.Fatalf("bind: %v", err)
+		}
+		conn, err := so.Dial4(&UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: rport})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		c, err := NewReliableConn(conn, opts...)
+		if err != nil {
+			t.Fatalf("new reliable conn: %v", err)
+		}
+		return c
+	}
+	return dial(port0, port1), dial(port1, port0)
+}
+
+func TestReliableConn_Ordered(t *testing.T) {
+	opt := func(options *ReliableConnOptions) {
+		options.Window = 8
+		options.InitialRTO = 20 * time.Millisecond
+		options.MinRTO = 10 * time.Millisecond
+		options.MaxRTO = 100 * time.Millisecond
+		options.MaxRetransmits = 100
+	}
+	c0, c1 := reliableConnPair(t, 8190, 8191, opt)
+	defer c1.Close()
+	// every third datagram from c0 is lost, including acks and fin
+	n := atomic.Int32{}
+	c0.drop = func(b []byte) bool {
+		return n.Add(1)%3 == 0
+	}
+
+	const count = 50
+	go func() {
+		w := NewMessageWriter(c0, func(options *MessageOptions) {
+			options.WriteProto = UnderlyingProtocolDgram
+			options.Nonblock = false
+		})
+		for i := range count {
+			_, err := w.Write([]byte(fmt.Sprintf("message %d", i)))
+			if err != nil {
+				t.Errorf("write: %v", err)
+				return
+			}
+		}
+		_ = c0.Close()
+	}()
+
+	r := NewMessageReader(c1, func(options *MessageOptions) {
+		options.ReadProto = UnderlyingProtocolDgram
+		options.Nonblock = false
+	})
+	p := make([]byte, 64)
+	_ = c1.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for i := range count {
+		n, err := r.Read(p)
+		if err != nil || string(p[:n]) != fmt.Sprintf("message %d", i) {
+			t.Errorf("read message %d but got %q: %v", i, p[:n], err)
+			return
+		}
+	}
+	_, err := c1.Read(p)
+	if err != io.EOF {
+		t.Errorf("expected EOF but got %v", err)
+		return
+	}
+}
+
+func TestReliableConn_Unordered(t *testing.T) {
+	opt := func(options *ReliableConnOptions) {
+		options.Unordered = true
+		options.InitialRTO = 20 * time.Millisecond
+		options.MinRTO = 10 * time.Millisecond
+	}
+	c0, c1 := reliableConnPair(t, 8192, 8193, opt)
+	defer c1.Close()
+	defer c0.Close()
+	// the first transmission of message 0 is lost
+	dropped := atomic.Bool{}
+	c0.drop = func(b []byte) bool {
+		return b[0] == reliablePacketData && b[reliableHeaderLength] == '0' && dropped.CompareAndSwap(false, true)
+	}
+	for _, s := range []string{"0", "1", "2"} {
+		_, err := c0.Write([]byte(s))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+	}
+
+	got := map[string]bool{}
+	p := make([]byte, 16)
+	_ = c1.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for range 3 {
+		n, err := c1.Read(p)
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		got[string(p[:n])] = true
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 distinct messages but got %v", got)
+		return
+	}
+
+	t.Run("short buffer", func(t *testing.T) {
+		_, err := c0.Write([]byte("long message"))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		_, err = c1.Read(p[:4])
+		if err != io.ErrShortBuffer {
+			t.Errorf("expected ErrShortBuffer but got %v", err)
+			return
+		}
+		n, err := c1.Read(p)
+		if err != nil || string(p[:n]) != "long message" {
+			t.Errorf("read %q: %v", p[:n], err)
+			return
+		}
+	})
+
+	t.Run("read deadline", func(t *testing.T) {
+		_ = c1.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		_, err := c1.Read(p)
+		if err != os.ErrDeadlineExceeded {
+			t.Errorf("expected deadline exceeded but got %v", err)
+			return
+		}
+	})
+}
+
+func TestReliableConn_RetransmitLimit(t *testing.T) {
+	c0, c1 := reliableConnPair(t, 8194, 8195, func(options *ReliableConnOptions) {
+		options.InitialRTO = 10 * time.Millisecond
+		options.MinRTO = 10 * time.Millisecond
+		options.MaxRTO = 10 * time.Millisecond
+		options.MaxRetransmits = 3
+		options.Linger = 0
+	})
+	defer c0.Close()
+	defer c1.Close()
+	c0.drop = func(b []byte) bool {
+		return true
+	}
+	_, err := c0.Write([]byte("lost"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	_ = c0.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c0.Read(make([]byte, 16))
+	if err != ErrRetransmitLimit {
+		t.Errorf("expected ErrRetransmitLimit but got %v", err)
+		return
+	}
+}
+
+func TestReliableConn_Nack(t *testing.T) {
+	c0, c1 := reliableConnPair(t, 8220, 8221, func(options *ReliableConnOptions) {
+		options.InitialRTO = time.Second
+		options.MinRTO = time.Second
+		options.MaxRetransmits = 1
+		options.Linger = 0
+	})
+	defer c0.Close()
+	defer c1.Close()
+	sent := atomic.Int32{}
+	c0.drop = func(b []byte) bool {
+		if b[0] == reliablePacketData {
+			sent.Add(1)
+		}
+		return true
+	}
+	_, err := c0.Write([]byte("lost"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	nack := [reliableHeaderLength]byte{reliablePacketNack}
+	binary.BigEndian.PutUint64(nack[1:], 1)
+	for range 8 {
+		c0.handle(nack[:])
+	}
+	if sent.Load() != 2 {
+		t.Errorf("expected 1 fast retransmission but got %d", sent.Load()-1)
+		return
+	}
+	c0.mu.Lock()
+	pkt := c0.unacked[1]
+	retransmits, deadline := pkt.retransmits, pkt.deadline
+	c0.mu.Unlock()
+	if retransmits != 0 || time.Until(deadline) < c0.opt.MinRTO/2 {
+		t.Errorf("expected the fast retransmission to restart the timeout but got %d retransmits", retransmits)
+		return
+	}
+}
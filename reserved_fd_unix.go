@@ -0,0 +1,57 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"sync"
+)
+
+// reservedFd is a placeholder fd which is given up temporarily to accept
+// and immediately close a pending connection when the process or the system
+// runs out of fds. Otherwise the listener keeps readable and the accept loop
+// hot-spins on EMFILE or ENFILE while the connection is never taken
+var reservedFd = struct {
+	sync.Mutex
+	once sync.Once
+	fd   int
+}{fd: -1}
+
+func reserveFd() {
+	reservedFd.once.Do(func() {
+		reservedFd.Lock()
+		defer reservedFd.Unlock()
+		reservedFd.fd = openReservedFd()
+	})
+}
+
+func openReservedFd() int {
+	fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1
+	}
+	return fd
+}
+
+// shedConnection accepts and closes a pending connection of the listener fd
+// with the reserved fd given up. It reports whether a connection was shed
+func shedConnection(fd int) bool {
+	reservedFd.Lock()
+	defer reservedFd.Unlock()
+	if reservedFd.fd < 0 {
+		reservedFd.fd = openReservedFd()
+		return false
+	}
+	_ = unix.Close(reservedFd.fd)
+	nfd, _, err := unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+	if err == nil {
+		_ = unix.Close(nfd)
+	}
+	reservedFd.fd = openReservedFd()
+
+	return err == nil
+}
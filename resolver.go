@@ -0,0 +1,57 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Resolver is the interface that wraps the forward lookup method LookupIPAddr
+// LookupIPAddr looks up host and returns its IPv4 and IPv6 addresses
+// *net.Resolver implements Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]IPAddr, error)
+}
+
+// DefaultResolver is the Resolver used by ResolveSCTPAddr and DialThroughProxy
+var DefaultResolver Resolver = net.DefaultResolver
+
+// lookupPort returns the port number of the service, which is either
+// a decimal port number or a service name of the services database
+func lookupPort(ctx context.Context, service string) (int, error) {
+	if service == "" {
+		return 0, nil
+	}
+	if port, err := strconv.ParseUint(service, 10, 16); err == nil {
+		return int(port), nil
+	}
+
+	return net.DefaultResolver.LookupPort(ctx, "tcp", service)
+}
+
+// lookupIP returns the address of host, which is either an IP address or a
+// host name looked up with DefaultResolver. IPv4 addresses are preferred
+func lookupIP(ctx context.Context, host string) (net.IP, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.AsSlice(), nil
+	}
+	ipAddrs, err := DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ipAddrs) < 1 {
+		return nil, &AddrError{Err: "no suitable address", Addr: host}
+	}
+	for _, ipAddr := range ipAddrs {
+		if ipAddr.IP.To4() != nil {
+			return ipAddr.IP, nil
+		}
+	}
+
+	return ipAddrs[0].IP, nil
+}
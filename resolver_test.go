@@ -0,0 +1,64 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"hybscloud.com/sox"
+	"net"
+	"testing"
+)
+
+type staticResolver map[string][]sox.IPAddr
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]sox.IPAddr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func TestResolveSCTPAddr(t *testing.T) {
+	resolver := staticResolver{
+		"dual.example": {{IP: net.ParseIP("192.0.2.1").To4()}, {IP: net.ParseIP("2001:db8::1")}},
+		"v4.example":   {{IP: net.ParseIP("192.0.2.2").To4()}},
+	}
+	cases := []struct {
+		network, address string
+		expected         string
+		fail             bool
+	}{
+		{"sctp", "dual.example:9000", "[2001:db8::1]:9000", false},
+		{"sctp4", "dual.example:9000", "192.0.2.1:9000", false},
+		{"sctp6", "dual.example:9000", "[2001:db8::1]:9000", false},
+		{"sctp", "v4.example:9000", "192.0.2.2:9000", false},
+		{"sctp6", "v4.example:9000", "", true},
+		{"sctp", "127.0.0.1:9000", "127.0.0.1:9000", false},
+		{"sctp6", "127.0.0.1:9000", "", true},
+		{"sctp", ":9000", ":9000", false},
+		{"sctp", "unknown.example:9000", "", true},
+		{"sctp", "dual.example", "", true},
+		{"tcp", "dual.example:9000", "", true},
+	}
+	for _, c := range cases {
+		addr, err := sox.ResolveSCTPAddrContext(context.Background(), resolver, c.network, c.address)
+		if c.fail {
+			if err == nil {
+				t.Errorf("resolve %s %s expected error but got %v", c.network, c.address, addr)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolve %s %s: %v", c.network, c.address, err)
+			return
+		}
+		if addr.String() != c.expected {
+			t.Errorf("resolve %s %s expected %s but got %s", c.network, c.address, c.expected, addr)
+			return
+		}
+	}
+}
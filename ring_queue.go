@@ -5,6 +5,7 @@
 package sox
 
 import (
+	"context"
 	"errors"
 	"io"
 	"math"
@@ -29,8 +30,59 @@ type ItemConsumer[ItemType any] interface {
 	Consume() (item ItemType, err error)
 }
 
+// ItemPeeker is the interface that groups the introspection methods of containers
+// For concurrent containers the results are only weakly consistent snapshots
+// which may be outdated as soon as they are returned
+type ItemPeeker[ItemType any] interface {
+	// Peek returns the next item which will be consumed without removing it
+	// When the container is empty, it returns the zero-value and io.EOF if the
+	// container is already closed, or ErrTemporarilyUnavailable otherwise
+	Peek() (item ItemType, err error)
+	// Len returns the number of items in the container
+	Len() int
+	// Cap returns the capacity of the container
+	Cap() int
+}
+
+// ItemBatchProducer is the interface that groups the ItemProducer methods and ProduceMany method
+type ItemBatchProducer[ItemType any] interface {
+	ItemProducer[ItemType]
+	// ProduceMany produces as many of the items as possible in one synchronization episode
+	// It returns the number of produced items, which is less than len(items) only when
+	// the queue has been full. It blocks until at least one item has been produced
+	// unless the Nonblocking option is set as true
+	ProduceMany(items []ItemType) (n int, err error)
+}
+
+// ItemBatchConsumer is the interface that groups the ItemConsumer methods and ConsumeMany method
+type ItemBatchConsumer[ItemType any] interface {
+	ItemConsumer[ItemType]
+	// ConsumeMany consumes at most len(dst) items into dst in one synchronization episode
+	// It returns the number of consumed items. It blocks until at least one item has been
+	// consumed unless the Nonblocking option is set as true
+	ConsumeMany(dst []ItemType) (n int, err error)
+}
+
+// ItemContextProducer is the interface that groups the ItemProducer methods and ProduceContext method
+type ItemContextProducer[ItemType any] interface {
+	ItemProducer[ItemType]
+	// ProduceContext produces the item and blocks until the item has been produced
+	// or the ctx is done. It returns ctx.Err() if the ctx is done before producing
+	ProduceContext(ctx context.Context, item ItemType) error
+}
+
+// ItemContextConsumer is the interface that groups the ItemConsumer methods and ConsumeContext method
+type ItemContextConsumer[ItemType any] interface {
+	ItemConsumer[ItemType]
+	// ConsumeContext consumes an item and blocks until any item has been consumed
+	// or the ctx is done. It returns ctx.Err() if the ctx is done before consuming
+	ConsumeContext(ctx context.Context) (item ItemType, err error)
+}
+
 // NewRingQueue creates a ring queue with given options
 // and returns the consumer and the producer of it
+// The returned consumer and producer also implement ItemBatchConsumer,
+// ItemBatchProducer, ItemContextConsumer, ItemContextProducer and ItemPeeker
 func NewRingQueue[ItemType any](
 	opts ...func(options *RingQueueOptions)) (
 	consumer ItemConsumer[ItemType],
@@ -75,20 +127,93 @@ type RingQueueOptions struct {
 	Capacity int
 	// ConcurrentProduce specifies whether the ItemProducer works concurrently or not
 	// It should be set as true, if there are multiple goroutines doing Produce operations
+	// Otherwise there must be only one producer goroutine, which may run in parallel with consumers
 	ConcurrentProduce bool
 	// ConcurrentConsume specifies whether the ItemConsumer works concurrently or not
 	// It should be set as true, if there are multiple goroutines doing Consumer operations
+	// Otherwise there must be only one consumer goroutine, which may run in parallel with producers
 	ConcurrentConsume bool
 	// Nonblocking specifies whether the Produce or Consume operations will NOT block
 	// even if it is temporarily unavailable or not
 	Nonblocking bool
+	// WaitStrategy specifies how the blocking Produce or Consume operations wait
+	// while the queue is full or empty. The default WaitStrategy is WaitStrategySpin
+	// WaitStrategyPark lets idle consumers sleep until any item has been produced
+	WaitStrategy WaitStrategy
+}
+
+type ringQueueWaits struct {
+	strategy WaitStrategy
+	notEmpty *parker
+	notFull  *parker
+}
+
+func newRingQueueWaits(opt *RingQueueOptions) *ringQueueWaits {
+	return &ringQueueWaits{strategy: opt.WaitStrategy, notEmpty: newParker(), notFull: newParker()}
+}
+
+func (ws *ringQueueWaits) notEmptyWait(sw *SpinWait) strategyWait {
+	return strategyWait{strategy: ws.strategy, sw: sw, pk: ws.notEmpty}
+}
+
+func (ws *ringQueueWaits) notFullWait(sw *SpinWait) strategyWait {
+	return strategyWait{strategy: ws.strategy, sw: sw, pk: ws.notFull}
+}
+
+// waitContext retries the nonblocking operation try with the WaitStrategy
+// until it is not temporarily unavailable or the ctx is done
+func (ws *ringQueueWaits) waitContext(ctx context.Context, pk *parker, try func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w := strategyWait{strategy: ws.strategy, sw: NewSpinWait().SetLevel(SpinWaitLevelBlockingIO), pk: pk}
+	defer w.done()
+	if ws.strategy == WaitStrategyPark {
+		stop := context.AfterFunc(ctx, pk.unpark)
+		defer stop()
+	}
+	for {
+		err := try()
+		if err != ErrTemporarilyUnavailable {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		w.once()
+	}
 }
 
+func (ws *ringQueueWaits) produced() {
+	if ws.strategy == WaitStrategyPark {
+		ws.notEmpty.unpark()
+	}
+}
+
+func (ws *ringQueueWaits) consumed() {
+	if ws.strategy == WaitStrategyPark {
+		ws.notFull.unpark()
+	}
+}
+
+func (ws *ringQueueWaits) closed() {
+	if ws.strategy == WaitStrategyPark {
+		ws.notEmpty.unpark()
+		ws.notFull.unpark()
+	}
+}
+
+// ringQueue is the single-producer single-consumer ring queue
+// The producer owns the tail and the consumer owns the head. Both of them
+// are published with atomic stores so that the slots written before a
+// store are visible to the counterpart which observes the stored value
 type ringQueue[T any] struct {
 	*RingQueueOptions
-	ring                 []T
-	capacity, head, tail uint32
-	closed               bool
+	ring       []T
+	capacity   uint32
+	head, tail atomic.Uint32
+	closed     atomic.Bool
+	waits      *ringQueueWaits
 }
 
 func newRingQueue[T any](opt *RingQueueOptions) *ringQueue[T] {
@@ -96,52 +221,182 @@ func newRingQueue[T any](opt *RingQueueOptions) *ringQueue[T] {
 		RingQueueOptions: opt,
 		ring:             make([]T, opt.Capacity+1),
 		capacity:         uint32(opt.Capacity),
-		head:             0,
-		tail:             0,
-		closed:           false,
+		head:             atomic.Uint32{},
+		tail:             atomic.Uint32{},
+		closed:           atomic.Bool{},
+		waits:            newRingQueueWaits(opt),
 	}
 }
 
 func (rq *ringQueue[T]) Produce(item T) error {
-	for sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce); !sw.Closed(); sw.Once() {
-		if rq.closed {
+	return rq.produce(item, rq.Nonblocking)
+}
+
+func (rq *ringQueue[T]) produce(item T, nonblocking bool) error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	tail := rq.tail.Load()
+	for ; !sw.Closed(); w.once() {
+		if rq.closed.Load() {
 			return io.ErrClosedPipe
 		}
-		if (rq.tail+1)&rq.capacity == rq.head {
-			if rq.Nonblocking {
+		if (tail+1)&rq.capacity == rq.head.Load() {
+			if nonblocking {
 				return ErrTemporarilyUnavailable
 			}
 			continue
 		}
 		break
 	}
-	rq.ring[rq.tail] = item
-	rq.tail = (rq.tail + 1) & rq.capacity
+	rq.ring[tail] = item
+	rq.tail.Store((tail + 1) & rq.capacity)
+	rq.waits.produced()
 
 	return nil
 }
 
 func (rq *ringQueue[T]) Consume() (item T, err error) {
-	for sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce); !sw.Closed(); sw.Once() {
-		if rq.head == rq.tail {
-			if rq.closed {
+	return rq.consume(rq.Nonblocking)
+}
+
+// empty reports whether there are no items to consume. It reloads the tail
+// after observing the closed flag, since the items produced before Close
+// must still be consumed
+func (rq *ringQueue[T]) empty(head uint32) (empty bool, closed bool) {
+	if head != rq.tail.Load() {
+		return false, false
+	}
+	if !rq.closed.Load() {
+		return true, false
+	}
+	return head == rq.tail.Load(), true
+}
+
+func (rq *ringQueue[T]) consume(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	head := rq.head.Load()
+	for ; !sw.Closed(); w.once() {
+		if empty, closed := rq.empty(head); empty {
+			if closed {
 				return item, io.EOF
 			}
-			if rq.Nonblocking {
+			if nonblocking {
 				return item, ErrTemporarilyUnavailable
 			}
 			continue
 		}
 		break
 	}
-	item = rq.ring[rq.head]
-	rq.head = (rq.head + 1) & rq.capacity
+	item = rq.ring[head]
+	rq.ring[head] = *new(T)
+	rq.head.Store((head + 1) & rq.capacity)
+	rq.waits.consumed()
 
 	return item, nil
 }
 
+func (rq *ringQueue[T]) Peek() (item T, err error) {
+	head := rq.head.Load()
+	if empty, closed := rq.empty(head); empty {
+		if closed {
+			return item, io.EOF
+		}
+		return item, ErrTemporarilyUnavailable
+	}
+	return rq.ring[head], nil
+}
+
+func (rq *ringQueue[T]) Len() int {
+	return int((rq.tail.Load() - rq.head.Load()) & rq.capacity)
+}
+
+func (rq *ringQueue[T]) Cap() int {
+	return int(rq.capacity)
+}
+
+func (rq *ringQueue[T]) ProduceContext(ctx context.Context, item T) error {
+	return rq.waits.waitContext(ctx, rq.waits.notFull, func() error { return rq.produce(item, true) })
+}
+
+func (rq *ringQueue[T]) ConsumeContext(ctx context.Context) (item T, err error) {
+	err = rq.waits.waitContext(ctx, rq.waits.notEmpty, func() (err error) {
+		item, err = rq.consume(true)
+		return
+	})
+	return
+}
+
+func (rq *ringQueue[T]) ProduceMany(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	tail := rq.tail.Load()
+	for ; !sw.Closed(); w.once() {
+		if rq.closed.Load() {
+			return 0, io.ErrClosedPipe
+		}
+		free := rq.capacity - (tail-rq.head.Load())&rq.capacity
+		if free == 0 {
+			if rq.Nonblocking {
+				return 0, ErrTemporarilyUnavailable
+			}
+			continue
+		}
+		n = min(int(free), len(items))
+		for i := range n {
+			rq.ring[(tail+uint32(i))&rq.capacity] = items[i]
+		}
+		rq.tail.Store((tail + uint32(n)) & rq.capacity)
+		rq.waits.produced()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
+func (rq *ringQueue[T]) ConsumeMany(dst []T) (n int, err error) {
+	if len(dst) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	head := rq.head.Load()
+	for ; !sw.Closed(); w.once() {
+		if empty, closed := rq.empty(head); empty {
+			if closed {
+				return 0, io.EOF
+			}
+			if rq.Nonblocking {
+				return 0, ErrTemporarilyUnavailable
+			}
+			continue
+		}
+		used := (rq.tail.Load() - head) & rq.capacity
+		n = min(int(used), len(dst))
+		for i := range n {
+			idx := (head + uint32(i)) & rq.capacity
+			dst[i], rq.ring[idx] = rq.ring[idx], *new(T)
+		}
+		rq.head.Store((head + uint32(n)) & rq.capacity)
+		rq.waits.consumed()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
 func (rq *ringQueue[T]) Close() error {
-	rq.closed = true
+	rq.closed.Store(true)
+	rq.waits.closed()
 
 	return nil
 }
@@ -155,8 +410,9 @@ const (
 
 type ringQueueConcurrentProduce[T any] struct {
 	*RingQueueOptions
-	ring           []T
-	capacity, head uint32
+	ring     []T
+	capacity uint32
+	head     atomic.Uint32
 	*ringQueueConcurrentClose
 }
 
@@ -165,13 +421,20 @@ func newRingQueueConcurrentProduce[T any](opt *RingQueueOptions) *ringQueueConcu
 		RingQueueOptions:         opt,
 		ring:                     make([]T, opt.Capacity+1),
 		capacity:                 uint32(opt.Capacity),
-		head:                     0,
-		ringQueueConcurrentClose: newRingQueueConcurrentClose(),
+		head:                     atomic.Uint32{},
+		ringQueueConcurrentClose: newRingQueueConcurrentClose(opt),
 	}
 }
 
 func (rq *ringQueueConcurrentProduce[T]) Produce(item T) error {
-	for sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce); !sw.Closed(); {
+	return rq.produce(item, rq.Nonblocking)
+}
+
+func (rq *ringQueueConcurrentProduce[T]) produce(item T, nonblocking bool) error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	for !sw.Closed() {
 		tail := rq.tail.Load()
 		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
 			sw.Once()
@@ -180,21 +443,22 @@ func (rq *ringQueueConcurrentProduce[T]) Produce(item T) error {
 		if tail&ringQueueStatusClosed == ringQueueStatusClosed {
 			return io.ErrClosedPipe
 		}
-		if ((tail&ringQueueTailValueMask)+1)&rq.capacity == rq.head {
-			if rq.Nonblocking {
+		if ((tail&ringQueueTailValueMask)+1)&rq.capacity == rq.head.Load() {
+			if nonblocking {
 				break
 			}
-			sw.Once()
+			w.once()
 			continue
 		}
 		newTailStatus, newTailVal := (tail|ringQueueStatusWriting)&ringQueueTailStatusMask, (tail+1)&rq.capacity
 		if swapped := rq.tail.CompareAndSwap(tail, newTailStatus|newTailVal); !swapped {
-			sw.OnceWithLevel(spinWaitLevelAtomic)
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
 			continue
 		}
 		rq.ring[tail&ringQueueTailValueMask] = item
 		newTailStatus &= ringQueueTailStatusMask ^ ringQueueStatusWriting
 		rq.tail.Store(newTailStatus | newTailVal)
+		rq.waits.produced()
 
 		return nil
 	}
@@ -203,23 +467,35 @@ func (rq *ringQueueConcurrentProduce[T]) Produce(item T) error {
 }
 
 func (rq *ringQueueConcurrentProduce[T]) Consume() (item T, err error) {
-	for sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); sw.Once() {
+	return rq.consume(rq.Nonblocking)
+}
+
+func (rq *ringQueueConcurrentProduce[T]) consume(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for !sw.Closed() {
 		tail := rq.tail.Load()
 		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			sw.Once()
 			continue
 		}
+		head := rq.head.Load()
 		tailStatus, tailVal := tail&ringQueueTailStatusMask, tail&ringQueueTailValueMask
-		if rq.head == tailVal {
+		if head == tailVal {
 			if tailStatus&ringQueueStatusClosed == ringQueueStatusClosed {
 				return item, io.EOF
 			}
-			if rq.Nonblocking {
+			if nonblocking {
 				return item, ErrTemporarilyUnavailable
 			}
+			w.once()
 			continue
 		}
-		item = rq.ring[rq.head]
-		rq.head = (rq.head + 1) & rq.capacity
+		item = rq.ring[head]
+		rq.ring[head] = *new(T)
+		rq.head.Store((head + 1) & rq.capacity)
+		rq.waits.consumed()
 
 		return item, nil
 	}
@@ -227,13 +503,134 @@ func (rq *ringQueueConcurrentProduce[T]) Consume() (item T, err error) {
 	return
 }
 
+func (rq *ringQueueConcurrentProduce[T]) Peek() (item T, err error) {
+	for sw := NewSpinWait().SetLevel(SpinWaitLevelConsume); ; sw.Once() {
+		tail := rq.tail.Load()
+		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			continue
+		}
+		head := rq.head.Load()
+		if head == tail&ringQueueTailValueMask {
+			if tail&ringQueueStatusClosed == ringQueueStatusClosed {
+				return item, io.EOF
+			}
+			return item, ErrTemporarilyUnavailable
+		}
+		return rq.ring[head], nil
+	}
+}
+
+func (rq *ringQueueConcurrentProduce[T]) Len() int {
+	return int((rq.tail.Load()&ringQueueTailValueMask - rq.head.Load()) & rq.capacity)
+}
+
+func (rq *ringQueueConcurrentProduce[T]) Cap() int {
+	return int(rq.capacity)
+}
+
+func (rq *ringQueueConcurrentProduce[T]) ProduceContext(ctx context.Context, item T) error {
+	return rq.waits.waitContext(ctx, rq.waits.notFull, func() error { return rq.produce(item, true) })
+}
+
+func (rq *ringQueueConcurrentProduce[T]) ConsumeContext(ctx context.Context) (item T, err error) {
+	err = rq.waits.waitContext(ctx, rq.waits.notEmpty, func() (err error) {
+		item, err = rq.consume(true)
+		return
+	})
+	return
+}
+
+func (rq *ringQueueConcurrentProduce[T]) ProduceMany(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	for !sw.Closed() {
+		tail := rq.tail.Load()
+		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			sw.Once()
+			continue
+		}
+		if tail&ringQueueStatusClosed == ringQueueStatusClosed {
+			return 0, io.ErrClosedPipe
+		}
+		tailVal := tail & ringQueueTailValueMask
+		free := rq.capacity - (tailVal-rq.head.Load())&rq.capacity
+		if free == 0 {
+			if rq.Nonblocking {
+				break
+			}
+			w.once()
+			continue
+		}
+		n = min(int(free), len(items))
+		newTailStatus, newTailVal := (tail|ringQueueStatusWriting)&ringQueueTailStatusMask, (tailVal+uint32(n))&rq.capacity
+		if swapped := rq.tail.CompareAndSwap(tail, newTailStatus|newTailVal); !swapped {
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
+			continue
+		}
+		for i := range n {
+			rq.ring[(tailVal+uint32(i))&rq.capacity] = items[i]
+		}
+		newTailStatus &= ringQueueTailStatusMask ^ ringQueueStatusWriting
+		rq.tail.Store(newTailStatus | newTailVal)
+		rq.waits.produced()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
+func (rq *ringQueueConcurrentProduce[T]) ConsumeMany(dst []T) (n int, err error) {
+	if len(dst) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for !sw.Closed() {
+		tail := rq.tail.Load()
+		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			sw.Once()
+			continue
+		}
+		head := rq.head.Load()
+		used := (tail&ringQueueTailValueMask - head) & rq.capacity
+		if used == 0 {
+			if tail&ringQueueStatusClosed == ringQueueStatusClosed {
+				return 0, io.EOF
+			}
+			if rq.Nonblocking {
+				return 0, ErrTemporarilyUnavailable
+			}
+			w.once()
+			continue
+		}
+		n = min(int(used), len(dst))
+		for i := range n {
+			idx := (head + uint32(i)) & rq.capacity
+			dst[i], rq.ring[idx] = rq.ring[idx], *new(T)
+		}
+		rq.head.Store((head + uint32(n)) & rq.capacity)
+		rq.waits.consumed()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
 type ringQueueConcurrentConsume[T any] struct {
 	*RingQueueOptions
 	ring     []T
 	capacity uint32
 	head     atomic.Uint32
-	tail     uint32
-	closed   bool
+	tail     atomic.Uint32
+	closed   atomic.Bool
+	waits    *ringQueueWaits
 }
 
 func newRingQueueConcurrentConsume[T any](opt *RingQueueOptions) *ringQueueConcurrentConsume[T] {
@@ -242,24 +639,34 @@ func newRingQueueConcurrentConsume[T any](opt *RingQueueOptions) *ringQueueConcu
 		ring:             make([]T, opt.Capacity+1),
 		capacity:         uint32(opt.Capacity),
 		head:             atomic.Uint32{},
-		tail:             0,
-		closed:           false,
+		tail:             atomic.Uint32{},
+		closed:           atomic.Bool{},
+		waits:            newRingQueueWaits(opt),
 	}
 }
 
 func (rq *ringQueueConcurrentConsume[T]) Produce(item T) error {
-	if rq.closed {
+	return rq.produce(item, rq.Nonblocking)
+}
+
+func (rq *ringQueueConcurrentConsume[T]) produce(item T, nonblocking bool) error {
+	if rq.closed.Load() {
 		return io.ErrClosedPipe
 	}
-	for sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce); !sw.Closed(); sw.Once() {
-		if (rq.tail+1)&rq.capacity == rq.head.Load()&rq.capacity {
-			if rq.Nonblocking {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	tail := rq.tail.Load()
+	for ; !sw.Closed(); w.once() {
+		if (tail+1)&rq.capacity == rq.head.Load()&rq.capacity {
+			if nonblocking {
 				break
 			}
 			continue
 		}
-		rq.ring[rq.tail] = item
-		rq.tail = (rq.tail + 1) & rq.capacity
+		rq.ring[tail] = item
+		rq.tail.Store((tail + 1) & rq.capacity)
+		rq.waits.produced()
 
 		return nil
 	}
@@ -268,21 +675,31 @@ func (rq *ringQueueConcurrentConsume[T]) Produce(item T) error {
 }
 
 func (rq *ringQueueConcurrentConsume[T]) Consume() (item T, err error) {
-	for sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); sw.Once() {
+	return rq.consume(rq.Nonblocking)
+}
+
+func (rq *ringQueueConcurrentConsume[T]) consume(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for !sw.Closed() {
 		head := rq.head.Load()
-		if head == rq.tail {
-			if rq.closed {
+		if empty, closed := rq.empty(head); empty {
+			if closed {
 				return item, io.EOF
 			}
-			if rq.Nonblocking {
+			if nonblocking {
 				return item, ErrTemporarilyUnavailable
 			}
+			w.once()
 			continue
 		}
 		item = rq.ring[head]
 		if swapped := rq.head.CompareAndSwap(head, (head+1)&rq.capacity); !swapped {
+			sw.Once()
 			continue
 		}
+		rq.waits.consumed()
 
 		return item, nil
 	}
@@ -290,8 +707,125 @@ func (rq *ringQueueConcurrentConsume[T]) Consume() (item T, err error) {
 	return
 }
 
+func (rq *ringQueueConcurrentConsume[T]) Peek() (item T, err error) {
+	for sw := NewSpinWait().SetLevel(SpinWaitLevelConsume); ; sw.Once() {
+		head := rq.head.Load()
+		if empty, closed := rq.empty(head); empty {
+			if closed {
+				return item, io.EOF
+			}
+			return item, ErrTemporarilyUnavailable
+		}
+		item = rq.ring[head]
+		if rq.head.Load() != head {
+			continue
+		}
+		return item, nil
+	}
+}
+
+func (rq *ringQueueConcurrentConsume[T]) Len() int {
+	return int((rq.tail.Load() - rq.head.Load()) & rq.capacity)
+}
+
+func (rq *ringQueueConcurrentConsume[T]) Cap() int {
+	return int(rq.capacity)
+}
+
+func (rq *ringQueueConcurrentConsume[T]) ProduceContext(ctx context.Context, item T) error {
+	return rq.waits.waitContext(ctx, rq.waits.notFull, func() error { return rq.produce(item, true) })
+}
+
+func (rq *ringQueueConcurrentConsume[T]) ConsumeContext(ctx context.Context) (item T, err error) {
+	err = rq.waits.waitContext(ctx, rq.waits.notEmpty, func() (err error) {
+		item, err = rq.consume(true)
+		return
+	})
+	return
+}
+
+func (rq *ringQueueConcurrentConsume[T]) ProduceMany(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	if rq.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	tail := rq.tail.Load()
+	for ; !sw.Closed(); w.once() {
+		free := rq.capacity - (tail-rq.head.Load())&rq.capacity
+		if free == 0 {
+			if rq.Nonblocking {
+				break
+			}
+			continue
+		}
+		n = min(int(free), len(items))
+		for i := range n {
+			rq.ring[(tail+uint32(i))&rq.capacity] = items[i]
+		}
+		rq.tail.Store((tail + uint32(n)) & rq.capacity)
+		rq.waits.produced()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
+func (rq *ringQueueConcurrentConsume[T]) ConsumeMany(dst []T) (n int, err error) {
+	if len(dst) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for !sw.Closed() {
+		head := rq.head.Load()
+		if empty, closed := rq.empty(head); empty {
+			if closed {
+				return 0, io.EOF
+			}
+			if rq.Nonblocking {
+				return 0, ErrTemporarilyUnavailable
+			}
+			w.once()
+			continue
+		}
+		used := (rq.tail.Load() - head) & rq.capacity
+		n = min(int(used), len(dst))
+		for i := range n {
+			dst[i] = rq.ring[(head+uint32(i))&rq.capacity]
+		}
+		if swapped := rq.head.CompareAndSwap(head, (head+uint32(n))&rq.capacity); !swapped {
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
+			continue
+		}
+		rq.waits.consumed()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
+// empty reports whether there are no items to consume, see ringQueue.empty
+func (rq *ringQueueConcurrentConsume[T]) empty(head uint32) (empty bool, closed bool) {
+	if head != rq.tail.Load() {
+		return false, false
+	}
+	if !rq.closed.Load() {
+		return true, false
+	}
+	return head == rq.tail.Load(), true
+}
+
 func (rq *ringQueueConcurrentConsume[T]) Close() error {
-	rq.closed = true
+	rq.closed.Store(true)
+	rq.waits.closed()
 
 	return nil
 }
@@ -310,12 +844,19 @@ func newRingQueueConcurrent[T any](opt *RingQueueOptions) *ringQueueConcurrent[T
 		ring:                     make([]T, opt.Capacity+1),
 		capacity:                 uint32(opt.Capacity),
 		head:                     atomic.Uint32{},
-		ringQueueConcurrentClose: newRingQueueConcurrentClose(),
+		ringQueueConcurrentClose: newRingQueueConcurrentClose(opt),
 	}
 }
 
 func (rq *ringQueueConcurrent[T]) Produce(item T) error {
-	for sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce); !sw.Closed(); {
+	return rq.produce(item, rq.Nonblocking)
+}
+
+func (rq *ringQueueConcurrent[T]) produce(item T, nonblocking bool) error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	for !sw.Closed() {
 		tail := rq.tail.Load()
 		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
 			sw.Once()
@@ -325,20 +866,21 @@ func (rq *ringQueueConcurrent[T]) Produce(item T) error {
 			return io.ErrClosedPipe
 		}
 		if (tail+1)&rq.capacity == rq.head.Load() {
-			if rq.Nonblocking {
+			if nonblocking {
 				break
 			}
-			sw.Once()
+			w.once()
 			continue
 		}
 		newTailStatus, newTailVal := (tail|ringQueueStatusWriting)&ringQueueTailStatusMask, (tail+1)&rq.capacity
 		if swapped := rq.tail.CompareAndSwap(tail, newTailStatus|newTailVal); !swapped {
-			sw.OnceWithLevel(spinWaitLevelAtomic)
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
 			continue
 		}
 		rq.ring[tail&ringQueueTailValueMask] = item
 		newTailStatus &= ringQueueTailStatusMask ^ ringQueueStatusWriting
 		rq.tail.Store(newTailStatus | newTailVal)
+		rq.waits.produced()
 
 		return nil
 	}
@@ -347,16 +889,23 @@ func (rq *ringQueueConcurrent[T]) Produce(item T) error {
 }
 
 func (rq *ringQueueConcurrent[T]) Consume() (item T, err error) {
-	for sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); {
+	return rq.consume(rq.Nonblocking)
+}
+
+func (rq *ringQueueConcurrent[T]) consume(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for !sw.Closed() {
 		head, tail := rq.head.Load(), rq.tail.Load()
 		if head == tail&ringQueueTailValueMask {
 			if tail&ringQueueStatusClosed == ringQueueStatusClosed {
 				return item, io.EOF
 			}
-			if rq.Nonblocking {
+			if nonblocking {
 				return item, ErrTemporarilyUnavailable
 			}
-			sw.OnceWithLevel(SpinWaitLevelConsume)
+			w.once()
 			continue
 		}
 		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
@@ -365,9 +914,10 @@ func (rq *ringQueueConcurrent[T]) Consume() (item T, err error) {
 		}
 		item = rq.ring[head]
 		if swapped := rq.head.CompareAndSwap(head, (head+1)&rq.capacity); !swapped {
-			sw.OnceWithLevel(spinWaitLevelAtomic)
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
 			continue
 		}
+		rq.waits.consumed()
 
 		return item, nil
 	}
@@ -375,16 +925,140 @@ func (rq *ringQueueConcurrent[T]) Consume() (item T, err error) {
 	return
 }
 
+func (rq *ringQueueConcurrent[T]) Peek() (item T, err error) {
+	for sw := NewSpinWait().SetLevel(SpinWaitLevelConsume); ; sw.Once() {
+		head, tail := rq.head.Load(), rq.tail.Load()
+		if head == tail&ringQueueTailValueMask {
+			if tail&ringQueueStatusClosed == ringQueueStatusClosed {
+				return item, io.EOF
+			}
+			return item, ErrTemporarilyUnavailable
+		}
+		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			continue
+		}
+		item = rq.ring[head]
+		if rq.head.Load() != head {
+			continue
+		}
+		return item, nil
+	}
+}
+
+func (rq *ringQueueConcurrent[T]) Len() int {
+	return int((rq.tail.Load()&ringQueueTailValueMask - rq.head.Load()) & rq.capacity)
+}
+
+func (rq *ringQueueConcurrent[T]) Cap() int {
+	return int(rq.capacity)
+}
+
+func (rq *ringQueueConcurrent[T]) ProduceContext(ctx context.Context, item T) error {
+	return rq.waits.waitContext(ctx, rq.waits.notFull, func() error { return rq.produce(item, true) })
+}
+
+func (rq *ringQueueConcurrent[T]) ConsumeContext(ctx context.Context) (item T, err error) {
+	err = rq.waits.waitContext(ctx, rq.waits.notEmpty, func() (err error) {
+		item, err = rq.consume(true)
+		return
+	})
+	return
+}
+
+func (rq *ringQueueConcurrent[T]) ProduceMany(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	for !sw.Closed() {
+		tail := rq.tail.Load()
+		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			sw.Once()
+			continue
+		}
+		if tail&ringQueueStatusClosed == ringQueueStatusClosed {
+			return 0, io.ErrClosedPipe
+		}
+		tailVal := tail & ringQueueTailValueMask
+		free := rq.capacity - (tailVal-rq.head.Load())&rq.capacity
+		if free == 0 {
+			if rq.Nonblocking {
+				break
+			}
+			w.once()
+			continue
+		}
+		n = min(int(free), len(items))
+		newTailStatus, newTailVal := (tail|ringQueueStatusWriting)&ringQueueTailStatusMask, (tailVal+uint32(n))&rq.capacity
+		if swapped := rq.tail.CompareAndSwap(tail, newTailStatus|newTailVal); !swapped {
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
+			continue
+		}
+		for i := range n {
+			rq.ring[(tailVal+uint32(i))&rq.capacity] = items[i]
+		}
+		newTailStatus &= ringQueueTailStatusMask ^ ringQueueStatusWriting
+		rq.tail.Store(newTailStatus | newTailVal)
+		rq.waits.produced()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
+func (rq *ringQueueConcurrent[T]) ConsumeMany(dst []T) (n int, err error) {
+	if len(dst) < 1 {
+		return 0, nil
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for !sw.Closed() {
+		head, tail := rq.head.Load(), rq.tail.Load()
+		if head == tail&ringQueueTailValueMask {
+			if tail&ringQueueStatusClosed == ringQueueStatusClosed {
+				return 0, io.EOF
+			}
+			if rq.Nonblocking {
+				return 0, ErrTemporarilyUnavailable
+			}
+			w.once()
+			continue
+		}
+		if tail&ringQueueStatusWriting == ringQueueStatusWriting {
+			sw.OnceWithLevel(SpinWaitLevelConsume)
+			continue
+		}
+		n = min(int((tail&ringQueueTailValueMask-head)&rq.capacity), len(dst))
+		for i := range n {
+			dst[i] = rq.ring[(head+uint32(i))&rq.capacity]
+		}
+		if swapped := rq.head.CompareAndSwap(head, (head+uint32(n))&rq.capacity); !swapped {
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
+			continue
+		}
+		rq.waits.consumed()
+
+		return n, nil
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
+
 type ringQueueConcurrentClose struct {
-	tail atomic.Uint32
+	tail  atomic.Uint32
+	waits *ringQueueWaits
 }
 
-func newRingQueueConcurrentClose() *ringQueueConcurrentClose {
-	return &ringQueueConcurrentClose{tail: atomic.Uint32{}}
+func newRingQueueConcurrentClose(opt *RingQueueOptions) *ringQueueConcurrentClose {
+	return &ringQueueConcurrentClose{tail: atomic.Uint32{}, waits: newRingQueueWaits(opt)}
 }
 
 func (rq *ringQueueConcurrentClose) Close() error {
-	for sw := NewParamSpinWait().SetLevel(spinWaitLevelProduce); !sw.Closed(); {
+	for sw := NewSpinWait().SetLevel(SpinWaitLevelProduce); !sw.Closed(); {
 		tail := rq.tail.Load()
 		if tail&ringQueueStatusClosed == ringQueueStatusClosed {
 			return nil
@@ -394,9 +1068,10 @@ func (rq *ringQueueConcurrentClose) Close() error {
 			continue
 		}
 		if swapped := rq.tail.CompareAndSwap(tail, tail|ringQueueStatusClosed); !swapped {
-			sw.OnceWithLevel(spinWaitLevelAtomic)
+			sw.OnceWithLevel(SpinWaitLevelAtomic)
 			continue
 		}
+		rq.waits.closed()
 
 		break
 	}
@@ -5,11 +5,15 @@
 package sox_test
 
 import (
+	"context"
+	"fmt"
 	"hybscloud.com/sox"
 	"io"
 	"math"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNewRingQueue(t *testing.T) {
@@ -431,6 +435,401 @@ func TestRingQueue_Concurrent(t *testing.T) {
 	})
 }
 
+func TestRingQueue_Batch(t *testing.T) {
+	modes := []struct {
+		name                                 string
+		concurrentProduce, concurrentConsume bool
+	}{
+		{"series", false, false},
+		{"concurrent produce", true, false},
+		{"concurrent consume", false, true},
+		{"concurrent", true, true},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name+" nonblocking", func(t *testing.T) {
+			c, p, err := sox.NewRingQueue[int](func(options *sox.RingQueueOptions) {
+				options.Capacity = 0x7
+				options.ConcurrentProduce = mode.concurrentProduce
+				options.ConcurrentConsume = mode.concurrentConsume
+				options.Nonblocking = true
+			})
+			if err != nil {
+				t.Errorf("ring queue new: %v", err)
+				return
+			}
+			bp, bc := p.(sox.ItemBatchProducer[int]), c.(sox.ItemBatchConsumer[int])
+			dst := make([]int, 16)
+			n, err := bc.ConsumeMany(dst)
+			if n != 0 || err != sox.ErrTemporarilyUnavailable {
+				t.Errorf("ring consume many expected ErrTemporarilyUnavailable but got %d %v", n, err)
+				return
+			}
+			n, err = bp.ProduceMany([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+			if n != 7 || err != nil {
+				t.Errorf("ring produce many expected 7 items but got %d %v", n, err)
+				return
+			}
+			n, err = bp.ProduceMany([]int{8})
+			if n != 0 || err != sox.ErrTemporarilyUnavailable {
+				t.Errorf("ring produce many expected ErrTemporarilyUnavailable but got %d %v", n, err)
+				return
+			}
+			n, err = bc.ConsumeMany(dst[:3])
+			if n != 3 || err != nil || dst[0] != 1 || dst[2] != 3 {
+				t.Errorf("ring consume many expected [1 2 3] but got %v %v", dst[:n], err)
+				return
+			}
+			n, err = bp.ProduceMany([]int{8, 9, 10, 11})
+			if n != 3 || err != nil {
+				t.Errorf("ring produce many expected 3 items but got %d %v", n, err)
+				return
+			}
+			err = p.Close()
+			if err != nil {
+				t.Errorf("ring producer close: %v", err)
+				return
+			}
+			n, err = bc.ConsumeMany(dst)
+			if n != 7 || err != nil || dst[0] != 4 || dst[6] != 10 {
+				t.Errorf("ring consume many expected [4 ... 10] but got %v %v", dst[:n], err)
+				return
+			}
+			n, err = bc.ConsumeMany(dst)
+			if n != 0 || err != io.EOF {
+				t.Errorf("ring consume many expected %v but got %d %v", io.EOF, n, err)
+				return
+			}
+		})
+	}
+
+	t.Run("concurrent produce and consume many", func(t *testing.T) {
+		const pNum, cNum, num, batch = 8, 8, 0x1000, 0x10
+		c, p, err := sox.NewRingQueue[int64](func(options *sox.RingQueueOptions) {
+			options.Capacity = 0xff
+		})
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		bp, bc := p.(sox.ItemBatchProducer[int64]), c.(sox.ItemBatchConsumer[int64])
+		for i := range pNum {
+			go func(i int) {
+				items := make([]int64, batch)
+				for j := 0; j < num; j += batch {
+					for k := range batch {
+						items[k] = int64(i<<32) | int64(j+k)
+					}
+					for s := items; len(s) > 0; {
+						n, err := bp.ProduceMany(s)
+						if err != nil {
+							t.Errorf("ring produce many: %v", err)
+							return
+						}
+						s = s[n:]
+					}
+				}
+			}(i)
+		}
+		wg, total := sync.WaitGroup{}, atomic.Int64{}
+		for range cNum {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dst := make([]int64, batch)
+				for total.Load() < pNum*num {
+					n, err := bc.ConsumeMany(dst)
+					if err == io.EOF {
+						return
+					}
+					if err != nil {
+						t.Errorf("ring consume many: %v", err)
+						return
+					}
+					if total.Add(int64(n)) >= pNum*num {
+						_ = p.Close()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if total.Load() != pNum*num {
+			t.Errorf("ring consume many expected %d items but got %d", pNum*num, total.Load())
+			return
+		}
+	})
+}
+
+func TestRingQueue_Context(t *testing.T) {
+	modes := []struct {
+		name                                 string
+		concurrentProduce, concurrentConsume bool
+	}{
+		{"series", false, false},
+		{"concurrent produce", true, false},
+		{"concurrent consume", false, true},
+		{"concurrent", true, true},
+	}
+	for _, strategy := range []sox.WaitStrategy{sox.WaitStrategySpin, sox.WaitStrategyPark} {
+		for _, mode := range modes {
+			name := fmt.Sprintf("%s strategy %d", mode.name, strategy)
+			t.Run(name, func(t *testing.T) {
+				c, p, err := sox.NewRingQueue[int](func(options *sox.RingQueueOptions) {
+					options.Capacity = 0x1
+					options.ConcurrentProduce = mode.concurrentProduce
+					options.ConcurrentConsume = mode.concurrentConsume
+					options.WaitStrategy = strategy
+				})
+				if err != nil {
+					t.Errorf("ring queue new: %v", err)
+					return
+				}
+				cp, cc := p.(sox.ItemContextProducer[int]), c.(sox.ItemContextConsumer[int])
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				item, err := cc.ConsumeContext(ctx)
+				if err != context.DeadlineExceeded {
+					t.Errorf("ring consume context expected %v but got %v %v", context.DeadlineExceeded, item, err)
+					return
+				}
+				err = cp.ProduceContext(context.Background(), 1)
+				if err != nil {
+					t.Errorf("ring produce context: %v", err)
+					return
+				}
+				ctx, cancel = context.WithCancel(context.Background())
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					cancel()
+				}()
+				err = cp.ProduceContext(ctx, 2)
+				if err != context.Canceled {
+					t.Errorf("ring produce context expected %v but got %v", context.Canceled, err)
+					return
+				}
+				item, err = cc.ConsumeContext(context.Background())
+				if err != nil || item != 1 {
+					t.Errorf("ring consume context expected %d but got %v %v", 1, item, err)
+					return
+				}
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					_ = p.Produce(3)
+				}()
+				item, err = cc.ConsumeContext(context.Background())
+				if err != nil || item != 3 {
+					t.Errorf("ring consume context expected %d but got %v %v", 3, item, err)
+					return
+				}
+			})
+		}
+	}
+}
+
+func TestRingQueue_Peek(t *testing.T) {
+	modes := []struct {
+		name                                 string
+		concurrentProduce, concurrentConsume bool
+	}{
+		{"series", false, false},
+		{"concurrent produce", true, false},
+		{"concurrent consume", false, true},
+		{"concurrent", true, true},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			c, p, err := sox.NewRingQueue[int](func(options *sox.RingQueueOptions) {
+				options.Capacity = 0x7
+				options.ConcurrentProduce = mode.concurrentProduce
+				options.ConcurrentConsume = mode.concurrentConsume
+				options.Nonblocking = true
+			})
+			if err != nil {
+				t.Errorf("ring queue new: %v", err)
+				return
+			}
+			pk := c.(sox.ItemPeeker[int])
+			if pk.Cap() != 7 || pk.Len() != 0 {
+				t.Errorf("ring queue expected len=0 cap=7 but got len=%d cap=%d", pk.Len(), pk.Cap())
+				return
+			}
+			item, err := pk.Peek()
+			if err != sox.ErrTemporarilyUnavailable {
+				t.Errorf("ring queue peek expected ErrTemporarilyUnavailable but got %v %v", item, err)
+				return
+			}
+			for i := range 3 {
+				err = p.Produce(i + 1)
+				if err != nil {
+					t.Errorf("ring producer produce: %v", err)
+					return
+				}
+			}
+			item, err = pk.Peek()
+			if err != nil || item != 1 || pk.Len() != 3 {
+				t.Errorf("ring queue peek expected 1 with len=3 but got %v len=%d %v", item, pk.Len(), err)
+				return
+			}
+			item, err = c.Consume()
+			if err != nil || item != 1 {
+				t.Errorf("ring consumer consume expected 1 but got %v %v", item, err)
+				return
+			}
+			item, err = pk.Peek()
+			if err != nil || item != 2 || pk.Len() != 2 {
+				t.Errorf("ring queue peek expected 2 with len=2 but got %v len=%d %v", item, pk.Len(), err)
+				return
+			}
+			_ = p.Close()
+			for range 2 {
+				_, _ = c.Consume()
+			}
+			item, err = pk.Peek()
+			if err != io.EOF {
+				t.Errorf("ring queue peek expected %v but got %v %v", io.EOF, item, err)
+				return
+			}
+		})
+	}
+}
+
+func TestRingQueue_ProduceBeforeClose(t *testing.T) {
+	modes := []struct {
+		name                                 string
+		concurrentProduce, concurrentConsume bool
+	}{
+		{"series", false, false},
+		{"concurrent produce", true, false},
+		{"concurrent consume", false, true},
+		{"concurrent", true, true},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			for range 64 {
+				c, p, err := sox.NewRingQueue[int](func(options *sox.RingQueueOptions) {
+					options.Capacity = 0xf
+					options.ConcurrentProduce = mode.concurrentProduce
+					options.ConcurrentConsume = mode.concurrentConsume
+				})
+				if err != nil {
+					t.Errorf("ring queue new: %v", err)
+					return
+				}
+				const n = 100
+				go func() {
+					for i := range n {
+						_ = p.Produce(i)
+					}
+					_ = p.Close()
+				}()
+				for i := range n {
+					item, err := c.Consume()
+					if err != nil || item != i {
+						t.Errorf("ring consumer consume expected %d but got %v %v", i, item, err)
+						return
+					}
+				}
+				item, err := c.Consume()
+				if err != io.EOF {
+					t.Errorf("ring consumer consume expected %v but got %v %v", io.EOF, item, err)
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestRingQueue_WaitStrategy(t *testing.T) {
+	newQueue := func(concurrentProduce, concurrentConsume bool, strategy sox.WaitStrategy) (sox.ItemConsumer[int64], sox.ItemProducer[int64], error) {
+		return sox.NewRingQueue[int64](func(options *sox.RingQueueOptions) {
+			options.Capacity = 0xf
+			options.ConcurrentProduce = concurrentProduce
+			options.ConcurrentConsume = concurrentConsume
+			options.WaitStrategy = strategy
+		})
+	}
+
+	t.Run("park series", func(t *testing.T) {
+		c, p, err := newQueue(false, false, sox.WaitStrategyPark)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrent(t, c, p, 1, 1, 0x2000)
+	})
+
+	t.Run("park concurrent produce", func(t *testing.T) {
+		c, p, err := newQueue(true, false, sox.WaitStrategyPark)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrentProduce(t, c, p, 0x10, 0x400)
+	})
+
+	t.Run("park concurrent consume", func(t *testing.T) {
+		c, p, err := newQueue(false, true, sox.WaitStrategyPark)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrentConsume(t, c, p, 0x10, 0x400)
+	})
+
+	t.Run("park concurrent", func(t *testing.T) {
+		c, p, err := newQueue(true, true, sox.WaitStrategyPark)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrent(t, c, p, 0x10, 0x10, 0x400)
+	})
+
+	t.Run("yield concurrent", func(t *testing.T) {
+		c, p, err := newQueue(true, true, sox.WaitStrategyYield)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrent(t, c, p, 0x10, 0x10, 0x400)
+	})
+
+	t.Run("spin then yield concurrent", func(t *testing.T) {
+		c, p, err := newQueue(true, true, sox.WaitStrategySpinThenYield)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrent(t, c, p, 0x10, 0x10, 0x400)
+	})
+
+	t.Run("spin then sleep concurrent", func(t *testing.T) {
+		c, p, err := newQueue(true, true, sox.WaitStrategySpinThenSleep)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		testRingQueueConcurrent(t, c, p, 0x10, 0x10, 0x400)
+	})
+
+	t.Run("park close wakes consumer", func(t *testing.T) {
+		c, p, err := newQueue(true, true, sox.WaitStrategyPark)
+		if err != nil {
+			t.Errorf("ring queue new: %v", err)
+			return
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = p.Close()
+		}()
+		item, err := c.Consume()
+		if err != io.EOF {
+			t.Errorf("ring consumer consume expected %v but got %v %v", io.EOF, item, err)
+			return
+		}
+	})
+}
+
 func BenchmarkRingQueue_Concurrent(b *testing.B) {
 	b.Run("1 producer 1 consumer", func(b *testing.B) {
 		c, p, err := sox.NewRingQueue[int](func(options *sox.RingQueueOptions) {
@@ -0,0 +1,437 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRPCClosed will be returned when the RPCClient has been closed
+	ErrRPCClosed = errors.New("rpc client closed")
+	// ErrRPCReplied will be returned when a request has already been replied
+	ErrRPCReplied = errors.New("rpc request replied")
+)
+
+//
+// Each frame of the RPC mode is a message, which begins with the header:
+//
+// | type (1) | method id (4) | correlation id (8) | payload ... |
+//
+// The client sends request frames with a correlation id which is unique
+// among its pending calls, and the server replies a response frame or an
+// error frame, whose payload is the error message, with the same method id
+// and correlation id. The responses may be replied in any order
+//
+
+const (
+	rpcFrameRequest  = 1
+	rpcFrameResponse = 2
+	rpcFrameError    = 3
+
+	rpcHeaderLength = 1 + 4 + 8
+)
+
+// RPCError is the error replied by the server of an RPC
+type RPCError struct {
+	Method uint32
+	Msg    string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc method %d: %s", e.Method, e.Msg)
+}
+
+// RPCOptions holds the options of RPCClients and RPCRouter.ServeConn
+type RPCOptions struct {
+	// Timeout is the timeout of the calls of Call. A Timeout of zero indicates
+	// that the calls never time out. The default Timeout is 30 seconds
+	Timeout time.Duration
+	// MessageOptions are applied to the message reader and writer of the connection
+	// The Nonblock option is ignored, since the frames are exchanged synchronously
+	MessageOptions []func(options *MessageOptions)
+}
+
+var defaultRPCOptions = RPCOptions{
+	Timeout: 30 * time.Second,
+}
+
+func newRPCOptions(opts []func(options *RPCOptions)) RPCOptions {
+	opt := defaultRPCOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	opt.MessageOptions = append(opt.MessageOptions[:len(opt.MessageOptions):len(opt.MessageOptions)], func(options *MessageOptions) {
+		options.Nonblock = false
+	})
+	return opt
+}
+
+// RPCClient calls the methods of the server on the other side of a connection
+// All of its methods are safe to be called from any goroutine. The responses
+// are read by a goroutine which NewRPCClient starts until the connection is
+// broken or the client is closed
+type RPCClient struct {
+	opt  RPCOptions
+	conn io.ReadWriter
+	rd   io.Reader
+
+	wmu sync.Mutex
+	wr  io.Writer
+
+	mu    sync.Mutex
+	next  uint64
+	calls map[uint64]*RPCFuture
+	err   error
+	// closeOnce closes the connection on the first Close, even if the
+	// client has been shut down by the broken connection before
+	closeOnce sync.Once
+}
+
+// RPCFuture is the pending result of a call
+type RPCFuture struct {
+	id     uint64
+	method uint32
+	done   chan struct{}
+	timer  *time.Timer
+	resp   []byte
+	err    error
+}
+
+// NewRPCClient creates and returns a new RPCClient over the connection conn
+func NewRPCClient(conn io.ReadWriter, opts ...func(options *RPCOptions)) *RPCClient {
+	opt := newRPCOptions(opts)
+	c := &RPCClient{
+		opt:   opt,
+		conn:  conn,
+		rd:    NewMessageReader(conn, opt.MessageOptions...),
+		wr:    NewMessageWriter(conn, opt.MessageOptions...),
+		calls: make(map[uint64]*RPCFuture),
+	}
+	goLabeled(ProfileLabelReader, c.readLoop)
+
+	return c
+}
+
+// Call sends the request to the method, and returns the future of the response
+// The future fails with ErrTimedOut if no response arrives within the Timeout
+func (c *RPCClient) Call(method uint32, request []byte) *RPCFuture {
+	return c.CallTimeout(method, request, c.opt.Timeout)
+}
+
+// CallTimeout is like Call but with the timeout instead of the Timeout option
+func (c *RPCClient) CallTimeout(method uint32, request []byte, timeout time.Duration) *RPCFuture {
+	f := &RPCFuture{method: method, done: make(chan struct{})}
+	c.mu.Lock()
+	if c.err != nil {
+		f.err = c.err
+		c.mu.Unlock()
+		close(f.done)
+		return f
+	}
+	c.next++
+	f.id = c.next
+	c.calls[f.id] = f
+	if timeout > 0 {
+		f.timer = time.AfterFunc(timeout, func() {
+			c.complete(f.id, nil, ErrTimedOut)
+		})
+	}
+	c.mu.Unlock()
+
+	err := writeRPCFrame(&c.wmu, c.wr, rpcFrameRequest, method, f.id, request)
+	if err != nil {
+		c.complete(f.id, nil, err)
+	}
+	return f
+}
+
+// NumPending returns the number of the calls waiting for the responses
+func (c *RPCClient) NumPending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.calls)
+}
+
+// Close fails the pending calls with ErrRPCClosed, and closes the
+// connection if it implements io.Closer
+func (c *RPCClient) Close() (err error) {
+	c.shutdown(ErrRPCClosed)
+	c.closeOnce.Do(func() {
+		if closer, ok := c.conn.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
+
+func (c *RPCClient) readLoop() {
+	lr, _ := c.rd.(MessageLeaseReader)
+	for {
+		lease, err := lr.ReadLease()
+		if err != nil {
+			if err == io.EOF {
+				err = ErrRPCClosed
+			}
+			c.shutdown(err)
+			return
+		}
+		b := lease.Bytes()
+		if len(b) < rpcHeaderLength || (b[0] != rpcFrameResponse && b[0] != rpcFrameError) {
+			lease.Release()
+			c.shutdown(ErrMsgProtocol)
+			return
+		}
+		method, id := binary.BigEndian.Uint32(b[1:]), binary.BigEndian.Uint64(b[5:])
+		if b[0] == rpcFrameError {
+			c.complete(id, nil, &RPCError{Method: method, Msg: string(b[rpcHeaderLength:])})
+		} else {
+			c.complete(id, bytes.Clone(b[rpcHeaderLength:]), nil)
+		}
+		lease.Release()
+	}
+}
+
+// complete completes the pending call of id with the response or the error
+// The responses of the calls which have already completed are dropped
+func (c *RPCClient) complete(id uint64, resp []byte, err error) {
+	c.mu.Lock()
+	f, ok := c.calls[id]
+	delete(c.calls, id)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.resp, f.err = resp, err
+	close(f.done)
+}
+
+// shutdown fails the pending calls with err and reports whether it is the
+// first shutdown
+func (c *RPCClient) shutdown(err error) bool {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return false
+	}
+	c.err = err
+	calls := c.calls
+	c.calls = make(map[uint64]*RPCFuture)
+	c.mu.Unlock()
+	for _, f := range calls {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		f.err = err
+		close(f.done)
+	}
+	return true
+}
+
+// ID returns the correlation id of the call
+func (f *RPCFuture) ID() uint64 {
+	return f.id
+}
+
+// Method returns the method id of the call
+func (f *RPCFuture) Method() uint32 {
+	return f.method
+}
+
+// Done returns a channel which is closed when the call has completed
+func (f *RPCFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits until the call has completed and returns the response
+func (f *RPCFuture) Wait() (response []byte, err error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// WaitContext is like Wait but returns ctx.Err() if the ctx is done first
+// The call is still pending after WaitContext has returned on the ctx
+func (f *RPCFuture) WaitContext(ctx context.Context) (response []byte, err error) {
+	select {
+	case <-f.done:
+		return f.resp, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// RPCRouter is a MessageHandler which serves the request frames of the RPC mode
+// It routes each request to the MessageHandler of its method id, with an
+// RPCRequest as the request and an RPCReply as the reply. The handler replies
+// the response by writing it to the RPCReply once, or an error by WriteError
+// An empty response is replied if the handler returns without replying
+type RPCRouter struct {
+	mu      sync.RWMutex
+	methods map[uint32]MessageHandler
+}
+
+// RPCRequest is the request of an RPC, which is passed to the MessageHandler
+// of the method as the request PollReader
+type RPCRequest struct {
+	*bytes.Reader
+	fd     int
+	Method uint32
+	ID     uint64
+}
+
+// Fd returns the file descriptor of the connection
+func (r *RPCRequest) Fd() int {
+	return r.fd
+}
+
+// RPCReply is the reply of an RPC, which is passed to the MessageHandler
+// of the method as the reply PollWriter
+type RPCReply struct {
+	w       PollWriter
+	method  uint32
+	id      uint64
+	replied bool
+}
+
+// Fd returns the file descriptor of the connection
+func (r *RPCReply) Fd() int {
+	return r.w.Fd()
+}
+
+// Write replies p as the response. It returns ErrRPCReplied if the
+// request has already been replied
+func (r *RPCReply) Write(p []byte) (n int, err error) {
+	err = r.reply(rpcFrameResponse, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteError replies err as the error of the request, which fails the call
+// with an *RPCError. It returns ErrRPCReplied if the request has already been replied
+func (r *RPCReply) WriteError(err error) error {
+	return r.reply(rpcFrameError, []byte(err.Error()))
+}
+
+func (r *RPCReply) reply(typ byte, p []byte) error {
+	if r.replied {
+		return ErrRPCReplied
+	}
+	r.replied = true
+	return writeRPCFrame(nil, r.w, typ, r.method, r.id, p)
+}
+
+// NewRPCRouter creates and returns a new RPCRouter without any method
+func NewRPCRouter() *RPCRouter {
+	return &RPCRouter{methods: make(map[uint32]MessageHandler)}
+}
+
+// Handle registers the handler of the method. A nil handler removes the method
+func (r *RPCRouter) Handle(method uint32, handler MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if handler == nil {
+		delete(r.methods, method)
+		return
+	}
+	r.methods[method] = handler
+}
+
+// ServeMessage reads a request frame from the request, and serves it with the
+// handler of its method. The request is read with ReadLease if it implements
+// MessageLeaseReader, or else with one Read of at most BufferSizeLarge bytes
+func (r *RPCRouter) ServeMessage(ctx context.Context, reply PollWriter, request PollReader) {
+	lease, err := readRequest(request)
+	if err != nil {
+		return
+	}
+	r.serve(ctx, reply, request.Fd(), lease.Bytes())
+	lease.Release()
+}
+
+// ServeConn serves the request frames read from conn one by one until conn
+// is broken or the ctx is done. It returns nil when conn reaches io.EOF
+func (r *RPCRouter) ServeConn(ctx context.Context, conn io.ReadWriter, opts ...func(options *RPCOptions)) error {
+	opt := newRPCOptions(opts)
+	rd := NewMessageReader(conn, opt.MessageOptions...).(MessageLeaseReader)
+	fd := -1
+	if pf, ok := conn.(pollFd); ok {
+		fd = pf.Fd()
+	}
+	reply := &rpcConnWriter{fd: fd, Writer: NewMessageWriter(conn, opt.MessageOptions...)}
+	for ctx.Err() == nil {
+		lease, err := rd.ReadLease()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.serve(ctx, reply, fd, lease.Bytes())
+		lease.Release()
+	}
+	return ctx.Err()
+}
+
+func (r *RPCRouter) serve(ctx context.Context, reply PollWriter, fd int, b []byte) {
+	if len(b) < rpcHeaderLength || b[0] != rpcFrameRequest {
+		return
+	}
+	req := &RPCRequest{
+		Reader: bytes.NewReader(b[rpcHeaderLength:]),
+		fd:     fd,
+		Method: binary.BigEndian.Uint32(b[1:]),
+		ID:     binary.BigEndian.Uint64(b[5:]),
+	}
+	rep := &RPCReply{w: reply, method: req.Method, id: req.ID}
+	r.mu.RLock()
+	handler, ok := r.methods[req.Method]
+	r.mu.RUnlock()
+	if !ok {
+		_ = rep.WriteError(errors.New("unknown method"))
+		return
+	}
+	handler.ServeMessage(ctx, rep, req)
+	if !rep.replied {
+		_, _ = rep.Write(nil)
+	}
+}
+
+type rpcConnWriter struct {
+	io.Writer
+	fd int
+}
+
+func (w *rpcConnWriter) Fd() int {
+	return w.fd
+}
+
+// writeRPCFrame writes the frame with one Write, holding mu unless it is nil
+func writeRPCFrame(mu *sync.Mutex, w io.Writer, typ byte, method uint32, id uint64, p []byte) error {
+	lease := DefaultBufferPool.Get(rpcHeaderLength + len(p))
+	defer lease.Release()
+	b := lease.Bytes()
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], method)
+	binary.BigEndian.PutUint64(b[5:], id)
+	copy(b[rpcHeaderLength:], p)
+	if mu != nil {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,155 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRPCHandler replies the request with the prefix, or the error
+type testRPCHandler struct {
+	prefix string
+	err    error
+	delay  time.Duration
+}
+
+func (h *testRPCHandler) ServeMessage(ctx context.Context, reply sox.PollWriter, request sox.PollReader) {
+	time.Sleep(h.delay)
+	if h.err != nil {
+		_ = reply.(*sox.RPCReply).WriteError(h.err)
+		return
+	}
+	if h.prefix == "" {
+		return
+	}
+	b, _ := io.ReadAll(request)
+	_, _ = reply.Write(append([]byte(h.prefix), b...))
+}
+
+func TestRPC(t *testing.T) {
+	cc, sc := net.Pipe()
+	router := sox.NewRPCRouter()
+	router.Handle(1, &testRPCHandler{prefix: "echo:"})
+	router.Handle(2, &testRPCHandler{err: errors.New("bad request")})
+	router.Handle(3, &testRPCHandler{delay: 200 * time.Millisecond})
+	router.Handle(4, &testRPCHandler{})
+	served := make(chan error, 1)
+	go func() {
+		served <- router.ServeConn(context.Background(), sc)
+	}()
+	client := sox.NewRPCClient(cc, func(options *sox.RPCOptions) {
+		options.Timeout = 5 * time.Second
+	})
+
+	t.Run("call", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for i := range 64 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Call(1, []byte(fmt.Sprint(i))).Wait()
+				if err != nil || string(resp) != fmt.Sprintf("echo:%d", i) {
+					t.Errorf("call expected echo:%d but got %q: %v", i, resp, err)
+				}
+			}()
+		}
+		wg.Wait()
+		resp, err := client.Call(4, []byte("empty")).Wait()
+		if err != nil || len(resp) != 0 {
+			t.Errorf("call expected empty response but got %q: %v", resp, err)
+			return
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := client.Call(2, nil).Wait()
+		rpcErr := &sox.RPCError{}
+		if !errors.As(err, &rpcErr) || rpcErr.Method != 2 || rpcErr.Msg != "bad request" {
+			t.Errorf("call expected bad request but got %v", err)
+			return
+		}
+		_, err = client.Call(5, nil).Wait()
+		if !errors.As(err, &rpcErr) || rpcErr.Method != 5 {
+			t.Errorf("call expected unknown method but got %v", err)
+			return
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, err := client.CallTimeout(3, nil, 10*time.Millisecond).Wait()
+		if err != sox.ErrTimedOut {
+			t.Errorf("call expected ErrTimedOut but got %v", err)
+			return
+		}
+		// the late response is dropped
+		resp, err := client.Call(1, []byte("next")).Wait()
+		if err != nil || string(resp) != "echo:next" {
+			t.Errorf("call expected echo:next but got %q: %v", resp, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		f := client.Call(3, nil)
+		if _, err = f.WaitContext(ctx); err != context.DeadlineExceeded {
+			t.Errorf("wait expected DeadlineExceeded but got %v", err)
+			return
+		}
+		if _, err = f.Wait(); err != nil {
+			t.Errorf("wait: %v", err)
+			return
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		f := client.Call(3, nil)
+		_ = client.Close()
+		if _, err := f.Wait(); err != sox.ErrRPCClosed {
+			t.Errorf("pending call expected ErrRPCClosed but got %v", err)
+			return
+		}
+		if _, err := client.Call(1, nil).Wait(); err != sox.ErrRPCClosed {
+			t.Errorf("call expected ErrRPCClosed but got %v", err)
+			return
+		}
+		if n := client.NumPending(); n != 0 {
+			t.Errorf("pending calls expected 0 but got %d", n)
+			return
+		}
+		select {
+		case <-served:
+		case <-time.After(5 * time.Second):
+			t.Errorf("serve expected to return after the client has been closed")
+		}
+	})
+}
+
+func TestRPCClient_ConnDropped(t *testing.T) {
+	c0, c1 := net.Pipe()
+	conn := &closeCountingConn{Conn: c0}
+	client := sox.NewRPCClient(conn)
+	go func() {
+		// the server reads the request and drops the connection
+		_, _ = c1.Read(make([]byte, 64))
+		_ = c1.Close()
+	}()
+	if _, err := client.Call(1, []byte("a")).Wait(); err == nil {
+		t.Errorf("call expected error")
+		return
+	}
+	_ = client.Close()
+	_ = client.Close()
+	if conn.closed.Load() != 1 {
+		t.Errorf("expected the connection to be closed once but got %d", conn.closed.Load())
+		return
+	}
+}
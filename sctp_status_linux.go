@@ -0,0 +1,163 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"encoding/binary"
+	"golang.org/x/sys/unix"
+	"net/netip"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+const (
+	SCTP_STATUS = 14
+)
+
+// SCTPState is the state of an SCTP association
+type SCTPState int32
+
+const (
+	SCTPStateEmpty SCTPState = iota
+	SCTPStateClosed
+	SCTPStateCookieWait
+	SCTPStateCookieEchoed
+	SCTPStateEstablished
+	SCTPStateShutdownPending
+	SCTPStateShutdownSent
+	SCTPStateShutdownReceived
+	SCTPStateShutdownAckSent
+)
+
+var sctpStateNames = [...]string{
+	SCTPStateEmpty:            "EMPTY",
+	SCTPStateClosed:           "CLOSED",
+	SCTPStateCookieWait:       "COOKIE-WAIT",
+	SCTPStateCookieEchoed:     "COOKIE-ECHOED",
+	SCTPStateEstablished:      "ESTAB",
+	SCTPStateShutdownPending:  "SHUTDOWN-PENDING",
+	SCTPStateShutdownSent:     "SHUTDOWN-SENT",
+	SCTPStateShutdownReceived: "SHUTDOWN-RECEIVED",
+	SCTPStateShutdownAckSent:  "SHUTDOWN-ACK-SENT",
+}
+
+// String returns the name of the state
+func (s SCTPState) String() string {
+	if s >= 0 && int(s) < len(sctpStateNames) {
+		return sctpStateNames[s]
+	}
+	return "UNKNOWN(" + strconv.Itoa(int(s)) + ")"
+}
+
+// SCTPPeerAddrState is the reachability state of a peer address of an SCTP association
+type SCTPPeerAddrState int32
+
+const (
+	SCTPPeerAddrInactive SCTPPeerAddrState = iota
+	SCTPPeerAddrPotentiallyFailed
+	SCTPPeerAddrActive
+	SCTPPeerAddrUnconfirmed
+	SCTPPeerAddrUnknown SCTPPeerAddrState = 0xffff
+)
+
+// SCTPPeerAddrInfo is the information of a peer address of an SCTP association
+type SCTPPeerAddrInfo struct {
+	Addr  netip.AddrPort
+	State SCTPPeerAddrState
+	// Cwnd is in bytes
+	Cwnd int
+	SRTT time.Duration
+	RTO  time.Duration
+	MTU  int
+}
+
+// SCTPStatus is the snapshot of the SCTP_STATUS of an SCTP association
+type SCTPStatus struct {
+	State SCTPState
+	// Rwnd is the receive window of the peer in bytes
+	Rwnd int
+	// UnackedData and PendingData are the numbers of the unacknowledged
+	// and the pending DATA chunks
+	UnackedData        int
+	PendingData        int
+	InStreams          int
+	OutStreams         int
+	FragmentationPoint int
+	// Primary is the primary peer address of the association
+	Primary SCTPPeerAddrInfo
+}
+
+type sctpPaddrInfo struct {
+	AssocID int32
+	Address [128]byte
+	State   int32
+	Cwnd    uint32
+	SRTT    uint32
+	RTO     uint32
+	MTU     uint32
+}
+
+type sctpStatus struct {
+	AssocID            int32
+	State              int32
+	Rwnd               uint32
+	UnackData          uint16
+	PendData           uint16
+	InStrms            uint16
+	OutStrms           uint16
+	FragmentationPoint uint32
+	Primary            sctpPaddrInfo
+}
+
+// Status returns the snapshot of the SCTP_STATUS of the association
+func (conn *SCTPConn) Status() (*SCTPStatus, error) {
+	st := sctpStatus{}
+	n := uint32(unsafe.Sizeof(st))
+	_, _, errno := unix.Syscall6(
+		unix.SYS_GETSOCKOPT,
+		uintptr(conn.fd),
+		SOL_SCTP,
+		SCTP_STATUS,
+		uintptr(unsafe.Pointer(&st)),
+		uintptr(unsafe.Pointer(&n)),
+		0)
+	if errno != 0 {
+		return nil, opError("getsockopt", conn.networkName("sctp"), conn.laddr, conn.raddr, errFromUnixErrno(errno))
+	}
+	status := &SCTPStatus{
+		State:              SCTPState(st.State),
+		Rwnd:               int(st.Rwnd),
+		UnackedData:        int(st.UnackData),
+		PendingData:        int(st.PendData),
+		InStreams:          int(st.InStrms),
+		OutStreams:         int(st.OutStrms),
+		FragmentationPoint: int(st.FragmentationPoint),
+		Primary: SCTPPeerAddrInfo{
+			Addr:  addrPortFromSockaddrStorage(st.Primary.Address[:]),
+			State: SCTPPeerAddrState(st.Primary.State),
+			Cwnd:  int(st.Primary.Cwnd),
+			SRTT:  time.Duration(st.Primary.SRTT) * time.Millisecond,
+			RTO:   time.Duration(st.Primary.RTO) * time.Millisecond,
+			MTU:   int(st.Primary.MTU),
+		},
+	}
+	return status, nil
+}
+
+// addrPortFromSockaddrStorage returns the address of the raw sockaddr_storage b
+func addrPortFromSockaddrStorage(b []byte) netip.AddrPort {
+	switch *(*uint16)(unsafe.Pointer(unsafe.SliceData(b))) {
+	case unix.AF_INET:
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[4:8])), binary.BigEndian.Uint16(b[2:4]))
+	case unix.AF_INET6:
+		scope := *(*uint32)(unsafe.Pointer(&b[24]))
+		addr := netip.AddrFrom16([16]byte(b[8:24])).WithZone(ip6ZoneName(scope))
+		return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(b[2:4]))
+	}
+	return netip.AddrPort{}
+}
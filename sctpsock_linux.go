@@ -9,6 +9,7 @@ package sox
 import (
 	"errors"
 	"golang.org/x/sys/unix"
+	"net/netip"
 	"time"
 )
 
@@ -84,6 +85,14 @@ func NewSCTPConn(localAddr Addr, remoteSock *SCTPSocket) (Conn, error) {
 	return &SCTPConn{SCTPSocket: remoteSock, laddr: sctpAddr, raddr: remoteAddr}, nil
 }
 
+func (conn *SCTPConn) Read(b []byte) (n int, err error) {
+	n, err = conn.SCTPSocket.Read(b)
+	return n, opError("read", conn.networkName("sctp"), conn.laddr, conn.raddr, err)
+}
+func (conn *SCTPConn) Write(b []byte) (n int, err error) {
+	n, err = conn.SCTPSocket.Write(b)
+	return n, opError("write", conn.networkName("sctp"), conn.laddr, conn.raddr, err)
+}
 func (conn *SCTPConn) LocalAddr() Addr {
 	return conn.laddr
 }
@@ -106,15 +115,30 @@ type SCTPListener struct {
 }
 
 func (l *SCTPListener) Accept() (Conn, error) {
-	nfd, sa, err := sctpAcceptWait(l)
+	return l.accept(func(int) (int, unix.Sockaddr, error) { return sctpAcceptWait(l) })
+}
+
+func (l *SCTPListener) acceptOnce() (Conn, error) {
+	return l.accept(acceptOnce)
+}
+
+func (l *SCTPListener) accept(accept4 func(fd int) (int, unix.Sockaddr, error)) (Conn, error) {
+	nfd, sa, err := accept4(l.fd)
 	if err != nil {
-		return nil, err
+		return nil, opError("accept", l.networkName("sctp"), nil, l.Addr(), err)
 	}
 
-	so := &SCTPSocket{socket: newSocket(l.network, nfd, sa)}
-	conn, err := NewSCTPConn(l.laddr, so)
+	// the listener may be bound to the wildcard address
+	lsa, rsa := sockname(nfd, nil), peername(nfd, sa)
+	laddr := l.Addr()
+	if lsa != nil {
+		laddr = SCTPAddrFromAddrPort(addrPortFromSockaddr(lsa))
+	}
+	so := &SCTPSocket{socket: newSocket(l.network, nfd, rsa)}
+	conn, err := NewSCTPConn(laddr, so)
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("accept", l.networkName("sctp"), nil, l.Addr(), err)
 	}
 	return conn, err
 }
@@ -135,17 +159,18 @@ func ListenSCTP4(laddr *SCTPAddr) (*SCTPListener, error) {
 	lsa := sctp4AddrToSockaddr(laddr)
 	so, err := newSCTPSocket(lsa)
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "sctp4", nil, laddr, err)
 	}
 	err = sctpBindx(so, lsa)
-	if err != nil {
-		return nil, err
+	if err == nil {
+		err = errFromUnixErrno(listen(so.fd))
 	}
-	err = unix.Listen(so.fd, defaultBacklog)
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "sctp4", nil, laddr, err)
 	}
 
+	laddr = SCTPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	lis := &SCTPListener{SCTPSocket: so, laddr: laddr}
 	return lis, nil
 }
@@ -154,20 +179,24 @@ func ListenSCTP6(laddr *SCTPAddr) (*SCTPListener, error) {
 	if laddr == nil {
 		return nil, InvalidAddrError("nil local address")
 	}
+	if err := zoneError(laddr); err != nil {
+		return nil, opError("listen", "sctp6", nil, laddr, err)
+	}
 	lsa := sctp6AddrToSockaddr(laddr)
 	so, err := newSCTPSocket(lsa)
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "sctp6", nil, laddr, err)
 	}
 	err = sctpBindx(so, lsa)
-	if err != nil {
-		return nil, err
+	if err == nil {
+		err = errFromUnixErrno(listen(so.fd))
 	}
-	err = unix.Listen(so.fd, defaultBacklog)
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "sctp6", nil, laddr, err)
 	}
 
+	laddr = SCTPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	lis := &SCTPListener{SCTPSocket: so, laddr: laddr}
 	return lis, nil
 }
@@ -182,11 +211,12 @@ func DialSCTP4(laddr *SCTPAddr, raddr *SCTPAddr) (*SCTPConn, error) {
 	lsa := sctp4AddrToSockaddr(laddr)
 	so, err := newSCTPSocket(lsa)
 	if err != nil {
-		return nil, err
+		return nil, opError("dial", "sctp4", laddr, raddr, err)
 	}
 	err = sctpBindx(so, lsa)
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("dial", "sctp4", laddr, raddr, err)
 	}
 	conn := &SCTPConn{
 		SCTPSocket: so,
@@ -195,8 +225,10 @@ func DialSCTP4(laddr *SCTPAddr, raddr *SCTPAddr) (*SCTPConn, error) {
 	}
 	err = sctpConnectx(so, sctp4AddrToSockaddr(raddr))
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("dial", "sctp4", laddr, raddr, err)
 	}
+	conn.laddr = SCTPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 
 	return conn, nil
 }
@@ -208,14 +240,18 @@ func DialSCTP6(laddr *SCTPAddr, raddr *SCTPAddr) (*SCTPConn, error) {
 	if raddr == nil {
 		return nil, &OpError{Op: "dial", Net: "sctp6", Source: laddr, Addr: nil, Err: errors.New("missing address")}
 	}
+	if err := zoneError(laddr, raddr); err != nil {
+		return nil, opError("dial", "sctp6", laddr, raddr, err)
+	}
 	lsa := sctp6AddrToSockaddr(laddr)
 	so, err := newSCTPSocket(lsa)
 	if err != nil {
-		return nil, err
+		return nil, opError("dial", "sctp6", laddr, raddr, err)
 	}
 	err = sctpBindx(so, lsa)
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("dial", "sctp6", laddr, raddr, err)
 	}
 	conn := &SCTPConn{
 		SCTPSocket: so,
@@ -224,12 +260,45 @@ func DialSCTP6(laddr *SCTPAddr, raddr *SCTPAddr) (*SCTPConn, error) {
 	}
 	err = sctpConnectx(so, sctp6AddrToSockaddr(raddr))
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("dial", "sctp6", laddr, raddr, err)
 	}
+	conn.laddr = SCTPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 
 	return conn, nil
 }
 
+// ListenSCTPAddrPort listens on laddr with ListenSCTP4 if laddr is an IPv4
+// or IPv4-mapped IPv6 address, and with ListenSCTP6 otherwise
+func ListenSCTPAddrPort(laddr netip.AddrPort) (*SCTPListener, error) {
+	if !laddr.IsValid() {
+		return nil, InvalidAddrError("invalid local address")
+	}
+	if laddr = unmapAddrPort(laddr); laddr.Addr().Is4() {
+		return ListenSCTP4(SCTPAddrFromAddrPort(laddr))
+	}
+	return ListenSCTP6(SCTPAddrFromAddrPort(laddr))
+}
+
+// DialSCTPAddrPort connects to raddr with DialSCTP4 if raddr is an IPv4
+// or IPv4-mapped IPv6 address, and with DialSCTP6 otherwise
+// The zero laddr means the loopback address
+func DialSCTPAddrPort(laddr, raddr netip.AddrPort) (*SCTPConn, error) {
+	if !raddr.IsValid() {
+		return nil, InvalidAddrError("invalid remote address")
+	}
+	if raddr = unmapAddrPort(raddr); raddr.Addr().Is4() {
+		if !laddr.IsValid() {
+			laddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)
+		}
+		return DialSCTP4(SCTPAddrFromAddrPort(unmapAddrPort(laddr)), SCTPAddrFromAddrPort(raddr))
+	}
+	if !laddr.IsValid() {
+		laddr = netip.AddrPortFrom(netip.IPv6Loopback(), 0)
+	}
+	return DialSCTP6(SCTPAddrFromAddrPort(laddr), SCTPAddrFromAddrPort(raddr))
+}
+
 func newSCTP4Socket() (fd int, err error) {
 	fd, err = unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
 	if err != nil {
@@ -267,7 +336,7 @@ func sctpBindx(so *SCTPSocket, sa unix.Sockaddr) error {
 }
 
 func sctpAcceptWait(lis *SCTPListener) (nfd int, sa unix.Sockaddr, err error) {
-	for sw := NewParamSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); sw.Once() {
+	for sw := NewSpinWait().SetLevel(SpinWaitLevelConsume); !sw.Closed(); sw.Once() {
 		nfd, sa, err = unix.Accept4(lis.fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
 		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
 			continue
@@ -296,7 +365,7 @@ func sctpConnectx(so *SCTPSocket, sa unix.Sockaddr) error {
 	if errno != unix.EINPROGRESS {
 		return errFromUnixErrno(errno)
 	}
-	for sw := NewParamSpinWait(); !sw.Closed(); sw.Once() {
+	for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
 		val, err := unix.GetsockoptInt(so.fd, unix.SOL_SOCKET, unix.SO_ERROR)
 		if err != nil {
 			return errFromUnixErrno(err)
@@ -0,0 +1,573 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSessionNotConnected will be returned when a session has no connection attached
+	ErrSessionNotConnected = errors.New("session not connected")
+	// ErrSessionExpired will be returned when the server does not know the session to resume
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSessionWindowExhausted will be returned by Write when the peer has
+	// not opened the flow control window for more messages
+	ErrSessionWindowExhausted = errors.New("session window exhausted")
+	// ErrSessionClosed will be returned by the Write blocked on the flow
+	// control window when the session is closed
+	ErrSessionClosed = errors.New("session closed")
+)
+
+//
+// Each frame of sessions is a message, which begins with the frame type:
+//
+// hello:   | type (1) | session id (16) | last received seq (8) | [window seq (8)] |
+// welcome: | type (1) | session id (16) | last received seq (8) | [window seq (8)] |
+// data:    | type (1) | seq (8) | payload ... |
+// ack:     | type (1) | seq (8) |
+// window:  | type (1) | window seq (8) |
+//
+// The client sends hello with a zero session id to start a new session, or
+// with the id of the session to resume. The server replies welcome with the
+// session id, or a zero session id if the session to resume is unknown. Both
+// sides then replay the data frames which the other side has not received
+//
+// The side with a flow control window appends the window seq to its hello or
+// welcome, which is the last seq of the data frames it accepts, and advertises
+// the window seq again with window frames as the messages are read. The other
+// side writes no data frame beyond the window seq. A hello or a welcome
+// without the window seq means no flow control
+//
+
+const (
+	sessionFrameHello   = 1
+	sessionFrameWelcome = 2
+	sessionFrameData    = 3
+	sessionFrameAck     = 4
+	sessionFrameWindow  = 5
+
+	sessionHelloLength       = 1 + SessionIDLength + 8
+	sessionHelloWindowLength = sessionHelloLength + 8
+	sessionHeaderLength      = 1 + 8
+)
+
+// SessionIDLength is the length of session ids in bytes
+const SessionIDLength = 16
+
+// DefaultSessionReadLimit is the ReadLimit of the connections of sessions
+// without a ReadLimit, which counts the header of the data frames as well
+const DefaultSessionReadLimit = 16 << 20
+
+// SessionID identifies a session across connections
+type SessionID [SessionIDLength]byte
+
+// String returns the hex encoding of the id
+func (id SessionID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero returns true if id is the zero id
+func (id SessionID) IsZero() bool {
+	return id == SessionID{}
+}
+
+// SessionOptions represents the options of sessions
+type SessionOptions struct {
+	// ReplayCapacity is the maximum number of unacknowledged outbound messages
+	// which are kept for the replay. Writing more messages returns
+	// ErrTemporarilyUnavailable until the peer has acknowledged them
+	ReplayCapacity int
+	// AckInterval is the number of received messages which are acknowledged at once
+	// An AckInterval larger than ReplayCapacity is reduced to ReplayCapacity,
+	// otherwise the writers of both sides would wait for the acknowledgments
+	AckInterval int
+	// Window is the number of the messages the peer may write ahead of the
+	// messages which have been read. The window is advertised to the peer and
+	// reopened as the messages are read. The default 0 means no flow control
+	Window int
+	// BlockOnWindow specifies whether Write blocks until the peer opens the
+	// window, or returns ErrSessionWindowExhausted at once when the window
+	// advertised by the peer is exhausted. Since the window advertisements are
+	// received by Read, Read has to be called concurrently with blocked Writes
+	BlockOnWindow bool
+	// IdleTimeout is how long a SessionServer keeps the sessions which have
+	// not been resumed, read or written. IdleTimeout <= 0 means no timeout
+	// The default IdleTimeout is 5 minutes
+	IdleTimeout time.Duration
+	// MaxSessions is the maximum number of the sessions a SessionServer keeps
+	// The least recently active session is forgotten to accept a new one
+	// MaxSessions <= 0 means no limit. The default MaxSessions is 4096
+	MaxSessions int
+	// MessageOptions are applied to the message reader and writer of connections
+	// The Nonblock option is ignored, since session frames are exchanged synchronously
+	// A zero ReadLimit means DefaultSessionReadLimit
+	MessageOptions []func(options *MessageOptions)
+}
+
+var defaultSessionOptions = SessionOptions{
+	ReplayCapacity: 256,
+	AckInterval:    16,
+	IdleTimeout:    5 * time.Minute,
+	MaxSessions:    4096,
+}
+
+// Session is a message stream which survives reconnections. Each outbound
+// message is numbered and kept until the peer acknowledges it, so that the
+// messages lost with a broken connection are replayed on the next one
+// Session implements io.ReadWriter; Read and Write may be called concurrently,
+// but Read must not be called concurrently with itself
+type Session struct {
+	id     SessionID
+	opt    SessionOptions
+	server *SessionServer
+	// active is the unix nanoseconds when the session was active last time
+	active atomic.Int64
+
+	rmu      sync.Mutex
+	rd       io.Reader
+	received uint64
+	unacked  int
+	lease    *BufferLease
+	// advertised is the window seq advertised to the peer last time
+	advertised uint64
+
+	wmu     sync.Mutex
+	wr      io.Writer
+	sent    uint64
+	acked   uint64
+	replay  [][]byte
+	head    int
+	pending int
+	// window is the window seq advertised by the peer, and windowed is
+	// whether the peer has advertised any. opened is signaled when the
+	// window is opened or the session is closed
+	window   uint64
+	windowed bool
+	opened   *sync.Cond
+	closed   bool
+}
+
+// NewSession creates and returns a new client side Session
+// The Session has to be connected with Connect before reading and writing
+func NewSession(opts ...func(options *SessionOptions)) *Session {
+	opt := defaultSessionOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	return newSession(SessionID{}, opt)
+}
+
+func newSession(id SessionID, opt SessionOptions) *Session {
+	opt.ReplayCapacity = max(1, opt.ReplayCapacity)
+	opt.AckInterval = min(max(1, opt.AckInterval), opt.ReplayCapacity)
+	opt.MessageOptions = append(opt.MessageOptions[:len(opt.MessageOptions):len(opt.MessageOptions)], func(options *MessageOptions) {
+		options.Nonblock = false
+		if options.ReadLimit < 1 {
+			options.ReadLimit = DefaultSessionReadLimit
+		}
+	})
+	opt.Window = max(0, opt.Window)
+	s := &Session{id: id, opt: opt, replay: make([][]byte, opt.ReplayCapacity)}
+	s.opened = sync.NewCond(&s.wmu)
+	s.touch()
+	return s
+}
+
+// touch marks the session as active now
+func (s *Session) touch() {
+	s.active.Store(time.Now().UnixNano())
+}
+
+// ID returns the session id, which is zero before the first Connect
+func (s *Session) ID() SessionID {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.id
+}
+
+// Connect performs the handshake on the connection rw, e.g. a new
+// connection after the previous one has been broken, and replays the
+// messages the server has not received. Connect returns ErrSessionExpired
+// if the server does not know the session any more
+func (s *Session) Connect(rw io.ReadWriter) error {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.detach()
+	s.closed = false
+
+	rd := NewMessageReader(rw, s.opt.MessageOptions...)
+	wr := NewMessageWriter(rw, s.opt.MessageOptions...)
+	_, err := wr.Write(sessionHello(sessionFrameHello, s.id, s.received, s.windowSeq()))
+	if err != nil {
+		return err
+	}
+	b := [sessionHelloWindowLength]byte{}
+	n, err := rd.Read(b[:])
+	if err != nil {
+		return err
+	}
+	id, acked, window, err := parseSessionHello(b[:n], sessionFrameWelcome)
+	if err != nil {
+		return err
+	}
+	if id.IsZero() || !s.id.IsZero() && id != s.id {
+		return ErrSessionExpired
+	}
+	s.id = id
+
+	return s.attach(rd, wr, acked, window)
+}
+
+// attach replays the messages after acked to the new connection, which
+// accepts the messages up to the window seq, or any if window is zero
+// The caller must hold both of the locks
+func (s *Session) attach(rd io.Reader, wr io.Writer, acked uint64, window uint64) error {
+	s.ack(acked)
+	s.windowed = false
+	if window > 0 {
+		s.openWindow(window)
+	} else {
+		// the Writes blocked on the window of the previous connection go on
+		s.opened.Broadcast()
+	}
+	s.advertised = s.windowSeq()
+	for i := range s.pending {
+		_, err := wr.Write(s.replay[(s.head+i)%len(s.replay)])
+		if err != nil {
+			return err
+		}
+	}
+	s.rd, s.wr, s.unacked = rd, wr, 0
+	s.touch()
+	return nil
+}
+
+// detach drops the connection. The caller must hold both of the locks
+func (s *Session) detach() {
+	s.rd, s.wr = nil, nil
+	if s.lease != nil {
+		s.lease.Release()
+		s.lease = nil
+	}
+}
+
+// Read reads the payload of the next message into p. The messages replayed
+// after reconnections are deduplicated. If p is too short, Read returns
+// io.ErrShortBuffer and the message can be read with a larger buffer
+func (s *Session) Read(p []byte) (n int, err error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	for {
+		if s.lease == nil {
+			if s.rd == nil {
+				return 0, ErrSessionNotConnected
+			}
+			s.lease, err = s.rd.(MessageLeaseReader).ReadLease()
+			if err != nil {
+				return 0, err
+			}
+		}
+		b := s.lease.Bytes()
+		if len(b) < sessionHeaderLength {
+			s.releaseLease()
+			return 0, ErrMsgProtocol
+		}
+		seq := binary.BigEndian.Uint64(b[1:])
+		switch b[0] {
+		case sessionFrameAck:
+			s.releaseLease()
+			s.wmu.Lock()
+			s.ack(seq)
+			s.wmu.Unlock()
+			continue
+		case sessionFrameWindow:
+			s.releaseLease()
+			s.wmu.Lock()
+			s.openWindow(seq)
+			s.wmu.Unlock()
+			continue
+		case sessionFrameData:
+		default:
+			s.releaseLease()
+			return 0, ErrMsgProtocol
+		}
+		if seq <= s.received {
+			// a replayed message which has been received
+			s.releaseLease()
+			continue
+		}
+		if seq != s.received+1 || s.opt.Window > 0 && seq > s.advertised {
+			s.releaseLease()
+			return 0, ErrMsgProtocol
+		}
+		if len(b)-sessionHeaderLength > len(p) {
+			return 0, io.ErrShortBuffer
+		}
+		n = copy(p, b[sessionHeaderLength:])
+		s.releaseLease()
+		s.touch()
+		s.received = seq
+		s.unacked++
+		if s.unacked >= s.opt.AckInterval {
+			s.unacked = 0
+			err = s.writeFrame(sessionFrameAck, seq)
+		}
+		// the window is reopened once half of it has been read
+		if err == nil && s.opt.Window > 0 && s.windowSeq()-s.advertised >= uint64(max(1, s.opt.Window/2)) {
+			s.advertised = s.windowSeq()
+			err = s.writeFrame(sessionFrameWindow, s.advertised)
+		}
+		return n, err
+	}
+}
+
+// windowSeq returns the last seq of the messages the session accepts, or
+// zero if the session has no flow control window. The caller must hold rmu
+func (s *Session) windowSeq() uint64 {
+	if s.opt.Window == 0 {
+		return 0
+	}
+	return s.received + uint64(s.opt.Window)
+}
+
+// openWindow opens the window of the peer up to seq. The caller must hold wmu
+func (s *Session) openWindow(seq uint64) {
+	if !s.windowed || seq > s.window {
+		s.window = seq
+	}
+	s.windowed = true
+	s.opened.Broadcast()
+}
+
+func (s *Session) releaseLease() {
+	s.lease.Release()
+	s.lease = nil
+}
+
+func (s *Session) writeFrame(typ byte, seq uint64) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.wr == nil {
+		return ErrSessionNotConnected
+	}
+	b := [sessionHeaderLength]byte{typ}
+	binary.BigEndian.PutUint64(b[1:], seq)
+	_, err := s.wr.Write(b[:])
+	return err
+}
+
+// Write writes p as a message, which is kept until the peer acknowledges it
+// If the connection is broken, the message is replayed on the next connection
+// When the flow control window of the peer is exhausted, Write blocks or
+// returns ErrSessionWindowExhausted depending on SessionOptions.BlockOnWindow
+func (s *Session) Write(p []byte) (n int, err error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.wr == nil && s.id.IsZero() && s.server == nil {
+		return 0, ErrSessionNotConnected
+	}
+	for s.windowed && s.sent >= s.window {
+		if s.closed {
+			return 0, ErrSessionClosed
+		}
+		if !s.opt.BlockOnWindow {
+			return 0, ErrSessionWindowExhausted
+		}
+		s.opened.Wait()
+	}
+	if s.pending == len(s.replay) {
+		return 0, ErrTemporarilyUnavailable
+	}
+	s.sent++
+	b := make([]byte, sessionHeaderLength+len(p))
+	b[0] = sessionFrameData
+	binary.BigEndian.PutUint64(b[1:], s.sent)
+	copy(b[sessionHeaderLength:], p)
+	s.replay[(s.head+s.pending)%len(s.replay)] = b
+	s.pending++
+	s.touch()
+	if s.wr == nil {
+		return len(p), nil
+	}
+	_, err = s.wr.Write(b)
+	if err != nil {
+		// the message will be replayed on the next connection
+		s.wr = nil
+		return len(p), nil
+	}
+	return len(p), nil
+}
+
+// ack drops the replay messages up to seq. The caller must hold wmu
+func (s *Session) ack(seq uint64) {
+	for s.pending > 0 && s.acked < seq {
+		s.replay[s.head] = nil
+		s.head = (s.head + 1) % len(s.replay)
+		s.pending--
+		s.acked++
+	}
+}
+
+// Close drops the connection and forgets the session on the server side
+// Close does not close the underlying connection
+func (s *Session) Close() error {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.detach()
+	s.closed = true
+	s.opened.Broadcast()
+	if s.server != nil {
+		s.server.forget(s.id)
+	}
+	return nil
+}
+
+// SessionServer accepts the sessions of clients and resumes them on reconnections
+// The sessions idle for SessionOptions.IdleTimeout, or beyond
+// SessionOptions.MaxSessions, are forgotten and can not be resumed any more
+type SessionServer struct {
+	opt      SessionOptions
+	mu       sync.Mutex
+	sessions map[SessionID]*Session
+}
+
+// NewSessionServer creates and returns a new SessionServer
+func NewSessionServer(opts ...func(options *SessionOptions)) *SessionServer {
+	opt := defaultSessionOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	return &SessionServer{opt: opt, sessions: make(map[SessionID]*Session)}
+}
+
+// Accept performs the handshake on the connection rw and returns the session
+// of the client. The resumed is true if the session has been resumed, and the
+// messages the client has not received have been replayed
+func (srv *SessionServer) Accept(rw io.ReadWriter) (s *Session, resumed bool, err error) {
+	rd := NewMessageReader(rw, srv.opt.MessageOptions...)
+	wr := NewMessageWriter(rw, srv.opt.MessageOptions...)
+	b := [sessionHelloWindowLength]byte{}
+	n, err := rd.Read(b[:])
+	if err != nil {
+		return nil, false, err
+	}
+	id, acked, window, err := parseSessionHello(b[:n], sessionFrameHello)
+	if err != nil {
+		return nil, false, err
+	}
+
+	srv.mu.Lock()
+	now := time.Now().UnixNano()
+	if id.IsZero() {
+		_, err = rand.Read(id[:])
+		if err == nil {
+			srv.evict(now)
+			s = newSession(id, srv.opt)
+			s.server = srv
+			srv.sessions[id] = s
+		}
+	} else {
+		s, resumed = srv.sessions[id]
+		if resumed && srv.idle(s, now) {
+			delete(srv.sessions, id)
+			s, resumed = nil, false
+		}
+	}
+	srv.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	if s == nil {
+		_, _ = wr.Write(sessionHello(sessionFrameWelcome, SessionID{}, 0, 0))
+		return nil, false, ErrSessionExpired
+	}
+
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.detach()
+	_, err = wr.Write(sessionHello(sessionFrameWelcome, id, s.received, s.windowSeq()))
+	if err != nil {
+		return nil, false, err
+	}
+	err = s.attach(rd, wr, acked, window)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return s, resumed, nil
+}
+
+// Len returns the number of the sessions known by the server
+func (srv *SessionServer) Len() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.sessions)
+}
+
+// evict forgets the idle sessions, and the least recently active ones until
+// a new session can be kept. The caller must hold mu
+func (srv *SessionServer) evict(now int64) {
+	for id, s := range srv.sessions {
+		if srv.idle(s, now) {
+			delete(srv.sessions, id)
+		}
+	}
+	for srv.opt.MaxSessions > 0 && len(srv.sessions) >= srv.opt.MaxSessions {
+		var lru *Session
+		for _, s := range srv.sessions {
+			if lru == nil || s.active.Load() < lru.active.Load() {
+				lru = s
+			}
+		}
+		delete(srv.sessions, lru.id)
+	}
+}
+
+func (srv *SessionServer) idle(s *Session, now int64) bool {
+	return srv.opt.IdleTimeout > 0 && now-s.active.Load() > int64(srv.opt.IdleTimeout)
+}
+
+func (srv *SessionServer) forget(id SessionID) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.sessions, id)
+}
+
+// sessionHello returns the hello or the welcome, which carries the window seq unless it is zero
+func sessionHello(typ byte, id SessionID, received uint64, window uint64) []byte {
+	b := make([]byte, sessionHelloLength, sessionHelloWindowLength)
+	b[0] = typ
+	copy(b[1:], id[:])
+	binary.BigEndian.PutUint64(b[1+SessionIDLength:], received)
+	if window > 0 {
+		b = binary.BigEndian.AppendUint64(b, window)
+	}
+	return b
+}
+
+func parseSessionHello(b []byte, typ byte) (id SessionID, received uint64, window uint64, err error) {
+	if len(b) != sessionHelloLength && len(b) != sessionHelloWindowLength || b[0] != typ {
+		return SessionID{}, 0, 0, ErrMsgProtocol
+	}
+	copy(id[:], b[1:])
+	if len(b) == sessionHelloWindowLength {
+		window = binary.BigEndian.Uint64(b[sessionHelloLength:])
+	}
+	return id, binary.BigEndian.Uint64(b[1+SessionIDLength:]), window, nil
+}
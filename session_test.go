@@ -0,0 +1,433 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"hybscloud.com/sox"
+	"net"
+	"testing"
+	"time"
+)
+
+// sessionConn connects the client session and accepts it with the server
+// on a new loopback connection, and returns the server side session
+func sessionConn(t *testing.T, l net.Listener, client *sox.Session, srv *sox.SessionServer) (s *sox.Session, resumed bool, cc net.Conn, sc net.Conn) {
+	type result struct {
+		s       *sox.Session
+		resumed bool
+		conn    net.Conn
+		err     error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		s, resumed, err := srv.Accept(conn)
+		ch <- result{s, resumed, conn, err}
+	}()
+	cc, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	err = client.Connect(cc)
+	r := <-ch
+	if err != nil || r.err != nil {
+		t.Fatalf("connect: %v, accept: %v", err, r.err)
+	}
+	return r.s, r.resumed, cc, r.conn
+}
+
+func TestSession_Resume(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	opt := func(options *sox.SessionOptions) {
+		options.AckInterval = 1
+		options.ReplayCapacity = 4
+	}
+	srv := sox.NewSessionServer(opt)
+	client := sox.NewSession(opt)
+
+	s, resumed, cc, sc := sessionConn(t, l, client, srv)
+	if resumed || s.ID() != client.ID() || client.ID().IsZero() {
+		t.Errorf("expected new session %v but got %v resumed %v", client.ID(), s.ID(), resumed)
+		return
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		_, err = client.Write([]byte(msg))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+	}
+	p := make([]byte, 16)
+	for _, msg := range []string{"a", "b"} {
+		n, err := s.Read(p)
+		if err != nil || string(p[:n]) != msg {
+			t.Errorf("read expected %q but got %q: %v", msg, p[:n], err)
+			return
+		}
+	}
+	_, err = s.Write([]byte("x"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	// the connection is broken, and "c" and "x" may be lost
+	_ = cc.Close()
+	_ = sc.Close()
+	_, err = client.Write([]byte("d"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+
+	s2, resumed, cc, sc := sessionConn(t, l, client, srv)
+	defer cc.Close()
+	defer sc.Close()
+	if !resumed || s2 != s {
+		t.Errorf("expected resumed session")
+		return
+	}
+	for _, msg := range []string{"c", "d"} {
+		n, err := s.Read(p)
+		if err != nil || string(p[:n]) != msg {
+			t.Errorf("read expected %q but got %q: %v", msg, p[:n], err)
+			return
+		}
+	}
+	n, err := client.Read(p)
+	if err != nil || string(p[:n]) != "x" {
+		t.Errorf("read expected %q but got %q: %v", "x", p[:n], err)
+		return
+	}
+
+	_ = s.Close()
+	if srv.Len() != 0 {
+		t.Errorf("expected no sessions but got %d", srv.Len())
+		return
+	}
+
+	// the server does not know the closed session any more
+	ch := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			ch <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = srv.Accept(conn)
+		ch <- err
+	}()
+	conn, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	err = client.Connect(conn)
+	if err != sox.ErrSessionExpired || <-ch != sox.ErrSessionExpired {
+		t.Errorf("expected ErrSessionExpired but got %v", err)
+		return
+	}
+}
+
+// sessionConnectErr reconnects the client session on a new loopback
+// connection, and returns the errors of Connect and Accept
+func sessionConnectErr(t *testing.T, l net.Listener, client *sox.Session, srv *sox.SessionServer) (connectErr error, acceptErr error) {
+	ch := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			ch <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = srv.Accept(conn)
+		ch <- err
+	}()
+	conn, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	err = client.Connect(conn)
+	return err, <-ch
+}
+
+func TestSession_Evict(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+
+	t.Run("max sessions", func(t *testing.T) {
+		srv := sox.NewSessionServer(func(options *sox.SessionOptions) {
+			options.MaxSessions = 2
+		})
+		clients := []*sox.Session{sox.NewSession(), sox.NewSession(), sox.NewSession()}
+		for _, client := range clients {
+			_, _, cc, sc := sessionConn(t, l, client, srv)
+			_ = cc.Close()
+			_ = sc.Close()
+			time.Sleep(time.Millisecond)
+		}
+		if srv.Len() != 2 {
+			t.Errorf("expected 2 sessions but got %d", srv.Len())
+			return
+		}
+		// the least recently active session has been forgotten
+		connectErr, acceptErr := sessionConnectErr(t, l, clients[0], srv)
+		if connectErr != sox.ErrSessionExpired || acceptErr != sox.ErrSessionExpired {
+			t.Errorf("expected ErrSessionExpired but got %v and %v", connectErr, acceptErr)
+			return
+		}
+		_, resumed, cc, sc := sessionConn(t, l, clients[2], srv)
+		defer cc.Close()
+		defer sc.Close()
+		if !resumed {
+			t.Errorf("expected the latest session to be resumed")
+			return
+		}
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		srv := sox.NewSessionServer(func(options *sox.SessionOptions) {
+			options.IdleTimeout = 20 * time.Millisecond
+		})
+		client := sox.NewSession()
+		_, _, cc, sc := sessionConn(t, l, client, srv)
+		_ = cc.Close()
+		_ = sc.Close()
+		time.Sleep(40 * time.Millisecond)
+		connectErr, acceptErr := sessionConnectErr(t, l, client, srv)
+		if connectErr != sox.ErrSessionExpired || acceptErr != sox.ErrSessionExpired {
+			t.Errorf("expected ErrSessionExpired but got %v and %v", connectErr, acceptErr)
+			return
+		}
+		if srv.Len() != 0 {
+			t.Errorf("expected no sessions but got %d", srv.Len())
+			return
+		}
+	})
+}
+
+func TestSession_ReadLimit(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	srv := sox.NewSessionServer(func(options *sox.SessionOptions) {
+		options.MessageOptions = []func(options *sox.MessageOptions){func(options *sox.MessageOptions) {
+			options.ReadLimit = 64
+		}}
+	})
+	client := sox.NewSession()
+	s, _, cc, sc := sessionConn(t, l, client, srv)
+	defer cc.Close()
+	defer sc.Close()
+	_, err = client.Write(make([]byte, 1024))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	_, err = s.Read(make([]byte, 2048))
+	if err != sox.ErrMsgTooLong {
+		t.Errorf("read expected ErrMsgTooLong but got %v", err)
+		return
+	}
+}
+
+func TestSession_AckInterval(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	// the messages are acknowledged before the replay is full
+	opt := func(options *sox.SessionOptions) {
+		options.ReplayCapacity = 2
+		options.AckInterval = 16
+	}
+	srv := sox.NewSessionServer(opt)
+	client := sox.NewSession(opt)
+	s, _, cc, sc := sessionConn(t, l, client, srv)
+	defer cc.Close()
+	defer sc.Close()
+	p := make([]byte, 16)
+	for _, msg := range []string{"a", "b"} {
+		_, err = client.Write([]byte(msg))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		_, err = s.Read(p)
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+	}
+	_, err = s.Write([]byte("x"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	_, err = client.Read(p)
+	if err != nil {
+		t.Errorf("read: %v", err)
+		return
+	}
+	_, err = client.Write([]byte("c"))
+	if err != nil {
+		t.Errorf("write expected the replay to be acknowledged but got %v", err)
+		return
+	}
+}
+
+func TestSession_NotConnected(t *testing.T) {
+	s := sox.NewSession()
+	_, err := s.Write([]byte("a"))
+	if err != sox.ErrSessionNotConnected {
+		t.Errorf("expected ErrSessionNotConnected but got %v", err)
+		return
+	}
+	_, err = s.Read(make([]byte, 16))
+	if err != sox.ErrSessionNotConnected {
+		t.Errorf("expected ErrSessionNotConnected but got %v", err)
+		return
+	}
+}
+
+func TestSession_Window(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+
+	t.Run("fail fast", func(t *testing.T) {
+		srv := sox.NewSessionServer(func(options *sox.SessionOptions) {
+			options.Window = 4
+		})
+		client := sox.NewSession()
+		s, _, cc, sc := sessionConn(t, l, client, srv)
+		defer cc.Close()
+		defer sc.Close()
+		for i := range 4 {
+			_, err := client.Write([]byte{byte(i)})
+			if err != nil {
+				t.Errorf("write: %v", err)
+				return
+			}
+		}
+		_, err := client.Write([]byte{4})
+		if err != sox.ErrSessionWindowExhausted {
+			t.Errorf("write expected ErrSessionWindowExhausted but got %v", err)
+			return
+		}
+		// reading half of the window reopens it, which the client receives by Read
+		p := make([]byte, 16)
+		for i := range 2 {
+			n, err := s.Read(p)
+			if err != nil || n != 1 || p[0] != byte(i) {
+				t.Errorf("read expected %d but got %v: %v", i, p[:n], err)
+				return
+			}
+		}
+		_, err = s.Write([]byte("x"))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		n, err := client.Read(p)
+		if err != nil || string(p[:n]) != "x" {
+			t.Errorf("read expected x but got %q: %v", p[:n], err)
+			return
+		}
+		for i := 4; i < 6; i++ {
+			_, err = client.Write([]byte{byte(i)})
+			if err != nil {
+				t.Errorf("write: %v", err)
+				return
+			}
+		}
+		_, err = client.Write([]byte{6})
+		if err != sox.ErrSessionWindowExhausted {
+			t.Errorf("write expected ErrSessionWindowExhausted but got %v", err)
+			return
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		opt := func(options *sox.SessionOptions) {
+			options.Window = 2
+			options.BlockOnWindow = true
+		}
+		srv := sox.NewSessionServer(opt)
+		client := sox.NewSession(opt)
+		s, _, cc, sc := sessionConn(t, l, client, srv)
+		defer cc.Close()
+		defer sc.Close()
+		const messages = 64
+		// the client reads to receive the window advertisements of the server
+		go func() {
+			_, _ = client.Read(make([]byte, 16))
+		}()
+		done := make(chan error, 1)
+		go func() {
+			for i := range messages {
+				_, err := client.Write([]byte{byte(i)})
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+		p := make([]byte, 16)
+		for i := range messages {
+			n, err := s.Read(p)
+			if err != nil || n != 1 || p[0] != byte(i) {
+				t.Errorf("read expected %d but got %v: %v", i, p[:n], err)
+				return
+			}
+		}
+		if err := <-done; err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+
+		// the blocked Write returns when the session is closed
+		go func() {
+			for {
+				if _, err := client.Write([]byte("z")); err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+		// the connection is closed first to unblock the Read of the client
+		_ = cc.Close()
+		_ = client.Close()
+		if err := <-done; err != sox.ErrSessionClosed {
+			t.Errorf("write expected ErrSessionClosed but got %v", err)
+			return
+		}
+	})
+}
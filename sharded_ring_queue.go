@@ -0,0 +1,226 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync/atomic"
+	_ "unsafe"
+)
+
+const (
+	defaultShardedRingQueueCapacity = 1<<12 - 1
+)
+
+// NewShardedRingQueue creates a ring queue which consists of multiple shards
+// and returns the consumer and the producer of it. Each producer produces into
+// the shard of the P it is running on, so that producers running in parallel
+// rarely contend with each other. The consumer merges the shards in round-robin
+// Items produced by one goroutine may be consumed out of order
+// The returned consumer and producer also implement ItemContextConsumer
+// and ItemContextProducer
+func NewShardedRingQueue[ItemType any](
+	opts ...func(options *ShardedRingQueueOptions)) (
+	consumer ItemConsumer[ItemType],
+	producer ItemProducer[ItemType],
+	err error) {
+	o := &ShardedRingQueueOptions{
+		Shards:            runtime.GOMAXPROCS(0),
+		Capacity:          defaultShardedRingQueueCapacity,
+		ConcurrentConsume: true,
+		Nonblocking:       false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	if o.Shards < 1 || o.Shards > (1<<16) {
+		return nil, nil, errors.New("invalid sharded ring queue shards")
+	}
+	if o.Capacity < 1 || o.Capacity >= (1<<30) {
+		return nil, nil, errors.New("invalid sharded ring queue capacity")
+	}
+	ring := newShardedRingQueue[ItemType](o)
+
+	return ring, ring, nil
+}
+
+// ShardedRingQueueOptions holds optional parameters for sharded RingQueue implementations
+type ShardedRingQueueOptions struct {
+	// Shards specifies the number of shards. The default Shards is GOMAXPROCS
+	Shards int
+	// Capacity specifies the capacity of each shard. The default Capacity is 4K
+	Capacity int
+	// ConcurrentConsume specifies whether the ItemConsumer works concurrently or not
+	// It should be set as true, if there are multiple goroutines doing Consumer operations
+	ConcurrentConsume bool
+	// Nonblocking specifies whether the Produce or Consume operations will NOT block
+	// even if it is temporarily unavailable or not
+	Nonblocking bool
+	// WaitStrategy specifies how the blocking Produce or Consume operations wait
+	// while the queue is full or empty. The default WaitStrategy is WaitStrategySpin
+	WaitStrategy WaitStrategy
+}
+
+type ringQueueShard[T any] interface {
+	produce(item T, nonblocking bool) error
+	consume(nonblocking bool) (item T, err error)
+	Len() int
+	Cap() int
+	Close() error
+}
+
+type shardedRingQueue[T any] struct {
+	*ShardedRingQueueOptions
+	shards []ringQueueShard[T]
+	next   atomic.Uint32
+	closed atomic.Bool
+	waits  *ringQueueWaits
+}
+
+func newShardedRingQueue[T any](opt *ShardedRingQueueOptions) *shardedRingQueue[T] {
+	shardOpt := &RingQueueOptions{
+		Capacity:          opt.Capacity,
+		ConcurrentProduce: true,
+		ConcurrentConsume: opt.ConcurrentConsume,
+		Nonblocking:       true,
+	}
+	shardOpt.Capacity |= shardOpt.Capacity >> 1
+	shardOpt.Capacity |= shardOpt.Capacity >> 2
+	shardOpt.Capacity |= shardOpt.Capacity >> 4
+	shardOpt.Capacity |= shardOpt.Capacity >> 8
+	shardOpt.Capacity |= shardOpt.Capacity >> 16
+	shards := make([]ringQueueShard[T], opt.Shards)
+	for i := range shards {
+		if opt.ConcurrentConsume {
+			shards[i] = newRingQueueConcurrent[T](shardOpt)
+		} else {
+			shards[i] = newRingQueueConcurrentProduce[T](shardOpt)
+		}
+	}
+
+	return &shardedRingQueue[T]{
+		ShardedRingQueueOptions: opt,
+		shards:                  shards,
+		next:                    atomic.Uint32{},
+		closed:                  atomic.Bool{},
+		waits:                   &ringQueueWaits{strategy: opt.WaitStrategy, notEmpty: newParker(), notFull: newParker()},
+	}
+}
+
+//go:linkname runtime_procPin runtime.procPin
+func runtime_procPin() int
+
+//go:linkname runtime_procUnpin runtime.procUnpin
+func runtime_procUnpin()
+
+func (rq *shardedRingQueue[T]) Produce(item T) error {
+	return rq.produce(item, rq.Nonblocking)
+}
+
+func (rq *shardedRingQueue[T]) produce(item T, nonblocking bool) error {
+	pid := runtime_procPin()
+	runtime_procUnpin()
+	sw := NewSpinWait().SetLevel(SpinWaitLevelProduce)
+	w := rq.waits.notFullWait(sw)
+	defer w.done()
+	for ; ; w.once() {
+		if rq.closed.Load() {
+			return io.ErrClosedPipe
+		}
+		for i := range len(rq.shards) {
+			err := rq.shards[(pid+i)%len(rq.shards)].produce(item, true)
+			if err == nil {
+				rq.waits.produced()
+				return nil
+			}
+			if err != ErrTemporarilyUnavailable {
+				return err
+			}
+		}
+		if nonblocking {
+			return ErrTemporarilyUnavailable
+		}
+	}
+}
+
+func (rq *shardedRingQueue[T]) Consume() (item T, err error) {
+	return rq.consume(rq.Nonblocking)
+}
+
+func (rq *shardedRingQueue[T]) consume(nonblocking bool) (item T, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := rq.waits.notEmptyWait(sw)
+	defer w.done()
+	for ; ; w.once() {
+		start, eof := int(rq.next.Load()), 0
+		for i := range len(rq.shards) {
+			idx := (start + i) % len(rq.shards)
+			item, err = rq.shards[idx].consume(true)
+			if err == nil {
+				if i > 0 {
+					rq.next.Store(uint32(idx))
+				}
+				rq.waits.consumed()
+				return item, nil
+			}
+			if err == io.EOF {
+				eof++
+				continue
+			}
+			if err != ErrTemporarilyUnavailable {
+				return item, err
+			}
+		}
+		if eof == len(rq.shards) {
+			return item, io.EOF
+		}
+		if nonblocking {
+			return item, ErrTemporarilyUnavailable
+		}
+	}
+}
+
+func (rq *shardedRingQueue[T]) ProduceContext(ctx context.Context, item T) error {
+	return rq.waits.waitContext(ctx, rq.waits.notFull, func() error { return rq.produce(item, true) })
+}
+
+func (rq *shardedRingQueue[T]) ConsumeContext(ctx context.Context) (item T, err error) {
+	err = rq.waits.waitContext(ctx, rq.waits.notEmpty, func() (err error) {
+		item, err = rq.consume(true)
+		return
+	})
+	return
+}
+
+// Len returns the sum of the number of items in all shards
+func (rq *shardedRingQueue[T]) Len() (n int) {
+	for _, shard := range rq.shards {
+		n += shard.Len()
+	}
+	return
+}
+
+// Cap returns the sum of the capacity of all shards
+func (rq *shardedRingQueue[T]) Cap() (n int) {
+	for _, shard := range rq.shards {
+		n += shard.Cap()
+	}
+	return
+}
+
+func (rq *shardedRingQueue[T]) Close() error {
+	if !rq.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	for _, shard := range rq.shards {
+		_ = shard.Close()
+	}
+	rq.waits.closed()
+
+	return nil
+}
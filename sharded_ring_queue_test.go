@@ -0,0 +1,148 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"hybscloud.com/sox"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestShardedRingQueue_Nonblocking(t *testing.T) {
+	c, p, err := sox.NewShardedRingQueue[int](func(options *sox.ShardedRingQueueOptions) {
+		options.Shards = 2
+		options.Capacity = 3
+		options.ConcurrentConsume = false
+		options.Nonblocking = true
+	})
+	if err != nil {
+		t.Errorf("sharded ring queue new: %v", err)
+		return
+	}
+	_, err = c.Consume()
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("sharded ring consumer expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	for i := range 6 {
+		err = p.Produce(i)
+		if err != nil {
+			t.Errorf("sharded ring producer produce: %v", err)
+			return
+		}
+	}
+	err = p.Produce(6)
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("sharded ring producer expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	_ = p.Close()
+	err = p.Produce(6)
+	if err != io.ErrClosedPipe {
+		t.Errorf("sharded ring producer expected %v but got %v", io.ErrClosedPipe, err)
+		return
+	}
+	seen := make([]bool, 6)
+	for range 6 {
+		item, err := c.Consume()
+		if err != nil {
+			t.Errorf("sharded ring consumer consume: %v", err)
+			return
+		}
+		seen[item] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			t.Errorf("sharded ring consumer item %d lost", i)
+			return
+		}
+	}
+	_, err = c.Consume()
+	if err != io.EOF {
+		t.Errorf("sharded ring consumer expected %v but got %v", io.EOF, err)
+		return
+	}
+}
+
+func TestShardedRingQueue_Concurrent(t *testing.T) {
+	for _, concurrentConsume := range []bool{false, true} {
+		c, p, err := sox.NewShardedRingQueue[int](func(options *sox.ShardedRingQueueOptions) {
+			options.Capacity = 0xff
+			options.ConcurrentConsume = concurrentConsume
+		})
+		if err != nil {
+			t.Errorf("sharded ring queue new: %v", err)
+			return
+		}
+		const producers, n = 16, 1000
+		wg := sync.WaitGroup{}
+		for i := range producers {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := range n {
+					err := p.Produce(i*n + j)
+					if err != nil {
+						t.Errorf("sharded ring producer produce: %v", err)
+						return
+					}
+				}
+			}(i)
+		}
+		go func() {
+			wg.Wait()
+			_ = p.Close()
+		}()
+		seen := make([]bool, producers*n)
+		for {
+			item, err := c.Consume()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("sharded ring consumer consume: %v", err)
+				return
+			}
+			if seen[item] {
+				t.Errorf("sharded ring consumer duplicated item %d", item)
+				return
+			}
+			seen[item] = true
+		}
+		for i, ok := range seen {
+			if !ok {
+				t.Errorf("sharded ring consumer item %d lost", i)
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkShardedRingQueue_ConcurrentProduce(b *testing.B) {
+	b.Run("4 producers", func(b *testing.B) {
+		c, p, err := sox.NewShardedRingQueue[int](func(options *sox.ShardedRingQueueOptions) {
+			options.ConcurrentConsume = false
+		})
+		if err != nil {
+			b.Errorf("sharded ring queue new: %v", err)
+			return
+		}
+		b.ResetTimer()
+		benchmarkRingQueueConcurrentProduce(b, c, p, 4)
+	})
+
+	b.Run("64 producers", func(b *testing.B) {
+		c, p, err := sox.NewShardedRingQueue[int](func(options *sox.ShardedRingQueueOptions) {
+			options.ConcurrentConsume = false
+		})
+		if err != nil {
+			b.Errorf("sharded ring queue new: %v", err)
+			return
+		}
+		b.ResetTimer()
+		benchmarkRingQueueConcurrentProduce(b, c, p, 64)
+	})
+}
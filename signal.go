@@ -0,0 +1,38 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"syscall"
+)
+
+// SignalEvent represents a signal received from a signal file
+type SignalEvent struct {
+	// Signal is the signal number
+	Signal syscall.Signal
+	// Code is the signal code, e.g. SI_USER or SI_QUEUE
+	Code int
+	// Errno is the error number associated with the signal
+	Errno int
+	// Pid is the process ID of the sender
+	Pid int
+	// Uid is the real user ID of the sender
+	Uid int
+	// Fd is the file descriptor for SIGIO
+	Fd int
+	// Status is the exit status or signal for SIGCHLD
+	Status int
+	// Int is the integer sent by sigqueue
+	Int int
+	// Ptr is the pointer sent by sigqueue
+	Ptr uint64
+	// Addr is the address that generated the signal for hardware-generated signals
+	Addr uint64
+}
+
+// SignalHandler handles signal events
+type SignalHandler interface {
+	ServeSignal(event SignalEvent)
+}
@@ -20,23 +20,66 @@ const (
 
 type signalfd int
 
-// NewSignalFile creates and returns a new signal fd
-func NewSignalFile() (signalFile PollSignalfd, err error) {
-	return newSignalfd()
+var defaultSignalFileSignals = []unix.Signal{
+	unix.SIGHUP,
+	unix.SIGINT,
+	unix.SIGQUIT,
+	unix.SIGUSR1,
+	unix.SIGUSR2,
+	unix.SIGPIPE,
+	unix.SIGTERM,
+	unix.SIGCHLD,
 }
 
-func newSignalfd() (signalfd, error) {
-	set := unix.Sigset_t{Val: [16]uint64{}}
-	sigAddSet(&set, unix.SIGHUP)
-	sigAddSet(&set, unix.SIGINT)
-	sigAddSet(&set, unix.SIGQUIT)
-	sigAddSet(&set, unix.SIGBUS)
-	sigAddSet(&set, unix.SIGUSR1)
-	sigAddSet(&set, unix.SIGUSR2)
-	sigAddSet(&set, unix.SIGPIPE)
-	sigAddSet(&set, unix.SIGTERM)
-	sigAddSet(&set, unix.SIGCHLD)
-	fd, err := unix.Signalfd(-1, &set, unix.SFD_CLOEXEC)
+// NewSignalFile creates and returns a new signal fd which receives the given signals
+// The default signals are SIGHUP, SIGINT, SIGQUIT, SIGUSR1, SIGUSR2,
+// SIGPIPE, SIGTERM and SIGCHLD. The synchronous signals SIGBUS, SIGFPE, SIGILL
+// and SIGSEGV, which the Go runtime turns into panics, can not be blocked and
+// ErrInvalidParam is returned for them
+// The signals are blocked in the signal mask of the calling thread with pthread_sigmask,
+// so that they are queued to the signal fd instead of being handled by the Go runtime.
+// The calling goroutine should be wired to its thread with runtime.LockOSThread
+// and read the signal fd on the same thread
+// Only the signals sent to the thread, e.g. with tgkill, are sure to be received.
+// The other threads of the Go runtime do not block the signals, so that the signals
+// sent to the process, e.g. with kill, are mostly delivered to them and handled by
+// the Go runtime as if there were no signal fd. To receive the signals sent to the
+// process, block them before the process starts and use NewSignalFileMaskInherited
+func NewSignalFile(signals ...unix.Signal) (signalFile PollSignalfd, err error) {
+	if len(signals) < 1 {
+		signals = defaultSignalFileSignals
+	}
+	for _, sig := range signals {
+		switch sig {
+		case unix.SIGBUS, unix.SIGFPE, unix.SIGILL, unix.SIGSEGV:
+			return nil, ErrInvalidParam
+		}
+	}
+	set := newSigset(signals...)
+	err = unix.PthreadSigmask(unix.SIG_BLOCK, &set, nil)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+
+	return newSignalfd(&set)
+}
+
+// NewSignalFileMaskInherited creates and returns a new signal fd which receives the given
+// signals like NewSignalFile, but leaves the signal mask as it is. It is useful when
+// the signals have already been blocked, e.g. in a signal mask inherited from the parent
+// The Go runtime starts all of its threads with the signal mask of the process start,
+// so that the signals blocked by the parent are received even if sent to the process
+func NewSignalFileMaskInherited(signals ...unix.Signal) (signalFile PollSignalfd, err error) {
+	if len(signals) < 1 {
+		signals = defaultSignalFileSignals
+	}
+	set := newSigset(signals...)
+
+	return newSignalfd(&set)
+}
+
+func newSignalfd(set *unix.Sigset_t) (signalfd, error) {
+	fd, err := unix.Signalfd(-1, set, unix.SFD_CLOEXEC)
 	if err != nil {
 		return -1, errFromUnixErrno(err)
 	}
@@ -44,6 +87,13 @@ func newSignalfd() (signalfd, error) {
 	return signalfd(fd), nil
 }
 
+func newSigset(signals ...unix.Signal) (set unix.Sigset_t) {
+	for _, sig := range signals {
+		sigAddSet(&set, sig)
+	}
+	return
+}
+
 func (fd signalfd) Fd() int {
 	return int(fd)
 }
@@ -74,14 +124,34 @@ func (fd signalfd) ReadUint() (val uint, err error) {
 }
 
 func (fd signalfd) ReadSiginfo() (sig unix.Signal, code int, err error) {
-	var buf [128]byte
-	_, err = fd.Read(buf[:])
+	event, err := fd.ReadSignalEvent()
 	if err != nil {
 		return -1, -1, err
 	}
-	s := (*unix.Siginfo)(unsafe.Pointer(&buf))
 
-	return unix.Signal(s.Signo), int(s.Code), nil
+	return event.Signal, event.Code, nil
+}
+
+func (fd signalfd) ReadSignalEvent() (event SignalEvent, err error) {
+	var info unix.SignalfdSiginfo
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	_, err = fd.Read(buf)
+	if err != nil {
+		return event, err
+	}
+
+	return SignalEvent{
+		Signal: unix.Signal(info.Signo),
+		Code:   int(info.Code),
+		Errno:  int(info.Errno),
+		Pid:    int(info.Pid),
+		Uid:    int(info.Uid),
+		Fd:     int(info.Fd),
+		Status: int(info.Status),
+		Int:    int(info.Int),
+		Ptr:    info.Ptr,
+		Addr:   info.Addr,
+	}, nil
 }
 
 func (fd signalfd) Close() error {
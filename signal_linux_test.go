@@ -10,24 +10,25 @@ import (
 	"golang.org/x/sys/unix"
 	"hybscloud.com/sox"
 	"os"
+	"os/exec"
+	"runtime"
 	"testing"
-	"time"
 )
 
 func TestSignalFile(t *testing.T) {
+	// keep the thread locked so that it exits with the blocked signal mask
+	runtime.LockOSThread()
 	s, err := sox.NewSignalFile()
 	if err != nil {
 		t.Errorf("new signalfd: %v", err)
 		return
 	}
+	defer s.(interface{ Close() error }).Close()
 	if s.Fd() < 0 {
 		t.Errorf("new signalfd: %v", s.Fd())
 		return
 	}
-	go func() {
-		time.Sleep(200 * time.Millisecond)
-		_ = unix.Kill(os.Getpid(), unix.SIGINT)
-	}()
+	_ = unix.Tgkill(os.Getpid(), unix.Gettid(), unix.SIGINT)
 	sig, _, err := s.ReadSiginfo()
 	if err != nil {
 		t.Errorf("signal fd read: %v", err)
@@ -38,3 +39,75 @@ func TestSignalFile(t *testing.T) {
 		return
 	}
 }
+
+func TestSignalFile_SignalEvent(t *testing.T) {
+	// keep the thread locked so that it exits with the blocked signal mask
+	runtime.LockOSThread()
+	s, err := sox.NewSignalFile(unix.SIGUSR1)
+	if err != nil {
+		t.Errorf("new signalfd: %v", err)
+		return
+	}
+	defer s.(interface{ Close() error }).Close()
+	_ = unix.Tgkill(os.Getpid(), unix.Gettid(), unix.SIGUSR1)
+	event, err := s.ReadSignalEvent()
+	if err != nil {
+		t.Errorf("signal fd read: %v", err)
+		return
+	}
+	if event.Signal != unix.SIGUSR1 || event.Pid != os.Getpid() || event.Uid != os.Getuid() {
+		t.Errorf("signal fd read unexpected event: %+v", event)
+		return
+	}
+}
+
+func TestSignalFile_Synchronous(t *testing.T) {
+	for _, sig := range []unix.Signal{unix.SIGBUS, unix.SIGFPE, unix.SIGILL, unix.SIGSEGV} {
+		_, err := sox.NewSignalFile(sig)
+		if err != sox.ErrInvalidParam {
+			t.Errorf("new signalfd of %v expected ErrInvalidParam but got %v", sig, err)
+			return
+		}
+	}
+}
+
+func TestSignalFile_Process(t *testing.T) {
+	if os.Getenv("SOX_TEST_SIGNAL_PROCESS") == "1" {
+		// the child started with SIGUSR1 blocked on all of its threads
+		s, err := sox.NewSignalFileMaskInherited(unix.SIGUSR1)
+		if err != nil {
+			t.Errorf("new signalfd: %v", err)
+			return
+		}
+		defer s.(interface{ Close() error }).Close()
+		_ = unix.Kill(os.Getpid(), unix.SIGUSR1)
+		fds := []unix.PollFd{{Fd: int32(s.Fd()), Events: unix.POLLIN}}
+		if n, err := unix.Poll(fds, 1000); n < 1 {
+			t.Errorf("signal fd expected to be readable: %v", err)
+			return
+		}
+		sig, _, err := s.ReadSiginfo()
+		if err != nil || sig != unix.SIGUSR1 {
+			t.Errorf("signal fd read expected SIGUSR1 but got %v: %v", sig, err)
+			return
+		}
+		return
+	}
+
+	// keep the thread locked so that it exits with the blocked signal mask, which
+	// the child forked on the thread inherits
+	runtime.LockOSThread()
+	s, err := sox.NewSignalFile(unix.SIGUSR1)
+	if err != nil {
+		t.Errorf("new signalfd: %v", err)
+		return
+	}
+	defer s.(interface{ Close() error }).Close()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSignalFile_Process$")
+	cmd.Env = append(os.Environ(), "SOX_TEST_SIGNAL_PROCESS=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("signal sent to the process expected to be received: %v\n%s", err, out)
+		return
+	}
+}
@@ -8,6 +8,7 @@ package sox
 
 import (
 	"golang.org/x/sys/unix"
+	"io"
 )
 
 type socket struct {
@@ -20,6 +21,13 @@ func newSocket(network NetworkType, fd int, sa unix.Sockaddr) *socket {
 	return &socket{fd: fd, sa: sa, network: network}
 }
 
+// refreshSockname replaces the sa of the socket with the address which the
+// socket is actually bound to after bind or connect, and returns it
+func (so *socket) refreshSockname() unix.Sockaddr {
+	so.sa = sockname(so.fd, so.sa)
+	return so.sa
+}
+
 func (so *socket) Fd() int {
 	return so.fd
 }
@@ -40,6 +48,28 @@ func (so *socket) Writev(iovs [][]byte) (n int, err error) {
 	return n, nil
 }
 
+// Peek receives the next n bytes with MSG_PEEK, which are left in the receive
+// queue and will be read again by Read. Over the stream sockets, fewer than n
+// bytes are returned with ErrTemporarilyUnavailable until n bytes have been
+// received, and io.EOF is returned after the peer has shut down the writing
+func (so *socket) Peek(n int) ([]byte, error) {
+	if n < 1 {
+		return nil, ErrInvalidParam
+	}
+	b := make([]byte, n)
+	rn, _, err := unix.Recvfrom(so.fd, b, unix.MSG_PEEK)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+	if rn == 0 {
+		return nil, io.EOF
+	}
+	if rn < n {
+		return b[:rn], ErrTemporarilyUnavailable
+	}
+	return b, nil
+}
+
 func (so *socket) Recvmsg(buffers [][]byte, oob []byte) (n, oobn int, recvflags int, from unix.Sockaddr, err error) {
 	n, oobn, recvflags, from, err = unix.RecvmsgBuffers(so.fd, buffers, oob, unix.MSG_WAITALL)
 	if err != nil {
@@ -67,6 +97,52 @@ func (so *socket) Sendmsg(buffers [][]byte, oob []byte, to Addr) (n int, err err
 	return n, nil
 }
 
+// readMsg reads into b and oob with recvmsg and the flags, and returns the
+// address of the sender converted by toAddr, which is nil if the socket
+// is connected and the kernel does not report the sender
+func (so *socket) readMsg(b, oob []byte, flags int, toAddr func(sa unix.Sockaddr) Addr) (n, oobn, recvflags int, addr Addr, err error) {
+	n, oobn, recvflags, sa, err := unix.Recvmsg(so.fd, b, oob, flags)
+	if err != nil {
+		return 0, 0, recvflags, nil, errFromUnixErrno(err)
+	}
+	if sa != nil {
+		addr = toAddr(sa)
+	}
+	return
+}
+
+// WriteMsg writes b and the control message oob to addr with sendmsg
+// A nil addr means the peer of the connected socket
+func (so *socket) WriteMsg(b, oob []byte, addr Addr) (n, oobn int, err error) {
+	return so.writeMsg(b, oob, addr, 0)
+}
+
+func (so *socket) writeMsg(b, oob []byte, addr Addr, flags int) (n, oobn int, err error) {
+	sa := unix.Sockaddr(nil)
+	if addr != nil {
+		switch so.network {
+		case NetworkUnix:
+			ua, ok := addr.(*UnixAddr)
+			if !ok {
+				return 0, 0, InvalidAddrError("unexpected address type")
+			}
+			sa = unixAddrToSockaddr(ua)
+		case NetworkIPv4:
+			sa = inet4AddrToSockaddr(addr)
+		case NetworkIPv6:
+			sa = inet6AddrToSockaddr(addr)
+		}
+		if sa == nil {
+			return 0, 0, InvalidAddrError("unexpected address type")
+		}
+	}
+	n, err = unix.SendmsgN(so.fd, b, oob, sa, flags)
+	if err != nil {
+		return 0, 0, errFromUnixErrno(err)
+	}
+	return n, len(oob), nil
+}
+
 func (so *socket) Read(b []byte) (n int, err error) {
 	n, err = unix.Read(so.fd, b)
 	if err != nil {
@@ -88,3 +164,54 @@ func (so *socket) Write(b []byte) (n int, err error) {
 func (so *socket) Close() error {
 	return unix.Close(so.fd)
 }
+
+// networkName returns the name of the network of the socket as the net
+// package names it, e.g. "tcp4" for the proto "tcp" over IPv4
+func (so *socket) networkName(proto string) string {
+	switch so.network {
+	case NetworkIPv4:
+		return proto + "4"
+	case NetworkIPv6:
+		return proto + "6"
+	}
+	return proto
+}
+
+// opError wraps the failure err of the operation op in an *OpError with the
+// network and the addresses. The errors of the non-blocking control flow and
+// io.EOF are returned as they are, so that they can still be compared with ==
+func opError(op string, network string, source, addr Addr, err error) error {
+	switch err {
+	case nil, io.EOF, ErrTemporarilyUnavailable, ErrInterruptedSyscall, ErrInProgress:
+		return err
+	}
+	return &OpError{Op: op, Net: network, Source: opAddr(source), Addr: opAddr(addr), Err: err}
+}
+
+// opAddr returns nil for the typed nil addresses, which the OpError would
+// otherwise print as "<nil>"
+func opAddr(addr Addr) Addr {
+	switch a := addr.(type) {
+	case *TCPAddr:
+		if a == nil {
+			return nil
+		}
+	case *UDPAddr:
+		if a == nil {
+			return nil
+		}
+	case *SCTPAddr:
+		if a == nil {
+			return nil
+		}
+	case *UnixAddr:
+		if a == nil {
+			return nil
+		}
+	case *IPAddr:
+		if a == nil {
+			return nil
+		}
+	}
+	return addr
+}
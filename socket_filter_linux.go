@@ -0,0 +1,174 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const (
+	skfAdOff    = -0x1000
+	skfAdRandom = 56
+
+	// socketFilterAccept keeps the whole packet, and socketFilterDrop drops it
+	socketFilterAccept = 0xffffffff
+	socketFilterDrop   = 0
+
+	// socketFilterMaxPorts is the limit of the ports of an AcceptSrcPorts
+	// call, which are bounded by the 8-bit conditional jump offsets
+	socketFilterMaxPorts = 0xff
+)
+
+// AttachSocketFilter attaches the classic BPF program prog to the socket fd
+// with SO_ATTACH_FILTER. The program replaces the filter attached before
+func AttachSocketFilter(fd int, prog []unix.SockFilter) error {
+	if len(prog) == 0 || len(prog) > unix.BPF_MAXINSNS {
+		return ErrInvalidParam
+	}
+	fprog := &unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, fprog)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// AttachSocketBPF attaches the loaded eBPF program of the type
+// BPF_PROG_TYPE_SOCKET_FILTER progFd to the socket fd with SO_ATTACH_BPF
+func AttachSocketBPF(fd int, progFd int) error {
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ATTACH_BPF, progFd)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// DetachSocketFilter detaches the classic or eBPF filter of the socket fd
+func DetachSocketFilter(fd int) error {
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DETACH_FILTER, 0)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// LockSocketFilter locks the filter of the socket fd, which then cannot be
+// detached or replaced, e.g. before handing the socket over to a less
+// privileged process
+func LockSocketFilter(fd int) error {
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_LOCK_FILTER, 1)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// SocketFilterBuilder builds the classic BPF programs of the common socket
+// filters. The rules are checked in the order they are added, and a packet
+// is accepted only if it passes all of them
+// The port rules read the transport header, which the packet data of the UDP
+// and the TCP sockets starts at. The length and the sampling rules apply
+// to the packet sockets as well
+type SocketFilterBuilder struct {
+	prog []unix.SockFilter
+	err  error
+}
+
+// NewSocketFilterBuilder creates and returns a SocketFilterBuilder which accepts all packets
+func NewSocketFilterBuilder() *SocketFilterBuilder {
+	return &SocketFilterBuilder{prog: make([]unix.SockFilter, 0, 16)}
+}
+
+// DropSrcPorts drops the packets from any of the source ports, e.g. the
+// well-known ports of the reflection attacks
+func (b *SocketFilterBuilder) DropSrcPorts(ports ...uint16) *SocketFilterBuilder {
+	if len(ports) == 0 {
+		return b
+	}
+	b.prog = append(b.prog, unix.SockFilter{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 0})
+	for _, port := range ports {
+		b.prog = append(b.prog,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 1, K: uint32(port)},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: socketFilterDrop},
+		)
+	}
+	return b
+}
+
+// AcceptSrcPorts drops the packets from any source port other than the ports
+// At most 255 ports are accepted by a call
+func (b *SocketFilterBuilder) AcceptSrcPorts(ports ...uint16) *SocketFilterBuilder {
+	if len(ports) == 0 || len(ports) > socketFilterMaxPorts {
+		b.err = ErrInvalidParam
+		return b
+	}
+	b.prog = append(b.prog, unix.SockFilter{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 0})
+	for i, port := range ports {
+		// a matched port skips the rest of the ports and the drop
+		b.prog = append(b.prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: uint8(len(ports) - i), Jf: 0, K: uint32(port)})
+	}
+	b.prog = append(b.prog, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: socketFilterDrop})
+	return b
+}
+
+// MinLength drops the packets shorter than n bytes
+func (b *SocketFilterBuilder) MinLength(n int) *SocketFilterBuilder {
+	if n < 0 {
+		b.err = ErrInvalidParam
+		return b
+	}
+	b.prog = append(b.prog,
+		unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_LEN},
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: 1, Jf: 0, K: uint32(n)},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: socketFilterDrop},
+	)
+	return b
+}
+
+// MaxLength drops the packets longer than n bytes
+func (b *SocketFilterBuilder) MaxLength(n int) *SocketFilterBuilder {
+	if n < 0 {
+		b.err = ErrInvalidParam
+		return b
+	}
+	b.prog = append(b.prog,
+		unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_LEN},
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, Jt: 0, Jf: 1, K: uint32(n)},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: socketFilterDrop},
+	)
+	return b
+}
+
+// Sample accepts one of every n packets at random, e.g. for telemetry
+func (b *SocketFilterBuilder) Sample(n int) *SocketFilterBuilder {
+	if n < 1 || uint64(n) > 1<<32-1 {
+		b.err = ErrInvalidParam
+		return b
+	}
+	if n == 1 {
+		return b
+	}
+	b.prog = append(b.prog,
+		unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 1<<32 + skfAdOff + skfAdRandom},
+		unix.SockFilter{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(n)},
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, Jf: 0, K: 0},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: socketFilterDrop},
+	)
+	return b
+}
+
+// Build returns the program, which accepts the packets that pass all the rules
+func (b *SocketFilterBuilder) Build() ([]unix.SockFilter, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	prog := append(b.prog[:len(b.prog):len(b.prog)], unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: socketFilterAccept})
+	if len(prog) > unix.BPF_MAXINSNS {
+		return nil, ErrInvalidParam
+	}
+	return prog, nil
+}
@@ -0,0 +1,62 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ReadMsg reads into b and the control messages into oob, which can be parsed
+// with unix.ParseSocketControlMessage. The flags are the flags of the received
+// message, e.g. MSG_CTRUNC if oob is too short for the control messages
+func (so *TCPSocket) ReadMsg(b, oob []byte) (n, oobn, flags int, addr Addr, err error) {
+	return so.readMsg(b, oob, 0, func(sa unix.Sockaddr) Addr {
+		return TCPAddrFromAddrPort(addrPortFromSockaddr(sa))
+	})
+}
+
+// ReadOOB reads the urgent data of TCP, which is sent by WriteOOB out of band
+// of the stream. Only the last byte of each urgent write is kept out of band
+// ErrInvalidParam is returned if there is no urgent data to be read
+func (so *TCPSocket) ReadOOB(b []byte) (n int, err error) {
+	n, _, err = unix.Recvfrom(so.fd, b, unix.MSG_OOB)
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+	return n, nil
+}
+
+// WriteOOB writes b as the urgent data of TCP
+func (so *TCPSocket) WriteOOB(b []byte) (n int, err error) {
+	n, _, err = so.writeMsg(b, nil, nil, unix.MSG_OOB)
+	return
+}
+
+// ReadMsg reads a datagram into b and the control messages into oob, and
+// returns the address of the sender. The flags are the flags of the received
+// message, e.g. MSG_TRUNC if the datagram is longer than b
+func (so *UDPSocket) ReadMsg(b, oob []byte) (n, oobn, flags int, addr Addr, err error) {
+	return so.readMsg(b, oob, 0, func(sa unix.Sockaddr) Addr {
+		return UDPAddrFromAddrPort(addrPortFromSockaddr(sa))
+	})
+}
+
+// ReadMsg reads a message into b and the control messages into oob, e.g. the
+// SCM_RIGHTS of the passed fds and the SCM_CREDENTIALS of the peer
+func (so *UnixSocket) ReadMsg(b, oob []byte) (n, oobn, flags int, addr Addr, err error) {
+	return so.readMsg(b, oob, 0, func(sa unix.Sockaddr) Addr {
+		return unixAddrFromSockaddr(sa, so.Protocol())
+	})
+}
+
+// ReadMsg reads a message into b and the ancillary data into oob, e.g. the
+// SCTP_SNDRCV information of the stream when it has been enabled
+func (so *SCTPSocket) ReadMsg(b, oob []byte) (n, oobn, flags int, addr Addr, err error) {
+	return so.readMsg(b, oob, 0, func(sa unix.Sockaddr) Addr {
+		return SCTPAddrFromAddrPort(addrPortFromSockaddr(sa))
+	})
+}
@@ -5,84 +5,86 @@
 package sox
 
 import (
+	"context"
 	"math"
+	"os"
 	"runtime"
 	"time"
 	_ "unsafe"
 )
 
+// The levels of SpinWait. A SpinWait at the level L spins up to 4^L-1 times
+// between two yields, and the spins shrink as it keeps on yielding, so that a
+// long wait converges to yield on every Once
+//
+// The thresholds are measured with BenchmarkSpinWait. A spin costs a few
+// hundred nanoseconds, which is less than a yield under load, so that the
+// lower levels suit the waits for the system calls and the other goroutines,
+// and the higher levels suit the waits for the other processors to finish a
+// few atomic instructions
 const (
-	procYieldCycles = 16
+	// SpinWaitLevelClient sleeps a jiffy on every Once
+	SpinWaitLevelClient = iota
+	// SpinWaitLevelBlockingIO spins 3 times between the sleeps of a jiffy
+	SpinWaitLevelBlockingIO
+	// SpinWaitLevelConsume spins 15 times between the yields
+	// It is the level of the zero value of SpinWait
+	SpinWaitLevelConsume
+	// SpinWaitLevelProduce spins 63 times between the yields
+	SpinWaitLevelProduce
+	// SpinWaitLevelAtomic spins 255 times between the yields
+	SpinWaitLevelAtomic
 )
 
 // SpinWait is a lightweight synchronization type that
 // you can use in low-level scenarios with lower cost.
-// The zero value for SpinWait is ready to use.
+// The zero value for SpinWait is ready to use at SpinWaitLevelConsume
+//
+// A spin executes procYieldCycles pause instructions of the processor,
+// PAUSE on amd64 and YIELD on arm64. A yield is runtime.Gosched, or a sleep
+// of a jiffy at SpinWaitLevelBlockingIO and below
+//
+// A SpinWait can be closed by the limit of spins, the deadline and the
+// context, and Err returns the reason why it is closed
 type SpinWait struct {
-	i uint32
+	i        uint32
+	level    int8 // the level plus 1, so that the zero value means the default
+	limit    uint32
+	total    int32
+	polled   int32
+	deadline time.Time
+	ctx      context.Context
+	err      error
 }
 
-// Once performs a single spin
-func (s *SpinWait) Once() {
-	s.i++
-	if s.WillYield() {
-		runtime.Gosched()
-		return
-	}
-	procyield(procYieldCycles)
-}
+// ParamSpinWait is SpinWait
+//
+// Deprecated: use SpinWait
+type ParamSpinWait = SpinWait
 
-// WillYield returns true if calling SpinOnce() will result
-// in occurring a thread sleeping instead of a simply procyield()
-func (s *SpinWait) WillYield() bool {
-	return s.i >= 8
+// NewSpinWait returns a SpinWait at SpinWaitLevelBlockingIO,
+// the level of the waits for the non-blocking system calls
+func NewSpinWait() *SpinWait {
+	return (&SpinWait{}).SetLevel(SpinWaitLevelBlockingIO)
 }
 
-// Reset resets the counter in SpinWait
-func (s *SpinWait) Reset() {
-	s.i = 0
+// NewParamSpinWait returns a SpinWait at SpinWaitLevelBlockingIO
+//
+// Deprecated: use NewSpinWait
+func NewParamSpinWait() *SpinWait {
+	return NewSpinWait()
 }
 
-const (
-	SpinWaitLevelClient = iota
-	SpinWaitLevelBlockingIO
-	SpinWaitLevelConsume
-	spinWaitLevelProduce
-	spinWaitLevelAtomic
-)
-
-type ParamSpinWait struct {
-	i     uint32
-	level int8
-	d     time.Duration
-	limit uint32
-	total int32
-}
-
-func NewParamSpinWait() *ParamSpinWait {
-	return &ParamSpinWait{
-		i:     0,
-		level: SpinWaitLevelBlockingIO,
-		d:     jiffies,
-		limit: 0,
-		total: 0,
-	}
-}
-
-func (sw *ParamSpinWait) SetLevel(level int) *ParamSpinWait {
-	if level < SpinWaitLevelClient {
-		sw.level = SpinWaitLevelClient
-		return sw
-	}
-	if level > spinWaitLevelAtomic {
-		level = spinWaitLevelAtomic
-	}
-	sw.level = int8(level)
+// SetLevel sets the level of the spin wait, e.g. SpinWaitLevelConsume
+func (sw *SpinWait) SetLevel(level int) *SpinWait {
+	sw.level = spinWaitLevel(level) + 1
 
 	return sw
 }
 
-func (sw *ParamSpinWait) SetLimit(limit int) *ParamSpinWait {
+// SetLimit sets the number of spins after which the spin wait is closed
+// The zero limit means no limit
+func (sw *SpinWait) SetLimit(limit int) *SpinWait {
 	if limit > math.MaxUint32-1 {
 		limit = math.MaxUint32 - 1
 	}
@@ -91,53 +93,116 @@ func (sw *ParamSpinWait) SetLimit(limit int) *ParamSpinWait {
 	return sw
 }
 
-func (sw *ParamSpinWait) Once() {
-	sw.once(sw.level)
+// SetDeadline sets the deadline after which the spin wait is closed
+// The zero t means no deadline
+func (sw *SpinWait) SetDeadline(t time.Time) *SpinWait {
+	sw.deadline = t
+
+	return sw
 }
 
-func (sw *ParamSpinWait) OnceWithLevel(level int) {
-	if level < SpinWaitLevelClient {
-		level = SpinWaitLevelClient
-	}
-	if level > spinWaitLevelAtomic {
-		level = spinWaitLevelAtomic
-	}
-	sw.once(int8(level))
+// SetContext sets the ctx which closes the spin wait when it is done
+func (sw *SpinWait) SetContext(ctx context.Context) *SpinWait {
+	sw.ctx = ctx
+
+	return sw
+}
+
+// Once performs a single spin
+func (sw *SpinWait) Once() {
+	sw.once(sw.currentLevel())
+}
+
+// OnceWithLevel performs a single spin at the level instead of the level of the spin wait
+func (sw *SpinWait) OnceWithLevel(level int) {
+	sw.once(spinWaitLevel(level))
 }
 
-func (sw *ParamSpinWait) WillYield() bool {
-	return sw.willYield(sw.level)
+// WillYield returns true if calling Once will result in
+// a yield or a sleep instead of a simple spin
+func (sw *SpinWait) WillYield() bool {
+	return sw.willYield(sw.i+1, sw.currentLevel())
 }
 
-func (sw *ParamSpinWait) WillYieldWithLevel(level int8) bool {
-	return sw.willYield(level)
+// WillYieldWithLevel returns true if calling OnceWithLevel with the level
+// will result in a yield or a sleep instead of a simple spin
+func (sw *SpinWait) WillYieldWithLevel(level int8) bool {
+	return sw.willYield(sw.i+1, spinWaitLevel(int(level)))
 }
 
-func (sw *ParamSpinWait) Reset() {
+// Reset resets the counters and the closed reason of the spin wait
+// The level, the limit, the deadline and the context are kept
+func (sw *SpinWait) Reset() {
 	sw.i = 0
 	sw.total = 0
+	sw.polled = 0
+	sw.err = nil
+}
+
+// Closed reports whether the limit of spins, the deadline or the done
+// of the context has been reached. The deadline and the context are only
+// polled before the first spin and after the spins which yield, so that
+// the busy spins stay cheap
+func (sw *SpinWait) Closed() bool {
+	if sw.err != nil {
+		return true
+	}
+	if sw.limit > 0 && sw.i >= sw.limit {
+		sw.err = ErrTemporarilyUnavailable
+		return true
+	}
+	if sw.i > 0 && sw.polled == sw.total {
+		return false
+	}
+	sw.polled = sw.total
+	if sw.ctx != nil {
+		if err := sw.ctx.Err(); err != nil {
+			sw.err = err
+			return true
+		}
+	}
+	if !sw.deadline.IsZero() && !time.Now().Before(sw.deadline) {
+		sw.err = os.ErrDeadlineExceeded
+		return true
+	}
+
+	return false
+}
+
+// Err returns nil if the spin wait is not closed. Otherwise it returns
+// ErrTemporarilyUnavailable if the limit of spins has been reached,
+// os.ErrDeadlineExceeded if the deadline has passed, or the error of
+// the context
+func (sw *SpinWait) Err() error {
+	sw.Closed()
+	return sw.err
 }
 
-func (sw *ParamSpinWait) Closed() bool {
-	return sw.limit > 0 && sw.i >= sw.limit
+func (sw *SpinWait) currentLevel() int8 {
+	if sw.level == 0 {
+		return SpinWaitLevelConsume
+	}
+	return sw.level - 1
 }
 
-func (sw *ParamSpinWait) willYield(level int8) bool {
+// willYield reports whether the i-th spin at the level yields
+func (sw *SpinWait) willYield(i uint32, level int8) bool {
 	x := int32(level << 1)
-	if sw.i&(1<<(x-min(x, sw.total>>1))-1) != 0 {
+	if i&(1<<(x-min(x, sw.total>>1))-1) != 0 {
 		return false
 	}
 
 	return true
 }
 
-func (sw *ParamSpinWait) once(level int8) {
+func (sw *SpinWait) once(level int8) {
 	sw.i++
-	if !sw.willYield(level) {
+	if !sw.willYield(sw.i, level) {
 		procyield(procYieldCycles)
 		return
 	}
 	sw.total++
+	spinWaitYields.Add(1)
 	if level <= SpinWaitLevelBlockingIO {
 		time.Sleep(jiffies)
 	} else {
@@ -145,5 +210,15 @@ func (sw *ParamSpinWait) once(level int8) {
 	}
 }
 
+func spinWaitLevel(level int) int8 {
+	if level < SpinWaitLevelClient {
+		return SpinWaitLevelClient
+	}
+	if level > SpinWaitLevelAtomic {
+		return SpinWaitLevelAtomic
+	}
+	return int8(level)
+}
+
 //go:linkname procyield runtime.procyield
 func procyield(cycles uint32)
@@ -0,0 +1,10 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+// procYieldCycles is the number of YIELD instructions of a spin
+// Most of the arm64 cores retire YIELD as a NOP in a few cycles while PAUSE
+// of amd64 takes tens of cycles, so that more of them make a similar spin
+const procYieldCycles = 64
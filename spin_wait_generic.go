@@ -0,0 +1,10 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !arm64
+
+package sox
+
+// procYieldCycles is the number of the pause instructions of a spin
+const procYieldCycles = 16
@@ -5,15 +5,17 @@
 package sox_test
 
 import (
+	"context"
 	"hybscloud.com/sox"
 	"math"
+	"os"
 	"runtime"
 	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func TestSpinWaiter(t *testing.T) {
+func TestSpinWait(t *testing.T) {
 	fn := func(x *atomic.Int32) {
 		for {
 			val := x.Load()
@@ -35,7 +37,7 @@ func TestSpinWaiter(t *testing.T) {
 	t.Run("common usage", func(t *testing.T) {
 		x := atomic.Int32{}
 		go fn(&x)
-		for sw := sox.NewParamSpinWait(); !sw.Closed(); sw.Once() {
+		for sw := sox.NewSpinWait(); !sw.Closed(); sw.Once() {
 			val := x.Load()
 			//  some actions
 			runtime.Gosched()
@@ -53,7 +55,7 @@ func TestSpinWaiter(t *testing.T) {
 	})
 
 	t.Run("level 0", func(t *testing.T) {
-		sw := sox.NewParamSpinWait().SetLevel(sox.SpinWaitLevelClient)
+		sw := sox.NewSpinWait().SetLevel(sox.SpinWaitLevelClient)
 		total := 0
 		for range 1 << 4 {
 			if sw.WillYield() {
@@ -67,7 +69,7 @@ func TestSpinWaiter(t *testing.T) {
 	})
 
 	t.Run("level 1", func(t *testing.T) {
-		sw := sox.NewParamSpinWait().SetLevel(sox.SpinWaitLevelBlockingIO)
+		sw := sox.NewSpinWait().SetLevel(sox.SpinWaitLevelBlockingIO)
 		total := 0
 		for range 1 << 5 {
 			if sw.WillYield() {
@@ -81,7 +83,7 @@ func TestSpinWaiter(t *testing.T) {
 	})
 
 	t.Run("level 2", func(t *testing.T) {
-		sw := sox.NewParamSpinWait().SetLevel(sox.SpinWaitLevelConsume)
+		sw := sox.NewSpinWait().SetLevel(sox.SpinWaitLevelConsume)
 		total := 0
 		for range 1 << 7 {
 			if sw.WillYield() {
@@ -95,7 +97,7 @@ func TestSpinWaiter(t *testing.T) {
 	})
 
 	t.Run("level 3", func(t *testing.T) {
-		sw := sox.NewParamSpinWait().SetLevel(sox.SpinWaitLevelConsume + 1)
+		sw := sox.NewSpinWait().SetLevel(sox.SpinWaitLevelProduce)
 		total := 0
 		for range 1 << 10 {
 			if sw.WillYield() {
@@ -109,7 +111,7 @@ func TestSpinWaiter(t *testing.T) {
 	})
 
 	t.Run("level 4", func(t *testing.T) {
-		sw := sox.NewParamSpinWait().SetLevel(sox.SpinWaitLevelConsume + 2)
+		sw := sox.NewSpinWait().SetLevel(sox.SpinWaitLevelAtomic)
 		total := 0
 		for range 1 << 14 {
 			if sw.WillYield() {
@@ -122,8 +124,20 @@ func TestSpinWaiter(t *testing.T) {
 		}
 	})
 
+	t.Run("zero value", func(t *testing.T) {
+		sw, consume := sox.SpinWait{}, sox.NewSpinWait().SetLevel(sox.SpinWaitLevelConsume)
+		for range 1 << 7 {
+			if sw.WillYield() != consume.WillYield() {
+				t.Errorf("expected zero value to spin at SpinWaitLevelConsume")
+				return
+			}
+			sw.Once()
+			consume.Once()
+		}
+	})
+
 	t.Run("timeout", func(t *testing.T) {
-		sw := sox.NewParamSpinWait().SetLimit(128)
+		sw := sox.NewSpinWait().SetLimit(128)
 		cnt := 0
 		for ; !sw.Closed(); sw.Once() {
 			cnt++
@@ -133,3 +147,74 @@ func TestSpinWaiter(t *testing.T) {
 		}
 	})
 }
+
+func TestSpinWait_Err(t *testing.T) {
+	t.Run("limit", func(t *testing.T) {
+		sw := sox.NewSpinWait().SetLimit(16)
+		for ; !sw.Closed(); sw.Once() {
+			if sw.Err() != nil {
+				t.Errorf("expected nil error before closed but got %v", sw.Err())
+				return
+			}
+		}
+		if sw.Err() != sox.ErrTemporarilyUnavailable {
+			t.Errorf("expected ErrTemporarilyUnavailable but got %v", sw.Err())
+			return
+		}
+		sw.Reset()
+		if sw.Closed() || sw.Err() != nil {
+			t.Errorf("expected reset spin wait but got %v", sw.Err())
+			return
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		start := time.Now()
+		sw := sox.NewSpinWait().SetDeadline(start.Add(20 * time.Millisecond))
+		for ; !sw.Closed(); sw.Once() {
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+			t.Errorf("expected closed after 20ms but got %v", elapsed)
+			return
+		}
+		if sw.Err() != os.ErrDeadlineExceeded {
+			t.Errorf("expected ErrDeadlineExceeded but got %v", sw.Err())
+			return
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sw := sox.NewSpinWait().SetLevel(sox.SpinWaitLevelConsume).SetContext(ctx)
+		time.AfterFunc(10*time.Millisecond, cancel)
+		for ; !sw.Closed(); sw.Once() {
+		}
+		if sw.Err() != context.Canceled {
+			t.Errorf("expected context.Canceled but got %v", sw.Err())
+			return
+		}
+	})
+}
+
+func BenchmarkSpinWait(b *testing.B) {
+	levels := []struct {
+		name  string
+		level int
+	}{
+		{"consume", sox.SpinWaitLevelConsume},
+		{"produce", sox.SpinWaitLevelProduce},
+		{"atomic", sox.SpinWaitLevelAtomic},
+	}
+	for _, l := range levels {
+		b.Run(l.name, func(b *testing.B) {
+			// the average cost of a Once in the waits of 1024 spins
+			sw := sox.NewSpinWait().SetLevel(l.level)
+			for i := range b.N {
+				if i&1023 == 0 {
+					sw.Reset()
+				}
+				sw.Once()
+			}
+		})
+	}
+}
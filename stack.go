@@ -0,0 +1,251 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"io"
+	"math/bits"
+	"sync/atomic"
+)
+
+// StackOptions holds optional parameters for the unbounded Stack
+type StackOptions struct {
+	// Nonblocking specifies whether the Pop operations will NOT block
+	// even if it is temporarily unavailable or not
+	Nonblocking bool
+	// WaitStrategy specifies how the blocking Pop operations wait
+	// while the Stack is empty. The default WaitStrategy is WaitStrategySpin
+	WaitStrategy WaitStrategy
+}
+
+// NewStack creates and returns an unbounded lock-free Stack with the given options
+// Any number of goroutines may Push and Pop concurrently. Push never blocks since
+// the Stack is never full
+//
+// The Stack is a Treiber stack. The nodes are allocated in chunks and recycled
+// through a free list, so that a steady workload does not allocate. The nodes
+// are referred to by their indices tagged with a version, which prevents the
+// ABA problem of the recycled nodes
+func NewStack[ItemType any](opts ...func(options *StackOptions)) (Stack[ItemType], error) {
+	o := &StackOptions{
+		Nonblocking: false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	s := &treiberStack[ItemType]{
+		StackOptions: o,
+		waits:        &ringQueueWaits{strategy: o.WaitStrategy, notEmpty: newParker(), notFull: newParker()},
+	}
+
+	return s, nil
+}
+
+const (
+	// the heads are the node ids in the low 32 bits, the closed flag,
+	// and the version in the remaining high bits
+	treiberStackIdMask     = 1<<32 - 1
+	treiberStackClosed     = 1 << 32
+	treiberStackVersionOne = 1 << 33
+
+	// the chunk k holds 1<<(k+treiberStackChunkShift) nodes
+	treiberStackChunkShift = 8
+	treiberStackChunks     = 32 - treiberStackChunkShift
+)
+
+type treiberStackNode[T any] struct {
+	item T
+	next atomic.Uint32
+}
+
+type treiberStack[T any] struct {
+	*StackOptions
+	head   atomic.Uint64
+	free   atomic.Uint64
+	ids    atomic.Uint32
+	chunks [treiberStackChunks]atomic.Pointer[[]treiberStackNode[T]]
+	waits  *ringQueueWaits
+}
+
+func (s *treiberStack[T]) Push(item T) error {
+	_, err := s.PushMany([]T{item})
+	return err
+}
+
+func (s *treiberStack[T]) PushMany(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	if s.head.Load()&treiberStackClosed == treiberStackClosed {
+		return 0, io.ErrClosedPipe
+	}
+	// link the nodes of items so that the last item is the top
+	top, prev := uint32(0), uint32(0)
+	var bottom *treiberStackNode[T]
+	for i := range items {
+		id, err := s.alloc()
+		if err != nil {
+			if top != 0 {
+				s.release(top, bottom)
+			}
+			return 0, err
+		}
+		nd := s.node(id)
+		nd.item = items[i]
+		nd.next.Store(prev)
+		if bottom == nil {
+			bottom = nd
+		}
+		top, prev = id, id
+	}
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		head := s.head.Load()
+		if head&treiberStackClosed == treiberStackClosed {
+			s.release(top, bottom)
+			return 0, io.ErrClosedPipe
+		}
+		bottom.next.Store(uint32(head))
+		if s.head.CompareAndSwap(head, head&^treiberStackIdMask+treiberStackVersionOne|uint64(top)) {
+			break
+		}
+		sw.Once()
+	}
+	s.waits.produced()
+
+	return len(items), nil
+}
+
+func (s *treiberStack[T]) Pop() (item T, err error) {
+	return s.pop(s.Nonblocking)
+}
+
+func (s *treiberStack[T]) TryPop() (item T, ok bool) {
+	item, err := s.pop(true)
+	return item, err == nil
+}
+
+func (s *treiberStack[T]) pop(nonblocking bool) (item T, err error) {
+	items := [1]T{}
+	_, err = s.popN(items[:], nonblocking)
+	return items[0], err
+}
+
+func (s *treiberStack[T]) PopN(items []T) (n int, err error) {
+	if len(items) < 1 {
+		return 0, nil
+	}
+	return s.popN(items, s.Nonblocking)
+}
+
+func (s *treiberStack[T]) popN(items []T, nonblocking bool) (n int, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelConsume)
+	w := s.waits.notEmptyWait(sw)
+	defer w.done()
+	for {
+		head := s.head.Load()
+		top := uint32(head)
+		if top == 0 {
+			if head&treiberStackClosed == treiberStackClosed {
+				return 0, io.EOF
+			}
+			if nonblocking {
+				return 0, ErrTemporarilyUnavailable
+			}
+			w.once()
+			continue
+		}
+		// the nodes under an unchanged head are unchanged, since any
+		// push or pop changes the version of the head
+		n, next, bottom := 1, s.node(top).next.Load(), s.node(top)
+		for ; n < len(items) && next != 0; n++ {
+			bottom = s.node(next)
+			next = bottom.next.Load()
+		}
+		if !s.head.CompareAndSwap(head, head&^treiberStackIdMask+treiberStackVersionOne|uint64(next)) {
+			sw.Once()
+			continue
+		}
+		for i, id := 0, top; i < n; i++ {
+			nd := s.node(id)
+			items[i], nd.item = nd.item, *new(T)
+			id = nd.next.Load()
+		}
+		s.release(top, bottom)
+		s.waits.consumed()
+		return n, nil
+	}
+}
+
+func (s *treiberStack[T]) Close() error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		head := s.head.Load()
+		if head&treiberStackClosed == treiberStackClosed {
+			return nil
+		}
+		if s.head.CompareAndSwap(head, head+treiberStackVersionOne|treiberStackClosed) {
+			break
+		}
+		sw.Once()
+	}
+	s.waits.closed()
+
+	return nil
+}
+
+// alloc takes a node from the free list, or a new node if the free list is empty
+func (s *treiberStack[T]) alloc() (id uint32, err error) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		free := s.free.Load()
+		id = uint32(free)
+		if id == 0 {
+			break
+		}
+		next := s.node(id).next.Load()
+		if s.free.CompareAndSwap(free, free&^treiberStackIdMask+treiberStackVersionOne|uint64(next)) {
+			return id, nil
+		}
+		sw.Once()
+	}
+	id = s.ids.Add(1)
+	if id == 0 || id > treiberStackIdMask-1<<treiberStackChunkShift {
+		s.ids.Add(^uint32(0))
+		return 0, ErrNoAvailableMemory
+	}
+	k, _ := treiberStackChunk(id)
+	if s.chunks[k].Load() == nil {
+		chunk := make([]treiberStackNode[T], 1<<(k+treiberStackChunkShift))
+		s.chunks[k].CompareAndSwap(nil, &chunk)
+	}
+
+	return id, nil
+}
+
+// release puts the linked nodes from top to bottom back to the free list
+func (s *treiberStack[T]) release(top uint32, bottom *treiberStackNode[T]) {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		free := s.free.Load()
+		bottom.next.Store(uint32(free))
+		if s.free.CompareAndSwap(free, free&^treiberStackIdMask+treiberStackVersionOne|uint64(top)) {
+			return
+		}
+		sw.Once()
+	}
+}
+
+func (s *treiberStack[T]) node(id uint32) *treiberStackNode[T] {
+	k, i := treiberStackChunk(id)
+	return &(*s.chunks[k].Load())[i]
+}
+
+// treiberStackChunk returns the chunk k and the index i in the chunk of the node id
+func treiberStackChunk(id uint32) (k int, i uint32) {
+	x := id - 1 + 1<<treiberStackChunkShift
+	k = bits.Len32(x) - 1 - treiberStackChunkShift
+	return k, x - 1<<(k+treiberStackChunkShift)
+}
@@ -0,0 +1,145 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"hybscloud.com/sox"
+	"io"
+	"testing"
+)
+
+func TestStack_Series(t *testing.T) {
+	s, err := sox.NewStack[int](func(options *sox.StackOptions) {
+		options.Nonblocking = true
+	})
+	if err != nil {
+		t.Errorf("stack new: %v", err)
+		return
+	}
+	item, err := s.Pop()
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("stack pop expected ErrTemporarilyUnavailable but got %v %v", item, err)
+		return
+	}
+	// more than a chunk of nodes, twice to reuse the freed nodes
+	for range 2 {
+		for i := range 0x1000 {
+			err = s.Push(i)
+			if err != nil {
+				t.Errorf("stack push: %v", err)
+				return
+			}
+		}
+		for i := 0xfff; i >= 0; i-- {
+			item, err = s.Pop()
+			if err != nil || item != i {
+				t.Errorf("stack pop expected %d but got %d: %v", i, item, err)
+				return
+			}
+		}
+		if item, ok := s.TryPop(); ok {
+			t.Errorf("stack try pop expected not ok but got %d", item)
+			return
+		}
+	}
+
+	n, err := s.PushMany([]int{1, 2, 3, 4, 5})
+	if n != 5 || err != nil {
+		t.Errorf("stack push many 5 items: %d %v", n, err)
+		return
+	}
+	items := make([]int, 4)
+	n, err = s.PopN(items)
+	if n != 4 || err != nil || items[0] != 5 || items[1] != 4 || items[2] != 3 || items[3] != 2 {
+		t.Errorf("stack pop n expected [5 4 3 2] but got %v: %v", items[:n], err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Errorf("stack close: %v", err)
+		return
+	}
+	err = s.Push(6)
+	if err != io.ErrClosedPipe {
+		t.Errorf("stack push expected io.ErrClosedPipe but got %v", err)
+		return
+	}
+	n, err = s.PopN(items)
+	if n != 1 || err != nil || items[0] != 1 {
+		t.Errorf("stack pop n expected [1] but got %v: %v", items[:n], err)
+		return
+	}
+	item, err = s.Pop()
+	if err != io.EOF {
+		t.Errorf("stack pop expected io.EOF but got %v %v", item, err)
+		return
+	}
+}
+
+func TestStack_Concurrent(t *testing.T) {
+	t.Run("4 push goroutines 4 pop goroutines", func(t *testing.T) {
+		s, err := sox.NewStack[int64]()
+		if err != nil {
+			t.Errorf("stack new: %v", err)
+			return
+		}
+		testFixedStackConcurrent(t, s, 0x04, 0x2000)
+	})
+
+	t.Run("64 push goroutines 64 pop goroutines", func(t *testing.T) {
+		s, err := sox.NewStack[int64]()
+		if err != nil {
+			t.Errorf("stack new: %v", err)
+			return
+		}
+		testFixedStackConcurrent(t, s, 0x40, 0x2000)
+	})
+
+	t.Run("park close wakes pop", func(t *testing.T) {
+		s, err := sox.NewStack[int](func(options *sox.StackOptions) {
+			options.WaitStrategy = sox.WaitStrategyPark
+		})
+		if err != nil {
+			t.Errorf("stack new: %v", err)
+			return
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.Pop()
+			done <- err
+		}()
+		err = s.Close()
+		if err != nil {
+			t.Errorf("stack close: %v", err)
+			return
+		}
+		if err = <-done; err != io.EOF {
+			t.Errorf("stack pop expected io.EOF but got %v", err)
+			return
+		}
+	})
+}
+
+func BenchmarkStack(b *testing.B) {
+	b.Run("4 push goroutines 4 pop goroutines", func(b *testing.B) {
+		s, err := sox.NewStack[int]()
+		if err != nil {
+			b.Errorf("stack new: %v", err)
+			return
+		}
+		b.ResetTimer()
+		benchmarkFixedStackConcurrent(b, s, 4)
+	})
+
+	b.Run("64 push goroutines 64 pop goroutines", func(b *testing.B) {
+		s, err := sox.NewStack[int]()
+		if err != nil {
+			b.Errorf("stack new: %v", err)
+			return
+		}
+		b.ResetTimer()
+		benchmarkFixedStackConcurrent(b, s, 64)
+	})
+}
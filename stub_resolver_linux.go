@@ -0,0 +1,312 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsHeaderLength   = 12
+	dnsUDPMaxLength   = 512
+	dnsTypeA          = 1
+	dnsTypeAAAA       = 28
+	dnsClassINET      = 1
+	dnsFlagResponse   = 1 << 15
+	dnsFlagTruncated  = 1 << 9
+	dnsFlagRecursion  = 1 << 8
+	dnsRCodeMask      = 0xf
+	dnsRCodeNameError = 3
+	dnsNameMaxLength  = 255
+	dnsLabelMaxLength = 63
+	dnsPointerMask    = 0xc0
+
+	stubResolverDefaultTimeout = 5 * time.Second
+)
+
+var errDNSMalformed = errors.New("malformed dns message")
+
+// StubResolver is a minimal DNS stub resolver implemented on sox sockets
+// It queries the A and AAAA records of a host to the recursive Server
+// over UDP, and retries over TCP if the response has been truncated
+type StubResolver struct {
+	// Server is the address of the recursive DNS server
+	Server netip.AddrPort
+	// Timeout is the timeout of each query. Zero means 5 seconds
+	Timeout time.Duration
+}
+
+// LookupIPAddr looks up host with the Server and returns its IPv4 and IPv6 addresses
+func (r *StubResolver) LookupIPAddr(ctx context.Context, host string) ([]IPAddr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []IPAddr{{IP: ip.AsSlice(), Zone: ip.Zone()}}, nil
+	}
+	var addrs []IPAddr
+	var lastErr error
+	for _, qtype := range [...]uint16{dnsTypeA, dnsTypeAAAA} {
+		answers, err := r.lookup(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs = append(addrs, answers...)
+	}
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.Server.String(), IsNotFound: true}
+	}
+
+	return addrs, nil
+}
+
+func (r *StubResolver) lookup(ctx context.Context, host string, qtype uint16) ([]IPAddr, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = stubResolverDefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	id := uint16(rand.Uint32())
+	query, err := dnsQuery(id, host, qtype)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host, Server: r.Server.String()}
+	}
+
+	resp, err := r.exchangeUDP(ctx, query, deadline)
+	if err != nil {
+		return nil, r.dnsError(host, err)
+	}
+	answers, truncated, err := dnsAnswers(resp, id, qtype)
+	if truncated {
+		resp, err = r.exchangeTCP(ctx, query, deadline)
+		if err != nil {
+			return nil, r.dnsError(host, err)
+		}
+		answers, _, err = dnsAnswers(resp, id, qtype)
+	}
+	if err != nil {
+		return nil, r.dnsError(host, err)
+	}
+
+	return answers, nil
+}
+
+func (r *StubResolver) dnsError(host string, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		dnsErr.Name, dnsErr.Server = host, r.Server.String()
+		return dnsErr
+	}
+	return &net.DNSError{
+		Err:       err.Error(),
+		Name:      host,
+		Server:    r.Server.String(),
+		IsTimeout: errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded),
+	}
+}
+
+func (r *StubResolver) exchangeUDP(ctx context.Context, query []byte, deadline time.Time) ([]byte, error) {
+	server := netip.AddrPortFrom(r.Server.Addr().Unmap(), r.Server.Port())
+	var conn *UDPConn
+	var err error
+	if server.Addr().Is4() {
+		conn, err = DialUDP4(&UDPAddr{IP: IPV4zero}, UDPAddrFromAddrPort(server))
+	} else {
+		conn, err = DialUDP6(&UDPAddr{IP: IPV6unspecified}, UDPAddrFromAddrPort(server))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	p, err := newFdPoller(conn.Fd())
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	_, err = conn.Write(query)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsUDPMaxLength)
+	for {
+		n, err := conn.Read(buf)
+		if err == ErrTemporarilyUnavailable || err == ErrInterruptedSyscall {
+			err = pollWait(ctx, p, conn.Fd(), PollIn, deadline)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// responses of other queries are ignored
+		if n < dnsHeaderLength || binary.BigEndian.Uint16(buf) != binary.BigEndian.Uint16(query) {
+			continue
+		}
+		return buf[:n], nil
+	}
+}
+
+func (r *StubResolver) exchangeTCP(ctx context.Context, query []byte, deadline time.Time) ([]byte, error) {
+	server := netip.AddrPortFrom(r.Server.Addr().Unmap(), r.Server.Port())
+	var conn *TCPConn
+	var err error
+	if server.Addr().Is4() {
+		conn, err = DialTCP4(&TCPAddr{IP: IPV4zero}, TCPAddrFromAddrPort(server))
+	} else {
+		conn, err = DialTCP6(&TCPAddr{IP: IPV6unspecified}, TCPAddrFromAddrPort(server))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	p, err := newFdPoller(conn.Fd())
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	// wait for the connection to be established
+	err = pollWait(ctx, p, conn.Fd(), PollOut, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	err = pollWriteAll(ctx, conn, p, msg, deadline)
+	if err != nil {
+		return nil, err
+	}
+	length := [2]byte{}
+	err = pollReadFull(ctx, conn, p, length[:], deadline)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	err = pollReadFull(ctx, conn, p, resp, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// dnsQuery builds a recursive query of the qtype records of name
+func dnsQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name)+2 > dnsNameMaxLength {
+		return nil, errors.New("invalid domain name")
+	}
+	b := make([]byte, dnsHeaderLength, dnsHeaderLength+len(name)+2+4)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > dnsLabelMaxLength {
+			return nil, errors.New("invalid domain name")
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	b = binary.BigEndian.AppendUint16(b, dnsClassINET)
+
+	return b, nil
+}
+
+// dnsAnswers parses the response of the query id and returns the addresses of
+// the qtype answers, and whether the response has been truncated
+func dnsAnswers(b []byte, id uint16, qtype uint16) (addrs []IPAddr, truncated bool, err error) {
+	if len(b) < dnsHeaderLength || binary.BigEndian.Uint16(b) != id {
+		return nil, false, errDNSMalformed
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, false, errDNSMalformed
+	}
+	if flags&dnsFlagTruncated != 0 {
+		return nil, true, nil
+	}
+	switch flags & dnsRCodeMask {
+	case 0:
+	case dnsRCodeNameError:
+		return nil, false, &net.DNSError{Err: "no such host", IsNotFound: true}
+	default:
+		return nil, false, &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+	}
+	qdCount, anCount := binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:])
+	off := dnsHeaderLength
+	for range qdCount {
+		off, err = dnsSkipName(b, off)
+		if err != nil || off+4 > len(b) {
+			return nil, false, errDNSMalformed
+		}
+		off += 4
+	}
+	for range anCount {
+		off, err = dnsSkipName(b, off)
+		if err != nil || off+10 > len(b) {
+			return nil, false, errDNSMalformed
+		}
+		rtype, class := binary.BigEndian.Uint16(b[off:]), binary.BigEndian.Uint16(b[off+2:])
+		length := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+length > len(b) {
+			return nil, false, errDNSMalformed
+		}
+		rdata := b[off : off+length]
+		off += length
+		if rtype != qtype || class != dnsClassINET {
+			continue
+		}
+		if rtype == dnsTypeA && length == net.IPv4len || rtype == dnsTypeAAAA && length == net.IPv6len {
+			addrs = append(addrs, IPAddr{IP: append(IP(nil), rdata...)})
+		}
+	}
+
+	return addrs, false, nil
+}
+
+// dnsSkipName returns the offset next to the possibly compressed name at off
+func dnsSkipName(b []byte, off int) (int, error) {
+	for range dnsNameMaxLength {
+		if off >= len(b) {
+			return 0, errDNSMalformed
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&dnsPointerMask == dnsPointerMask:
+			if off+2 > len(b) {
+				return 0, errDNSMalformed
+			}
+			return off + 2, nil
+		case l&dnsPointerMask != 0:
+			return 0, errDNSMalformed
+		}
+		off += 1 + l
+	}
+
+	return 0, errDNSMalformed
+}
@@ -0,0 +1,155 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// dnsTestResponse answers the query with the A record 192.0.2.1 of example.com
+// and no AAAA records, or with the truncated flag if truncate is true
+func dnsTestResponse(query []byte, truncate bool) []byte {
+	resp := append([]byte(nil), query...)
+	flags := uint16(0x8180)
+	if truncate {
+		flags |= 1 << 9
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	qtype := binary.BigEndian.Uint16(query[len(query)-4:])
+	if string(query[12:len(query)-4]) != "\x07example\x03com\x00" {
+		binary.BigEndian.PutUint16(resp[2:], flags|3)
+		return resp
+	}
+	if truncate || qtype != 1 {
+		return resp
+	}
+	binary.BigEndian.PutUint16(resp[6:], 1)
+	resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+	return resp
+}
+
+func TestStubResolver(t *testing.T) {
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Errorf("listen udp: %v", err)
+		return
+	}
+	defer udp.Close()
+	truncate := make(chan bool, 4)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			tc := false
+			select {
+			case tc = <-truncate:
+			default:
+			}
+			_, _ = udp.WriteToUDP(dnsTestResponse(buf[:n], tc), addr)
+		}
+	}()
+	server := udp.LocalAddr().(*net.UDPAddr).AddrPort()
+	r := &sox.StubResolver{Server: server, Timeout: time.Second}
+
+	t.Run("udp", func(t *testing.T) {
+		addrs, err := r.LookupIPAddr(context.Background(), "example.com")
+		if err != nil {
+			t.Errorf("lookup: %v", err)
+			return
+		}
+		if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+			t.Errorf("lookup expected 192.0.2.1 but got %v", addrs)
+			return
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := r.LookupIPAddr(context.Background(), "unknown.example")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("lookup expected not found but got %v", err)
+			return
+		}
+	})
+
+	t.Run("tcp fallback", func(t *testing.T) {
+		tcp, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(server.Port())})
+		if err != nil {
+			t.Skipf("listen tcp: %v", err)
+			return
+		}
+		defer tcp.Close()
+		go func() {
+			for {
+				conn, err := tcp.Accept()
+				if err != nil {
+					return
+				}
+				length := [2]byte{}
+				if _, err = io.ReadFull(conn, length[:]); err == nil {
+					query := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err = io.ReadFull(conn, query); err == nil {
+						resp := dnsTestResponse(query, false)
+						_, _ = conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+						_, _ = conn.Write(resp)
+					}
+				}
+				_ = conn.Close()
+			}
+		}()
+		truncate <- true
+		truncate <- true
+		addrs, err := r.LookupIPAddr(context.Background(), "example.com")
+		if err != nil {
+			t.Errorf("lookup: %v", err)
+			return
+		}
+		if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+			t.Errorf("lookup expected 192.0.2.1 but got %v", addrs)
+			return
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Errorf("listen udp: %v", err)
+			return
+		}
+		defer silent.Close()
+		r := &sox.StubResolver{Server: silent.LocalAddr().(*net.UDPAddr).AddrPort(), Timeout: 50 * time.Millisecond}
+		_, err = r.LookupIPAddr(context.Background(), "example.com")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsTimeout {
+			t.Errorf("lookup expected timeout but got %v", err)
+			return
+		}
+	})
+
+	t.Run("resolve sctp addr", func(t *testing.T) {
+		addr, err := sox.ResolveSCTPAddrContext(context.Background(), r, "sctp4", "example.com:9000")
+		if err != nil {
+			t.Errorf("resolve: %v", err)
+			return
+		}
+		if addr.String() != netip.MustParseAddrPort("192.0.2.1:9000").String() {
+			t.Errorf("resolve expected 192.0.2.1:9000 but got %v", addr)
+			return
+		}
+	})
+}
@@ -0,0 +1,113 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	taskQueueStatusClosed = 1 << 30
+)
+
+var taskNodePool = sync.Pool{New: func() any { return &mpscNode[func()]{} }}
+
+// TaskQueue is an unbounded queue which posts callbacks from arbitrary goroutines
+// into the polling goroutine. Posting never blocks and wakes the polling goroutine
+// up through an eventfd, which should be polled for reading with the Fd method
+type TaskQueue struct {
+	q        *mpscQueue[func()]
+	efd      PollUintReadWriteCloser
+	signaled atomic.Bool
+	// status holds the closed bit and the number of in-flight Execute calls
+	status atomic.Int32
+}
+
+// NewTaskQueue creates and returns a new TaskQueue
+func NewTaskQueue() (*TaskQueue, error) {
+	efd, err := NewEventfd()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TaskQueue{q: newMPSCQueue[func()](), efd: efd}, nil
+}
+
+// Fd returns the file descriptor of the eventfd which becomes readable
+// when there are any tasks to run
+func (tq *TaskQueue) Fd() int {
+	return tq.efd.Fd()
+}
+
+// Execute posts fn to be run by the goroutine which calls Run
+// It is safe to be called from any goroutine. It returns io.ErrClosedPipe
+// if the TaskQueue is already closed
+func (tq *TaskQueue) Execute(fn func()) error {
+	if fn == nil {
+		return ErrInvalidParam
+	}
+	if tq.status.Add(1)&taskQueueStatusClosed != 0 {
+		tq.status.Add(-1)
+		return io.ErrClosedPipe
+	}
+	defer tq.status.Add(-1)
+	n := taskNodePool.Get().(*mpscNode[func()])
+	n.value = fn
+	tq.q.push(n)
+	if tq.signaled.CompareAndSwap(false, true) {
+		return tq.efd.WriteUint(1)
+	}
+
+	return nil
+}
+
+// Run runs all of the posted tasks in the calling goroutine and
+// returns the number of tasks have been run
+// Run must only be called by one goroutine, typically the polling goroutine
+// after the Fd became readable
+func (tq *TaskQueue) Run() (n int, err error) {
+	_, err = tq.efd.ReadUint()
+	if err != nil && err != ErrTemporarilyUnavailable {
+		return 0, err
+	}
+	tq.signaled.Store(false)
+	for {
+		node := tq.q.pop()
+		if node == nil {
+			break
+		}
+		fn := node.value
+		node.value = nil
+		taskNodePool.Put(node)
+		fn()
+		n++
+	}
+
+	return n, nil
+}
+
+// Close closes the TaskQueue. The tasks which have not been run yet are dropped
+func (tq *TaskQueue) Close() error {
+	sw := NewSpinWait().SetLevel(SpinWaitLevelAtomic)
+	for {
+		status := tq.status.Load()
+		if status&taskQueueStatusClosed != 0 {
+			return nil
+		}
+		if tq.status.CompareAndSwap(status, status|taskQueueStatusClosed) {
+			break
+		}
+		sw.Once()
+	}
+	for tq.status.Load() != taskQueueStatusClosed {
+		sw.Once()
+	}
+
+	return tq.efd.Close()
+}
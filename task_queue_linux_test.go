@@ -0,0 +1,99 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox_test
+
+import (
+	"golang.org/x/sys/unix"
+	"hybscloud.com/sox"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestTaskQueue_Execute(t *testing.T) {
+	tq, err := sox.NewTaskQueue()
+	if err != nil {
+		t.Errorf("new task queue: %v", err)
+		return
+	}
+	defer tq.Close()
+
+	const producers, n = 8, 1000
+	wg := sync.WaitGroup{}
+	for i := range producers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for range n {
+				err := tq.Execute(func() {})
+				if err != nil {
+					t.Errorf("task queue execute: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	total := 0
+	for total < producers*n {
+		fds := []unix.PollFd{{Fd: int32(tq.Fd()), Events: unix.POLLIN}}
+		_, err = unix.Poll(fds, 1000)
+		if err != nil && err != unix.EINTR {
+			t.Errorf("poll task queue: %v", err)
+			return
+		}
+		if fds[0].Revents&unix.POLLIN == 0 {
+			t.Errorf("task queue wakeup lost after %d tasks", total)
+			return
+		}
+		ran, err := tq.Run()
+		if err != nil {
+			t.Errorf("task queue run: %v", err)
+			return
+		}
+		total += ran
+	}
+	wg.Wait()
+	if total != producers*n {
+		t.Errorf("task queue expected %d tasks but ran %d", producers*n, total)
+		return
+	}
+}
+
+func TestTaskQueue_Order(t *testing.T) {
+	tq, err := sox.NewTaskQueue()
+	if err != nil {
+		t.Errorf("new task queue: %v", err)
+		return
+	}
+	seq := make([]int, 0, 8)
+	for i := range 8 {
+		_ = tq.Execute(func() { seq = append(seq, i) })
+	}
+	n, err := tq.Run()
+	if err != nil || n != 8 {
+		t.Errorf("task queue run expected 8 tasks but got %d %v", n, err)
+		return
+	}
+	for i, v := range seq {
+		if i != v {
+			t.Errorf("task queue expected task %d but got %d", i, v)
+			return
+		}
+	}
+	n, err = tq.Run()
+	if err != nil || n != 0 {
+		t.Errorf("task queue run expected no tasks but got %d %v", n, err)
+		return
+	}
+	_ = tq.Close()
+	err = tq.Execute(func() {})
+	if err != io.ErrClosedPipe {
+		t.Errorf("task queue execute expected %v but got %v", io.ErrClosedPipe, err)
+		return
+	}
+}
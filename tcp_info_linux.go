@@ -0,0 +1,151 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strconv"
+	"time"
+)
+
+// TCPState is the state of a TCP connection
+type TCPState uint8
+
+const (
+	TCPStateEstablished TCPState = iota + 1
+	TCPStateSynSent
+	TCPStateSynRecv
+	TCPStateFinWait1
+	TCPStateFinWait2
+	TCPStateTimeWait
+	TCPStateClose
+	TCPStateCloseWait
+	TCPStateLastAck
+	TCPStateListen
+	TCPStateClosing
+	TCPStateNewSynRecv
+)
+
+var tcpStateNames = [...]string{
+	TCPStateEstablished: "ESTAB",
+	TCPStateSynSent:     "SYN-SENT",
+	TCPStateSynRecv:     "SYN-RECV",
+	TCPStateFinWait1:    "FIN-WAIT-1",
+	TCPStateFinWait2:    "FIN-WAIT-2",
+	TCPStateTimeWait:    "TIME-WAIT",
+	TCPStateClose:       "UNCONN",
+	TCPStateCloseWait:   "CLOSE-WAIT",
+	TCPStateLastAck:     "LAST-ACK",
+	TCPStateListen:      "LISTEN",
+	TCPStateClosing:     "CLOSING",
+	TCPStateNewSynRecv:  "SYN-RECV",
+}
+
+// String returns the name of the state as ss reports it
+func (s TCPState) String() string {
+	if int(s) < len(tcpStateNames) && tcpStateNames[s] != "" {
+		return tcpStateNames[s]
+	}
+	return "UNKNOWN(" + strconv.Itoa(int(s)) + ")"
+}
+
+// TCPCAState is the congestion avoidance state of a TCP connection
+type TCPCAState uint8
+
+const (
+	TCPCAOpen TCPCAState = iota
+	TCPCADisorder
+	TCPCACWR
+	TCPCARecovery
+	TCPCALoss
+)
+
+// TCPInfo is the snapshot of the TCP_INFO of a TCP connection
+// The fields which the running kernel does not report are zero
+type TCPInfo struct {
+	State   TCPState
+	CAState TCPCAState
+	// Retransmits is the number of the consecutive retransmissions of the
+	// unacknowledged segment, and TotalRetrans is of the connection lifetime
+	Retransmits  int
+	TotalRetrans int
+	RTT          time.Duration
+	RTTVar       time.Duration
+	MinRTT       time.Duration
+	RTO          time.Duration
+	SndMSS       int
+	RcvMSS       int
+	PMTU         int
+	// SndCwnd and SndSsthresh are in segments
+	SndCwnd     int
+	SndSsthresh int
+	SndWnd      int
+	RcvWnd      int
+	Unacked     int
+	Lost        int
+	// NotSentBytes is the number of the written bytes which are not sent yet
+	NotSentBytes int
+	// PacingRate, MaxPacingRate and DeliveryRate are in bytes per second
+	PacingRate    uint64
+	MaxPacingRate uint64
+	DeliveryRate  uint64
+	BytesSent     uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	BytesRetrans  uint64
+}
+
+// String returns the TCPInfo in the format of ss --info
+func (info *TCPInfo) String() string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s rto:%s rtt:%s/%s minrtt:%s mss:%d pmtu:%d cwnd:%d ssthresh:%d bytes_sent:%d bytes_retrans:%d bytes_acked:%d bytes_received:%d pacing_rate:%dbps delivery_rate:%dbps unacked:%d retrans:%d/%d lost:%d notsent:%d",
+		info.State, ms(info.RTO), ms(info.RTT), ms(info.RTTVar), ms(info.MinRTT), info.SndMSS, info.PMTU,
+		info.SndCwnd, info.SndSsthresh, info.BytesSent, info.BytesRetrans, info.BytesAcked, info.BytesReceived,
+		info.PacingRate*8, info.DeliveryRate*8, info.Unacked, info.Retransmits, info.TotalRetrans, info.Lost, info.NotSentBytes)
+}
+
+// Info returns the snapshot of the TCP_INFO of the connection
+func (conn *TCPConn) Info() (*TCPInfo, error) {
+	ti, err := unix.GetsockoptTCPInfo(conn.fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return nil, opError("getsockopt", conn.networkName("tcp"), conn.laddr, conn.raddr, errFromUnixErrno(err))
+	}
+	usec := func(v uint32) time.Duration {
+		return time.Duration(v) * time.Microsecond
+	}
+	info := &TCPInfo{
+		State:         TCPState(ti.State),
+		CAState:       TCPCAState(ti.Ca_state),
+		Retransmits:   int(ti.Retransmits),
+		TotalRetrans:  int(ti.Total_retrans),
+		RTT:           usec(ti.Rtt),
+		RTTVar:        usec(ti.Rttvar),
+		MinRTT:        usec(ti.Min_rtt),
+		RTO:           usec(ti.Rto),
+		SndMSS:        int(ti.Snd_mss),
+		RcvMSS:        int(ti.Rcv_mss),
+		PMTU:          int(ti.Pmtu),
+		SndCwnd:       int(ti.Snd_cwnd),
+		SndSsthresh:   int(ti.Snd_ssthresh),
+		SndWnd:        int(ti.Snd_wnd),
+		RcvWnd:        int(ti.Rcv_wnd),
+		Unacked:       int(ti.Unacked),
+		Lost:          int(ti.Lost),
+		NotSentBytes:  int(ti.Notsent_bytes),
+		PacingRate:    ti.Pacing_rate,
+		MaxPacingRate: ti.Max_pacing_rate,
+		DeliveryRate:  ti.Delivery_rate,
+		BytesSent:     ti.Bytes_sent,
+		BytesAcked:    ti.Bytes_acked,
+		BytesReceived: ti.Bytes_received,
+		BytesRetrans:  ti.Bytes_retrans,
+	}
+	return info, nil
+}
@@ -9,6 +9,7 @@ package sox
 import (
 	"errors"
 	"golang.org/x/sys/unix"
+	"net/netip"
 	"time"
 )
 
@@ -76,6 +77,14 @@ func NewTCPConn(localAddr Addr, remoteSock *TCPSocket) (Conn, error) {
 	return &TCPConn{TCPSocket: remoteSock, laddr: tcpAddr, raddr: remoteAddr}, nil
 }
 
+func (conn *TCPConn) Read(b []byte) (n int, err error) {
+	n, err = conn.TCPSocket.Read(b)
+	return n, opError("read", conn.networkName("tcp"), conn.laddr, conn.raddr, err)
+}
+func (conn *TCPConn) Write(b []byte) (n int, err error) {
+	n, err = conn.TCPSocket.Write(b)
+	return n, opError("write", conn.networkName("tcp"), conn.laddr, conn.raddr, err)
+}
 func (conn *TCPConn) LocalAddr() Addr {
 	return conn.laddr
 }
@@ -98,15 +107,30 @@ type TCPListener struct {
 }
 
 func (l *TCPListener) Accept() (Conn, error) {
-	nfd, sa, err := acceptWait(l.fd)
+	return l.accept(acceptWait)
+}
+
+func (l *TCPListener) acceptOnce() (Conn, error) {
+	return l.accept(acceptOnce)
+}
+
+func (l *TCPListener) accept(accept4 func(fd int) (int, unix.Sockaddr, error)) (Conn, error) {
+	nfd, sa, err := accept4(l.fd)
 	if err != nil {
-		return nil, err
+		return nil, opError("accept", l.networkName("tcp"), nil, l.Addr(), err)
 	}
 
-	so := &TCPSocket{socket: newSocket(l.network, nfd, sa)}
-	conn, err := NewTCPConn(l.Addr(), so)
+	// the listener may be bound to the wildcard address
+	lsa, rsa := sockname(nfd, nil), peername(nfd, sa)
+	laddr := l.Addr()
+	if lsa != nil {
+		laddr = TCPAddrFromAddrPort(addrPortFromSockaddr(lsa))
+	}
+	so := &TCPSocket{socket: newSocket(l.network, nfd, rsa)}
+	conn, err := NewTCPConn(laddr, so)
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("accept", l.networkName("tcp"), nil, l.Addr(), err)
 	}
 	return conn, err
 }
@@ -124,17 +148,18 @@ func ListenTCP4(laddr *TCPAddr) (*TCPListener, error) {
 	}
 	so, err := newTCPSocket(tcp4AddrToSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "tcp4", nil, laddr, err)
 	}
 	err = unix.Bind(so.fd, tcp4AddrToSockaddr(laddr))
-	if err != nil {
-		return nil, errFromUnixErrno(err)
+	if err == nil {
+		err = listen(so.fd)
 	}
-	err = unix.Listen(so.fd, defaultBacklog)
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "tcp4", nil, laddr, errFromUnixErrno(err))
 	}
 
+	laddr = TCPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	lis := &TCPListener{TCPSocket: so, laddr: laddr}
 	return lis, nil
 }
@@ -143,19 +168,23 @@ func ListenTCP6(laddr *TCPAddr) (*TCPListener, error) {
 	if laddr == nil {
 		return nil, InvalidAddrError("nil local address")
 	}
+	if err := zoneError(laddr); err != nil {
+		return nil, opError("listen", "tcp6", nil, laddr, err)
+	}
 	so, err := newTCPSocket(tcp6AddrToSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "tcp6", nil, laddr, err)
 	}
 	err = unix.Bind(so.fd, tcp6AddrToSockaddr(laddr))
-	if err != nil {
-		return nil, errFromUnixErrno(err)
+	if err == nil {
+		err = listen(so.fd)
 	}
-	err = unix.Listen(so.fd, defaultBacklog)
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "tcp6", nil, laddr, errFromUnixErrno(err))
 	}
 
+	laddr = TCPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	lis := &TCPListener{TCPSocket: so, laddr: laddr}
 	return lis, nil
 }
@@ -164,15 +193,42 @@ func DialTCP4(laddr *TCPAddr, raddr *TCPAddr) (*TCPConn, error) {
 	if raddr == nil {
 		return nil, &OpError{Op: "dial", Net: "tcp4", Source: laddr, Addr: nil, Err: errors.New("missing address")}
 	}
-	so, err := newTCPSocket(tcp4AddrToSockaddr(laddr))
+	return dialTCP("tcp4", laddr, raddr, "")
+}
+
+func DialTCP6(laddr *TCPAddr, raddr *TCPAddr) (*TCPConn, error) {
+	if raddr == nil {
+		return nil, &OpError{Op: "dial", Net: "tcp6", Source: laddr, Addr: nil, Err: errors.New("missing address")}
+	}
+	if err := zoneError(laddr, raddr); err != nil {
+		return nil, opError("dial", "tcp6", laddr, raddr, err)
+	}
+	return dialTCP("tcp6", laddr, raddr, "")
+}
+
+// dialTCP connects to raddr on the network tcp4 or tcp6. The socket is bound
+// to the network interface ifname before connecting unless ifname is empty
+func dialTCP(network string, laddr *TCPAddr, raddr *TCPAddr, ifname string) (*TCPConn, error) {
+	toSockaddr := tcp4AddrToSockaddr
+	if network == "tcp6" {
+		toSockaddr = tcp6AddrToSockaddr
+	}
+	so, err := newTCPSocket(toSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("dial", network, laddr, raddr, err)
+	}
+	if ifname != "" {
+		err = SetBindToDevice(so.fd, ifname)
+	}
+	if err == nil {
+		err = connectWait(so.fd, toSockaddr(raddr))
 	}
-	err = connectWait(so.fd, tcp4AddrToSockaddr(raddr))
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("dial", network, laddr, raddr, err)
 	}
 
+	laddr = TCPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	conn := &TCPConn{
 		TCPSocket: so,
 		laddr:     laddr,
@@ -181,25 +237,43 @@ func DialTCP4(laddr *TCPAddr, raddr *TCPAddr) (*TCPConn, error) {
 	return conn, nil
 }
 
-func DialTCP6(laddr *TCPAddr, raddr *TCPAddr) (*TCPConn, error) {
-	if raddr == nil {
-		return nil, &OpError{Op: "dial", Net: "udp6", Source: laddr, Addr: nil, Err: errors.New("missing address")}
+// ListenTCPAddrPort listens on laddr with ListenTCP4 if laddr is an IPv4
+// or IPv4-mapped IPv6 address, and with ListenTCP6 otherwise
+func ListenTCPAddrPort(laddr netip.AddrPort) (*TCPListener, error) {
+	if !laddr.IsValid() {
+		return nil, InvalidAddrError("invalid local address")
 	}
-	so, err := newTCPSocket(tcp6AddrToSockaddr(laddr))
-	if err != nil {
-		return nil, err
-	}
-	err = connectWait(so.fd, tcp6AddrToSockaddr(raddr))
-	if err != nil {
-		return nil, err
+	if laddr = unmapAddrPort(laddr); laddr.Addr().Is4() {
+		return ListenTCP4(TCPAddrFromAddrPort(laddr))
 	}
+	return ListenTCP6(TCPAddrFromAddrPort(laddr))
+}
 
-	conn := &TCPConn{
-		TCPSocket: so,
-		laddr:     laddr,
-		raddr:     raddr,
+// DialTCPAddrPort connects to raddr with DialTCP4 if raddr is an IPv4
+// or IPv4-mapped IPv6 address, and with DialTCP6 otherwise
+// The zero laddr means any address
+func DialTCPAddrPort(laddr, raddr netip.AddrPort) (*TCPConn, error) {
+	return dialTCPAddrPort(laddr, raddr, "")
+}
+
+func dialTCPAddrPort(laddr, raddr netip.AddrPort, ifname string) (*TCPConn, error) {
+	if !raddr.IsValid() {
+		return nil, InvalidAddrError("invalid remote address")
 	}
-	return conn, nil
+	if raddr = unmapAddrPort(raddr); raddr.Addr().Is4() {
+		if !laddr.IsValid() {
+			laddr = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+		}
+		return dialTCP("tcp4", TCPAddrFromAddrPort(unmapAddrPort(laddr)), TCPAddrFromAddrPort(raddr), ifname)
+	}
+	if !laddr.IsValid() {
+		laddr = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
+	la, ra := TCPAddrFromAddrPort(laddr), TCPAddrFromAddrPort(raddr)
+	if err := zoneError(la, ra); err != nil {
+		return nil, opError("dial", "tcp6", la, ra, err)
+	}
+	return dialTCP("tcp6", la, ra, ifname)
 }
 
 func newTCP4Socket() (fd int, err error) {
@@ -8,9 +8,17 @@ package sox_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
 	"hybscloud.com/sox"
 	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestTCPSocket_ReadWrite(t *testing.T) {
@@ -72,7 +80,7 @@ func TestTCPSocket_ReadWrite(t *testing.T) {
 		return
 	}
 
-	for sw := sox.NewParamSpinWait(); !sw.Closed(); sw.Once() {
+	for sw := sox.NewSpinWait(); !sw.Closed(); sw.Once() {
 		w := sox.NewMessageWriter(conn, sox.MessageOptionsTCPSocket)
 		n, err := w.Write(p)
 		if err != nil {
@@ -102,3 +110,445 @@ func TestTCPSocket_ReadWrite(t *testing.T) {
 		break
 	}
 }
+
+func TestTCPListener_FileLimit(t *testing.T) {
+	laddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("resolve tcp addr: %v", err)
+		return
+	}
+	lis, err := sox.ListenTCP4(laddr)
+	if err != nil {
+		t.Errorf("listen tcp: %v", err)
+		return
+	}
+	defer lis.Close()
+	sa, err := unix.Getsockname(sox.GetFd(lis))
+	if err != nil {
+		t.Errorf("getsockname: %v", err)
+		return
+	}
+	client, err := net.Dial("tcp4", fmt.Sprintf("127.0.0.1:%d", sa.(*unix.SockaddrInet4).Port))
+	if err != nil {
+		t.Errorf("dial tcp: %v", err)
+		return
+	}
+	defer client.Close()
+
+	// run out of the fds of the process
+	rlimit := unix.Rlimit{}
+	err = unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit)
+	if err != nil {
+		t.Errorf("getrlimit: %v", err)
+		return
+	}
+	fds := make([]int, 0, 64)
+	defer func() {
+		for _, fd := range fds {
+			_ = unix.Close(fd)
+		}
+	}()
+	limited := rlimit
+	limited.Cur = uint64(sox.GetFd(lis) + 64)
+	err = unix.Setrlimit(unix.RLIMIT_NOFILE, &limited)
+	if err != nil {
+		t.Skipf("setrlimit: %v", err)
+	}
+	defer unix.Setrlimit(unix.RLIMIT_NOFILE, &rlimit)
+	for {
+		fd, err := unix.Dup(sox.GetFd(lis))
+		if err != nil {
+			break
+		}
+		fds = append(fds, fd)
+	}
+
+	_, err = lis.Accept()
+	if !errors.Is(err, sox.ErrProcessFileLimit) {
+		t.Errorf("accept expected ErrProcessFileLimit but got %v", err)
+		return
+	}
+	// the pending connection must have been shed
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	if err != io.EOF && !errors.Is(err, unix.ECONNRESET) {
+		t.Errorf("read shed connection expected EOF but got %v", err)
+		return
+	}
+}
+
+func TestTCPAddrPort(t *testing.T) {
+	// an IPv4-mapped IPv6 address listens and dials on IPv4
+	laddr := netip.MustParseAddrPort("[::ffff:127.0.0.1]:8200")
+	l, err := sox.ListenTCPAddrPort(laddr)
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	if l.Addr().String() != "127.0.0.1:8200" {
+		t.Errorf("listen expected address 127.0.0.1:8200 but got %v", l.Addr())
+		return
+	}
+	accepted := make(chan sox.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+		}
+		accepted <- conn
+	}()
+	conn, err := sox.DialTCPAddrPort(netip.AddrPort{}, laddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	if rconn := <-accepted; rconn != nil {
+		_ = rconn.Close()
+	}
+
+	t.Run("unknown zone", func(t *testing.T) {
+		addr := &sox.TCPAddr{IP: net.IPv6loopback, Port: 8200, Zone: "sox-no-such-if"}
+		_, err := sox.ListenTCP6(addr)
+		addrErr := &sox.AddrError{}
+		if !errors.As(err, &addrErr) || addrErr.Addr != addr.Zone {
+			t.Errorf("listen expected the unknown zone but got %v", err)
+			return
+		}
+		_, err = sox.DialTCPAddrPort(netip.AddrPort{}, netip.MustParseAddrPort("[fe80::1%sox-no-such-if]:8200"))
+		if !errors.As(err, &addrErr) {
+			t.Errorf("dial expected the unknown zone but got %v", err)
+			return
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := sox.ListenTCPAddrPort(netip.AddrPort{}); err == nil {
+			t.Errorf("listen expected error of the invalid address")
+			return
+		}
+		if _, err := sox.DialTCPAddrPort(netip.AddrPort{}, netip.AddrPort{}); err == nil {
+			t.Errorf("dial expected error of the invalid address")
+			return
+		}
+	})
+}
+
+func TestTCPBindToDevice(t *testing.T) {
+	lo, err := sox.NetworkInterfaceByName("lo")
+	if err != nil {
+		t.Errorf("loopback interface: %v", err)
+		return
+	}
+	if !slices.Contains(lo.Addrs, netip.MustParsePrefix("127.0.0.1/8")) || lo.IsVRF {
+		t.Errorf("loopback interface expected 127.0.0.1/8 but got %+v", lo)
+		return
+	}
+	ifs, err := sox.NetworkInterfaces()
+	if err != nil || !slices.ContainsFunc(ifs, func(ni sox.NetworkInterface) bool { return ni.Index == lo.Index }) {
+		t.Errorf("interfaces expected the loopback interface but got %v: %v", ifs, err)
+		return
+	}
+
+	laddr := netip.MustParseAddrPort("127.0.0.1:8203")
+	l, err := sox.ListenTCPAddrPort(laddr)
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	// the connection is established in the backlog before it is accepted
+	conn, err := sox.DialTCPDevice("lo", netip.AddrPort{}, laddr)
+	if errors.Is(err, sox.ErrNoPermission) {
+		t.Skipf("bind to device: %v", err)
+		return
+	}
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	rconn, err := l.Accept()
+	if err != nil {
+		t.Errorf("accept: %v", err)
+		return
+	}
+	_ = rconn.Close()
+	ifname, err := sox.BindToDevice(conn.Fd())
+	if err != nil || ifname != "lo" {
+		t.Errorf("bind to device expected lo but got %q: %v", ifname, err)
+		return
+	}
+
+	_, err = sox.DialTCPDevice("sox-no-such-if", netip.AddrPort{}, laddr)
+	if !errors.Is(err, sox.ErrNoDevice) {
+		t.Errorf("dial expected ErrNoDevice but got %v", err)
+		return
+	}
+}
+
+func TestTCPConn_Info(t *testing.T) {
+	laddr := netip.MustParseAddrPort("127.0.0.1:8204")
+	l, err := sox.ListenTCPAddrPort(laddr)
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer l.Close()
+	conn, err := sox.DialTCPAddrPort(netip.AddrPort{}, laddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	rconn, err := l.Accept()
+	if err != nil {
+		t.Errorf("accept: %v", err)
+		return
+	}
+	defer rconn.Close()
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	b := make([]byte, 16)
+	n, err := 0, sox.ErrTemporarilyUnavailable
+	for sw := sox.NewSpinWait().SetLimit(4096); err == sox.ErrTemporarilyUnavailable && !sw.Closed(); sw.Once() {
+		n, err = rconn.Read(b)
+	}
+	if err != nil || string(b[:n]) != "ping" {
+		t.Errorf("read expected ping but got %q: %v", b[:n], err)
+		return
+	}
+
+	info, err := conn.Info()
+	if err != nil {
+		t.Errorf("info: %v", err)
+		return
+	}
+	if info.State != sox.TCPStateEstablished || info.State.String() != "ESTAB" {
+		t.Errorf("info expected state ESTAB but got %v", info.State)
+		return
+	}
+	if info.SndCwnd <= 0 || info.SndMSS <= 0 || info.RTO <= 0 {
+		t.Errorf("info expected positive cwnd, mss and rto but got %v", info)
+		return
+	}
+	if info.BytesAcked == 0 && info.BytesSent < 4 {
+		t.Errorf("info expected the sent bytes but got %v", info)
+		return
+	}
+	if !strings.HasPrefix(info.String(), "ESTAB rto:") {
+		t.Errorf("info expected ss format but got %q", info.String())
+		return
+	}
+}
+
+func TestTCPSocket_Peek(t *testing.T) {
+	laddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8210")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lis, err := sox.ListenTCP4(laddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lis.Close()
+	raddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8211")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := sox.DialTCP4(raddr, laddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	accepted, err := lis.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer accepted.Close()
+
+	_, err = sox.NewMessageWriter(conn, sox.MessageOptionsTCPSocket).Write([]byte("hello"))
+	if err != nil {
+		t.Errorf("write message: %v", err)
+		return
+	}
+	peeker := accepted.(sox.Peeker)
+	var b []byte
+	for sw := sox.NewSpinWait(); !sw.Closed(); sw.Once() {
+		b, err = peeker.Peek(3)
+		if err != sox.ErrTemporarilyUnavailable {
+			break
+		}
+	}
+	if err != nil || !bytes.Equal(b, []byte("\x05he")) {
+		t.Errorf("peek expected %q but got %q: %v", "\x05he", b, err)
+		return
+	}
+	b, err = peeker.Peek(16)
+	if err != sox.ErrTemporarilyUnavailable || !bytes.Equal(b, []byte("\x05hello")) {
+		t.Errorf("peek expected the received bytes but got %q: %v", b, err)
+		return
+	}
+	p := make([]byte, 16)
+	n, err := sox.NewMessageReader(accepted, sox.MessageOptionsTCPSocket).Read(p)
+	if err != nil || string(p[:n]) != "hello" {
+		t.Errorf("read message expected hello but got %q: %v", p[:n], err)
+		return
+	}
+}
+
+func TestTCPSocket_OOB(t *testing.T) {
+	laddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8212")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lis, err := sox.ListenTCP4(laddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lis.Close()
+	raddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8213")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := sox.DialTCP4(raddr, laddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	accepted, err := lis.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer accepted.Close()
+
+	_, _, err = conn.WriteMsg([]byte("data"), nil, nil)
+	if err != nil {
+		t.Errorf("write msg: %v", err)
+		return
+	}
+	_, err = conn.WriteOOB([]byte("!"))
+	if err != nil {
+		t.Errorf("write oob: %v", err)
+		return
+	}
+	b := make([]byte, 16)
+	n := 0
+	for sw := sox.NewSpinWait(); !sw.Closed() && n < len("data"); sw.Once() {
+		rn, _, _, _, err := accepted.(sox.MsgConn).ReadMsg(b[n:len("data")], nil)
+		if err == sox.ErrTemporarilyUnavailable {
+			continue
+		}
+		if err != nil {
+			t.Errorf("read msg: %v", err)
+			return
+		}
+		n += rn
+	}
+	if string(b[:n]) != "data" {
+		t.Errorf("read msg expected data but got %q", b[:n])
+		return
+	}
+	// the urgent data may not have been received yet
+	for sw := sox.NewSpinWait(); !sw.Closed(); sw.Once() {
+		n, err = accepted.(*sox.TCPConn).ReadOOB(b)
+		if err != sox.ErrTemporarilyUnavailable && err != sox.ErrInvalidParam {
+			break
+		}
+	}
+	if err != nil || string(b[:n]) != "!" {
+		t.Errorf("read oob expected ! but got %q: %v", b[:n], err)
+		return
+	}
+}
+
+func TestTCPListener_AcceptedAddr(t *testing.T) {
+	laddr, err := sox.ResolveTCPAddr("tcp4", "0.0.0.0:8214")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lis, err := sox.ListenTCP4(laddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lis.Close()
+	daddr, err := sox.ResolveTCPAddr("tcp4", "127.0.0.1:8214")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := sox.DialTCP4(nil, daddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	accepted, err := lis.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer accepted.Close()
+
+	if accepted.LocalAddr().String() != "127.0.0.1:8214" {
+		t.Errorf("local addr expected %s but got %s", "127.0.0.1:8214", accepted.LocalAddr())
+		return
+	}
+	sa, err := unix.Getsockname(conn.Fd())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	raddr := fmt.Sprintf("127.0.0.1:%d", sa.(*unix.SockaddrInet4).Port)
+	if accepted.RemoteAddr().String() != raddr {
+		t.Errorf("remote addr expected %s but got %s", raddr, accepted.RemoteAddr())
+		return
+	}
+}
+
+func TestTCPListener_EphemeralPort(t *testing.T) {
+	lis, err := sox.ListenTCPAddrPort(netip.MustParseAddrPort("127.0.0.1:0"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lis.Close()
+	ap := lis.Addr().(*sox.TCPAddr).AddrPort()
+	if ap.Port() == 0 || ap.Addr() != netip.MustParseAddr("127.0.0.1") {
+		t.Errorf("listener expected the port assigned by the kernel but got %s", ap)
+		return
+	}
+	conn, err := sox.DialTCPAddrPort(netip.AddrPort{}, ap)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	accepted, err := lis.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer accepted.Close()
+
+	if conn.LocalAddr().(*sox.TCPAddr).Port == 0 || conn.LocalAddr().String() != accepted.RemoteAddr().String() {
+		t.Errorf("dialer local addr expected %s but got %s", accepted.RemoteAddr(), conn.LocalAddr())
+		return
+	}
+}
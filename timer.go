@@ -11,7 +11,23 @@ import (
 
 const jiffies = time.Millisecond
 
+// Timer is the interface of timers which can be polled
+// The Fd of a Timer becomes readable when the timer expires, then Read
+// should be called with a buffer of at least 8 bytes to acknowledge it
 type Timer interface {
+	pollFd
+	// Now returns the time when the timer expired last time
 	Now() time.Time
+	// Stop disarms the timer
+	Stop() error
+	// Reset rearms the timer with the duration d in the same mode as it was created
+	Reset(d time.Duration) error
 	io.ReadCloser
 }
+
+// TimerOptions holds optional parameters for Timer
+type TimerOptions struct {
+	// OneShot specifies whether the timer expires only once or periodically
+	// The default is periodically
+	OneShot bool
+}
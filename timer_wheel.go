@@ -0,0 +1,212 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	timerWheelLevelBits = 8
+	timerWheelSlots     = 1 << timerWheelLevelBits
+	timerWheelSlotMask  = timerWheelSlots - 1
+	timerWheelLevels    = 4
+	timerWheelMaxTicks  = 1<<(timerWheelLevelBits*timerWheelLevels) - 1
+)
+
+// Timeout is a callback scheduled on a TimerWheel
+type Timeout struct {
+	wheel      *TimerWheel
+	expires    uint64
+	fn         func()
+	prev, next *Timeout
+	list       *timeoutList
+}
+
+// Cancel cancels the timeout. It returns false if the timeout
+// has already expired or been canceled
+func (t *Timeout) Cancel() bool {
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.list == nil {
+		return false
+	}
+	t.list.remove(t)
+	w.n--
+	return true
+}
+
+type timeoutList struct {
+	head, tail *Timeout
+}
+
+func (l *timeoutList) push(t *Timeout) {
+	t.list, t.prev, t.next = l, l.tail, nil
+	if l.tail != nil {
+		l.tail.next = t
+	} else {
+		l.head = t
+	}
+	l.tail = t
+}
+
+func (l *timeoutList) remove(t *Timeout) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	} else {
+		l.tail = t.prev
+	}
+	t.list, t.prev, t.next = nil, nil, nil
+}
+
+func (l *timeoutList) take() (head *Timeout) {
+	head = l.head
+	l.head, l.tail = nil, nil
+	return
+}
+
+// TimerWheel is a hierarchical timing wheel which manages a large number of
+// timeouts, e.g. the idle, read and write timeouts of every connection, with
+// one periodic timer. Adding and canceling a timeout costs O(1)
+// The Fd of the TimerWheel becomes readable on every tick, then Run should
+// be called to run the expired timeouts
+type TimerWheel struct {
+	tick   time.Duration
+	timer  Timer
+	buf    []byte
+	mu     sync.Mutex
+	now    uint64
+	n      int
+	levels [timerWheelLevels][timerWheelSlots]timeoutList
+}
+
+func newTimerWheel(tick time.Duration, timer Timer) *TimerWheel {
+	return &TimerWheel{tick: tick, timer: timer, buf: make([]byte, 8)}
+}
+
+// Fd returns the file descriptor of the underlying timer
+func (w *TimerWheel) Fd() int {
+	return w.timer.Fd()
+}
+
+// Tick returns the resolution of the TimerWheel
+func (w *TimerWheel) Tick() time.Duration {
+	return w.tick
+}
+
+// Len returns the number of pending timeouts
+func (w *TimerWheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.n
+}
+
+// AddTimeout schedules fn to be called by Run after the duration d
+// The duration is rounded up to the ticks of the TimerWheel, and one more
+// tick is added for the part of the current tick which has passed, so that
+// fn is never called early. It is safe to be called from any goroutine
+func (w *TimerWheel) AddTimeout(d time.Duration, fn func()) *Timeout {
+	ticks := uint64(0)
+	if d > 0 {
+		ticks = uint64((d + w.tick - 1) / w.tick)
+	}
+	ticks = min(ticks+1, timerWheelMaxTicks)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := &Timeout{wheel: w, expires: w.now + ticks, fn: fn}
+	w.add(t)
+	w.n++
+	return t
+}
+
+func (w *TimerWheel) add(t *Timeout) {
+	delta := t.expires - w.now
+	for level := range timerWheelLevels {
+		if delta < 1<<(timerWheelLevelBits*(level+1)) || level == timerWheelLevels-1 {
+			slot := (t.expires >> (timerWheelLevelBits * level)) & timerWheelSlotMask
+			w.levels[level][slot].push(t)
+			return
+		}
+	}
+}
+
+// Run reads the ticks elapsed from the underlying timer, then advances
+// the TimerWheel and runs the expired timeouts in the calling goroutine
+// It returns the number of timeouts have been run
+func (w *TimerWheel) Run() (n int, err error) {
+	_, err = w.timer.Read(w.buf)
+	if err == ErrTemporarilyUnavailable {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return w.advance(binary.LittleEndian.Uint64(w.buf)), nil
+}
+
+func (w *TimerWheel) advance(ticks uint64) (n int) {
+	for range ticks {
+		w.mu.Lock()
+		w.now++
+		w.cascade()
+		expired := w.levels[0][w.now&timerWheelSlotMask].take()
+		for t := expired; t != nil; t = t.next {
+			t.list = nil
+			w.n--
+		}
+		w.mu.Unlock()
+		for t := expired; t != nil; {
+			next := t.next
+			t.prev, t.next = nil, nil
+			t.fn()
+			n++
+			t = next
+		}
+	}
+
+	return n
+}
+
+// cascade moves the timeouts of the higher level slot which becomes current
+// down to the lower levels whenever a lower level wheel wraps around
+func (w *TimerWheel) cascade() {
+	for level := 1; level < timerWheelLevels; level++ {
+		if (w.now>>(timerWheelLevelBits*(level-1)))&timerWheelSlotMask != 0 {
+			return
+		}
+		slot := (w.now >> (timerWheelLevelBits * level)) & timerWheelSlotMask
+		for t := w.levels[level][slot].take(); t != nil; {
+			next := t.next
+			t.list = nil
+			w.add(t)
+			t = next
+		}
+	}
+}
+
+// Close closes the underlying timer and drops all of the pending timeouts
+func (w *TimerWheel) Close() error {
+	w.mu.Lock()
+	for level := range w.levels {
+		for slot := range w.levels[level] {
+			for t := w.levels[level][slot].take(); t != nil; t = t.next {
+				t.list = nil
+			}
+		}
+	}
+	w.n = 0
+	w.mu.Unlock()
+
+	return w.timer.Close()
+}
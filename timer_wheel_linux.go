@@ -0,0 +1,22 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"time"
+)
+
+// NewTimerWheel creates and returns a new TimerWheel backed by one timerfd
+// which ticks every tick duration
+func NewTimerWheel(tick time.Duration) (*TimerWheel, error) {
+	timer, err := newTimerfd(tick, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTimerWheel(tick, timer), nil
+}
@@ -0,0 +1,109 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"testing"
+	"time"
+)
+
+func TestTimerWheel_Advance(t *testing.T) {
+	w, err := NewTimerWheel(time.Millisecond)
+	if err != nil {
+		t.Errorf("new timer wheel: %v", err)
+		return
+	}
+	defer w.Close()
+
+	expiredAt := make(map[int]uint64)
+	ticks := []int{1, 2, 255, 256, 257, 300, 65535, 65536, 70000, 1 << 24, 1<<24 + 3}
+	// the timeouts expire one tick after the rounded up ticks
+	for _, tick := range ticks {
+		w.AddTimeout(time.Duration(tick)*time.Millisecond, func() { expiredAt[tick] = w.now })
+	}
+	canceled := w.AddTimeout(100*time.Millisecond, func() { t.Errorf("canceled timeout expired") })
+	if !canceled.Cancel() || canceled.Cancel() {
+		t.Errorf("timeout expected to be canceled only once")
+		return
+	}
+	if w.Len() != len(ticks) {
+		t.Errorf("timer wheel expected %d timeouts but got %d", len(ticks), w.Len())
+		return
+	}
+	n := w.advance(1<<24 + 4)
+	if n != len(ticks) || w.Len() != 0 {
+		t.Errorf("timer wheel expected %d expired but got %d with %d left", len(ticks), n, w.Len())
+		return
+	}
+	for _, tick := range ticks {
+		if expiredAt[tick] != uint64(tick)+1 {
+			t.Errorf("timeout of %d ticks expired at %d", tick, expiredAt[tick])
+			return
+		}
+	}
+}
+
+func TestTimerWheel_Rounding(t *testing.T) {
+	w, err := NewTimerWheel(time.Millisecond)
+	if err != nil {
+		t.Errorf("new timer wheel: %v", err)
+		return
+	}
+	defer w.Close()
+
+	expiredAt := make(map[time.Duration]uint64)
+	delays := map[time.Duration]uint64{
+		0:                                    1,
+		time.Millisecond:                     2,
+		2 * time.Millisecond:                 3,
+		2*time.Millisecond + time.Nanosecond: 4,
+	}
+	for d := range delays {
+		w.AddTimeout(d, func() { expiredAt[d] = w.now })
+	}
+	w.advance(8)
+	for d, tick := range delays {
+		if expiredAt[d] != tick {
+			t.Errorf("timeout of %v expected to expire at %d but at %d", d, tick, expiredAt[d])
+			return
+		}
+	}
+}
+
+func TestTimerWheel_Run(t *testing.T) {
+	w, err := NewTimerWheel(10 * time.Millisecond)
+	if err != nil {
+		t.Errorf("new timer wheel: %v", err)
+		return
+	}
+	defer w.Close()
+
+	start, expired := time.Now(), time.Duration(0)
+	w.AddTimeout(50*time.Millisecond, func() { expired = time.Since(start) })
+	for expired == 0 {
+		fds := []unix.PollFd{{Fd: int32(w.Fd()), Events: unix.POLLIN}}
+		_, err = unix.Poll(fds, 1000)
+		if err != nil && err != unix.EINTR {
+			t.Errorf("poll timer wheel: %v", err)
+			return
+		}
+		_, err = w.Run()
+		if err != nil {
+			t.Errorf("timer wheel run: %v", err)
+			return
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("timeout not expired")
+			return
+		}
+	}
+	if expired < 50*time.Millisecond {
+		t.Errorf("timeout expired too early after %v", expired)
+		return
+	}
+}
@@ -19,10 +19,24 @@ type timerfd struct {
 
 	startedAt time.Time
 	d         time.Duration
+	oneShot   bool
 	fd        int
 }
 
-func newTimerfd(d time.Duration) (Timer, error) {
+// NewTimer creates and returns a new nonblocking Timer which expires after
+// the duration d, and then every duration d unless the OneShot option is set
+func NewTimer(d time.Duration, opts ...func(options *TimerOptions)) (Timer, error) {
+	o := &TimerOptions{
+		OneShot: false,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+
+	return newTimerfd(d, o.OneShot)
+}
+
+func newTimerfd(d time.Duration, oneShot bool) (Timer, error) {
 	if d <= 0 {
 		return nil, ErrInvalidParam
 	}
@@ -31,17 +45,14 @@ func newTimerfd(d time.Duration) (Timer, error) {
 	if err != nil {
 		return nil, errFromUnixErrno(err)
 	}
-
-	sec, nano := int64(d.Truncate(time.Second)/time.Second), d.Nanoseconds()%int64(time.Second/time.Nanosecond)
-	err = unix.TimerfdSettime(fd, 0, &unix.ItimerSpec{
-		Interval: unix.Timespec{Sec: sec, Nsec: nano},
-		Value:    unix.NsecToTimespec(d.Nanoseconds()),
-	}, nil)
+	tm := &timerfd{fd: fd, buf: make([]byte, 8), oneShot: oneShot}
+	err = tm.Reset(d)
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = unix.Close(fd)
+		return nil, err
 	}
 
-	return &timerfd{fd: fd, buf: make([]byte, 8), tickCount: 0, startedAt: time.Now().Local(), d: d}, nil
+	return tm, nil
 }
 
 func (tm *timerfd) Fd() int {
@@ -52,12 +63,41 @@ func (tm *timerfd) Now() time.Time {
 	return tm.tickedAt
 }
 
+func (tm *timerfd) Stop() error {
+	err := unix.TimerfdSettime(tm.fd, 0, &unix.ItimerSpec{}, nil)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+func (tm *timerfd) Reset(d time.Duration) error {
+	if d <= 0 {
+		return ErrInvalidParam
+	}
+	spec := unix.ItimerSpec{Value: unix.NsecToTimespec(d.Nanoseconds())}
+	if !tm.oneShot {
+		spec.Interval = spec.Value
+	}
+	err := unix.TimerfdSettime(tm.fd, 0, &spec, nil)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	tm.startedAt, tm.d, tm.tickCount = time.Now().Local(), d, 0
+
+	return nil
+}
+
 func (tm *timerfd) Read(p []byte) (n int, err error) {
 	n, err = unix.Read(tm.fd, p)
 	if err != nil {
 		return n, errFromUnixErrno(err)
 	}
-	tm.tickCount = binary.LittleEndian.Uint64(tm.buf)
+	if n < 8 {
+		return n, ErrInvalidParam
+	}
+	tm.tickCount += binary.LittleEndian.Uint64(p)
 	tm.tickedAt = tm.startedAt.Add(tm.d * time.Duration(tm.tickCount))
 
 	return n, nil
@@ -13,7 +13,7 @@ import (
 
 func TestTimer_Tick(t *testing.T) {
 	fn := func(d time.Duration, t *testing.T) {
-		tm, err := newTimerfd(d)
+		tm, err := newTimerfd(d, false)
 		if err != nil {
 			t.Errorf("create timerfd fd: %v", err)
 			return
@@ -41,3 +41,73 @@ func TestTimer_Tick(t *testing.T) {
 		fn(1*time.Second+100*time.Millisecond, t)
 	})
 }
+
+func TestTimer_OneShot(t *testing.T) {
+	tm, err := NewTimer(50*time.Millisecond, func(options *TimerOptions) {
+		options.OneShot = true
+	})
+	if err != nil {
+		t.Errorf("new timer: %v", err)
+		return
+	}
+	defer tm.Close()
+
+	buf := make([]byte, 8)
+	time.Sleep(150 * time.Millisecond)
+	_, err = tm.Read(buf)
+	if err != nil {
+		t.Errorf("timer read: %v", err)
+		return
+	}
+	if tm.(*timerfd).tickCount != 1 {
+		t.Errorf("one-shot timer expected 1 tick but got %d", tm.(*timerfd).tickCount)
+		return
+	}
+	_, err = tm.Read(buf)
+	if err != ErrTemporarilyUnavailable {
+		t.Errorf("one-shot timer read expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+}
+
+func TestTimer_StopReset(t *testing.T) {
+	tm, err := NewTimer(20 * time.Millisecond)
+	if err != nil {
+		t.Errorf("new timer: %v", err)
+		return
+	}
+	defer tm.Close()
+
+	buf := make([]byte, 8)
+	err = tm.Stop()
+	if err != nil {
+		t.Errorf("timer stop: %v", err)
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+	_, err = tm.Read(buf)
+	if err != ErrTemporarilyUnavailable {
+		t.Errorf("stopped timer read expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	err = tm.Reset(10 * time.Millisecond)
+	if err != nil {
+		t.Errorf("timer reset: %v", err)
+		return
+	}
+	time.Sleep(55 * time.Millisecond)
+	_, err = tm.Read(buf)
+	if err != nil {
+		t.Errorf("timer read: %v", err)
+		return
+	}
+	if ticks := tm.(*timerfd).tickCount; ticks < 3 {
+		t.Errorf("reset timer expected at least 3 ticks but got %d", ticks)
+		return
+	}
+	err = tm.Reset(0)
+	if err != ErrInvalidParam {
+		t.Errorf("timer reset expected ErrInvalidParam but got %v", err)
+		return
+	}
+}
@@ -0,0 +1,168 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTLSNoHandler will be returned when no AcceptedHandler is registered
+	// for the application protocol negotiated with ALPN
+	ErrTLSNoHandler = errors.New("no handler of the negotiated protocol")
+)
+
+// TLSRouterOptions holds the options of NewTLSRouter
+type TLSRouterOptions struct {
+	// HandshakeTimeout is the maximum time of the handshakes
+	// The default HandshakeTimeout is 10 seconds
+	HandshakeTimeout time.Duration
+	// SessionTicketKeys are the keys of the session tickets, of which the first
+	// one encrypts the new tickets and all of them decrypt the resumed ones
+	// Sharing the keys lets the clients resume their sessions across the
+	// listeners and the processes. Empty SessionTicketKeys means a random
+	// key is generated for the TLSRouter
+	SessionTicketKeys [][32]byte
+	// ErrorHandler handles the failed handshakes and the connections which are
+	// closed for the lack of an AcceptedHandler. A nil ErrorHandler means the
+	// errors are dropped
+	ErrorHandler ErrorHandler
+}
+
+var defaultTLSRouterOptions = TLSRouterOptions{
+	HandshakeTimeout:  10 * time.Second,
+	SessionTicketKeys: nil,
+	ErrorHandler:      nil,
+}
+
+// TLSRouter is an AcceptedHandler which performs the TLS handshakes of the
+// accepted connections and hands them over to the AcceptedHandlers of the
+// application protocols negotiated with ALPN, e.g. "game/1" and "metrics"
+// so that one listener can serve multiple protocols. The certificates are
+// selected by the server names of SNI
+// The accepted connections must be blocking, e.g. accepted by a
+// ListenerAdapter with NetConn, and ServeAccepted blocks until the handshake
+// has completed, so that it should be called on its own goroutine
+type TLSRouter struct {
+	config *tls.Config
+	opt    TLSRouterOptions
+
+	mu       sync.RWMutex
+	protos   []string
+	handlers map[string]AcceptedHandler
+	certs    map[string]*tls.Certificate
+}
+
+// NewTLSRouter creates and returns a new TLSRouter with the base config
+// The Certificates of config are used for the server names which have no
+// certificate set with Certificate. The NextProtos and GetCertificate of
+// config are replaced by the TLSRouter
+func NewTLSRouter(config *tls.Config, opts ...func(options *TLSRouterOptions)) *TLSRouter {
+	opt := defaultTLSRouterOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.HandshakeTimeout <= 0 {
+		opt.HandshakeTimeout = defaultTLSRouterOptions.HandshakeTimeout
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	r := &TLSRouter{
+		config:   config.Clone(),
+		opt:      opt,
+		handlers: make(map[string]AcceptedHandler),
+		certs:    make(map[string]*tls.Certificate),
+	}
+	r.config.GetCertificate = r.getCertificate
+	// the keys are set explicitly, since the configs cloned for the
+	// connections would generate the keys of their own otherwise
+	keys := opt.SessionTicketKeys
+	if len(keys) < 1 {
+		keys = make([][32]byte, 1)
+		_, _ = rand.Read(keys[0][:])
+	}
+	r.config.SetSessionTicketKeys(keys)
+
+	return r
+}
+
+// Handle registers h as the AcceptedHandler of the application protocol proto
+// The protocols are preferred in the order of the registration. An empty proto
+// registers the AcceptedHandler of the clients which do not support ALPN
+func (r *TLSRouter) Handle(proto string, h AcceptedHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[proto]; !ok && proto != "" {
+		r.protos = append(r.protos, proto)
+	}
+	r.handlers[proto] = h
+}
+
+// Certificate sets cert as the certificate of the server name. A server name
+// of the form "*.example.com" matches the names of the subdomains
+func (r *TLSRouter) Certificate(serverName string, cert *tls.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certs[strings.ToLower(serverName)] = cert
+}
+
+// ServeAccepted performs the TLS handshake of conn, and hands the *tls.Conn
+// over to the AcceptedHandler of the negotiated protocol. The connections of
+// the failed handshakes and the protocols without handlers are closed
+func (r *TLSRouter) ServeAccepted(conn Conn, listener Listener) {
+	r.mu.RLock()
+	config := r.config.Clone()
+	config.NextProtos = append([]string(nil), r.protos...)
+	r.mu.RUnlock()
+
+	tc := tls.Server(conn, config)
+	ctx, cancel := context.WithTimeout(context.Background(), r.opt.HandshakeTimeout)
+	defer cancel()
+	err := tc.HandshakeContext(ctx)
+	if err != nil {
+		_ = conn.Close()
+		r.serveError(ctx, err)
+		return
+	}
+	proto := tc.ConnectionState().NegotiatedProtocol
+	r.mu.RLock()
+	h, ok := r.handlers[proto]
+	r.mu.RUnlock()
+	if !ok {
+		_ = tc.Close()
+		r.serveError(ctx, ErrTLSNoHandler)
+		return
+	}
+	h.ServeAccepted(tc, listener)
+}
+
+func (r *TLSRouter) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cert, ok := r.certs[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := r.certs["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	// the Certificates of the base config are used
+	return nil, nil
+}
+
+func (r *TLSRouter) serveError(ctx context.Context, err error) {
+	if r.opt.ErrorHandler != nil {
+		r.opt.ErrorHandler.ServeError(ctx, err)
+	}
+}
@@ -0,0 +1,150 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"hybscloud.com/sox"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate of the server name
+func testCertificate(t *testing.T, serverName string) (*tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, leaf
+}
+
+// testALPNHandler records the protocols of the accepted connections
+type testALPNHandler struct {
+	accepted chan string
+}
+
+func (h *testALPNHandler) ServeAccepted(conn sox.Conn, listener sox.Listener) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		h.accepted <- "not tls"
+		return
+	}
+	h.accepted <- tc.ConnectionState().NegotiatedProtocol + " " + tc.ConnectionState().ServerName
+	// the close notify would block on the synchronous pipe
+	_ = tc.NetConn().Close()
+}
+
+// testErrorChan sends the handled errors to errs
+type testErrorChan struct {
+	errs chan error
+}
+
+func (h *testErrorChan) ServeError(ctx context.Context, err error) {
+	h.errs <- err
+}
+
+func TestTLSRouter(t *testing.T) {
+	gameCert, gameLeaf := testCertificate(t, "game.test")
+	metricsCert, metricsLeaf := testCertificate(t, "metrics.test")
+	roots := x509.NewCertPool()
+	roots.AddCert(gameLeaf)
+	roots.AddCert(metricsLeaf)
+
+	errs := &testErrorChan{errs: make(chan error, 4)}
+	router := sox.NewTLSRouter(&tls.Config{Certificates: []tls.Certificate{*gameCert}}, func(options *sox.TLSRouterOptions) {
+		options.HandshakeTimeout = time.Second
+		options.ErrorHandler = errs
+	})
+	h := &testALPNHandler{accepted: make(chan string, 4)}
+	router.Handle("game/1", h)
+	router.Handle("metrics", h)
+	router.Handle("", h)
+	router.Certificate("*.metrics.test", metricsCert)
+	router.Certificate("metrics.test", metricsCert)
+
+	dial := func(serverName string, protos ...string) (*tls.Conn, error) {
+		cc, sc := net.Pipe()
+		go router.ServeAccepted(sc, nil)
+		tc := tls.Client(cc, &tls.Config{ServerName: serverName, NextProtos: protos, RootCAs: roots})
+		err := tc.Handshake()
+		if err != nil {
+			_ = cc.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+
+	cases := []struct {
+		serverName string
+		protos     []string
+		expected   string
+		leaf       *x509.Certificate
+	}{
+		{"game.test", []string{"game/1"}, "game/1 game.test", gameLeaf},
+		{"metrics.test", []string{"chat/2", "metrics"}, "metrics metrics.test", metricsLeaf},
+		{"metrics.test", nil, " metrics.test", metricsLeaf},
+	}
+	for _, c := range cases {
+		tc, err := dial(c.serverName, c.protos...)
+		if err != nil {
+			t.Errorf("%s handshake: %v", c.serverName, err)
+			return
+		}
+		if !tc.ConnectionState().PeerCertificates[0].Equal(c.leaf) {
+			t.Errorf("%s expected the certificate of %s", c.serverName, c.leaf.Subject.CommonName)
+			return
+		}
+		select {
+		case accepted := <-h.accepted:
+			if accepted != c.expected {
+				t.Errorf("expected %q to be accepted but got %q", c.expected, accepted)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected %q to be accepted", c.expected)
+			return
+		}
+		_ = tc.NetConn().Close()
+	}
+
+	t.Run("unknown protocol", func(t *testing.T) {
+		_, err := dial("game.test", "chat/2")
+		if err == nil {
+			t.Errorf("expected the handshake of an unknown protocol to fail")
+			return
+		}
+		select {
+		case <-errs.errs:
+		case <-time.After(time.Second):
+			t.Errorf("expected the handshake error to be handled")
+			return
+		}
+	})
+}
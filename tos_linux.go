@@ -0,0 +1,105 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ECN is the Explicit Congestion Notification codepoint, which is
+// the low 2 bits of the IPv4 TOS or the IPv6 traffic class
+type ECN uint8
+
+const (
+	// ECNNotECT marks a packet of a transport which is not ECN-capable
+	ECNNotECT ECN = 0b00
+	// ECNECT1 marks a packet of an ECN-capable transport with ECT(1)
+	ECNECT1 ECN = 0b01
+	// ECNECT0 marks a packet of an ECN-capable transport with ECT(0)
+	ECNECT0 ECN = 0b10
+	// ECNCE marks a packet which has experienced congestion
+	ECNCE ECN = 0b11
+)
+
+// TOSFromDSCP returns the IPv4 TOS or the IPv6 traffic class of the DSCP and the ECN
+func TOSFromDSCP(dscp int, ecn ECN) int {
+	return dscp&0x3f<<2 | int(ecn&ECNCE)
+}
+
+// TOSDSCP returns the DSCP of the IPv4 TOS or the IPv6 traffic class tos
+func TOSDSCP(tos int) int {
+	return tos >> 2 & 0x3f
+}
+
+// TOSECN returns the ECN of the IPv4 TOS or the IPv6 traffic class tos
+func TOSECN(tos int) ECN {
+	return ECN(tos) & ECNCE
+}
+
+// SetTOS sets the IPv4 TOS of the packets sent on the socket fd with IP_TOS
+// The TOS holds the DSCP in the high 6 bits and the ECN in the low 2 bits
+// The ECN bits of a TCP socket are managed by the kernel
+func SetTOS(fd int, tos int) error {
+	err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// TOS returns the IPv4 TOS of the packets sent on the socket fd
+func TOS(fd int) (tos int, err error) {
+	tos, err = unix.GetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS)
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+	return tos, nil
+}
+
+// SetTrafficClass sets the IPv6 traffic class of the packets sent on the
+// socket fd with IPV6_TCLASS. A negative tclass resets the kernel default
+func SetTrafficClass(fd int, tclass int) error {
+	err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tclass)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
+
+// TrafficClass returns the IPv6 traffic class of the packets sent on the socket fd
+func TrafficClass(fd int) (tclass int, err error) {
+	tclass, err = unix.GetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS)
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+	return tclass, nil
+}
+
+// SetRecvTOS sets whether the TOS or the traffic class of the received
+// datagrams is reported as PktInfo.TOS by RecvFromPktInfo. It sets IP_RECVTOS,
+// and also IPV6_RECVTCLASS on an IPv6 socket
+func SetRecvTOS(fd int, on bool) error {
+	v := 0
+	if on {
+		v = 1
+	}
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	if domain == unix.AF_INET6 {
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, v)
+		if err != nil {
+			return errFromUnixErrno(err)
+		}
+	}
+	err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_RECVTOS, v)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"io"
+	"iter"
+)
+
+// TypedMessageReader is the interface that reads the messages as values of ItemType
+type TypedMessageReader[ItemType any] interface {
+	// Read reads a whole message and decodes it with the Codec
+	Read() (item ItemType, err error)
+	// All returns an iterator over the values read until io.EOF
+	// Errors are yielded with the zero value and stop the iteration
+	All() iter.Seq2[ItemType, error]
+}
+
+// TypedMessageWriter is the interface that writes the values of ItemType as messages
+type TypedMessageWriter[ItemType any] interface {
+	// Write encodes the item with the Codec and writes it as a message
+	// A nonblocking Write which returns ErrTemporarilyUnavailable must be
+	// retried with the same item
+	Write(item ItemType) error
+}
+
+// NewTypedMessageReader creates and returns a new TypedMessageReader which
+// reads the messages from reader and decodes them with the Codec option
+func NewTypedMessageReader[ItemType any](
+	reader io.Reader, opts ...func(options *MessageOptions)) TypedMessageReader[ItemType] {
+	return &typedMessageReader[ItemType]{message: newMessage(reader, nil, opts...)}
+}
+
+// NewTypedMessageWriter creates and returns a new TypedMessageWriter which
+// encodes the values with the Codec option and writes them to writer
+func NewTypedMessageWriter[ItemType any](
+	writer io.Writer, opts ...func(options *MessageOptions)) TypedMessageWriter[ItemType] {
+	return &typedMessageWriter[ItemType]{message: newMessage(nil, writer, opts...)}
+}
+
+type typedMessageReader[T any] struct {
+	*message
+}
+
+func (r *typedMessageReader[T]) Read() (item T, err error) {
+	err = r.readInto(&item)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return item, nil
+}
+
+func (r *typedMessageReader[T]) All() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			item, err := r.Read()
+			if err == io.EOF {
+				return
+			}
+			if !yield(item, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+type typedMessageWriter[T any] struct {
+	*message
+}
+
+func (w *typedMessageWriter[T]) Write(item T) error {
+	// the pointer lets the codecs use the methods of pointer receivers
+	return w.writeValue(&item)
+}
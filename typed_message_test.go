@@ -0,0 +1,57 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"hybscloud.com/sox"
+	"net/netip"
+	"testing"
+)
+
+func TestTypedMessage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := sox.NewTypedMessageWriter[testCodecMessage](buf)
+	for i := range 8 {
+		if err := w.Write(testCodecMessage{ID: i, Name: "sox"}); err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+	}
+	r := sox.NewTypedMessageReader[testCodecMessage](buf)
+	msg, err := r.Read()
+	if err != nil || msg.ID != 0 || msg.Name != "sox" {
+		t.Errorf("read expected message 0 but got %+v: %v", msg, err)
+		return
+	}
+	i := 1
+	for msg, err := range r.All() {
+		if err != nil || msg.ID != i {
+			t.Errorf("read expected message %d but got %+v: %v", i, msg, err)
+			return
+		}
+		i++
+	}
+	if i != 8 {
+		t.Errorf("read expected 8 messages but got %d", i)
+		return
+	}
+
+	addrs := sox.NewTypedMessageWriter[netip.Addr](buf, func(options *sox.MessageOptions) {
+		options.Codec = sox.BinaryCodec
+	})
+	addr := netip.MustParseAddr("192.0.2.1")
+	if err = addrs.Write(addr); err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	got, err := sox.NewTypedMessageReader[netip.Addr](buf, func(options *sox.MessageOptions) {
+		options.Codec = sox.BinaryCodec
+	}).Read()
+	if err != nil || got != addr {
+		t.Errorf("read expected %v but got %v: %v", addr, got, err)
+		return
+	}
+}
@@ -0,0 +1,151 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"net/netip"
+	"unsafe"
+)
+
+// PktInfo is the packet information of a datagram, which multi-homed UDP
+// servers need to reply from the address the request was sent to
+type PktInfo struct {
+	// Dst is the destination address of a received datagram, or the source
+	// address of a datagram to send. The zero Dst lets the kernel choose
+	Dst netip.Addr
+	// IfIndex is the index of the receiving interface of a received datagram,
+	// or the outgoing interface of a datagram to send. Zero means any
+	IfIndex int
+	// TOS is the IPv4 TOS or the IPv6 traffic class of a received datagram while
+	// SetRecvTOS is on, or of a datagram to send, e.g. to mark it with an ECN
+	// The zero TOS of a datagram to send leaves the TOS of the socket as it is
+	TOS int
+}
+
+// ECN returns the ECN codepoint of the TOS
+func (info *PktInfo) ECN() ECN {
+	return TOSECN(info.TOS)
+}
+
+// SetPktInfo sets whether the packet information is received with the datagrams
+// It sets IP_PKTINFO on an IPv4 socket and IPV6_RECVPKTINFO on an IPv6 socket
+func (so *UDPSocket) SetPktInfo(on bool) error {
+	v := 0
+	if on {
+		v = 1
+	}
+	var err error
+	if so.network == NetworkIPv6 {
+		err = unix.SetsockoptInt(so.fd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, v)
+	} else {
+		err = unix.SetsockoptInt(so.fd, unix.IPPROTO_IP, unix.IP_PKTINFO, v)
+	}
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// RecvFromPktInfo reads a datagram into b like RecvFrom, and returns its
+// packet information as well. The info is zero unless SetPktInfo is on
+func (so *UDPSocket) RecvFromPktInfo(b []byte) (n int, addr Addr, info PktInfo, err error) {
+	oob := [128]byte{}
+	n, oobn, _, sa, err := unix.Recvmsg(so.fd, b, oob[:], 0)
+	if err != nil {
+		return n, nil, info, opError("read", so.networkName("udp"), so.localAddr(), nil, errFromUnixErrno(err))
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, nil, info, opError("read", so.networkName("udp"), so.localAddr(), nil, errFromUnixErrno(err))
+	}
+	for _, msg := range msgs {
+		info.parse(msg)
+	}
+
+	return n, UDPAddrFromAddrPort(addrPortFromSockaddr(sa)), info, nil
+}
+
+// SendToPktInfo writes b to raddr like SendTo, from the source address,
+// through the outgoing interface and with the TOS of info
+func (so *UDPSocket) SendToPktInfo(b []byte, raddr Addr, info PktInfo) (n int, err error) {
+	ra, ok := raddr.(*UDPAddr)
+	if !ok {
+		return 0, opError("write", so.networkName("udp"), so.localAddr(), raddr, InvalidAddrError("unexpected address type"))
+	}
+	if err = zoneError(ra); err != nil {
+		return 0, opError("write", so.networkName("udp"), so.localAddr(), ra, err)
+	}
+	ap := ra.AddrPort()
+	if so.network == NetworkIPv4 {
+		ap = unmapAddrPort(ap)
+	}
+	err = unix.Sendmsg(so.fd, b, info.marshal(so.network), inetAddrFromAddrPort(ap), 0)
+	if err != nil {
+		return 0, opError("write", so.networkName("udp"), so.localAddr(), ra, errFromUnixErrno(err))
+	}
+
+	return len(b), nil
+}
+
+func (info *PktInfo) parse(msg unix.SocketControlMessage) {
+	switch {
+	case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_PKTINFO &&
+		len(msg.Data) >= unix.SizeofInet4Pktinfo:
+		pi := (*unix.Inet4Pktinfo)(unsafe.Pointer(unsafe.SliceData(msg.Data)))
+		info.Dst, info.IfIndex = netip.AddrFrom4(pi.Addr), int(pi.Ifindex)
+	case msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_PKTINFO &&
+		len(msg.Data) >= unix.SizeofInet6Pktinfo:
+		pi := (*unix.Inet6Pktinfo)(unsafe.Pointer(unsafe.SliceData(msg.Data)))
+		info.Dst, info.IfIndex = netip.AddrFrom16(pi.Addr), int(pi.Ifindex)
+	case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_TOS && len(msg.Data) >= 1:
+		info.TOS = int(msg.Data[0])
+	case msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_TCLASS && len(msg.Data) >= 4:
+		info.TOS = int(*(*int32)(unsafe.Pointer(unsafe.SliceData(msg.Data))))
+	}
+}
+
+// marshal returns the control message of info to send on a socket of the network
+func (info *PktInfo) marshal(network NetworkType) (oob []byte) {
+	tos := int32(info.TOS)
+	if network == NetworkIPv6 {
+		if info.Dst.IsValid() || info.IfIndex != 0 {
+			pi := unix.Inet6Pktinfo{Ifindex: uint32(info.IfIndex)}
+			if info.Dst.IsValid() {
+				pi.Addr = info.Dst.As16()
+			}
+			oob = appendCmsg(oob, unix.IPPROTO_IPV6, unix.IPV6_PKTINFO, unsafe.Slice((*byte)(unsafe.Pointer(&pi)), unix.SizeofInet6Pktinfo))
+		}
+		if tos != 0 {
+			oob = appendCmsg(oob, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, unsafe.Slice((*byte)(unsafe.Pointer(&tos)), 4))
+		}
+		return oob
+	}
+	if info.Dst.IsValid() || info.IfIndex != 0 {
+		pi := unix.Inet4Pktinfo{Ifindex: int32(info.IfIndex)}
+		if info.Dst.Unmap().Is4() {
+			pi.Spec_dst = info.Dst.Unmap().As4()
+		}
+		oob = appendCmsg(oob, unix.IPPROTO_IP, unix.IP_PKTINFO, unsafe.Slice((*byte)(unsafe.Pointer(&pi)), unix.SizeofInet4Pktinfo))
+	}
+	if tos != 0 {
+		oob = appendCmsg(oob, unix.IPPROTO_IP, unix.IP_TOS, unsafe.Slice((*byte)(unsafe.Pointer(&tos)), 4))
+	}
+	return oob
+}
+
+// appendCmsg appends a control message of the level and the type with data to oob
+func appendCmsg(oob []byte, level, typ int, data []byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(unsafe.SliceData(b)))
+	h.Level, h.Type = int32(level), int32(typ)
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+
+	return append(oob, b...)
+}
@@ -0,0 +1,220 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+	"time"
+)
+
+const (
+	// the headers of the IP and the UDP which the MTU counts besides the payload
+	udp4HeaderSize = 20 + 8
+	udp6HeaderSize = 40 + 8
+	// the maximum total length of the datagrams
+	maxDatagramMTU = 65535
+)
+
+// PathMTUOptions holds the options of DiscoverPathMTU
+type PathMTUOptions struct {
+	// MinMTU is the MTU which the path is assumed to support. MinMTU <= 0 means
+	// 576 of IPv4 or 1280 of IPv6 will be used
+	MinMTU int
+	// MaxMTU is the largest MTU to be probed. MaxMTU <= 0 means the MTU of the
+	// route, which ReadPathMTU returns, will be used
+	MaxMTU int
+	// ProbeTimeout is the time to wait for the ICMP error of each probe, after
+	// which the probe is taken as delivered. The default ProbeTimeout is 200ms
+	ProbeTimeout time.Duration
+	// ProbeHeader is written at the beginning of each probe, so that the peers can
+	// tell the probes from the messages. The rest of a probe is filled with zeros
+	ProbeHeader []byte
+}
+
+var defaultPathMTUOptions = PathMTUOptions{
+	MinMTU:       0,
+	MaxMTU:       0,
+	ProbeTimeout: 200 * time.Millisecond,
+	ProbeHeader:  nil,
+}
+
+// SetDontFragment sets whether the datagrams are sent with the DF bit, so that
+// they are not fragmented on the path, and the datagrams larger than the known
+// MTU of the path fail with ErrMsgSize. It sets IP_MTU_DISCOVER on an IPv4
+// socket and IPV6_MTU_DISCOVER on an IPv6 socket
+func (so *UDPSocket) SetDontFragment(on bool) error {
+	if on {
+		return so.setMTUDiscover(unix.IP_PMTUDISC_DO)
+	}
+	return so.setMTUDiscover(unix.IP_PMTUDISC_DONT)
+}
+
+// ReadPathMTU returns the MTU of the path to the peer which the kernel knows
+// It gets IP_MTU of an IPv4 socket and IPV6_MTU of an IPv6 socket. The socket
+// must be connected, otherwise ErrNotConnected is returned
+func (so *UDPSocket) ReadPathMTU() (int, error) {
+	var mtu int
+	var err error
+	if so.network == NetworkIPv6 {
+		mtu, err = unix.GetsockoptInt(so.fd, unix.IPPROTO_IPV6, unix.IPV6_MTU)
+	} else {
+		mtu, err = unix.GetsockoptInt(so.fd, unix.IPPROTO_IP, unix.IP_MTU)
+	}
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+
+	return mtu, nil
+}
+
+// DiscoverPathMTU searches for the largest MTU of the path to the peer with
+// probe datagrams of the DF bit. A probe is taken as too big if it fails with
+// ErrMsgSize or the fragmentation needed or the packet too big is received
+// with IP_RECVERR within ProbeTimeout, in which case the MTU of the ICMP error
+// bounds the search. The other ICMPErrors, e.g. the port unreachable, fail the
+// discovery. The paths which drop the probes silently are not detected, which
+// the protocols confirming the probes with their own acknowledgments should do
+// DiscoverPathMTU blocks for ProbeTimeout per probe, and the datagrams must
+// not be read on other goroutines meanwhile. The MTU discovery mode of the
+// socket is restored after the discovery
+func (conn *UDPConn) DiscoverPathMTU(opts ...func(options *PathMTUOptions)) (int, error) {
+	opt := defaultPathMTUOptions
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.ProbeTimeout <= 0 {
+		opt.ProbeTimeout = defaultPathMTUOptions.ProbeTimeout
+	}
+	hdr, lo := udp4HeaderSize, 576
+	if conn.network == NetworkIPv6 {
+		hdr, lo = udp6HeaderSize, 1280
+	}
+	if opt.MinMTU > 0 {
+		lo = opt.MinMTU
+	}
+	hi := opt.MaxMTU
+	if hi <= 0 {
+		mtu, err := conn.ReadPathMTU()
+		if err != nil {
+			return 0, opError("pmtud", conn.networkName("udp"), conn.laddr, conn.raddr, err)
+		}
+		hi = mtu
+	}
+	hi = min(hi, maxDatagramMTU)
+	if lo > hi || lo < hdr+len(opt.ProbeHeader) {
+		return 0, ErrInvalidParam
+	}
+
+	mode, err := conn.mtuDiscover()
+	if err == nil {
+		// the probes are sent with the DF bit regardless of the known MTU
+		err = conn.setMTUDiscover(unix.IP_PMTUDISC_PROBE)
+	}
+	if err != nil {
+		return 0, opError("pmtud", conn.networkName("udp"), conn.laddr, conn.raddr, err)
+	}
+	defer func() { _ = conn.setMTUDiscover(mode) }()
+	// the stale errors are dropped
+	conn.readErrQueue()
+
+	probe := make([]byte, hi-hdr)
+	copy(probe, opt.ProbeHeader)
+	for lo < hi {
+		size := lo + (hi-lo+1)/2
+		mtu, err := conn.probe(probe[:size-hdr], opt.ProbeTimeout)
+		if err != nil {
+			return lo, opError("pmtud", conn.networkName("udp"), conn.laddr, conn.raddr, err)
+		}
+		if mtu == 0 {
+			lo = size
+			continue
+		}
+		// the MTU reported with the error bounds the search unless it is bogus
+		hi = size - 1
+		if mtu >= lo && mtu < hi {
+			hi = mtu
+		}
+	}
+
+	return lo, nil
+}
+
+// probe sends the probe p, and waits for its error until timeout. It returns
+// 0 if the probe is taken as delivered, or the MTU reported by the error if the
+// probe is too big. The MTU is -1 if the error does not report the MTU
+func (conn *UDPConn) probe(p []byte, timeout time.Duration) (mtu int, err error) {
+	err = unix.Send(conn.fd, p, 0)
+	if err == unix.EMSGSIZE {
+		if e := conn.readErrQueue(); e != nil && e.MTU() > 0 {
+			return e.MTU(), nil
+		}
+		return -1, nil
+	}
+	if err != nil {
+		return 0, conn.icmpError(errFromUnixErrno(err))
+	}
+
+	fds := []unix.PollFd{{Fd: int32(conn.fd)}}
+	for deadline := time.Now().Add(timeout); ; {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, nil
+		}
+		_, err = unix.Poll(fds, int((d+time.Millisecond-1)/time.Millisecond))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, errFromUnixErrno(err)
+		}
+		if fds[0].Revents&unix.POLLERR != 0 {
+			break
+		}
+	}
+	e := conn.readErrQueue()
+	switch {
+	case e == nil:
+		return 0, nil
+	case e.Err != ErrMsgSize:
+		return 0, e
+	case e.MTU() > 0:
+		return e.MTU(), nil
+	}
+
+	return -1, nil
+}
+
+func (so *UDPSocket) mtuDiscover() (int, error) {
+	var mode int
+	var err error
+	if so.network == NetworkIPv6 {
+		mode, err = unix.GetsockoptInt(so.fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER)
+	} else {
+		mode, err = unix.GetsockoptInt(so.fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER)
+	}
+	if err != nil {
+		return 0, errFromUnixErrno(err)
+	}
+
+	return mode, nil
+}
+
+// setMTUDiscover sets the MTU discovery mode, of which the values of IPv4 and
+// IPv6 are the same, e.g. unix.IP_PMTUDISC_DO and unix.IPV6_PMTUDISC_DO
+func (so *UDPSocket) setMTUDiscover(mode int) error {
+	var err error
+	if so.network == NetworkIPv6 {
+		err = unix.SetsockoptInt(so.fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, mode)
+	} else {
+		err = unix.SetsockoptInt(so.fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, mode)
+	}
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
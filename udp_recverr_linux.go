@@ -0,0 +1,142 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"net/netip"
+	"syscall"
+	"unsafe"
+)
+
+// ICMPError is an asynchronous error of the datagrams sent on a connected UDP
+// socket, e.g. the ICMP port unreachable of a peer which has gone away and the
+// fragmentation needed of a router on the path, which is queued by the kernel
+// while SetRecvErr is on and reported by the next Read or Write of the UDPConn
+// so that the reliability layers can detect the failures without timing out
+type ICMPError struct {
+	// Err is the error which the errno of the ICMP error is mapped to, e.g.
+	// ErrConnectionRefused of port unreachable and ErrMsgSize of fragmentation needed
+	Err error
+	// Origin is where the error comes from, unix.SO_EE_ORIGIN_ICMP,
+	// unix.SO_EE_ORIGIN_ICMP6 or unix.SO_EE_ORIGIN_LOCAL
+	Origin uint8
+	// Type and Code are the type and the code of the ICMP message
+	Type uint8
+	Code uint8
+	// Info is the MTU of the path of fragmentation needed and packet too big
+	Info uint32
+	// Offender is the address of the node which has sent the ICMP message
+	// It is the zero Addr of the local errors
+	Offender netip.Addr
+}
+
+func (e *ICMPError) Error() string {
+	if e.Origin == unix.SO_EE_ORIGIN_LOCAL || !e.Offender.IsValid() {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s (icmp type %d code %d from %s)", e.Err, e.Type, e.Code, e.Offender)
+}
+
+// Unwrap returns the error which the errno of the ICMP error is mapped to
+func (e *ICMPError) Unwrap() error {
+	return e.Err
+}
+
+// MTU returns the MTU of the path if the datagram was too big, or 0 otherwise
+func (e *ICMPError) MTU() int {
+	if e.Err != ErrMsgSize {
+		return 0
+	}
+	return int(e.Info)
+}
+
+// SetRecvErr sets whether the asynchronous errors are queued and reported as
+// ICMPErrors. It sets IP_RECVERR on an IPv4 socket, and IPV6_RECVERR as well
+// on an IPv6 socket for the IPv4-mapped peers. It is on for the dialed UDPConns
+func (so *UDPSocket) SetRecvErr(on bool) error {
+	v := 0
+	if on {
+		v = 1
+	}
+	err := unix.SetsockoptInt(so.fd, unix.IPPROTO_IP, unix.IP_RECVERR, v)
+	if err == nil && so.network == NetworkIPv6 {
+		err = unix.SetsockoptInt(so.fd, unix.IPPROTO_IPV6, unix.IPV6_RECVERR, v)
+	}
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// icmpError drains the error queue of the socket after err has been returned
+// and returns the latest ICMPError, or err if nothing has been queued
+func (so *UDPSocket) icmpError(err error) error {
+	e, ok := err.(*ErrnoError)
+	if !ok || e == ErrTemporarilyUnavailable || e == ErrInterruptedSyscall {
+		return err
+	}
+	if icmpErr := so.readErrQueue(); icmpErr != nil {
+		return icmpErr
+	}
+
+	return err
+}
+
+// readErrQueue drains the error queue of the socket and returns the latest
+// ICMPError, or nil if nothing has been queued
+func (so *UDPSocket) readErrQueue() (icmpErr *ICMPError) {
+	oob := [128]byte{}
+	for {
+		_, oobn, _, _, err := unix.Recvmsg(so.fd, nil, oob[:], unix.MSG_ERRQUEUE)
+		if err != nil {
+			return icmpErr
+		}
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			if e := parseICMPError(msg); e != nil {
+				icmpErr = e
+			}
+		}
+	}
+}
+
+func parseICMPError(msg unix.SocketControlMessage) *ICMPError {
+	if !(msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_RECVERR) &&
+		!(msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_RECVERR) {
+		return nil
+	}
+	const sizeofSockExtendedErr = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+	if len(msg.Data) < sizeofSockExtendedErr {
+		return nil
+	}
+	ee := (*unix.SockExtendedErr)(unsafe.Pointer(unsafe.SliceData(msg.Data)))
+	switch ee.Origin {
+	case unix.SO_EE_ORIGIN_ICMP, unix.SO_EE_ORIGIN_ICMP6, unix.SO_EE_ORIGIN_LOCAL:
+	default:
+		// e.g. the notifications of the zerocopy sends
+		return nil
+	}
+	e := &ICMPError{
+		Err:    errFromUnixErrno(syscall.Errno(ee.Errno)),
+		Origin: ee.Origin,
+		Type:   ee.Type,
+		Code:   ee.Code,
+		Info:   ee.Info,
+	}
+	// the address of the offender follows the extended error
+	var offender [unix.SizeofSockaddrAny]byte
+	copy(offender[:], msg.Data[sizeofSockExtendedErr:])
+	e.Offender = addrPortFromSockaddrStorage(offender[:]).Addr()
+
+	return e
+}
@@ -9,6 +9,7 @@ package sox
 import (
 	"errors"
 	"golang.org/x/sys/unix"
+	"net/netip"
 	"time"
 )
 
@@ -59,25 +60,35 @@ func (so *UDPSocket) Protocol() UnderlyingProtocol {
 }
 
 func (so *UDPSocket) Dial4(raddr *UDPAddr) (conn *UDPConn, err error) {
-	err = unix.Connect(so.fd, udp4AddrToSockaddr(raddr))
+	laddr := UDPAddrFromAddrPort(addrPortFromSockaddr(so.sa))
+	err = so.SetRecvErr(true)
+	if err == nil {
+		err = unix.Connect(so.fd, udp4AddrToSockaddr(raddr))
+	}
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		return nil, opError("dial", "udp4", laddr, raddr, errFromUnixErrno(err))
 	}
-	return &UDPConn{UDPSocket: so, laddr: UDPAddrFromAddrPort(addrPortFromSockaddr(so.sa)), raddr: raddr}, nil
+	laddr = UDPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
+	return &UDPConn{UDPSocket: so, laddr: laddr, raddr: raddr}, nil
 }
 
 func (so *UDPSocket) Dial6(raddr *UDPAddr) (conn *UDPConn, err error) {
-	err = unix.Connect(so.fd, udp6AddrToSockaddr(raddr))
+	laddr := UDPAddrFromAddrPort(addrPortFromSockaddr(so.sa))
+	err = so.SetRecvErr(true)
+	if err == nil {
+		err = unix.Connect(so.fd, udp6AddrToSockaddr(raddr))
+	}
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		return nil, opError("dial", "udp6", laddr, raddr, errFromUnixErrno(err))
 	}
-	return &UDPConn{UDPSocket: so, laddr: UDPAddrFromAddrPort(addrPortFromSockaddr(so.sa)), raddr: raddr}, nil
+	laddr = UDPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
+	return &UDPConn{UDPSocket: so, laddr: laddr, raddr: raddr}, nil
 }
 
 func (so *UDPSocket) RecvFrom(b []byte) (n int, addr Addr, err error) {
 	n, sa, err := unix.Recvfrom(so.fd, b, 0)
 	if err != nil {
-		return n, nil, errFromUnixErrno(err)
+		return n, nil, opError("read", so.networkName("udp"), so.localAddr(), nil, errFromUnixErrno(err))
 	}
 
 	return n, UDPAddrFromAddrPort(addrPortFromSockaddr(sa)), nil
@@ -86,16 +97,27 @@ func (so *UDPSocket) RecvFrom(b []byte) (n int, addr Addr, err error) {
 func (so *UDPSocket) SendTo(b []byte, raddr Addr) (n int, err error) {
 	ra, ok := raddr.(*UDPAddr)
 	if !ok {
-		return 0, InvalidAddrError(raddr.String())
+		return 0, opError("write", so.networkName("udp"), so.localAddr(), raddr, InvalidAddrError("unexpected address type"))
+	}
+	if err = zoneError(ra); err != nil {
+		return 0, opError("write", so.networkName("udp"), so.localAddr(), ra, err)
 	}
-	err = unix.Sendto(so.fd, b, 0, inetAddrFromAddrPort(ra.AddrPort()))
+	ap := ra.AddrPort()
+	if so.network == NetworkIPv4 {
+		ap = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	}
+	err = unix.Sendto(so.fd, b, 0, inetAddrFromAddrPort(ap))
 	if err != nil {
-		return 0, errFromUnixErrno(err)
+		return 0, opError("write", so.networkName("udp"), so.localAddr(), ra, errFromUnixErrno(err))
 	}
 
 	return len(b), nil
 }
 
+func (so *UDPSocket) localAddr() *UDPAddr {
+	return UDPAddrFromAddrPort(addrPortFromSockaddr(so.sa))
+}
+
 type UDPConn struct {
 	*UDPSocket
 	laddr *UDPAddr
@@ -133,8 +155,19 @@ func (conn *UDPConn) SetReadDeadline(t time.Time) error {
 func (conn *UDPConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
+func (conn *UDPConn) Read(b []byte) (n int, err error) {
+	n, err = conn.UDPSocket.Read(b)
+	if err != nil {
+		err = conn.icmpError(err)
+	}
+	return n, opError("read", conn.networkName("udp"), conn.laddr, conn.raddr, err)
+}
 func (conn *UDPConn) Write(p []byte) (n int, err error) {
-	return conn.UDPSocket.SendTo(p, conn.raddr)
+	n, err = conn.UDPSocket.SendTo(p, conn.raddr)
+	if e, ok := err.(*OpError); ok {
+		e.Err = conn.icmpError(e.Err)
+	}
+	return n, err
 }
 
 func ListenUDP4(laddr *UDPAddr) (*UDPConn, error) {
@@ -143,12 +176,14 @@ func ListenUDP4(laddr *UDPAddr) (*UDPConn, error) {
 	}
 	so, err := newUDPSocket(udp4AddrToSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "udp4", nil, laddr, err)
 	}
 	err = unix.Bind(so.fd, udp4AddrToSockaddr(laddr))
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "udp4", nil, laddr, errFromUnixErrno(err))
 	}
+	laddr = UDPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	return &UDPConn{UDPSocket: so, laddr: laddr, raddr: nil}, nil
 }
 
@@ -156,14 +191,19 @@ func ListenUDP6(laddr *UDPAddr) (*UDPConn, error) {
 	if laddr == nil {
 		return nil, InvalidAddrError("nil local address")
 	}
+	if err := zoneError(laddr); err != nil {
+		return nil, opError("listen", "udp6", nil, laddr, err)
+	}
 	so, err := newUDPSocket(udp6AddrToSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "udp6", nil, laddr, err)
 	}
 	err = unix.Bind(so.fd, udp6AddrToSockaddr(laddr))
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "udp6", nil, laddr, errFromUnixErrno(err))
 	}
+	laddr = UDPAddrFromAddrPort(addrPortFromSockaddr(so.refreshSockname()))
 	return &UDPConn{UDPSocket: so, laddr: laddr, raddr: nil}, nil
 }
 
@@ -173,20 +213,64 @@ func DialUDP4(laddr *UDPAddr, raddr *UDPAddr) (*UDPConn, error) {
 	}
 	so, err := newUDPSocket(udp4AddrToSockaddr(laddr))
 	if err != nil {
+		return nil, opError("dial", "udp4", laddr, raddr, err)
+	}
+	conn, err := so.Dial4(raddr)
+	if err != nil {
+		_ = so.Close()
 		return nil, err
 	}
-	return so.Dial4(raddr)
+	return conn, nil
 }
 
 func DialUDP6(laddr *UDPAddr, raddr *UDPAddr) (*UDPConn, error) {
 	if raddr == nil {
 		return nil, &OpError{Op: "dial", Net: "udp6", Source: laddr, Addr: nil, Err: errors.New("missing address")}
 	}
+	if err := zoneError(laddr, raddr); err != nil {
+		return nil, opError("dial", "udp6", laddr, raddr, err)
+	}
 	so, err := newUDPSocket(udp6AddrToSockaddr(laddr))
 	if err != nil {
+		return nil, opError("dial", "udp6", laddr, raddr, err)
+	}
+	conn, err := so.Dial6(raddr)
+	if err != nil {
+		_ = so.Close()
 		return nil, err
 	}
-	return so.Dial6(raddr)
+	return conn, nil
+}
+
+// ListenUDPAddrPort listens on laddr with ListenUDP4 if laddr is an IPv4
+// or IPv4-mapped IPv6 address, and with ListenUDP6 otherwise
+func ListenUDPAddrPort(laddr netip.AddrPort) (*UDPConn, error) {
+	if !laddr.IsValid() {
+		return nil, InvalidAddrError("invalid local address")
+	}
+	if laddr = unmapAddrPort(laddr); laddr.Addr().Is4() {
+		return ListenUDP4(UDPAddrFromAddrPort(laddr))
+	}
+	return ListenUDP6(UDPAddrFromAddrPort(laddr))
+}
+
+// DialUDPAddrPort connects to raddr with DialUDP4 if raddr is an IPv4
+// or IPv4-mapped IPv6 address, and with DialUDP6 otherwise
+// The zero laddr means any address
+func DialUDPAddrPort(laddr, raddr netip.AddrPort) (*UDPConn, error) {
+	if !raddr.IsValid() {
+		return nil, InvalidAddrError("invalid remote address")
+	}
+	if raddr = unmapAddrPort(raddr); raddr.Addr().Is4() {
+		if !laddr.IsValid() {
+			laddr = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+		}
+		return DialUDP4(UDPAddrFromAddrPort(unmapAddrPort(laddr)), UDPAddrFromAddrPort(raddr))
+	}
+	if !laddr.IsValid() {
+		laddr = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
+	return DialUDP6(UDPAddrFromAddrPort(laddr), UDPAddrFromAddrPort(raddr))
 }
 
 func newUDP4Socket() (fd int, err error) {
@@ -8,10 +8,16 @@ package sox_test
 
 import (
 	"bytes"
+	"errors"
+	"golang.org/x/sys/unix"
 	"hybscloud.com/sox"
 	"io"
+	"net"
+	"net/netip"
 	"runtime"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestUDPSocket_ReadWrite(t *testing.T) {
@@ -90,3 +96,427 @@ func TestUDPSocket_ReadWrite(t *testing.T) {
 		break
 	}
 }
+
+func TestUDPConn_ReadvWritev(t *testing.T) {
+	var _ sox.SocketConn = (*sox.TCPConn)(nil)
+	var _ sox.SocketConn = (*sox.UDPConn)(nil)
+	var _ sox.SocketConn = (*sox.UnixConn)(nil)
+	var _ sox.SocketConn = (*sox.SCTPConn)(nil)
+
+	addr0, err := sox.ResolveUDPAddr("udp4", "127.0.0.1:8188")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr1, err := sox.ResolveUDPAddr("udp4", "127.0.0.1:8189")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lis, err := sox.ListenUDP4(addr0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lis.Close()
+	conn, err := sox.DialUDP4(addr1, addr0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	wn, err := conn.Writev([][]byte{[]byte("test"), []byte("0123456789")})
+	if err != nil {
+		t.Errorf("writev: %v", err)
+		return
+	}
+	if wn != 14 {
+		t.Errorf("writev expected n=%d but got %d", 14, wn)
+		return
+	}
+	head, body := make([]byte, 4), make([]byte, 10)
+	for sw := sox.NewSpinWait().SetLimit(4096); !sw.Closed(); sw.Once() {
+		rn, err := lis.Readv([][]byte{head, body})
+		if err == sox.ErrTemporarilyUnavailable {
+			continue
+		}
+		if err != nil {
+			t.Errorf("readv: %v", err)
+			return
+		}
+		if rn != 14 || string(head) != "test" || string(body) != "0123456789" {
+			t.Errorf("readv expected test0123456789 but got %s%s", head, body)
+			return
+		}
+		return
+	}
+	t.Errorf("readv timed out")
+}
+
+func TestUDPConn_OpError(t *testing.T) {
+	laddr := &sox.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 8197}
+	_, err := sox.ListenUDP4(laddr)
+	opErr := (*sox.OpError)(nil)
+	if !errors.As(err, &opErr) || opErr.Op != "listen" || opErr.Net != "udp4" || opErr.Addr != laddr || opErr.Source != nil {
+		t.Errorf("expected listen OpError but got %#v", err)
+		return
+	}
+	if !errors.Is(err, sox.ErrAddrNotAvailable) || !errors.Is(err, syscall.EADDRNOTAVAIL) {
+		t.Errorf("expected ErrAddrNotAvailable but got %v", err)
+		return
+	}
+
+	laddr = &sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8197}
+	raddr := &sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8198}
+	conn, err := sox.DialUDP4(laddr, raddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	// the errors of the non-blocking control flow are not wrapped
+	_, err = conn.Read(make([]byte, 16))
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+	// nobody listens on raddr, and the port unreachable is reported to the next read
+	_, err = conn.Write([]byte("test"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	for sw := sox.NewSpinWait().SetLimit(4096); !sw.Closed(); sw.Once() {
+		_, err = conn.Read(make([]byte, 16))
+		if err != sox.ErrTemporarilyUnavailable {
+			break
+		}
+	}
+	if !errors.As(err, &opErr) || opErr.Op != "read" || opErr.Net != "udp4" || opErr.Source.String() != conn.LocalAddr().String() || opErr.Addr != raddr {
+		t.Errorf("expected read OpError but got %#v", err)
+		return
+	}
+	if !errors.Is(err, sox.ErrConnectionRefused) {
+		t.Errorf("expected ErrConnectionRefused but got %v", err)
+		return
+	}
+}
+
+func TestUDPConn_ICMPError(t *testing.T) {
+	laddr := &sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8216}
+	raddr := &sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8217}
+	conn, err := sox.DialUDP4(laddr, raddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+	// nobody listens on raddr, and the port unreachable is queued
+	_, err = conn.Write([]byte("test"))
+	if err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	for sw := sox.NewSpinWait().SetLimit(4096); !sw.Closed(); sw.Once() {
+		_, err = conn.Read(make([]byte, 16))
+		if err != sox.ErrTemporarilyUnavailable {
+			break
+		}
+	}
+	var icmpErr *sox.ICMPError
+	if !errors.As(err, &icmpErr) || !errors.Is(err, sox.ErrConnectionRefused) {
+		t.Errorf("expected ICMPError of ErrConnectionRefused but got %v", err)
+		return
+	}
+	if icmpErr.Origin != unix.SO_EE_ORIGIN_ICMP || icmpErr.Type != 3 || icmpErr.Code != 3 {
+		t.Errorf("expected port unreachable but got origin %d type %d code %d", icmpErr.Origin, icmpErr.Type, icmpErr.Code)
+		return
+	}
+	if icmpErr.Offender != netip.MustParseAddr("127.0.0.1") || icmpErr.MTU() != 0 {
+		t.Errorf("expected the offender 127.0.0.1 but got %s mtu %d", icmpErr.Offender, icmpErr.MTU())
+		return
+	}
+	// the error queue has been drained
+	_, err = conn.Read(make([]byte, 16))
+	if err != sox.ErrTemporarilyUnavailable {
+		t.Errorf("expected ErrTemporarilyUnavailable but got %v", err)
+		return
+	}
+}
+
+func TestUDPConn_PathMTU(t *testing.T) {
+	laddr := &sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8218}
+	raddr := &sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8219}
+	lis, err := sox.ListenUDP4(raddr)
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer lis.Close()
+	conn, err := sox.DialUDP4(laddr, raddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	err = conn.SetDontFragment(true)
+	if err != nil {
+		t.Errorf("set dont fragment: %v", err)
+		return
+	}
+	mtu, err := conn.ReadPathMTU()
+	if err != nil || mtu < 1500 {
+		t.Errorf("read path mtu expected the mtu of the loopback but got %d: %v", mtu, err)
+		return
+	}
+	_, err = conn.Write(make([]byte, mtu))
+	if !errors.Is(err, sox.ErrMsgSize) {
+		t.Errorf("expected ErrMsgSize but got %v", err)
+		return
+	}
+	probeTimeout := func(options *sox.PathMTUOptions) {
+		options.ProbeTimeout = 5 * time.Millisecond
+	}
+	discovered, err := conn.DiscoverPathMTU(probeTimeout)
+	if err != nil || discovered != min(mtu, 65535) {
+		t.Errorf("discover path mtu expected %d but got %d: %v", min(mtu, 65535), discovered, err)
+		return
+	}
+	discovered, err = conn.DiscoverPathMTU(probeTimeout, func(options *sox.PathMTUOptions) {
+		options.MaxMTU = 1500
+	})
+	if err != nil || discovered != 1500 {
+		t.Errorf("discover path mtu expected %d but got %d: %v", 1500, discovered, err)
+		return
+	}
+	_ = lis.Close()
+	_, err = conn.DiscoverPathMTU(probeTimeout)
+	if !errors.Is(err, sox.ErrConnectionRefused) {
+		t.Errorf("discover path mtu expected ErrConnectionRefused but got %v", err)
+		return
+	}
+}
+
+func TestUDPSocket_PktInfo(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		t.Run(network, func(t *testing.T) {
+			var lis *sox.UDPConn
+			var err error
+			dst := netip.MustParseAddr("127.0.0.1")
+			if network == "udp4" {
+				lis, err = sox.ListenUDP4(&sox.UDPAddr{IP: net.IPv4zero.To4(), Port: 8201})
+			} else {
+				dst = netip.IPv6Loopback()
+				lis, err = sox.ListenUDP6(&sox.UDPAddr{IP: net.IPv6unspecified, Port: 8201})
+			}
+			if err != nil {
+				t.Skipf("listen: %v", err)
+				return
+			}
+			defer lis.Close()
+			err = lis.SetPktInfo(true)
+			if err != nil {
+				t.Errorf("set pktinfo: %v", err)
+				return
+			}
+			client, err := net.DialUDP(network, nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, 8201)))
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer client.Close()
+			_, err = client.Write([]byte("ping"))
+			if err != nil {
+				t.Errorf("write: %v", err)
+				return
+			}
+
+			b := make([]byte, 16)
+			n, addr, info, err := 0, sox.Addr(nil), sox.PktInfo{}, sox.ErrTemporarilyUnavailable
+			for sw := sox.NewSpinWait().SetLimit(4096); err == sox.ErrTemporarilyUnavailable && !sw.Closed(); sw.Once() {
+				n, addr, info, err = lis.RecvFromPktInfo(b)
+			}
+			if err != nil || string(b[:n]) != "ping" {
+				t.Errorf("recv expected ping but got %q: %v", b[:n], err)
+				return
+			}
+			lo, err := net.InterfaceByName("lo")
+			if err != nil {
+				t.Errorf("loopback interface: %v", err)
+				return
+			}
+			if info.Dst != dst || info.IfIndex != lo.Index {
+				t.Errorf("pktinfo expected %v on %d but got %+v", dst, lo.Index, info)
+				return
+			}
+			_, err = lis.SendToPktInfo([]byte("pong"), addr, info)
+			if err != nil {
+				t.Errorf("send: %v", err)
+				return
+			}
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, from, err := client.ReadFromUDPAddrPort(b)
+			if err != nil || string(b[:n]) != "pong" || from.Addr().Unmap() != dst || from.Port() != 8201 {
+				t.Errorf("read expected pong from %v but got %q from %v: %v", dst, b[:n], from, err)
+				return
+			}
+		})
+	}
+}
+
+func TestUDPSocket_TOS(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		t.Run(network, func(t *testing.T) {
+			var lis *sox.UDPConn
+			var err error
+			dst := netip.MustParseAddr("127.0.0.1")
+			if network == "udp4" {
+				lis, err = sox.ListenUDP4(&sox.UDPAddr{IP: net.IPv4zero.To4(), Port: 8202})
+			} else {
+				dst = netip.IPv6Loopback()
+				lis, err = sox.ListenUDP6(&sox.UDPAddr{IP: net.IPv6unspecified, Port: 8202})
+			}
+			if err != nil {
+				t.Skipf("listen: %v", err)
+				return
+			}
+			defer lis.Close()
+			tos := sox.TOSFromDSCP(10, sox.ECNNotECT)
+			if network == "udp4" {
+				err = sox.SetTOS(lis.Fd(), tos)
+			} else {
+				err = sox.SetTrafficClass(lis.Fd(), tos)
+			}
+			if err != nil {
+				t.Errorf("set tos: %v", err)
+				return
+			}
+			got := 0
+			if network == "udp4" {
+				got, err = sox.TOS(lis.Fd())
+			} else {
+				got, err = sox.TrafficClass(lis.Fd())
+			}
+			if err != nil || got != tos {
+				t.Errorf("tos expected %#x but got %#x: %v", tos, got, err)
+				return
+			}
+			err = sox.SetRecvTOS(lis.Fd(), true)
+			if err != nil {
+				t.Errorf("set recv tos: %v", err)
+				return
+			}
+
+			// the datagram is marked per packet, which overrides the tos of the socket
+			raddr := sox.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, 8202))
+			_, err = lis.SendToPktInfo([]byte("ping"), raddr, sox.PktInfo{TOS: sox.TOSFromDSCP(46, sox.ECNECT0)})
+			if err != nil {
+				t.Errorf("send: %v", err)
+				return
+			}
+			b := make([]byte, 16)
+			n, info, err := 0, sox.PktInfo{}, sox.ErrTemporarilyUnavailable
+			for sw := sox.NewSpinWait().SetLimit(4096); err == sox.ErrTemporarilyUnavailable && !sw.Closed(); sw.Once() {
+				n, _, info, err = lis.RecvFromPktInfo(b)
+			}
+			if err != nil || string(b[:n]) != "ping" {
+				t.Errorf("recv expected ping but got %q: %v", b[:n], err)
+				return
+			}
+			if info.ECN() != sox.ECNECT0 || sox.TOSDSCP(info.TOS) != 46 {
+				t.Errorf("tos expected ECT(0) with DSCP 46 but got %#x", info.TOS)
+				return
+			}
+		})
+	}
+}
+
+func TestUDPSocket_SocketFilter(t *testing.T) {
+	lis, err := sox.ListenUDP4(&sox.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8205})
+	if err != nil {
+		t.Errorf("listen: %v", err)
+		return
+	}
+	defer lis.Close()
+	prog, err := sox.NewSocketFilterBuilder().DropSrcPorts(53, 123).AcceptSrcPorts(8206).MinLength(8 + 4).Build()
+	if err != nil {
+		t.Errorf("build: %v", err)
+		return
+	}
+	err = sox.AttachSocketFilter(lis.Fd(), prog)
+	if err != nil {
+		t.Errorf("attach: %v", err)
+		return
+	}
+
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8205}
+	dropped, err := net.DialUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8207}, raddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer dropped.Close()
+	accepted, err := net.DialUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8206}, raddr)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return
+	}
+	defer accepted.Close()
+	recv := func() (string, sox.Addr, error) {
+		b := make([]byte, 16)
+		n, addr, err := 0, sox.Addr(nil), error(sox.ErrTemporarilyUnavailable)
+		for sw := sox.NewSpinWait().SetLimit(4096); err == sox.ErrTemporarilyUnavailable && !sw.Closed(); sw.Once() {
+			n, addr, err = lis.RecvFrom(b)
+		}
+		return string(b[:n]), addr, err
+	}
+	_, _ = dropped.Write([]byte("drop"))
+	_, _ = accepted.Write([]byte("pin"))
+	_, _ = accepted.Write([]byte("ping"))
+	msg, addr, err := recv()
+	if err != nil || msg != "ping" || addr.(*sox.UDPAddr).Port != 8206 {
+		t.Errorf("recv expected ping from 8206 but got %q from %v: %v", msg, addr, err)
+		return
+	}
+
+	err = sox.DetachSocketFilter(lis.Fd())
+	if err != nil {
+		t.Errorf("detach: %v", err)
+		return
+	}
+	_, _ = dropped.Write([]byte("pass"))
+	msg, addr, err = recv()
+	if err != nil || msg != "pass" || addr.(*sox.UDPAddr).Port != 8207 {
+		t.Errorf("recv expected pass from 8207 but got %q from %v: %v", msg, addr, err)
+		return
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := sox.NewSocketFilterBuilder().Sample(0).Build(); err != sox.ErrInvalidParam {
+			t.Errorf("build expected ErrInvalidParam but got %v", err)
+			return
+		}
+		if _, err := sox.NewSocketFilterBuilder().AcceptSrcPorts().Build(); err != sox.ErrInvalidParam {
+			t.Errorf("build expected ErrInvalidParam but got %v", err)
+			return
+		}
+		if err := sox.AttachSocketFilter(lis.Fd(), nil); err != sox.ErrInvalidParam {
+			t.Errorf("attach expected ErrInvalidParam but got %v", err)
+			return
+		}
+	})
+
+	t.Run("sample", func(t *testing.T) {
+		prog, err := sox.NewSocketFilterBuilder().Sample(1 << 30).Build()
+		if err != nil {
+			t.Errorf("build: %v", err)
+			return
+		}
+		if err = sox.AttachSocketFilter(lis.Fd(), prog); err != nil {
+			t.Errorf("attach: %v", err)
+			return
+		}
+	})
+}
@@ -69,6 +69,14 @@ func NewUnixConn(localAddr Addr, remoteSock *UnixSocket) (Conn, error) {
 	return &UnixConn{UnixSocket: remoteSock, laddr: unixAddr, raddr: remoteAddr}, nil
 }
 
+func (conn *UnixConn) Read(b []byte) (n int, err error) {
+	n, err = conn.UnixSocket.Read(b)
+	return n, opError("read", "unixpacket", conn.laddr, conn.raddr, err)
+}
+func (conn *UnixConn) Write(b []byte) (n int, err error) {
+	n, err = conn.UnixSocket.Write(b)
+	return n, opError("write", "unixpacket", conn.laddr, conn.raddr, err)
+}
 func (conn *UnixConn) LocalAddr() Addr {
 	return conn.laddr
 }
@@ -91,15 +99,30 @@ type UnixListener struct {
 }
 
 func (l *UnixListener) Accept() (Conn, error) {
-	nfd, sa, err := acceptWait(l.fd)
+	return l.accept(acceptWait)
+}
+
+func (l *UnixListener) acceptOnce() (Conn, error) {
+	return l.accept(acceptOnce)
+}
+
+func (l *UnixListener) accept(accept4 func(fd int) (int, unix.Sockaddr, error)) (Conn, error) {
+	nfd, sa, err := accept4(l.fd)
 	if err != nil {
-		return nil, err
+		return nil, opError("accept", "unixpacket", nil, l.Addr(), err)
 	}
 
-	so := &UnixSocket{socket: newSocket(NetworkUnix, nfd, sa)}
-	conn, err := NewUnixConn(l.Addr(), so)
+	// the listener may be bound to the wildcard address
+	lsa, rsa := sockname(nfd, nil), peername(nfd, sa)
+	laddr := l.Addr()
+	if lsa != nil {
+		laddr = unixAddrFromSockaddr(lsa, UnderlyingProtocolSeqPacket)
+	}
+	so := &UnixSocket{socket: newSocket(NetworkUnix, nfd, rsa)}
+	conn, err := NewUnixConn(laddr, so)
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("accept", "unixpacket", nil, l.Addr(), err)
 	}
 	return conn, err
 }
@@ -125,31 +148,33 @@ func ListenUnix(laddr *UnixAddr) (*UnixListener, error) {
 	}
 	so, err := newUnixSocket(unixAddrToSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("listen", "unixpacket", nil, laddr, err)
 	}
 	err = unix.Bind(so.fd, unixAddrToSockaddr(laddr))
-	if err != nil {
-		return nil, errFromUnixErrno(err)
+	if err == nil {
+		err = listen(so.fd)
 	}
-	err = unix.Listen(so.fd, defaultBacklog)
 	if err != nil {
-		return nil, errFromUnixErrno(err)
+		_ = so.Close()
+		return nil, opError("listen", "unixpacket", nil, laddr, errFromUnixErrno(err))
 	}
+	laddr = unixAddrFromSockaddr(so.refreshSockname(), UnderlyingProtocolSeqPacket)
 	lis := &UnixListener{UnixSocket: so, laddr: laddr}
 	return lis, nil
 }
 
 func DialUnix(laddr *UnixAddr, raddr *UnixAddr) (*UnixConn, error) {
 	if raddr == nil {
-		return nil, &OpError{Op: "dial", Net: "unix", Source: laddr, Addr: nil, Err: errors.New("missing address")}
+		return nil, &OpError{Op: "dial", Net: "unixpacket", Source: laddr, Addr: nil, Err: errors.New("missing address")}
 	}
 	so, err := newUnixSocket(unixAddrToSockaddr(laddr))
 	if err != nil {
-		return nil, err
+		return nil, opError("dial", "unixpacket", laddr, raddr, err)
 	}
 	err = connectWait(so.fd, unixAddrToSockaddr(raddr))
 	if err != nil {
-		return nil, err
+		_ = so.Close()
+		return nil, opError("dial", "unixpacket", laddr, raddr, err)
 	}
 
 	conn := &UnixConn{
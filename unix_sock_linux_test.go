@@ -8,6 +8,7 @@ package sox_test
 
 import (
 	"bytes"
+	"golang.org/x/sys/unix"
 	"hybscloud.com/sox"
 	"io"
 	"testing"
@@ -74,7 +75,7 @@ func TestUnixSocket_ReadWrite(t *testing.T) {
 	}
 	defer conn.Close()
 
-	for sw := sox.NewParamSpinWait(); !sw.Closed(); sw.Once() {
+	for sw := sox.NewSpinWait(); !sw.Closed(); sw.Once() {
 		w := sox.NewMessageWriter(conn)
 		n, err := w.Write(p)
 		if err != nil {
@@ -104,3 +105,83 @@ func TestUnixSocket_ReadWrite(t *testing.T) {
 		break
 	}
 }
+
+func TestUnixSocket_ReadWriteMsg(t *testing.T) {
+	addr0, err := sox.ResolveUnixAddr("unixpacket", "@sox-test-msg")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lis, err := sox.ListenUnix(addr0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lis.Close()
+	addr1, err := sox.ResolveUnixAddr("unixpacket", "@")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := sox.DialUnix(addr1, addr0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	accepted, err := lis.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer accepted.Close()
+
+	fds := [2]int{}
+	err = unix.Pipe2(fds[:], unix.O_CLOEXEC)
+	if err != nil {
+		t.Errorf("pipe: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+	_, oobn, err := conn.WriteMsg([]byte("fd"), unix.UnixRights(fds[1]), nil)
+	if err != nil || oobn != len(unix.UnixRights(fds[1])) {
+		t.Errorf("write msg: %v", err)
+		return
+	}
+
+	b, oob := make([]byte, 16), make([]byte, 128)
+	var n, flags int
+	for sw := sox.NewSpinWait(); !sw.Closed(); sw.Once() {
+		n, oobn, flags, _, err = accepted.(sox.MsgConn).ReadMsg(b, oob)
+		if err != sox.ErrTemporarilyUnavailable {
+			break
+		}
+	}
+	if err != nil || string(b[:n]) != "fd" || flags&unix.MSG_CTRUNC != 0 {
+		t.Errorf("read msg expected fd but got %q flags=%x: %v", b[:n], flags, err)
+		return
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Errorf("parse control message: %v", err)
+		return
+	}
+	var rights []int
+	for i := range msgs {
+		if msgs[i].Header.Type == unix.SCM_RIGHTS {
+			rights, err = unix.ParseUnixRights(&msgs[i])
+		}
+	}
+	if err != nil || len(rights) != 1 {
+		t.Errorf("parse unix rights: %v", err)
+		return
+	}
+	defer unix.Close(rights[0])
+	_, _ = unix.Write(rights[0], []byte("x"))
+	p := make([]byte, 1)
+	if rn, _ := unix.Read(fds[0], p); rn != 1 || p[0] != 'x' {
+		t.Errorf("expected the passed fd to write to the pipe")
+		return
+	}
+}
@@ -0,0 +1,124 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ioUringGroup is a group of rings, typically one ring per worker or per CPU
+// Each ring has its own submission lock, so that submitters on different
+// rings never contend with each other. Submissions are routed by the fd hash
+// or the processor of the caller, and the completions of all of the rings are
+// reaped through the group
+type ioUringGroup struct {
+	rings []*ioUring
+	next  atomic.Uint32
+}
+
+// ioUringAttachWQOptions shares the async workqueue of the ring wqFd
+func ioUringAttachWQOptions(wqFd int) func(params *ioUringParams) {
+	return func(params *ioUringParams) {
+		params.flags |= IORING_SETUP_ATTACH_WQ
+		params.wqFd = uint32(wqFd)
+	}
+}
+
+// newIoUringGroup creates n rings with the given entries and options
+// If shareWQ is true, the rings share the async workqueue of the first ring
+// instead of creating a workqueue per ring
+func newIoUringGroup(n int, entries int, shareWQ bool, opts ...func(params *ioUringParams)) (*ioUringGroup, error) {
+	if n < 1 {
+		return nil, ErrInvalidParam
+	}
+	g := &ioUringGroup{rings: make([]*ioUring, 0, n)}
+	for i := range n {
+		ringOpts := opts
+		if shareWQ && i > 0 {
+			ringOpts = append(opts[:len(opts):len(opts)], ioUringAttachWQOptions(g.rings[0].ringFd))
+		}
+		ur, err := newIoUring(entries, ringOpts...)
+		if err != nil {
+			_ = g.Close()
+			return nil, err
+		}
+		g.rings = append(g.rings, ur)
+	}
+
+	return g, nil
+}
+
+// ringOf returns the ring which the submissions on fd are routed to
+// Routing by fd keeps the operations on the same fd ordered on one ring
+func (g *ioUringGroup) ringOf(fd int) *ioUring {
+	return g.rings[uint(fd)%uint(len(g.rings))]
+}
+
+// local returns the ring of the processor which the caller is running on
+func (g *ioUringGroup) local() *ioUring {
+	pid := runtime_procPin()
+	runtime_procUnpin()
+	return g.rings[uint(pid)%uint(len(g.rings))]
+}
+
+// submit submits the operation to the ring routed by fd
+func (g *ioUringGroup) submit(ctx context.Context, op uint8, fd int, off uint64, addr uint64, n int, uflags uint32) error {
+	return g.ringOf(fd).submit(ctx, op, fd, off, addr, n, uflags)
+}
+
+// enter submits the queued entries of all of the rings
+func (g *ioUringGroup) enter() error {
+	for _, ur := range g.rings {
+		err := ur.enter()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wait reaps a completion from any ring. The rings are visited
+// round-robin so that a busy ring never starves the others
+// It returns ErrTemporarilyUnavailable if no ring has completions
+func (g *ioUringGroup) wait() (*ioUringCqe, error) {
+	start := g.next.Add(1)
+	for i := range uint32(len(g.rings)) {
+		cqe, err := g.rings[(start+i)%uint32(len(g.rings))].wait()
+		if err == ErrTemporarilyUnavailable {
+			continue
+		}
+		return cqe, err
+	}
+
+	return nil, ErrTemporarilyUnavailable
+}
+
+// collectMetrics records the total depth of the queues of all of the rings into m
+func (g *ioUringGroup) collectMetrics(m *Metrics) {
+	sq, cq := int64(0), int64(0)
+	for _, ur := range g.rings {
+		rsq, rcq := ur.depths()
+		sq, cq = sq+rsq, cq+rcq
+	}
+	m.SetSQDepth(sq)
+	m.SetCQDepth(cq)
+}
+
+// Close closes all of the rings of the group
+func (g *ioUringGroup) Close() (err error) {
+	for _, ur := range g.rings {
+		cerr := ur.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	g.rings = nil
+
+	return
+}
@@ -9,6 +9,7 @@ package sox
 import (
 	"context"
 	"golang.org/x/sys/unix"
+	"log/slog"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -16,9 +17,20 @@ import (
 )
 
 const (
-	IORING_SETUP_IOPOLL = 1 << 0
-	IORING_SETUP_SQPOLL = 1 << 1
-	IORING_SETUP_SQ_AFF = 1 << 2
+	IORING_SETUP_IOPOLL        = 1 << 0
+	IORING_SETUP_SQPOLL        = 1 << 1
+	IORING_SETUP_SQ_AFF        = 1 << 2
+	IORING_SETUP_CQSIZE        = 1 << 3
+	IORING_SETUP_CLAMP         = 1 << 4
+	IORING_SETUP_ATTACH_WQ     = 1 << 5
+	IORING_SETUP_R_DISABLED    = 1 << 6
+	IORING_SETUP_SUBMIT_ALL    = 1 << 7
+	IORING_SETUP_COOP_TASKRUN  = 1 << 8
+	IORING_SETUP_TASKRUN_FLAG  = 1 << 9
+	IORING_SETUP_SQE128        = 1 << 10
+	IORING_SETUP_CQE32         = 1 << 11
+	IORING_SETUP_SINGLE_ISSUER = 1 << 12
+	IORING_SETUP_DEFER_TASKRUN = 1 << 13
 )
 
 const (
@@ -46,6 +58,15 @@ const (
 	IOSQE_IO_LINK
 	IOSQE_IO_HARDLINK
 	IOSQE_ASYNC
+	IOSQE_BUFFER_SELECT
+	IOSQE_CQE_SKIP_SUCCESS
+)
+
+const (
+	IORING_RECVSEND_POLL_FIRST = 1 << iota
+	IORING_RECV_MULTISHOT
+	IORING_RECVSEND_FIXED_BUF
+	IORING_SEND_ZC_REPORT_USAGE
 )
 
 const (
@@ -81,6 +102,15 @@ const (
 	IO_URING_OP_SUPPORTED = 1 << 0
 )
 
+const (
+	IORING_CQE_F_BUFFER        = 1 << 0
+	IORING_CQE_F_MORE          = 1 << 1
+	IORING_CQE_F_SOCK_NONEMPTY = 1 << 2
+	IORING_CQE_F_NOTIF         = 1 << 3
+
+	IORING_CQE_BUFFER_SHIFT = 16
+)
+
 const (
 	ioUringDefaultEntries = 0x2000
 
@@ -88,6 +118,10 @@ const (
 	ioUringDefaultSqThreadIdle = 5 * time.Second
 )
 
+// ioUring is an io_uring instance. The rings are mapped outside the Go heap
+// and the registered resources are held by the kernel, so that the ioUring
+// must be closed explicitly with Close after use. There is no finalizer
+// to release them, and the ioUring must not be used after Close
 type ioUring struct {
 	params *ioUringParams
 
@@ -97,6 +131,20 @@ type ioUring struct {
 	ringFd int
 	ops    []ioUringProbeOp
 	bufs   Buffers
+	leases []*BufferLease
+	slots  Stack[int]
+	// held marks the registered buffer slots which have been acquired
+	held []atomic.Bool
+
+	sqRing []byte
+	sqes   []byte
+	cqRing []byte
+	efd    int
+	closed atomic.Bool
+
+	// the dropped submissions and the overflowed completions seen by logDrops
+	dropped  uint32
+	overflow uint32
 }
 
 func newIoUring(entries int, opts ...func(params *ioUringParams)) (*ioUring, error) {
@@ -123,45 +171,54 @@ func newIoUring(entries int, opts ...func(params *ioUringParams)) (*ioUring, err
 		},
 		sqLock: atomic.Bool{},
 		cq: ioUringCq{
-			ringSz: params.cqOff.cqes + uint32(unsafe.Sizeof(uint32(0)))*params.cqEntries,
+			ringSz: params.cqOff.cqes + uint32(unsafe.Sizeof(ioUringCqe{}))*params.cqEntries,
 		},
 		ringFd: fd,
 		bufs:   Buffers{},
+		efd:    -1,
 	}
 
-	b, err := unix.Mmap(uring.ringFd, IORING_OFF_SQ_RING, int(uring.sq.ringSz), unix.PROT_READ|unix.PROT_WRITE|unix.PROT_EXEC, unix.MAP_SHARED|unix.MAP_POPULATE)
+	prot, flags := unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE
+	uring.sqRing, err = unix.Mmap(uring.ringFd, IORING_OFF_SQ_RING, int(uring.sq.ringSz), prot, flags)
 	if err != nil {
-		return uring, errFromUnixErrno(err)
-	}
-	ptr := uintptr(unsafe.Pointer(&b[0]))
-	uring.sq.kHead = (*uint32)(unsafe.Pointer(ptr + uintptr(params.sqOff.head)))
-	uring.sq.kTail = (*uint32)(unsafe.Pointer(ptr + uintptr(params.sqOff.tail)))
-	uring.sq.kRingMask = (*uint32)(unsafe.Pointer(ptr + uintptr(params.sqOff.ringMask)))
-	uring.sq.kRingEntries = (*uint32)(unsafe.Pointer(ptr + uintptr(params.sqOff.ringEntries)))
-	uring.sq.kFlags = (*uint32)(unsafe.Pointer(ptr + uintptr(params.sqOff.flags)))
-	uring.sq.kDropped = (*uint32)(unsafe.Pointer(ptr + uintptr(params.sqOff.dropped)))
-	uring.sq.array = unsafe.Slice((*uint32)(unsafe.Pointer(ptr+uintptr(params.sqOff.array))), int(params.sqEntries))
-	b, err = unix.Mmap(uring.ringFd, IORING_OFF_SQES, int(params.sqEntries)*int(unsafe.Sizeof(ioUringSqe{})), unix.PROT_READ|unix.PROT_WRITE|unix.PROT_EXEC, unix.MAP_SHARED|unix.MAP_POPULATE)
+		_ = uring.Close()
+		return nil, errFromUnixErrno(err)
+	}
+	uring.sqes, err = unix.Mmap(uring.ringFd, IORING_OFF_SQES, int(params.sqEntries)*int(unsafe.Sizeof(ioUringSqe{})), prot, flags)
 	if err != nil {
-		return uring, errFromUnixErrno(err)
+		_ = uring.Close()
+		return nil, errFromUnixErrno(err)
 	}
-	uring.sq.sqes = unsafe.Slice((*ioUringSqe)(unsafe.Pointer(&b[0])), int(params.sqEntries))
-
-	b, err = unix.Mmap(uring.ringFd, IORING_OFF_CQ_RING, int(uring.cq.ringSz), unix.PROT_READ|unix.PROT_WRITE|unix.PROT_EXEC, unix.MAP_SHARED|unix.MAP_POPULATE)
+	uring.cqRing, err = unix.Mmap(uring.ringFd, IORING_OFF_CQ_RING, int(uring.cq.ringSz), prot, flags)
 	if err != nil {
-		return uring, errFromUnixErrno(err)
+		_ = uring.Close()
+		return nil, errFromUnixErrno(err)
 	}
-	ptr = uintptr(unsafe.Pointer(&b[0]))
-	uring.cq.kHead = (*uint32)(unsafe.Pointer(ptr + uintptr(params.cqOff.head)))
-	uring.cq.kTail = (*uint32)(unsafe.Pointer(ptr + uintptr(params.cqOff.tail)))
-	uring.cq.kRingMask = (*uint32)(unsafe.Pointer(ptr + uintptr(params.cqOff.ringMask)))
-	uring.cq.kRingEntries = (*uint32)(unsafe.Pointer(ptr + uintptr(params.cqOff.ringEntries)))
-	uring.cq.kOverflow = (*uint32)(unsafe.Pointer(ptr + uintptr(params.cqOff.overflow)))
-	uring.cq.cqes = unsafe.Slice((*ioUringCqe)(unsafe.Pointer(ptr+uintptr(params.cqOff.cqes))), int(params.cqEntries))
+	uring.mapRings()
 
 	return uring, nil
 }
 
+// mapRings sets up the views of the queues on the mapped memory of the rings
+func (ur *ioUring) mapRings() {
+	sq, cq, params := unsafe.Pointer(unsafe.SliceData(ur.sqRing)), unsafe.Pointer(unsafe.SliceData(ur.cqRing)), ur.params
+	ur.sq.kHead = (*uint32)(unsafe.Add(sq, params.sqOff.head))
+	ur.sq.kTail = (*uint32)(unsafe.Add(sq, params.sqOff.tail))
+	ur.sq.kRingMask = (*uint32)(unsafe.Add(sq, params.sqOff.ringMask))
+	ur.sq.kRingEntries = (*uint32)(unsafe.Add(sq, params.sqOff.ringEntries))
+	ur.sq.kFlags = (*uint32)(unsafe.Add(sq, params.sqOff.flags))
+	ur.sq.kDropped = (*uint32)(unsafe.Add(sq, params.sqOff.dropped))
+	ur.sq.array = unsafe.Slice((*uint32)(unsafe.Add(sq, params.sqOff.array)), params.sqEntries)
+	ur.sq.sqes = unsafe.Slice((*ioUringSqe)(unsafe.Pointer(unsafe.SliceData(ur.sqes))), params.sqEntries)
+
+	ur.cq.kHead = (*uint32)(unsafe.Add(cq, params.cqOff.head))
+	ur.cq.kTail = (*uint32)(unsafe.Add(cq, params.cqOff.tail))
+	ur.cq.kRingMask = (*uint32)(unsafe.Add(cq, params.cqOff.ringMask))
+	ur.cq.kRingEntries = (*uint32)(unsafe.Add(cq, params.cqOff.ringEntries))
+	ur.cq.kOverflow = (*uint32)(unsafe.Add(cq, params.cqOff.overflow))
+	ur.cq.cqes = unsafe.Slice((*ioUringCqe)(unsafe.Add(cq, params.cqOff.cqes)), params.cqEntries)
+}
+
 func (ur *ioUring) registerProbe(probe *ioUringProbe) error {
 	addr, n := uintptr(unsafe.Pointer(probe)), uintptr(len(probe.ops))
 	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ur.ringFd), IORING_REGISTER_PROBE, addr, n, 0, 0)
@@ -183,19 +240,79 @@ func (ur *ioUring) registerBuffers(n, size int) error {
 	if ur.bufs != nil && len(ur.bufs) > 0 {
 		panic("io-uring buffers already registered")
 	}
-	if n < 1 || size < 1 || n != n&(n-1) || size != size&(size-1) {
+	if n < 1 || size < 1 || n&(n-1) != 0 || size&(size-1) != 0 {
 		return ErrInvalidParam
 	}
-	ur.bufs = NewBuffers(n, size)
-	addr, n := ioVecFromBytesSlice(ur.bufs)
-	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ur.ringFd), IORING_REGISTER_BUFFERS, addr, uintptr(n), 0, 0)
+	slots, err := newBufferSlots(n)
+	if err != nil {
+		return err
+	}
+	ur.bufs, ur.leases = make(Buffers, n), make([]*BufferLease, n)
+	for i := range n {
+		ur.leases[i] = DefaultBufferPool.Get(size)
+		ur.bufs[i] = ur.leases[i].Bytes()
+	}
+	vec := newIoVec(ur.bufs)
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ur.ringFd), IORING_REGISTER_BUFFERS, uintptr(vec.addr()), uintptr(vec.n()), 0, 0)
+	vec.release()
 	if errno != 0 {
+		for _, lease := range ur.leases {
+			lease.Release()
+		}
+		ur.bufs, ur.leases = nil, nil
 		return errFromUnixErrno(errno)
 	}
+	ur.slots, ur.held = slots, make([]atomic.Bool, n)
 
 	return nil
 }
 
+// newBufferSlots returns the stack of the free slots of n registered buffers
+// The buffers are acquired and released by the goroutines submitting to the ring
+func newBufferSlots(n int) (Stack[int], error) {
+	slots, err := NewFixedStack[int](func(options *FixedStackOptions) {
+		options.Capacity = uint32(n)
+		options.Concurrent = true
+		options.Nonblocking = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range n {
+		_ = slots.Push(n - 1 - i)
+	}
+
+	return slots, nil
+}
+
+// acquireBuffer hands out a free registered buffer slot and returns its index and bytes
+// It returns ErrTemporarilyUnavailable when all the registered buffers are in use
+func (ur *ioUring) acquireBuffer() (index int, buf []byte, err error) {
+	if ur.slots == nil {
+		return -1, nil, ErrInvalidParam
+	}
+	index, err = ur.slots.Pop()
+	if err != nil {
+		return -1, nil, err
+	}
+	ur.held[index].Store(true)
+
+	return index, ur.bufs[index], nil
+}
+
+// releaseBuffer gives back the registered buffer slot with the given index
+// It returns ErrInvalidParam if the slot is not held, e.g. released twice
+func (ur *ioUring) releaseBuffer(index int) error {
+	if ur.slots == nil || index < 0 || index >= len(ur.held) {
+		return ErrInvalidParam
+	}
+	if !ur.held[index].CompareAndSwap(true, false) {
+		return ErrInvalidParam
+	}
+
+	return ur.slots.Push(index)
+}
+
 func (ur *ioUring) unregisterBuffers() error {
 	if ur.bufs == nil || len(ur.bufs) < 1 {
 		panic("no io-uring buffers registered")
@@ -204,7 +321,10 @@ func (ur *ioUring) unregisterBuffers() error {
 	if errno != 0 {
 		return errFromUnixErrno(errno)
 	}
-	ur.bufs = Buffers{}
+	for _, l := range ur.leases {
+		l.Release()
+	}
+	ur.bufs, ur.leases, ur.slots, ur.held = Buffers{}, nil, nil, nil
 
 	return nil
 }
@@ -220,6 +340,8 @@ func (ur *ioUring) registerPoller(p *epoll) (int, error) {
 		return 0, errFromUnixErrno(errno)
 	}
 
+	ur.efd = efd
+
 	err = p.add(efd, unix.EPOLLIN|unix.EPOLLET)
 	if err != nil {
 		return 0, err
@@ -228,7 +350,93 @@ func (ur *ioUring) registerPoller(p *epoll) (int, error) {
 	return efd, nil
 }
 
+func (ur *ioUring) unregisterEventfd() error {
+	if ur.efd < 0 {
+		return nil
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ur.ringFd), IORING_UNREGISTER_EVENTFD, 0, 0, 0, 0)
+	if errno != 0 {
+		return errFromUnixErrno(errno)
+	}
+	err := unix.Close(ur.efd)
+	ur.efd = -1
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// Close cancels the in-flight operations, unregisters the buffers and the eventfd,
+// unmaps the rings and closes the ring fd. The completions of the cancelled
+// operations are never reaped. Close is idempotent
+func (ur *ioUring) Close() error {
+	if !ur.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if ur.sqRing != nil && ur.sqes != nil && ur.cqRing != nil {
+		if ur.cancelAll(context.Background()) == nil {
+			_ = ur.enter()
+		}
+	}
+	if len(ur.bufs) > 0 {
+		_ = ur.unregisterBuffers()
+	}
+	_ = ur.unregisterEventfd()
+	for _, b := range [][]byte{ur.cqRing, ur.sqes, ur.sqRing} {
+		if b != nil {
+			_ = unix.Munmap(b)
+		}
+	}
+	ur.sqRing, ur.sqes, ur.cqRing = nil, nil, nil
+	ur.sq, ur.cq = ioUringSq{}, ioUringCq{}
+	err := unix.Close(ur.ringFd)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
 func (ur *ioUring) submit(ctx context.Context, op uint8, fd int, off uint64, addr uint64, n int, uflags uint32) error {
+	return ur.submitBufIndex(ctx, op, fd, off, addr, n, uflags, 0)
+}
+
+func (ur *ioUring) submitBufIndex(ctx context.Context, op uint8, fd int, off uint64, addr uint64, n int, uflags uint32, bufIndex uint16) error {
+	e := ioUringSqe{
+		opcode:   op,
+		fd:       int32(fd),
+		off:      off,
+		addr:     addr,
+		len:      uint32(n),
+		uflags:   uflags,
+		bufIndex: bufIndex,
+	}
+
+	return ur.submitSqe(ctx, &e)
+}
+
+// submitSqe copies the prepared sqe into the submission queue
+// with the IOSQE_ASYNC flag and the userData referring to ctx
+func (ur *ioUring) submitSqe(ctx context.Context, sqe *ioUringSqe) error {
+	return ur.submitSqeUserData(sqe, uint64(uintptr(unsafe.Pointer(&ctx))))
+}
+
+// submitSqeUserData copies the prepared sqe into the submission queue
+// with the IOSQE_ASYNC flag and the given userData, which identifies
+// the completion of the sqe
+func (ur *ioUring) submitSqeUserData(sqe *ioUringSqe, userData uint64) error {
+	ud := [1]uint64{userData}
+	return ur.submitSqesUserData(unsafe.Slice(sqe, 1), ud[:])
+}
+
+// submitSqesUserData copies the prepared sqes into the submission queue at once
+// with the IOSQE_ASYNC flag and the userData of each, so that the sqes linked
+// with IOSQE_IO_LINK stay contiguous. Either all or none of them are submitted
+func (ur *ioUring) submitSqesUserData(sqes []ioUringSqe, userData []uint64) error {
+	if len(sqes) < 1 || len(sqes) != len(userData) {
+		return ErrInvalidParam
+	}
 	sw := SpinWait{}
 	for {
 		if ur.sqLock.CompareAndSwap(false, true) {
@@ -238,23 +446,20 @@ func (ur *ioUring) submit(ctx context.Context, op uint8, fd int, off uint64, add
 	}
 	defer ur.sqLock.Store(false)
 
-	h, t := *ur.sq.kHead, *ur.sq.kTail
-	if (t+1)&*ur.sq.kRingMask == h {
+	h, t := atomic.LoadUint32(ur.sq.kHead), *ur.sq.kTail
+	if t-h+uint32(len(sqes)) > *ur.sq.kRingEntries {
 		return ErrTemporarilyUnavailable
 	}
 
-	e := &ur.sq.sqes[t&*ur.sq.kRingMask]
-	e.opcode = op
-	e.flags = IOSQE_ASYNC
-	e.fd = int32(fd)
-	e.off = off
-	e.addr = addr
-	e.len = uint32(n)
-	e.uflags = uflags
-	e.userData = uint64(uintptr(unsafe.Pointer(&ctx)))
-
-	ur.sq.array[t&*ur.sq.kRingMask] = t & *ur.sq.kRingMask
-	*ur.sq.kTail++
+	for i := range sqes {
+		e := &ur.sq.sqes[t&*ur.sq.kRingMask]
+		*e = sqes[i]
+		e.flags |= IOSQE_ASYNC
+		e.userData = userData[i]
+		ur.sq.array[t&*ur.sq.kRingMask] = t & *ur.sq.kRingMask
+		t++
+	}
+	atomic.StoreUint32(ur.sq.kTail, t)
 
 	return nil
 }
@@ -304,6 +509,59 @@ func (ur *ioUring) wait() (*ioUringCqe, error) {
 	return nil, ErrTemporarilyUnavailable
 }
 
+// waitOne blocks until a completion arrives and returns its result, or the
+// error of a negative result. It is used by the synchronous callers which
+// have exactly one operation in flight on the ring
+func (ur *ioUring) waitOne() (res int32, err error) {
+	for {
+		cqe, err := ur.wait()
+		if err == ErrTemporarilyUnavailable {
+			_, err = ioUringEnter(ur.ringFd, 0, 1, IORING_ENTER_GETEVENTS)
+			if err != nil && err != ErrInterruptedSyscall {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		res = cqe.res
+		if res < 0 {
+			return res, errFromUnixErrno(unix.Errno(-res))
+		}
+
+		return res, nil
+	}
+}
+
+// depths returns the number of the pending submission queue entries
+// and the number of the unconsumed completion queue entries
+func (ur *ioUring) depths() (sq int64, cq int64) {
+	sqHead, sqTail := atomic.LoadUint32(ur.sq.kHead), atomic.LoadUint32(ur.sq.kTail)
+	cqHead, cqTail := atomic.LoadUint32(ur.cq.kHead), atomic.LoadUint32(ur.cq.kTail)
+	return int64(sqTail - sqHead), int64(cqTail - cqHead)
+}
+
+// collectMetrics records the depth of the submission and completion queues into m
+func (ur *ioUring) collectMetrics(m *Metrics) {
+	sq, cq := ur.depths()
+	m.SetSQDepth(sq)
+	m.SetCQDepth(cq)
+}
+
+// logDrops logs the submissions dropped and the completions overflowed by the
+// kernel since the last call, e.g. the lost notifications of zero-copy sends
+func (ur *ioUring) logDrops(ctx context.Context, l Logger) {
+	dropped, overflow := atomic.LoadUint32(ur.sq.kDropped), atomic.LoadUint32(ur.cq.kOverflow)
+	if n := dropped - ur.dropped; n > 0 {
+		logRecord(ctx, l, slog.LevelWarn, "io-uring submissions dropped", slog.Int("ring", ur.ringFd), slog.Uint64("n", uint64(n)))
+	}
+	if n := overflow - ur.overflow; n > 0 {
+		logRecord(ctx, l, slog.LevelWarn, "io-uring completions overflowed", slog.Int("ring", ur.ringFd), slog.Uint64("n", uint64(n)))
+	}
+	ur.dropped, ur.overflow = dropped, overflow
+}
+
 type ioUringProbe struct {
 	lastOp uint8
 	opsLen uint8
@@ -365,6 +623,16 @@ type ioUringCqe struct {
 	flags    uint32
 }
 
+// more reports whether more completions of the same submission will follow
+func (cqe *ioUringCqe) more() bool {
+	return cqe.flags&IORING_CQE_F_MORE != 0
+}
+
+// notif reports whether the completion is the notification of a zero-copy send
+func (cqe *ioUringCqe) notif() bool {
+	return cqe.flags&IORING_CQE_F_NOTIF != 0
+}
+
 func (cqe *ioUringCqe) Context() context.Context {
 	if cqe.userData == 0 {
 		return context.Background()
@@ -441,3 +709,15 @@ func ioUringEnter(fd int, toSubmit uintptr, minComplete uintptr, flags uintptr)
 	}
 	return int(result), nil
 }
+
+// ioUringTimespec is the struct __kernel_timespec of the timeout operations
+type ioUringTimespec struct {
+	sec  int64
+	nsec int64
+}
+
+// set sets the timespec to the duration d, or zero if d is not positive
+func (ts *ioUringTimespec) set(d time.Duration) {
+	d = max(d, 0)
+	ts.sec, ts.nsec = int64(d/time.Second), int64(d%time.Second)
+}
@@ -53,81 +53,124 @@ const (
 	IORING_OP_MKDIRAT
 	IORING_OP_SYMLINKAT
 	IORING_OP_LINKAT
+	IORING_OP_MSG_RING
+	IORING_OP_FSETXATTR
+	IORING_OP_SETXATTR
+	IORING_OP_FGETXATTR
+	IORING_OP_GETXATTR
+	IORING_OP_SOCKET
+	IORING_OP_URING_CMD
+	IORING_OP_SEND_ZC
+	IORING_OP_SENDMSG_ZC
+	IORING_OP_READ_MULTISHOT
+	IORING_OP_WAITID
+	IORING_OP_FUTEX_WAIT
+	IORING_OP_FUTEX_WAKE
+	IORING_OP_FUTEX_WAITV
 )
 
+// ioUringCurrentOffset is the offset which makes reads and writes
+// use the current file position as read(2) and write(2) do
+const ioUringCurrentOffset = ^uint64(0)
+
 func (ur *ioUring) nop(ctx context.Context, fd int) error {
 	return ur.submit(contextWithFD(ctx, fd), IORING_OP_NOP, fd, 0, 0, 0, 0)
 }
 
-func (ur *ioUring) readv(ctx context.Context, fd int, iov [][]byte) error {
+// readv submits a vectored read into iov. The returned ioVec
+// must be released after the operation has completed
+func (ur *ioUring) readv(ctx context.Context, fd int, iov [][]byte) (*ioVec, error) {
 	if iov == nil || len(iov) < 1 {
-		return ErrInvalidParam
+		return nil, ErrInvalidParam
 	}
 	opcode := IORING_OP_READV
-	addr, n := ioVecFromBytesSlice(iov)
+	vec := newIoVec(iov)
+	err := ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(uintptr(vec.addr())), vec.n(), unix.MSG_WAITALL)
+	if err != nil {
+		vec.release()
+		return nil, err
+	}
 
-	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(addr), n, unix.MSG_WAITALL)
+	return vec, nil
 }
 
-func (ur *ioUring) writev(ctx context.Context, fd int, iov [][]byte) error {
+// writev submits a vectored write from iov. The returned ioVec
+// must be released after the operation has completed
+func (ur *ioUring) writev(ctx context.Context, fd int, iov [][]byte) (*ioVec, error) {
 	if iov == nil || len(iov) < 1 {
-		return ErrInvalidParam
+		return nil, ErrInvalidParam
 	}
 
 	opcode := IORING_OP_WRITEV
-	addr, n := ioVecFromBytesSlice(iov)
+	vec := newIoVec(iov)
+	err := ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(uintptr(vec.addr())), vec.n(), 0)
+	if err != nil {
+		vec.release()
+		return nil, err
+	}
+
+	return vec, nil
+}
+
+func (ur *ioUring) readFixed(ctx context.Context, fd int, off uint64, bufIndex int, n int) error {
+	if bufIndex < 0 || bufIndex >= len(ur.bufs) || n < 1 || n > len(ur.bufs[bufIndex]) {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_READ_FIXED
+	addr := uint64(uintptr(unsafe.Pointer(&ur.bufs[bufIndex][0])))
+
+	return ur.submitBufIndex(contextWithFD(ctx, fd), opcode, fd, off, addr, n, 0, uint16(bufIndex))
+}
 
-	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(addr), n, unix.MSG_ZEROCOPY)
+func (ur *ioUring) writeFixed(ctx context.Context, fd int, off uint64, bufIndex int, n int) error {
+	if bufIndex < 0 || bufIndex >= len(ur.bufs) || n < 1 || n > len(ur.bufs[bufIndex]) {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_WRITE_FIXED
+	addr := uint64(uintptr(unsafe.Pointer(&ur.bufs[bufIndex][0])))
+
+	return ur.submitBufIndex(contextWithFD(ctx, fd), opcode, fd, off, addr, n, 0, uint16(bufIndex))
 }
 
 func (ur *ioUring) fsync(ctx context.Context, fd int) error {
 	return ur.submit(contextWithFD(ctx, fd), IORING_OP_FSYNC, fd, 0, 0, 0, 0)
 }
 
-func (ur *ioUring) sendmsg(ctx context.Context, fd int, buffers [][]byte, oob []byte, to unix.Sockaddr) error {
-	saPtr, saN, err := unsafe.Pointer(uintptr(0)), 0, error(nil)
+// sendmsg submits a message sending. The returned ioVec
+// must be released after the operation has completed
+func (ur *ioUring) sendmsg(ctx context.Context, fd int, buffers [][]byte, oob []byte, to unix.Sockaddr) (*ioVec, error) {
+	saPtr, saN, err := unsafe.Pointer(nil), 0, error(nil)
 	if to != nil {
 		saPtr, saN, err = sockaddr(to)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	opcode := IORING_OP_SENDMSG
-	addr, n := ioVecFromBytesSlice(buffers)
-	msg := unix.Msghdr{
-		Name:       (*byte)(saPtr),
-		Namelen:    uint32(saN),
-		Iov:        (*unix.Iovec)(unsafe.Pointer(addr)),
-		Iovlen:     uint64(n),
-		Control:    nil,
-		Controllen: 0,
-	}
-	if len(oob) > 0 {
-		msg.Control = &oob[0]
-		msg.Controllen = uint64(len(oob))
+	vec := newIoVec(buffers)
+	addr := vec.msghdr(saPtr, saN, oob)
+	err = ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(uintptr(addr)), 1, 0)
+	if err != nil {
+		vec.release()
+		return nil, err
 	}
 
-	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(addr), 1, unix.MSG_ZEROCOPY)
+	return vec, nil
 }
 
-func (ur *ioUring) recvmsg(ctx context.Context, fd int, buffers [][]byte, oob []byte) error {
-	from := unix.RawSockaddrAny{}
+// recvmsg submits a message receiving. The returned ioVec
+// must be released after the operation has completed
+func (ur *ioUring) recvmsg(ctx context.Context, fd int, buffers [][]byte, oob []byte) (*ioVec, error) {
 	opcode := IORING_OP_RECVMSG
-	addr, n := ioVecFromBytesSlice(buffers)
-	msg := unix.Msghdr{
-		Name:       (*byte)(unsafe.Pointer(&from)),
-		Namelen:    uint32(unix.SizeofSockaddrAny),
-		Iov:        (*unix.Iovec)(unsafe.Pointer(addr)),
-		Iovlen:     uint64(n),
-		Control:    nil,
-		Controllen: 0,
-	}
-	if len(oob) > 0 {
-		msg.Control = &oob[0]
-		msg.Controllen = uint64(len(oob))
+	vec := newIoVec(buffers)
+	addr := vec.msghdr(unsafe.Pointer(&vec.from), unix.SizeofSockaddrAny, oob)
+	err := ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(uintptr(addr)), 1, unix.MSG_WAITALL)
+	if err != nil {
+		vec.release()
+		return nil, err
 	}
 
-	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(addr), 1, unix.MSG_WAITALL)
+	return vec, nil
 }
 
 func (ur *ioUring) accept(ctx context.Context, fd int) error {
@@ -160,6 +203,108 @@ func (ur *ioUring) write(ctx context.Context, fd int, p []byte, n int) error {
 	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, addr, n, 0)
 }
 
+// readAt submits a read into p from the offset off of fd. An off of
+// ioUringCurrentOffset reads from and advances the current file position
+func (ur *ioUring) readAt(ctx context.Context, fd int, p []byte, off uint64) error {
+	if p == nil || len(p) < 1 {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_READ
+	addr := uint64(uintptr(unsafe.Pointer(&p[0])))
+
+	return ur.submit(contextWithFD(ctx, fd), opcode, fd, off, addr, len(p), 0)
+}
+
+// writeAt submits a write of p to the offset off of fd. An off of
+// ioUringCurrentOffset writes to and advances the current file position
+func (ur *ioUring) writeAt(ctx context.Context, fd int, p []byte, off uint64) error {
+	if p == nil || len(p) < 1 {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_WRITE
+	addr := uint64(uintptr(unsafe.Pointer(&p[0])))
+
+	return ur.submit(contextWithFD(ctx, fd), opcode, fd, off, addr, len(p), 0)
+}
+
+// openat submits an opening of the file at path relative to dirfd
+// The path must be kept alive until the operation has completed
+func (ur *ioUring) openat(ctx context.Context, dirfd int, path *byte, flags int, mode uint32) error {
+	if path == nil {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_OPENAT
+	addr := uint64(uintptr(unsafe.Pointer(path)))
+
+	return ur.submit(ctx, opcode, dirfd, 0, addr, int(mode), uint32(flags))
+}
+
+// openat2 submits an opening of the file at path relative to dirfd with how
+// The result of the completion is the opened fd. The path and how must be
+// kept alive until the operation has completed
+func (ur *ioUring) openat2(ctx context.Context, dirfd int, path *byte, how *unix.OpenHow) error {
+	if path == nil || how == nil {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_OPENAT2
+	addr := uint64(uintptr(unsafe.Pointer(path)))
+	addr2 := uint64(uintptr(unsafe.Pointer(how)))
+
+	return ur.submit(ctx, opcode, dirfd, addr2, addr, unix.SizeofOpenHow, 0)
+}
+
+// statx submits a query of the status of the file at path relative to dirfd
+// into st. An empty path with unix.AT_EMPTY_PATH in flags queries dirfd
+// itself. The path and st must be kept alive until the operation has completed
+func (ur *ioUring) statx(ctx context.Context, dirfd int, path *byte, flags int, mask int, st *unix.Statx_t) error {
+	if path == nil || st == nil {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_STATX
+	addr := uint64(uintptr(unsafe.Pointer(path)))
+	addr2 := uint64(uintptr(unsafe.Pointer(st)))
+
+	return ur.submit(contextWithFD(ctx, dirfd), opcode, dirfd, addr2, addr, mask, uint32(flags))
+}
+
+// fallocate submits a manipulation of the allocated space of fd
+// in the range from off with the length and the mode
+func (ur *ioUring) fallocate(ctx context.Context, fd int, mode uint32, off int64, length int64) error {
+	if off < 0 || length < 1 {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_FALLOCATE
+
+	return ur.submit(contextWithFD(ctx, fd), opcode, fd, uint64(off), uint64(length), int(mode), 0)
+}
+
+// unlinkat submits a removal of the file or, with unix.AT_REMOVEDIR in flags,
+// the directory at path relative to dirfd. The path must be kept alive
+// until the operation has completed
+func (ur *ioUring) unlinkat(ctx context.Context, dirfd int, path *byte, flags int) error {
+	if path == nil {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_UNLINKAT
+	addr := uint64(uintptr(unsafe.Pointer(path)))
+
+	return ur.submit(ctx, opcode, dirfd, 0, addr, 0, uint32(flags))
+}
+
+// renameat submits a renaming of oldpath relative to olddirfd to newpath
+// relative to newdirfd with the renameat2 flags. The paths must be kept
+// alive until the operation has completed
+func (ur *ioUring) renameat(ctx context.Context, olddirfd int, oldpath *byte, newdirfd int, newpath *byte, flags int) error {
+	if oldpath == nil || newpath == nil {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_RENAMEAT
+	addr := uint64(uintptr(unsafe.Pointer(oldpath)))
+	addr2 := uint64(uintptr(unsafe.Pointer(newpath)))
+
+	return ur.submit(ctx, opcode, olddirfd, addr2, addr, newdirfd, uint32(flags))
+}
+
 func (ur *ioUring) send(ctx context.Context, fd int, p []byte) error {
 	if p == nil || len(p) < 1 {
 		return ErrInvalidParam
@@ -167,7 +312,43 @@ func (ur *ioUring) send(ctx context.Context, fd int, p []byte) error {
 	opcode := IORING_OP_SEND
 	addr := uint64(uintptr(unsafe.Pointer(&p[0])))
 
-	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, addr, len(p), unix.MSG_ZEROCOPY)
+	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, addr, len(p), 0)
+}
+
+// sendZC submits a zero-copy send of p. The kernel posts two completions:
+// the result of the send with IORING_CQE_F_MORE, and then the notification
+// with IORING_CQE_F_NOTIF after which p is safe to be reused
+// Both of them should be fed to a zeroCopySend to track the state
+func (ur *ioUring) sendZC(ctx context.Context, fd int, p []byte) error {
+	if p == nil || len(p) < 1 {
+		return ErrInvalidParam
+	}
+	opcode := IORING_OP_SEND_ZC
+	addr := uint64(uintptr(unsafe.Pointer(&p[0])))
+
+	return ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, addr, len(p), 0)
+}
+
+// sendmsgZC submits a zero-copy message sending. The returned ioVec must be
+// released after the notification completion has arrived, as well as the buffers
+func (ur *ioUring) sendmsgZC(ctx context.Context, fd int, buffers [][]byte, oob []byte, to unix.Sockaddr) (*ioVec, error) {
+	saPtr, saN, err := unsafe.Pointer(nil), 0, error(nil)
+	if to != nil {
+		saPtr, saN, err = sockaddr(to)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opcode := IORING_OP_SENDMSG_ZC
+	vec := newIoVec(buffers)
+	addr := vec.msghdr(saPtr, saN, oob)
+	err = ur.submit(contextWithFD(ctx, fd), opcode, fd, 0, uint64(uintptr(addr)), 1, 0)
+	if err != nil {
+		vec.release()
+		return nil, err
+	}
+
+	return vec, nil
 }
 
 func (ur *ioUring) receive(ctx context.Context, fd int, p []byte) error {
@@ -187,3 +368,39 @@ func (ur *ioUring) epollCtl(ctx context.Context, epfd int, op int, fd int, event
 
 	return ur.submit(ctx, opcode, epfd, uint64(fd), addr, op, 0)
 }
+
+const (
+	IORING_ASYNC_CANCEL_ALL = 1 << iota
+	IORING_ASYNC_CANCEL_FD
+	IORING_ASYNC_CANCEL_ANY
+	IORING_ASYNC_CANCEL_FD_FIXED
+)
+
+// cancelAll submits a cancellation of all of the in-flight operations
+func (ur *ioUring) cancelAll(ctx context.Context) error {
+	return ur.submit(ctx, IORING_OP_ASYNC_CANCEL, -1, 0, 0, 0, IORING_ASYNC_CANCEL_ALL|IORING_ASYNC_CANCEL_ANY)
+}
+
+func (ur *ioUring) futexWait(ctx context.Context, f *Futex, val uint32) error {
+	e := ioUringSqe{
+		opcode: IORING_OP_FUTEX_WAIT,
+		fd:     _FUTEX2_SIZE_U32,
+		off:    uint64(val),
+		addr:   uint64(uintptr(unsafe.Pointer(f))),
+		pad:    [2]uint64{_FUTEX_BITSET_MATCH_ANY, 0},
+	}
+
+	return ur.submitSqe(ctx, &e)
+}
+
+func (ur *ioUring) futexWake(ctx context.Context, f *Futex, n int) error {
+	e := ioUringSqe{
+		opcode: IORING_OP_FUTEX_WAKE,
+		fd:     _FUTEX2_SIZE_U32,
+		off:    uint64(n),
+		addr:   uint64(uintptr(unsafe.Pointer(f))),
+		pad:    [2]uint64{_FUTEX_BITSET_MATCH_ANY, 0},
+	}
+
+	return ur.submitSqe(ctx, &e)
+}
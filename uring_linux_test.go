@@ -9,11 +9,16 @@ package sox
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"golang.org/x/sys/unix"
 	"io"
+	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestIOUring_BasicUsage(t *testing.T) {
@@ -58,7 +63,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 		}
 
 		dl := time.Now().Add(2 * time.Second)
-		for sw := NewParamSpinWait(); !sw.Closed(); sw.Once() {
+		for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
 			err := ur.poll(1)
 			if err != nil {
 				t.Errorf("io_ring_enter poll: %v", err)
@@ -118,7 +123,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 		}
 
 		dl := time.Now().Add(2 * time.Second)
-		for sw := NewParamSpinWait(); !sw.Closed(); sw.Once() {
+		for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
 			err := ur.poll(1)
 			if err != nil {
 				t.Errorf("io_ring_enter poll: %v", err)
@@ -194,7 +199,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 		}
 
 		dl := time.Now().Add(2 * time.Second)
-		for sw := NewParamSpinWait(); !sw.Closed(); sw.Once() {
+		for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
 			err := ur.poll(1)
 			if err != nil {
 				t.Errorf("io_ring_enter poll: %v", err)
@@ -252,7 +257,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 		}
 
 		dl := time.Now().Add(2 * time.Second)
-		for sw := NewParamSpinWait(); !sw.Closed(); sw.Once() {
+		for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
 			err := ur.poll(1)
 			if err != nil {
 				t.Errorf("io_ring_enter poll: %v", err)
@@ -305,6 +310,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fr(t, ur)
 	})
@@ -315,6 +321,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fw(t, ur)
 	})
@@ -325,6 +332,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		udsr(t, ur)
 	})
@@ -335,6 +343,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		udsw(t, ur)
 	})
@@ -345,6 +354,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fr(t, ur)
 	})
@@ -355,6 +365,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fw(t, ur)
 	})
@@ -365,6 +376,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fr(t, ur)
 	})
@@ -375,6 +387,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fw(t, ur)
 	})
@@ -385,6 +398,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		udsr(t, ur)
 	})
@@ -395,6 +409,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		udsw(t, ur)
 	})
@@ -405,6 +420,7 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fr(t, ur)
 	})
@@ -415,9 +431,343 @@ func TestIOUring_BasicUsage(t *testing.T) {
 			t.Errorf("new io-uring: %v", err)
 			return
 		}
+		defer ur.Close()
 
 		fw(t, ur)
 	})
 }
 
+func TestIOUring_FixedBuffers(t *testing.T) {
+	ur, err := newIoUring(16)
+	if err != nil {
+		t.Errorf("new io-uring: %v", err)
+		return
+	}
+	defer ur.Close()
+	err = ur.registerBuffers(4, BufferSizeSmall)
+	if err != nil {
+		t.Errorf("register buffers: %v", err)
+		return
+	}
+	defer ur.unregisterBuffers()
+
+	so, err := newUnixSocketPair()
+	if err != nil {
+		t.Errorf("unix socket pair: %v", err)
+		return
+	}
+	defer so[0].Close()
+	defer so[1].Close()
+
+	complete := func(t *testing.T, expected int) bool {
+		dl := time.Now().Add(2 * time.Second)
+		for sw := NewSpinWait(); !sw.Closed(); sw.Once() {
+			cqe, err := ur.wait()
+			if err == ErrTemporarilyUnavailable {
+				if time.Now().After(dl) {
+					t.Error("wait completion timeout")
+					return false
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("wait completion: %v", err)
+				return false
+			}
+			if cqe.res != int32(expected) {
+				t.Errorf("completion expected res=%d but got %d", expected, cqe.res)
+				return false
+			}
+			break
+		}
+		return true
+	}
+
+	wi, wb, err := ur.acquireBuffer()
+	if err != nil {
+		t.Errorf("acquire buffer: %v", err)
+		return
+	}
+	s := []byte("test0123456789")
+	copy(wb, s)
+	err = ur.writeFixed(context.TODO(), so[1].fd, 0, wi, len(s))
+	if err != nil {
+		t.Errorf("submit write fixed: %v", err)
+		return
+	}
+	err = ur.enter()
+	if err != nil {
+		t.Errorf("io_uring enter: %v", err)
+		return
+	}
+	if !complete(t, len(s)) {
+		return
+	}
+
+	ri, rb, err := ur.acquireBuffer()
+	if err != nil {
+		t.Errorf("acquire buffer: %v", err)
+		return
+	}
+	if ri == wi {
+		t.Errorf("acquire buffer expected a different slot from %d", wi)
+		return
+	}
+	err = ur.readFixed(context.TODO(), so[0].fd, 0, ri, len(s))
+	if err != nil {
+		t.Errorf("submit read fixed: %v", err)
+		return
+	}
+	err = ur.enter()
+	if err != nil {
+		t.Errorf("io_uring enter: %v", err)
+		return
+	}
+	if !complete(t, len(s)) {
+		return
+	}
+	if !bytes.Equal(rb[:len(s)], s) {
+		t.Errorf("read fixed expected %s but got %s", s, rb[:len(s)])
+		return
+	}
+
+	for _, i := range []int{wi, ri} {
+		err = ur.releaseBuffer(i)
+		if err != nil {
+			t.Errorf("release buffer: %v", err)
+			return
+		}
+	}
+}
+
+func TestIoUring_BufferSlots(t *testing.T) {
+	const n = 8
+	slots, err := newBufferSlots(n)
+	if err != nil {
+		t.Errorf("new buffer slots: %v", err)
+		return
+	}
+	ur := &ioUring{bufs: make(Buffers, n), slots: slots, held: make([]atomic.Bool, n)}
+	wg := sync.WaitGroup{}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1 << 12 {
+				i, _, err := ur.acquireBuffer()
+				if err == ErrTemporarilyUnavailable {
+					continue
+				}
+				if err != nil {
+					t.Errorf("acquire buffer: %v", err)
+					return
+				}
+				err = ur.releaseBuffer(i)
+				if err != nil {
+					t.Errorf("release buffer: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	i, _, err := ur.acquireBuffer()
+	if err != nil {
+		t.Errorf("acquire buffer: %v", err)
+		return
+	}
+	err = ur.releaseBuffer(i)
+	if err != nil {
+		t.Errorf("release buffer: %v", err)
+		return
+	}
+	err = ur.releaseBuffer(i)
+	if err != ErrInvalidParam {
+		t.Errorf("release buffer twice expected ErrInvalidParam but got %v", err)
+		return
+	}
+	if slots.(ItemPeeker[int]).Len() != n {
+		t.Errorf("expected %d free buffers but got %d", n, slots.(ItemPeeker[int]).Len())
+		return
+	}
+}
+
 func TestIoUring_IOOperations(t *testing.T) {}
+
+func TestIOUring_Group(t *testing.T) {
+	g, err := newIoUringGroup(2, 16, true)
+	if err != nil {
+		t.Errorf("new io-uring group: %v", err)
+		return
+	}
+	defer g.Close()
+	if g.rings[1].params.flags&IORING_SETUP_ATTACH_WQ == 0 || g.rings[1].params.wqFd != uint32(g.rings[0].ringFd) {
+		t.Errorf("expected the second ring to attach the workqueue of the first ring")
+		return
+	}
+	if g.ringOf(4) != g.rings[0] || g.ringOf(5) != g.rings[1] {
+		t.Errorf("expected submissions to be routed by fd")
+		return
+	}
+	for fd := range 4 {
+		err = g.submit(context.TODO(), IORING_OP_NOP, fd, 0, 0, 0, 0)
+		if err != nil {
+			t.Errorf("submit nop: %v", err)
+			return
+		}
+	}
+	err = g.enter()
+	if err != nil {
+		t.Errorf("io_uring enter: %v", err)
+		return
+	}
+	dl := time.Now().Add(2 * time.Second)
+	for n := 0; n < 4; {
+		_, err = g.wait()
+		if err == ErrTemporarilyUnavailable {
+			if time.Now().After(dl) {
+				t.Errorf("wait completion timeout after %d completions", n)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("wait completion: %v", err)
+			return
+		}
+		n++
+	}
+}
+
+func TestIOUring_Close(t *testing.T) {
+	ur, err := newIoUring(16)
+	if err != nil {
+		t.Errorf("new io-uring: %v", err)
+		return
+	}
+	err = ur.registerBuffers(4, BufferSizeSmall)
+	if err != nil {
+		t.Errorf("register buffers: %v", err)
+		return
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Errorf("socket pair: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+	// leave a receive in flight to be cancelled
+	err = ur.receive(context.TODO(), fds[0], make([]byte, 16))
+	if err != nil {
+		t.Errorf("submit receive: %v", err)
+		return
+	}
+	err = ur.enter()
+	if err != nil {
+		t.Errorf("io_uring enter: %v", err)
+		return
+	}
+	ringFd := ur.ringFd
+	err = ur.Close()
+	if err != nil {
+		t.Errorf("close io-uring: %v", err)
+		return
+	}
+	if len(ur.bufs) > 0 || ur.sqRing != nil || ur.sqes != nil || ur.cqRing != nil {
+		t.Errorf("expected the resources to be released after close")
+		return
+	}
+	_, err = unix.FcntlInt(uintptr(ringFd), unix.F_GETFD, 0)
+	if err != unix.EBADF {
+		t.Errorf("expected the ring fd to be closed but got %v", err)
+		return
+	}
+	err = ur.Close()
+	if err != nil {
+		t.Errorf("close io-uring twice: %v", err)
+		return
+	}
+}
+
+func TestIoUringMapRings(t *testing.T) {
+	const entries = 8
+	params := &ioUringParams{sqEntries: entries, cqEntries: entries * 2}
+	params.sqOff = ioSqRingOffsets{head: 0, tail: 64, ringMask: 256, ringEntries: 264, flags: 276, dropped: 272, array: 320}
+	params.cqOff = ioCqRingOffsets{head: 128, tail: 192, ringMask: 260, ringEntries: 268, overflow: 284, cqes: 320}
+	ur := &ioUring{
+		params: params,
+		sq:     ioUringSq{ringSz: params.sqOff.array + 4*params.sqEntries},
+		cq:     ioUringCq{ringSz: params.cqOff.cqes + 16*params.cqEntries},
+		efd:    -1,
+	}
+	ur.sqRing = make([]byte, ur.sq.ringSz)
+	ur.sqes = make([]byte, entries*unsafe.Sizeof(ioUringSqe{}))
+	ur.cqRing = make([]byte, ur.cq.ringSz)
+	ur.mapRings()
+
+	*ur.sq.kTail, *ur.sq.kRingMask, *ur.sq.kDropped = 3, entries-1, 5
+	if binary.NativeEndian.Uint32(ur.sqRing[64:]) != 3 || binary.NativeEndian.Uint32(ur.sqRing[256:]) != entries-1 {
+		t.Errorf("sq ring view mismatch")
+		return
+	}
+	if binary.NativeEndian.Uint32(ur.sqRing[272:]) != 5 {
+		t.Errorf("sq dropped view mismatch")
+		return
+	}
+	if len(ur.sq.array) != entries || len(ur.sq.sqes) != entries || len(ur.cq.cqes) != entries*2 {
+		t.Errorf("ring lengths mismatch")
+		return
+	}
+	ur.sq.array[entries-1] = 7
+	if binary.NativeEndian.Uint32(ur.sqRing[len(ur.sqRing)-4:]) != 7 {
+		t.Errorf("sq array view mismatch")
+		return
+	}
+	ur.sq.sqes[entries-1].userData = 42
+	if binary.NativeEndian.Uint64(ur.sqes[len(ur.sqes)-32:]) != 42 {
+		t.Errorf("sqes view mismatch")
+		return
+	}
+	ur.cq.cqes[entries*2-1].res = 9
+	if binary.NativeEndian.Uint32(ur.cqRing[len(ur.cqRing)-8:]) != 9 {
+		t.Errorf("cqes view mismatch")
+		return
+	}
+	*ur.cq.kOverflow = 1
+	if binary.NativeEndian.Uint32(ur.cqRing[284:]) != 1 {
+		t.Errorf("cq overflow view mismatch")
+		return
+	}
+}
+
+// testLogger records the messages of the logged records
+type testLogger struct {
+	msgs []string
+}
+
+func (l *testLogger) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	l.msgs = append(l.msgs, msg)
+}
+
+func TestIoUringLogDrops(t *testing.T) {
+	ur := &ioUring{ringFd: -1}
+	ur.sq.kDropped, ur.cq.kOverflow = new(uint32), new(uint32)
+	l := &testLogger{}
+	ur.logDrops(context.Background(), l)
+	if len(l.msgs) != 0 {
+		t.Errorf("log drops expected no record but got %v", l.msgs)
+		return
+	}
+	*ur.sq.kDropped, *ur.cq.kOverflow = 2, 1
+	ur.logDrops(context.Background(), l)
+	ur.logDrops(context.Background(), l)
+	if len(l.msgs) != 2 || l.msgs[0] != "io-uring submissions dropped" || l.msgs[1] != "io-uring completions overflowed" {
+		t.Errorf("log drops expected two records but got %v", l.msgs)
+		return
+	}
+	// a nil Logger drops the records
+	*ur.sq.kDropped = 3
+	ur.logDrops(context.Background(), nil)
+}
@@ -0,0 +1,212 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+type ioUringBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+type ioUringBuf struct {
+	addr uint64
+	len  uint32
+	bid  uint16
+	resv uint16
+}
+
+// ioUringBufRing is a ring of provided buffers registered as a buffer group
+// The kernel picks a buffer from the ring for each completion of an operation
+// with IOSQE_BUFFER_SELECT, and the buffer must be provided again after the
+// data in it has been consumed
+type ioUringBufRing struct {
+	ur      *ioUring
+	bgid    uint16
+	mem     []byte
+	ring    []ioUringBuf
+	tail    uint16
+	mask    uint16
+	bufs    [][]byte
+	backing []byte
+	mu      sync.Mutex
+}
+
+// registerBufRing registers a ring of entries provided buffers with the given size
+// as the buffer group bgid. The entries must be a power of two
+func (ur *ioUring) registerBufRing(bgid uint16, entries int, size int) (*ioUringBufRing, error) {
+	if entries < 1 || entries > 1<<15 || entries&(entries-1) != 0 || size < 1 {
+		return nil, ErrInvalidParam
+	}
+	mem, err := unix.Mmap(-1, 0, entries*int(unsafe.Sizeof(ioUringBuf{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, errFromUnixErrno(err)
+	}
+	br := &ioUringBufRing{
+		ur:      ur,
+		bgid:    bgid,
+		mem:     mem,
+		ring:    unsafe.Slice((*ioUringBuf)(unsafe.Pointer(unsafe.SliceData(mem))), entries),
+		mask:    uint16(entries - 1),
+		bufs:    make([][]byte, entries),
+		backing: make([]byte, entries*size),
+	}
+	reg := ioUringBufReg{
+		ringAddr:    uint64(uintptr(unsafe.Pointer(unsafe.SliceData(mem)))),
+		ringEntries: uint32(entries),
+		bgid:        bgid,
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ur.ringFd), IORING_REGISTER_PBUF_RING, uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	if errno != 0 {
+		_ = unix.Munmap(mem)
+		return nil, errFromUnixErrno(errno)
+	}
+	for i := range entries {
+		br.bufs[i] = br.backing[i*size : (i+1)*size : (i+1)*size]
+		br.provide(uint16(i))
+	}
+
+	return br, nil
+}
+
+// provide gives the buffer bid back to the kernel
+func (br *ioUringBufRing) provide(bid uint16) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	e := &br.ring[br.tail&br.mask]
+	e.addr = uint64(uintptr(unsafe.Pointer(unsafe.SliceData(br.bufs[bid]))))
+	e.len = uint32(len(br.bufs[bid]))
+	e.bid = bid
+	br.tail++
+	// the tail shares a word with the bid of the first entry
+	// publish them with a release store after the entry has been filled
+	word := [2]uint16{br.ring[0].bid, br.tail}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&br.ring[0].bid)), *(*uint32)(unsafe.Pointer(&word)))
+}
+
+// buffer returns the id and the data of the buffer selected for the completion
+func (br *ioUringBufRing) buffer(cqe *ioUringCqe) (bid uint16, p []byte, ok bool) {
+	if cqe.flags&IORING_CQE_F_BUFFER == 0 || cqe.res < 0 {
+		return 0, nil, false
+	}
+	bid = uint16(cqe.flags >> IORING_CQE_BUFFER_SHIFT)
+	if int(bid) >= len(br.bufs) {
+		return 0, nil, false
+	}
+
+	return bid, br.bufs[bid][:cqe.res], true
+}
+
+// unregister unregisters the buffer group and unmaps the ring
+func (br *ioUringBufRing) unregister() error {
+	reg := ioUringBufReg{bgid: br.bgid}
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(br.ur.ringFd), IORING_UNREGISTER_PBUF_RING, uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	if errno != 0 {
+		return errFromUnixErrno(errno)
+	}
+	err := unix.Munmap(br.mem)
+	if err != nil {
+		return errFromUnixErrno(err)
+	}
+
+	return nil
+}
+
+// receiveMultishot submits a multishot receive on the connected socket fd
+// which keeps posting a completion with IORING_CQE_F_MORE for each inbound
+// segment into a buffer selected from the buffer group bgid
+func (ur *ioUring) receiveMultishot(ctx context.Context, fd int, bgid uint16) error {
+	e := ioUringSqe{
+		opcode:   IORING_OP_RECV,
+		flags:    IOSQE_BUFFER_SELECT,
+		ioprio:   IORING_RECV_MULTISHOT,
+		fd:       int32(fd),
+		bufIndex: bgid,
+	}
+
+	return ur.submitSqe(contextWithFD(ctx, fd), &e)
+}
+
+type multishotSegment struct {
+	bid uint16
+	p   []byte
+}
+
+// multishotReader surfaces the data received by a multishot receive as an io.Reader
+// The event loop feeds the completions, and the reader can be wrapped by
+// NewMessageReader with MessageOptionsNonblock. Read returns ErrTemporarilyUnavailable
+// when no data has been received
+type multishotReader struct {
+	br       *ioUringBufRing
+	mu       sync.Mutex
+	segments []multishotSegment
+	err      error
+}
+
+func newMultishotReader(br *ioUringBufRing) *multishotReader {
+	return &multishotReader{br: br, segments: make([]multishotSegment, 0, 8)}
+}
+
+// feed takes a completion of the multishot receive. It reports whether the
+// multishot receive has terminated and should be submitted again
+func (r *multishotReader) feed(cqe *ioUringCqe) (rearm bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case cqe.res == 0:
+		r.err = io.EOF
+		return false
+	case cqe.res < 0:
+		errno := unix.Errno(-cqe.res)
+		// the buffer group has run out of buffers
+		if errno == unix.ENOBUFS {
+			return true
+		}
+		r.err = errFromUnixErrno(errno)
+		return false
+	}
+	if bid, p, ok := r.br.buffer(cqe); ok {
+		r.segments = append(r.segments, multishotSegment{bid: bid, p: p})
+	}
+
+	return !cqe.more()
+}
+
+func (r *multishotReader) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.segments) > 0 && n < len(p) {
+		seg := &r.segments[0]
+		cn := copy(p[n:], seg.p)
+		n += cn
+		seg.p = seg.p[cn:]
+		if len(seg.p) > 0 {
+			break
+		}
+		r.br.provide(seg.bid)
+		r.segments[0] = multishotSegment{}
+		r.segments = r.segments[1:]
+	}
+	if n > 0 {
+		return n, nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return 0, ErrTemporarilyUnavailable
+}
@@ -0,0 +1,159 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/sys/unix"
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestBufRing(entries int, size int) *ioUringBufRing {
+	br := &ioUringBufRing{
+		ring:    make([]ioUringBuf, entries),
+		mask:    uint16(entries - 1),
+		bufs:    make([][]byte, entries),
+		backing: make([]byte, entries*size),
+	}
+	for i := range entries {
+		br.bufs[i] = br.backing[i*size : (i+1)*size : (i+1)*size]
+		br.provide(uint16(i))
+	}
+	return br
+}
+
+func TestMultishotReader(t *testing.T) {
+	t.Run("provide", func(t *testing.T) {
+		br := newTestBufRing(4, 16)
+		if br.tail != 4 || br.ring[0].resv != 4 {
+			t.Errorf("expected tail 4 but got %d %d", br.tail, br.ring[0].resv)
+			return
+		}
+		for i, e := range br.ring {
+			if e.bid != uint16(i) || e.len != 16 {
+				t.Errorf("unexpected entry %d: %+v", i, e)
+				return
+			}
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		br := newTestBufRing(4, 16)
+		r := newMultishotReader(br)
+		segment := func(bid uint16, p []byte, flags uint32) *ioUringCqe {
+			copy(br.bufs[bid], p)
+			return &ioUringCqe{res: int32(len(p)), flags: flags | IORING_CQE_F_BUFFER | uint32(bid)<<IORING_CQE_BUFFER_SHIFT}
+		}
+		mr := NewMessageReader(r, MessageOptionsNonblock)
+		p := make([]byte, 8)
+		_, err := mr.Read(p)
+		if err != ErrTemporarilyUnavailable {
+			t.Errorf("read expected ErrTemporarilyUnavailable but got %v", err)
+			return
+		}
+		if r.feed(segment(1, []byte("\x05ab"), IORING_CQE_F_MORE)) {
+			t.Errorf("expected no rearm with IORING_CQE_F_MORE")
+			return
+		}
+		if !r.feed(segment(2, []byte("cde"), 0)) {
+			t.Errorf("expected rearm without IORING_CQE_F_MORE")
+			return
+		}
+		for {
+			n, err := mr.Read(p)
+			if err == ErrTemporarilyUnavailable {
+				continue
+			}
+			if err != nil {
+				t.Errorf("read message: %v", err)
+				return
+			}
+			if !bytes.Equal(p[:n], []byte("abcde")) {
+				t.Errorf("read message expected abcde but got %s", p[:n])
+				return
+			}
+			break
+		}
+		if br.tail != 6 {
+			t.Errorf("expected the consumed buffers to be provided again but tail is %d", br.tail)
+			return
+		}
+		r.feed(&ioUringCqe{res: 0})
+		_, err = r.Read(p)
+		if err != io.EOF {
+			t.Errorf("read expected io.EOF but got %v", err)
+			return
+		}
+	})
+}
+
+func TestIOUring_MultishotReceive(t *testing.T) {
+	ur, err := newIoUring(16)
+	if err != nil {
+		t.Errorf("new io-uring: %v", err)
+		return
+	}
+	defer ur.Close()
+	br, err := ur.registerBufRing(1, 4, BufferSizeSmall)
+	if err != nil {
+		t.Errorf("register buffer ring: %v", err)
+		return
+	}
+	defer br.unregister()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Errorf("socket pair: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	err = ur.receiveMultishot(context.TODO(), fds[0], 1)
+	if err != nil {
+		t.Errorf("submit multishot receive: %v", err)
+		return
+	}
+	err = ur.enter()
+	if err != nil {
+		t.Errorf("io_uring enter: %v", err)
+		return
+	}
+	r := newMultishotReader(br)
+	for _, s := range []string{"abc", "def"} {
+		_, err = unix.Write(fds[1], []byte(s))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		dl := time.Now().Add(2 * time.Second)
+		for {
+			cqe, err := ur.wait()
+			if err == ErrTemporarilyUnavailable {
+				if time.Now().After(dl) {
+					t.Errorf("wait completion timeout")
+					return
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("wait completion: %v", err)
+				return
+			}
+			r.feed(cqe)
+			break
+		}
+	}
+	p := make([]byte, 8)
+	n, err := r.Read(p)
+	if err != nil || string(p[:n]) != "abcdef" {
+		t.Errorf("read expected abcdef but got %s: %v", p[:n], err)
+		return
+	}
+}
@@ -0,0 +1,54 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// zeroCopySend tracks the two completions of a zero-copy send
+// The buffers of the send must not be modified until Reusable returns true
+type zeroCopySend struct {
+	res      int32
+	done     bool
+	reusable bool
+}
+
+// complete feeds a completion of the zero-copy send
+func (zc *zeroCopySend) complete(cqe *ioUringCqe) {
+	if cqe.notif() {
+		zc.reusable = true
+		return
+	}
+	zc.res, zc.done = cqe.res, true
+	// no notification follows if the send has failed before taking the buffers
+	if !cqe.more() {
+		zc.reusable = true
+	}
+}
+
+// Done reports whether the result of the send has arrived
+func (zc *zeroCopySend) Done() bool {
+	return zc.done
+}
+
+// Reusable reports whether the buffers of the send are safe to be reused
+func (zc *zeroCopySend) Reusable() bool {
+	return zc.reusable
+}
+
+// Result returns the number of the bytes sent
+func (zc *zeroCopySend) Result() (n int, err error) {
+	if !zc.done {
+		return 0, ErrTemporarilyUnavailable
+	}
+	if zc.res < 0 {
+		return 0, errFromUnixErrno(unix.Errno(-zc.res))
+	}
+
+	return int(zc.res), nil
+}
@@ -0,0 +1,111 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package sox
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/sys/unix"
+	"testing"
+	"time"
+)
+
+func TestZeroCopySend(t *testing.T) {
+	t.Run("data and notification", func(t *testing.T) {
+		zc := zeroCopySend{}
+		zc.complete(&ioUringCqe{res: 14, flags: IORING_CQE_F_MORE})
+		if !zc.Done() || zc.Reusable() {
+			t.Errorf("expected done but not reusable before notification")
+			return
+		}
+		n, err := zc.Result()
+		if err != nil || n != 14 {
+			t.Errorf("expected result 14 but got %d: %v", n, err)
+			return
+		}
+		zc.complete(&ioUringCqe{flags: IORING_CQE_F_NOTIF})
+		if !zc.Reusable() {
+			t.Errorf("expected reusable after notification")
+			return
+		}
+	})
+
+	t.Run("failed without notification", func(t *testing.T) {
+		zc := zeroCopySend{}
+		_, err := zc.Result()
+		if err != ErrTemporarilyUnavailable {
+			t.Errorf("expected ErrTemporarilyUnavailable before completion but got %v", err)
+			return
+		}
+		zc.complete(&ioUringCqe{res: -int32(unix.EINVAL)})
+		if !zc.Done() || !zc.Reusable() {
+			t.Errorf("expected done and reusable after failure")
+			return
+		}
+		_, err = zc.Result()
+		if err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam but got %v", err)
+			return
+		}
+	})
+}
+
+func TestIOUring_SendZC(t *testing.T) {
+	ur, err := newIoUring(16)
+	if err != nil {
+		t.Errorf("new io-uring: %v", err)
+		return
+	}
+	defer ur.Close()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Errorf("socket pair: %v", err)
+		return
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	p := []byte("test0123456789")
+	err = ur.sendZC(context.TODO(), fds[1], p)
+	if err != nil {
+		t.Errorf("submit send zc: %v", err)
+		return
+	}
+	err = ur.enter()
+	if err != nil {
+		t.Errorf("io_uring enter: %v", err)
+		return
+	}
+	zc := zeroCopySend{}
+	dl := time.Now().Add(2 * time.Second)
+	for !zc.Reusable() {
+		cqe, err := ur.wait()
+		if err == ErrTemporarilyUnavailable {
+			if time.Now().After(dl) {
+				t.Errorf("wait completion timeout")
+				return
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("wait completion: %v", err)
+			return
+		}
+		zc.complete(cqe)
+	}
+	n, err := zc.Result()
+	if err != nil || n != len(p) {
+		t.Errorf("send zc expected %d bytes but got %d: %v", len(p), n, err)
+		return
+	}
+	b := make([]byte, len(p))
+	_, err = unix.Read(fds[0], b)
+	if err != nil || !bytes.Equal(b, p) {
+		t.Errorf("read expected %s but got %s: %v", p, b, err)
+		return
+	}
+}
@@ -8,6 +8,9 @@ package sox
 
 import (
 	"golang.org/x/sys/unix"
+	"runtime"
+	"slices"
+	"sync"
 	"unsafe"
 )
 
@@ -40,17 +43,120 @@ func errFromUnixErrno(err error) error {
 		return ErrNoAvailableMemory
 	case unix.EPERM:
 		return ErrNoPermission
+	case unix.EACCES:
+		return ErrPermissionDenied
+	case unix.EBADF:
+		return ErrBadFd
+	case unix.ENOTSOCK:
+		return ErrNotSocket
+	case unix.ENOBUFS:
+		return ErrNoBufferSpace
+	case unix.EMSGSIZE:
+		return ErrMsgSize
+	case unix.EOPNOTSUPP:
+		return ErrNotSupported
+	case unix.EPROTONOSUPPORT:
+		return ErrProtocolNotSupported
+	case unix.EAFNOSUPPORT:
+		return ErrAddrFamilyNotSupported
+	case unix.EADDRINUSE:
+		return ErrAddrInUse
+	case unix.EADDRNOTAVAIL:
+		return ErrAddrNotAvailable
+	case unix.ENETDOWN:
+		return ErrNetworkDown
+	case unix.ENETUNREACH:
+		return ErrNetworkUnreachable
+	case unix.EHOSTUNREACH:
+		return ErrHostUnreachable
+	case unix.ECONNABORTED:
+		return ErrConnectionAborted
+	case unix.ECONNRESET:
+		return ErrConnectionReset
+	case unix.ECONNREFUSED:
+		return ErrConnectionRefused
+	case unix.EISCONN:
+		return ErrAlreadyConnected
+	case unix.ENOTCONN:
+		return ErrNotConnected
+	case unix.EALREADY:
+		return ErrAlreadyInProgress
+	case unix.ETIMEDOUT:
+		return ErrTimedOut
+	case unix.EPIPE:
+		return ErrBrokenPipe
 	default:
 		return errno
 	}
 }
 
-func ioVecFromBytesSlice(iov [][]byte) (addr uintptr, n int) {
-	vec := make([]unix.Iovec, len(iov))
+// ioVec holds the iovec array and the message header built from bytes slices
+// The array and the buffers are pinned, so that they are neither moved nor
+// collected while the kernel refers to them. The ioVec must be released after
+// the operation has completed
+type ioVec struct {
+	vec    []unix.Iovec
+	msg    unix.Msghdr
+	from   unix.RawSockaddrAny
+	pinner runtime.Pinner
+}
+
+var ioVecPool = sync.Pool{New: func() any { return &ioVec{} }}
+
+func newIoVec(iov [][]byte) *ioVec {
+	v := ioVecPool.Get().(*ioVec)
+	v.vec = slices.Grow(v.vec[:0], len(iov))[:len(iov)]
 	for i := range len(iov) {
-		vec[i] = unix.Iovec{Base: &iov[i][0], Len: uint64(len(iov[i]))}
+		if len(iov[i]) < 1 {
+			continue
+		}
+		v.vec[i].Base = &iov[i][0]
+		v.vec[i].SetLen(len(iov[i]))
+		v.pinner.Pin(&iov[i][0])
+	}
+	v.pinner.Pin(v)
+	if len(v.vec) > 0 {
+		v.pinner.Pin(&v.vec[0])
+	}
+
+	return v
+}
+
+// addr returns the pointer to the iovec array
+func (v *ioVec) addr() unsafe.Pointer {
+	return unsafe.Pointer(unsafe.SliceData(v.vec))
+}
+
+// n returns the number of the iovec entries
+func (v *ioVec) n() int {
+	return len(v.vec)
+}
+
+// msghdr fills the message header referring to the iovec array
+// and returns the pointer to the header
+func (v *ioVec) msghdr(name unsafe.Pointer, namelen int, oob []byte) unsafe.Pointer {
+	v.msg = unix.Msghdr{Name: (*byte)(name), Namelen: uint32(namelen)}
+	if len(v.vec) > 0 {
+		v.msg.Iov = &v.vec[0]
+		v.msg.SetIovlen(len(v.vec))
 	}
-	addr, n = uintptr(unsafe.Pointer(&iov)), len(iov)
+	if len(oob) > 0 {
+		v.msg.Control = &oob[0]
+		v.msg.SetControllen(len(oob))
+		v.pinner.Pin(&oob[0])
+	}
+	if name != nil {
+		v.pinner.Pin(name)
+	}
+
+	return unsafe.Pointer(&v.msg)
+}
 
-	return
+// release unpins the buffers and puts the ioVec back to the pool
+func (v *ioVec) release() {
+	v.pinner.Unpin()
+	clear(v.vec)
+	v.vec = v.vec[:0]
+	v.msg, v.from = unix.Msghdr{}, unix.RawSockaddrAny{}
+	ioVecPool.Put(v)
 }
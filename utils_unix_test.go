@@ -0,0 +1,142 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package sox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestIoVec(t *testing.T) {
+	t.Run("iovec array", func(t *testing.T) {
+		iov := [][]byte{make([]byte, 8), make([]byte, 16), {}, make([]byte, 32)}
+		vec := newIoVec(iov)
+		defer vec.release()
+		runtime.GC()
+		if vec.n() != len(iov) {
+			t.Errorf("iovec expected %d entries but got %d", len(iov), vec.n())
+			return
+		}
+		entries := unsafe.Slice((*unix.Iovec)(vec.addr()), vec.n())
+		for i := range iov {
+			if int(entries[i].Len) != len(iov[i]) {
+				t.Errorf("iovec %d expected len %d but got %d", i, len(iov[i]), entries[i].Len)
+				return
+			}
+			if len(iov[i]) > 0 && entries[i].Base != &iov[i][0] {
+				t.Errorf("iovec %d refers to wrong base", i)
+				return
+			}
+		}
+	})
+
+	t.Run("msghdr", func(t *testing.T) {
+		iov, oob := [][]byte{make([]byte, 8), make([]byte, 16)}, make([]byte, 64)
+		vec := newIoVec(iov)
+		defer vec.release()
+		msg := (*unix.Msghdr)(vec.msghdr(unsafe.Pointer(&vec.from), unix.SizeofSockaddrAny, oob))
+		if msg.Iov != &vec.vec[0] || int(msg.Iovlen) != len(iov) {
+			t.Errorf("msghdr refers to wrong iovec")
+			return
+		}
+		if msg.Control != &oob[0] || int(msg.Controllen) != len(oob) {
+			t.Errorf("msghdr refers to wrong control")
+			return
+		}
+		if msg.Name != (*byte)(unsafe.Pointer(&vec.from)) || msg.Namelen != unix.SizeofSockaddrAny {
+			t.Errorf("msghdr refers to wrong name")
+			return
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		vec := newIoVec([][]byte{make([]byte, 8)})
+		vec.release()
+		if vec.n() != 0 || vec.msg.Iov != nil {
+			t.Errorf("released ioVec expected to be reset")
+			return
+		}
+	})
+}
+
+func TestErrFromUnixErrno(t *testing.T) {
+	cases := []struct {
+		errno unix.Errno
+		err   error
+	}{
+		{unix.EAGAIN, ErrTemporarilyUnavailable},
+		{unix.EINVAL, ErrInvalidParam},
+		{unix.ECONNRESET, ErrConnectionReset},
+		{unix.EPIPE, ErrBrokenPipe},
+		{unix.ECONNREFUSED, ErrConnectionRefused},
+		{unix.EHOSTUNREACH, ErrHostUnreachable},
+		{unix.ENETUNREACH, ErrNetworkUnreachable},
+		{unix.EADDRINUSE, ErrAddrInUse},
+		{unix.EMSGSIZE, ErrMsgSize},
+	}
+	for _, c := range cases {
+		err := errFromUnixErrno(c.errno)
+		if err != c.err {
+			t.Errorf("errno %v expected %v but got %v", c.errno, c.err, err)
+			return
+		}
+		var e *ErrnoError
+		if !errors.As(err, &e) || e.Errno() != c.errno || !errors.Is(err, c.errno) {
+			t.Errorf("expected %v to unwrap to errno %v", err, c.errno)
+			return
+		}
+	}
+	err := errFromUnixErrno(unix.ENOENT)
+	if err != unix.ENOENT {
+		t.Errorf("expected unmapped errno ENOENT but got %v", err)
+		return
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	cases := []struct {
+		err       error
+		temporary bool
+		timeout   bool
+	}{
+		{ErrTemporarilyUnavailable, true, true},
+		{ErrInterruptedSyscall, true, false},
+		{ErrProcessFileLimit, true, false},
+		{ErrTimedOut, true, true},
+		{ErrConnectionReset, false, false},
+		{&OpError{Op: "read", Net: "tcp4", Err: ErrTemporarilyUnavailable}, true, true},
+		{&OpError{Op: "read", Net: "tcp4", Err: ErrBrokenPipe}, false, false},
+		{fmt.Errorf("wrapped: %w", ErrTimedOut), true, true},
+		{os.ErrDeadlineExceeded, true, true},
+		{context.DeadlineExceeded, true, true},
+		{io.EOF, false, false},
+		{nil, false, false},
+	}
+	for _, c := range cases {
+		if IsTemporary(c.err) != c.temporary {
+			t.Errorf("%v expected temporary %v", c.err, c.temporary)
+			return
+		}
+		if IsTimeout(c.err) != c.timeout {
+			t.Errorf("%v expected timeout %v", c.err, c.timeout)
+			return
+		}
+	}
+	var ne net.Error
+	if !errors.As(ErrTemporarilyUnavailable, &ne) || !ne.Timeout() {
+		t.Errorf("expected ErrTemporarilyUnavailable to be a net.Error timeout")
+		return
+	}
+}
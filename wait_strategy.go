@@ -0,0 +1,77 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"runtime"
+)
+
+// WaitStrategy specifies how a blocking operation waits
+// while it is temporarily unavailable
+type WaitStrategy int
+
+const (
+	// WaitStrategySpin spins with procyield and backs off to yield or sleep.
+	// It has the lowest latency and burns CPU while waiting
+	WaitStrategySpin WaitStrategy = iota
+	// WaitStrategyYield yields the processor on every retry
+	WaitStrategyYield
+	// WaitStrategyPark parks the waiting goroutine until it is woken by
+	// the counterpart operation. It costs nothing while idle. The goroutine
+	// is parked by the Go scheduler and holds no OS thread, so that any
+	// number of goroutines may wait. The waits which have no counterpart to wake
+	// them, e.g. the waits of Message for the underlying reader or writer,
+	// fall back to WaitStrategySpinThenSleep
+	WaitStrategyPark
+	// WaitStrategySpinThenYield spins with procyield for a while and then
+	// yields the processor on every retry
+	WaitStrategySpinThenYield
+	// WaitStrategySpinThenSleep spins with procyield for a few times and
+	// then sleeps a jiffy on every retry. It suits the long waits which do
+	// not need a low latency
+	WaitStrategySpinThenSleep
+)
+
+// strategyWait performs a single wait of a blocking operation with its WaitStrategy
+// For WaitStrategyPark, the first once arms the parker and returns immediately
+// so that the caller checks its condition again before it really parks
+type strategyWait struct {
+	strategy WaitStrategy
+	sw       *SpinWait
+	pk       *parker
+	gen      uint32
+	armed    bool
+}
+
+func (w *strategyWait) once() {
+	switch w.strategy {
+	case WaitStrategyYield:
+		runtime.Gosched()
+	case WaitStrategySpinThenYield:
+		w.sw.OnceWithLevel(SpinWaitLevelConsume)
+	case WaitStrategySpinThenSleep:
+		w.sw.OnceWithLevel(SpinWaitLevelBlockingIO)
+	case WaitStrategyPark:
+		if w.pk == nil {
+			w.sw.OnceWithLevel(SpinWaitLevelBlockingIO)
+			return
+		}
+		if !w.armed {
+			w.gen, w.armed = w.pk.prepare(), true
+			return
+		}
+		w.pk.park(w.gen)
+		w.armed = false
+	default:
+		w.sw.Once()
+	}
+}
+
+func (w *strategyWait) done() {
+	if w.armed {
+		w.pk.cancel()
+		w.armed = false
+	}
+}
@@ -0,0 +1,648 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"unicode/utf8"
+)
+
+// WebSocketOptions represents the options of FrameFormatWebSocket
+type WebSocketOptions struct {
+	// Client makes written frames masked and read frames required to be unmasked
+	// A false Client indicates the server side, which is the other way around
+	Client bool
+	// Text makes written messages text messages instead of binary messages
+	Text bool
+	// MaxFrameSize is the maximum payload size of written frames, and longer
+	// messages are fragmented. A MaxFrameSize of zero indicates that there is no limit
+	MaxFrameSize int
+	// MaxReadFrameSize is the maximum payload size of read frames. A longer frame
+	// fails the reader with ErrMsgTooLong, after which the connection should be
+	// closed with WebSocketCloseTooBig. A MaxReadFrameSize of zero indicates that
+	// DefaultWebSocketMaxReadFrameSize will be used
+	MaxReadFrameSize int
+	// OnPing is invoked with the payload of each received ping frame
+	// The payload is only valid during the invocation
+	OnPing func(payload []byte)
+	// OnPong is invoked with the payload of each received pong frame
+	// The payload is only valid during the invocation
+	OnPong func(payload []byte)
+	// OnClose is invoked with the status code and the reason of the received close frame
+	// The code is WebSocketCloseNoStatus if the close frame has no status code
+	OnClose func(code int, reason string)
+}
+
+const (
+	// DefaultWebSocketMaxReadFrameSize is the MaxReadFrameSize of the readers
+	// without a MaxReadFrameSize
+	DefaultWebSocketMaxReadFrameSize = 16 << 20
+	// DefaultWebSocketReadLimit is the ReadLimit of the FrameFormatWebSocket
+	// readers without a ReadLimit, which bounds the reassembled messages
+	DefaultWebSocketReadLimit = 64 << 20
+)
+
+// WebSocket close status codes of RFC 6455
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseUnsupportedData = 1003
+	WebSocketCloseNoStatus        = 1005
+	WebSocketCloseInvalidPayload  = 1007
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseTooBig          = 1009
+	WebSocketCloseInternalError   = 1011
+)
+
+// WebSocketWriter is the interface that groups the basic Write method and the
+// methods to write WebSocket control frames. The io.Writer returned by
+// NewMessageWriter and NewMessageReadWriter implements WebSocketWriter
+//
+// With MessageOptionsNonblock, a control frame which has returned ErrTemporarilyUnavailable
+// stays pending, and will be flushed by retrying or by the next call of the write methods
+type WebSocketWriter interface {
+	io.Writer
+	// WritePing writes a ping frame with the payload of at most 125 bytes
+	WritePing(payload []byte) error
+	// WritePong writes a pong frame with the payload of at most 125 bytes
+	WritePong(payload []byte) error
+	// WriteClose writes a close frame with the status code and the reason of at most
+	// 123 bytes. A code of zero or WebSocketCloseNoStatus writes a close frame without
+	// body. Writing messages after the close frame returns ErrMsgClosed
+	WriteClose(code int, reason string) error
+}
+
+//
+// The base framing protocol of RFC 6455 is as follows:
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-------+-+-------------+-------------------------------+
+// |F|R|R|R| opcode|M| Payload len |    Extended payload length    |
+// |I|S|S|S|  (4)  |A|     (7)     |             (16/64)           |
+// |N|V|V|V|       |S|             |   (if payload len==126/127)   |
+// | |1|2|3|       |K|             |                               |
+// +-+-+-+-+-------+-+-------------+ - - - - - - - - - - - - - - - +
+// |     Extended payload length continued, if payload len == 127  |
+// + - - - - - - - - - - - - - - - +-------------------------------+
+// |                               |Masking-key, if MASK set to 1  |
+// +-------------------------------+-------------------------------+
+// | Masking-key (continued)       |          Payload Data         |
+// +-------------------------------- - - - - - - - - - - - - - - - +
+// :                     Payload Data continued ...                :
+// +---------------------------------------------------------------+
+//
+// The extended payload length is always in network byte order
+//
+
+const (
+	webSocketOpContinuation = 0x0
+	webSocketOpText         = 0x1
+	webSocketOpBinary       = 0x2
+	webSocketOpClose        = 0x8
+	webSocketOpPing         = 0x9
+	webSocketOpPong         = 0xa
+
+	webSocketFlagFin     = 0x80
+	webSocketFlagRsvMask = 0x70
+	webSocketOpMask      = 0x0f
+	webSocketFlagMask    = 0x80
+	webSocketLengthMask  = 0x7f
+
+	webSocketHeaderMaxLength      = 14
+	webSocketPayloadMaxLength7    = 125
+	webSocketPayloadLength16      = 126
+	webSocketPayloadLength64      = 127
+	webSocketControlMaxLength     = 125
+	webSocketCloseReasonMaxLength = webSocketControlMaxLength - 2
+	// the fragments are reassembled by chunks of at most this size
+	webSocketFragChunkSize = 64 << 10
+)
+
+type webSocket struct {
+	client           bool
+	text             bool
+	maxFrameSize     int
+	maxReadFrameSize int64
+	onPing           func(payload []byte)
+	onPong           func(payload []byte)
+	onClose          func(code int, reason string)
+
+	// read side
+	header        [webSocketHeaderMaxLength]byte
+	headerOffset  int64
+	headerLength  int64
+	payloadOffset int64
+	opcode        byte
+	fin           bool
+	masked        bool
+	key           [4]byte
+	length        int64
+	fragmented    bool
+	fragOpcode    byte
+	frag          []byte
+	ready         bool
+	discarding    bool
+	control       [webSocketControlMaxLength]byte
+	rerr          error
+
+	// write side
+	mu        sync.Mutex
+	out       *BufferLease
+	outOffset int
+	outData   bool
+	sentClose bool
+}
+
+func newWebSocket(opt WebSocketOptions) *webSocket {
+	ws := &webSocket{
+		client:           opt.Client,
+		text:             opt.Text,
+		maxFrameSize:     opt.MaxFrameSize,
+		maxReadFrameSize: int64(opt.MaxReadFrameSize),
+		onPing:           opt.OnPing,
+		onPong:           opt.OnPong,
+		onClose:          opt.OnClose,
+	}
+	if ws.maxReadFrameSize < 1 {
+		ws.maxReadFrameSize = DefaultWebSocketMaxReadFrameSize
+	}
+	return ws
+}
+
+func (ws *webSocket) resetFrame() {
+	ws.headerOffset, ws.headerLength, ws.payloadOffset = 0, 0, 0
+}
+
+func (ws *webSocket) resetMessage() {
+	ws.fragmented, ws.ready = false, false
+	ws.frag = ws.frag[:0]
+}
+
+func (msg *message) readWebSocket(p []byte) (n int, err error) {
+	defer func() {
+		if err != ErrTemporarilyUnavailable {
+			msg.exitRead()
+		}
+	}()
+
+	ws := msg.ws
+	for {
+		if ws.rerr != nil {
+			return 0, ws.rerr
+		}
+		if ws.ready {
+			// a fragmented message has been reassembled
+			msg.length = int64(len(ws.frag))
+			if len(ws.frag) > len(p) {
+				return 0, io.ErrShortBuffer
+			}
+			n = copy(p, ws.frag)
+			ws.resetMessage()
+			msg.count.Add(-1)
+			return n, nil
+		}
+		if ws.headerLength == 0 || ws.headerOffset < ws.headerLength {
+			err = msg.readWebSocketHeader()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if ws.opcode&webSocketOpClose != 0 {
+			payload := ws.control[:ws.length]
+			err = msg.fillWebSocket(payload, &ws.payloadOffset)
+			if err != nil {
+				return 0, err
+			}
+			ws.unmask(payload)
+			ws.resetFrame()
+			err = msg.handleWebSocketControl(ws.opcode, payload)
+			if err != nil {
+				ws.rerr = err
+				return 0, err
+			}
+			continue
+		}
+
+		if ws.discarding {
+			err = msg.skip()
+			if err != nil {
+				return 0, err
+			}
+			ws.resetFrame()
+			if !ws.fin {
+				continue
+			}
+			ws.resetMessage()
+			ws.discarding = false
+			return 0, ErrMsgTooLong
+		}
+
+		if !ws.fragmented && ws.fin {
+			// an unfragmented message is read into p directly
+			// the read can be retried with a larger buffer, see readLease
+			msg.length = ws.length
+			if ws.length > int64(len(p)) {
+				return 0, io.ErrShortBuffer
+			}
+			payload := p[:ws.length]
+			err = msg.fillWebSocket(payload, &ws.payloadOffset)
+			if err != nil {
+				return 0, err
+			}
+			ws.unmask(payload)
+			ws.resetFrame()
+			if ws.opcode == webSocketOpText && !utf8.Valid(payload) {
+				ws.rerr = ErrMsgProtocol
+				return 0, ErrMsgProtocol
+			}
+			msg.count.Add(-1)
+			return len(payload), nil
+		}
+
+		// the payload of fragments is appended to frag as it arrives, instead of
+		// growing frag by the length which the peer declares
+		for ws.payloadOffset < ws.length {
+			n, k := len(ws.frag), int(min(ws.length-ws.payloadOffset, webSocketFragChunkSize))
+			ws.frag = slices.Grow(ws.frag, k)[:n+k]
+			offset := int64(0)
+			err = msg.fillWebSocket(ws.frag[n:], &offset)
+			ws.frag, ws.payloadOffset = ws.frag[:n+int(offset)], ws.payloadOffset+offset
+			if err != nil {
+				return 0, err
+			}
+		}
+		payload := ws.frag[int64(len(ws.frag))-ws.length:]
+		ws.unmask(payload)
+		ws.resetFrame()
+		if !ws.fin {
+			continue
+		}
+		if ws.fragOpcode == webSocketOpText && !utf8.Valid(ws.frag) {
+			ws.rerr = ErrMsgProtocol
+			return 0, ErrMsgProtocol
+		}
+		ws.ready = true
+	}
+}
+
+// readWebSocketHeader reads and validates the header of the next frame
+func (msg *message) readWebSocketHeader() error {
+	ws := msg.ws
+	if ws.headerLength == 0 {
+		err := msg.fillWebSocket(ws.header[:2], &ws.headerOffset)
+		if err == io.ErrUnexpectedEOF && ws.headerOffset == 0 && !ws.fragmented {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		err = ws.parseHeader()
+		if err != nil {
+			ws.rerr = err
+			return err
+		}
+	}
+	err := msg.fillWebSocket(ws.header[:ws.headerLength], &ws.headerOffset)
+	if err != nil {
+		return err
+	}
+
+	b := ws.header[2:ws.headerLength]
+	switch l := ws.header[1] & webSocketLengthMask; l {
+	case webSocketPayloadLength16:
+		ws.length = int64(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	case webSocketPayloadLength64:
+		u64 := binary.BigEndian.Uint64(b)
+		if u64 > 1<<63-1 {
+			ws.rerr = ErrMsgProtocol
+			return ErrMsgProtocol
+		}
+		ws.length = int64(u64)
+		b = b[8:]
+	default:
+		ws.length = int64(l)
+	}
+	if ws.masked {
+		copy(ws.key[:], b)
+	}
+	if int64(int(ws.length)) != ws.length {
+		ws.rerr = ErrMsgTooLong
+		return ErrMsgTooLong
+	}
+	if ws.opcode&webSocketOpClose != 0 {
+		return nil
+	}
+	if ws.length > ws.maxReadFrameSize {
+		ws.rerr = ErrMsgTooLong
+		return ErrMsgTooLong
+	}
+	if !ws.discarding && msg.readLimit > 0 && int64(len(ws.frag))+ws.length > msg.readLimit {
+		// the oversized message is skipped, see readWebSocket
+		ws.discarding = true
+	}
+	if !ws.fragmented && !ws.fin {
+		ws.fragmented, ws.fragOpcode = true, ws.opcode
+	}
+	if ws.discarding {
+		msg.discard = ws.length
+	}
+
+	return nil
+}
+
+// parseHeader validates the first two bytes of the header and sets the header length
+func (ws *webSocket) parseHeader() error {
+	b0, b1 := ws.header[0], ws.header[1]
+	if b0&webSocketFlagRsvMask != 0 {
+		return ErrMsgProtocol
+	}
+	ws.opcode, ws.fin = b0&webSocketOpMask, b0&webSocketFlagFin != 0
+	ws.masked = b1&webSocketFlagMask != 0
+	length := b1 & webSocketLengthMask
+	switch ws.opcode {
+	case webSocketOpContinuation:
+		if !ws.fragmented {
+			return ErrMsgProtocol
+		}
+	case webSocketOpText, webSocketOpBinary:
+		if ws.fragmented {
+			return ErrMsgProtocol
+		}
+	case webSocketOpClose, webSocketOpPing, webSocketOpPong:
+		if !ws.fin || length > webSocketPayloadMaxLength7 {
+			return ErrMsgProtocol
+		}
+	default:
+		return ErrMsgProtocol
+	}
+	// clients must mask frames and servers must not
+	if ws.masked == ws.client {
+		return ErrMsgProtocol
+	}
+
+	ws.headerLength = 2
+	if length == webSocketPayloadLength16 {
+		ws.headerLength += 2
+	} else if length == webSocketPayloadLength64 {
+		ws.headerLength += 8
+	}
+	if ws.masked {
+		ws.headerLength += 4
+	}
+
+	return nil
+}
+
+func (ws *webSocket) unmask(b []byte) {
+	if ws.masked {
+		webSocketMask(ws.key, b)
+	}
+}
+
+func webSocketMask(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// fillWebSocket reads into b from the offset until b is full
+func (msg *message) fillWebSocket(b []byte, offset *int64) error {
+	for *offset < int64(len(b)) {
+		rn, err := msg.readOnce(b[*offset:])
+		*offset += int64(rn)
+		if err == io.EOF {
+			if *offset < int64(len(b)) {
+				return io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (msg *message) handleWebSocketControl(opcode byte, payload []byte) error {
+	ws := msg.ws
+	switch opcode {
+	case webSocketOpPing:
+		if ws.onPing != nil {
+			ws.onPing(payload)
+		}
+		if msg.peer != nil {
+			// the pong is best effort, and the error will be
+			// returned by the next write of the peer if any
+			_ = msg.peer.writeWebSocketControl(webSocketOpPong, payload)
+		}
+	case webSocketOpPong:
+		if ws.onPong != nil {
+			ws.onPong(payload)
+		}
+	case webSocketOpClose:
+		code, reason := WebSocketCloseNoStatus, ""
+		if len(payload) == 1 {
+			return ErrMsgProtocol
+		}
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+			reason = string(payload[2:])
+			if !webSocketValidCloseCode(code) || !utf8.ValidString(reason) {
+				return ErrMsgProtocol
+			}
+		}
+		if ws.onClose != nil {
+			ws.onClose(code, reason)
+		}
+		if msg.peer != nil {
+			_ = msg.peer.writeWebSocketClose(code, "")
+		}
+		return io.EOF
+	}
+
+	return nil
+}
+
+// webSocketValidCloseCode returns true if the code may be sent in close frames
+func webSocketValidCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != 1005 && code != 1006
+}
+
+func (msg *message) writeWebSocket(p []byte) (n int, err error) {
+	defer func() {
+		if err != ErrTemporarilyUnavailable {
+			msg.exitWrite()
+		}
+	}()
+
+	ws := msg.ws
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.out != nil && !ws.outData {
+		err = msg.flushWebSocket()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if ws.out == nil {
+		if ws.sentClose {
+			return 0, ErrMsgClosed
+		}
+		opcode := byte(webSocketOpBinary)
+		if ws.text {
+			if !utf8.Valid(p) {
+				return 0, ErrMsgInvalidArguments
+			}
+			opcode = webSocketOpText
+		}
+		ws.out = msg.encodeWebSocket(opcode, p)
+		ws.outOffset, ws.outData = 0, true
+	}
+	err = msg.flushWebSocket()
+	if err != nil {
+		return 0, err
+	}
+
+	msg.count.Add(1)
+	return len(p), nil
+}
+
+func (msg *message) writeWebSocketControl(opcode byte, payload []byte) error {
+	if msg.ws == nil {
+		return ErrMsgInvalidArguments
+	}
+	if msg.done {
+		return ErrMsgClosed
+	}
+	if len(payload) > webSocketControlMaxLength {
+		return ErrMsgTooLong
+	}
+	ws := msg.ws
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.out != nil {
+		if ws.outData {
+			return ErrTemporarilyUnavailable
+		}
+		// a retry of the pending control frame
+		return msg.flushWebSocket()
+	}
+	if ws.sentClose {
+		return ErrMsgClosed
+	}
+	ws.out = msg.encodeWebSocket(opcode, payload)
+	ws.outOffset, ws.outData = 0, false
+	if opcode == webSocketOpClose {
+		ws.sentClose = true
+	}
+
+	return msg.flushWebSocket()
+}
+
+func (msg *message) writeWebSocketClose(code int, reason string) error {
+	if code == 0 || code == WebSocketCloseNoStatus {
+		if reason != "" {
+			return ErrMsgInvalidArguments
+		}
+		return msg.writeWebSocketControl(webSocketOpClose, nil)
+	}
+	if !webSocketValidCloseCode(code) || len(reason) > webSocketCloseReasonMaxLength {
+		return ErrMsgInvalidArguments
+	}
+	payload := [webSocketControlMaxLength]byte{}
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+	n := 2 + copy(payload[2:], reason)
+
+	return msg.writeWebSocketControl(webSocketOpClose, payload[:n])
+}
+
+// encodeWebSocket encodes the message p into frames of at most maxFrameSize
+// bytes payload, which are masked on the client side
+func (msg *message) encodeWebSocket(opcode byte, p []byte) *BufferLease {
+	ws := msg.ws
+	frameSize := len(p)
+	if ws.maxFrameSize > 0 && opcode&webSocketOpClose == 0 && frameSize > ws.maxFrameSize {
+		frameSize = ws.maxFrameSize
+	}
+	frames := 1
+	if frameSize > 0 {
+		frames = (len(p) + frameSize - 1) / frameSize
+	}
+	lease := msg.pool.Get(frames*webSocketHeaderMaxLength + len(p))
+	b := lease.Bytes()[:0]
+	for i := 0; i < frames; i++ {
+		payload := p[i*frameSize : min((i+1)*frameSize, len(p))]
+		b0 := opcode
+		if i > 0 {
+			b0 = webSocketOpContinuation
+		}
+		if i == frames-1 {
+			b0 |= webSocketFlagFin
+		}
+		b1 := byte(0)
+		if ws.client {
+			b1 = webSocketFlagMask
+		}
+		switch {
+		case len(payload) <= webSocketPayloadMaxLength7:
+			b = append(b, b0, b1|byte(len(payload)))
+		case len(payload) <= messagePayloadMaxLength16Bits:
+			b = append(b, b0, b1|webSocketPayloadLength16)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+		default:
+			b = append(b, b0, b1|webSocketPayloadLength64)
+			b = binary.BigEndian.AppendUint64(b, uint64(len(payload)))
+		}
+		if !ws.client {
+			b = append(b, payload...)
+			continue
+		}
+		key := [4]byte{}
+		binary.LittleEndian.PutUint32(key[:], rand.Uint32())
+		b = append(b, key[:]...)
+		b = append(b, payload...)
+		webSocketMask(key, b[len(b)-len(payload):])
+	}
+	lease.Truncate(len(b))
+
+	return lease
+}
+
+// flushWebSocket writes the pending frames. The frames stay pending
+// if the write returns ErrTemporarilyUnavailable
+func (msg *message) flushWebSocket() error {
+	ws := msg.ws
+	for ws.outOffset < ws.out.Len() {
+		wn, err := msg.writeOnce(ws.out.Bytes()[ws.outOffset:])
+		ws.outOffset += wn
+		if err == ErrTemporarilyUnavailable {
+			return err
+		}
+		if err != nil {
+			ws.out.Release()
+			ws.out = nil
+			return err
+		}
+	}
+	ws.out.Release()
+	ws.out = nil
+
+	return nil
+}
@@ -0,0 +1,279 @@
+// ©Hayabusa Cloud Co., Ltd. 2024. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sox_test
+
+import (
+	"bytes"
+	"errors"
+	"hybscloud.com/sox"
+	"io"
+	"testing"
+)
+
+func TestWebSocket_ReadWrite(t *testing.T) {
+	t.Run("server to client", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf, sox.MessageOptionsWebSocket)
+		r := sox.NewMessageReader(&buf, sox.MessageOptionsWebSocketClient)
+		for _, size := range []int{0, 5, 125, 126, 1 << 16, 1<<16 + 1} {
+			s := bytes.Repeat([]byte{0x41}, size)
+			n, err := w.Write(s)
+			if err != nil || n != size {
+				t.Errorf("write %d byte(s): %v", n, err)
+				return
+			}
+			lease, err := r.(sox.MessageLeaseReader).ReadLease()
+			if err != nil {
+				t.Errorf("read lease: %v", err)
+				return
+			}
+			if !bytes.Equal(lease.Bytes(), s) {
+				t.Errorf("expected %d bytes but got %d bytes", size, lease.Len())
+				return
+			}
+			lease.Release()
+		}
+	})
+
+	t.Run("client frames are masked", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf, sox.MessageOptionsWebSocketClient)
+		s := []byte("hello")
+		_, err := w.Write(s)
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		b := buf.Bytes()
+		if len(b) != 2+4+len(s) || b[0] != 0x82 || b[1] != 0x80|byte(len(s)) {
+			t.Errorf("bad frame %x", b)
+			return
+		}
+		r := sox.NewMessageReader(&buf, sox.MessageOptionsWebSocket)
+		p := make([]byte, 16)
+		n, err := r.Read(p)
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		if !bytes.Equal(p[:n], s) {
+			t.Errorf("expected %s but got %s", s, p[:n])
+			return
+		}
+	})
+
+	t.Run("fragmentation", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf, sox.MessageOptionsWebSocketClient, func(options *sox.MessageOptions) {
+			options.WebSocket.MaxFrameSize = 4
+			options.WebSocket.Text = true
+		})
+		s := []byte("fragmented message")
+		_, err := w.Write(s)
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		b := buf.Bytes()
+		if b[0] != 0x01 || b[2+4+4] != 0x00 {
+			t.Errorf("bad fragments %x", b)
+			return
+		}
+		pings := 0
+		r := sox.NewMessageReader(&buf, sox.MessageOptionsWebSocket, func(options *sox.MessageOptions) {
+			options.WebSocket.OnPing = func(payload []byte) {
+				pings++
+			}
+		})
+		// a control frame in the middle of a fragmented message
+		ping := []byte{0x89, 0x80, 0, 0, 0, 0}
+		b = append(append(append([]byte{}, b[:10]...), ping...), b[10:]...)
+		buf.Reset()
+		buf.Write(b)
+		p := make([]byte, 4)
+		_, err = r.Read(p)
+		if err != io.ErrShortBuffer {
+			t.Errorf("expected short buffer but got %v", err)
+			return
+		}
+		p = make([]byte, 64)
+		n, err := r.Read(p)
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		if !bytes.Equal(p[:n], s) || pings != 1 {
+			t.Errorf("expected %s and 1 ping but got %s and %d ping(s)", s, p[:n], pings)
+			return
+		}
+	})
+}
+
+func TestWebSocket_Control(t *testing.T) {
+	t.Run("ping pong", func(t *testing.T) {
+		in, out := bytes.Buffer{}, bytes.Buffer{}
+		client := sox.NewMessageWriter(&in, sox.MessageOptionsWebSocketClient).(sox.WebSocketWriter)
+		server := sox.NewMessageReadWriter(&in, &out, sox.MessageOptionsWebSocket)
+		err := client.WritePing([]byte("ping"))
+		if err != nil {
+			t.Errorf("write ping: %v", err)
+			return
+		}
+		_, err = client.Write([]byte("data"))
+		if err != nil {
+			t.Errorf("write: %v", err)
+			return
+		}
+		p := make([]byte, 16)
+		n, err := server.Read(p)
+		if err != nil || string(p[:n]) != "data" {
+			t.Errorf("read %q: %v", p[:n], err)
+			return
+		}
+		var pong []byte
+		r := sox.NewMessageReader(&out, sox.MessageOptionsWebSocketClient, func(options *sox.MessageOptions) {
+			options.WebSocket.OnPong = func(payload []byte) {
+				pong = append(pong, payload...)
+			}
+		})
+		_, err = r.Read(p)
+		if err != io.EOF || string(pong) != "ping" {
+			t.Errorf("expected pong %q but got %q: %v", "ping", pong, err)
+			return
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		in, out := bytes.Buffer{}, bytes.Buffer{}
+		client := sox.NewMessageWriter(&in, sox.MessageOptionsWebSocketClient).(sox.WebSocketWriter)
+		code, reason := 0, ""
+		server := sox.NewMessageReadWriter(&in, &out, sox.MessageOptionsWebSocket, func(options *sox.MessageOptions) {
+			options.WebSocket.OnClose = func(c int, r string) {
+				code, reason = c, r
+			}
+		})
+		err := client.WriteClose(sox.WebSocketCloseGoingAway, "bye")
+		if err != nil {
+			t.Errorf("write close: %v", err)
+			return
+		}
+		_, err = client.Write([]byte("data"))
+		if err != sox.ErrMsgClosed {
+			t.Errorf("expected message closed but got %v", err)
+			return
+		}
+		_, err = server.Read(make([]byte, 16))
+		if err != io.EOF {
+			t.Errorf("expected EOF but got %v", err)
+			return
+		}
+		if code != sox.WebSocketCloseGoingAway || reason != "bye" {
+			t.Errorf("bad close %d %q", code, reason)
+			return
+		}
+		if !bytes.Equal(out.Bytes(), []byte{0x88, 0x02, 0x03, 0xe9}) {
+			t.Errorf("bad close echo %x", out.Bytes())
+			return
+		}
+		_, err = server.Write([]byte("data"))
+		if err != sox.ErrMsgClosed {
+			t.Errorf("expected message closed but got %v", err)
+			return
+		}
+	})
+}
+
+func TestWebSocket_ProtocolError(t *testing.T) {
+	cases := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"unmasked client frame", []byte{0x82, 0x01, 0x41}, sox.ErrMsgProtocol},
+		{"reserved bits", []byte{0xc2, 0x80, 0, 0, 0, 0}, sox.ErrMsgProtocol},
+		{"reserved opcode", []byte{0x83, 0x80, 0, 0, 0, 0}, sox.ErrMsgProtocol},
+		{"orphan continuation", []byte{0x80, 0x80, 0, 0, 0, 0}, sox.ErrMsgProtocol},
+		{"fragmented control", []byte{0x09, 0x80, 0, 0, 0, 0}, sox.ErrMsgProtocol},
+		{"long control", []byte{0x89, 0xfe, 0, 126, 0, 0, 0, 0}, sox.ErrMsgProtocol},
+		{"invalid text", []byte{0x81, 0x81, 0, 0, 0, 0, 0xff}, sox.ErrMsgProtocol},
+		{"invalid close code", []byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xed}, sox.ErrMsgProtocol},
+		{"length msb set", []byte{0x82, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, sox.ErrMsgProtocol},
+		{"truncated", []byte{0x82, 0x85, 0, 0, 0, 0, 0x41}, io.ErrUnexpectedEOF},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := sox.NewMessageReader(bytes.NewReader(c.frame), sox.MessageOptionsWebSocket, func(options *sox.MessageOptions) {
+				options.ReadLimit = 1024
+			})
+			_, err := r.Read(make([]byte, 16))
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected %v but got %v", c.err, err)
+				return
+			}
+		})
+	}
+}
+
+func TestWebSocket_ReadLimit(t *testing.T) {
+	// the frames of the length 2^44 from a client
+	forged := func(b0 byte) []byte {
+		return []byte{b0, 0xff, 0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	}
+
+	t.Run("forged fragment", func(t *testing.T) {
+		r := sox.NewMessageReader(bytes.NewReader(forged(0x02)), sox.MessageOptionsWebSocket)
+		_, err := r.(sox.MessageLeaseReader).ReadLease()
+		if err != sox.ErrMsgTooLong {
+			t.Errorf("read lease expected ErrMsgTooLong but got %v", err)
+			return
+		}
+	})
+
+	t.Run("forged message", func(t *testing.T) {
+		r := sox.NewMessageReader(bytes.NewReader(forged(0x82)), sox.MessageOptionsWebSocket, func(options *sox.MessageOptions) {
+			options.WebSocket.MaxReadFrameSize = 1 << 62
+		})
+		_, err := r.(sox.MessageLeaseReader).ReadLease()
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("read lease expected ErrUnexpectedEOF but got %v", err)
+			return
+		}
+	})
+
+	t.Run("fragments", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		w := sox.NewMessageWriter(&buf, sox.MessageOptionsWebSocketClient, func(options *sox.MessageOptions) {
+			options.WebSocket.MaxFrameSize = 150 << 10
+		})
+		r := sox.NewMessageReader(&buf, sox.MessageOptionsWebSocket, func(options *sox.MessageOptions) {
+			options.ReadLimit = 256 << 10
+		})
+		s := bytes.Repeat([]byte{0x41, 0x42, 0x43}, 100<<10)
+		for _, p := range [][]byte{s[:200<<10], s, []byte("next")} {
+			_, err := w.Write(p)
+			if err != nil {
+				t.Errorf("write message: %v", err)
+				return
+			}
+		}
+		l, err := r.(sox.MessageLeaseReader).ReadLease()
+		if err != nil || !bytes.Equal(l.Bytes(), s[:200<<10]) {
+			t.Errorf("read lease expected the reassembled message: %v", err)
+			return
+		}
+		l.Release()
+		_, err = r.(sox.MessageLeaseReader).ReadLease()
+		if err != sox.ErrMsgTooLong {
+			t.Errorf("read lease expected ErrMsgTooLong but got %v", err)
+			return
+		}
+		l, err = r.(sox.MessageLeaseReader).ReadLease()
+		if err != nil || string(l.Bytes()) != "next" {
+			t.Errorf("read lease expected next: %v", err)
+			return
+		}
+		l.Release()
+	})
+}